@@ -0,0 +1,522 @@
+package tagotip
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Typed ACK variables detail
+// ---------------------------------------------------------------------------
+//
+// An "ACK|OK|[...]" detail uses the variable block grammar of a PUSH body.
+// AckDetail.Vars and AckDetail.Variables hold it with plain-text values,
+// escaped and unescaped like those of an uplink, so that a value such as
+// "a;b]" reaches the device intact.
+
+// writeDetailVariables writes vars as a variable block.
+func writeDetailVariables(b *frameBuf, vars []Variable) {
+	b.WriteByte('[')
+	for i := range vars {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		writeVariable(b, &vars[i])
+	}
+	b.WriteByte(']')
+}
+
+// checkAckDetail rejects a typed detail that cannot be sent: one whose wire
+// form, written in n bytes, exceeds MaxAckDetailSize, a malformed binary
+// command, or an error detail that would not parse back as built.
+func checkAckDetail(d *AckDetail, n int) error {
+	if d.Type == "command" && d.Cmd != nil {
+		return checkCommandDetail(d.Cmd)
+	}
+	switch {
+	case d.Type == "error" && errorDetailText(d) == "":
+		return fmt.Errorf("tagotip: error detail has no text for error code %v", d.ErrorCode)
+	case d.Type == "error" && d.Err != nil && d.Err.RetryAfter != nil && d.Err.Extra != "":
+		return fmt.Errorf("tagotip: error detail has both RetryAfter and Extra")
+	case d.Type == "variables" && (d.Vars != nil || d.Text == "") && n > MaxAckDetailSize:
+		return fmt.Errorf("tagotip: variables detail is %d bytes, exceeds %d", n, MaxAckDetailSize)
+	case d.Type == "raw" && d.Pong != nil && n > MaxAckDetailSize:
+		return fmt.Errorf("tagotip: PONG detail is %d bytes, exceeds %d", n, MaxAckDetailSize)
+	}
+	return nil
+}
+
+// parseDetailVariables parses an OK detail found at pos that starts with
+// '[' as a variable block. It returns nil without error for a detail over
+// MaxAckDetailSize, which is left as text.
+func (p *parser) parseDetailVariables(s string, pos int) ([]Variable, error) {
+	if len(s) > MaxAckDetailSize {
+		return nil, nil
+	}
+	return p.parseVariableBlock(s, pos)
+}
+
+// parseVariableBlock parses s as a whole bracketed variable block starting
+// at position pos.
+func (p *parser) parseVariableBlock(s string, pos int) ([]Variable, error) {
+	if len(s) == 0 || s[0] != '[' {
+		return nil, failf(ErrInvalidVarBlock, pos, "expected '['")
+	}
+	end := findClosingBracket(s, 1)
+	if end == -1 {
+		return nil, failf(ErrInvalidVarBlock, pos, "expected ']' to close the variable block")
+	}
+	if end != len(s)-1 {
+		return nil, failf(ErrInvalidVarBlock, pos+end+1, "expected the variable block to end the detail")
+	}
+	vars, err := p.parseVariableList(nil, s[1:end], pos+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(vars) == 0 {
+		return nil, failf(ErrInvalidVarBlock, pos, "variable block must not be empty")
+	}
+	return vars, nil
+}
+
+// Variables returns the variables of an "ACK|OK|[...]" detail, such as the
+// answer to a PULL, or nil when the frame has no variables detail. A detail
+// that ParseAck kept only as text, because it is not a well-formed variable
+// block, is reported as a *ParseError positioned within the parsed frame,
+// or within the detail for a frame that was not parsed. Blocks over
+// MaxAckDetailSize, which ParseAck does not type, are parsed.
+func (f *AckFrame) Variables() ([]Variable, error) {
+	d := f.Detail
+	if d == nil || d.Type != "variables" {
+		return nil, nil
+	}
+	switch {
+	case d.Vars != nil:
+		return d.Vars.Variables, nil
+	case d.Variables != nil:
+		return d.Variables, nil
+	}
+	p := newParser(nil)
+	return p.parseVariableBlock(d.Text, f.detailPos)
+}
+
+// ---------------------------------------------------------------------------
+// Typed PONG detail
+// ---------------------------------------------------------------------------
+
+// Keys of the fields defined by PongDetail.
+const (
+	PongKeyRegion  = "region"
+	PongKeyQueue   = "queue"
+	PongKeyNextSeq = "next_seq"
+)
+
+// PongDetail carries server hints in the detail of an "ACK|PONG" frame, as a
+// list of key=value pairs in the metadata grammar:
+//
+//	ACK|!3|PONG|region=us-east,queue=12,next_seq=43
+//
+// Devices that treat the PONG detail as opaque text are unaffected. Pairs
+// with keys not defined here are kept in Extra, so hints added by newer
+// servers survive a parse and rebuild. Values are plain text, escaped by
+// BuildAck and unescaped by ParseAck.
+type PongDetail struct {
+	Region     string  // server region; empty if absent
+	QueueDepth *uint32 // messages queued for the device
+	NextSeq    *uint32 // next sequence counter the server expects
+	Extra      []MetaPair
+}
+
+// NewAckPongWith returns a PONG ACK for seq carrying the hints in opts. The
+// frame has no detail when opts is empty. Extra keys must be valid metadata
+// keys and must not repeat a defined key.
+func NewAckPongWith(seq *uint32, opts PongDetail) (*AckFrame, error) {
+	frame := &AckFrame{Seq: seq, Status: AckStatusPong}
+	if opts.Region == "" && opts.QueueDepth == nil && opts.NextSeq == nil && len(opts.Extra) == 0 {
+		return frame, nil
+	}
+	for _, p := range opts.Extra {
+		if validateMetaKey(p.Key, 0) != nil {
+			return nil, fmt.Errorf("tagotip: invalid PONG detail key %q", p.Key)
+		}
+		if isPongKey(p.Key) {
+			return nil, fmt.Errorf("tagotip: PONG detail key %q must be set through its field", p.Key)
+		}
+	}
+	pong := opts
+	pong.Extra = append([]MetaPair(nil), opts.Extra...)
+	var b frameBuf
+	writePongDetail(&b, &pong)
+	frame.Detail = &AckDetail{Type: "raw", Text: b.String(), Pong: &pong}
+	return frame, nil
+}
+
+func isPongKey(key string) bool {
+	return key == PongKeyRegion || key == PongKeyQueue || key == PongKeyNextSeq
+}
+
+// writePongDetail writes d as a comma-separated key=value list, defined
+// fields first.
+func writePongDetail(b *frameBuf, d *PongDetail) {
+	sep := false
+	key := func(k string) {
+		if sep {
+			b.WriteByte(',')
+		}
+		sep = true
+		b.WriteString(k)
+		b.WriteByte('=')
+	}
+	if d.Region != "" {
+		key(PongKeyRegion)
+		writeEscaped(b, d.Region)
+	}
+	if d.QueueDepth != nil {
+		key(PongKeyQueue)
+		writeUint(b, uint64(*d.QueueDepth))
+	}
+	if d.NextSeq != nil {
+		key(PongKeyNextSeq)
+		writeUint(b, uint64(*d.NextSeq))
+	}
+	for _, p := range d.Extra {
+		key(p.Key)
+		writeEscaped(b, p.Value)
+	}
+}
+
+// parsePongDetail parses a PONG detail written by writePongDetail. It
+// returns nil when s is not a well-formed pair list within
+// MaxAckDetailSize, when a defined field repeats, or when a numeric field
+// does not hold a uint32; the detail is then only available as text.
+func parsePongDetail(s string) *PongDetail {
+	if s == "" || len(s) > MaxAckDetailSize {
+		return nil
+	}
+	p := newParser(nil)
+	pairs, err := p.parseMetadata(nil, s, 0)
+	if err != nil {
+		return nil
+	}
+	d := &PongDetail{}
+	var seen [3]bool
+	for _, pair := range pairs {
+		value := pair.Value
+		var field int
+		switch pair.Key {
+		case PongKeyRegion:
+			if value == "" {
+				return nil
+			}
+			d.Region = value
+		case PongKeyQueue:
+			n, ok := parseU32(value)
+			if !ok {
+				return nil
+			}
+			d.QueueDepth, field = &n, 1
+		case PongKeyNextSeq:
+			n, ok := parseU32(value)
+			if !ok {
+				return nil
+			}
+			d.NextSeq, field = &n, 2
+		default:
+			d.Extra = append(d.Extra, MetaPair{Key: pair.Key, Value: value})
+			continue
+		}
+		if seen[field] {
+			return nil
+		}
+		seen[field] = true
+	}
+	return d
+}
+
+// ---------------------------------------------------------------------------
+// Binary CMD detail
+// ---------------------------------------------------------------------------
+
+// CommandDetail is the typed form of a CMD detail that carries a binary
+// payload after the command name, encoded as in an uplink passthrough body:
+//
+//	ACK|!7|CMD|fw_chunk >bAAECAw==
+//	ACK|CMD|set_key >x0a1b2c
+//
+// Only a payload right after the first space of the detail is recognized;
+// plain-text commands have no typed form. Devices that do not expect a
+// payload see the detail text unchanged.
+type CommandDetail struct {
+	Name     string
+	Encoding PassthroughEncoding
+	Binary   []byte // at most MaxCommandBinary bytes
+}
+
+func writeCommandDetail(b *frameBuf, c *CommandDetail) {
+	b.WriteString(c.Name)
+	if c.Encoding == PassthroughEncodingBase64 {
+		b.WriteString(" >b")
+		*b = base64.StdEncoding.AppendEncode(*b, c.Binary)
+	} else {
+		b.WriteString(" >x")
+		*b = hex.AppendEncode(*b, c.Binary)
+	}
+}
+
+func checkCommandDetail(c *CommandDetail) error {
+	if c.Name == "" || strings.IndexByte(c.Name, ' ') >= 0 || Escape(c.Name) != c.Name {
+		return fmt.Errorf("tagotip: invalid command name %q", c.Name)
+	}
+	if len(c.Binary) == 0 {
+		return fmt.Errorf("tagotip: empty command payload")
+	}
+	if len(c.Binary) > MaxCommandBinary {
+		return fmt.Errorf("tagotip: command payload is %d bytes, exceeds %d", len(c.Binary), MaxCommandBinary)
+	}
+	return nil
+}
+
+// parseCommandDetail parses a CMD detail found at pos. It returns nil for a
+// plain-text command, and an error when the payload fails the validation
+// of an uplink passthrough body or exceeds MaxCommandBinary.
+func parseCommandDetail(s string, pos int) (*CommandDetail, error) {
+	sp := strings.IndexByte(s, ' ')
+	if sp <= 0 || len(s) < sp+3 || s[sp+1] != '>' {
+		return nil, nil
+	}
+	var enc PassthroughEncoding
+	switch s[sp+2] {
+	case 'x':
+		enc = PassthroughEncodingHex
+	case 'b':
+		enc = PassthroughEncodingBase64
+	default:
+		return nil, nil
+	}
+	data, dataPos := s[sp+3:], pos+sp+3
+	if data == "" {
+		return nil, failf(ErrInvalidPassthru, dataPos, "expected a command payload")
+	}
+	if enc == PassthroughEncodingBase64 {
+		if off, msg := checkBase64(data); off >= 0 {
+			return nil, failf(ErrInvalidPassthru, dataPos+off, msg)
+		}
+	}
+	bin, err := appendDecodedPassthrough(nil, enc, data)
+	if err != nil {
+		return nil, fail(ErrInvalidPassthru, dataPos)
+	}
+	if len(bin) > MaxCommandBinary {
+		return nil, failf(ErrInvalidPassthru, dataPos, "command payload exceeds "+strconv.Itoa(MaxCommandBinary)+" bytes")
+	}
+	return &CommandDetail{Name: s[:sp], Encoding: enc, Binary: bin}, nil
+}
+
+// ---------------------------------------------------------------------------
+// Text CMD detail
+// ---------------------------------------------------------------------------
+//
+// A plain-text CMD detail is a command name followed by space-separated
+// arguments:
+//
+//	ACK|CMD|set_interval 60
+//	ACK|!3|CMD|ota https://fw.example.com/v2.bin
+//
+// An argument holding a space writes it as "\ ", and the structural
+// characters are escaped as in an uplink frame, so "a b|c" travels as
+// "a\ b\|c".
+
+// Command is a plain-text CMD detail split into its name and arguments.
+type Command struct {
+	Name string
+	Args []string
+}
+
+// ParseCommand splits the text of a CMD detail into a Command, undoing the
+// escapes of its arguments. A run of unescaped spaces separates two
+// arguments. The name must be one or more letters, digits, '-', or '_'.
+//
+// The payload of a binary command is read from the detail's Cmd instead;
+// here it is returned as the first argument, still encoded.
+func ParseCommand(detail *AckDetail) (*Command, error) {
+	if detail == nil || detail.Type != "command" {
+		return nil, fmt.Errorf("tagotip: not a command detail")
+	}
+	var tokens []string
+	var b strings.Builder
+	inToken := false
+	s := detail.Text
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ':
+			if inToken {
+				tokens = append(tokens, b.String())
+				b.Reset()
+				inToken = false
+			}
+			continue
+		case c == '\\' && i+1 < len(s) && s[i+1] == ' ':
+			c = ' '
+			i++
+		case c == '\\' && i+1 < len(s) && unescapeTable[s[i+1]] != 0:
+			c = unescapeTable[s[i+1]]
+			i++
+		}
+		b.WriteByte(c)
+		inToken = true
+	}
+	if inToken {
+		tokens = append(tokens, b.String())
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("tagotip: empty command")
+	}
+	if err := checkCommandName(tokens[0]); err != nil {
+		return nil, err
+	}
+	cmd := &Command{Name: tokens[0]}
+	if len(tokens) > 1 {
+		cmd.Args = tokens[1:]
+	}
+	return cmd, nil
+}
+
+// NewAckCmd returns a CMD ACK for seq carrying the command name with args,
+// escaped as ParseCommand expects. It fails for a name ParseCommand would
+// reject, for an empty argument, and for a first argument starting with
+// '>', which devices would read as a binary payload.
+func NewAckCmd(seq *uint32, name string, args ...string) (*AckFrame, error) {
+	if err := checkCommandName(name); err != nil {
+		return nil, err
+	}
+	b := frameBuf(make([]byte, 0, len(name)+16*len(args)))
+	b.WriteString(name)
+	for i, arg := range args {
+		if arg == "" {
+			return nil, fmt.Errorf("tagotip: empty argument %d to command %q", i+1, name)
+		}
+		if i == 0 && arg[0] == '>' {
+			return nil, fmt.Errorf("tagotip: first argument to command %q starts with '>'", name)
+		}
+		b.WriteByte(' ')
+		for j := 0; j < len(arg); j++ {
+			switch c := arg[j]; {
+			case c == ' ':
+				b.WriteString("\\ ")
+			case escapeTable[c] != 0:
+				b.WriteByte('\\')
+				b.WriteByte(escapeTable[c])
+			default:
+				b.WriteByte(c)
+			}
+		}
+	}
+	return &AckFrame{
+		Seq:    seq,
+		Status: AckStatusCmd,
+		Detail: &AckDetail{Type: "command", Text: b.String()},
+	}, nil
+}
+
+// checkCommandName rejects a command name that is empty or holds a
+// character other than a letter, digit, '-', or '_'.
+func checkCommandName(name string) error {
+	if name == "" {
+		return fmt.Errorf("tagotip: empty command name")
+	}
+	for i := 0; i < len(name); i++ {
+		if !isSerialChar(name[i]) {
+			return fmt.Errorf("tagotip: invalid command name %q", name)
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// Extended ERR detail
+// ---------------------------------------------------------------------------
+
+// ErrorDetail holds the fields that may follow the error code of an ERR
+// ACK. A rate_limited error may carry the number of seconds the device
+// should wait before sending again:
+//
+//	ACK|!4|ERR|rate_limited|30
+//
+// Fields that are not understood, on any error code, are kept verbatim in
+// Extra, without the leading separator, and written back by BuildAck.
+// Devices that read only the error code are unaffected. A retry delay
+// followed by more fields is not understood, so BuildAck rejects an
+// ErrorDetail setting both RetryAfter and Extra; put the delay in Extra
+// instead.
+type ErrorDetail struct {
+	RetryAfter *uint32 // seconds; rate_limited only
+	Extra      string
+}
+
+// NewAckErrRateLimited returns a rate_limited ERR ACK for seq that asks the
+// device to wait retryAfter seconds.
+func NewAckErrRateLimited(seq *uint32, retryAfter uint32) *AckFrame {
+	return &AckFrame{
+		Seq:    seq,
+		Status: AckStatusErr,
+		Detail: &AckDetail{
+			Type:      "error",
+			Text:      errorCodeName(ErrorCodeRateLimited),
+			ErrorCode: ErrorCodeRateLimited,
+			Err:       &ErrorDetail{RetryAfter: &retryAfter},
+		},
+	}
+}
+
+// NewAckErr returns an ERR ACK for seq carrying code. Use
+// NewAckErrRateLimited to add a retry delay to ErrorCodeRateLimited.
+func NewAckErr(code ErrorCode, seq *uint32) *AckFrame {
+	d := &AckDetail{Type: "error", ErrorCode: code}
+	if code >= 0 && code < ErrorCodeUnknown {
+		d.Text = errorCodeName(code)
+	}
+	return &AckFrame{Seq: seq, Status: AckStatusErr, Detail: d}
+}
+
+// errorDetailText returns the error code as written in an ERR detail: the
+// detail's Text, or when that is empty the canonical name of its
+// ErrorCode. It is empty for ErrorCodeUnknown without Text.
+func errorDetailText(d *AckDetail) string {
+	if d.Text != "" || d.ErrorCode < 0 || d.ErrorCode >= ErrorCodeUnknown {
+		return d.Text
+	}
+	return errorCodeName(d.ErrorCode)
+}
+
+func writeErrorDetail(b *frameBuf, e *ErrorDetail) {
+	if e.RetryAfter != nil {
+		b.WriteByte('|')
+		writeUint(b, uint64(*e.RetryAfter))
+	}
+	if e.Extra != "" {
+		b.WriteByte('|')
+		b.WriteString(e.Extra)
+	}
+}
+
+// parseAckExtra parses the fields after the detail of an ACK, given as the
+// raw text that follows the detail's separator, at pos. Only ERR details
+// carry such fields; on other statuses they fail with ErrInvalidAck at the
+// first of them.
+func parseAckExtra(d *AckDetail, status AckStatus, extra string, pos int) error {
+	if status != AckStatusErr {
+		return fail(ErrInvalidAck, pos)
+	}
+	if d.ErrorCode == ErrorCodeRateLimited {
+		if n, ok := parseU32(extra); ok {
+			d.Err = &ErrorDetail{RetryAfter: &n}
+			return nil
+		}
+	}
+	d.Err = &ErrorDetail{Extra: extra}
+	return nil
+}
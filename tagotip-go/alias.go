@@ -0,0 +1,156 @@
+package tagotip
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Variable name aliases
+// ---------------------------------------------------------------------------
+//
+// A structured PUSH that repeats long variable names, such as a batch of
+// timestamped readings, can be shortened without leaving the protocol by
+// declaring aliases in the body metadata and naming the variables by alias:
+//
+//	[temperature:=21.5@1700000000000;temperature:=21.7@1700000060000]
+//	{_0=temperature}[0:=21.5@1700000000000;0:=21.7@1700000060000]
+//
+// Body metadata keys that start with AliasMetaPrefix are reserved for
+// aliases: the rest of the key is the alias, a valid variable name, and the
+// value is the full variable name. ExpandAliases reverses the rewrite on
+// the server before storage.
+
+// AliasMetaPrefix starts the body metadata keys that declare aliases.
+const AliasMetaPrefix = "_"
+
+// CompressAliases returns a copy of frame in which repeated variable names
+// of a structured PUSH are replaced by aliases, where doing so shortens the
+// frame. Other frames are returned as copies. It fails if the body already
+// uses AliasMetaPrefix metadata keys.
+func CompressAliases(frame *UplinkFrame) (*UplinkFrame, error) {
+	c := cloneUplink(frame)
+	sb := structuredBody(c)
+	if sb == nil {
+		return c, nil
+	}
+	for _, p := range sb.Meta {
+		if strings.HasPrefix(p.Key, AliasMetaPrefix) {
+			return nil, fmt.Errorf("tagotip: body metadata key %q is reserved for aliases", p.Key)
+		}
+	}
+
+	counts := make(map[string]int)
+	items := len(sb.Variables) + len(sb.Meta)
+	for i := range sb.Variables {
+		counts[sb.Variables[i].Name]++
+		items += len(sb.Variables[i].Meta)
+	}
+	candidates := make([]string, 0, len(counts))
+	for name, n := range counts {
+		if n > 1 {
+			candidates = append(candidates, name)
+		}
+	}
+	// Longest savings first, so the shortest aliases go to them.
+	saving := func(name string) int { return counts[name] * len(name) }
+	sort.Slice(candidates, func(i, j int) bool {
+		si, sj := saving(candidates[i]), saving(candidates[j])
+		if si != sj {
+			return si > sj
+		}
+		return candidates[i] < candidates[j]
+	})
+
+	aliases := make(map[string]string)
+	next := uint64(0)
+	for _, name := range candidates {
+		if len(sb.Meta) >= MaxMetaPairs || items >= DefaultMaxTotalItems {
+			break
+		}
+		alias := strconv.FormatUint(next, 36)
+		for counts[alias] > 0 {
+			next++
+			alias = strconv.FormatUint(next, 36)
+		}
+		// The declaration "_alias=name" and its separator must cost less
+		// than the bytes saved.
+		cost := len(AliasMetaPrefix) + len(alias) + 1 + len(name) + 1
+		if counts[name]*(len(name)-len(alias)) <= cost {
+			continue
+		}
+		next++
+		items++
+		aliases[name] = alias
+		sb.Meta = append(sb.Meta, MetaPair{Key: AliasMetaPrefix + alias, Value: name})
+	}
+	for i := range sb.Variables {
+		if alias, ok := aliases[sb.Variables[i].Name]; ok {
+			sb.Variables[i].Name = alias
+		}
+	}
+	return c, nil
+}
+
+// ExpandAliases returns a copy of frame with the aliases declared in its
+// body metadata replaced by the full variable names, and the declarations
+// removed. It fails if a declaration is not a valid alias or variable name,
+// if an alias is declared twice, or if the expanded frame exceeds
+// MaxFrameSize.
+func ExpandAliases(frame *UplinkFrame) (*UplinkFrame, error) {
+	c := cloneUplink(frame)
+	sb := structuredBody(c)
+	if sb == nil {
+		return c, nil
+	}
+	var aliases map[string]string
+	meta := sb.Meta[:0]
+	for _, p := range sb.Meta {
+		alias, ok := strings.CutPrefix(p.Key, AliasMetaPrefix)
+		if !ok {
+			meta = append(meta, p)
+			continue
+		}
+		if validateVarname(alias, 0) != nil {
+			return nil, fmt.Errorf("tagotip: alias %q is not a valid variable name", alias)
+		}
+		if validateVarname(p.Value, 0) != nil {
+			return nil, fmt.Errorf("tagotip: alias %q stands for invalid variable name %q", alias, p.Value)
+		}
+		if _, dup := aliases[alias]; dup {
+			return nil, fmt.Errorf("tagotip: alias %q declared twice", alias)
+		}
+		if aliases == nil {
+			aliases = make(map[string]string)
+		}
+		aliases[alias] = p.Value
+	}
+	if aliases == nil {
+		return c, nil
+	}
+	sb.Meta = meta
+	if len(sb.Meta) == 0 {
+		sb.Meta = nil
+	}
+	for i := range sb.Variables {
+		if name, ok := aliases[sb.Variables[i].Name]; ok {
+			sb.Variables[i].Name = name
+		}
+	}
+	var tooLarge *FrameSizeError
+	if _, err := BuildUplink(c); errors.As(err, &tooLarge) {
+		return nil, fmt.Errorf("tagotip: expanded frame is %d bytes, exceeds %d", tooLarge.Size, tooLarge.Limit)
+	}
+	return c, nil
+}
+
+// structuredBody returns the structured PUSH body of f, or nil.
+func structuredBody(f *UplinkFrame) *StructuredBody {
+	if f.Method != MethodPush || f.PushBody == nil || f.PushBody.IsPassthrough {
+		return nil
+	}
+	return f.PushBody.Structured
+}
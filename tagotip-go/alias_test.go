@@ -0,0 +1,154 @@
+package tagotip
+
+import (
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// =========================================================================
+// Variable name aliases
+// =========================================================================
+
+func TestCompressAliases(t *testing.T) {
+	frame := mustParse(t, "PUSH|"+testAuth+"|logger|{site=a}[temperature:=21.5@1;temperature:=21.7@2;temperature:=21.9@3;hum:=40;hum:=41]")
+	c, err := CompressAliases(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := BuildUplink(c)
+	want := "PUSH|" + testAuth + "|logger|{site=a,_0=temperature}[0:=21.5@1;0:=21.7@2;0:=21.9@3;hum:=40;hum:=41]"
+	if raw != want {
+		t.Fatalf("got  %s\nwant %s", raw, want)
+	}
+	if orig, _ := BuildUplink(frame); strings.Contains(orig, "_0") {
+		t.Error("input frame was modified")
+	}
+
+	e, err := ExpandAliases(mustParse(t, raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Equal(frame) {
+		expanded, _ := BuildUplink(e)
+		t.Errorf("expanded as %s", expanded)
+	}
+}
+
+func TestCompressAliasesAvoidsNamesInUse(t *testing.T) {
+	frame := mustParse(t, "PUSH|"+testAuth+"|logger|[0:=1;1:=2;temperature:=3;temperature:=4;temperature:=5]")
+	c, err := CompressAliases(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := BuildUplink(c)
+	if want := "PUSH|" + testAuth + "|logger|{_2=temperature}[0:=1;1:=2;2:=3;2:=4;2:=5]"; raw != want {
+		t.Fatalf("got  %s\nwant %s", raw, want)
+	}
+	if e, err := ExpandAliases(c); err != nil || !e.Equal(frame) {
+		t.Errorf("expand: %v", err)
+	}
+}
+
+func TestCompressAliasesOnlyWhenShorter(t *testing.T) {
+	for _, input := range []string{
+		"PUSH|" + testAuth + "|dev|[temperature:=1]",
+		"PUSH|" + testAuth + "|dev|[t:=1;t:=2;t:=3]",
+		"PUSH|" + testAuth + "|dev|[abc:=1;abc:=2]",
+		"PUSH|" + testAuth + "|dev|>xABCD",
+		"PULL|" + testAuth + "|dev|[temperature;temperature]",
+		"PING|" + testAuth + "|dev",
+	} {
+		frame := mustParse(t, input)
+		c, err := CompressAliases(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if raw, _ := BuildUplink(c); raw != input {
+			t.Errorf("%q: rewritten as %q", input, raw)
+		}
+	}
+}
+
+func TestCompressAliasesReservedKeys(t *testing.T) {
+	frame := mustParse(t, "PUSH|"+testAuth+"|dev|{_x=1}[temperature:=1;temperature:=2;temperature:=3]")
+	if _, err := CompressAliases(frame); err == nil {
+		t.Error("expected error for reserved metadata key")
+	}
+}
+
+func TestExpandAliasesRejects(t *testing.T) {
+	for _, meta := range []string{
+		"{_=temperature}",
+		"{_a=Temperature}",
+		"{_a=temp,_a=hum}",
+		"{_a=" + strings.Repeat("t", MaxVarNameLen+1) + "}",
+	} {
+		frame := mustParse(t, "PUSH|"+testAuth+"|dev|"+meta+"[a:=1]")
+		if _, err := ExpandAliases(frame); err == nil {
+			t.Errorf("%s: expected error", meta)
+		}
+	}
+}
+
+func TestExpandAliasesFrameSizeLimit(t *testing.T) {
+	long := strings.Repeat("n", MaxVarNameLen)
+	var b strings.Builder
+	for i := 0; i < MaxVariables; i++ {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString("a=" + strings.Repeat("v", 70))
+	}
+	frame := mustParse(t, "PUSH|"+testAuth+"|dev|{_a="+long+"}["+b.String()+"]")
+	if _, err := ExpandAliases(frame); err == nil {
+		t.Error("expected error for an expansion over MaxFrameSize")
+	}
+}
+
+func TestAliasesLosslessProperty(t *testing.T) {
+	check := func(f *UplinkFrame) bool {
+		// Repeat a long name so that there is something to compress.
+		if sb := structuredBody(f); sb != nil {
+			for i := range sb.Variables {
+				if i%2 == 0 {
+					sb.Variables[i].Name = "temperature_probe"
+				}
+			}
+			for _, p := range sb.Meta {
+				if strings.HasPrefix(p.Key, AliasMetaPrefix) {
+					return true
+				}
+			}
+		}
+		c, err := CompressAliases(f)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		if err := c.Validate(); err != nil {
+			t.Log(err)
+			return false
+		}
+		before, _ := BuildUplink(f)
+		after, _ := BuildUplink(c)
+		if len(after) > len(before) {
+			t.Logf("compressed frame is longer:\n%s\n%s", before, after)
+			return false
+		}
+		e, err := ExpandAliases(c)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		if !e.Normalize().Equal(f.Normalize()) {
+			expanded, _ := BuildUplink(e)
+			t.Logf("not lossless:\n%s\n%s", before, expanded)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(check, quickConfig); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,406 @@
+package tagotip
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Anonymization
+// ---------------------------------------------------------------------------
+//
+// Anonymize and AnonymizeRaw rewrite a frame so that it can be attached to a
+// problem report. Both keep every structural character in place and every
+// field at its original length, so parse error positions carry over. The
+// authorization token is replaced with a placeholder, names with
+// deterministic pseudonyms (a name that repeats in the frame repeats in the
+// output), and values with filler of the same shape. Sequence counters,
+// method and status keywords, booleans, and ACK error codes are kept.
+
+// Anonymize rewrites an uplink or ACK frame for sharing, and verifies the
+// result by parsing both frames. A frame that parses is rewritten field by
+// field and must parse back to the same structure: method, sequence
+// counter, operators, variable count, and the length of every name, value,
+// and unit. A frame that fails to parse is rewritten with AnonymizeRaw and
+// must fail with the same error kind at the same position. An error is
+// returned when the verification fails.
+func Anonymize(frame string) (string, error) {
+	ack := isAckFrame(frame)
+	parseErr := parseForAnonymize(frame, ack)
+	if parseErr != nil {
+		out := AnonymizeRaw(frame)
+		if err := parseForAnonymize(out, ack); !sameParseError(parseErr, err) {
+			return "", fmt.Errorf("tagotip: anonymized frame fails with %v instead of %v", err, parseErr)
+		}
+		return out, nil
+	}
+
+	var out, want, got string
+	if ack {
+		f, _ := ParseAck(frame)
+		want = ackShape(f)
+		out = anonymizeAck(f)
+	} else {
+		f, _ := ParseUplink(frame)
+		want = uplinkShape(f)
+		out = newAnonymizer().uplink(f)
+	}
+	if strings.HasSuffix(frame, "\n") {
+		out += "\n"
+	}
+
+	if ack {
+		f, err := ParseAck(out)
+		if err != nil {
+			return "", fmt.Errorf("tagotip: anonymized frame does not parse: %w", err)
+		}
+		got = ackShape(f)
+	} else {
+		f, err := ParseUplink(out)
+		if err != nil {
+			return "", fmt.Errorf("tagotip: anonymized frame does not parse: %w", err)
+		}
+		got = uplinkShape(f)
+	}
+	if got != want {
+		return "", fmt.Errorf("tagotip: anonymized frame has shape %s instead of %s", got, want)
+	}
+	return out, nil
+}
+
+// AnonymizeRaw rewrites any string, typically a frame that does not parse,
+// by substituting characters within their class: lowercase and uppercase
+// letters stay lowercase and uppercase and hex letters stay hex letters,
+// digits other than zero become '1', and bytes outside ASCII become '~'.
+// Punctuation, keywords, sequence counters, passthrough prefixes, and the
+// character after a backslash are kept; a well-formed authorization token
+// is replaced with a placeholder. The output has the same length as s.
+//
+// Unlike Anonymize, AnonymizeRaw does not verify that the result fails the
+// same way as s.
+func AnonymizeRaw(s string) string {
+	b := []byte(s)
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c == '\\':
+			i += 2 // keep the escaped character
+			continue
+		case c >= 0x80:
+			b[i] = '~'
+			i++
+			continue
+		case !isWordChar(c):
+			i++
+			continue
+		}
+
+		j := i
+		for j < len(b) && isWordChar(b[j]) {
+			j++
+		}
+		word := s[i:j]
+		prev := byte(0)
+		if i > 0 {
+			prev = s[i-1]
+		}
+		switch {
+		case anonymizeKeywords[word] || prev == '!':
+			// Keywords carry structure and sequence counters carry none.
+		case validateAuth(word, 0) == nil:
+			copy(b[i:j], placeholderAuth)
+		default:
+			k := i
+			if prev == '>' {
+				k++ // passthrough encoding prefix
+			} else if strings.HasPrefix(word, "at") {
+				k += 2 // keep a malformed token recognizable
+			}
+			for ; k < j; k++ {
+				b[k] = maskChar(b[k])
+			}
+		}
+		i = j
+	}
+	return string(b)
+}
+
+// anonymizeKeywords are the words AnonymizeRaw keeps verbatim.
+var anonymizeKeywords = map[string]bool{
+	"PUSH": true, "PULL": true, "PING": true, "ACK": true,
+	"OK": true, "PONG": true, "CMD": true, "ERR": true,
+	"true": true, "false": true,
+	"invalid_token": true, "invalid_method": true, "invalid_payload": true,
+	"invalid_seq": true, "device_not_found": true, "variable_not_found": true,
+	"rate_limited": true, "auth_failed": true, "unsupported_version": true,
+	"payload_too_large": true, "server_error": true,
+}
+
+func isWordChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_'
+}
+
+// maskChar maps c to the representative of its character class.
+func maskChar(c byte) byte {
+	switch {
+	case c >= 'a' && c <= 'f':
+		return 'a'
+	case c >= 'a' && c <= 'z':
+		return 'x'
+	case c >= 'A' && c <= 'F':
+		return 'A'
+	case c >= 'A' && c <= 'Z':
+		return 'X'
+	case c >= '1' && c <= '9':
+		return '1'
+	}
+	return c
+}
+
+func isAckFrame(s string) bool {
+	method := s
+	if i := strings.IndexByte(s, '|'); i >= 0 {
+		method = s[:i]
+	}
+	return strings.TrimSuffix(method, "\n") == "ACK"
+}
+
+func parseForAnonymize(s string, ack bool) error {
+	var err error
+	if ack {
+		_, err = ParseAck(s)
+	} else {
+		_, err = ParseUplink(s)
+	}
+	return err
+}
+
+func sameParseError(a, b error) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	var pa, pb *ParseError
+	if errors.As(a, &pa) && errors.As(b, &pb) {
+		return *pa == *pb
+	}
+	return a.Error() == b.Error()
+}
+
+// anonymizer assigns pseudonyms to the names of one frame.
+type anonymizer struct {
+	names map[string]string
+	count map[string]int
+}
+
+func newAnonymizer() *anonymizer {
+	return &anonymizer{names: make(map[string]string), count: make(map[string]int)}
+}
+
+// pseudonym returns the pseudonym of name within its kind, a valid name of
+// the same length such as "var_a" or "var_b_".
+func (a *anonymizer) pseudonym(kind, name string) string {
+	key := kind + "\x00" + name
+	if p, ok := a.names[key]; ok {
+		return p
+	}
+	n := a.count[kind]
+	a.count[kind]++
+
+	var letters []byte
+	for n++; n > 0; n = (n - 1) / 26 {
+		letters = append([]byte{byte('a' + (n-1)%26)}, letters...)
+	}
+	p := kind + "_" + string(letters)
+	if len(p) > len(name) {
+		p = string(letters)
+	}
+	if len(p) > len(name) {
+		p = p[len(p)-len(name):]
+	}
+	p += strings.Repeat("_", len(name)-len(p))
+	a.names[key] = p
+	return p
+}
+
+func fill(c byte, n int) string {
+	return strings.Repeat(string(c), n)
+}
+
+// maskDigits replaces each nonzero digit of s with '1', keeping its sign,
+// decimal point, and any leading zero valid.
+func maskDigits(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c >= '1' && c <= '9' {
+			b[i] = '1'
+		}
+	}
+	return string(b)
+}
+
+func maskDigitsPtr(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	m := maskDigits(*s)
+	return &m
+}
+
+func (a *anonymizer) namePtr(kind string, s *string) *string {
+	if s == nil {
+		return nil
+	}
+	p := a.pseudonym(kind, *s)
+	return &p
+}
+
+func (a *anonymizer) meta(pairs []MetaPair) []MetaPair {
+	if len(pairs) == 0 {
+		return nil
+	}
+	out := make([]MetaPair, len(pairs))
+	for i, m := range pairs {
+		out[i] = MetaPair{Key: a.pseudonym("key", m.Key), Value: fill('x', escapedLen(m.Value))}
+	}
+	return out
+}
+
+func (a *anonymizer) uplink(f *UplinkFrame) string {
+	out := &UplinkFrame{
+		Method: f.Method,
+		Seq:    f.Seq,
+		Auth:   placeholderAuth,
+		Serial: a.pseudonym("dev", f.Serial),
+	}
+	if pb := f.PushBody; pb != nil {
+		if pb.IsPassthrough && pb.Passthrough != nil {
+			pt := pb.Passthrough
+			data := strings.TrimRight(pt.Data, "=")
+			c := byte('0')
+			if pt.Encoding == PassthroughEncodingBase64 {
+				c = 'A'
+			}
+			out.PushBody = &PushBody{IsPassthrough: true, Passthrough: &PassthroughBody{
+				Encoding: pt.Encoding,
+				Data:     fill(c, len(data)) + pt.Data[len(data):],
+			}}
+		} else if sb := pb.Structured; sb != nil {
+			osb := &StructuredBody{
+				Timestamp: maskDigitsPtr(sb.Timestamp),
+				Group:     a.namePtr("grp", sb.Group),
+				Meta:      a.meta(sb.Meta),
+				Variables: make([]Variable, len(sb.Variables)),
+			}
+			for i := range sb.Variables {
+				osb.Variables[i] = a.variable(&sb.Variables[i])
+			}
+			out.PushBody = &PushBody{Structured: osb}
+		}
+	}
+	if f.PullBody != nil {
+		names := make([]string, len(f.PullBody.Variables))
+		for i, name := range f.PullBody.Variables {
+			names[i] = a.pseudonym("var", name)
+		}
+		out.PullBody = &PullBody{Variables: names}
+	}
+	s, _ := BuildUplink(out)
+	return s
+}
+
+func (a *anonymizer) variable(v *Variable) Variable {
+	out := Variable{
+		Name:      a.pseudonym("var", v.Name),
+		Operator:  v.Operator,
+		Value:     Value{Type: v.Value.Type, Bool: v.Value.Bool},
+		Timestamp: maskDigitsPtr(v.Timestamp),
+		Group:     a.namePtr("grp", v.Group),
+		Meta:      a.meta(v.Meta),
+	}
+	if v.Unit != nil {
+		u := fill('u', escapedLen(*v.Unit))
+		out.Unit = &u
+	}
+	switch v.Operator {
+	case OperatorNumber:
+		out.Value.Str = maskDigits(v.Value.Str)
+	case OperatorString:
+		out.Value.Str = fill('x', escapedLen(v.Value.Str))
+	case OperatorLocation:
+		if loc := v.Value.Location; loc != nil {
+			out.Value.Location = &LocationValue{Lat: maskDigits(loc.Lat), Lng: maskDigits(loc.Lng), Alt: maskDigitsPtr(loc.Alt)}
+		}
+	}
+	return out
+}
+
+func anonymizeAck(f *AckFrame) string {
+	out := &AckFrame{Seq: f.Seq, Status: f.Status}
+	if d := f.Detail; d != nil {
+		od := *d
+		if d.Type != "count" && (d.Type != "error" || d.ErrorCode == ErrorCodeUnknown) {
+			od.Text = AnonymizeRaw(d.Text)
+		}
+		out.Detail = &od
+	}
+	s, _ := BuildAck(out)
+	return s
+}
+
+// uplinkShape describes the structure of f that Anonymize must preserve.
+func uplinkShape(f *UplinkFrame) string {
+	var b strings.Builder
+	b.WriteString(methodKeyword(f.Method))
+	if f.Seq != nil {
+		fmt.Fprintf(&b, " !%d", *f.Seq)
+	}
+	fmt.Fprintf(&b, " auth:%d serial:%d", len(f.Auth), len(f.Serial))
+	if pb := f.PushBody; pb != nil {
+		if pb.IsPassthrough && pb.Passthrough != nil {
+			fmt.Fprintf(&b, " >%d:%d", pb.Passthrough.Encoding, len(pb.Passthrough.Data))
+		} else if sb := pb.Structured; sb != nil {
+			fmt.Fprintf(&b, " @%d ^%d {%s} [%d", optLen(sb.Timestamp), optLen(sb.Group), metaShape(sb.Meta), len(sb.Variables))
+			for i := range sb.Variables {
+				v := &sb.Variables[i]
+				fmt.Fprintf(&b, " %d%s%d#%d@%d^%d{%s}", len(v.Name), operatorName(v.Operator), escapedLen(v.Value.Str),
+					escapedOptLen(v.Unit), optLen(v.Timestamp), optLen(v.Group), metaShape(v.Meta))
+				if v.Operator == OperatorBoolean {
+					fmt.Fprintf(&b, "=%t", v.Value.Bool)
+				}
+				if loc := v.Value.Location; loc != nil {
+					fmt.Fprintf(&b, "=%d,%d,%d", len(loc.Lat), len(loc.Lng), optLen(loc.Alt))
+				}
+			}
+			b.WriteByte(']')
+		}
+	}
+	if f.PullBody != nil {
+		fmt.Fprintf(&b, " [%d", len(f.PullBody.Variables))
+		for _, name := range f.PullBody.Variables {
+			fmt.Fprintf(&b, " %d", len(name))
+		}
+		b.WriteByte(']')
+	}
+	return b.String()
+}
+
+func metaShape(pairs []MetaPair) string {
+	var b strings.Builder
+	for _, m := range pairs {
+		fmt.Fprintf(&b, "%d=%d,", len(m.Key), escapedLen(m.Value))
+	}
+	return b.String()
+}
+
+// ackShape describes the structure of f that Anonymize must preserve.
+func ackShape(f *AckFrame) string {
+	s := ackStatusKeyword(f.Status)
+	if f.Seq != nil {
+		s = fmt.Sprintf("!%d %s", *f.Seq, s)
+	}
+	if d := f.Detail; d != nil {
+		s += fmt.Sprintf(" %s:%d:%d:%d", d.Type, d.Count, d.ErrorCode, len(d.Text))
+	}
+	return s
+}
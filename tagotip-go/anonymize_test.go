@@ -0,0 +1,189 @@
+package tagotip
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Anonymize
+// ============================================================================
+
+var anonymizeValid = []string{
+	"PUSH|!42|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|Boiler-Room_7|@1694567890000^plant_a{site=denver,fw=2.1}[temperature:=-15.30#C@1694567890123^zone_1{src=dht22};status=on\\;line;ok?=false;pos@=39.74,-104.99,305;temperature:=0.5]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|>xDEADBEEF01020304",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|>bSGVsbG8gd29ybGQ=",
+	"PULL|!7|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t;humidity;t]",
+	"PING|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|m\n",
+	"ACK|!3|OK|[temperature:=32#F@1694567890000]",
+	"ACK|CMD|open valve 3",
+	"ACK|ERR|custom_failure",
+	"ACK|OK|17",
+}
+
+func TestAnonymizeValid(t *testing.T) {
+	inputs := append([]string(nil), anonymizeValid...)
+	for _, ex := range Spec11Examples() {
+		inputs = append(inputs, ex.Raw)
+	}
+	for _, in := range inputs {
+		out, err := Anonymize(in)
+		if err != nil {
+			t.Errorf("Anonymize(%q): %v", in, err)
+			continue
+		}
+		if len(out) != len(in) {
+			t.Errorf("Anonymize(%q) = %q: length %d, want %d", in, out, len(out), len(in))
+		}
+		if isAckFrame(in) {
+			want, _ := ParseAck(in)
+			got, err := ParseAck(out)
+			if err != nil || ackShape(got) != ackShape(want) {
+				t.Errorf("Anonymize(%q) = %q does not keep the ACK structure", in, out)
+			}
+			continue
+		}
+		want, _ := ParseUplink(in)
+		got, err := ParseUplink(out)
+		if err != nil {
+			t.Errorf("Anonymize(%q) = %q: %v", in, out, err)
+			continue
+		}
+		if uplinkShape(got) != uplinkShape(want) {
+			t.Errorf("Anonymize(%q) = %q: shape\n  %s\nwant\n  %s", in, out, uplinkShape(got), uplinkShape(want))
+		}
+		if got.Auth != placeholderAuth {
+			t.Errorf("Anonymize(%q) kept auth %q", in, got.Auth)
+		}
+	}
+}
+
+func TestAnonymizeHidesData(t *testing.T) {
+	out, err := Anonymize(anonymizeValid[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"5f3a9c", "Boiler", "plant_a", "denver", "temperature", "15.3", "dht22", "zone_1", "line", "39.74", "104.99"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("output %q contains %q", out, secret)
+		}
+	}
+	// A repeated name keeps repeating, distinct names stay distinct.
+	f, err := ParseUplink(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := f.PushBody.Structured.Variables
+	if vars[0].Name != vars[4].Name || vars[0].Name == vars[1].Name {
+		t.Errorf("pseudonyms %q do not follow the original names", out)
+	}
+	if again, _ := Anonymize(anonymizeValid[0]); again != out {
+		t.Errorf("Anonymize is not deterministic: %q, then %q", out, again)
+	}
+}
+
+var anonymizeInvalid = []string{
+	"",
+	"POST|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter",
+	"PUSH|!x|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t:=1]",
+	"PUSH|!4294967296|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t:=1]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e1|meter|[t:=1]",
+	"PUSH|xx5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t:=1]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|met er|[t:=1]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[Temp:=1]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t:=01]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t:=1.2.3]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t?=yes]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t:=1@12a]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t:=1{Key=v}]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|>xABC",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|>xGG",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|>zABCD",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t=caf\xc3\xa9;Q=1]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|me\xc3\xa9ter|[t:=1]",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t:=1]\x00",
+	"PUSH|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|meter|[t=a\\]",
+	"ACK",
+	"ACK|!x|OK",
+	"ACK|MAYBE|x",
+}
+
+func TestAnonymizeInvalid(t *testing.T) {
+	for _, in := range anonymizeInvalid {
+		want := parseForAnonymize(in, isAckFrame(in))
+		if want == nil {
+			t.Fatalf("%q parses; the invalid inputs must fail", in)
+		}
+		out, err := Anonymize(in)
+		if err != nil {
+			t.Errorf("Anonymize(%q): %v", in, err)
+			continue
+		}
+		checkSameFailure(t, in, out, want)
+	}
+}
+
+// TestAnonymizeMutations checks the error-equivalence property over every
+// truncation and single-byte corruption of the valid inputs. Anonymize may
+// decline a frame, but must never return one that fails differently.
+func TestAnonymizeMutations(t *testing.T) {
+	var checked, declined int
+	for _, in := range anonymizeValid {
+		var mutants []string
+		for i := 0; i < len(in); i++ {
+			mutants = append(mutants, in[:i])
+			for _, c := range []byte{'|', ';', '[', '\\', 'Z', '0', '\x00'} {
+				mutants = append(mutants, in[:i]+string(c)+in[i+1:])
+			}
+		}
+		for _, m := range mutants {
+			want := parseForAnonymize(m, isAckFrame(m))
+			out, err := Anonymize(m)
+			if err != nil {
+				declined++
+				continue
+			}
+			checked++
+			if want == nil {
+				if parseForAnonymize(out, isAckFrame(out)) != nil {
+					t.Errorf("Anonymize(%q) = %q no longer parses", m, out)
+				}
+				continue
+			}
+			checkSameFailure(t, m, out, want)
+		}
+	}
+	if declined*20 > checked {
+		t.Errorf("Anonymize declined %d of %d mutants", declined, checked+declined)
+	}
+}
+
+func checkSameFailure(t *testing.T, in, out string, want error) {
+	t.Helper()
+	if len(out) != len(in) {
+		t.Errorf("Anonymize(%q) = %q: length %d, want %d", in, out, len(out), len(in))
+	}
+	got := parseForAnonymize(out, isAckFrame(in))
+	var wantPE, gotPE *ParseError
+	if !errors.As(want, &wantPE) || !errors.As(got, &gotPE) || *wantPE != *gotPE {
+		t.Errorf("Anonymize(%q) = %q fails with %v, original fails with %v", in, out, got, want)
+	}
+}
+
+func TestAnonymizeRaw(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"", ""},
+		{"PUSH|!12|at5f3a9c0e1b2d4f6a8c0e1b2d4f6a8c0e|Meter-9|[temp:=20.5#C]",
+			"PUSH|!12|" + placeholderAuth + "|Xaxax-1|[xaxx:=10.1#A]"},
+		{"PUSH|atzz|dev|>xBEEF", "PUSH|atxx|aax|>xAAAA"},
+		{"ACK|ERR|device_not_found", "ACK|ERR|device_not_found"},
+		{"x\\ny=caf\xc3\xa9?=true", "x\\nx=aaa~~?=true"},
+	}
+	for _, tt := range tests {
+		if got := AnonymizeRaw(tt.in); got != tt.want {
+			t.Errorf("AnonymizeRaw(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
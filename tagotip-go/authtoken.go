@@ -0,0 +1,72 @@
+package tagotip
+
+import "crypto/subtle"
+
+// ---------------------------------------------------------------------------
+// Authorization tokens
+// ---------------------------------------------------------------------------
+//
+// A token grants write access to a device, so it should not end up in logs
+// or be compared in a way that leaks timing. AuthToken holds a validated
+// token whose String method prints it redacted; Raw returns the full value
+// for the wire. UplinkFrame.Auth stays a plain string, and
+// UplinkFrame.AuthToken parses it.
+
+// AuthToken is a validated authorization token: "at" followed by 32 hex
+// digits. The zero value holds no token.
+type AuthToken struct {
+	raw string
+}
+
+// ParseAuthToken validates s and returns it as an AuthToken. It fails
+// with a ParseError of kind ErrInvalidAuth when s is not "at" followed by
+// 32 hex digits.
+func ParseAuthToken(s string) (AuthToken, error) {
+	if err := validateAuth(s, 0); err != nil {
+		return AuthToken{}, err
+	}
+	return AuthToken{raw: s}, nil
+}
+
+// String returns the token redacted to its first 6 and last 4 characters,
+// as in "at0123…cdef", or "" for the zero value.
+func (t AuthToken) String() string {
+	if t.raw == "" {
+		return ""
+	}
+	return t.raw[:6] + "…" + t.raw[len(t.raw)-4:]
+}
+
+// GoString returns the redacted form, so that %#v does not print the
+// token either.
+func (t AuthToken) GoString() string {
+	return "tagotip.AuthToken(" + t.String() + ")"
+}
+
+// Raw returns the full token, as written in frames.
+func (t AuthToken) Raw() string {
+	return t.raw
+}
+
+// IsZero reports whether t holds no token.
+func (t AuthToken) IsZero() bool {
+	return t.raw == ""
+}
+
+// Hash returns the Authorization Hash of the token, which identifies it in
+// TagoTiP/S envelopes. See DeriveAuthHash.
+func (t AuthToken) Hash() [authHashSize]byte {
+	return DeriveAuthHash(t.raw)
+}
+
+// EqualConstantTime reports whether t and u are the same token, in time
+// that does not depend on where they differ. Hex digits compare with their
+// case, as the Authorization Hash does.
+func (t AuthToken) EqualConstantTime(u AuthToken) bool {
+	return subtle.ConstantTimeCompare([]byte(t.raw), []byte(u.raw)) == 1
+}
+
+// AuthToken parses f.Auth as an AuthToken.
+func (f *UplinkFrame) AuthToken() (AuthToken, error) {
+	return ParseAuthToken(f.Auth)
+}
@@ -0,0 +1,68 @@
+package tagotip
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Authorization tokens
+// ============================================================================
+
+func TestParseAuthToken(t *testing.T) {
+	tok, err := ParseAuthToken(testAuth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Raw() != testAuth || tok.IsZero() {
+		t.Errorf("Raw = %q", tok.Raw())
+	}
+	if got := tok.String(); got != "at0123…cdef" {
+		t.Errorf("String = %q", got)
+	}
+	for _, s := range []string{fmt.Sprint(tok), fmt.Sprintf("%v %s %+v %#v", tok, tok, tok, tok),
+		fmt.Sprintf("%+v", struct{ Token AuthToken }{tok})} {
+		if strings.Contains(s, testAuth[6:28]) {
+			t.Errorf("token printed in full: %s", s)
+		}
+	}
+	if tok.Hash() != DeriveAuthHash(testAuth) {
+		t.Error("Hash differs from DeriveAuthHash")
+	}
+
+	for _, s := range []string{"", "at", "bt0123456789abcdef0123456789abcdef", testAuth + "0",
+		"at0123456789abcdef0123456789abcdeg"} {
+		_, err := ParseAuthToken(s)
+		assertParseError(t, err, ErrInvalidAuth)
+	}
+	var zero AuthToken
+	if zero.String() != "" || !zero.IsZero() {
+		t.Errorf("zero token: %q", zero.String())
+	}
+}
+
+func TestAuthTokenEqualConstantTime(t *testing.T) {
+	a, _ := ParseAuthToken(testAuth)
+	b, _ := ParseAuthToken(strings.Clone(testAuth))
+	c, _ := ParseAuthToken("at0123456789abcdef0123456789abcdee")
+	upper, _ := ParseAuthToken("at0123456789ABCDEF0123456789ABCDEF")
+	if !a.EqualConstantTime(b) || a.EqualConstantTime(c) || a.EqualConstantTime(upper) {
+		t.Error("EqualConstantTime")
+	}
+	if a.EqualConstantTime(AuthToken{}) || !(AuthToken{}).EqualConstantTime(AuthToken{}) {
+		t.Error("EqualConstantTime with the zero token")
+	}
+}
+
+func TestUplinkFrameAuthToken(t *testing.T) {
+	f := mustParse(t, "PING|"+testAuth+"|dev")
+	tok, err := f.AuthToken()
+	if err != nil || tok.Raw() != f.Auth {
+		t.Errorf("got %v, %v", tok, err)
+	}
+	f.Auth = "secret"
+	if _, err := f.AuthToken(); err == nil {
+		t.Error("invalid Auth accepted")
+	}
+}
@@ -0,0 +1,158 @@
+package tagotip
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// batchChunk is the number of lines a ParseBatchParallel worker claims at a
+// time. Claiming lines in chunks keeps the shared counter off the hot path
+// without letting one slow worker hold back the tail of the batch.
+const batchChunk = 256
+
+// ParseBatch parses newline-separated uplink frames. A newline escaped with
+// a backslash does not end a frame, and empty lines are skipped.
+//
+// The returned slices have one entry per frame, in input order: frames[i]
+// is the parsed frame, or nil when errs[i] reports why line i failed. The
+// frames share a single copy of data.
+func ParseBatch(data []byte) ([]*UplinkFrame, []error) {
+	lines := splitLines(string(data))
+	frames := make([]*UplinkFrame, len(lines))
+	errs := make([]error, len(lines))
+	for i, line := range lines {
+		frames[i], errs[i] = ParseUplink(line)
+	}
+	return frames, errs
+}
+
+// DefaultMaxBatchSize is the largest batch ParseUplinkBatch accepts when
+// BatchOptions.MaxBatchSize is not set.
+const DefaultMaxBatchSize = 16 << 20
+
+// BatchOptions configures ParseUplinkBatchWithOptions. The zero value
+// applies DefaultMaxBatchSize and parses each frame as ParseUplink does.
+type BatchOptions struct {
+	// MaxBatchSize bounds the length of the whole input, in bytes. Zero or
+	// negative means DefaultMaxBatchSize.
+	MaxBatchSize int
+
+	// Parser, if non-nil, is used to parse each frame.
+	Parser *ParserOptions
+}
+
+// BatchSizeError is returned by ParseUplinkBatch for input longer than its
+// batch size limit.
+type BatchSizeError struct {
+	Size  int // length of the input
+	Limit int // BatchOptions.MaxBatchSize, or DefaultMaxBatchSize
+}
+
+func (e *BatchSizeError) Error() string {
+	return fmt.Sprintf("tagotip: batch is %d bytes, exceeds %d", e.Size, e.Limit)
+}
+
+// ParseUplinkBatch is ParseBatch over a string, with the total input size
+// bounded by DefaultMaxBatchSize. See ParseUplinkBatchWithOptions.
+func ParseUplinkBatch(input string) ([]*UplinkFrame, []error) {
+	return ParseUplinkBatchWithOptions(input, nil)
+}
+
+// ParseUplinkBatchWithOptions parses newline-separated uplink frames as
+// ParseBatch does, under opts; nil opts is the zero BatchOptions. The
+// frames are substrings of input wherever ParseUplink would share it.
+//
+// Input longer than the batch size limit is not split or parsed: frames is
+// nil and errs holds a single *BatchSizeError.
+func ParseUplinkBatchWithOptions(input string, opts *BatchOptions) ([]*UplinkFrame, []error) {
+	var o BatchOptions
+	if opts != nil {
+		o = *opts
+	}
+	limit := o.MaxBatchSize
+	if limit <= 0 {
+		limit = DefaultMaxBatchSize
+	}
+	if len(input) > limit {
+		return nil, []error{&BatchSizeError{Size: len(input), Limit: limit}}
+	}
+
+	lines := splitLines(input)
+	frames := make([]*UplinkFrame, len(lines))
+	errs := make([]error, len(lines))
+	for i, line := range lines {
+		frames[i], errs[i] = ParseUplinkWithOptions(line, o.Parser)
+	}
+	return frames, errs
+}
+
+// ParseBatchParallel is ParseBatch spread across workers goroutines. The
+// results are identical to ParseBatch, including their order; workers <= 1
+// parses sequentially on the calling goroutine.
+func ParseBatchParallel(data []byte, workers int) ([]*UplinkFrame, []error) {
+	if workers <= 1 {
+		return ParseBatch(data)
+	}
+
+	lines := splitLines(string(data))
+	frames := make([]*UplinkFrame, len(lines))
+	errs := make([]error, len(lines))
+	if n := (len(lines) + batchChunk - 1) / batchChunk; workers > n {
+		workers = n
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(next.Add(batchChunk)) - batchChunk
+				if start >= len(lines) {
+					return
+				}
+				end := min(start+batchChunk, len(lines))
+				for i := start; i < end; i++ {
+					frames[i], errs[i] = ParseUplink(lines[i])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return frames, errs
+}
+
+// splitLines splits s at unescaped newlines, dropping empty lines.
+func splitLines(s string) []string {
+	lines := make([]string, 0, strings.Count(s, "\n")+1)
+	start := 0
+	i := 0
+	for {
+		j := strings.IndexByte(s[i:], '\n')
+		if j == -1 {
+			break
+		}
+		j += i
+		i = j + 1
+
+		// A newline preceded by an odd run of backslashes is escaped.
+		k := j
+		for k > start && s[k-1] == '\\' {
+			k--
+		}
+		if (j-k)%2 == 1 {
+			continue
+		}
+		if j > start {
+			lines = append(lines, s[start:j])
+		}
+		start = i
+	}
+	if start < len(s) {
+		lines = append(lines, s[start:])
+	}
+	return lines
+}
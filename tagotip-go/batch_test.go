@@ -0,0 +1,129 @@
+package tagotip
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// =========================================================================
+// splitLines
+// =========================================================================
+
+func TestSplitLines(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{}},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a"}},
+		{"a\nb", []string{"a", "b"}},
+		{"a\n\n\nb\n", []string{"a", "b"}},
+		{"a\\\nb\nc", []string{"a\\\nb", "c"}},
+		{"a\\\\\nb", []string{"a\\\\", "b"}},
+		{"a\\\\\\\nb", []string{"a\\\\\\\nb"}},
+		{"\\\n", []string{"\\\n"}},
+	}
+	for _, tc := range cases {
+		if got := splitLines(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitLines(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+// =========================================================================
+// ParseBatch
+// =========================================================================
+
+func TestParseBatchPerLineErrors(t *testing.T) {
+	data := []byte("PING|" + testAuth + "|a\nPUSH|bad\n\nPULL|" + testAuth + "|b|[x]\n")
+	frames, errs := ParseBatch(data)
+	if len(frames) != 3 || len(errs) != 3 {
+		t.Fatalf("expected 3 results, got %d/%d", len(frames), len(errs))
+	}
+	if errs[0] != nil || frames[0].Serial != "a" {
+		t.Errorf("line 0: %v", errs[0])
+	}
+	if errs[1] == nil || frames[1] != nil {
+		t.Error("line 1: expected error")
+	}
+	assertParseError(t, errs[1], ErrInvalidAuth)
+	if errs[2] != nil || frames[2].Method != MethodPull {
+		t.Errorf("line 2: %v", errs[2])
+	}
+}
+
+func TestParseUplinkBatch(t *testing.T) {
+	input := "PING|bad\n\nPUSH|" + testAuth + "|b|[x:=1;Y:=2]\nPUSH|" + testAuth + "|c|[s=a\\\nb]"
+	frames, errs := ParseUplinkBatch(input)
+	if len(frames) != 3 || len(errs) != 3 {
+		t.Fatalf("expected 3 results, got %d/%d", len(frames), len(errs))
+	}
+	if frames[0] != nil {
+		t.Errorf("line 0: got %v", frames[0])
+	}
+	assertParseError(t, errs[0], ErrInvalidAuth)
+	assertParseError(t, errs[1], ErrInvalidVariable)
+	if errs[2] != nil || frames[2].Serial != "c" {
+		t.Errorf("line 2: %v", errs[2])
+	}
+
+	// The parser options apply to every frame.
+	opts := &BatchOptions{Parser: &ParserOptions{RejectDuplicateVariables: true}}
+	_, errs = ParseUplinkBatchWithOptions("PUSH|"+testAuth+"|b|[x:=1;x:=2]\nPING|"+testAuth+"|a", opts)
+	if len(errs) != 2 || errs[0] == nil || errs[1] != nil {
+		t.Errorf("with parser options: %v", errs)
+	}
+}
+
+func TestParseUplinkBatchSizeLimit(t *testing.T) {
+	line := "PING|" + testAuth + "|a\n"
+	input := strings.Repeat(line, 4)
+	frames, errs := ParseUplinkBatchWithOptions(input, &BatchOptions{MaxBatchSize: len(input)})
+	if len(frames) != 4 || errs[3] != nil {
+		t.Errorf("at the limit: %v", errs)
+	}
+
+	frames, errs = ParseUplinkBatchWithOptions(input, &BatchOptions{MaxBatchSize: len(input) - 1})
+	var se *BatchSizeError
+	if frames != nil || len(errs) != 1 || !errors.As(errs[0], &se) || se.Size != len(input) || se.Limit != len(input)-1 {
+		t.Errorf("over the limit: %v, %v", frames, errs)
+	}
+	if _, errs := ParseUplinkBatch(strings.Repeat(" ", DefaultMaxBatchSize+1)); len(errs) != 1 || !errors.As(errs[0], &se) {
+		t.Errorf("default limit: %v", errs)
+	}
+}
+
+func batchInput(tb testing.TB, n int) []byte {
+	corpus := loadCorpus(tb)
+	corpus = append(corpus, "PUSH|bad", "PUSH|"+testAuth+"|dev|[x:=1{]")
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		b.WriteString(corpus[i%len(corpus)])
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+func TestParseBatchParallelMatchesSequential(t *testing.T) {
+	data := batchInput(t, 5000)
+	wantFrames, wantErrs := ParseBatch(data)
+	for _, workers := range []int{-1, 0, 1, 2, 3, 8, 64} {
+		frames, errs := ParseBatchParallel(data, workers)
+		if !reflect.DeepEqual(frames, wantFrames) {
+			t.Errorf("workers=%d: frames differ from ParseBatch", workers)
+		}
+		if !reflect.DeepEqual(errs, wantErrs) {
+			t.Errorf("workers=%d: errors differ from ParseBatch", workers)
+		}
+	}
+}
+
+func TestParseBatchParallelEmpty(t *testing.T) {
+	frames, errs := ParseBatchParallel(nil, 4)
+	if len(frames) != 0 || len(errs) != 0 {
+		t.Errorf("expected no results, got %d/%d", len(frames), len(errs))
+	}
+}
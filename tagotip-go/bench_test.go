@@ -0,0 +1,709 @@
+package tagotip
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadCorpus reads testdata/corpus.txt, the representative frame set shared
+// by the benchmarks and the fuzz seeds.
+func loadCorpus(tb testing.TB) []string {
+	tb.Helper()
+	f, err := os.Open("testdata/corpus.txt")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+
+	var frames []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		frames = append(frames, line)
+	}
+	if err := sc.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return frames
+}
+
+// meta32Frame returns a single-variable PUSH carrying MaxMetaPairs pairs.
+func meta32Frame() string {
+	pairs := make([]string, MaxMetaPairs)
+	for i := range pairs {
+		pairs[i] = "key_" + strconv.Itoa(i) + "=value"
+	}
+	return "PUSH|" + testAuth + "|dev|[temp:=1{" + strings.Join(pairs, ",") + "}]"
+}
+
+// pull100Frame returns a PULL requesting MaxVariables variables.
+func pull100Frame() string {
+	names := make([]string, MaxVariables)
+	for i := range names {
+		names[i] = "var_" + strconv.Itoa(i)
+	}
+	return "PULL|" + testAuth + "|dev|[" + strings.Join(names, ";") + "]"
+}
+
+func passthroughFrame(n int) string {
+	return "PUSH|" + testAuth + "|dev|>x" + strings.Repeat("a5", n)
+}
+
+// =========================================================================
+// Allocation regression guards
+// =========================================================================
+
+// TestAllocations pins the allocation count of each hot operation. A change
+// that moves one of these numbers must update the table deliberately.
+func TestAllocations(t *testing.T) {
+	small := "PUSH|" + testAuth + "|dev|[temperature:=32.5#C]"
+	frame100, err := ParseUplink(dataloggerFrame(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	locationFrame, err := ParseUplink("PUSH|!4294967295|" + testAuth + "|dev|{a=b}[pos@=39.74,-104.99,305{k=v};ok?=false;s=x#u]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reused := &UplinkFrame{}
+	reusedAck := &AckFrame{}
+	ack := &AckFrame{Seq: u32Ptr(10), Status: AckStatusOk, Detail: &AckDetail{Type: "count", Count: 5}}
+	inner := []byte("sensor-01|[temp:=32]")
+	sealBuf := make([]byte, 0, 64)
+	buildBuf := make([]byte, 0, EstimateSize(frame100))
+	envCipher, err := NewEnvelopeCipher(specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		want float64
+		fn   func()
+	}{
+		{"ParseUplink/small", 5, func() { ParseUplink(small) }},
+		{"ParseUplink/20vars", 88, func() { ParseUplink(dataloggerFrame20) }},
+		{"ParseUplink/100vars", 408, func() { ParseUplink(dataloggerFrame100) }},
+		{"ParseUplink/32meta", 5, func() { ParseUplink(meta32Input) }},
+		{"ParseUplink/pull100", 3, func() { ParseUplink(pull100Input) }},
+		{"ParseUplink/passthrough", 3, func() { ParseUplink(passthrough4K) }},
+		{"ParseUplink/location", 6, func() { ParseUplink(locationInput) }},
+		{"ParseUplinkFunc/100vars", 9, func() { ParseUplinkFunc(dataloggerFrame100, func(*Variable) error { return nil }) }},
+		{"ParseUplinkInto/20vars", 0, func() { ParseUplinkInto(reused, dataloggerFrame20) }},
+		{"BuildUplink/100vars", 1, func() { BuildUplink(frame100) }},
+		{"ParseAck", 3, func() { ParseAck("ACK|!10|OK|5") }},
+		{"ParseAckInto", 0, func() { ParseAckInto(reusedAck, "ACK|!10|OK|5") }},
+		{"BuildUplink/location", 1, func() { BuildUplink(locationFrame) }},
+		{"BuildAck", 1, func() { BuildAck(ack) }},
+		{"AppendUplink/100vars", 0, func() { AppendUplink(buildBuf[:0], frame100) }},
+		{"AppendAck", 0, func() { AppendAck(buildBuf[:0], ack) }},
+		{"SealUplink", 3, func() {
+			SealUplink(EnvelopeMethodPush, inner, 42, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm)
+		}},
+		{"AppendSealUplink", 2, func() {
+			AppendSealUplink(sealBuf[:0], EnvelopeMethodPush, inner, 42, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm)
+		}},
+		{"OpenEnvelope", 4, func() { OpenEnvelope(specEnvelope, specKey) }},
+		{"EnvelopeCipher.AppendSeal", 1, func() {
+			envCipher.AppendSeal(sealBuf[:0], EnvelopeMethodPush, inner, 42, specAuthHash, specDeviceHash)
+		}},
+		{"EnvelopeCipher.Open", 3, func() { envCipher.Open(specEnvelope) }},
+		{"EnvelopeCipher.AppendOpen", 2, func() { envCipher.AppendOpen(sealBuf[:0], specEnvelope) }},
+		{"splitFields", 0, func() { splitFields(small) }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := testing.AllocsPerRun(100, tc.fn); got != tc.want {
+				t.Errorf("allocs = %.1f, want %.0f", got, tc.want)
+			}
+		})
+	}
+}
+
+var (
+	dataloggerFrame20  = dataloggerFrame(20)
+	dataloggerFrame100 = dataloggerFrame(100)
+	meta32Input        = meta32Frame()
+	pull100Input       = pull100Frame()
+	passthrough4K      = passthroughFrame(4096)
+	locationInput      = "PUSH|" + testAuth + "|tracker|[pos@=39.7392,-104.9903,1609.3]"
+	longStringInput    = "PUSH|" + testAuth + "|dev|[log=" + strings.Repeat("boot ok, ", 900) + "#txt^diag]"
+)
+
+// =========================================================================
+// Benchmarks
+// =========================================================================
+
+func benchmarkParseUplink(b *testing.B, input string) {
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseUplink(input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseUplinkSmall(b *testing.B) {
+	benchmarkParseUplink(b, "PUSH|"+testAuth+"|dev|[temperature:=32.5#C]")
+}
+
+func BenchmarkParseUplink20Vars(b *testing.B) {
+	benchmarkParseUplink(b, dataloggerFrame(20))
+}
+
+func BenchmarkParseUplink100Vars(b *testing.B) {
+	benchmarkParseUplink(b, dataloggerFrame(100))
+}
+
+func BenchmarkParseUplink32Meta(b *testing.B) {
+	benchmarkParseUplink(b, meta32Frame())
+}
+
+func BenchmarkParseUplink32MetaLazy(b *testing.B) {
+	opts := &ParserOptions{LazyMeta: true}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(meta32Input)))
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseUplinkWithOptions(meta32Input, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseUplinkPull100(b *testing.B) {
+	benchmarkParseUplink(b, pull100Frame())
+}
+
+func BenchmarkParseUplinkPassthrough(b *testing.B) {
+	benchmarkParseUplink(b, passthroughFrame(4096))
+}
+
+// BenchmarkParseUplinkPassthrough covers an 8KB hex payload; this is the
+// base64 counterpart.
+func BenchmarkParseUplinkPassthroughBase648K(b *testing.B) {
+	benchmarkParseUplink(b, "PUSH|"+testAuth+"|dev|>b"+strings.Repeat("3q2+7wEC", 1024))
+}
+
+func BenchmarkParseUplinkLongString(b *testing.B) {
+	benchmarkParseUplink(b, longStringInput)
+}
+
+func BenchmarkParseUplinkLocation(b *testing.B) {
+	benchmarkParseUplink(b, locationInput)
+}
+
+func BenchmarkParseUplinkLocationNoAlt(b *testing.B) {
+	benchmarkParseUplink(b, "PUSH|"+testAuth+"|tracker|[pos@=39.7392,-104.9903]")
+}
+
+func BenchmarkParseUplinkCorpus(b *testing.B) {
+	corpus := loadCorpus(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, input := range corpus {
+			if _, err := ParseUplink(input); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkParseBatchParallel(b *testing.B) {
+	data := batchInput(b, 100_000)
+	for _, workers := range []int{1, 2, 4, 8, 16, 32} {
+		b.Run("workers="+strconv.Itoa(workers), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				ParseBatchParallel(data, workers)
+			}
+		})
+	}
+}
+
+// adversarialFrame packs 100 variables with 32 one-byte metadata pairs each
+// into a frame under MaxFrameSize, maximizing the number of allocations.
+func adversarialFrame() string {
+	var b strings.Builder
+	b.WriteString("PUSH|" + testAuth + "|d|[")
+	for i := 0; i < MaxVariables; i++ {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString("v=x{")
+		b.WriteString(strings.TrimSuffix(strings.Repeat("k=,", MaxMetaPairs), ","))
+		b.WriteByte('}')
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// worstCaseFrame is the most allocation-heavy frame accepted under
+// DefaultMaxTotalItems: 100 variables with every optional suffix and 512
+// metadata pairs in total.
+func worstCaseFrame() string {
+	var b strings.Builder
+	b.WriteString("PUSH|" + testAuth + "|d|@1^g{" + strings.TrimSuffix(strings.Repeat("k=v,", MaxMetaPairs), ",") + "}[")
+	meta := MaxTotalMeta - MaxMetaPairs
+	for i := 0; i < MaxVariables; i++ {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString("v=s#u@1^g")
+		if n := min(meta, 5); n > 0 {
+			b.WriteString("{" + strings.TrimSuffix(strings.Repeat("k=v,", n), ",") + "}")
+			meta -= n
+		}
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func BenchmarkParseUplinkAdversarial(b *testing.B) {
+	input := adversarialFrame()
+	unbounded := &ParserOptions{MaxTotalItems: MaxVariables*(1+MaxMetaPairs) + MaxMetaPairs}
+	b.Run("budget=default", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ParseUplink(input); err == nil {
+				b.Fatal("expected budget error")
+			}
+		}
+	})
+	b.Run("budget=unbounded", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ParseUplinkWithOptions(input, unbounded); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("worst-accepted", func(b *testing.B) {
+		input := worstCaseFrame()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := ParseUplink(input); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// pathologicalFrames generate frames of about size bytes that stress the
+// scanners: dense escapes force the escape-stepping path everywhere, and
+// unclosed or nested delimiters make a scanner run to the end of the input.
+var pathologicalFrames = []struct {
+	name string
+	gen  func(size int) string
+}{
+	{"backslashes", func(n int) string { return padFrame("[s=", `\\`, "]", n) }},
+	{"escaped-structural", func(n int) string { return padFrame("[s=", `\;\|\[\{\,\}\#\@\^`, "]", n) }},
+	{"escaped-unit", func(n int) string { return padFrame("[s=1#", `\#`, "]", n) }},
+	{"escaped-meta", func(n int) string { return padFrame("[a:=1{k=", `\,`, "}]", n) }},
+	{"unclosed-meta", func(n int) string { return padFrame("[a:=1{k=", `\}`, "]", n) }},
+	{"body-meta", func(n int) string { return padFrame("{k=", `\}`, "}[a:=1]", n) }},
+	{"nesting", func(n int) string { return padFrame("", "[", "", n) }},
+	{"empty-items", func(n int) string { return padFrame("[", ";", "a:=1]", n) }},
+	{"no-operator", func(n int) string { return padFrame("[", `\=`, "]", n) }},
+}
+
+// padFrame returns a PUSH whose body is open, then unit repeated, then
+// close, filling about size bytes.
+func padFrame(open, unit, close string, size int) string {
+	head := "PUSH|" + testAuth + "|dev|" + open
+	return head + strings.Repeat(unit, (size-len(head)-len(close))/len(unit)) + close
+}
+
+var pathologicalSizes = []int{1 << 10, 4 << 10, MaxFrameSize}
+
+func BenchmarkParseUplinkPathological(b *testing.B) {
+	for _, pf := range pathologicalFrames {
+		for _, size := range pathologicalSizes {
+			input := pf.gen(size)
+			b.Run(pf.name+"/"+strconv.Itoa(size>>10)+"KB", func(b *testing.B) {
+				b.SetBytes(int64(len(input)))
+				for i := 0; i < b.N; i++ {
+					ParseUplink(input)
+				}
+			})
+		}
+	}
+}
+
+// TestParseTimeScalesLinearly guards the linear-time guarantee of
+// ParseUplink: on each pathological frame, the time per byte at
+// MaxFrameSize stays within a small factor of the time per byte at 1KB.
+// A quadratic scan would cost 16 times more per byte.
+func TestParseTimeScalesLinearly(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test")
+	}
+	const tolerance = 4
+	perByte := func(input string) float64 {
+		iters := max(1, (1<<20)/len(input))
+		best := time.Duration(math.MaxInt64)
+		for range 3 {
+			start := time.Now()
+			for i := 0; i < iters; i++ {
+				ParseUplink(input)
+			}
+			best = min(best, time.Since(start))
+		}
+		return float64(best) / float64(iters*len(input))
+	}
+	for _, pf := range pathologicalFrames {
+		small, large := pf.gen(pathologicalSizes[0]), pf.gen(MaxFrameSize)
+		if len(large) > MaxFrameSize {
+			t.Fatalf("%s: %d bytes exceeds MaxFrameSize", pf.name, len(large))
+		}
+		if ratio := perByte(large) / perByte(small); ratio > tolerance {
+			t.Errorf("%s: %.1fx the per-byte time at %d bytes than at %d", pf.name, ratio, len(large), len(small))
+		}
+	}
+}
+
+// distinctFrame returns a PUSH of vars variables and a body metadata block
+// of meta pairs, none of them repeating, so that the duplicate checks
+// compare every item and find nothing.
+func distinctFrame(vars, meta int) string {
+	var b strings.Builder
+	b.WriteString("PUSH|" + testAuth + "|dev|")
+	if meta > 0 {
+		b.WriteByte('{')
+		for i := range meta {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString("k" + strconv.Itoa(i) + "=v")
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte('[')
+	for i := range vars {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString("v" + strconv.Itoa(i) + ":=1@1700000000000")
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// duplicateCheckOptions parses at the largest ParseLimits with both
+// duplicate checks on.
+var duplicateCheckOptions = &ParserOptions{
+	RejectDuplicateVariables: true,
+	RejectDuplicateMetaKeys:  true,
+	Limits: ParseLimits{
+		MaxFrameSize: MaxParseFrameSize,
+		MaxVariables: MaxParseVariables,
+		MaxMetaPairs: MaxParseMetaPairs,
+	},
+	MaxTotalItems: MaxParseVariables + MaxParseMetaPairs,
+}
+
+func BenchmarkParseUplinkDuplicateChecks(b *testing.B) {
+	for _, n := range []struct{ vars, meta int }{
+		{MaxVariables, MaxMetaPairs},
+		{MaxParseVariables, MaxParseMetaPairs},
+	} {
+		input := distinctFrame(n.vars, n.meta)
+		b.Run(strconv.Itoa(n.vars)+"vars", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseUplinkWithOptions(input, duplicateCheckOptions); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// TestDuplicateChecksScaleLinearly guards the duplicate checks against
+// rescanning the earlier items: the time per item at the largest
+// ParseLimits stays within a small factor of the time per item at the
+// protocol limits, where a quadratic check would cost 100 times more.
+func TestDuplicateChecksScaleLinearly(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing test")
+	}
+	const tolerance = 4
+	perItem := func(vars, meta int) float64 {
+		input := distinctFrame(vars, meta)
+		iters := max(1, (1<<20)/len(input))
+		best := time.Duration(math.MaxInt64)
+		for range 3 {
+			start := time.Now()
+			for i := 0; i < iters; i++ {
+				if _, err := ParseUplinkWithOptions(input, duplicateCheckOptions); err != nil {
+					t.Fatal(err)
+				}
+			}
+			best = min(best, time.Since(start))
+		}
+		return float64(best) / float64(iters*(vars+meta))
+	}
+	small := perItem(MaxVariables, MaxMetaPairs)
+	if ratio := perItem(MaxParseVariables, MaxParseMetaPairs) / small; ratio > tolerance {
+		t.Errorf("%.1fx the per-item time at %d variables than at %d", ratio, MaxParseVariables, MaxVariables)
+	}
+	sb := mustParse(t, distinctFrame(MaxVariables, 0)).PushBody.Structured
+	big, err := ParseUplinkWithOptions(distinctFrame(MaxParseVariables, 0), duplicateCheckOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perCheck := func(sb *StructuredBody) float64 {
+		iters := max(1, (1<<16)/len(sb.Variables))
+		best := time.Duration(math.MaxInt64)
+		for range 3 {
+			start := time.Now()
+			for i := 0; i < iters; i++ {
+				CheckDuplicateVariables(sb)
+			}
+			best = min(best, time.Since(start))
+		}
+		return float64(best) / float64(iters*len(sb.Variables))
+	}
+	if ratio := perCheck(big.PushBody.Structured) / perCheck(sb); ratio > tolerance {
+		t.Errorf("CheckDuplicateVariables: %.1fx the per-variable time at %d variables than at %d", ratio, MaxParseVariables, MaxVariables)
+	}
+}
+
+func BenchmarkParseUplinkInto20Vars(b *testing.B) {
+	input := dataloggerFrame(20)
+	frame := &UplinkFrame{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := ParseUplinkInto(frame, input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkRetainedFrames parses 10k copies of the same frame, keeps the
+// results alive, and reports the heap they retain.
+func benchmarkRetainedFrames(b *testing.B, opts *ParserOptions) {
+	const n = 10000
+	inputs := make([]string, n)
+	for i := range inputs {
+		inputs[i] = strings.Clone(dataloggerFrame20)
+	}
+	frames := make([]*UplinkFrame, n)
+	var before, after runtime.MemStats
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		for j, input := range inputs {
+			f, err := ParseUplinkWithOptions(input, opts)
+			if err != nil {
+				b.Fatal(err)
+			}
+			frames[j] = f
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(int64(after.HeapAlloc)-int64(before.HeapAlloc))/n, "retained-B/frame")
+		clear(frames)
+	}
+}
+
+func BenchmarkParseUplinkRetained(b *testing.B) {
+	benchmarkRetainedFrames(b, nil)
+}
+
+func BenchmarkParseUplinkRetainedInterned(b *testing.B) {
+	benchmarkRetainedFrames(b, &ParserOptions{Intern: NewInternTable(1024)})
+}
+
+func BenchmarkBuildUplink100Vars(b *testing.B) {
+	frame, err := ParseUplink(dataloggerFrame(100))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := BuildUplink(frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendUplink100Vars(b *testing.B) {
+	frame, err := ParseUplink(dataloggerFrame(100))
+	if err != nil {
+		b.Fatal(err)
+	}
+	buf := make([]byte, 0, EstimateSize(frame))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if buf, err = AppendUplink(buf[:0], frame); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseAckInto(b *testing.B) {
+	frame := &AckFrame{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := ParseAckInto(frame, "ACK|!10|OK|5"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseUplinkFunc100Vars(b *testing.B) {
+	input := dataloggerFrame(100)
+	fn := func(*Variable) error { return nil }
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := ParseUplinkFunc(input, fn); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseAck(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseAck("ACK|!10|OK|5"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSealUplink(b *testing.B) {
+	inner := []byte("sensor-01|[temperature:=32.5;humidity:=65]")
+	b.SetBytes(int64(len(inner)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := SealUplink(EnvelopeMethodPush, inner, 42, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAppendSealUplink(b *testing.B) {
+	inner := []byte("sensor-01|[temperature:=32.5;humidity:=65]")
+	buf := make([]byte, 0, headerSize+len(inner)+ccmTagSize)
+	b.SetBytes(int64(len(inner)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := AppendSealUplink(buf[:0], EnvelopeMethodPush, inner, 42, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkOpenEnvelope(b *testing.B) {
+	b.SetBytes(int64(len(specEnvelope)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := OpenEnvelope(specEnvelope, specKey); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEnvelopeCipherSeal(b *testing.B) {
+	inner := []byte("sensor-01|[temperature:=32.5;humidity:=65]")
+	c, err := NewEnvelopeCipher(specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(inner)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Seal(EnvelopeMethodPush, inner, 42, specAuthHash, specDeviceHash); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEnvelopeCipherAppendSeal(b *testing.B) {
+	inner := []byte("sensor-01|[temperature:=32.5;humidity:=65]")
+	buf := make([]byte, 0, headerSize+len(inner)+ccmTagSize)
+	c, err := NewEnvelopeCipher(specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(inner)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.AppendSeal(buf[:0], EnvelopeMethodPush, inner, 42, specAuthHash, specDeviceHash); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEnvelopeCipherOpen(b *testing.B) {
+	c, err := NewEnvelopeCipher(specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(specEnvelope)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := c.Open(specEnvelope); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEnvelopeCipherAppendOpen(b *testing.B) {
+	buf := make([]byte, 0, len(specEnvelope))
+	c, err := NewEnvelopeCipher(specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(specEnvelope)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := c.AppendOpen(buf[:0], specEnvelope); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSplitFields(b *testing.B) {
+	input := dataloggerFrame(20)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		splitFields(input)
+	}
+}
+
+// =========================================================================
+// Fuzzing
+// =========================================================================
+
+func FuzzParseUplink(f *testing.F) {
+	for _, input := range loadCorpus(f) {
+		f.Add(input)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		frame, err := ParseUplink(input)
+		if err != nil {
+			return
+		}
+		out, err := BuildUplink(frame)
+		if err != nil {
+			t.Fatalf("build failed for parsed frame: %v", err)
+		}
+		if _, err := ParseUplink(out); err != nil {
+			t.Fatalf("rebuilt frame %q does not parse: %v", out, err)
+		}
+	})
+}
@@ -2,45 +2,79 @@ package tagotip
 
 import (
 	"fmt"
-	"strings"
+	"strconv"
+	"unsafe"
 )
 
-func writeValue(op Operator, v Value) string {
+// frameBuf is the buffer frames are written into: the caller's slice for
+// the Append functions, or a fresh one for the Build functions. Its
+// methods mirror those of strings.Builder.
+type frameBuf []byte
+
+func (b *frameBuf) WriteByte(c byte) error {
+	*b = append(*b, c)
+	return nil
+}
+
+func (b *frameBuf) WriteString(s string) (int, error) {
+	*b = append(*b, s...)
+	return len(s), nil
+}
+
+func (b *frameBuf) Len() int { return len(*b) }
+
+// String returns the buffer's content without copying it. The buffer must
+// not be written to afterwards.
+func (b frameBuf) String() string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
+}
+
+func writeValue(b *frameBuf, op Operator, v Value) {
+	if op < 0 || int(op) >= numOperators {
+		b.WriteByte(operatorAssign)
+		return
+	}
+	b.WriteString(operatorTokens[op])
+	if v.Type != op {
+		return
+	}
 	switch op {
 	case OperatorNumber:
-		if v.Type != OperatorNumber {
-			return ":="
-		}
-		return ":=" + v.Str
+		b.WriteString(v.Str)
 	case OperatorString:
-		if v.Type != OperatorString {
-			return "="
-		}
-		return "=" + v.Str
+		writeEscaped(b, v.Str)
 	case OperatorBoolean:
-		if v.Type != OperatorBoolean {
-			return "?="
-		}
 		if v.Bool {
-			return "?=true"
+			b.WriteString("true")
+		} else {
+			b.WriteString("false")
 		}
-		return "?=false"
 	case OperatorLocation:
-		if v.Type != OperatorLocation || v.Location == nil {
-			return "@="
-		}
 		loc := v.Location
-		s := "@=" + loc.Lat + "," + loc.Lng
+		if loc == nil {
+			return
+		}
+		b.WriteString(loc.Lat)
+		b.WriteByte(',')
+		b.WriteString(loc.Lng)
 		if loc.Alt != nil {
-			s += "," + *loc.Alt
+			b.WriteByte(',')
+			b.WriteString(*loc.Alt)
 		}
-		return s
 	}
-	return "="
 }
 
-func writeMetaPairs(pairs []MetaPair) string {
-	var b strings.Builder
+// writeMeta writes a metadata block from pairs, escaping their values, or
+// from the raw block text kept by a lazy parse when pairs is empty.
+func writeMeta(b *frameBuf, pairs []MetaPair, raw string) {
+	if len(pairs) == 0 {
+		if raw != "" {
+			b.WriteByte('{')
+			b.WriteString(raw)
+			b.WriteByte('}')
+		}
+		return
+	}
 	b.WriteByte('{')
 	for i, p := range pairs {
 		if i > 0 {
@@ -48,19 +82,17 @@ func writeMetaPairs(pairs []MetaPair) string {
 		}
 		b.WriteString(p.Key)
 		b.WriteByte('=')
-		b.WriteString(p.Value)
+		writeEscaped(b, p.Value)
 	}
 	b.WriteByte('}')
-	return b.String()
 }
 
-func writeVariable(v Variable) string {
-	var b strings.Builder
+func writeVariable(b *frameBuf, v *Variable) {
 	b.WriteString(v.Name)
-	b.WriteString(writeValue(v.Operator, v.Value))
+	writeValue(b, v.Operator, v.Value)
 	if v.Unit != nil {
 		b.WriteByte('#')
-		b.WriteString(*v.Unit)
+		writeEscaped(b, *v.Unit)
 	}
 	if v.Timestamp != nil {
 		b.WriteByte('@')
@@ -70,28 +102,27 @@ func writeVariable(v Variable) string {
 		b.WriteByte('^')
 		b.WriteString(*v.Group)
 	}
-	if len(v.Meta) > 0 {
-		b.WriteString(writeMetaPairs(v.Meta))
-	}
-	return b.String()
+	writeMeta(b, v.Meta, v.rawMeta)
 }
 
-func writePushBody(body *PushBody) string {
+func writePushBody(b *frameBuf, body *PushBody) {
 	if body.IsPassthrough && body.Passthrough != nil {
 		pt := body.Passthrough
-		prefix := ">x"
 		if pt.Encoding == PassthroughEncodingBase64 {
-			prefix = ">b"
+			b.WriteString(">b")
+		} else {
+			b.WriteString(">x")
 		}
-		return prefix + pt.Data
+		b.WriteString(pt.Data)
+		return
 	}
 
 	sb := body.Structured
 	if sb == nil {
-		return "[]"
+		b.WriteString("[]")
+		return
 	}
 
-	var b strings.Builder
 	if sb.Timestamp != nil {
 		b.WriteByte('@')
 		b.WriteString(*sb.Timestamp)
@@ -100,57 +131,301 @@ func writePushBody(body *PushBody) string {
 		b.WriteByte('^')
 		b.WriteString(*sb.Group)
 	}
-	if len(sb.Meta) > 0 {
-		b.WriteString(writeMetaPairs(sb.Meta))
+	writeMeta(b, sb.Meta, sb.rawMeta)
+	b.WriteByte('[')
+	for i := range sb.Variables {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		writeVariable(b, &sb.Variables[i])
 	}
+	b.WriteByte(']')
+}
+
+func writePullBody(b *frameBuf, body *PullBody) {
 	b.WriteByte('[')
-	for i, v := range sb.Variables {
+	for i, name := range body.Variables {
 		if i > 0 {
 			b.WriteByte(';')
 		}
-		b.WriteString(writeVariable(v))
+		b.WriteString(name)
 	}
 	b.WriteByte(']')
-	return b.String()
 }
 
-func writePullBody(body *PullBody) string {
-	return "[" + strings.Join(body.Variables, ";") + "]"
+func writeUint(b *frameBuf, n uint64) {
+	*b = strconv.AppendUint(*b, n, 10)
 }
 
-// BuildUplink serializes an UplinkFrame into a raw frame string.
-func BuildUplink(frame *UplinkFrame) (string, error) {
-	if frame == nil {
-		return "", fmt.Errorf("tagotip: nil frame")
+func uintLen(n uint64) int {
+	l := 1
+	for n >= 10 {
+		n /= 10
+		l++
+	}
+	return l
+}
+
+func optLen(s *string) int {
+	if s == nil {
+		return 0
+	}
+	return len(*s) + 1
+}
+
+func escapedOptLen(s *string) int {
+	if s == nil {
+		return 0
 	}
+	return escapedLen(*s) + 1
+}
+
+func metaSize(pairs []MetaPair, raw string) int {
+	if len(pairs) == 0 {
+		if raw != "" {
+			return 2 + len(raw)
+		}
+		return 0
+	}
+	n := 2 + len(pairs) - 1
+	for _, p := range pairs {
+		n += len(p.Key) + 1 + escapedLen(p.Value)
+	}
+	return n
+}
+
+// pushBodySize returns an upper bound on the serialized size of body, used to
+// size the output buffer up front.
+func pushBodySize(body *PushBody) int {
+	if body.IsPassthrough && body.Passthrough != nil {
+		return 2 + len(body.Passthrough.Data)
+	}
+	sb := body.Structured
+	if sb == nil {
+		return 2
+	}
+	n := optLen(sb.Timestamp) + optLen(sb.Group) + metaSize(sb.Meta, sb.rawMeta) + 2
+	for i := range sb.Variables {
+		v := &sb.Variables[i]
+		n += len(v.Name) + 2 + escapedLen(v.Value.Str) + 5 + 1
+		if loc := v.Value.Location; loc != nil {
+			n += len(loc.Lat) + 1 + len(loc.Lng) + optLen(loc.Alt)
+		}
+		n += escapedOptLen(v.Unit) + optLen(v.Timestamp) + optLen(v.Group) + metaSize(v.Meta, v.rawMeta)
+	}
+	return n
+}
 
-	var parts []string
+func pullBodySize(body *PullBody) int {
+	n := 2
+	for _, name := range body.Variables {
+		n += len(name) + 1
+	}
+	return n
+}
 
-	switch frame.Method {
+func methodKeyword(m Method) string {
+	switch m {
 	case MethodPush:
-		parts = append(parts, "PUSH")
+		return "PUSH"
 	case MethodPull:
-		parts = append(parts, "PULL")
+		return "PULL"
 	case MethodPing:
-		parts = append(parts, "PING")
+		return "PING"
 	}
+	return ""
+}
 
-	if frame.Seq != nil {
-		parts = append(parts, fmt.Sprintf("!%d", *frame.Seq))
+func ackStatusKeyword(s AckStatus) string {
+	switch s {
+	case AckStatusOk:
+		return "OK"
+	case AckStatusPong:
+		return "PONG"
+	case AckStatusCmd:
+		return "CMD"
+	case AckStatusErr:
+		return "ERR"
+	}
+	return ""
+}
+
+func isAckDetailType(t string) bool {
+	switch t {
+	case "count", "variables", "command", "error", "raw":
+		return true
+	}
+	return false
+}
+
+// writeAckDetail writes the ACK detail without its leading separator.
+func writeAckDetail(b *frameBuf, d *AckDetail) {
+	switch d.Type {
+	case "count":
+		writeUint(b, uint64(d.Count))
+	case "variables":
+		switch {
+		case d.Vars != nil:
+			writeDetailVariables(b, d.Vars.Variables)
+		case d.Text == "" && len(d.Variables) > 0:
+			writeDetailVariables(b, d.Variables)
+		default:
+			b.WriteString(d.Text)
+		}
+	case "raw":
+		if d.Pong != nil {
+			writePongDetail(b, d.Pong)
+			return
+		}
+		b.WriteString(d.Text)
+	case "command":
+		if d.Cmd != nil {
+			writeCommandDetail(b, d.Cmd)
+			return
+		}
+		b.WriteString(d.Text)
+	case "error":
+		b.WriteString(errorDetailText(d))
+		if d.Err != nil {
+			writeErrorDetail(b, d.Err)
+		}
 	}
+}
 
-	parts = append(parts, frame.Auth)
-	parts = append(parts, frame.Serial)
+// BuildUplink serializes an UplinkFrame into a raw frame string. String
+// values, units, and metadata values hold plain text, as ParseUplink
+// returns them, and are escaped on the way out.
+//
+// Before writing anything, BuildUplink checks the method, authorization
+// hash, serial, names, groups, metadata keys, units, timestamps, number
+// values, passthrough payloads, and the number of variables and metadata
+// pairs against the rules and default limits ParseUplink applies, and
+// fails naming the first field that breaks them. A frame that, with its
+// trailing newline, is longer than MaxFrameSize fails with a
+// *FrameSizeError.
+func BuildUplink(frame *UplinkFrame) (string, error) {
+	buf, err := AppendUplink(make([]byte, 0, EstimateSize(frame)), frame)
+	if err != nil {
+		return "", err
+	}
+	return frameBuf(buf).String(), nil
+}
 
-	result := strings.Join(parts, "|")
+// AppendUplink appends the frame BuildUplink writes for frame to dst and
+// returns the extended slice. It allocates nothing when dst has room for
+// the frame; EstimateSize gives a capacity that always does. On error, dst
+// is returned unchanged.
+func AppendUplink(dst []byte, frame *UplinkFrame) ([]byte, error) {
+	if err := checkBuildable(frame); err != nil {
+		return dst, err
+	}
+	if err := checkUplink(frame); err != nil {
+		return dst, err
+	}
+	b := frameBuf(dst)
+	writeUplink(&b, frame)
+	// MaxFrameSize counts the newline the frame is sent with.
+	if err := checkFrameSize(len(b)-len(dst)+1, MaxFrameSize); err != nil {
+		return dst, err
+	}
+	return b, nil
+}
 
+// EstimateSize returns an upper bound on the length of the frame
+// BuildUplink writes for frame, so that callers can check it, plus the
+// trailing newline, against MaxFrameSize before building. The bound is
+// not tight: frames with many variables can come out some bytes shorter.
+// It is 0 for a nil frame.
+func EstimateSize(frame *UplinkFrame) int {
+	if frame == nil {
+		return 0
+	}
+	n := len(methodKeyword(frame.Method)) + 1 + len(frame.Auth) + 1 + len(frame.Serial)
+	if frame.Seq != nil {
+		n += 2 + uintLen(uint64(*frame.Seq))
+	}
 	if frame.Method == MethodPush && frame.PushBody != nil {
-		result += "|" + writePushBody(frame.PushBody)
+		n += 1 + pushBodySize(frame.PushBody)
 	} else if frame.Method == MethodPull && frame.PullBody != nil {
-		result += "|" + writePullBody(frame.PullBody)
+		n += 1 + pullBodySize(frame.PullBody)
+	} else if frame.Method == MethodPing && len(frame.PingDiagnostics) > 0 {
+		n += 1 + metaSize(frame.PingDiagnostics, "")
+	}
+	return n
+}
+
+// BuildOptions controls BuildUplinkWithOptions.
+type BuildOptions struct {
+	// DedupMetaKeys writes every metadata block, and the diagnostics of a
+	// PING, with each key once, as MetaPairs.Dedup leaves it: at its first
+	// position, holding its last value. The frame itself is not changed.
+	DedupMetaKeys bool
+}
+
+// BuildUplinkWithOptions is BuildUplink applying opts. A nil opts is
+// equivalent to the zero value.
+func BuildUplinkWithOptions(frame *UplinkFrame, opts *BuildOptions) (string, error) {
+	if frame == nil || opts == nil || !opts.DedupMetaKeys {
+		return BuildUplink(frame)
+	}
+	c := cloneUplink(frame)
+	c.PingDiagnostics = MetaPairs(c.PingDiagnostics).Dedup()
+	if sb := structuredBody(c); sb != nil {
+		sb.Meta = sb.Metadata().Dedup()
+		for i := range sb.Variables {
+			v := &sb.Variables[i]
+			v.Meta = v.Metadata().Dedup()
+		}
+	}
+	return BuildUplink(c)
+}
+
+// BuildUplinkUnchecked is BuildUplink without the field and size checks,
+// for tools that need to write frames the parser rejects.
+func BuildUplinkUnchecked(frame *UplinkFrame) (string, error) {
+	if err := checkBuildable(frame); err != nil {
+		return "", err
+	}
+	b := frameBuf(make([]byte, 0, EstimateSize(frame)))
+	writeUplink(&b, frame)
+	return b.String(), nil
+}
+
+// checkBuildable rejects the frames that cannot be written at all.
+func checkBuildable(frame *UplinkFrame) error {
+	if frame == nil {
+		return fmt.Errorf("tagotip: nil frame")
+	}
+	if frame.Method == MethodUnknown {
+		return fmt.Errorf("tagotip: cannot build frame with unknown method %q", frame.RawMethod)
+	}
+	return nil
+}
+
+func writeUplink(b *frameBuf, frame *UplinkFrame) {
+	if method := methodKeyword(frame.Method); method != "" {
+		b.WriteString(method)
+		b.WriteByte('|')
+	}
+	if frame.Seq != nil {
+		b.WriteByte('!')
+		writeUint(b, uint64(*frame.Seq))
+		b.WriteByte('|')
 	}
+	b.WriteString(frame.Auth)
+	b.WriteByte('|')
+	b.WriteString(frame.Serial)
 
-	return result, nil
+	if frame.Method == MethodPush && frame.PushBody != nil {
+		b.WriteByte('|')
+		writePushBody(b, frame.PushBody)
+	} else if frame.Method == MethodPull && frame.PullBody != nil {
+		b.WriteByte('|')
+		writePullBody(b, frame.PullBody)
+	} else if frame.Method == MethodPing && len(frame.PingDiagnostics) > 0 {
+		b.WriteByte('|')
+		writeMeta(b, frame.PingDiagnostics, "")
+	}
 }
 
 // BuildHeadless serializes a HeadlessFrame for TagoTiP/S.
@@ -158,27 +433,44 @@ func BuildUplink(frame *UplinkFrame) (string, error) {
 //   - PUSH: SERIAL|BODY
 //   - PULL: SERIAL|[VARNAME;...]
 //   - PING: SERIAL
+//
+// The fields are checked as BuildUplink checks them, and a frame longer
+// than the TagoTiP/S inner frame limit fails with a *FrameSizeError.
 func BuildHeadless(method Method, frame *HeadlessFrame) (string, error) {
 	if frame == nil {
 		return "", fmt.Errorf("tagotip: nil frame")
 	}
+	if err := checkHeadless(method, frame); err != nil {
+		return "", err
+	}
 
+	var b frameBuf
 	switch method {
 	case MethodPush:
 		if frame.PushBody == nil {
 			return "", fmt.Errorf("tagotip: PUSH headless frame requires push body")
 		}
-		return frame.Serial + "|" + writePushBody(frame.PushBody), nil
+		b = make(frameBuf, 0, len(frame.Serial)+1+pushBodySize(frame.PushBody))
+		b.WriteString(frame.Serial)
+		b.WriteByte('|')
+		writePushBody(&b, frame.PushBody)
 	case MethodPull:
 		if frame.PullBody == nil {
 			return "", fmt.Errorf("tagotip: PULL headless frame requires pull body")
 		}
-		return frame.Serial + "|" + writePullBody(frame.PullBody), nil
+		b = make(frameBuf, 0, len(frame.Serial)+1+pullBodySize(frame.PullBody))
+		b.WriteString(frame.Serial)
+		b.WriteByte('|')
+		writePullBody(&b, frame.PullBody)
 	case MethodPing:
 		return frame.Serial, nil
+	default:
+		return "", fmt.Errorf("tagotip: unknown method")
 	}
-
-	return "", fmt.Errorf("tagotip: unknown method")
+	if err := checkFrameSize(len(b), maxInnerFrameSize); err != nil {
+		return "", err
+	}
+	return b.String(), nil
 }
 
 // BuildAckInner serializes an AckFrame into a TagoTiP/S inner frame (STATUS[|DETAIL], no ACK| prefix).
@@ -187,76 +479,70 @@ func BuildAckInner(frame *AckFrame) (string, error) {
 		return "", fmt.Errorf("tagotip: nil frame")
 	}
 
-	var status string
-	switch frame.Status {
-	case AckStatusOk:
-		status = "OK"
-	case AckStatusPong:
-		status = "PONG"
-	case AckStatusCmd:
-		status = "CMD"
-	case AckStatusErr:
-		status = "ERR"
-	}
-
+	status := ackStatusKeyword(frame.Status)
 	if frame.Detail == nil {
 		return status, nil
 	}
 
-	var detailStr string
-	switch frame.Detail.Type {
-	case "count":
-		detailStr = fmt.Sprintf("%d", frame.Detail.Count)
-	case "variables":
-		detailStr = frame.Detail.Text
-	case "command":
-		detailStr = frame.Detail.Text
-	case "error":
-		detailStr = frame.Detail.Text
-	case "raw":
-		detailStr = frame.Detail.Text
+	b := make(frameBuf, 0, len(status)+1+len(frame.Detail.Text)+10)
+	b.WriteString(status)
+	b.WriteByte('|')
+	start := b.Len()
+	writeAckDetail(&b, frame.Detail)
+	if err := checkAckDetail(frame.Detail, b.Len()-start); err != nil {
+		return "", err
 	}
-
-	return status + "|" + detailStr, nil
+	if err := checkFrameSize(len(b), maxInnerFrameSize); err != nil {
+		return "", err
+	}
+	return b.String(), nil
 }
 
-// BuildAck serializes an AckFrame into a raw frame string.
+// BuildAck serializes an AckFrame into a raw frame string. A frame that,
+// with its trailing newline, is longer than MaxFrameSize fails with a
+// *FrameSizeError.
 func BuildAck(frame *AckFrame) (string, error) {
 	if frame == nil {
 		return "", fmt.Errorf("tagotip: nil frame")
 	}
 
-	parts := []string{"ACK"}
-
-	if frame.Seq != nil {
-		parts = append(parts, fmt.Sprintf("!%d", *frame.Seq))
+	size := 4 + 12 + 5
+	if frame.Detail != nil {
+		size += 1 + len(frame.Detail.Text) + 10
+	}
+	buf, err := AppendAck(make([]byte, 0, size), frame)
+	if err != nil {
+		return "", err
 	}
+	return frameBuf(buf).String(), nil
+}
 
-	switch frame.Status {
-	case AckStatusOk:
-		parts = append(parts, "OK")
-	case AckStatusPong:
-		parts = append(parts, "PONG")
-	case AckStatusCmd:
-		parts = append(parts, "CMD")
-	case AckStatusErr:
-		parts = append(parts, "ERR")
+// AppendAck is the BuildAck counterpart of AppendUplink.
+func AppendAck(dst []byte, frame *AckFrame) ([]byte, error) {
+	if frame == nil {
+		return dst, fmt.Errorf("tagotip: nil frame")
 	}
 
-	if frame.Detail != nil {
-		switch frame.Detail.Type {
-		case "count":
-			parts = append(parts, fmt.Sprintf("%d", frame.Detail.Count))
-		case "variables":
-			parts = append(parts, frame.Detail.Text)
-		case "command":
-			parts = append(parts, frame.Detail.Text)
-		case "error":
-			parts = append(parts, frame.Detail.Text)
-		case "raw":
-			parts = append(parts, frame.Detail.Text)
+	b := frameBuf(dst)
+	b.WriteString("ACK")
+	if frame.Seq != nil {
+		b.WriteString("|!")
+		writeUint(&b, uint64(*frame.Seq))
+	}
+	if status := ackStatusKeyword(frame.Status); status != "" {
+		b.WriteByte('|')
+		b.WriteString(status)
+	}
+	if frame.Detail != nil && isAckDetailType(frame.Detail.Type) {
+		b.WriteByte('|')
+		start := b.Len()
+		writeAckDetail(&b, frame.Detail)
+		if err := checkAckDetail(frame.Detail, b.Len()-start); err != nil {
+			return dst, err
 		}
 	}
-
-	return strings.Join(parts, "|"), nil
+	if err := checkFrameSize(len(b)-len(dst)+1, MaxFrameSize); err != nil {
+		return dst, err
+	}
+	return b, nil
 }
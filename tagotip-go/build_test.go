@@ -1,6 +1,13 @@
 package tagotip
 
-import "testing"
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
 
 func strPtr(s string) *string { return &s }
 func u32Ptr(n uint32) *uint32 { return &n }
@@ -276,6 +283,948 @@ func TestBuildRoundTripAckErrAllCodes(t *testing.T) {
 	}
 }
 
+func TestBuildAckErrFromErrorCode(t *testing.T) {
+	for code := ErrorCode(0); code < ErrorCodeUnknown; code++ {
+		for name, frame := range map[string]*AckFrame{
+			"detail":    {Status: AckStatusErr, Detail: &AckDetail{Type: "error", ErrorCode: code}},
+			"NewAckErr": NewAckErr(code, u32Ptr(9)),
+		} {
+			raw, err := BuildAck(frame)
+			if err != nil {
+				t.Fatalf("%v %s: %v", code, name, err)
+			}
+			if !strings.HasSuffix(raw, "|ERR|"+code.String()) {
+				t.Errorf("%v %s built as %s", code, name, raw)
+			}
+			parsed, err := ParseAck(raw)
+			if err != nil {
+				t.Fatalf("%v %s: %v", code, name, err)
+			}
+			if parsed.Detail.ErrorCode != code {
+				t.Errorf("%s parsed as %v", raw, parsed.Detail.ErrorCode)
+			}
+		}
+	}
+
+	for _, frame := range []*AckFrame{
+		{Status: AckStatusErr, Detail: &AckDetail{Type: "error", ErrorCode: ErrorCodeUnknown}},
+		NewAckErr(ErrorCodeUnknown, nil),
+	} {
+		if raw, err := BuildAck(frame); err == nil {
+			t.Errorf("unknown code without text built as %s", raw)
+		}
+	}
+	frame := NewAckErr(ErrorCodeUnknown, nil)
+	frame.Detail.Text = "quota_exceeded"
+	if raw, err := BuildAck(frame); err != nil || raw != "ACK|ERR|quota_exceeded" {
+		t.Errorf("got %q, %v", raw, err)
+	}
+}
+
+// =========================================================================
+// Field checks
+// =========================================================================
+
+func TestBuildUplinkRejectsInvalidFields(t *testing.T) {
+	base := "PUSH|" + testAuth + "|dev|^g{k=v}[t:=1#C^g{k=v};p@=1,2,3]"
+	for _, tc := range []struct {
+		name  string
+		edit  func(*UplinkFrame)
+		field string
+		kind  ParseErrorKind
+	}{
+		{"empty auth", func(f *UplinkFrame) { f.Auth = "" }, "authorization hash", ErrInvalidAuth},
+		{"serial", func(f *UplinkFrame) { f.Serial = "a b" }, `serial "a b"`, ErrInvalidSerial},
+		{"variable name", func(f *UplinkFrame) { f.PushBody.Structured.Variables[0].Name = "Temp" }, `variable name "Temp"`, ErrInvalidVariable},
+		{"number", func(f *UplinkFrame) { f.PushBody.Structured.Variables[0].Value.Str = "1e5" }, `variable "t" value`, ErrInvalidVariable},
+		{"latitude", func(f *UplinkFrame) { f.PushBody.Structured.Variables[1].Value.Location.Lat = "" }, `variable "p" value`, ErrInvalidVariable},
+		{"unit", func(f *UplinkFrame) { f.PushBody.Structured.Variables[0].Unit = strPtr("") }, `variable "t" unit`, ErrInvalidVariable},
+		{"group", func(f *UplinkFrame) { f.PushBody.Structured.Variables[0].Group = strPtr("G") }, `variable "t" group "G"`, ErrInvalidVariable},
+		{"meta key", func(f *UplinkFrame) { f.PushBody.Structured.Variables[0].Meta[0].Key = "K" }, `variable "t" metadata key "K"`, ErrInvalidMetadata},
+		{"body group", func(f *UplinkFrame) { f.PushBody.Structured.Group = strPtr("") }, `body group ""`, ErrInvalidVariable},
+		{"body meta key", func(f *UplinkFrame) { f.PushBody.Structured.Meta[0].Key = "a-b" }, `body metadata key "a-b"`, ErrInvalidMetadata},
+		{"no variables", func(f *UplinkFrame) { f.PushBody.Structured.Variables = nil }, "PUSH body", ErrInvalidVarBlock},
+		{"operator", func(f *UplinkFrame) { f.PushBody.Structured.Variables[0].Operator = OperatorString }, `variable "t"`, ErrInvalidVariable},
+		{"method", func(f *UplinkFrame) { f.Method = Method(7) }, "method Method(7)", ErrInvalidMethod},
+	} {
+		frame := mustParse(t, base)
+		tc.edit(frame)
+		raw, err := BuildUplink(frame)
+		if err == nil {
+			t.Errorf("%s: built %s", tc.name, raw)
+			continue
+		}
+		var pe *ParseError
+		if !strings.HasPrefix(err.Error(), "tagotip: "+tc.field+": ") || !errors.As(err, &pe) || pe.Kind != tc.kind {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if _, err := BuildUplinkUnchecked(frame); err != nil {
+			t.Errorf("%s: unchecked: %v", tc.name, err)
+		}
+	}
+}
+
+func TestBuildUplinkRejectsWhatParseRejects(t *testing.T) {
+	vars := func(n, meta int) []Variable {
+		vs := make([]Variable, n)
+		for i := range vs {
+			vs[i] = Variable{Name: "v", Operator: OperatorBoolean, Value: Value{Type: OperatorBoolean}}
+			for j := 0; j < meta; j++ {
+				vs[i].Meta = append(vs[i].Meta, MetaPair{"k", "x"})
+			}
+		}
+		return vs
+	}
+	push := func(sb *StructuredBody) *UplinkFrame {
+		return &UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev", PushBody: &PushBody{Structured: sb}}
+	}
+	passthrough := func(enc PassthroughEncoding, data string) *UplinkFrame {
+		return &UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev",
+			PushBody: &PushBody{IsPassthrough: true, Passthrough: &PassthroughBody{Encoding: enc, Data: data}}}
+	}
+	withTimestamp := vars(1, 0)
+	withTimestamp[0].Timestamp = strPtr("abc")
+	for name, frame := range map[string]*UplinkFrame{
+		"variable timestamp": push(&StructuredBody{Variables: withTimestamp}),
+		"body timestamp":     push(&StructuredBody{Timestamp: strPtr("1x"), Variables: vars(1, 0)}),
+		"variables":          push(&StructuredBody{Variables: vars(MaxVariables+1, 0)}),
+		"variable metadata":  push(&StructuredBody{Variables: vars(1, MaxMetaPairs+1)}),
+		"body metadata":      push(&StructuredBody{Meta: vars(1, MaxMetaPairs+1)[0].Meta, Variables: vars(1, 0)}),
+		"total items":        push(&StructuredBody{Variables: vars(20, MaxMetaPairs)}),
+		"hex passthrough":    passthrough(PassthroughEncodingHex, "zz"),
+		"odd hex":            passthrough(PassthroughEncodingHex, "abc"),
+		"base64 passthrough": passthrough(PassthroughEncodingBase64, "zz"),
+		"empty passthrough":  passthrough(PassthroughEncodingBase64, ""),
+		"PULL names": {Method: MethodPull, Auth: testAuth, Serial: "dev",
+			PullBody: &PullBody{Variables: strings.Fields(strings.Repeat("v ", MaxVariables+1))}},
+		"PING diagnostics": {Method: MethodPing, Auth: testAuth, Serial: "dev", PingDiagnostics: vars(1, MaxMetaPairs+1)[0].Meta},
+	} {
+		if raw, err := BuildUplink(frame); err == nil {
+			t.Errorf("%s: built %.60s", name, raw)
+		}
+		// The unchecked frame is one ParseUplink rejects.
+		raw, err := BuildUplinkUnchecked(frame)
+		if err != nil {
+			t.Fatalf("%s: unchecked: %v", name, err)
+		}
+		if _, err := ParseUplinkWithOptions(raw, &ParserOptions{AllowPingDiagnostics: true}); err == nil {
+			t.Errorf("%s: ParseUplink accepts %.60s", name, raw)
+		}
+	}
+
+	// At the limits, the frame builds and parses back.
+	for name, frame := range map[string]*UplinkFrame{
+		"variables":   push(&StructuredBody{Variables: vars(MaxVariables, 0)}),
+		"metadata":    push(&StructuredBody{Meta: vars(1, MaxMetaPairs)[0].Meta, Variables: vars(1, MaxMetaPairs)}),
+		"total items": push(&StructuredBody{Variables: vars(18, MaxMetaPairs)}),
+		"passthrough": passthrough(PassthroughEncodingBase64, "AQID"),
+	} {
+		raw, err := BuildUplink(frame)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if _, err := ParseUplink(raw); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestBuildUplinkRejectsInvalidPull(t *testing.T) {
+	for _, vars := range [][]string{nil, {"ok", "Bad"}} {
+		frame := &UplinkFrame{Method: MethodPull, Auth: testAuth, Serial: "dev", PullBody: &PullBody{Variables: vars}}
+		if raw, err := BuildUplink(frame); err == nil {
+			t.Errorf("%q: built %s", vars, raw)
+		}
+	}
+}
+
+func TestBuildHeadlessRejectsInvalidFields(t *testing.T) {
+	for name, build := range map[string]func() (string, error){
+		"serial": func() (string, error) { return BuildHeadless(MethodPing, &HeadlessFrame{Serial: ""}) },
+		"method": func() (string, error) { return BuildHeadless(Method(7), &HeadlessFrame{Serial: "dev"}) },
+		"pull": func() (string, error) {
+			return BuildHeadless(MethodPull, &HeadlessFrame{Serial: "dev", PullBody: &PullBody{Variables: []string{"A"}}})
+		},
+		"push": func() (string, error) {
+			body := &StructuredBody{Variables: []Variable{{Name: "t", Operator: OperatorNumber, Value: Value{Str: "-"}}}}
+			return BuildHeadless(MethodPush, &HeadlessFrame{Serial: "dev", PushBody: &PushBody{Structured: body}})
+		},
+	} {
+		if raw, err := build(); err == nil {
+			t.Errorf("%s: built %s", name, raw)
+		}
+	}
+}
+
+// =========================================================================
+// Frame size
+// =========================================================================
+
+func TestBuildRejectsOversizedFrames(t *testing.T) {
+	long := strings.Repeat("x", MaxFrameSize)
+	body := &PushBody{Structured: &StructuredBody{Variables: []Variable{
+		{Name: "s", Operator: OperatorString, Value: Value{Type: OperatorString, Str: long}},
+	}}}
+	for name, build := range map[string]func() (string, error){
+		"uplink": func() (string, error) {
+			return BuildUplink(&UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev", PushBody: body})
+		},
+		"headless": func() (string, error) {
+			return BuildHeadless(MethodPush, &HeadlessFrame{Serial: "dev", PushBody: body})
+		},
+		"ack": func() (string, error) {
+			return BuildAck(&AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "raw", Text: long}})
+		},
+		"ack inner": func() (string, error) {
+			return BuildAckInner(&AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "raw", Text: long}})
+		},
+	} {
+		raw, err := build()
+		var tooLarge *FrameSizeError
+		if !errors.As(err, &tooLarge) {
+			t.Errorf("%s: got %d bytes, %v", name, len(raw), err)
+			continue
+		}
+		if tooLarge.Limit != MaxFrameSize || tooLarge.Size <= MaxFrameSize || raw != "" {
+			t.Errorf("%s: %+v", name, tooLarge)
+		}
+	}
+
+	frame := &UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev", PushBody: body}
+	if raw, err := BuildUplinkUnchecked(frame); err != nil || len(raw) <= MaxFrameSize {
+		t.Errorf("unchecked: %d bytes, %v", len(raw), err)
+	}
+}
+
+func TestBuildFrameSizeBoundary(t *testing.T) {
+	// Each frame is sized to MaxFrameSize-1+extra bytes, so that with its
+	// newline it fits exactly when extra is 0.
+	uplink := func(extra int) (string, error) {
+		head := len("PUSH|" + testAuth + "|dev|[s=]")
+		str := strings.Repeat("x", MaxFrameSize-1+extra-head)
+		return BuildUplink(&UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev",
+			PushBody: &PushBody{Structured: &StructuredBody{Variables: []Variable{
+				{Name: "s", Operator: OperatorString, Value: Value{Type: OperatorString, Str: str}},
+			}}}})
+	}
+	ack := func(extra int) (string, error) {
+		text := strings.Repeat("x", MaxFrameSize-1+extra-len("ACK|OK|"))
+		return BuildAck(&AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "raw", Text: text}})
+	}
+	for name, build := range map[string]func(int) (string, error){"uplink": uplink, "ack": ack} {
+		raw, err := build(0)
+		if err != nil || len(raw) != MaxFrameSize-1 {
+			t.Fatalf("%s: %d bytes, %v", name, len(raw), err)
+		}
+		if name == "uplink" {
+			_, err = ParseUplink(raw + "\n")
+		} else {
+			_, err = ParseAck(raw + "\n")
+		}
+		if err != nil {
+			t.Errorf("%s: parsing %d bytes with the newline: %v", name, len(raw)+1, err)
+		}
+
+		raw, err = build(1)
+		var tooLarge *FrameSizeError
+		if !errors.As(err, &tooLarge) || tooLarge.Size != MaxFrameSize+1 || raw != "" {
+			t.Errorf("%s: %d bytes built, %v", name, len(raw), err)
+		}
+	}
+}
+
+func TestEstimateSize(t *testing.T) {
+	for _, raw := range loadCorpus(t) {
+		frame, err := ParseUplink(raw)
+		if err != nil {
+			continue
+		}
+		built, err := BuildUplink(frame)
+		if err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+		if n := EstimateSize(frame); n < len(built) {
+			t.Errorf("%s: estimated %d bytes, built %d", raw, n, len(built))
+		}
+	}
+	for _, raw := range []string{
+		"PING|" + testAuth + "|dev",
+		"PING|!4294967295|" + testAuth + "|dev",
+	} {
+		if n := EstimateSize(mustParse(t, raw)); n != len(raw) {
+			t.Errorf("%s: estimated %d bytes, want %d", raw, n, len(raw))
+		}
+	}
+}
+
+func TestAppendUplinkMatchesBuild(t *testing.T) {
+	prefix := []byte("len=")
+	for _, raw := range loadCorpus(t) {
+		frame, err := ParseUplink(raw)
+		if err != nil {
+			continue
+		}
+		want, err := BuildUplink(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := AppendUplink(append([]byte(nil), prefix...), frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(prefix)+want {
+			t.Errorf("%s: appended %s", raw, got)
+		}
+	}
+
+	ack := NewAckErrRateLimited(u32Ptr(3), 30)
+	want, _ := BuildAck(ack)
+	if got, err := AppendAck(prefix, ack); err != nil || string(got) != string(prefix)+want {
+		t.Errorf("AppendAck: %s, %v", got, err)
+	}
+}
+
+func TestAppendUplinkErrorKeepsDst(t *testing.T) {
+	dst := []byte("keep")
+	frame := &UplinkFrame{Method: MethodPing, Auth: "", Serial: "dev"}
+	got, err := AppendUplink(dst, frame)
+	if err == nil || string(got) != "keep" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	long := &AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "raw", Text: strings.Repeat("x", MaxFrameSize)}}
+	got, err = AppendAck(dst, long)
+	var tooLarge *FrameSizeError
+	if !errors.As(err, &tooLarge) || string(got) != "keep" {
+		t.Errorf("got %d bytes, %v", len(got), err)
+	}
+}
+
+// =========================================================================
+// Typed ACK variables detail
+// =========================================================================
+
+func TestBuildAckVariablesDetailEscapes(t *testing.T) {
+	frame := &AckFrame{
+		Seq:    u32Ptr(4),
+		Status: AckStatusOk,
+		Detail: &AckDetail{Type: "variables", Vars: &VariablesDetail{Variables: []Variable{
+			{Name: "msg", Operator: OperatorString, Value: Value{Type: OperatorString, Str: "a;b]c|d"}},
+			{Name: "temp", Operator: OperatorNumber, Value: Value{Type: OperatorNumber, Str: "21.5"}, Unit: strPtr("#C")},
+			{Name: "mode", Operator: OperatorString, Value: Value{Type: OperatorString, Str: "eco"},
+				Meta: []MetaPair{{Key: "note", Value: "x,y}\\z"}}},
+		}}},
+	}
+	raw, err := BuildAck(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `ACK|!4|OK|[msg=a\;b\]c\|d;temp:=21.5#\#C;mode=eco{note=x\,y\}\\z}]`
+	if raw != want {
+		t.Fatalf("got  %s\nwant %s", raw, want)
+	}
+
+	parsed, err := ParseAck(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Detail.Text != strings.TrimPrefix(want, "ACK|!4|OK|") {
+		t.Errorf("text: %q", parsed.Detail.Text)
+	}
+	if path := diffExported(reflect.ValueOf(frame.Detail.Vars), reflect.ValueOf(parsed.Detail.Vars), "vars"); path != "" {
+		t.Errorf("mismatch at %s: %+v", path, parsed.Detail.Vars)
+	}
+	if rebuilt, err := BuildAck(parsed); err != nil || rebuilt != raw {
+		t.Errorf("rebuild: %q, %v", rebuilt, err)
+	}
+}
+
+func TestBuildAckInnerVariablesDetail(t *testing.T) {
+	frame := &AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "variables", Vars: &VariablesDetail{Variables: []Variable{
+		{Name: "s", Operator: OperatorString, Value: Value{Type: OperatorString, Str: "]|;"}},
+	}}}}
+	raw, err := BuildAckInner(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `OK|[s=\]\|\;]`; raw != want {
+		t.Fatalf("got %s, want %s", raw, want)
+	}
+	parsed, err := ParseAckInner(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed.Detail.Vars.Variables[0].Value.Str; got != "]|;" {
+		t.Errorf("value: %q", got)
+	}
+}
+
+func TestBuildAckVariablesDetailSizeLimit(t *testing.T) {
+	detail := func(n int) *AckFrame {
+		v := Variable{Name: "s", Operator: OperatorString, Value: Value{Type: OperatorString, Str: strings.Repeat(";", n)}}
+		return &AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "variables", Vars: &VariablesDetail{Variables: []Variable{v}}}}
+	}
+	// "[s=" + escaped value + "]": the limit counts escaped bytes.
+	fits := (MaxAckDetailSize - 4) / 2
+	if _, err := BuildAck(detail(fits)); err != nil {
+		t.Errorf("detail at the limit rejected: %v", err)
+	}
+	if _, err := BuildAck(detail(fits + 1)); err == nil {
+		t.Error("oversized detail accepted")
+	}
+	if _, err := BuildAckInner(detail(fits + 1)); err == nil {
+		t.Error("oversized inner detail accepted")
+	}
+}
+
+func TestParseAckVariablesDetailUntyped(t *testing.T) {
+	for _, detail := range []string{
+		"[]",
+		"[a]",
+		"[a=1]x",
+		"[a=1",
+		"[" + strings.Repeat("a=1;", MaxAckDetailSize/4) + "]",
+	} {
+		frame, err := ParseAck("ACK|OK|" + detail)
+		if err != nil {
+			t.Fatalf("%q: %v", detail, err)
+		}
+		if frame.Detail.Type != "variables" || frame.Detail.Text != detail || frame.Detail.Vars != nil {
+			t.Errorf("%q: got %+v", detail, frame.Detail)
+		}
+		if raw, _ := BuildAck(frame); raw != "ACK|OK|"+detail {
+			t.Errorf("%q: rebuilt as %q", detail, raw)
+		}
+	}
+}
+
+func TestAckFrameVariables(t *testing.T) {
+	frame, err := ParseAck("ACK|!7|OK|[temp:=21.5#C;msg=a\\;b]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars, err := frame.Variables()
+	if err != nil || len(vars) != 2 || vars[0].Name != "temp" || vars[1].Value.Str != "a;b" {
+		t.Fatalf("got %+v, %v", vars, err)
+	}
+	if !reflect.DeepEqual(frame.Detail.Variables, vars) {
+		t.Errorf("Detail.Variables = %+v", frame.Detail.Variables)
+	}
+
+	// Without Vars or Text, BuildAck writes Detail.Variables.
+	built, err := BuildAck(&AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "variables", Variables: vars}})
+	if err != nil || built != "ACK|OK|[temp:=21.5#C;msg=a\\;b]" {
+		t.Errorf("built %q, %v", built, err)
+	}
+
+	frame, _ = ParseAck("ACK|OK|3")
+	if vars, err := frame.Variables(); vars != nil || err != nil {
+		t.Errorf("count detail: %+v, %v", vars, err)
+	}
+}
+
+func TestAckFrameVariablesErrorPosition(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		kind ParseErrorKind
+		pos  int
+	}{
+		{"ACK|OK|[]", ErrInvalidVarBlock, 7},
+		{"ACK|OK|[a=1", ErrInvalidVarBlock, 7},
+		{"ACK|OK|[a=1]x", ErrInvalidVarBlock, 12},
+		{"ACK|OK|[a]", ErrInvalidVariable, 8},
+		{"ACK|!42|OK|[a=1;t:=x]", ErrInvalidVariable, 19},
+	} {
+		frame, err := ParseAck(tc.raw)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.raw, err)
+		}
+		_, err = frame.Variables()
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Kind != tc.kind || pe.Position != tc.pos {
+			t.Errorf("%s: got %v, want %s at %d", tc.raw, err, tc.kind, tc.pos)
+		}
+
+		// Under RejectMalformedAckVariables, ParseAck fails the same way.
+		_, err = ParseAckWithOptions(tc.raw, &ParserOptions{RejectMalformedAckVariables: true})
+		if !errors.As(err, &pe) || pe.Kind != tc.kind || pe.Position != tc.pos {
+			t.Errorf("%s: strict: got %v, want %s at %d", tc.raw, err, tc.kind, tc.pos)
+		}
+	}
+
+	// Positions follow where the detail was found, not where a canonical
+	// frame would put it.
+	frame, err := ParseAckInner("OK|[a]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = frame.Variables()
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Position != 4 {
+		t.Errorf("inner frame: %v", err)
+	}
+}
+
+func TestRejectMalformedAckVariablesAcceptsOthers(t *testing.T) {
+	opts := &ParserOptions{RejectMalformedAckVariables: true}
+	for _, raw := range []string{
+		"ACK|OK|[a=1]",
+		"ACK|OK|3",
+		"ACK|OK|done",
+		"ACK|PONG|[x",
+		"ACK|OK|[" + strings.Repeat("a=1;", MaxAckDetailSize/4) + "]",
+	} {
+		if _, err := ParseAckWithOptions(raw, opts); err != nil {
+			t.Errorf("%.40s: %v", raw, err)
+		}
+	}
+}
+
+// =========================================================================
+// Typed PONG detail
+// =========================================================================
+
+func TestNewAckPongWithFields(t *testing.T) {
+	cases := []struct {
+		opts PongDetail
+		want string
+	}{
+		{PongDetail{}, "ACK|!3|PONG"},
+		{PongDetail{Region: "us-east"}, "ACK|!3|PONG|region=us-east"},
+		{PongDetail{Region: "eu,west"}, `ACK|!3|PONG|region=eu\,west`},
+		{PongDetail{QueueDepth: u32Ptr(0)}, "ACK|!3|PONG|queue=0"},
+		{PongDetail{NextSeq: u32Ptr(43)}, "ACK|!3|PONG|next_seq=43"},
+		{PongDetail{Region: "sa", QueueDepth: u32Ptr(12), NextSeq: u32Ptr(4294967295)},
+			"ACK|!3|PONG|region=sa,queue=12,next_seq=4294967295"},
+	}
+	for _, tc := range cases {
+		frame, err := NewAckPongWith(u32Ptr(3), tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := BuildAck(frame)
+		if err != nil || raw != tc.want {
+			t.Errorf("%+v: got %q, %v; want %q", tc.opts, raw, err, tc.want)
+			continue
+		}
+		parsed, err := ParseAck(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path := diffExported(reflect.ValueOf(frame), reflect.ValueOf(parsed), "frame"); path != "" {
+			t.Errorf("%s: mismatch at %s", raw, path)
+		}
+	}
+}
+
+func TestPongDetailUnknownKeysSurvive(t *testing.T) {
+	raw := `ACK|PONG|queue=2,zone=b\,1,next_seq=9,region=ap`
+	frame, err := ParseAck(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pong := frame.Detail.Pong
+	if pong == nil || pong.Region != "ap" || *pong.QueueDepth != 2 || *pong.NextSeq != 9 {
+		t.Fatalf("defined fields: %+v", pong)
+	}
+	if want := []MetaPair{{Key: "zone", Value: "b,1"}}; !reflect.DeepEqual(pong.Extra, want) {
+		t.Errorf("extra: %+v, want %+v", pong.Extra, want)
+	}
+
+	rebuilt, err := BuildAck(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `ACK|PONG|region=ap,queue=2,next_seq=9,zone=b\,1`; rebuilt != want {
+		t.Errorf("rebuilt %q, want %q", rebuilt, want)
+	}
+	again, err := ParseAck(rebuilt)
+	if err != nil || !reflect.DeepEqual(again.Detail.Pong, pong) {
+		t.Errorf("second round trip: %+v, %v", again.Detail.Pong, err)
+	}
+}
+
+func TestPongDetailOpaqueText(t *testing.T) {
+	// Older servers and firmware treat the PONG detail as text; it stays
+	// available as such whether or not it parses as hints.
+	for _, tc := range []struct {
+		detail string
+		typed  bool
+	}{
+		{"region=eu,queue=1", true},
+		{"1694567890000", false},
+		{"queue=many", false},
+		{"queue=1,queue=2", false},
+		{"Region=eu", false},
+		{"region=", false},
+	} {
+		frame, err := ParseAck("ACK|PONG|" + tc.detail)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.detail, err)
+		}
+		if frame.Detail.Type != "raw" || frame.Detail.Text != tc.detail {
+			t.Errorf("%q: detail %+v", tc.detail, frame.Detail)
+		}
+		if (frame.Detail.Pong != nil) != tc.typed {
+			t.Errorf("%q: typed = %v, want %v", tc.detail, frame.Detail.Pong != nil, tc.typed)
+		}
+		if raw, _ := BuildAck(frame); raw != "ACK|PONG|"+tc.detail && !tc.typed {
+			t.Errorf("%q: rebuilt as %q", tc.detail, raw)
+		}
+	}
+}
+
+func TestNewAckPongWithRejects(t *testing.T) {
+	for _, extra := range [][]MetaPair{
+		{{Key: "Zone", Value: "a"}},
+		{{Key: "", Value: "a"}},
+		{{Key: "queue", Value: "3"}},
+	} {
+		if _, err := NewAckPongWith(nil, PongDetail{Extra: extra}); err == nil {
+			t.Errorf("%+v: expected error", extra)
+		}
+	}
+	big := PongDetail{Extra: []MetaPair{{Key: "k", Value: strings.Repeat("x", MaxAckDetailSize)}}}
+	frame, err := NewAckPongWith(nil, big)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BuildAck(frame); err == nil {
+		t.Error("oversized PONG detail accepted")
+	}
+}
+
+// =========================================================================
+// Binary CMD detail
+// =========================================================================
+
+func TestCommandDetailBinaryRoundTrip(t *testing.T) {
+	payload := []byte{0x00, 0x01, 0xfe, 0xff, '|', ';'}
+	cases := []struct {
+		enc  PassthroughEncoding
+		want string
+	}{
+		{PassthroughEncodingHex, "ACK|!7|CMD|set_key >x0001feff7c3b"},
+		{PassthroughEncodingBase64, "ACK|!7|CMD|set_key >bAAH+/3w7"},
+	}
+	for _, tc := range cases {
+		frame := &AckFrame{Seq: u32Ptr(7), Status: AckStatusCmd, Detail: &AckDetail{Type: "command",
+			Cmd: &CommandDetail{Name: "set_key", Encoding: tc.enc, Binary: payload}}}
+		raw, err := BuildAck(frame)
+		if err != nil || raw != tc.want {
+			t.Errorf("build: got %q, %v; want %q", raw, err, tc.want)
+			continue
+		}
+		parsed, err := ParseAck(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := parsed.Detail.Cmd
+		if cmd == nil || cmd.Name != "set_key" || cmd.Encoding != tc.enc || !reflect.DeepEqual(cmd.Binary, payload) {
+			t.Errorf("%s: parsed %+v", raw, cmd)
+		}
+		if parsed.Detail.Text != strings.TrimPrefix(raw, "ACK|!7|CMD|") {
+			t.Errorf("%s: text %q", raw, parsed.Detail.Text)
+		}
+	}
+}
+
+func TestCommandDetailParsesHexAndBase64(t *testing.T) {
+	for _, detail := range []string{"fw >bAQI=", "fw >x0102", "fw >x0A0b"} {
+		frame, err := ParseAckInner("CMD|" + detail)
+		if err != nil {
+			t.Fatalf("%q: %v", detail, err)
+		}
+		if frame.Detail.Cmd == nil || len(frame.Detail.Cmd.Binary) != 2 {
+			t.Errorf("%q: %+v", detail, frame.Detail.Cmd)
+		}
+	}
+}
+
+func TestCommandDetailSizeLimit(t *testing.T) {
+	cmd := func(n int) *AckFrame {
+		return &AckFrame{Status: AckStatusCmd, Detail: &AckDetail{Type: "command",
+			Cmd: &CommandDetail{Name: "fw", Encoding: PassthroughEncodingBase64, Binary: make([]byte, n)}}}
+	}
+	raw, err := BuildAck(cmd(MaxCommandBinary))
+	if err != nil {
+		t.Fatalf("payload at the limit rejected: %v", err)
+	}
+	if _, err := ParseAck(raw); err != nil {
+		t.Errorf("payload at the limit not parsed: %v", err)
+	}
+	if _, err := BuildAck(cmd(MaxCommandBinary + 1)); err == nil {
+		t.Error("oversized payload built")
+	}
+	_, err = ParseAck("ACK|CMD|fw >x" + strings.Repeat("00", MaxCommandBinary+1))
+	assertParseError(t, err, ErrInvalidPassthru)
+	if want := strconv.Itoa(MaxCommandBinary) + " bytes"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q does not name the limit", err)
+	}
+}
+
+func TestCommandDetailRejectsMalformedPayload(t *testing.T) {
+	prefix := "ACK|!2|CMD|"
+	for _, tc := range []struct {
+		detail string
+		off    int // offset of the error into the payload
+	}{
+		{"fw >x", 0},
+		{"fw >x0", 0},
+		{"fw >xzz", 0},
+		{"fw >b", 0},
+		{"fw >b====", 0},
+		{"fw >bA*B=", 1},
+		{"fw >bAQI", 3},
+		{"fw >bAQ=A", 2},
+	} {
+		detail := tc.detail
+		_, err := ParseAck(prefix + detail)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Kind != ErrInvalidPassthru {
+			t.Errorf("%q: expected ErrInvalidPassthru, got %v", detail, err)
+			continue
+		}
+		if want := len(prefix) + len("fw >x") + tc.off; pe.Position != want {
+			t.Errorf("%q: position %d, want %d", detail, pe.Position, want)
+		}
+	}
+	for _, c := range []*CommandDetail{
+		{Name: "", Binary: []byte{1}},
+		{Name: "two words", Binary: []byte{1}},
+		{Name: "a|b", Binary: []byte{1}},
+		{Name: "fw"},
+	} {
+		frame := &AckFrame{Status: AckStatusCmd, Detail: &AckDetail{Type: "command", Cmd: c}}
+		if raw, err := BuildAck(frame); err == nil {
+			t.Errorf("%+v: built %q", c, raw)
+		}
+	}
+}
+
+func TestCommandDetailPlainTextUnaffected(t *testing.T) {
+	for _, detail := range []string{"reboot", "set interval 30", "say >hello", "echo  >x00", " >x00", "log >y00"} {
+		input := "ACK|CMD|" + detail
+		frame, err := ParseAck(input)
+		if err != nil {
+			t.Fatalf("%q: %v", detail, err)
+		}
+		if frame.Detail.Type != "command" || frame.Detail.Text != detail || frame.Detail.Cmd != nil {
+			t.Errorf("%q: %+v", detail, frame.Detail)
+		}
+		if raw, err := BuildAck(frame); err != nil || raw != input {
+			t.Errorf("%q: rebuilt as %q, %v", detail, raw, err)
+		}
+	}
+}
+
+// =========================================================================
+// Text CMD detail
+// =========================================================================
+
+func TestCommandRoundTrip(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"reboot", nil, "ACK|!3|CMD|reboot"},
+		{"set_interval", []string{"60"}, "ACK|!3|CMD|set_interval 60"},
+		{"ota", []string{"https://fw.example.com/v2.bin"}, "ACK|!3|CMD|ota https://fw.example.com/v2.bin"},
+		{"display", []string{"a b|c", "x>y", `back\slash`}, `ACK|!3|CMD|display a\ b\|c x>y back\\slash`},
+		{"notify", []string{"line1\nline2;end"}, `ACK|!3|CMD|notify line1\nline2\;end`},
+	}
+	for _, tc := range cases {
+		frame, err := NewAckCmd(u32Ptr(3), tc.name, tc.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		raw, err := BuildAck(frame)
+		if err != nil || raw != tc.want {
+			t.Errorf("%s: built %q, %v", tc.name, raw, err)
+			continue
+		}
+		parsed, err := ParseAck(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+		cmd, err := ParseCommand(parsed.Detail)
+		if err != nil || cmd.Name != tc.name || !slices.Equal(cmd.Args, tc.args) {
+			t.Errorf("%s: parsed %+v, %v", raw, cmd, err)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	cmd, err := ParseCommand(&AckDetail{Type: "command", Text: "  set_interval   60  "})
+	if err != nil || cmd.Name != "set_interval" || !slices.Equal(cmd.Args, []string{"60"}) {
+		t.Errorf("extra spaces: %+v, %v", cmd, err)
+	}
+
+	// The payload of a binary command is left encoded.
+	ack, err := ParseAck("ACK|CMD|fw_chunk >bAAECAw==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd, err := ParseCommand(ack.Detail); err != nil || cmd.Name != "fw_chunk" || cmd.Args[0] != ">bAAECAw==" {
+		t.Errorf("binary command: %+v, %v", cmd, err)
+	}
+
+	for _, d := range []*AckDetail{
+		nil,
+		{Type: "raw", Text: "reboot"},
+		{Type: "command", Text: ""},
+		{Type: "command", Text: "   "},
+		{Type: "command", Text: "set:interval 60"},
+		{Type: "command", Text: `re\ boot`},
+	} {
+		if cmd, err := ParseCommand(d); err == nil {
+			t.Errorf("%+v: got %+v", d, cmd)
+		}
+	}
+}
+
+func TestNewAckCmdRejects(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{"", nil},
+		{"set interval", nil},
+		{"ota|x", nil},
+		{"ota", []string{"url", ""}},
+		{"ota", []string{">xff"}},
+	}
+	for _, tc := range cases {
+		if frame, err := NewAckCmd(nil, tc.name, tc.args...); err == nil {
+			t.Errorf("%q %q: got %+v", tc.name, tc.args, frame)
+		}
+	}
+}
+
+// =========================================================================
+// Extended ERR detail
+// =========================================================================
+
+func TestNewAckErrRateLimited(t *testing.T) {
+	raw, err := BuildAck(NewAckErrRateLimited(u32Ptr(4), 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ACK|!4|ERR|rate_limited|30"; raw != want {
+		t.Fatalf("got %q, want %q", raw, want)
+	}
+	for _, parse := range []func() (*AckFrame, error){
+		func() (*AckFrame, error) { return ParseAck(raw) },
+		func() (*AckFrame, error) { return ParseAckInner("ERR|rate_limited|30") },
+	} {
+		frame, err := parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := frame.Detail
+		if d.ErrorCode != ErrorCodeRateLimited || d.Text != "rate_limited" || d.Err == nil ||
+			d.Err.RetryAfter == nil || *d.Err.RetryAfter != 30 || d.Err.Extra != "" {
+			t.Errorf("parsed %+v, %+v", d, d.Err)
+		}
+	}
+}
+
+func TestAckErrRetryAfterWithExtra(t *testing.T) {
+	frame := NewAckErrRateLimited(nil, 30)
+	frame.Detail.Err.Extra = "x"
+	if raw, err := BuildAck(frame); err == nil {
+		t.Errorf("built %q", raw)
+	}
+
+	frame.Detail.Err = &ErrorDetail{Extra: "30|x"}
+	raw, err := BuildAck(frame)
+	if err != nil || raw != "ACK|ERR|rate_limited|30|x" {
+		t.Fatalf("got %q, %v", raw, err)
+	}
+	parsed, err := ParseAck(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.Detail.Err, frame.Detail.Err) {
+		t.Errorf("parsed %+v, want %+v", parsed.Detail.Err, frame.Detail.Err)
+	}
+}
+
+func TestAckErrTwoFieldForm(t *testing.T) {
+	frame, err := ParseAck("ACK|!4|ERR|rate_limited")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Detail.ErrorCode != ErrorCodeRateLimited || frame.Detail.Err != nil {
+		t.Errorf("parsed %+v", frame.Detail)
+	}
+	if raw, _ := BuildAck(frame); raw != "ACK|!4|ERR|rate_limited" {
+		t.Errorf("rebuilt as %q", raw)
+	}
+}
+
+func TestAckErrExtraFieldsPreserved(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		code  ErrorCode
+		extra string
+	}{
+		{"ACK|ERR|rate_limited|soon", ErrorCodeRateLimited, "soon"},
+		{"ACK|ERR|rate_limited|30|x", ErrorCodeRateLimited, "30|x"},
+		{"ACK|ERR|server_error|db|retry", ErrorCodeServerError, "db|retry"},
+		{"ACK|!1|ERR|invalid_seq|expected=5", ErrorCodeInvalidSeq, "expected=5"},
+		{"ACK|ERR|invalid_seq|a\\|b", ErrorCodeInvalidSeq, "a\\|b"},
+	} {
+		frame, err := ParseAck(tc.input)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.input, err)
+		}
+		d := frame.Detail
+		if d.ErrorCode != tc.code || d.Err == nil || d.Err.RetryAfter != nil || d.Err.Extra != tc.extra {
+			t.Errorf("%q: parsed %+v, %+v", tc.input, d, d.Err)
+			continue
+		}
+		if raw, err := BuildAck(frame); err != nil || raw != tc.input {
+			t.Errorf("%q: rebuilt as %q, %v", tc.input, raw, err)
+		}
+	}
+}
+
+func TestAckExtraFieldsRejectedOnOtherStatuses(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		pos   int
+	}{
+		{"ACK|OK|3|x", 9},
+		{"ACK|!7|OK|3|x|y", 12},
+		{"ACK|OK|[a:=1]|", 14},
+		{"ACK|PONG|hi|x", 12},
+		{"ACK|CMD|reboot|x", 15},
+		{"ACK|CMD|a\\|b|c", 13},
+	} {
+		_, err := ParseAck(tc.input)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Kind != ErrInvalidAck || pe.Position != tc.pos {
+			t.Errorf("%q: got %v, want invalid_ack at %d", tc.input, err, tc.pos)
+		}
+	}
+	_, err := ParseAckInner("OK|3|x")
+	assertParseError(t, err, ErrInvalidAck)
+
+	// The documented shapes, with and without a seq, still parse.
+	for _, input := range []string{
+		"ACK|OK", "ACK|OK|3", "ACK|!7|OK", "ACK|!7|OK|[a:=1]",
+		"ACK|PONG", "ACK|PONG|hi", "ACK|!7|PONG|hi",
+		"ACK|CMD|reboot", "ACK|!7|CMD|reboot",
+		"ACK|ERR|auth_failed", "ACK|!7|ERR|rate_limited|30", "ACK|ERR|invalid_payload|x|y",
+	} {
+		if _, err := ParseAck(input); err != nil {
+			t.Errorf("%q: %v", input, err)
+		}
+	}
+}
+
 // =========================================================================
 // Build from constructed frames
 // =========================================================================
@@ -0,0 +1,90 @@
+package tagotip
+
+import (
+	"strings"
+	"unsafe"
+)
+
+// ParseUplinkBytes parses a raw uplink frame held in a byte slice, such as
+// a network read buffer, like ParseUplink(string(input)) but without
+// copying frames that fail to parse. The returned frame does not reference
+// input: the span of input its fields point into is copied once, so the
+// caller may reuse the buffer as soon as the call returns.
+func ParseUplinkBytes(input []byte) (*UplinkFrame, error) {
+	view := unsafe.String(unsafe.SliceData(input), len(input))
+	frame := &UplinkFrame{}
+	if err := ParseUplinkInto(frame, view); err != nil {
+		return nil, err
+	}
+	frame.eachString(ownStrings(view, frame.eachString))
+	return frame, nil
+}
+
+// ParseAckBytes is the ParseAck counterpart of ParseUplinkBytes.
+func ParseAckBytes(input []byte) (*AckFrame, error) {
+	view := unsafe.String(unsafe.SliceData(input), len(input))
+	frame := &AckFrame{}
+	if err := ParseAckInto(frame, view); err != nil {
+		return nil, err
+	}
+	frame.eachString(ownStrings(view, frame.eachString))
+	return frame, nil
+}
+
+// ownStrings copies the span of view referenced by the strings that each
+// visits and returns a function that moves a string of view into the copy.
+// Strings outside view are left alone.
+func ownStrings(view string, each func(func(*string))) func(*string) {
+	base := uintptr(unsafe.Pointer(unsafe.StringData(view)))
+	offset := func(s string) (int, bool) {
+		if len(s) == 0 || len(view) == 0 {
+			return 0, false
+		}
+		p := uintptr(unsafe.Pointer(unsafe.StringData(s)))
+		if p < base || p+uintptr(len(s)) > base+uintptr(len(view)) {
+			return 0, false
+		}
+		return int(p - base), true
+	}
+
+	lo, hi := len(view), 0
+	each(func(s *string) {
+		if off, ok := offset(*s); ok {
+			lo, hi = min(lo, off), max(hi, off+len(*s))
+		}
+	})
+	if lo >= hi {
+		return func(*string) {}
+	}
+	owned := strings.Clone(view[lo:hi])
+	return func(s *string) {
+		if off, ok := offset(*s); ok {
+			*s = owned[off-lo : off-lo+len(*s)]
+		}
+	}
+}
+
+// eachString calls fn with a pointer to every string field of f.
+func (f *AckFrame) eachString(fn func(*string)) {
+	d := f.Detail
+	if d == nil {
+		return
+	}
+	fn(&d.Type)
+	fn(&d.Text)
+	if d.Vars != nil {
+		for i := range d.Vars.Variables {
+			d.Vars.Variables[i].eachString(fn)
+		}
+	}
+	if d.Pong != nil {
+		fn(&d.Pong.Region)
+		eachMetaString(d.Pong.Extra, fn)
+	}
+	if d.Cmd != nil {
+		fn(&d.Cmd.Name)
+	}
+	if d.Err != nil {
+		fn(&d.Err.Extra)
+	}
+}
@@ -0,0 +1,79 @@
+package tagotip
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+// ============================================================================
+// Byte-slice parsing
+// ============================================================================
+
+func TestParseUplinkBytesMatchesParseUplink(t *testing.T) {
+	for _, raw := range loadCorpus(t) {
+		want, wantErr := ParseUplink(raw)
+		buf := []byte(raw)
+		got, err := ParseUplinkBytes(buf)
+		if (err == nil) != (wantErr == nil) || (err != nil && err.Error() != wantErr.Error()) {
+			t.Errorf("%s: error %v, want %v", raw, err, wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		for i := range buf {
+			buf[i] = 0
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: frame changed with the buffer:\n  got  %+v\n  want %+v", raw, got, want)
+		}
+		for _, s := range frameStrings(reflect.ValueOf(got), nil) {
+			if aliases(s, unsafeView(buf)) {
+				t.Errorf("%s: %q references the input buffer", raw, s)
+			}
+		}
+	}
+}
+
+func TestParseAckBytes(t *testing.T) {
+	for _, raw := range []string{
+		"ACK|!10|OK|5",
+		"ACK|OK|[temp:=21.5#C{src=dht22}]",
+		"ACK|CMD|reboot >xDEADBEEF",
+		"ACK|PONG|region=us-e1,queue=3",
+		"ACK|ERR|rate_limited|30",
+		"ACK|ERR|server_error|db down",
+		"ACK|BAD",
+	} {
+		want, wantErr := ParseAck(raw)
+		buf := []byte(raw)
+		got, err := ParseAckBytes(buf)
+		if (err == nil) != (wantErr == nil) {
+			t.Errorf("%s: error %v, want %v", raw, err, wantErr)
+			continue
+		}
+		for i := range buf {
+			buf[i] = 'x'
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s:\n  got  %+v\n  want %+v", raw, got, want)
+		}
+	}
+}
+
+func TestParseUplinkBytesRejectedFrameAllocations(t *testing.T) {
+	bad := []byte("PUSH|" + testAuth + "|dev|[temp:=oops]")
+	viaString := testing.AllocsPerRun(100, func() { ParseUplink(string(bad)) })
+	direct := testing.AllocsPerRun(100, func() { ParseUplinkBytes(bad) })
+	if direct >= viaString {
+		t.Errorf("ParseUplinkBytes: %v allocations, ParseUplink(string(buf)): %v", direct, viaString)
+	}
+}
+
+func unsafeView(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
@@ -0,0 +1,156 @@
+package tagotip
+
+import (
+	"fmt"
+	"sort"
+)
+
+// PackageCapabilities describes what this package supports, for example to
+// serve from a bridge's capabilities endpoint. It marshals to JSON with
+// cipher suites, error codes, and methods as their names.
+type PackageCapabilities struct {
+	ProtocolVersion           int           `json:"protocol_version"`
+	SupportedCipherSuites     []CipherSuite `json:"cipher_suites"`
+	SupportedEnvelopeVersions []int         `json:"envelope_versions"`
+	ErrorCodes                []ErrorCode   `json:"error_codes"`
+	Methods                   []Method      `json:"methods"`
+	Limits                    Limits        `json:"limits"`
+}
+
+// Limits holds the size and count limits enforced by default.
+type Limits struct {
+	MaxFrameSize     int `json:"max_frame_size"`
+	MaxVariables     int `json:"max_variables"`
+	MaxMetaPairs     int `json:"max_meta_pairs"`
+	MaxTotalMeta     int `json:"max_total_meta"`
+	MaxTotalItems    int `json:"max_total_items"`
+	MaxVarNameLen    int `json:"max_var_name_len"`
+	MaxSerialLen     int `json:"max_serial_len"`
+	MaxGroupLen      int `json:"max_group_len"`
+	MaxMetaKeyLen    int `json:"max_meta_key_len"`
+	MaxUnitLen       int `json:"max_unit_len"`
+	MaxAckDetailSize int `json:"max_ack_detail_size"`
+	MaxCommandBinary int `json:"max_command_binary"`
+}
+
+// Capabilities returns the capabilities of this package, read from the
+// tables the parser, builder, and envelope functions use.
+func Capabilities() PackageCapabilities {
+	c := PackageCapabilities{
+		ProtocolVersion:           ProtocolVersion,
+		SupportedEnvelopeVersions: append([]int(nil), envelopeVersions...),
+		Limits: Limits{
+			MaxFrameSize:     MaxFrameSize,
+			MaxVariables:     MaxVariables,
+			MaxMetaPairs:     MaxMetaPairs,
+			MaxTotalMeta:     MaxTotalMeta,
+			MaxTotalItems:    DefaultMaxTotalItems,
+			MaxVarNameLen:    MaxVarNameLen,
+			MaxSerialLen:     MaxSerialLen,
+			MaxGroupLen:      MaxGroupLen,
+			MaxMetaKeyLen:    MaxMetaKeyLen,
+			MaxUnitLen:       MaxUnitLen,
+			MaxAckDetailSize: MaxAckDetailSize,
+			MaxCommandBinary: MaxCommandBinary,
+		},
+	}
+	for suite := range cipherSuites {
+		c.SupportedCipherSuites = append(c.SupportedCipherSuites, suite)
+	}
+	sort.Slice(c.SupportedCipherSuites, func(i, j int) bool {
+		return c.SupportedCipherSuites[i] < c.SupportedCipherSuites[j]
+	})
+	for code := ErrorCode(0); code < ErrorCodeUnknown; code++ {
+		c.ErrorCodes = append(c.ErrorCodes, code)
+	}
+	for m := MethodPush; methodKeyword(m) != ""; m++ {
+		c.Methods = append(c.Methods, m)
+	}
+	return c
+}
+
+// String returns the method keyword, such as "PUSH".
+func (m Method) String() string {
+	if kw := methodKeyword(m); kw != "" {
+		return kw
+	}
+	return fmt.Sprintf("Method(%d)", int(m))
+}
+
+// MarshalText returns the method keyword. It fails for MethodUnknown.
+func (m Method) MarshalText() ([]byte, error) {
+	kw := methodKeyword(m)
+	if kw == "" {
+		return nil, fmt.Errorf("tagotip: method %d has no keyword", int(m))
+	}
+	return []byte(kw), nil
+}
+
+// MethodFromString returns the method with keyword s, such as "PUSH". It
+// reports false for anything else.
+func MethodFromString(s string) (Method, bool) {
+	m, err := parseMethod(s)
+	return m, err == nil
+}
+
+// String returns the operator name, such as "number".
+func (op Operator) String() string {
+	if name := operatorName(op); name != "" {
+		return name
+	}
+	return fmt.Sprintf("Operator(%d)", int(op))
+}
+
+// String returns the status keyword, such as "OK".
+func (s AckStatus) String() string {
+	if kw := ackStatusKeyword(s); kw != "" {
+		return kw
+	}
+	return fmt.Sprintf("AckStatus(%d)", int(s))
+}
+
+// String returns "hex" or "base64".
+func (e PassthroughEncoding) String() string {
+	switch e {
+	case PassthroughEncodingHex:
+		return "hex"
+	case PassthroughEncodingBase64:
+		return "base64"
+	}
+	return fmt.Sprintf("PassthroughEncoding(%d)", int(e))
+}
+
+// ParseErrorCode returns the error code written as s in ERR ACKs, such as
+// "rate_limited", or ErrorCodeUnknown.
+func ParseErrorCode(s string) ErrorCode {
+	return parseErrorCodeStr(s)
+}
+
+// String returns the error code as written in ERR ACKs, such as
+// "rate_limited".
+func (c ErrorCode) String() string {
+	return errorCodeName(c)
+}
+
+// MarshalText returns the error code as written in ERR ACKs.
+func (c ErrorCode) MarshalText() ([]byte, error) {
+	return []byte(errorCodeName(c)), nil
+}
+
+// String returns the cipher suite name, such as "aes-128-ccm".
+func (s CipherSuite) String() string {
+	if info, ok := cipherSuites[s]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("CipherSuite(%d)", int(s))
+}
+
+// MarshalText returns the cipher suite name. It fails for suites this
+// package does not implement.
+func (s CipherSuite) MarshalText() ([]byte, error) {
+	info, ok := cipherSuites[s]
+	if !ok {
+		return nil, fmt.Errorf("tagotip: unsupported cipher suite %d", int(s))
+	}
+	return []byte(info.name), nil
+}
@@ -0,0 +1,154 @@
+package tagotip
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Capabilities
+// ============================================================================
+
+func isSecureErrorMsg(err error, msg string) bool {
+	se, ok := err.(*SecureError)
+	return ok && strings.Contains(se.Message, msg)
+}
+
+func TestCapabilitiesCipherSuitesMatchEnvelopes(t *testing.T) {
+	caps := Capabilities()
+	if len(caps.SupportedCipherSuites) == 0 {
+		t.Fatal("no cipher suites reported")
+	}
+	inner := []byte("dev|[a:=1]")
+	// The flags byte has three bits for the suite id.
+	for id := 0; id < 8; id++ {
+		suite := CipherSuite(id)
+		reported := slices.Contains(caps.SupportedCipherSuites, suite)
+
+		key := specKey
+		if info, ok := cipherSuites[suite]; ok {
+			key = make([]byte, info.keySize)
+		}
+		env, err := SealUplink(EnvelopeMethodPush, inner, 1, specAuthHash, specDeviceHash, key, suite)
+		if reported != (err == nil) {
+			t.Errorf("suite %d: reported %v, SealUplink error %v", id, reported, err)
+			continue
+		}
+		if reported {
+			if _, _, got, err := OpenEnvelope(env, key); err != nil || string(got) != string(inner) {
+				t.Errorf("suite %d: OpenEnvelope = %q, %v", id, got, err)
+			}
+			continue
+		}
+
+		forged := slices.Clone(specEnvelope)
+		forged[0] = byte(id<<flagsCipherShift) | byte(EnvelopeMethodPush)
+		if _, _, _, err := OpenEnvelope(forged, specKey); !isSecureErrorMsg(err, "unsupported cipher suite") {
+			t.Errorf("suite %d: not reported, but OpenEnvelope error is %v", id, err)
+		}
+	}
+}
+
+func TestCapabilitiesEnvelopeVersionsMatchOpen(t *testing.T) {
+	caps := Capabilities()
+	for version := 0; version < 4; version++ {
+		forged := slices.Clone(specEnvelope)
+		forged[0] = specEnvelope[0]&^flagsVersionMask | byte(version<<flagsVersionShift)
+		_, _, _, err := OpenEnvelope(forged, specKey)
+		rejected := isSecureErrorMsg(err, "unsupported version")
+		if slices.Contains(caps.SupportedEnvelopeVersions, version) == rejected {
+			t.Errorf("version %d: reported %v, OpenEnvelope error %v", version, !rejected, err)
+		}
+	}
+}
+
+func TestCapabilitiesErrorCodesAndMethods(t *testing.T) {
+	caps := Capabilities()
+	if len(caps.ErrorCodes) != int(ErrorCodeUnknown) {
+		t.Errorf("%d error codes, want %d", len(caps.ErrorCodes), ErrorCodeUnknown)
+	}
+	for _, code := range caps.ErrorCodes {
+		name := code.String()
+		if name == "unknown" || parseErrorCodeStr(name) != code {
+			t.Errorf("error code %d has wire name %q", int(code), name)
+		}
+	}
+	want := []Method{MethodPush, MethodPull, MethodPing}
+	if !slices.Equal(caps.Methods, want) {
+		t.Errorf("methods %v, want %v", caps.Methods, want)
+	}
+	for _, m := range caps.Methods {
+		if _, err := parseMethod(m.String()); err != nil {
+			t.Errorf("method %v: %v", m, err)
+		}
+	}
+}
+
+func TestCapabilitiesJSON(t *testing.T) {
+	js, err := json.Marshal(Capabilities())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		ProtocolVersion int            `json:"protocol_version"`
+		CipherSuites    []string       `json:"cipher_suites"`
+		Versions        []int          `json:"envelope_versions"`
+		ErrorCodes      []string       `json:"error_codes"`
+		Methods         []string       `json:"methods"`
+		Limits          map[string]int `json:"limits"`
+	}
+	if err := json.Unmarshal(js, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ProtocolVersion != ProtocolVersion || !slices.Equal(got.CipherSuites, []string{"aes-128-ccm"}) ||
+		!slices.Equal(got.Versions, []int{0}) || !slices.Equal(got.Methods, []string{"PUSH", "PULL", "PING"}) {
+		t.Errorf("unexpected capabilities %s", js)
+	}
+	if !slices.Contains(got.ErrorCodes, "rate_limited") || got.Limits["max_frame_size"] != MaxFrameSize {
+		t.Errorf("unexpected capabilities %s", js)
+	}
+}
+
+func TestStringersRoundTrip(t *testing.T) {
+	for m := MethodPush; m < MethodUnknown; m++ {
+		if got, ok := MethodFromString(m.String()); !ok || got != m {
+			t.Errorf("method %d: %q parsed as %v, %v", int(m), m.String(), got, ok)
+		}
+	}
+	if _, ok := MethodFromString("SUBS"); ok {
+		t.Error("unknown method accepted")
+	}
+	if got := MethodUnknown.String(); got != "Method(3)" {
+		t.Errorf("MethodUnknown prints as %q", got)
+	}
+	for code := ErrorCode(0); code <= ErrorCodeUnknown; code++ {
+		if got := ParseErrorCode(code.String()); got != code {
+			t.Errorf("error code %d: %q parsed as %d", int(code), code.String(), int(got))
+		}
+	}
+	if got := ParseErrorCode("no_such_code"); got != ErrorCodeUnknown {
+		t.Errorf("unknown code parsed as %d", int(got))
+	}
+	for op, want := range []string{"number", "string", "boolean", "location", "Operator(4)"} {
+		if got := Operator(op).String(); got != want {
+			t.Errorf("operator %d: %q, want %q", op, got, want)
+		}
+	}
+	for s, want := range []string{"OK", "PONG", "CMD", "ERR", "AckStatus(4)"} {
+		if got := AckStatus(s).String(); got != want {
+			t.Errorf("status %d: %q, want %q", s, got, want)
+		}
+	}
+	for e, want := range []string{"hex", "base64", "PassthroughEncoding(2)"} {
+		if got := PassthroughEncoding(e).String(); got != want {
+			t.Errorf("encoding %d: %q, want %q", e, got, want)
+		}
+	}
+	f := mustParse(t, "PUSH|"+testAuth+"|dev|[t:=1]")
+	if got := fmt.Sprintf("%v %v", f.Method, f.PushBody.Structured.Variables[0].Operator); got != "PUSH number" {
+		t.Errorf("formatted as %q", got)
+	}
+}
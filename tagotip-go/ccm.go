@@ -13,42 +13,62 @@ const (
 	ccmBlock = 16     // AES block size
 )
 
-// ccmSeal encrypts plaintext and produces ciphertext || tag.
-func ccmSeal(block cipher.Block, nonce, aad, plaintext []byte) ([]byte, error) {
-	if len(nonce) != ccmNonceSize {
-		return nil, secureErr("invalid nonce size")
-	}
+// ccmScratch holds the blocks handed to cipher.Block.Encrypt. Buffers passed
+// through the interface call escape to the heap, so a single scratch is
+// shared by the helpers of one seal or open operation.
+type ccmScratch struct {
+	in  [ccmBlock]byte
+	out [ccmBlock]byte
+}
 
-	tag := ccmCBCMAC(block, nonce, aad, plaintext)
-	ciphertext := make([]byte, len(plaintext)+ccmTagSize)
+// ccmSealTo encrypts plaintext and writes ciphertext || tag into dst, which
+// must be exactly len(plaintext)+ccmTagSize bytes. dst may be plaintext's
+// own storage extended by the tag, but must not otherwise overlap it.
+func ccmSealTo(block cipher.Block, dst, nonce, aad, plaintext []byte) {
+	sc := new(ccmScratch)
+	tag := ccmCBCMAC(block, sc, nonce, aad, plaintext)
 
 	// CTR encryption of plaintext
-	ccmCTR(block, nonce, ciphertext[:len(plaintext)], plaintext)
+	ccmCTR(block, sc, nonce, dst[:len(plaintext)], plaintext)
 
 	// Encrypt the tag with CTR counter = 0
-	var a0 [ccmBlock]byte
+	a0 := &sc.in
+	*a0 = [ccmBlock]byte{}
 	a0[0] = byte(ccmL - 1) // flags for A0
 	copy(a0[1:], nonce)
 	// Counter bytes at end are 0 (already zeroed)
 
-	var s0 [ccmBlock]byte
+	s0 := &sc.out
 	block.Encrypt(s0[:], a0[:])
 
 	// XOR tag with S0 to produce encrypted tag
 	for i := 0; i < ccmTagSize; i++ {
-		ciphertext[len(plaintext)+i] = tag[i] ^ s0[i]
+		dst[len(plaintext)+i] = tag[i] ^ s0[i]
 	}
-
-	return ciphertext, nil
 }
 
 // ccmOpen decrypts ciphertext || tag and verifies the tag.
 func ccmOpen(block cipher.Block, nonce, aad, ciphertextWithTag []byte) ([]byte, error) {
+	if len(ciphertextWithTag) < ccmTagSize {
+		return nil, secureErr(ErrEnvelopeTooShort, "ciphertext too short")
+	}
+	plaintext := make([]byte, len(ciphertextWithTag)-ccmTagSize)
+	if err := ccmOpenTo(block, plaintext, nonce, aad, ciphertextWithTag); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// ccmOpenTo decrypts ciphertext || tag into dst, which must be exactly
+// len(ciphertextWithTag)-ccmTagSize bytes and must not overlap the input,
+// and verifies the tag. On failure dst is cleared, so that no
+// unauthenticated plaintext is left behind.
+func ccmOpenTo(block cipher.Block, dst, nonce, aad, ciphertextWithTag []byte) error {
 	if len(nonce) != ccmNonceSize {
-		return nil, secureErr("invalid nonce size")
+		return secureErr(ErrInvalidNonce, "invalid nonce size")
 	}
 	if len(ciphertextWithTag) < ccmTagSize {
-		return nil, secureErr("ciphertext too short")
+		return secureErr(ErrEnvelopeTooShort, "ciphertext too short")
 	}
 
 	ctLen := len(ciphertextWithTag) - ccmTagSize
@@ -56,11 +76,12 @@ func ccmOpen(block cipher.Block, nonce, aad, ciphertextWithTag []byte) ([]byte,
 	encTag := ciphertextWithTag[ctLen:]
 
 	// Decrypt the tag with CTR counter = 0
-	var a0 [ccmBlock]byte
+	sc := new(ccmScratch)
+	a0 := &sc.in
 	a0[0] = byte(ccmL - 1)
 	copy(a0[1:], nonce)
 
-	var s0 [ccmBlock]byte
+	s0 := &sc.out
 	block.Encrypt(s0[:], a0[:])
 
 	var receivedTag [ccmTagSize]byte
@@ -69,22 +90,23 @@ func ccmOpen(block cipher.Block, nonce, aad, ciphertextWithTag []byte) ([]byte,
 	}
 
 	// CTR decrypt the ciphertext
-	plaintext := make([]byte, ctLen)
-	ccmCTR(block, nonce, plaintext, ciphertext)
+	plaintext := dst[:ctLen]
+	ccmCTR(block, sc, nonce, plaintext, ciphertext)
 
 	// Compute expected tag
-	expectedTag := ccmCBCMAC(block, nonce, aad, plaintext)
+	expectedTag := ccmCBCMAC(block, sc, nonce, aad, plaintext)
 
 	// Constant-time comparison
 	if subtle.ConstantTimeCompare(receivedTag[:], expectedTag[:]) != 1 {
-		return nil, secureErr("AEAD decryption failed")
+		clear(plaintext)
+		return secureErr(ErrAuthFailed, "AEAD decryption failed")
 	}
 
-	return plaintext, nil
+	return nil
 }
 
 // ccmCBCMAC computes the CBC-MAC authentication tag.
-func ccmCBCMAC(block cipher.Block, nonce, aad, plaintext []byte) [ccmTagSize]byte {
+func ccmCBCMAC(block cipher.Block, sc *ccmScratch, nonce, aad, plaintext []byte) [ccmTagSize]byte {
 	// Build B0 block
 	var b0 [ccmBlock]byte
 	flags := byte(0)
@@ -103,30 +125,31 @@ func ccmCBCMAC(block cipher.Block, nonce, aad, plaintext []byte) [ccmTagSize]byt
 	}
 
 	// Start CBC-MAC
-	var x [ccmBlock]byte
-	xorBlock(&x, b0[:])
+	x := &sc.out
+	*x = b0
 	block.Encrypt(x[:], x[:])
 
-	// Encode AAD
+	// Encode AAD: a 2-byte length prefix (aad length < 2^16 - 2^8) followed
+	// by the aad itself, zero-padded to a block boundary. The blocks are fed
+	// straight from aad without building a padded copy.
 	if len(aad) > 0 {
-		// For aad length < 2^16 - 2^8, encode as 2-byte length
-		var aadHeader [2]byte
-		aadHeader[0] = byte(len(aad) >> 8)
-		aadHeader[1] = byte(len(aad))
-
-		// Process aad header + aad data
-		aadBuf := make([]byte, 0, 2+len(aad))
-		aadBuf = append(aadBuf, aadHeader[:]...)
-		aadBuf = append(aadBuf, aad...)
-
-		// Pad to block boundary
-		padLen := (ccmBlock - len(aadBuf)%ccmBlock) % ccmBlock
-		for range padLen {
-			aadBuf = append(aadBuf, 0)
+		var first [ccmBlock]byte
+		first[0] = byte(len(aad) >> 8)
+		first[1] = byte(len(aad))
+		n := copy(first[2:], aad)
+		xorBlock(x, first[:])
+		block.Encrypt(x[:], x[:])
+
+		rest := aad[n:]
+		for len(rest) >= ccmBlock {
+			xorBlock(x, rest[:ccmBlock])
+			block.Encrypt(x[:], x[:])
+			rest = rest[ccmBlock:]
 		}
-
-		for i := 0; i < len(aadBuf); i += ccmBlock {
-			xorBlock(&x, aadBuf[i:i+ccmBlock])
+		if len(rest) > 0 {
+			var last [ccmBlock]byte
+			copy(last[:], rest)
+			xorBlock(x, last[:])
 			block.Encrypt(x[:], x[:])
 		}
 	}
@@ -135,14 +158,14 @@ func ccmCBCMAC(block cipher.Block, nonce, aad, plaintext []byte) [ccmTagSize]byt
 	if len(plaintext) > 0 {
 		full := (len(plaintext) / ccmBlock) * ccmBlock
 		for i := 0; i < full; i += ccmBlock {
-			xorBlock(&x, plaintext[i:i+ccmBlock])
+			xorBlock(x, plaintext[i:i+ccmBlock])
 			block.Encrypt(x[:], x[:])
 		}
 		// Handle last partial block
 		if full < len(plaintext) {
 			var lastBlock [ccmBlock]byte
 			copy(lastBlock[:], plaintext[full:])
-			xorBlock(&x, lastBlock[:])
+			xorBlock(x, lastBlock[:])
 			block.Encrypt(x[:], x[:])
 		}
 	}
@@ -153,12 +176,13 @@ func ccmCBCMAC(block cipher.Block, nonce, aad, plaintext []byte) [ccmTagSize]byt
 }
 
 // ccmCTR performs CTR encryption/decryption starting at counter = 1.
-func ccmCTR(block cipher.Block, nonce []byte, dst, src []byte) {
-	var a [ccmBlock]byte
+func ccmCTR(block cipher.Block, sc *ccmScratch, nonce []byte, dst, src []byte) {
+	a := &sc.in
+	*a = [ccmBlock]byte{}
 	a[0] = byte(ccmL - 1)
 	copy(a[1:], nonce)
 
-	var keystream [ccmBlock]byte
+	keystream := &sc.out
 	counter := uint16(1) // Start at counter 1 for data
 
 	for i := 0; i < len(src); i += ccmBlock {
@@ -0,0 +1,104 @@
+package tagotip
+
+import "strings"
+
+// ---------------------------------------------------------------------------
+// Checksum trailer
+// ---------------------------------------------------------------------------
+//
+// On links that corrupt bytes silently, such as RS-485 or raw UART, a frame
+// may carry a CRC trailer so that corruption is told apart from protocol
+// mistakes. The trailer is '*' followed by the CRC-16/CCITT-FALSE (poly
+// 0x1021, init 0xFFFF, no reflection) of every preceding byte, as four
+// upper-case hex digits, placed before a trailing newline:
+//
+//	PUSH|<auth>|<serial>|[temp:=21.5]*3A7F\n
+//
+// No valid frame ends in '*' and four hex digits, so the trailer cannot be
+// mistaken for frame content. It covers a signature field (see SignUplink),
+// which is added first.
+
+// checksumTrailerLen is the length of "*XXXX".
+const checksumTrailerLen = 5
+
+// AppendChecksum returns frame with a checksum trailer, keeping a trailing
+// newline at the end.
+func AppendChecksum(frame string) string {
+	body, nl := strings.CutSuffix(frame, "\n")
+	crc := crc16CCITT(body)
+	const digits = "0123456789ABCDEF"
+	var b strings.Builder
+	b.Grow(len(frame) + checksumTrailerLen)
+	b.WriteString(body)
+	b.WriteByte('*')
+	for shift := 12; shift >= 0; shift -= 4 {
+		b.WriteByte(digits[crc>>shift&0xF])
+	}
+	if nl {
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// VerifyChecksum strips the checksum trailer of frame, keeping a trailing
+// newline. ok is false when the trailer does not match the frame; a frame
+// without a trailer is returned unchanged with ok true.
+func VerifyChecksum(frame string) (unchecked string, ok bool) {
+	body, nl := strings.CutSuffix(frame, "\n")
+	want, has := checksumTrailer(body)
+	if !has {
+		return frame, true
+	}
+	body = body[:len(body)-checksumTrailerLen]
+	if crc16CCITT(body) != want {
+		return frame, false
+	}
+	if nl {
+		return body + "\n", true
+	}
+	return body, true
+}
+
+// checksumTrailer decodes the trailer at the end of s, if any. Hex digits
+// of either case are accepted.
+func checksumTrailer(s string) (crc uint16, ok bool) {
+	if len(s) < checksumTrailerLen || s[len(s)-checksumTrailerLen] != '*' {
+		return 0, false
+	}
+	for i := len(s) - 4; i < len(s); i++ {
+		c := s[i]
+		var d byte
+		switch {
+		case c >= '0' && c <= '9':
+			d = c - '0'
+		case c >= 'A' && c <= 'F':
+			d = c - 'A' + 10
+		case c >= 'a' && c <= 'f':
+			d = c - 'a' + 10
+		default:
+			return 0, false
+		}
+		crc = crc<<4 | uint16(d)
+	}
+	return crc, true
+}
+
+// checksumPos returns the position of the trailer of frame.
+func checksumPos(frame string) int {
+	return len(strings.TrimSuffix(frame, "\n")) - checksumTrailerLen
+}
+
+func crc16CCITT(s string) uint16 {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
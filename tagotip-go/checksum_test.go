@@ -0,0 +1,107 @@
+package tagotip
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// =========================================================================
+// Checksum trailer
+// =========================================================================
+
+func TestCRC16CCITTCheckValue(t *testing.T) {
+	if got := crc16CCITT("123456789"); got != 0x29B1 {
+		t.Errorf("crc16CCITT(123456789) = %#04x, want 0x29b1", got)
+	}
+}
+
+func TestChecksumRoundTrip(t *testing.T) {
+	for _, frame := range []string{
+		"PUSH|" + testAuth + "|dev|[temp:=21.5#C]",
+		"PUSH|" + testAuth + "|dev|[temp:=21.5#C]\n",
+		"PING|!3|" + testAuth + "|dev",
+		"PUSH|" + testAuth + "|dev|>xDEADBEEF",
+	} {
+		withCRC := AppendChecksum(frame)
+		body := strings.TrimSuffix(frame, "\n")
+		if !strings.HasPrefix(withCRC, body+"*") || len(withCRC) != len(frame)+5 {
+			t.Errorf("%q: got %q", frame, withCRC)
+		}
+		if strings.HasSuffix(frame, "\n") != strings.HasSuffix(withCRC, "\n") {
+			t.Errorf("%q: trailing newline not kept: %q", frame, withCRC)
+		}
+		got, ok := VerifyChecksum(withCRC)
+		if !ok || got != frame {
+			t.Errorf("%q: verified as %q, %v", frame, got, ok)
+		}
+		lower := withCRC[:len(body)+1] + strings.ToLower(withCRC[len(body)+1:])
+		if got, ok := VerifyChecksum(lower); !ok || got != frame {
+			t.Errorf("%q: lower-case trailer verified as %q, %v", frame, got, ok)
+		}
+	}
+}
+
+func TestChecksumDetectsFlippedBit(t *testing.T) {
+	withCRC := AppendChecksum("PUSH|" + testAuth + "|dev|[temp:=21.5#C]")
+	for i := 0; i < len(withCRC)-5; i++ {
+		for bit := 0; bit < 8; bit++ {
+			b := []byte(withCRC)
+			b[i] ^= 1 << bit
+			if _, ok := VerifyChecksum(string(b)); ok {
+				t.Fatalf("flip of bit %d at %d not detected", bit, i)
+			}
+		}
+	}
+}
+
+func TestChecksumAbsentPassesThrough(t *testing.T) {
+	for _, frame := range []string{
+		"PUSH|" + testAuth + "|dev|[temp:=21.5]",
+		"PUSH|" + testAuth + "|dev|[note=a*b]\n",
+		"",
+	} {
+		if got, ok := VerifyChecksum(frame); !ok || got != frame {
+			t.Errorf("%q: got %q, %v", frame, got, ok)
+		}
+	}
+}
+
+func TestParseWithVerifyChecksum(t *testing.T) {
+	opts := &ParserOptions{VerifyChecksum: true}
+	frame := "PUSH|" + testAuth + "|dev|[temp:=21.5#C]"
+	for _, input := range []string{AppendChecksum(frame), AppendChecksum(frame + "\n"), frame, frame + "\n"} {
+		f, err := ParseUplinkWithOptions(input, opts)
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		if raw, _ := BuildUplink(f); raw != frame {
+			t.Errorf("%q: parsed as %q", input, raw)
+		}
+	}
+
+	corrupted := strings.Replace(AppendChecksum(frame+"\n"), "21.5", "21.4", 1)
+	_, err := ParseUplinkWithOptions(corrupted, opts)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Kind != ErrChecksumMismatch || pe.Position != len(frame) {
+		t.Errorf("corrupted frame: got %v", err)
+	}
+
+	// Without the option the trailer is not interpreted.
+	_, err = ParseUplink(corrupted)
+	if errors.As(err, &pe) && pe.Kind == ErrChecksumMismatch {
+		t.Error("trailer checked without VerifyChecksum")
+	}
+}
+
+func TestChecksumCoversSignature(t *testing.T) {
+	signed := SignUplink("PUSH|!1|"+testAuth+"|dev|[temp:=21.5]\n", testSigningKey)
+	opts := &ParserOptions{VerifyChecksum: true, Verifier: NewSignatureVerifier(signingKeys)}
+	f, err := ParseUplinkWithOptions(AppendChecksum(signed), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Signature == "" {
+		t.Error("signature not exposed")
+	}
+}
@@ -0,0 +1,398 @@
+package tagotip
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// TCP client
+// ---------------------------------------------------------------------------
+//
+// Client sends newline-terminated uplink frames over a stream connection
+// and returns the ACK each one receives. A background goroutine reads the
+// ACKs and hands each to the call waiting for it: by seq when
+// ClientOptions.Sequencing is set, and in order otherwise, as the server
+// answers frames in the order it receives them. ClientOptions.RequireSeqEcho
+// further holds the server to echoing every seq.
+
+// ErrClientClosed is returned by Client calls after Close.
+var ErrClientClosed = errors.New("tagotip: client closed")
+
+// ServerError is returned by Client calls answered with ERR. A
+// FrameHandlerFunc may return one to choose the ERR ACK its frame is
+// answered with.
+type ServerError struct {
+	Code ErrorCode
+	Ack  *AckFrame // the ERR ACK as received; may be nil when sending
+}
+
+func (e *ServerError) Error() string {
+	if e.Ack != nil && e.Ack.Detail != nil && e.Ack.Detail.Text != "" {
+		return fmt.Sprintf("tagotip: server error: %s", e.Ack.Detail.Text)
+	}
+	return fmt.Sprintf("tagotip: server error: %v", e.Code)
+}
+
+// SeqEchoError is the error of a Client under ClientOptions.RequireSeqEcho
+// whose server answered with an ACK without seq, or with a seq the client
+// never sent. The client closes its connection, and every call in progress
+// and after returns the error.
+type SeqEchoError struct {
+	Ack *AckFrame // the offending ACK
+}
+
+func (e *SeqEchoError) Error() string {
+	if e.Ack.Seq == nil {
+		return "tagotip: ACK without seq"
+	}
+	return fmt.Sprintf("tagotip: ACK for seq %d, which was not sent", *e.Ack.Seq)
+}
+
+// ackError returns a *ServerError for an ERR ACK, and nil otherwise.
+func ackError(ack *AckFrame) error {
+	if ack.Status != AckStatusErr {
+		return nil
+	}
+	e := &ServerError{Code: ErrorCodeUnknown, Ack: ack}
+	if ack.Detail != nil {
+		e.Code = ack.Detail.ErrorCode
+	}
+	return e
+}
+
+// ClientOptions configures a Client. The zero value sends frames without
+// seq and waits for ACKs as long as each call's context allows.
+type ClientOptions struct {
+	// Sequencing numbers frames with a seq counter from 1 up and matches
+	// ACKs to calls by it, so that the ACK of a call given up on is not
+	// taken for the ACK of the next one.
+	Sequencing bool
+
+	// RequireSeqEcho turns on Sequencing and treats an ACK without seq, or
+	// with a seq the client never sent, as a protocol violation, failing
+	// the client with a *SeqEchoError. An ACK for a frame already answered
+	// or given up on is ignored.
+	RequireSeqEcho bool
+
+	// LastSeq is the seq numbering resumes after, as saved from
+	// Client.LastSeq, so that a restarted device does not reuse the seq of
+	// frames it sent before. Zero starts at 1.
+	LastSeq uint32
+
+	// Timeout bounds a call whose context has no deadline, and Dial.
+	// Under Retry it bounds each attempt. Zero means no bound.
+	Timeout time.Duration
+
+	// Retry, if not nil, retries Push and Pull calls answered with a
+	// retryable error code.
+	Retry *RetryPolicy
+
+	// IdempotencyKeys stamps the structured body of every Push with a new
+	// idempotency key, sent unchanged by each of its retries, so that an
+	// IdempotencyCache can tell a retry from a new frame. A body that
+	// already carries a key keeps it.
+	IdempotencyKeys bool
+}
+
+// Client sends frames for one device over a connection. It is safe for
+// concurrent use.
+type Client struct {
+	conn   net.Conn
+	auth   string
+	serial string
+	opts   ClientOptions
+
+	writeMu sync.Mutex
+
+	seq SeqTracker
+
+	mu      sync.Mutex
+	pending []*clientCall
+	err     error // set when the connection is closed or fails
+	done    chan struct{}
+}
+
+type clientCall struct {
+	seq       *uint32
+	ack       chan *AckFrame // buffered; receives the call's ACK
+	abandoned bool           // the caller gave up after sending
+}
+
+// Dial connects to the server at addr, a TCP host:port, for the device with
+// the given authorization hash and serial. A nil opts is equivalent to the
+// zero value.
+func Dial(addr, auth, serial string, opts *ClientOptions) (*Client, error) {
+	var o ClientOptions
+	if opts != nil {
+		o = *opts
+	}
+	conn, err := net.DialTimeout("tcp", addr, o.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn, auth, serial, &o), nil
+}
+
+// NewClient returns a Client using conn, which it takes over: the Client
+// reads from it until closed.
+func NewClient(conn net.Conn, auth, serial string, opts *ClientOptions) *Client {
+	c := &Client{conn: conn, auth: auth, serial: serial, done: make(chan struct{})}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.RequireSeqEcho {
+		c.opts.Sequencing = true
+	}
+	c.seq.last.Store(c.opts.LastSeq)
+	go c.readLoop()
+	return c
+}
+
+// LastSeq returns the seq of the last frame the client numbered, or
+// ClientOptions.LastSeq before the first, for a device to save and resume
+// numbering after with ClientOptions.LastSeq.
+func (c *Client) LastSeq() uint32 {
+	return c.seq.Last()
+}
+
+// Close closes the connection. Calls in progress return ErrClientClosed.
+func (c *Client) Close() error {
+	c.fail(ErrClientClosed)
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// Push sends a PUSH with body and returns its ACK. An ERR ACK is returned
+// as a *ServerError, after any retries ClientOptions.Retry allows.
+func (c *Client) Push(ctx context.Context, body *PushBody) (*AckFrame, error) {
+	return c.callRetry(ctx, &UplinkFrame{Method: MethodPush, PushBody: body})
+}
+
+// Pull sends a PULL for the named variables and returns its ACK. An ERR
+// ACK is returned as a *ServerError, after any retries ClientOptions.Retry
+// allows.
+func (c *Client) Pull(ctx context.Context, vars ...string) (*AckFrame, error) {
+	return c.callRetry(ctx, &UplinkFrame{Method: MethodPull, PullBody: &PullBody{Variables: vars}})
+}
+
+// Ping sends a PING. It returns a *ServerError for an ERR ACK.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.call(ctx, &UplinkFrame{Method: MethodPing})
+	return err
+}
+
+func (c *Client) callRetry(ctx context.Context, f *UplinkFrame) (*AckFrame, error) {
+	if c.opts.IdempotencyKeys && f.Method == MethodPush {
+		body, err := withIdempotencyKey(f.PushBody)
+		if err != nil {
+			return nil, err
+		}
+		f.PushBody = body
+	}
+	if c.opts.Retry == nil {
+		return c.call(ctx, f)
+	}
+	return c.opts.Retry.do(ctx, func() (*AckFrame, error) { return c.call(ctx, f) })
+}
+
+func (c *Client) call(ctx context.Context, f *UplinkFrame) (*AckFrame, error) {
+	if _, ok := ctx.Deadline(); !ok && c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+
+	f.Auth, f.Serial = c.auth, c.serial
+	call := &clientCall{ack: make(chan *AckFrame, 1)}
+
+	// ACKs without seq are matched in order, so a call joins the queue and
+	// writes its frame under one lock: the queue is then in wire order.
+	c.writeMu.Lock()
+	raw, err := c.enqueue(ctx, call, f)
+	if err == nil {
+		if err = c.write(ctx, raw); err != nil {
+			c.abort(call, err)
+		}
+	}
+	c.writeMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case ack, ok := <-call.ack:
+		if !ok {
+			return nil, c.closedErr()
+		}
+		if err := ackError(ack); err != nil {
+			return nil, err
+		}
+		return ack, nil
+	case <-ctx.Done():
+		c.forget(call)
+		return nil, ctx.Err()
+	}
+}
+
+// enqueue numbers f when sequencing, builds it, and queues call to receive
+// its ACK. The caller holds writeMu.
+func (c *Client) enqueue(ctx context.Context, call *clientCall, f *UplinkFrame) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return "", c.err
+	}
+	if c.opts.Sequencing {
+		seq := c.seq.Next()
+		call.seq, f.Seq = &seq, &seq
+	}
+	raw, err := BuildUplink(f)
+	if err != nil {
+		return "", err
+	}
+	c.pending = append(c.pending, call)
+	return raw, nil
+}
+
+// write sends one frame, giving up when ctx is done. The caller holds
+// writeMu.
+func (c *Client) write(ctx context.Context, raw string) error {
+	deadline, _ := ctx.Deadline()
+	c.conn.SetWriteDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { c.conn.SetWriteDeadline(time.Unix(1, 0)) })
+	defer stop()
+	_, err := c.conn.Write(append([]byte(raw), '\n'))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// forget drops a call given up on after its frame was sent. Without
+// sequencing the call keeps its place in the queue, so that its ACK is not
+// taken for the next call's.
+func (c *Client) forget(call *clientCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.opts.Sequencing {
+		call.abandoned = true
+		return
+	}
+	c.remove(call)
+}
+
+// abort drops a call whose frame failed to be written and closes the
+// connection: some of the frame may have been sent, leaving the stream
+// and the order of ACKs unknown. Calls in progress fail with the write
+// error.
+func (c *Client) abort(call *clientCall, err error) {
+	c.mu.Lock()
+	c.remove(call)
+	c.mu.Unlock()
+	c.fail(fmt.Errorf("tagotip: writing frame: %w", err))
+	c.conn.Close()
+}
+
+// remove takes call out of the queue. The caller holds mu.
+func (c *Client) remove(call *clientCall) {
+	for i, p := range c.pending {
+		if p == call {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+	sc := bufio.NewScanner(c.conn)
+	sc.Split(ScanFrames)
+	for sc.Scan() {
+		ack, err := ParseAck(sc.Text())
+		if err != nil {
+			continue // not an ACK this client can match
+		}
+		if err := c.deliver(ack); err != nil {
+			c.fail(err)
+			c.conn.Close()
+		}
+	}
+	err := sc.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	c.fail(fmt.Errorf("tagotip: reading ACK: %w", err))
+}
+
+// deliver hands ack to the call it answers: the call with its seq under
+// sequencing, and otherwise the oldest call. Under sequencing an ACK
+// without seq is dropped, as it may answer a call given up on. Under
+// RequireSeqEcho, deliver returns a *SeqEchoError for an ACK without seq or
+// with a seq never sent.
+func (c *Client) deliver(ack *AckFrame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := -1
+	switch {
+	case !c.opts.Sequencing:
+		if len(c.pending) > 0 {
+			i = 0
+		}
+	case ack.Seq != nil:
+		for j, p := range c.pending {
+			if *p.seq == *ack.Seq {
+				i = j
+				break
+			}
+		}
+	}
+	if i < 0 {
+		if c.opts.RequireSeqEcho && (ack.Seq == nil || !c.sent(*ack.Seq)) {
+			return &SeqEchoError{Ack: ack}
+		}
+		return nil // the ACK of a call answered or given up on
+	}
+	call := c.pending[i]
+	c.pending = append(c.pending[:i], c.pending[i+1:]...)
+	if !call.abandoned {
+		call.ack <- ack
+	}
+	return nil
+}
+
+// sent reports whether seq was handed out by the client, or before it by
+// the device: whether it is at most half the seq space behind the last.
+func (c *Client) sent(seq uint32) bool {
+	return c.seq.Last()-seq < 1<<31
+}
+
+// fail records err as the reason the client stopped, if none is yet, and
+// releases every waiting call.
+func (c *Client) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for _, p := range c.pending {
+		close(p.ack)
+	}
+	c.pending = nil
+}
+
+func (c *Client) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
@@ -0,0 +1,360 @@
+package tagotip
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// TCP client
+// ============================================================================
+
+// fakeServer reads uplink frames from conn and answers each with the ACK
+// reply returns for it, if any.
+func fakeServer(t *testing.T, conn net.Conn, reply func(*UplinkFrame) string) {
+	t.Helper()
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			f, err := ParseUplink(line)
+			if err != nil {
+				t.Errorf("server got %q: %v", line, err)
+				return
+			}
+			if ack := reply(f); ack != "" {
+				conn.Write([]byte(ack + "\n"))
+			}
+		}
+	}()
+}
+
+func pipeClient(t *testing.T, opts *ClientOptions, reply func(*UplinkFrame) string) *Client {
+	t.Helper()
+	client, server := net.Pipe()
+	fakeServer(t, server, reply)
+	c := NewClient(client, testAuth, "dev", opts)
+	t.Cleanup(func() {
+		c.Close()
+		server.Close()
+	})
+	return c
+}
+
+func TestClientCalls(t *testing.T) {
+	c := pipeClient(t, nil, func(f *UplinkFrame) string {
+		if f.Auth != testAuth || f.Serial != "dev" || f.Seq != nil {
+			t.Errorf("server got %+v", f)
+		}
+		switch f.Method {
+		case MethodPush:
+			return "ACK|OK|" + string(rune('0'+len(f.PushBody.Structured.Variables)))
+		case MethodPull:
+			return "ACK|OK|[" + strings.Join(f.PullBody.Variables, ":=1;") + ":=1]"
+		default:
+			return "ACK|PONG"
+		}
+	})
+	ctx := context.Background()
+
+	body := &PushBody{Structured: &StructuredBody{Variables: []Variable{
+		{Name: "temp", Operator: OperatorNumber, Value: Value{Type: OperatorNumber, Str: "21.5"}},
+		{Name: "hum", Operator: OperatorNumber, Value: Value{Type: OperatorNumber, Str: "60"}},
+	}}}
+	ack, err := c.Push(ctx, body)
+	if err != nil || ack.Status != AckStatusOk || ack.Detail.Count != 2 {
+		t.Errorf("Push: %+v, %v", ack, err)
+	}
+	ack, err = c.Pull(ctx, "temp", "hum")
+	if err != nil || ack.Detail.Vars == nil || len(ack.Detail.Vars.Variables) != 2 {
+		t.Errorf("Pull: %+v, %v", ack, err)
+	}
+	if err := c.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+
+	// Invalid frames are not sent.
+	if _, err := c.Pull(ctx); err == nil {
+		t.Error("PULL without variables sent")
+	}
+}
+
+func TestClientServerError(t *testing.T) {
+	c := pipeClient(t, nil, func(*UplinkFrame) string { return "ACK|ERR|rate_limited|30" })
+	err := c.Ping(context.Background())
+	var se *ServerError
+	if !errors.As(err, &se) || se.Code != ErrorCodeRateLimited {
+		t.Fatalf("got %v", err)
+	}
+	if se.Ack.Detail.Err == nil || *se.Ack.Detail.Err.RetryAfter != 30 {
+		t.Errorf("detail %+v", se.Ack.Detail)
+	}
+	if err.Error() != "tagotip: server error: rate_limited" {
+		t.Errorf("message %q", err)
+	}
+}
+
+func TestClientSequencing(t *testing.T) {
+	// The server answers the second PULL before the first.
+	held := make(chan *UplinkFrame, 1)
+	c := pipeClient(t, &ClientOptions{Sequencing: true}, func(f *UplinkFrame) string {
+		if f.Seq == nil {
+			t.Errorf("frame without seq")
+			return ""
+		}
+		if *f.Seq == 1 {
+			held <- f
+			return ""
+		}
+		n := strconv.FormatUint(uint64(*f.Seq), 10)
+		return "ACK|!" + n + "|OK|" + n + "\nACK|!1|OK|1"
+	})
+	ctx := context.Background()
+	first := make(chan *AckFrame)
+	go func() {
+		ack, err := c.Pull(ctx, "a")
+		if err != nil {
+			t.Error(err)
+		}
+		first <- ack
+	}()
+	<-held
+	ack, err := c.Pull(ctx, "b")
+	if err != nil || *ack.Seq != 2 || ack.Detail.Count != 2 {
+		t.Errorf("second call got %+v, %v", ack, err)
+	}
+	if ack := <-first; ack == nil || *ack.Seq != 1 || ack.Detail.Count != 1 {
+		t.Errorf("first call got %+v", ack)
+	}
+}
+
+func TestClientSequencingDropsAckWithoutSeq(t *testing.T) {
+	// An ACK without seq is not taken for the ACK of the oldest call.
+	c := pipeClient(t, &ClientOptions{Sequencing: true}, func(f *UplinkFrame) string {
+		return "ACK|OK|9\nACK|!" + strconv.FormatUint(uint64(*f.Seq), 10) + "|OK|1"
+	})
+	ack, err := c.Pull(context.Background(), "a")
+	if err != nil || ack.Seq == nil || *ack.Seq != 1 || ack.Detail.Count != 1 {
+		t.Errorf("got %+v, %v", ack, err)
+	}
+}
+
+func TestClientRequireSeqEcho(t *testing.T) {
+	echo := func(f *UplinkFrame) string { return "ACK|!" + strconv.FormatUint(uint64(*f.Seq), 10) + "|PONG" }
+	for _, tc := range []struct {
+		name  string
+		reply func(*UplinkFrame) string
+		ok    bool
+	}{
+		{"echo", echo, true},
+		{"stale ACK ignored", func(f *UplinkFrame) string {
+			return "ACK|!" + strconv.FormatUint(uint64(*f.Seq-1), 10) + "|PONG\n" + echo(f)
+		}, true},
+		{"missing echo", func(*UplinkFrame) string { return "ACK|PONG" }, false},
+		{"seq never sent", func(*UplinkFrame) string { return "ACK|!99|PONG" }, false},
+	} {
+		c := pipeClient(t, &ClientOptions{RequireSeqEcho: true, LastSeq: 41}, tc.reply)
+		err := c.Ping(context.Background())
+		var se *SeqEchoError
+		switch {
+		case tc.ok && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case !tc.ok && !errors.As(err, &se):
+			t.Errorf("%s: got %v", tc.name, err)
+		}
+		if c.LastSeq() != 42 {
+			t.Errorf("%s: last seq %d", tc.name, c.LastSeq())
+		}
+		// After a violation, the client is closed.
+		if err := c.Ping(context.Background()); !tc.ok && !errors.As(err, &se) {
+			t.Errorf("%s: next call got %v", tc.name, err)
+		}
+	}
+}
+
+func TestClientConcurrentSeqs(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[uint32]bool)
+	c := pipeClient(t, &ClientOptions{RequireSeqEcho: true}, func(f *UplinkFrame) string {
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[*f.Seq] {
+			t.Errorf("seq %d sent twice", *f.Seq)
+		}
+		seen[*f.Seq] = true
+		return "ACK|!" + strconv.FormatUint(uint64(*f.Seq), 10) + "|PONG"
+	})
+	const n = 200
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Ping(context.Background()); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != n || c.LastSeq() != n {
+		t.Errorf("%d seqs sent, last %d", len(seen), c.LastSeq())
+	}
+}
+
+func TestClientCancelKeepsOrder(t *testing.T) {
+	// Without seq, the late ACK of a call given up on must not be taken
+	// for the next call's.
+	release := make(chan struct{})
+	n := 0
+	c := pipeClient(t, nil, func(*UplinkFrame) string {
+		n++
+		if n == 1 {
+			<-release
+			return "ACK|OK|1"
+		}
+		return "ACK|OK|2"
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := c.Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v", err)
+	}
+	close(release)
+	ack, err := c.Pull(context.Background(), "a")
+	if err != nil || ack.Detail.Count != 2 {
+		t.Errorf("got %+v, %v", ack, err)
+	}
+}
+
+func TestClientConcurrentCalls(t *testing.T) {
+	// Each caller must get the ACK of its own frame, which the server
+	// answers with the frame's value as the count.
+	for _, seq := range []bool{false, true} {
+		c := pipeClient(t, &ClientOptions{Sequencing: seq}, func(f *UplinkFrame) string {
+			ack := "ACK|"
+			if f.Seq != nil {
+				ack += "!" + strconv.FormatUint(uint64(*f.Seq), 10) + "|"
+			}
+			return ack + "OK|" + f.PushBody.Structured.Variables[0].Value.Str
+		})
+		const n = 500
+		var wg sync.WaitGroup
+		errc := make(chan error, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				v, _ := NumberVar("v", float64(i))
+				ack, err := c.Push(context.Background(), &PushBody{Structured: &StructuredBody{Variables: []Variable{v}}})
+				if err == nil && ack.Detail.Count != uint32(i) {
+					err = fmt.Errorf("call %d got the ACK of %d", i, ack.Detail.Count)
+				}
+				errc <- err
+			}(i)
+		}
+		wg.Wait()
+		close(errc)
+		for err := range errc {
+			if err != nil {
+				t.Errorf("sequencing=%v: %v", seq, err)
+			}
+		}
+	}
+}
+
+// failingConn is a net.Conn whose writes fail after writing half the
+// frame.
+type failingConn struct {
+	net.Conn
+}
+
+func (c failingConn) Write(b []byte) (int, error) {
+	n, _ := c.Conn.Write(b[:len(b)/2])
+	return n, errors.New("write failed")
+}
+
+func TestClientWriteErrorClosesConnection(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		r := bufio.NewReader(server)
+		for {
+			if _, err := r.ReadString('|'); err != nil {
+				return
+			}
+			server.Write([]byte("ACK|OK|1\n"))
+		}
+	}()
+	c := NewClient(failingConn{client}, testAuth, "dev", nil)
+	defer c.Close()
+	if err := c.Ping(context.Background()); err == nil || !strings.Contains(err.Error(), "write failed") {
+		t.Fatalf("got %v", err)
+	}
+	// The connection is unusable: the next call fails rather than taking
+	// an ACK meant for the frame that failed.
+	if _, err := c.Pull(context.Background(), "a"); err == nil {
+		t.Error("call after a failed write succeeded")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) != 0 {
+		t.Errorf("%d calls left queued", len(c.pending))
+	}
+}
+
+func TestClientTimeoutOption(t *testing.T) {
+	c := pipeClient(t, &ClientOptions{Timeout: 20 * time.Millisecond}, func(*UplinkFrame) string { return "" })
+	if err := c.Ping(context.Background()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	c := pipeClient(t, nil, func(*UplinkFrame) string { return "" })
+	errc := make(chan error)
+	go func() { errc <- c.Ping(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+	if err := <-errc; !errors.Is(err, ErrClientClosed) {
+		t.Errorf("pending call: %v", err)
+	}
+	if err := c.Ping(context.Background()); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("after Close: %v", err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fakeServer(t, conn, func(*UplinkFrame) string { return "ACK|PONG" })
+		time.Sleep(time.Second)
+	}()
+	c, err := Dial(ln.Addr().String(), testAuth, "dev", &ClientOptions{Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := c.Ping(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,92 @@
+package tagotip
+
+import "reflect"
+
+// ---------------------------------------------------------------------------
+// Copying and comparing frames
+// ---------------------------------------------------------------------------
+//
+// Clone returns a deep copy that may be changed without affecting the
+// original, and Equal compares exported contents, treating nil and empty
+// slices alike. Neither looks at unexported state such as the spares kept
+// by Reset. Lazily held metadata is parsed into a clone and compared as
+// parsed.
+
+// Clone returns a deep copy of f. Strings are shared, as they are
+// immutable; to also detach them from a ZeroCopy input, use Detach.
+func (f *UplinkFrame) Clone() *UplinkFrame {
+	if f == nil {
+		return nil
+	}
+	return cloneUplink(f)
+}
+
+// Clone returns a deep copy of h.
+func (h *HeadlessFrame) Clone() *HeadlessFrame {
+	if h == nil {
+		return nil
+	}
+	u := cloneUplink(&UplinkFrame{PushBody: h.PushBody, PullBody: h.PullBody})
+	return &HeadlessFrame{Serial: h.Serial, PushBody: u.PushBody, PullBody: u.PullBody}
+}
+
+// Equal reports whether h and g have the same exported contents.
+func (h *HeadlessFrame) Equal(g *HeadlessFrame) bool {
+	if h == nil || g == nil {
+		return h == g
+	}
+	return reflect.DeepEqual(h.Clone(), g.Clone())
+}
+
+// Clone returns a deep copy of a, including its typed detail.
+func (a *AckFrame) Clone() *AckFrame {
+	if a == nil {
+		return nil
+	}
+	c := &AckFrame{Seq: clonePtr(a.Seq), Status: a.Status}
+	if d := a.Detail; d != nil {
+		cd := &AckDetail{Type: d.Type, Count: d.Count, Text: d.Text, ErrorCode: d.ErrorCode}
+		if d.Vars != nil {
+			cd.Vars = &VariablesDetail{Variables: cloneVariables(d.Vars.Variables)}
+		}
+		if d.Variables != nil {
+			cd.Variables = cloneVariables(d.Variables)
+			if d.Vars != nil && sameSlice(d.Variables, d.Vars.Variables) {
+				cd.Variables = cd.Vars.Variables
+			}
+		}
+		if p := d.Pong; p != nil {
+			cd.Pong = &PongDetail{Region: p.Region, QueueDepth: clonePtr(p.QueueDepth),
+				NextSeq: clonePtr(p.NextSeq), Extra: cloneSlice(p.Extra)}
+		}
+		if cmd := d.Cmd; cmd != nil {
+			cd.Cmd = &CommandDetail{Name: cmd.Name, Encoding: cmd.Encoding, Binary: cloneSlice(cmd.Binary)}
+		}
+		if e := d.Err; e != nil {
+			cd.Err = &ErrorDetail{RetryAfter: clonePtr(e.RetryAfter), Extra: e.Extra}
+		}
+		c.Detail = cd
+	}
+	return c
+}
+
+// Equal reports whether a and b have the same exported contents.
+func (a *AckFrame) Equal(b *AckFrame) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return reflect.DeepEqual(a.Clone(), b.Clone())
+}
+
+func cloneVariables(vs []Variable) []Variable {
+	c := make([]Variable, len(vs))
+	for i := range vs {
+		c[i] = cloneVariable(&vs[i])
+	}
+	return c
+}
+
+// sameSlice reports whether a and b are the same slice of the same array.
+func sameSlice[T any](a, b []T) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
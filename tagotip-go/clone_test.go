@@ -0,0 +1,118 @@
+package tagotip
+
+import "testing"
+
+// ============================================================================
+// Copying and comparing frames
+// ============================================================================
+
+func TestUplinkFrameClone(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		input := "PUSH|" + testAuth + "|dev|{src=a}[temp:=21#C{fw=1};pos@=1,2,3]"
+		f, err := ParseUplinkWithOptions(input, &ParserOptions{LazyMeta: lazy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := f.Clone()
+		if !c.Equal(f) {
+			t.Fatalf("lazy=%v: clone differs", lazy)
+		}
+		sb := c.PushBody.Structured
+		sb.Meta[0].Value = "b"
+		sb.Variables[0].Meta.Set("fw", "2")
+		*sb.Variables[0].Unit = "F"
+		*sb.Variables[1].Value.Location.Alt = "4"
+		if raw, _ := BuildUplink(f); raw != input {
+			t.Errorf("lazy=%v: original changed, now builds as %q", lazy, raw)
+		}
+		if c.Equal(f) {
+			t.Errorf("lazy=%v: changed clone still equal", lazy)
+		}
+	}
+	if (*UplinkFrame)(nil).Clone() != nil {
+		t.Error("nil frame cloned")
+	}
+}
+
+func TestUplinkFrameEqualEmptySlices(t *testing.T) {
+	f := mustParse(t, "PUSH|"+testAuth+"|dev|[a:=1]")
+	g := f.Clone()
+	g.PushBody.Structured.Meta = MetaPairs{}
+	g.PushBody.Structured.Variables[0].Meta = MetaPairs{}
+	g.PingDiagnostics = []MetaPair{}
+	if !f.Equal(g) || !g.Equal(f) {
+		t.Error("nil and empty metadata differ")
+	}
+	var nilFrame *UplinkFrame
+	if f.Equal(nilFrame) || !nilFrame.Equal(nil) {
+		t.Error("nil frame comparison")
+	}
+}
+
+func TestHeadlessFrameCloneEqual(t *testing.T) {
+	h, err := ParseHeadless(MethodPush, "dev|[temp:=21#C{fw=1}]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := h.Clone()
+	if !c.Equal(h) {
+		t.Fatal("clone differs")
+	}
+	*c.PushBody.Structured.Variables[0].Unit = "F"
+	c.PushBody.Structured.Variables[0].Meta[0].Value = "2"
+	v := h.PushBody.Structured.Variables[0]
+	if *v.Unit != "C" || v.Meta[0].Value != "1" || c.Equal(h) {
+		t.Errorf("clone shares memory: %+v", v)
+	}
+}
+
+func TestAckFrameCloneEqual(t *testing.T) {
+	for _, input := range []string{
+		"ACK|!4|OK|3",
+		"ACK|!1|OK|[temp:=21#C{fw=1}]",
+		"ACK|!9|PONG|region=sa,queue=2,next=10",
+		"ACK|!5|CMD|fw >xdead",
+		"ACK|!2|ERR|rate_limited|30",
+	} {
+		a, err := ParseAck(input)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		c := a.Clone()
+		if !c.Equal(a) {
+			t.Errorf("%s: clone differs", input)
+			continue
+		}
+		*c.Seq++
+		if c.Equal(a) || *a.Seq == *c.Seq {
+			t.Errorf("%s: Seq shared", input)
+		}
+		if raw, _ := BuildAck(c); raw == input {
+			t.Errorf("%s: clone not changed", input)
+		}
+		if raw, _ := BuildAck(a); raw != input {
+			t.Errorf("%s: original changed, now builds as %q", input, raw)
+		}
+	}
+
+	a, _ := ParseAck("ACK|OK|[temp:=21#C]")
+	c := a.Clone()
+	*c.Detail.Vars.Variables[0].Unit = "F"
+	if *a.Detail.Vars.Variables[0].Unit != "C" {
+		t.Error("variables detail shared")
+	}
+	a, _ = ParseAck("ACK|CMD|fw >xdead")
+	c = a.Clone()
+	c.Detail.Cmd.Binary[0] = 0
+	if a.Detail.Cmd.Binary[0] != 0xDE {
+		t.Error("command binary shared")
+	}
+
+	empty := &AckFrame{Status: AckStatusPong, Detail: &AckDetail{Pong: &PongDetail{Extra: []MetaPair{}}}}
+	if !empty.Equal(&AckFrame{Status: AckStatusPong, Detail: &AckDetail{Pong: &PongDetail{}}}) {
+		t.Error("nil and empty Extra differ")
+	}
+	if empty.Equal(nil) || !(*AckFrame)(nil).Equal(nil) {
+		t.Error("nil frame comparison")
+	}
+}
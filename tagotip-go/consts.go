@@ -12,4 +12,12 @@ const (
 	MaxFrameSize  = 16_384
 	AuthTokenLen  = 34
 	AuthHashLen   = 16
+
+	// MaxAckDetailSize caps the typed variables and PONG details of an
+	// ACK, which devices read into small receive buffers.
+	MaxAckDetailSize = 1_024
+
+	// MaxCommandBinary caps the decoded binary payload of a CMD detail, so
+	// that a command fits a single downlink on constrained links.
+	MaxCommandBinary = 512
 )
@@ -0,0 +1,389 @@
+package tagotip
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ---------------------------------------------------------------------------
+// Golden corpus
+// ---------------------------------------------------------------------------
+//
+// A golden corpus is a JSON document shared by every TagoTiP implementation:
+//
+//	{
+//	  "version": 1,
+//	  "entries": [
+//	    {"name": "...", "direction": "uplink", "input": "PUSH|...", "expect": {...}},
+//	    {"name": "...", "direction": "ack", "input": "ACK|OK|3", "expect": {...}},
+//	    {"name": "...", "direction": "envelope", "input_hex": "...", "key_hex": "...", "expect": {...}},
+//	    {"name": "...", "direction": "uplink", "input": "PUSH|x", "error": {"kind": "invalid_auth", "position": 5}}
+//	  ]
+//	}
+//
+// Each entry has either an expected structure or an expected error. The
+// expected structures use the field names of corpusUplink, corpusAck, and
+// corpusEnvelope below; optional fields are omitted when absent. String
+// values, units, and metadata values are recorded as written on the wire,
+// escapes included. Parse
+// errors are matched on kind and position; envelope failures use the kind
+// "secure" and are matched on message.
+
+// CorpusVersion is the golden corpus format version understood by
+// VerifyCorpus.
+const CorpusVersion = 1
+
+// CorpusReport is the outcome of verifying a golden corpus.
+type CorpusReport struct {
+	Results []CorpusResult
+}
+
+// CorpusResult is the outcome of one corpus entry. Failure is empty when
+// the entry passed.
+type CorpusResult struct {
+	Name      string
+	Direction string
+	Failure   string
+}
+
+// Failed returns the results of the entries that did not pass.
+func (r CorpusReport) Failed() []CorpusResult {
+	var failed []CorpusResult
+	for _, res := range r.Results {
+		if res.Failure != "" {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// VerifyCorpus runs every entry of the golden corpus at path against this
+// implementation. The error reports only problems reading or decoding the
+// file; mismatches are recorded in the report.
+func VerifyCorpus(path string) (CorpusReport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return CorpusReport{}, fmt.Errorf("tagotip: read corpus: %w", err)
+	}
+	var file corpusFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return CorpusReport{}, fmt.Errorf("tagotip: decode corpus %s: %w", path, err)
+	}
+	if file.Version != CorpusVersion {
+		return CorpusReport{}, fmt.Errorf("tagotip: unsupported corpus version %d", file.Version)
+	}
+
+	report := CorpusReport{Results: make([]CorpusResult, 0, len(file.Entries))}
+	for i := range file.Entries {
+		e := &file.Entries[i]
+		res := CorpusResult{Name: e.Name, Direction: e.Direction}
+		if err := e.verify(); err != nil {
+			res.Failure = err.Error()
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report, nil
+}
+
+type corpusFile struct {
+	Version int           `json:"version"`
+	Entries []corpusEntry `json:"entries"`
+}
+
+type corpusEntry struct {
+	Name      string          `json:"name"`
+	Direction string          `json:"direction"` // "uplink", "ack", or "envelope"
+	Input     string          `json:"input,omitempty"`
+	InputHex  string          `json:"input_hex,omitempty"`
+	KeyHex    string          `json:"key_hex,omitempty"`
+	Expect    json.RawMessage `json:"expect,omitempty"`
+	Error     *corpusError    `json:"error,omitempty"`
+}
+
+type corpusError struct {
+	Kind     string `json:"kind"`
+	Position int    `json:"position,omitempty"`
+	Message  string `json:"message,omitempty"`
+}
+
+type corpusUplink struct {
+	Method      string             `json:"method"`
+	Seq         *uint32            `json:"seq,omitempty"`
+	Auth        string             `json:"auth"`
+	Serial      string             `json:"serial"`
+	Push        *corpusStructured  `json:"push,omitempty"`
+	Passthrough *corpusPassthrough `json:"passthrough,omitempty"`
+	Pull        []string           `json:"pull,omitempty"`
+}
+
+type corpusStructured struct {
+	Timestamp *string          `json:"timestamp,omitempty"`
+	Group     *string          `json:"group,omitempty"`
+	Meta      [][2]string      `json:"meta,omitempty"`
+	Variables []corpusVariable `json:"variables"`
+}
+
+type corpusVariable struct {
+	Name      string          `json:"name"`
+	Operator  string          `json:"operator"` // "number", "string", "boolean", or "location"
+	Value     string          `json:"value,omitempty"`
+	Bool      bool            `json:"bool,omitempty"`
+	Location  *corpusLocation `json:"location,omitempty"`
+	Unit      *string         `json:"unit,omitempty"`
+	Timestamp *string         `json:"timestamp,omitempty"`
+	Group     *string         `json:"group,omitempty"`
+	Meta      [][2]string     `json:"meta,omitempty"`
+}
+
+type corpusLocation struct {
+	Lat string  `json:"lat"`
+	Lng string  `json:"lng"`
+	Alt *string `json:"alt,omitempty"`
+}
+
+type corpusPassthrough struct {
+	Encoding string `json:"encoding"` // "hex" or "base64"
+	Data     string `json:"data"`
+}
+
+type corpusAck struct {
+	Seq    *uint32          `json:"seq,omitempty"`
+	Status string           `json:"status"`
+	Detail *corpusAckDetail `json:"detail,omitempty"`
+}
+
+type corpusAckDetail struct {
+	Type      string `json:"type"`
+	Count     uint32 `json:"count,omitempty"`
+	Text      string `json:"text,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+type corpusEnvelope struct {
+	Method     string `json:"method"`
+	Flags      byte   `json:"flags"`
+	Counter    uint32 `json:"counter"`
+	AuthHash   string `json:"auth_hash"`
+	DeviceHash string `json:"device_hash"`
+	Inner      string `json:"inner"`
+}
+
+func (e *corpusEntry) verify() error {
+	if (e.Expect == nil) == (e.Error == nil) {
+		return errors.New("entry must have exactly one of expect and error")
+	}
+
+	var got any
+	var err error
+	var want any
+	switch e.Direction {
+	case "uplink":
+		var frame *UplinkFrame
+		if frame, err = ParseUplink(e.Input); err == nil {
+			got = corpusFromUplink(frame)
+		}
+		want = new(corpusUplink)
+	case "ack":
+		var frame *AckFrame
+		if frame, err = ParseAck(e.Input); err == nil {
+			got = corpusFromAck(frame)
+		}
+		want = new(corpusAck)
+	case "envelope":
+		got, err = e.openEnvelope()
+		want = new(corpusEnvelope)
+	default:
+		return fmt.Errorf("unknown direction %q", e.Direction)
+	}
+
+	if e.Error != nil {
+		if err == nil {
+			return fmt.Errorf("expected error %s, parsed successfully", e.Error.describe())
+		}
+		gotErr := corpusFromError(err)
+		if gotErr == nil {
+			return fmt.Errorf("expected error %s, got %v", e.Error.describe(), err)
+		}
+		if *gotErr != *e.Error {
+			return fmt.Errorf("expected error %s, got %s", e.Error.describe(), gotErr.describe())
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unexpected error: %v", err)
+	}
+
+	// Round-trip the expectation through its type so that field order and
+	// formatting in the file do not matter.
+	if err := json.Unmarshal(e.Expect, want); err != nil {
+		return fmt.Errorf("decode expect: %v", err)
+	}
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if !bytes.Equal(wantJSON, gotJSON) {
+		return fmt.Errorf("mismatch:\n  want: %s\n  got:  %s", wantJSON, gotJSON)
+	}
+	return nil
+}
+
+func (e *corpusEntry) openEnvelope() (*corpusEnvelope, error) {
+	envelope, err := hex.DecodeString(e.InputHex)
+	if err != nil {
+		return nil, fmt.Errorf("decode input_hex: %w", err)
+	}
+	key, err := hex.DecodeString(e.KeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("decode key_hex: %w", err)
+	}
+	header, method, inner, err := OpenEnvelope(envelope, key)
+	if err != nil {
+		return nil, err
+	}
+	return &corpusEnvelope{
+		Method:     envelopeMethodName(method),
+		Flags:      header.Flags,
+		Counter:    header.Counter,
+		AuthHash:   hex.EncodeToString(header.AuthHash[:]),
+		DeviceHash: hex.EncodeToString(header.DeviceHash[:]),
+		Inner:      string(inner),
+	}, nil
+}
+
+func (ce *corpusError) describe() string {
+	if ce.Kind == "secure" {
+		return fmt.Sprintf("secure %q", ce.Message)
+	}
+	return fmt.Sprintf("%s at %d", ce.Kind, ce.Position)
+}
+
+func corpusFromError(err error) *corpusError {
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		return &corpusError{Kind: string(pe.Kind), Position: pe.Position}
+	}
+	var se *SecureError
+	if errors.As(err, &se) {
+		return &corpusError{Kind: "secure", Message: se.Message}
+	}
+	return nil
+}
+
+func corpusFromUplink(f *UplinkFrame) *corpusUplink {
+	c := &corpusUplink{
+		Method: methodKeyword(f.Method),
+		Seq:    f.Seq,
+		Auth:   f.Auth,
+		Serial: f.Serial,
+	}
+	if pb := f.PushBody; pb != nil {
+		if pb.IsPassthrough && pb.Passthrough != nil {
+			enc := "hex"
+			if pb.Passthrough.Encoding == PassthroughEncodingBase64 {
+				enc = "base64"
+			}
+			c.Passthrough = &corpusPassthrough{Encoding: enc, Data: pb.Passthrough.Data}
+		} else if sb := pb.Structured; sb != nil {
+			cs := &corpusStructured{
+				Timestamp: sb.Timestamp,
+				Group:     sb.Group,
+				Meta:      corpusFromMeta(sb.Metadata()),
+				Variables: make([]corpusVariable, len(sb.Variables)),
+			}
+			for i := range sb.Variables {
+				cs.Variables[i] = corpusFromVariable(&sb.Variables[i])
+			}
+			c.Push = cs
+		}
+	}
+	if f.PullBody != nil {
+		c.Pull = f.PullBody.Variables
+	}
+	return c
+}
+
+func corpusFromVariable(v *Variable) corpusVariable {
+	cv := corpusVariable{
+		Name:      v.Name,
+		Operator:  operatorName(v.Operator),
+		Unit:      escapedPtr(v.Unit),
+		Timestamp: v.Timestamp,
+		Group:     v.Group,
+		Meta:      corpusFromMeta(v.Metadata()),
+	}
+	switch v.Operator {
+	case OperatorBoolean:
+		cv.Bool = v.Value.Bool
+	case OperatorLocation:
+		if loc := v.Value.Location; loc != nil {
+			cv.Location = &corpusLocation{Lat: loc.Lat, Lng: loc.Lng, Alt: loc.Alt}
+		}
+	case OperatorString:
+		cv.Value = Escape(v.Value.Str)
+	default:
+		cv.Value = v.Value.Str
+	}
+	return cv
+}
+
+func escapedPtr(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	e := Escape(*s)
+	return &e
+}
+
+func corpusFromMeta(pairs []MetaPair) [][2]string {
+	if len(pairs) == 0 {
+		return nil
+	}
+	out := make([][2]string, len(pairs))
+	for i, p := range pairs {
+		out[i] = [2]string{p.Key, Escape(p.Value)}
+	}
+	return out
+}
+
+func corpusFromAck(f *AckFrame) *corpusAck {
+	c := &corpusAck{Seq: f.Seq, Status: ackStatusKeyword(f.Status)}
+	if d := f.Detail; d != nil {
+		cd := &corpusAckDetail{Type: d.Type, Count: d.Count, Text: d.Text}
+		if d.Type == "error" {
+			cd.ErrorCode = errorCodeName(d.ErrorCode)
+		}
+		c.Detail = cd
+	}
+	return c
+}
+
+func operatorName(op Operator) string {
+	switch op {
+	case OperatorNumber:
+		return "number"
+	case OperatorString:
+		return "string"
+	case OperatorBoolean:
+		return "boolean"
+	case OperatorLocation:
+		return "location"
+	}
+	return ""
+}
+
+func envelopeMethodName(m EnvelopeMethod) string {
+	switch m {
+	case EnvelopeMethodPush:
+		return "PUSH"
+	case EnvelopeMethodPull:
+		return "PULL"
+	case EnvelopeMethodPing:
+		return "PING"
+	case EnvelopeMethodAck:
+		return "ACK"
+	}
+	return ""
+}
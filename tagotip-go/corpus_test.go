@@ -0,0 +1,55 @@
+package tagotip_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tagotip "github.com/tago-io/tagotip-sdk/tagotip-go"
+	"github.com/tago-io/tagotip-sdk/tagotip-go/tagotiptest"
+)
+
+func TestGoldenCorpus(t *testing.T) {
+	tagotiptest.RunCorpus(t, "testdata/golden.json")
+}
+
+func TestVerifyCorpusReportsMismatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.json")
+	corpus := `{"version": 1, "entries": [
+		{"name": "ok", "direction": "ack", "input": "ACK|OK|3", "expect": {"status": "OK", "detail": {"type": "count", "count": 3}}},
+		{"name": "wrong-field", "direction": "ack", "input": "ACK|OK|3", "expect": {"status": "OK", "detail": {"type": "count", "count": 4}}},
+		{"name": "wrong-error", "direction": "uplink", "input": "PUSH|bad", "error": {"kind": "invalid_auth", "position": 4}},
+		{"name": "no-error", "direction": "uplink", "input": "PING|at0123456789abcdef0123456789abcdef|d", "error": {"kind": "invalid_auth", "position": 5}},
+		{"name": "bad-direction", "direction": "sideways", "input": "x", "expect": {}}
+	]}`
+	if err := os.WriteFile(path, []byte(corpus), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := tagotip.VerifyCorpus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := report.Failed()
+	if len(report.Results) != 5 || len(failed) != 4 {
+		t.Fatalf("expected 4 of 5 entries to fail, got %d of %d", len(failed), len(report.Results))
+	}
+	for i, name := range []string{"wrong-field", "wrong-error", "no-error", "bad-direction"} {
+		if failed[i].Name != name {
+			t.Errorf("failure %d: got %q, want %q", i, failed[i].Name, name)
+		}
+	}
+	if !strings.Contains(failed[1].Failure, "invalid_auth at 5") {
+		t.Errorf("error mismatch not described: %s", failed[1].Failure)
+	}
+}
+
+func TestVerifyCorpusRejectsUnknownVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.json")
+	if err := os.WriteFile(path, []byte(`{"version": 99, "entries": []}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tagotip.VerifyCorpus(path); err == nil {
+		t.Error("expected error for unknown version")
+	}
+}
@@ -0,0 +1,163 @@
+package tagotip
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// CSV export
+// ---------------------------------------------------------------------------
+//
+// WriteCSV lays the variables of PUSH frames out one per row, for reading
+// in a spreadsheet:
+//
+//	serial,variable,type,value,unit,timestamp,group,metadata
+//	sensor-01,temp,number,20,C,2023-11-14T22:13:20Z,,
+//	sensor-01,temp,number,21,C,2023-11-14T22:13:21Z,,src=dht;fw=2
+//
+// As in ToTagoData, a variable without its own timestamp or group takes
+// the body-level one, and its metadata follows the body-level pairs it does
+// not override.
+
+// csvHeader names the columns WriteCSV writes.
+var csvHeader = []string{"serial", "variable", "type", "value", "unit", "timestamp", "group", "metadata"}
+
+// CSVOptions configures WriteCSVWithOptions.
+type CSVOptions struct {
+	// Passthrough writes a passthrough body as a row of type
+	// "passthrough" with the payload in hex as its value, and no variable
+	// name. Without it passthrough bodies are skipped.
+	Passthrough bool
+}
+
+// WriteCSV writes the variables of frames to w as CSV, one row per
+// variable after a header row. Frames other than PUSH, and passthrough
+// bodies, write no rows.
+func WriteCSV(w io.Writer, frames ...*UplinkFrame) error {
+	_, err := WriteCSVWithOptions(w, nil, frames...)
+	return err
+}
+
+// WriteCSVWithOptions is WriteCSV applying opts, and returns the number of
+// passthrough bodies skipped. A nil opts is equivalent to the zero value.
+//
+// Numbers are written as in the frame, booleans as true or false, and
+// locations as lat,lng[,alt]. Timestamps are written in RFC 3339, in UTC,
+// with milliseconds when not zero; one out of the range of time.Time is an
+// error. Metadata pairs are written as key=value, separated by ';'.
+func WriteCSVWithOptions(w io.Writer, opts *CSVOptions, frames ...*UplinkFrame) (skipped int, err error) {
+	var o CSVOptions
+	if opts != nil {
+		o = *opts
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return 0, err
+	}
+	row := make([]string, len(csvHeader))
+	for _, f := range frames {
+		if f.Method != MethodPush || f.PushBody == nil {
+			continue
+		}
+		if pb := f.PushBody; pb.IsPassthrough {
+			if !o.Passthrough || pb.Passthrough == nil {
+				skipped++
+				continue
+			}
+			data, err := pb.Passthrough.Bytes()
+			if err != nil {
+				return skipped, err
+			}
+			clear(row)
+			row[0], row[2], row[3] = f.Serial, "passthrough", hex.EncodeToString(data)
+			if err := cw.Write(row); err != nil {
+				return skipped, err
+			}
+			continue
+		}
+		if err := writeCSVBody(cw, row, f.Serial, f.PushBody.Structured); err != nil {
+			return skipped, err
+		}
+	}
+	cw.Flush()
+	return skipped, cw.Error()
+}
+
+func writeCSVBody(cw *csv.Writer, row []string, serial string, sb *StructuredBody) error {
+	if sb == nil {
+		return nil
+	}
+	bodyMeta := sb.Metadata()
+	var meta strings.Builder
+	for i := range sb.Variables {
+		v := &sb.Variables[i]
+		clear(row)
+		row[0], row[1], row[2] = serial, v.Name, v.Operator.String()
+		switch v.Operator {
+		case OperatorNumber, OperatorString:
+			row[3] = v.Value.Str
+		case OperatorBoolean:
+			row[3] = strconv.FormatBool(v.Value.Bool)
+		case OperatorLocation:
+			if l := v.Value.Location; l != nil {
+				row[3] = l.Lat + "," + l.Lng
+				if l.Alt != nil {
+					row[3] += "," + *l.Alt
+				}
+			}
+		}
+		if v.Unit != nil {
+			row[4] = *v.Unit
+		}
+
+		ts := v.Timestamp
+		if ts == nil {
+			ts = sb.Timestamp
+		}
+		if ts != nil {
+			t, _, err := timestampTime(ts)
+			if err != nil {
+				return fmt.Errorf("tagotip: variable %q: %w", v.Name, err)
+			}
+			row[5] = t.UTC().Format(time.RFC3339Nano)
+		}
+		switch {
+		case v.Group != nil:
+			row[6] = *v.Group
+		case sb.Group != nil:
+			row[6] = *sb.Group
+		}
+
+		meta.Reset()
+		varMeta := v.Metadata()
+		for _, p := range bodyMeta {
+			if !hasMetaKey(varMeta, p.Key) {
+				appendCSVMeta(&meta, p)
+			}
+		}
+		for _, p := range varMeta {
+			appendCSVMeta(&meta, p)
+		}
+		row[7] = meta.String()
+
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendCSVMeta(b *strings.Builder, p MetaPair) {
+	if b.Len() > 0 {
+		b.WriteByte(';')
+	}
+	b.WriteString(p.Key)
+	b.WriteByte('=')
+	b.WriteString(p.Value)
+}
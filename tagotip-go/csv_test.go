@@ -0,0 +1,70 @@
+package tagotip
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// CSV export
+// ============================================================================
+
+func TestWriteCSV(t *testing.T) {
+	frames := []*UplinkFrame{
+		mustParse(t, "PUSH|"+testAuth+"|logger-1|^run{src=dht,fw=2}"+
+			"[temp:=20#C@1700000000000;temp:=21#C@1700000001250{fw=3};note=a\\,b;pos@=1.5,-2,30;ok?=false]"),
+		mustParse(t, "PING|"+testAuth+"|logger-1"),
+		mustParse(t, "PUSH|"+testAuth+"|logger-2|>xDEADBEEF"),
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, frames...); err != nil {
+		t.Fatal(err)
+	}
+	want := "serial,variable,type,value,unit,timestamp,group,metadata\n" +
+		"logger-1,temp,number,20,C,2023-11-14T22:13:20Z,run,src=dht;fw=2\n" +
+		"logger-1,temp,number,21,C,2023-11-14T22:13:21.25Z,run,src=dht;fw=3\n" +
+		"logger-1,note,string,\"a,b\",,,run,src=dht;fw=2\n" +
+		"logger-1,pos,location,\"1.5,-2,30\",,,run,src=dht;fw=2\n" +
+		"logger-1,ok,boolean,false,,,run,src=dht;fw=2\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	// The output reads back with encoding/csv.
+	rows, err := csv.NewReader(strings.NewReader(buf.String())).ReadAll()
+	if err != nil || len(rows) != 6 || rows[3][3] != "a,b" {
+		t.Errorf("read back %q, %v", rows, err)
+	}
+}
+
+func TestWriteCSVPassthrough(t *testing.T) {
+	frames := []*UplinkFrame{
+		mustParse(t, "PUSH|"+testAuth+"|logger-2|>xDEADBEEF"),
+		mustParse(t, "PUSH|"+testAuth+"|logger-2|>b3q2+7w=="),
+		mustParse(t, "PUSH|"+testAuth+"|logger-2|[a:=1]"),
+	}
+	var buf bytes.Buffer
+	skipped, err := WriteCSVWithOptions(&buf, nil, frames...)
+	if err != nil || skipped != 2 || strings.Count(buf.String(), "\n") != 2 {
+		t.Errorf("skipping: %d skipped, %v\n%s", skipped, err, buf.String())
+	}
+
+	buf.Reset()
+	skipped, err = WriteCSVWithOptions(&buf, &CSVOptions{Passthrough: true}, frames...)
+	want := "serial,variable,type,value,unit,timestamp,group,metadata\n" +
+		"logger-2,,passthrough,deadbeef,,,,\n" +
+		"logger-2,,passthrough,deadbeef,,,,\n" +
+		"logger-2,a,number,1,,,,\n"
+	if err != nil || skipped != 0 || buf.String() != want {
+		t.Errorf("hex rows: %d skipped, %v\n%s", skipped, err, buf.String())
+	}
+}
+
+func TestWriteCSVTimestampOutOfRange(t *testing.T) {
+	f := mustParse(t, "PUSH|"+testAuth+"|logger-1|[a:=1@99999999999999999999]")
+	if err := WriteCSV(&bytes.Buffer{}, f); err == nil {
+		t.Error("out-of-range timestamp accepted")
+	}
+}
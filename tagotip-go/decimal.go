@@ -0,0 +1,123 @@
+package tagotip
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Decimal is an exact decimal number in the frame's number syntax: an
+// optional '-', an integer part without leading zeros, and an optional
+// fraction. It keeps the digits as written, so values beyond the 53-bit
+// mantissa of a float64, such as the readings of billing meters, survive
+// comparison and re-serialization unchanged. It does no arithmetic.
+//
+// The zero value is the number 0.
+type Decimal struct {
+	s string
+}
+
+// ParseDecimal parses s as a Decimal. Exponents, a leading '+', and leading
+// zeros are rejected, as they are in frames.
+func ParseDecimal(s string) (Decimal, error) {
+	if validateNumber(s, 0) != nil {
+		return Decimal{}, fmt.Errorf("tagotip: %q is not a decimal number", s)
+	}
+	return Decimal{s: s}, nil
+}
+
+// Decimal returns the exact value of a number. It fails with ErrNotNumber
+// if v is not a number.
+func (v Value) Decimal() (Decimal, error) {
+	if v.Type != OperatorNumber {
+		return Decimal{}, ErrNotNumber
+	}
+	return ParseDecimal(v.Str)
+}
+
+// DecimalValue returns the number value holding d, to be used with
+// OperatorNumber.
+func DecimalValue(d Decimal) Value {
+	return Value{Type: OperatorNumber, Str: d.String()}
+}
+
+// String returns d as it was parsed.
+func (d Decimal) String() string {
+	if d.s == "" {
+		return "0"
+	}
+	return d.s
+}
+
+// MarshalJSON writes d as a JSON number with its digits as parsed.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON reads a JSON number in the frame's number syntax.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	parsed, err := ParseDecimal(string(data))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// Sign returns -1, 0, or +1 depending on whether d is negative, zero, or
+// positive. "-0" and "0.00" are zero.
+func (d Decimal) Sign() int {
+	neg, intPart, frac := d.parts()
+	if intPart == "0" && frac == "" {
+		return 0
+	}
+	if neg {
+		return -1
+	}
+	return 1
+}
+
+// Cmp compares d and e by value and returns -1, 0, or +1. Trailing zeros in
+// the fraction do not matter: "1.50" equals "1.5".
+func (d Decimal) Cmp(e Decimal) int {
+	ds, es := d.Sign(), e.Sign()
+	if ds != es {
+		if ds < es {
+			return -1
+		}
+		return 1
+	}
+	if ds == 0 {
+		return 0
+	}
+	c := d.cmpAbs(e)
+	if ds < 0 {
+		return -c
+	}
+	return c
+}
+
+func (d Decimal) cmpAbs(e Decimal) int {
+	_, di, df := d.parts()
+	_, ei, ef := e.parts()
+	if len(di) != len(ei) {
+		if len(di) < len(ei) {
+			return -1
+		}
+		return 1
+	}
+	if c := strings.Compare(di, ei); c != 0 {
+		return c
+	}
+	// With trailing zeros trimmed, a fraction that is a prefix of another
+	// is the smaller one, as strings.Compare has it.
+	return strings.Compare(df, ef)
+}
+
+// parts splits d into its sign, integer digits, and fraction digits without
+// trailing zeros.
+func (d Decimal) parts() (neg bool, intPart, frac string) {
+	s := d.String()
+	s, neg = strings.CutPrefix(s, "-")
+	intPart, frac, _ = strings.Cut(s, ".")
+	return neg, intPart, strings.TrimRight(frac, "0")
+}
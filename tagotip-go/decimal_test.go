@@ -0,0 +1,115 @@
+package tagotip
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+// ============================================================================
+// Decimal
+// ============================================================================
+
+// Values whose digits a float64 cannot hold.
+var wideDecimals = []string{
+	"12345678901234567.891",
+	"-9007199254740993",
+	"0.10000000000000000555",
+	"123456789012345678901234567890",
+}
+
+func TestDecimalRoundTripBeyondFloat64(t *testing.T) {
+	for _, s := range wideDecimals {
+		f, _ := strconv.ParseFloat(s, 64)
+		if strconv.FormatFloat(f, 'f', -1, 64) == s {
+			t.Fatalf("%s: fits a float64, not a useful case", s)
+		}
+
+		raw := "PUSH|" + testAuth + "|meter|[energy:=" + s + "#kWh]"
+		frame := mustParse(t, raw)
+		d, err := frame.PushBody.Structured.Variables[0].Value.Decimal()
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if d.String() != s {
+			t.Errorf("String() = %q, want %q", d, s)
+		}
+
+		js, err := json.Marshal(map[string]Decimal{"value": d})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := `{"value":` + s + `}`; string(js) != want {
+			t.Errorf("json = %s, want %s", js, want)
+		}
+		var back map[string]Decimal
+		if err := json.Unmarshal(js, &back); err != nil {
+			t.Fatal(err)
+		}
+		if back["value"] != d {
+			t.Errorf("json round trip = %q, want %q", back["value"], s)
+		}
+
+		frame.PushBody.Structured.Variables[0].Value = DecimalValue(back["value"])
+		rebuilt, err := BuildUplink(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rebuilt != raw {
+			t.Errorf("rebuilt %q, want %q", rebuilt, raw)
+		}
+	}
+}
+
+func TestParseDecimalRejects(t *testing.T) {
+	for _, s := range []string{"", "-", "+1", "01", "1.", ".5", "1e5", "1.5.2", "NaN", " 1"} {
+		if _, err := ParseDecimal(s); err == nil {
+			t.Errorf("ParseDecimal(%q) succeeded", s)
+		}
+	}
+	if _, err := (Value{Type: OperatorString, Str: "12"}).Decimal(); err == nil {
+		t.Error("Decimal() of a string value succeeded")
+	}
+	var d Decimal
+	if err := json.Unmarshal([]byte("1e3"), &d); err == nil {
+		t.Error("json.Unmarshal accepted an exponent")
+	}
+}
+
+func TestDecimalCmp(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"0", "-0", 0},
+		{"0.00", "0", 0},
+		{"1.50", "1.5", 0},
+		{"1.5", "1.51", -1},
+		{"9007199254740993", "9007199254740992", 1},
+		{"12345678901234567.891", "12345678901234567.8901", 1},
+		{"-10", "-9", -1},
+		{"-0.5", "0.1", -1},
+		{"100", "99.999", 1},
+		{"-12345678901234567.891", "-12345678901234567.89", -1},
+	}
+	for _, tt := range tests {
+		a, err := ParseDecimal(tt.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseDecimal(tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Cmp(b); got != tt.want {
+			t.Errorf("Cmp(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := b.Cmp(a); got != -tt.want {
+			t.Errorf("Cmp(%s, %s) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+	var zero Decimal
+	if zero.String() != "0" || zero.Sign() != 0 {
+		t.Errorf("zero Decimal = %q, sign %d", zero, zero.Sign())
+	}
+}
@@ -0,0 +1,243 @@
+package tagotip
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// VariableIDMetaKey is the variable-level metadata key carrying a
+// device-assigned point identifier. A device numbers the points of each
+// variable with increasing decimal values, so that a server can drop the
+// points it already stored when a datalogger retransmits an overlapping
+// batch:
+//
+//	PUSH|...|logger|[temp:=21.5@1700000000000{id=41};temp:=21.7@1700000060000{id=42}]
+//
+// Frame-level idempotency (see IdempotencyMetaKey) cannot catch such a
+// partial overlap, since the retransmitted frame differs from the first.
+const VariableIDMetaKey = "id"
+
+// ValidateVariableID checks that s is a decimal uint64 without leading
+// zeros.
+func ValidateVariableID(s string) error {
+	_, err := parseVariableID(s)
+	return err
+}
+
+func parseVariableID(s string) (uint64, error) {
+	if len(s) == 0 || (len(s) > 1 && s[0] == '0') {
+		return 0, fmt.Errorf("tagotip: variable id must be a decimal number without leading zeros")
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, fmt.Errorf("tagotip: variable id must be a decimal number without leading zeros")
+		}
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("tagotip: variable id %s overflows uint64", s)
+	}
+	return id, nil
+}
+
+// StampVariableID sets the id=<id> metadata pair on v, replacing any
+// existing one.
+func StampVariableID(v *Variable, id uint64) error {
+	if v == nil {
+		return fmt.Errorf("tagotip: nil variable")
+	}
+	value := strconv.FormatUint(id, 10)
+	v.Metadata()
+	for i := range v.Meta {
+		if v.Meta[i].Key == VariableIDMetaKey {
+			v.Meta[i].Value = value
+			return nil
+		}
+	}
+	if len(v.Meta) >= MaxMetaPairs {
+		return fmt.Errorf("tagotip: variable metadata already has %d pairs", MaxMetaPairs)
+	}
+	v.Meta = append(v.Meta, MetaPair{Key: VariableIDMetaKey, Value: value})
+	return nil
+}
+
+// VariableID returns the identifier carried by v's metadata. ok is false
+// when v has no id pair or its value is malformed.
+func VariableID(v *Variable) (id uint64, ok bool) {
+	for _, p := range v.Metadata() {
+		if p.Key == VariableIDMetaKey {
+			id, err := parseVariableID(p.Value)
+			return id, err == nil
+		}
+	}
+	return 0, false
+}
+
+// VariableDedupStore persists the highest id stored for each (serial,
+// variable) pair, so that deduplication survives restarts and pairs
+// evicted from memory. Its methods are called with the VariableDedup's lock
+// held and must not call back into it.
+type VariableDedupStore interface {
+	// LoadHighest returns the highest id recorded for the pair, if any.
+	LoadHighest(serial, name string) (id uint64, ok bool)
+	// SaveHighest records a new highest id for the pair.
+	SaveHighest(serial, name string, id uint64)
+}
+
+// VariableDedupOptions configures a VariableDedup. The zero value keeps
+// state in memory only and ACKs the full variable count.
+type VariableDedupOptions struct {
+	// Store, if non-nil, is consulted for pairs not held in memory and
+	// told of every new highest id.
+	Store VariableDedupStore
+
+	// AckStored makes FilterNewVariables report the number of variables
+	// returned for storage instead of the number received.
+	AckStored bool
+}
+
+type dedupID struct {
+	serial string
+	name   string
+}
+
+type dedupEntry struct {
+	dedupID
+	highest uint64
+}
+
+// VariableDedup tracks the highest point id seen for each (serial,
+// variable) pair. It holds at most maxEntries pairs in memory, evicting the
+// least recently used one first. It is safe for concurrent use.
+//
+// Ids are expected to increase per variable: a point whose id does not
+// exceed the highest seen is treated as a duplicate, so points delivered
+// out of order after a newer one are dropped.
+type VariableDedup struct {
+	mu         sync.Mutex
+	maxEntries int
+	opts       VariableDedupOptions
+	entries    map[dedupID]*list.Element
+	order      *list.List // front = least recently used
+}
+
+// NewVariableDedup creates a tracker bounded to maxEntries pairs. A nil
+// opts is equivalent to the zero value. maxEntries must be positive.
+func NewVariableDedup(maxEntries int, opts *VariableDedupOptions) *VariableDedup {
+	if maxEntries <= 0 {
+		panic("tagotip: VariableDedup maxEntries must be positive")
+	}
+	d := &VariableDedup{
+		maxEntries: maxEntries,
+		entries:    make(map[dedupID]*list.Element),
+		order:      list.New(),
+	}
+	if opts != nil {
+		d.opts = *opts
+	}
+	return d
+}
+
+// Admit reports whether id is new for (serial, name), recording it as the
+// highest id if so.
+func (d *VariableDedup) Admit(serial, name string, id uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.admit(serial, name, id)
+}
+
+func (d *VariableDedup) admit(serial, name string, id uint64) bool {
+	key := dedupID{serial: serial, name: name}
+	el, ok := d.entries[key]
+	if !ok {
+		entry := &dedupEntry{dedupID: key}
+		if d.opts.Store != nil {
+			if highest, found := d.opts.Store.LoadHighest(serial, name); found {
+				if id <= highest {
+					// Cache the mark so repeated duplicates skip the store.
+					entry.highest = highest
+					d.insert(entry)
+					return false
+				}
+			}
+		}
+		entry.highest = id
+		d.insert(entry)
+		d.save(entry)
+		return true
+	}
+	d.order.MoveToBack(el)
+	entry := el.Value.(*dedupEntry)
+	if id <= entry.highest {
+		return false
+	}
+	entry.highest = id
+	d.save(entry)
+	return true
+}
+
+func (d *VariableDedup) insert(entry *dedupEntry) {
+	for d.order.Len() >= d.maxEntries {
+		evicted := d.order.Remove(d.order.Front()).(*dedupEntry)
+		delete(d.entries, evicted.dedupID)
+	}
+	d.entries[entry.dedupID] = d.order.PushBack(entry)
+}
+
+func (d *VariableDedup) save(entry *dedupEntry) {
+	if d.opts.Store != nil {
+		d.opts.Store.SaveHighest(entry.serial, entry.name, entry.highest)
+	}
+}
+
+// Highest returns the highest id recorded in memory for (serial, name).
+func (d *VariableDedup) Highest(serial, name string) (id uint64, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	el, ok := d.entries[dedupID{serial: serial, name: name}]
+	if !ok {
+		return 0, false
+	}
+	return el.Value.(*dedupEntry).highest, true
+}
+
+// Len returns the number of pairs currently held in memory.
+func (d *VariableDedup) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.order.Len()
+}
+
+// FilterNewVariables returns the variables of a structured PUSH that have
+// not been seen before, in frame order, together with the count to send
+// back in "ACK|OK|<count>": all variables received, or only those returned
+// when the tracker was created with AckStored.
+//
+// Variables without a well-formed id are always returned. The ids of the
+// returned variables are recorded as seen, so they should be handed to
+// storage; a frame that is not a structured PUSH yields no variables and a
+// count of zero.
+func FilterNewVariables(frame *UplinkFrame, dedup *VariableDedup) (fresh []Variable, ackCount uint32) {
+	if frame == nil || frame.PushBody == nil || frame.PushBody.Structured == nil {
+		return nil, 0
+	}
+	vars := frame.PushBody.Structured.Variables
+	fresh = make([]Variable, 0, len(vars))
+
+	dedup.mu.Lock()
+	for i := range vars {
+		v := &vars[i]
+		if id, ok := VariableID(v); ok && !dedup.admit(frame.Serial, v.Name, id) {
+			continue
+		}
+		fresh = append(fresh, *v)
+	}
+	dedup.mu.Unlock()
+
+	if dedup.opts.AckStored {
+		return fresh, uint32(len(fresh))
+	}
+	return fresh, uint32(len(vars))
+}
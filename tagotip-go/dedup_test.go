@@ -0,0 +1,223 @@
+package tagotip
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// =========================================================================
+// Variable ids
+// =========================================================================
+
+func TestValidateVariableID(t *testing.T) {
+	for _, s := range []string{"0", "1", "42", "18446744073709551615"} {
+		if err := ValidateVariableID(s); err != nil {
+			t.Errorf("%q: %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "01", "-1", "1.5", "x", "18446744073709551616"} {
+		if err := ValidateVariableID(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestStampVariableIDRoundTrip(t *testing.T) {
+	frame, err := ParseUplink("PUSH|" + testAuth + "|logger|[temp:=21{src=a,id=1}]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &frame.PushBody.Structured.Variables[0]
+	if err := StampVariableID(v, 77); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := BuildUplink(frame)
+	if want := "PUSH|" + testAuth + "|logger|[temp:=21{src=a,id=77}]"; raw != want {
+		t.Fatalf("got %q, want %q", raw, want)
+	}
+	parsed, err := ParseUplinkWithOptions(raw, &ParserOptions{LazyMeta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := VariableID(&parsed.PushBody.Structured.Variables[0]); !ok || id != 77 {
+		t.Errorf("VariableID = %d, %v", id, ok)
+	}
+}
+
+func TestVariableIDAbsentOrMalformed(t *testing.T) {
+	for _, meta := range []string{"", "{src=a}", "{id=01}", "{id=abc}"} {
+		frame, err := ParseUplink("PUSH|" + testAuth + "|logger|[temp:=21" + meta + "]")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := VariableID(&frame.PushBody.Structured.Variables[0]); ok {
+			t.Errorf("%q: unexpected id", meta)
+		}
+	}
+}
+
+// =========================================================================
+// VariableDedup
+// =========================================================================
+
+// loggerBatch builds a PUSH of temp points with ids from..to.
+func loggerBatch(t *testing.T, from, to int) *UplinkFrame {
+	t.Helper()
+	var b strings.Builder
+	for id := from; id <= to; id++ {
+		if id > from {
+			b.WriteByte(';')
+		}
+		b.WriteString("temp:=" + strconv.Itoa(20+id) + "{id=" + strconv.Itoa(id) + "}")
+	}
+	frame, err := ParseUplink("PUSH|" + testAuth + "|logger|[" + b.String() + "]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func variableIDs(vars []Variable) []uint64 {
+	var ids []uint64
+	for i := range vars {
+		id, _ := VariableID(&vars[i])
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestFilterNewVariablesPartialOverlap(t *testing.T) {
+	dedup := NewVariableDedup(16, nil)
+	fresh, count := FilterNewVariables(loggerBatch(t, 1, 5), dedup)
+	if !reflect.DeepEqual(variableIDs(fresh), []uint64{1, 2, 3, 4, 5}) || count != 5 {
+		t.Fatalf("first batch: ids %v, count %d", variableIDs(fresh), count)
+	}
+	// The retransmission overlaps points 4 and 5.
+	fresh, count = FilterNewVariables(loggerBatch(t, 4, 8), dedup)
+	if !reflect.DeepEqual(variableIDs(fresh), []uint64{6, 7, 8}) || count != 5 {
+		t.Fatalf("overlapping batch: ids %v, count %d", variableIDs(fresh), count)
+	}
+	fresh, _ = FilterNewVariables(loggerBatch(t, 2, 8), dedup)
+	if len(fresh) != 0 {
+		t.Errorf("full duplicate: ids %v", variableIDs(fresh))
+	}
+	if id, ok := dedup.Highest("logger", "temp"); !ok || id != 8 {
+		t.Errorf("Highest = %d, %v", id, ok)
+	}
+}
+
+func TestFilterNewVariablesAckStored(t *testing.T) {
+	dedup := NewVariableDedup(16, &VariableDedupOptions{AckStored: true})
+	FilterNewVariables(loggerBatch(t, 1, 5), dedup)
+	fresh, count := FilterNewVariables(loggerBatch(t, 4, 8), dedup)
+	if len(fresh) != 3 || count != 3 {
+		t.Errorf("got %d variables, count %d; want 3, 3", len(fresh), count)
+	}
+}
+
+func TestFilterNewVariablesScopedAndUnmarked(t *testing.T) {
+	dedup := NewVariableDedup(16, nil)
+	frame, err := ParseUplink("PUSH|" + testAuth + "|logger|[temp:=1{id=3};hum:=2{id=3};temp:=3;temp:=4{id=2}]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh, count := FilterNewVariables(frame, dedup)
+	var got []string
+	for _, v := range fresh {
+		got = append(got, v.Name+"="+v.Value.Str)
+	}
+	// Ids are per variable; a point without an id is always kept; id 2
+	// after id 3 is a duplicate.
+	if want := []string{"temp=1", "hum=2", "temp=3"}; !reflect.DeepEqual(got, want) || count != 4 {
+		t.Errorf("got %v, count %d", got, count)
+	}
+	other := loggerBatch(t, 1, 3)
+	other.Serial = "other"
+	if fresh, _ := FilterNewVariables(other, dedup); len(fresh) != 3 {
+		t.Errorf("ids leaked across serials: %v", variableIDs(fresh))
+	}
+}
+
+func TestFilterNewVariablesNonPush(t *testing.T) {
+	dedup := NewVariableDedup(4, nil)
+	for _, input := range []string{
+		"PING|" + testAuth + "|logger",
+		"PULL|" + testAuth + "|logger|[temp]",
+		"PUSH|" + testAuth + "|logger|>x0102",
+	} {
+		frame, err := ParseUplink(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fresh, count := FilterNewVariables(frame, dedup); fresh != nil || count != 0 {
+			t.Errorf("%q: got %v, %d", input, fresh, count)
+		}
+	}
+}
+
+func TestVariableDedupBounded(t *testing.T) {
+	dedup := NewVariableDedup(2, nil)
+	dedup.Admit("logger", "a", 5)
+	dedup.Admit("logger", "b", 5)
+	dedup.Admit("logger", "a", 6) // a is now the most recently used
+	dedup.Admit("logger", "c", 5)
+	if dedup.Len() != 2 {
+		t.Fatalf("Len = %d", dedup.Len())
+	}
+	if _, ok := dedup.Highest("logger", "b"); ok {
+		t.Error("least recently used pair should have been evicted")
+	}
+	if id, ok := dedup.Highest("logger", "a"); !ok || id != 6 {
+		t.Errorf("a: %d, %v", id, ok)
+	}
+}
+
+type mapDedupStore struct {
+	marks map[dedupID]uint64
+	loads int
+}
+
+func (s *mapDedupStore) LoadHighest(serial, name string) (uint64, bool) {
+	s.loads++
+	id, ok := s.marks[dedupID{serial, name}]
+	return id, ok
+}
+
+func (s *mapDedupStore) SaveHighest(serial, name string, id uint64) {
+	s.marks[dedupID{serial, name}] = id
+}
+
+func TestVariableDedupStore(t *testing.T) {
+	store := &mapDedupStore{marks: map[dedupID]uint64{}}
+	first := NewVariableDedup(1, &VariableDedupOptions{Store: store})
+	FilterNewVariables(loggerBatch(t, 1, 5), first)
+	if store.marks[dedupID{"logger", "temp"}] != 5 {
+		t.Fatalf("store = %v", store.marks)
+	}
+
+	// A restarted server, or one that evicted the pair, resumes from the
+	// store.
+	store.loads = 0
+	restarted := NewVariableDedup(1, &VariableDedupOptions{Store: store})
+	fresh, _ := FilterNewVariables(loggerBatch(t, 3, 7), restarted)
+	if !reflect.DeepEqual(variableIDs(fresh), []uint64{6, 7}) {
+		t.Errorf("after restart: ids %v", variableIDs(fresh))
+	}
+	if store.marks[dedupID{"logger", "temp"}] != 7 {
+		t.Errorf("store = %v", store.marks)
+	}
+	if store.loads != 1 {
+		t.Errorf("store loaded %d times, want 1", store.loads)
+	}
+}
+
+func TestNewVariableDedupPanicsOnNonPositive(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	NewVariableDedup(0, nil)
+}
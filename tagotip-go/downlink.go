@@ -0,0 +1,96 @@
+package tagotip
+
+import "fmt"
+
+// ---------------------------------------------------------------------------
+// Downlink envelopes
+// ---------------------------------------------------------------------------
+//
+// An ACK travels from the server to the device in an envelope whose method
+// is ACK. Its counter is the server's own, kept per device apart from the
+// device's uplink counter, so SealDownlink and OpenDownlink keep the two
+// directions from being mixed up at the call site.
+
+// SealDownlink encrypts an ACK inner frame (STATUS[|DETAIL], as written by
+// BuildAckInner) into an envelope with method ACK. counter is the server's
+// downlink counter for the device, not the counter of the uplink being
+// acknowledged.
+func SealDownlink(
+	innerAck []byte,
+	counter uint32,
+	authHash [authHashSize]byte,
+	deviceHash [deviceHashSize]byte,
+	key []byte,
+	suite CipherSuite,
+) ([]byte, error) {
+	return SealUplink(EnvelopeMethodAck, innerAck, counter, authHash, deviceHash, key, suite)
+}
+
+// OpenDownlink decrypts an envelope sealed by SealDownlink and parses its
+// inner frame with ParseAckInner. Envelopes of any other method are
+// rejected with a SecureError.
+func OpenDownlink(envelope, key []byte) (*EnvelopeHeader, *AckFrame, error) {
+	header, method, plaintext, err := OpenEnvelope(envelope, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	frame, err := parseDownlink(method, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, frame, nil
+}
+
+// parseDownlink parses the inner frame of an opened downlink envelope.
+func parseDownlink(method EnvelopeMethod, plaintext []byte) (*AckFrame, error) {
+	if method != EnvelopeMethodAck {
+		return nil, secureErr(ErrInvalidEnvelopeMethod, "not a downlink envelope")
+	}
+	return ParseAckInner(string(plaintext))
+}
+
+// SealOptions controls SealUplinkWithOptions.
+type SealOptions struct {
+	// CheckInner parses the inner frame as the envelope method requires
+	// before sealing it: as a headless frame of the method for PUSH, PULL,
+	// and PING, and with ParseAckInner for ACK. This catches an ACK sealed
+	// as a PUSH, or an uplink sealed as an ACK.
+	CheckInner bool
+}
+
+// SealUplinkWithOptions is SealUplink with the checks selected by opts. A
+// nil opts selects none.
+func SealUplinkWithOptions(
+	method EnvelopeMethod,
+	innerFrame []byte,
+	counter uint32,
+	authHash [authHashSize]byte,
+	deviceHash [deviceHashSize]byte,
+	key []byte,
+	suite CipherSuite,
+	opts *SealOptions,
+) ([]byte, error) {
+	if opts != nil && opts.CheckInner {
+		if err := checkInnerFrame(method, string(innerFrame)); err != nil {
+			return nil, err
+		}
+	}
+	return SealUplink(method, innerFrame, counter, authHash, deviceHash, key, suite)
+}
+
+// checkInnerFrame reports an inner frame that does not parse as method
+// requires.
+func checkInnerFrame(method EnvelopeMethod, inner string) error {
+	var err error
+	if m, ok := uplinkMethod(method); ok {
+		_, err = ParseHeadless(m, inner)
+	} else if method == EnvelopeMethodAck {
+		_, err = ParseAckInner(inner)
+	} else {
+		return secureErr(ErrInvalidEnvelopeMethod, "invalid method")
+	}
+	if err != nil {
+		return fmt.Errorf("tagotips: inner frame is not a %s frame: %w", envelopeMethodName(method), err)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package tagotip
+
+import (
+	"errors"
+	"testing"
+)
+
+// ============================================================================
+// Downlink envelopes
+// ============================================================================
+
+func TestSealOpenDownlink(t *testing.T) {
+	inner, err := BuildAckInner(&AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "count", Count: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := SealDownlink([]byte(inner), 7, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, method, _, err := OpenEnvelope(envelope, specKey); err != nil || method != EnvelopeMethodAck {
+		t.Fatalf("method %v, %v", method, err)
+	}
+	header, frame, err := OpenDownlink(envelope, specKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Counter != 7 || frame.Status != AckStatusOk || frame.Detail.Count != 3 {
+		t.Errorf("opened %+v, %+v", header, frame)
+	}
+}
+
+func TestOpenDownlinkRejectsUplink(t *testing.T) {
+	_, _, err := OpenDownlink(specEnvelope, specKey)
+	if !IsSecureError(err) {
+		t.Errorf("got %v", err)
+	}
+	envelope, err := SealDownlink([]byte("MAYBE|3"), 1, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pe *ParseError
+	if _, _, err := OpenDownlink(envelope, specKey); !errors.As(err, &pe) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestSealUplinkWithOptionsCheckInner(t *testing.T) {
+	check := &SealOptions{CheckInner: true}
+	for _, tc := range []struct {
+		method EnvelopeMethod
+		inner  string
+		ok     bool
+	}{
+		{EnvelopeMethodPush, "sensor-01|[temp:=32]", true},
+		{EnvelopeMethodPush, "OK|3", false},
+		{EnvelopeMethodPull, "sensor-01|[temp]", true},
+		{EnvelopeMethodPing, "sensor-01", true},
+		{EnvelopeMethodAck, "OK|3", true},
+		{EnvelopeMethodAck, "sensor-01|[temp:=32]", false},
+		{EnvelopeMethod(5), "OK", false},
+	} {
+		_, err := SealUplinkWithOptions(tc.method, []byte(tc.inner), 1, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm, check)
+		if (err == nil) != tc.ok {
+			t.Errorf("%d %q: %v", tc.method, tc.inner, err)
+		}
+	}
+	if _, err := SealUplinkWithOptions(EnvelopeMethodPush, []byte("OK|3"), 1, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm, nil); err != nil {
+		t.Errorf("unchecked: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package tagotip
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+)
+
+// ---------------------------------------------------------------------------
+// Reusable envelope ciphers
+// ---------------------------------------------------------------------------
+//
+// SealUplink and OpenEnvelope expand the key on every call. EnvelopeCipher
+// expands it once and keeps the cipher for later calls; a server holds one
+// per device key. The Append methods write into a caller's buffer, so that
+// sealing or opening into a buffer with room to spare allocates nothing for
+// the output.
+
+// EnvelopeCipher seals and opens envelopes under one key. It is safe for
+// concurrent use.
+type EnvelopeCipher struct {
+	suite CipherSuite
+	block cipher.Block
+}
+
+// NewEnvelopeCipher returns an EnvelopeCipher for key under suite.
+func NewEnvelopeCipher(key []byte, suite CipherSuite) (*EnvelopeCipher, error) {
+	info, ok := cipherSuites[suite]
+	if !ok {
+		return nil, secureErr(ErrUnsupportedSuite, "unsupported cipher suite")
+	}
+	if len(key) != info.keySize {
+		return nil, secureErr(ErrBadKeySize, "invalid encryption key size")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, secureErr(ErrBadKeySize, "invalid encryption key")
+	}
+	return &EnvelopeCipher{suite: suite, block: block}, nil
+}
+
+// Seal is SealUplink with the cipher's key and suite.
+func (c *EnvelopeCipher) Seal(
+	method EnvelopeMethod,
+	innerFrame []byte,
+	counter uint32,
+	authHash [authHashSize]byte,
+	deviceHash [deviceHashSize]byte,
+) ([]byte, error) {
+	dst := make([]byte, 0, headerSize+len(innerFrame)+ccmTagSize)
+	return c.AppendSeal(dst, method, innerFrame, counter, authHash, deviceHash)
+}
+
+// AppendSeal is AppendSealUplink with the cipher's key and suite.
+func (c *EnvelopeCipher) AppendSeal(
+	dst []byte,
+	method EnvelopeMethod,
+	innerFrame []byte,
+	counter uint32,
+	authHash [authHashSize]byte,
+	deviceHash [deviceHashSize]byte,
+) ([]byte, error) {
+	if len(innerFrame) > maxInnerFrameSize {
+		return nil, secureErr(ErrInnerTooLarge, "inner frame exceeds maximum size")
+	}
+	flags, err := encodeFlags(int(c.suite), envelopeVersion, int(method))
+	if err != nil {
+		return nil, err
+	}
+	return appendSealBlock(dst, c.block, flags, innerFrame, counter, authHash, deviceHash), nil
+}
+
+// Open is OpenEnvelope with the cipher's key. Envelopes of another cipher
+// suite are rejected.
+func (c *EnvelopeCipher) Open(envelope []byte) (*EnvelopeHeader, EnvelopeMethod, []byte, error) {
+	return c.AppendOpen(nil, envelope)
+}
+
+// AppendOpen is Open, appending the inner frame to dst and returning the
+// extended slice. When dst has enough spare capacity no allocation is made
+// for the inner frame. envelope must not overlap dst.
+func (c *EnvelopeCipher) AppendOpen(dst, envelope []byte) (*EnvelopeHeader, EnvelopeMethod, []byte, error) {
+	header, method, _, err := checkEnvelope(envelope)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if suite, _, _, _ := DecodeEnvelopeFlags(header.Flags); suite != c.suite {
+		return nil, 0, nil, secureErr(ErrUnsupportedSuite, "envelope cipher suite does not match the key")
+	}
+	out, err := appendOpenBlock(dst, c.block, envelope, header)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return header, method, out, nil
+}
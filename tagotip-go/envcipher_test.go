@@ -0,0 +1,115 @@
+package tagotip
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+// ============================================================================
+// Reusable envelope ciphers
+// ============================================================================
+
+func TestEnvelopeCipherSpecVector(t *testing.T) {
+	c, err := NewEnvelopeCipher(specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := []byte("sensor-01|[temp:=32]")
+	envelope, err := c.Seal(EnvelopeMethodPush, inner, 42, specAuthHash, specDeviceHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(envelope, specEnvelope) {
+		t.Errorf("sealed % x", envelope)
+	}
+
+	header, method, plaintext, err := c.Open(specEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != EnvelopeMethodPush || header.Counter != 42 || !bytes.Equal(plaintext, inner) {
+		t.Errorf("opened %d %+v %q", method, header, plaintext)
+	}
+}
+
+func TestEnvelopeCipherAppend(t *testing.T) {
+	c, err := NewEnvelopeCipher(specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := c.AppendSeal([]byte("prefix"), EnvelopeMethodPush, []byte("sensor-01|[temp:=32]"), 42, specAuthHash, specDeviceHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sealed[:6]) != "prefix" || !bytes.Equal(sealed[6:], specEnvelope) {
+		t.Errorf("appended % x", sealed)
+	}
+
+	buf := make([]byte, 3, 64)
+	copy(buf, "in:")
+	_, _, opened, err := c.AppendOpen(buf, specEnvelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(opened) != "in:sensor-01|[temp:=32]" || &opened[0] != &buf[0] {
+		t.Errorf("appended %q", opened)
+	}
+
+	// A failed open returns dst as given and leaves no plaintext in its
+	// spare capacity.
+	tampered := bytes.Clone(specEnvelope)
+	tampered[len(tampered)-1] ^= 1
+	_, _, _, err = c.AppendOpen(buf[:3], tampered)
+	if !IsAuthFailure(err) {
+		t.Fatalf("tampered: %v", err)
+	}
+	if spare := buf[3:23]; !bytes.Equal(spare, make([]byte, len(spare))) {
+		t.Errorf("plaintext left behind: %q", spare)
+	}
+}
+
+func TestEnvelopeCipherErrors(t *testing.T) {
+	if _, err := NewEnvelopeCipher(specKey[:5], CipherSuiteAes128Ccm); !IsSecureErrorKind(err, ErrBadKeySize) {
+		t.Errorf("short key: %v", err)
+	}
+	if _, err := NewEnvelopeCipher(specKey, CipherSuite(7)); !IsSecureErrorKind(err, ErrUnsupportedSuite) {
+		t.Errorf("unknown suite: %v", err)
+	}
+	c, err := NewEnvelopeCipher(make([]byte, 16), CipherSuiteAes128Ccm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := c.Open(specEnvelope); !IsAuthFailure(err) {
+		t.Errorf("wrong key: %v", err)
+	}
+	if _, err := c.Seal(EnvelopeMethodPush, make([]byte, maxInnerFrameSize+1), 1, specAuthHash, specDeviceHash); !IsSecureErrorKind(err, ErrInnerTooLarge) {
+		t.Errorf("large inner frame: %v", err)
+	}
+}
+
+func TestEnvelopeCipherConcurrent(t *testing.T) {
+	c, err := NewEnvelopeCipher(specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				envelope, err := c.Seal(EnvelopeMethodPush, []byte("sensor-01|[temp:=32]"), 42, specAuthHash, specDeviceHash)
+				if err != nil || !bytes.Equal(envelope, specEnvelope) {
+					t.Errorf("sealed % x, %v", envelope, err)
+					return
+				}
+				if _, _, _, err := c.Open(envelope); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,73 @@
+package tagotip
+
+// ---------------------------------------------------------------------------
+// Uplink envelopes of parsed frames
+// ---------------------------------------------------------------------------
+
+// OpenUplinkFrame decrypts an uplink envelope and parses its headless inner
+// frame with the method of the envelope. An ACK envelope is rejected with
+// an ErrInvalidMethod ParseError; use OpenDownlink for those.
+func OpenUplinkFrame(envelope, key []byte) (*EnvelopeHeader, *HeadlessFrame, Method, error) {
+	header, envMethod, plaintext, err := OpenEnvelope(envelope, key)
+	if err != nil {
+		return nil, nil, MethodUnknown, err
+	}
+	method, ok := uplinkMethod(envMethod)
+	if !ok {
+		return nil, nil, MethodUnknown, failf(ErrInvalidMethod, 0, "expected a PUSH, PULL, or PING envelope")
+	}
+	frame, err := ParseHeadless(method, string(plaintext))
+	if err != nil {
+		return nil, nil, MethodUnknown, err
+	}
+	return header, frame, method, nil
+}
+
+// SealUplinkFrame builds frame with BuildHeadless and seals it into an
+// envelope of the given method, which must be PUSH, PULL, or PING.
+func SealUplinkFrame(
+	method Method,
+	frame *HeadlessFrame,
+	counter uint32,
+	authHash [authHashSize]byte,
+	deviceHash [deviceHashSize]byte,
+	key []byte,
+	suite CipherSuite,
+) ([]byte, error) {
+	envMethod, ok := uplinkEnvelopeMethod(method)
+	if !ok {
+		return nil, failf(ErrInvalidMethod, 0, "expected PUSH, PULL, or PING")
+	}
+	inner, err := BuildHeadless(method, frame)
+	if err != nil {
+		return nil, err
+	}
+	return SealUplink(envMethod, []byte(inner), counter, authHash, deviceHash, key, suite)
+}
+
+// uplinkMethod returns the uplink method of an envelope method; ok is
+// false for ACK and unknown methods.
+func uplinkMethod(m EnvelopeMethod) (method Method, ok bool) {
+	switch m {
+	case EnvelopeMethodPush:
+		return MethodPush, true
+	case EnvelopeMethodPull:
+		return MethodPull, true
+	case EnvelopeMethodPing:
+		return MethodPing, true
+	}
+	return MethodUnknown, false
+}
+
+// uplinkEnvelopeMethod is the inverse of uplinkMethod.
+func uplinkEnvelopeMethod(m Method) (EnvelopeMethod, bool) {
+	switch m {
+	case MethodPush:
+		return EnvelopeMethodPush, true
+	case MethodPull:
+		return EnvelopeMethodPull, true
+	case MethodPing:
+		return EnvelopeMethodPing, true
+	}
+	return 0, false
+}
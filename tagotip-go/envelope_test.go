@@ -0,0 +1,64 @@
+package tagotip
+
+import (
+	"errors"
+	"testing"
+)
+
+// ============================================================================
+// Uplink envelopes of parsed frames
+// ============================================================================
+
+func TestOpenUplinkFrameSpecVector(t *testing.T) {
+	header, frame, method, err := OpenUplinkFrame(specEnvelope, specKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != MethodPush || header.Counter != 42 || frame.Serial != specSerial {
+		t.Errorf("opened %v %+v %+v", method, header, frame)
+	}
+	if v := frame.PushBody.Structured.Variables[0]; v.Name != "temp" || v.Value.Str != "32" {
+		t.Errorf("variable %+v", v)
+	}
+}
+
+func TestSealUplinkFrameRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		method Method
+		frame  *HeadlessFrame
+	}{
+		{MethodPush, &HeadlessFrame{Serial: "dev", PushBody: &PushBody{Structured: &StructuredBody{
+			Variables: []Variable{{Name: "t", Operator: OperatorNumber, Value: Value{Type: OperatorNumber, Str: "1"}}},
+		}}}},
+		{MethodPull, &HeadlessFrame{Serial: "dev", PullBody: &PullBody{Variables: []string{"a", "b"}}}},
+		{MethodPing, &HeadlessFrame{Serial: "dev"}},
+	} {
+		envelope, err := SealUplinkFrame(tc.method, tc.frame, 9, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, frame, method, err := OpenUplinkFrame(envelope, specKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := BuildHeadless(tc.method, tc.frame)
+		got, _ := BuildHeadless(method, frame)
+		if method != tc.method || header.Counter != 9 || got != want {
+			t.Errorf("%v: opened %v %s", tc.method, method, got)
+		}
+	}
+}
+
+func TestUplinkFrameRejectsAck(t *testing.T) {
+	envelope, err := SealDownlink([]byte("OK|3"), 1, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pe *ParseError
+	if _, _, _, err := OpenUplinkFrame(envelope, specKey); !errors.As(err, &pe) || pe.Kind != ErrInvalidMethod {
+		t.Errorf("open: %v", err)
+	}
+	if _, err := SealUplinkFrame(MethodUnknown, &HeadlessFrame{Serial: "dev"}, 1, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm); !errors.As(err, &pe) || pe.Kind != ErrInvalidMethod {
+		t.Errorf("seal: %v", err)
+	}
+}
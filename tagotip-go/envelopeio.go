@@ -0,0 +1,162 @@
+package tagotip
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// ---------------------------------------------------------------------------
+// Envelope streams
+// ---------------------------------------------------------------------------
+//
+// An envelope does not carry its own length, so a stream transport such as
+// TCP needs framing to tell where one ends. EnvelopeReader and
+// EnvelopeWriter frame each envelope with its length as a 2-byte big-endian
+// prefix:
+//
+//	len(envelope) >> 8 | len(envelope) & 0xff | envelope
+//
+// The same stream may carry plaintext frames starting with 0x41 ('A'), the
+// fallback ACK a server sends when it cannot open an envelope. These are
+// written as a line ending in '\n', as plaintext frames are over TCP.
+// No prefix can start with 0x41, as that would announce an envelope longer
+// than the protocol allows, so the first byte of a record tells the two
+// apart the same way IsEnvelope does.
+
+// EnvelopeReader reads length-prefixed envelopes, and interleaved plaintext
+// fallback frames, from a stream. It is not safe for concurrent use.
+type EnvelopeReader struct {
+	r   *bufio.Reader
+	max int
+	buf []byte
+	err error
+}
+
+// NewEnvelopeReader returns an EnvelopeReader reading from r that accepts
+// envelopes of up to maxSize bytes. A maxSize of 0, or one beyond the
+// largest envelope the protocol allows, selects that largest size.
+func NewEnvelopeReader(r io.Reader, maxSize int) *EnvelopeReader {
+	if maxSize <= 0 || maxSize > maxEnvelopeSize {
+		maxSize = maxEnvelopeSize
+	}
+	return &EnvelopeReader{r: bufio.NewReader(r), max: maxSize}
+}
+
+// Next returns the next record: an envelope without its length prefix, or a
+// plaintext frame without its newline, as told apart by IsEnvelope. The
+// record is only valid until the next call to Next.
+//
+// At the end of the stream Next returns io.EOF, or io.ErrUnexpectedEOF when
+// the stream ends partway through a record. A record that is too long or
+// too short to be valid fails with a SecureError for an envelope and a
+// *FrameSizeError for a plaintext frame. The stream cannot be resumed after
+// a framing error, and Next keeps returning it.
+func (r *EnvelopeReader) Next() ([]byte, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	rec, err := r.next()
+	if err != nil {
+		r.err = err
+	}
+	return rec, err
+}
+
+func (r *EnvelopeReader) next() ([]byte, error) {
+	first, err := r.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if first == reservedFlagsValue {
+		return r.readLine(first)
+	}
+	second, err := r.r.ReadByte()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	n := int(first)<<8 | int(second)
+	switch {
+	case n > r.max:
+		return nil, secureErr(ErrEnvelopeTooLarge, fmt.Sprintf("envelope of %d bytes exceeds %d", n, r.max))
+	case n < headerSize+ccmTagSize:
+		return nil, secureErr(ErrEnvelopeTooShort, fmt.Sprintf("envelope of %d bytes is too short", n))
+	}
+	r.buf = slices.Grow(r.buf[:0], n)[:n]
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return r.buf, nil
+}
+
+// readLine reads a plaintext frame starting with first up to its newline.
+func (r *EnvelopeReader) readLine(first byte) ([]byte, error) {
+	r.buf = append(r.buf[:0], first)
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		r.buf = append(r.buf, chunk...)
+		if len(r.buf) > MaxFrameSize+1 {
+			return nil, &FrameSizeError{Size: len(r.buf), Limit: MaxFrameSize}
+		}
+		switch {
+		case err == nil:
+			return r.buf[:len(r.buf)-1], nil
+		case errors.Is(err, bufio.ErrBufferFull):
+		default:
+			return nil, unexpectedEOF(err)
+		}
+	}
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// EnvelopeWriter writes envelopes and plaintext fallback frames framed for
+// an EnvelopeReader. It is not safe for concurrent use.
+type EnvelopeWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewEnvelopeWriter returns an EnvelopeWriter writing to w.
+func NewEnvelopeWriter(w io.Writer) *EnvelopeWriter {
+	return &EnvelopeWriter{w: w}
+}
+
+// WriteEnvelope writes envelope with its length prefix in a single Write.
+func (w *EnvelopeWriter) WriteEnvelope(envelope []byte) error {
+	if len(envelope) > maxEnvelopeSize {
+		return secureErr(ErrEnvelopeTooLarge, "envelope exceeds maximum size")
+	}
+	if !IsEnvelope(envelope) {
+		return secureErr(ErrReservedFlags, "flags byte 0x41 is reserved")
+	}
+	w.buf = append(w.buf[:0], byte(len(envelope)>>8), byte(len(envelope)))
+	w.buf = append(w.buf, envelope...)
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// WritePlaintext writes a plaintext fallback frame, which must start with
+// 0x41, followed by a newline unless it already ends with one.
+func (w *EnvelopeWriter) WritePlaintext(frame []byte) error {
+	line := bytes.TrimSuffix(frame, []byte{'\n'})
+	switch {
+	case IsEnvelope(line):
+		return errors.New("tagotips: plaintext frame must start with 0x41")
+	case bytes.IndexByte(line, '\n') >= 0:
+		return errors.New("tagotips: plaintext frame holds a newline")
+	case len(line) > MaxFrameSize:
+		return &FrameSizeError{Size: len(line), Limit: MaxFrameSize}
+	}
+	w.buf = append(append(w.buf[:0], line...), '\n')
+	_, err := w.w.Write(w.buf)
+	return err
+}
@@ -0,0 +1,151 @@
+package tagotip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// ============================================================================
+// Envelope streams
+// ============================================================================
+
+// envelopeStream frames specEnvelope, a plaintext ACK, and a second envelope.
+func envelopeStream(t *testing.T) (stream []byte, records [][]byte) {
+	t.Helper()
+	second, err := SealDownlink([]byte("OK|1"), 7, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records = [][]byte{specEnvelope, []byte("ACK|ERR|invalid_token"), second}
+	var buf bytes.Buffer
+	w := NewEnvelopeWriter(&buf)
+	if err := w.WriteEnvelope(records[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePlaintext(records[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteEnvelope(records[2]); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), records
+}
+
+func readAll(r *EnvelopeReader) ([][]byte, error) {
+	var out [][]byte
+	for {
+		rec, err := r.Next()
+		if err != nil {
+			return out, err
+		}
+		out = append(out, bytes.Clone(rec))
+	}
+}
+
+func TestEnvelopeReaderRoundTrip(t *testing.T) {
+	stream, want := envelopeStream(t)
+	if stream[0] != 0 || stream[1] != byte(len(specEnvelope)) {
+		t.Errorf("prefix % x", stream[:2])
+	}
+	for name, r := range map[string]io.Reader{
+		"coalesced":    bytes.NewReader(stream),
+		"byte by byte": iotest.OneByteReader(bytes.NewReader(stream)),
+		"half reads":   iotest.HalfReader(bytes.NewReader(stream)),
+	} {
+		got, err := readAll(NewEnvelopeReader(r, 0))
+		if err != io.EOF {
+			t.Errorf("%s: ended with %v", name, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: %d records", name, len(got))
+		}
+		for i := range want {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Errorf("%s: record %d is %q", name, i, got[i])
+			}
+		}
+	}
+}
+
+func TestEnvelopeReaderDispatch(t *testing.T) {
+	stream, _ := envelopeStream(t)
+	r := NewEnvelopeReader(bytes.NewReader(stream), 0)
+	var acks []string
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if IsEnvelope(rec) {
+			if _, _, _, err := OpenEnvelope(rec, specKey); err != nil {
+				t.Error(err)
+			}
+			continue
+		}
+		ack, err := ParseAck(string(rec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		acks = append(acks, ack.Detail.Text)
+	}
+	if len(acks) != 1 || acks[0] != "invalid_token" {
+		t.Errorf("plaintext ACKs %q", acks)
+	}
+}
+
+func TestEnvelopeReaderTruncated(t *testing.T) {
+	stream, _ := envelopeStream(t)
+	// Cut inside the prefix, the envelope, and the plaintext line.
+	for _, n := range []int{1, 2 + headerSize, 2 + len(specEnvelope) + 5} {
+		r := NewEnvelopeReader(bytes.NewReader(stream[:n]), 0)
+		_, err := readAll(r)
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("cut at %d: %v", n, err)
+		}
+		if _, again := r.Next(); again != err {
+			t.Errorf("cut at %d: error not kept: %v", n, again)
+		}
+	}
+	if _, err := NewEnvelopeReader(bytes.NewReader(nil), 0).Next(); err != io.EOF {
+		t.Errorf("empty stream: %v", err)
+	}
+}
+
+func TestEnvelopeReaderLimits(t *testing.T) {
+	big := []byte{0x20, 0x00} // 8192 bytes
+	if _, err := NewEnvelopeReader(bytes.NewReader(big), 1024).Next(); !IsSecureErrorKind(err, ErrEnvelopeTooLarge) {
+		t.Errorf("over maxSize: %v", err)
+	}
+	if _, err := NewEnvelopeReader(bytes.NewReader([]byte{0x00, 0x05, 1, 2, 3, 4, 5}), 0).Next(); !IsSecureErrorKind(err, ErrEnvelopeTooShort) {
+		t.Errorf("short envelope: %v", err)
+	}
+	long := append([]byte("ACK|"), bytes.Repeat([]byte("x"), MaxFrameSize)...)
+	var fe *FrameSizeError
+	if _, err := NewEnvelopeReader(bytes.NewReader(long), 0).Next(); !errors.As(err, &fe) {
+		t.Errorf("long plaintext line: %v", err)
+	}
+}
+
+func TestEnvelopeWriterRejects(t *testing.T) {
+	w := NewEnvelopeWriter(io.Discard)
+	if err := w.WriteEnvelope([]byte("ACK|OK")); !IsSecureErrorKind(err, ErrReservedFlags) {
+		t.Errorf("plaintext as envelope: %v", err)
+	}
+	if err := w.WriteEnvelope(make([]byte, maxEnvelopeSize+1)); !IsSecureErrorKind(err, ErrEnvelopeTooLarge) {
+		t.Errorf("oversized envelope: %v", err)
+	}
+	for _, frame := range []string{"PUSH|x", "ACK|OK\nACK|OK"} {
+		if err := w.WritePlaintext([]byte(frame)); err == nil {
+			t.Errorf("%q written", frame)
+		}
+	}
+	var buf bytes.Buffer
+	if err := NewEnvelopeWriter(&buf).WritePlaintext([]byte("ACK|OK\n")); err != nil || buf.String() != "ACK|OK\n" {
+		t.Errorf("wrote %q, %v", buf.String(), err)
+	}
+}
@@ -22,18 +22,64 @@ const (
 	ErrInvalidAck        ParseErrorKind = "invalid_ack"
 	ErrTooManyItems      ParseErrorKind = "too_many_items"
 	ErrFrameTooLarge     ParseErrorKind = "frame_too_large"
+	ErrChecksumMismatch  ParseErrorKind = "checksum_mismatch"
 )
 
 // ParseError is the error returned by the parsing functions.
 type ParseError struct {
 	Kind     ParseErrorKind
 	Position int
+	Expected string // what the grammar expected at Position; may be empty
+
+	// Field and Snippet are set under ParserOptions.ErrorContext. Field
+	// names the part of the frame at fault, such as "serial", "body.meta",
+	// or "variable[17].unit", counting variables from 0. Snippet shows the
+	// input around Position, with tokens redacted, over a line with a
+	// caret under Position.
+	Field   string
+	Snippet string
 }
 
 func (e *ParseError) Error() string {
-	return fmt.Sprintf("tagotip: %s at position %d", e.Kind, e.Position)
+	msg := fmt.Sprintf("tagotip: %s at position %d", e.Kind, e.Position)
+	if e.Field != "" {
+		msg += " in " + e.Field
+	}
+	if e.Expected != "" {
+		msg += ": " + e.Expected
+	}
+	if e.Snippet != "" {
+		msg += "\n" + e.Snippet
+	}
+	return msg
 }
 
 func fail(kind ParseErrorKind, pos int) error {
 	return &ParseError{Kind: kind, Position: pos}
 }
+
+// failf is fail with a description of what the grammar expected, such as
+// "expected digits for timestamp".
+func failf(kind ParseErrorKind, pos int, expected string) error {
+	return &ParseError{Kind: kind, Position: pos, Expected: expected}
+}
+
+// FrameSizeError is returned by the builders for a frame longer than the
+// protocol allows.
+type FrameSizeError struct {
+	Size  int // length of the frame as built, with its trailing newline if it is sent with one
+	Limit int // MaxFrameSize, or the inner frame limit of TagoTiP/S
+}
+
+func (e *FrameSizeError) Error() string {
+	return fmt.Sprintf("tagotip: frame is %d bytes, exceeds %d by %d", e.Size, e.Limit, e.Size-e.Limit)
+}
+
+// checkFrameSize returns a *FrameSizeError when a frame of n bytes is
+// longer than limit.
+func checkFrameSize(n, limit int) error {
+	if n > limit {
+		return &FrameSizeError{Size: n, Limit: limit}
+	}
+	return nil
+}
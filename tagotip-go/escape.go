@@ -2,40 +2,26 @@ package tagotip
 
 import "strings"
 
-// escapeMap maps escape characters to their replacement.
-var escapeMap = map[byte]byte{
-	'|': '|',
-	'[': '[',
-	']': ']',
-	';': ';',
-	',': ',',
-	'{': '{',
-	'}': '}',
-	'#': '#',
-	'@': '@',
-	'^': '^',
+// unescapeTable maps the byte following a backslash to the character it
+// stands for. Zero means the sequence is not an escape.
+var unescapeTable = [256]byte{
+	'|':  '|',
+	'[':  '[',
+	']':  ']',
+	';':  ';',
+	',':  ',',
+	'{':  '{',
+	'}':  '}',
+	'#':  '#',
+	'@':  '@',
+	'^':  '^',
 	'\\': '\\',
-	'n': '\n',
-}
-
-// structuralChars is the set of characters that need escaping.
-var structuralChars = [256]bool{
-	'|':  true,
-	'[':  true,
-	']':  true,
-	';':  true,
-	',':  true,
-	'{':  true,
-	'}':  true,
-	'#':  true,
-	'@':  true,
-	'^':  true,
-	'\\': true,
-	'\n': true,
+	'n':  '\n',
 }
 
-// reverseEscapeMap maps original bytes to their escape character.
-var reverseEscapeMap = map[byte]byte{
+// escapeTable maps structural characters to the byte written after the
+// backslash when escaping. Zero means the character is written as-is.
+var escapeTable = [256]byte{
 	'|':  '|',
 	'[':  '[',
 	']':  ']',
@@ -61,7 +47,7 @@ func Unescape(s string) string {
 	i := 0
 	for i < len(s) {
 		if s[i] == '\\' && i+1 < len(s) {
-			if ch, ok := escapeMap[s[i+1]]; ok {
+			if ch := unescapeTable[s[i+1]]; ch != 0 {
 				b.WriteByte(ch)
 				i += 2
 				continue
@@ -77,7 +63,7 @@ func Unescape(s string) string {
 func Escape(s string) string {
 	needsEscape := false
 	for i := 0; i < len(s); i++ {
-		if structuralChars[s[i]] {
+		if escapeTable[s[i]] != 0 {
 			needsEscape = true
 			break
 		}
@@ -86,15 +72,32 @@ func Escape(s string) string {
 		return s
 	}
 
-	var b strings.Builder
-	b.Grow(len(s) + len(s)/4)
+	b := make(frameBuf, 0, escapedLen(s))
+	writeEscaped(&b, s)
+	return b.String()
+}
+
+// writeEscaped writes s to b with its structural characters escaped.
+func writeEscaped(b *frameBuf, s string) {
+	start := 0
 	for i := 0; i < len(s); i++ {
-		if esc, ok := reverseEscapeMap[s[i]]; ok {
+		if esc := escapeTable[s[i]]; esc != 0 {
+			b.WriteString(s[start:i])
 			b.WriteByte('\\')
 			b.WriteByte(esc)
-		} else {
-			b.WriteByte(s[i])
+			start = i + 1
 		}
 	}
-	return b.String()
+	b.WriteString(s[start:])
+}
+
+// escapedLen returns the length of Escape(s).
+func escapedLen(s string) int {
+	n := len(s)
+	for i := 0; i < len(s); i++ {
+		if escapeTable[s[i]] != 0 {
+			n++
+		}
+	}
+	return n
 }
@@ -0,0 +1,152 @@
+package tagotip
+
+import (
+	"strings"
+	"testing"
+)
+
+// Reference mappings from the original map-based implementation. The lookup
+// tables in escape.go must agree with these for every byte value.
+var refEscapeMap = map[byte]byte{
+	'|': '|', '[': '[', ']': ']', ';': ';', ',': ',', '{': '{',
+	'}': '}', '#': '#', '@': '@', '^': '^', '\\': '\\', 'n': '\n',
+}
+
+var refReverseEscapeMap = map[byte]byte{
+	'|': '|', '[': '[', ']': ']', ';': ';', ',': ',', '{': '{',
+	'}': '}', '#': '#', '@': '@', '^': '^', '\\': '\\', '\n': 'n',
+}
+
+func TestEscapeTablesMatchReference(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		c := byte(i)
+		want, ok := refEscapeMap[c]
+		if got := unescapeTable[c]; got != want || (got != 0) != ok {
+			t.Errorf("unescapeTable[%#x] = %#x, want %#x (mapped=%v)", c, got, want, ok)
+		}
+		want, ok = refReverseEscapeMap[c]
+		if got := escapeTable[c]; got != want || (got != 0) != ok {
+			t.Errorf("escapeTable[%#x] = %#x, want %#x (mapped=%v)", c, got, want, ok)
+		}
+	}
+}
+
+func TestEscapeUnescapeRoundTrip(t *testing.T) {
+	cases := map[string]string{
+		"plain":       "plain",
+		"a|b":         `a\|b`,
+		"[x];y,z":     `\[x\]\;y\,z`,
+		"{k}#@^":      `\{k\}\#\@\^`,
+		`back\slash`:  `back\\slash`,
+		"line\nbreak": `line\nbreak`,
+	}
+	for raw, escaped := range cases {
+		if got := Escape(raw); got != escaped {
+			t.Errorf("Escape(%q) = %q, want %q", raw, got, escaped)
+		}
+		if got := Unescape(escaped); got != raw {
+			t.Errorf("Unescape(%q) = %q, want %q", escaped, got, raw)
+		}
+	}
+}
+
+func TestUnescapeKeepsUnknownSequences(t *testing.T) {
+	if got := Unescape(`a\qb\`); got != `a\qb\` {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestParseUnescapesValues(t *testing.T) {
+	input := "PUSH|" + testAuth + `|dev|{note=x\;y}[msg=hello\;world;p=a\|b;br=\[1\]\{2\};bs=c:\\d#m\#s{k=v\,w\]\\}]`
+	frame := mustParse(t, input)
+	sb := frame.PushBody.Structured
+	for i, want := range []string{"hello;world", "a|b", "[1]{2}", `c:\d`} {
+		if got := sb.Variables[i].Value.Str; got != want {
+			t.Errorf("%s = %q, want %q", sb.Variables[i].Name, got, want)
+		}
+	}
+	bs := sb.Variables[3]
+	if *bs.Unit != "m#s" || bs.Meta[0].Value != `v,w]\` || sb.Meta[0].Value != "x;y" {
+		t.Errorf("unit %q, meta %+v, body meta %+v", *bs.Unit, bs.Meta, sb.Meta)
+	}
+	if raw, err := BuildUplink(frame); err != nil || raw != input {
+		t.Errorf("rebuilt as %s, %v", raw, err)
+	}
+}
+
+func TestUnitRoundTrip(t *testing.T) {
+	for _, tc := range []struct{ wire, unit string }{
+		{"m/s", "m/s"},
+		{"%", "%"},
+		{"°C", "°C"},
+		{`\#`, "#"},
+		{`m\;s\,x`, "m;s,x"},
+		{`a\\b`, `a\b`},
+	} {
+		input := "PUSH|" + testAuth + "|dev|[v:=1#" + tc.wire + "@1700000000000]"
+		f := mustParse(t, input)
+		if got := *f.PushBody.Structured.Variables[0].Unit; got != tc.unit {
+			t.Errorf("%s: unit %q, want %q", tc.wire, got, tc.unit)
+		}
+		if raw, err := BuildUplink(f); err != nil || raw != input {
+			t.Errorf("%s: rebuilt as %s, %v", tc.wire, raw, err)
+		}
+	}
+	for _, wire := range []string{"m#s", "a,b", "a}b", `a\qb`} {
+		input := "PUSH|" + testAuth + "|dev|[v:=1#" + wire + "]"
+		_, err := ParseUplink(input)
+		assertParseError(t, err, ErrInvalidVariable)
+	}
+}
+
+func TestBuildEscapesValues(t *testing.T) {
+	unit := "m;s"
+	frame := &UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev",
+		PushBody: &PushBody{Structured: &StructuredBody{Variables: []Variable{{
+			Name: "v", Operator: OperatorString, Value: Value{Type: OperatorString, Str: "a;b|c]d\\e"},
+			Unit: &unit, Meta: []MetaPair{{Key: "k", Value: "x,y}"}},
+		}}}}}
+	raw, err := BuildUplink(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "PUSH|" + testAuth + `|dev|[v=a\;b\|c\]d\\e#m\;s{k=x\,y\}}]`; raw != want {
+		t.Fatalf("built %s, want %s", raw, want)
+	}
+	if back := mustParse(t, raw); !back.Equal(frame) {
+		t.Errorf("parsed back as %+v", back.PushBody.Structured.Variables[0])
+	}
+}
+
+// structuralValue returns a 1KB value in which every tenth byte is a
+// structural character.
+func structuralValue() string {
+	const structural = "|[];,{}#@^\\\n"
+	var b strings.Builder
+	for i := 0; i < 1024; i++ {
+		if i%10 == 0 {
+			b.WriteByte(structural[(i/10)%len(structural)])
+		} else {
+			b.WriteByte('a' + byte(i%26))
+		}
+	}
+	return b.String()
+}
+
+func BenchmarkEscape1KB(b *testing.B) {
+	s := structuralValue()
+	b.SetBytes(int64(len(s)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Escape(s)
+	}
+}
+
+func BenchmarkUnescape1KB(b *testing.B) {
+	s := Escape(structuralValue())
+	b.SetBytes(int64(len(s)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Unescape(s)
+	}
+}
@@ -0,0 +1,53 @@
+package tagotip_test
+
+import (
+	"fmt"
+	"sync"
+
+	tagotip "github.com/tago-io/tagotip-sdk/tagotip-go"
+)
+
+var framePool = sync.Pool{
+	New: func() any { return new(tagotip.UplinkFrame) },
+}
+
+func ExampleParseUplinkInto() {
+	raw := "PUSH|at0123456789abcdef0123456789abcdef|dev|[temp:=21.5#C;hum:=40]"
+
+	frame := framePool.Get().(*tagotip.UplinkFrame)
+	defer framePool.Put(frame) // frame must not be used after this
+
+	if err := tagotip.ParseUplinkInto(frame, raw); err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, v := range frame.PushBody.Structured.Variables {
+		fmt.Println(v.Name, v.Value.Str)
+	}
+	// Output:
+	// temp 21.5
+	// hum 40
+}
+
+func ExampleFramePool() {
+	var pool tagotip.FramePool
+
+	serials := make([]string, 0, 2)
+	for _, raw := range []string{
+		"PING|at0123456789abcdef0123456789abcdef|gateway-1",
+		"PING|at0123456789abcdef0123456789abcdef|gateway-2",
+	} {
+		frame := pool.Get()
+		if err := tagotip.ParseUplinkInto(frame, raw); err != nil {
+			fmt.Println(err)
+		} else {
+			// Strings can be kept: they point into raw, not into the frame.
+			// Slices and pointers such as frame.Seq must be copied out.
+			serials = append(serials, frame.Serial)
+		}
+		pool.Put(frame) // frame must not be used after this
+	}
+	fmt.Println(serials)
+	// Output:
+	// [gateway-1 gateway-2]
+}
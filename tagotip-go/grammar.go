@@ -0,0 +1,122 @@
+package tagotip
+
+// ---------------------------------------------------------------------------
+// Grammar tables
+// ---------------------------------------------------------------------------
+//
+// The tables below are the single source of the frame syntax's characters:
+// the parser and builder read them, and Grammar exports them for tools such
+// as syntax highlighters and code generators.
+
+// operatorTokens holds the token written between a variable name and its
+// value, indexed by Operator.
+var operatorTokens = [numOperators]string{
+	OperatorNumber:   ":=",
+	OperatorString:   "=",
+	OperatorBoolean:  "?=",
+	OperatorLocation: "@=",
+}
+
+// operatorAssign ends every operator token; alone it is the string
+// operator.
+const operatorAssign = '='
+
+// operatorByPrefix maps the first byte of a two-byte operator token to the
+// Operator plus one; zero means no such operator.
+var operatorByPrefix = func() (t [256]uint8) {
+	for op, tok := range operatorTokens {
+		if len(tok) == 2 {
+			t[tok[0]] = uint8(op) + 1
+		}
+	}
+	return t
+}()
+
+// plainOperator is the operator whose token is operatorAssign alone.
+var plainOperator = func() Operator {
+	for op, tok := range operatorTokens {
+		if tok == string(operatorAssign) {
+			return Operator(op)
+		}
+	}
+	panic("tagotip: no single-character operator")
+}()
+
+// variableSuffixes holds the markers of the optional variable suffixes in
+// their required order: unit, timestamp, group, metadata. Body modifiers
+// use the same markers from the timestamp on.
+const variableSuffixes = "#@^{"
+
+var variableSuffixNames = [len(variableSuffixes)]string{"unit", "timestamp", "group", "metadata"}
+
+// bodyModifiers holds the body-level modifier markers in order.
+var bodyModifiers = variableSuffixes[1:]
+
+// isSuffixMarker reports the bytes of variableSuffixes.
+var isSuffixMarker = func() (t [256]bool) {
+	for i := 0; i < len(variableSuffixes); i++ {
+		t[variableSuffixes[i]] = true
+	}
+	return t
+}()
+
+// OperatorSyntax describes how an operator is written.
+type OperatorSyntax struct {
+	Operator Operator
+	Token    string // such as ":="
+	Prefix   rune   // the character before '=', or 0 for the string operator
+}
+
+// SuffixMarker describes an optional variable suffix.
+type SuffixMarker struct {
+	Marker rune
+	Name   string // "unit", "timestamp", "group", or "metadata"
+}
+
+// GrammarDescriptor describes the characters and keywords of the frame
+// syntax. It is built from the tables the parser and builder use.
+type GrammarDescriptor struct {
+	// Operators lists the value operators in Operator order.
+	Operators []OperatorSyntax
+
+	// Suffixes lists the variable suffix markers in the order they must
+	// appear. Body modifiers use the same markers, without the unit.
+	Suffixes []SuffixMarker
+
+	// EscapeChar starts an escape sequence, and Escapable lists, in byte
+	// order, the characters that must be escaped within values.
+	EscapeChar rune
+	Escapable  []rune
+
+	// Methods and AckStatuses list the keywords of uplink methods and ACK
+	// statuses.
+	Methods     []string
+	AckStatuses []string
+}
+
+// Grammar returns a description of the frame syntax.
+func Grammar() GrammarDescriptor {
+	g := GrammarDescriptor{EscapeChar: '\\'}
+	for op, tok := range operatorTokens {
+		s := OperatorSyntax{Operator: Operator(op), Token: tok}
+		if len(tok) == 2 {
+			s.Prefix = rune(tok[0])
+		}
+		g.Operators = append(g.Operators, s)
+	}
+	for i := 0; i < len(variableSuffixes); i++ {
+		g.Suffixes = append(g.Suffixes, SuffixMarker{Marker: rune(variableSuffixes[i]), Name: variableSuffixNames[i]})
+	}
+	for ch, esc := range escapeTable {
+		if esc != 0 {
+			g.Escapable = append(g.Escapable, rune(ch))
+		}
+	}
+	for m := MethodPush; methodKeyword(m) != ""; m++ {
+		g.Methods = append(g.Methods, methodKeyword(m))
+	}
+	for s := AckStatusOk; ackStatusKeyword(s) != ""; s++ {
+		g.AckStatuses = append(g.AckStatuses, ackStatusKeyword(s))
+	}
+	return g
+}
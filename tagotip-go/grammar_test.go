@@ -0,0 +1,111 @@
+package tagotip
+
+import (
+	"slices"
+	"testing"
+)
+
+// ============================================================================
+// Grammar
+// ============================================================================
+
+var operatorSamples = map[Operator]string{
+	OperatorNumber:   "21.5",
+	OperatorString:   "hello",
+	OperatorBoolean:  "true",
+	OperatorLocation: "39.74,-104.99",
+}
+
+func TestGrammarOperatorsMatchParser(t *testing.T) {
+	g := Grammar()
+	if len(g.Operators) != numOperators {
+		t.Fatalf("%d operators declared, want %d", len(g.Operators), numOperators)
+	}
+	prefixes := make(map[byte]bool)
+	for _, op := range g.Operators {
+		raw := "PUSH|" + testAuth + "|dev|[v" + op.Token + operatorSamples[op.Operator] + "]"
+		frame, err := ParseUplink(raw)
+		if err != nil {
+			t.Errorf("%s: %v", op.Token, err)
+			continue
+		}
+		if got := frame.PushBody.Structured.Variables[0].Operator; got != op.Operator {
+			t.Errorf("%s parsed as operator %d, want %d", op.Token, got, op.Operator)
+		}
+		if rebuilt, _ := BuildUplink(frame); rebuilt != raw {
+			t.Errorf("%s rebuilt as %s", op.Token, rebuilt)
+		}
+		if op.Prefix != 0 {
+			prefixes[byte(op.Prefix)] = true
+		}
+	}
+
+	// Any other character before '=' is part of a name or an error, never
+	// an operator the table does not declare.
+	for c := byte(0x21); c < 0x7f; c++ {
+		if prefixes[c] || c == operatorAssign || c == '\\' {
+			continue
+		}
+		frame, err := ParseUplink("PUSH|" + testAuth + "|dev|[v" + string(c) + "=1]")
+		if err != nil {
+			continue
+		}
+		v := frame.PushBody.Structured.Variables[0]
+		if v.Operator != OperatorString || v.Name != "v"+string(c) {
+			t.Errorf("%q= parsed as %+v", c, v)
+		}
+	}
+}
+
+func TestGrammarSuffixOrder(t *testing.T) {
+	g := Grammar()
+	values := map[string]string{"unit": "C", "timestamp": "1700000000000", "group": "g", "metadata": "k=v}"}
+	suffix := func(s SuffixMarker) string { return string(s.Marker) + values[s.Name] }
+	var inOrder string
+	for _, s := range g.Suffixes {
+		inOrder += suffix(s)
+	}
+	if _, err := ParseUplink("PUSH|" + testAuth + "|dev|[t:=1" + inOrder + "]"); err != nil {
+		t.Errorf("suffixes in declared order: %v", err)
+	}
+	// Swapping two suffixes ahead of the metadata is rejected.
+	for i := 0; i+2 < len(g.Suffixes); i++ {
+		swapped := suffix(g.Suffixes[i+1]) + suffix(g.Suffixes[i])
+		if _, err := ParseUplink("PUSH|" + testAuth + "|dev|[t:=1" + swapped + "]"); err == nil {
+			t.Errorf("%s accepted", swapped)
+		}
+	}
+}
+
+func TestGrammarEscapableMatchesEscape(t *testing.T) {
+	g := Grammar()
+	for c := rune(0); c < 0x80; c++ {
+		escaped := Escape(string(c)) != string(c)
+		if escaped != slices.Contains(g.Escapable, c) {
+			t.Errorf("%q: Escape changes it %v, declared escapable %v", c, escaped, !escaped)
+		}
+		if escaped && Unescape(Escape(string(c))) != string(c) {
+			t.Errorf("%q does not round-trip", c)
+		}
+	}
+}
+
+func TestGrammarKeywords(t *testing.T) {
+	g := Grammar()
+	if !slices.Equal(g.Methods, []string{"PUSH", "PULL", "PING"}) {
+		t.Errorf("methods %v", g.Methods)
+	}
+	for _, m := range g.Methods {
+		if _, err := parseMethod(m); err != nil {
+			t.Errorf("%s: %v", m, err)
+		}
+	}
+	if !slices.Equal(g.AckStatuses, []string{"OK", "PONG", "CMD", "ERR"}) {
+		t.Errorf("ACK statuses %v", g.AckStatuses)
+	}
+	for _, s := range g.AckStatuses {
+		if _, err := parseAckStatus(s, 0); err != nil {
+			t.Errorf("%s: %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package tagotip
+
+import "strings"
+
+// UplinkHeader holds the header fields of an uplink frame, read by
+// ParseUplinkHeader without parsing the body.
+type UplinkHeader struct {
+	Method Method
+	Seq    *uint32
+	Auth   string
+	Serial string
+
+	// BodyOffset is the byte offset in the input of the field following
+	// the serial, or -1 when the serial ends the frame.
+	BodyOffset int
+}
+
+// ParseUplinkHeader reads the method, sequence counter, authorization
+// hash, and serial of a raw uplink frame, to route it before paying for a
+// full parse. Only those fields are validated, with the checks and error
+// positions of ParseUplink; the body, including NUL bytes within it, is
+// left for ParseUplink to check. Input longer than MaxFrameSize is
+// rejected, and a trailing "\r\n" or '\r' is stripped as ParseUplink
+// does by default.
+//
+// String fields are substrings of input.
+func ParseUplinkHeader(input string) (*UplinkHeader, error) {
+	if len(input) > MaxFrameSize {
+		return nil, fail(ErrFrameTooLarge, 0)
+	}
+	stripped := trimLineEnding(input, false)
+
+	// The header is at most four fields, none of which may hold an escape,
+	// so plain splitting finds the same fields as appendFields.
+	var buf [4]string
+	fields := buf[:0]
+	for rest := stripped; len(fields) < len(buf); {
+		i := strings.IndexByte(rest, '|')
+		if i < 0 {
+			fields = append(fields, rest)
+			break
+		}
+		fields = append(fields, rest[:i])
+		rest = rest[i+1:]
+	}
+
+	p := newParser(nil)
+	h, err := p.parseUplinkHeader(fields)
+	if err != nil {
+		return nil, err
+	}
+	hdr := h.export()
+	if h.bodyPos > len(stripped) {
+		hdr.BodyOffset = -1
+	}
+	return hdr, nil
+}
+
+// export returns h as an UplinkHeader.
+func (h *uplinkHeader) export() *UplinkHeader {
+	hdr := &UplinkHeader{Method: h.method, Auth: h.auth, Serial: h.serial, BodyOffset: h.bodyPos}
+	if h.hasSeq {
+		seq := h.seq
+		hdr.Seq = &seq
+	}
+	return hdr
+}
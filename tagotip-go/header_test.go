@@ -0,0 +1,80 @@
+package tagotip
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// ParseUplinkHeader
+// ============================================================================
+
+func TestParseUplinkHeaderMatchesParse(t *testing.T) {
+	for _, raw := range loadCorpus(t) {
+		frame, err := ParseUplink(raw)
+		if err != nil {
+			continue
+		}
+		h, err := ParseUplinkHeader(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+		if h.Method != frame.Method || h.Auth != frame.Auth || h.Serial != frame.Serial ||
+			!reflect.DeepEqual(h.Seq, frame.Seq) {
+			t.Errorf("%s: header %+v", raw, h)
+		}
+		prefix := raw[:strings.Index(raw, frame.Serial)+len(frame.Serial)]
+		switch {
+		case h.BodyOffset == -1:
+			if strings.TrimSuffix(raw, "\n") != prefix {
+				t.Errorf("%s: no body reported", raw)
+			}
+		case raw[:h.BodyOffset] != prefix+"|":
+			t.Errorf("%s: body at %d", raw, h.BodyOffset)
+		}
+	}
+}
+
+func TestParseUplinkHeaderBodyOffset(t *testing.T) {
+	for raw, want := range map[string]int{
+		"PUSH|" + testAuth + "|dev|[t:=1]":     44,
+		"PUSH|!7|" + testAuth + "|dev|[t:=1]":  47,
+		"PING|" + testAuth + "|dev":            -1,
+		"PING|" + testAuth + "|dev\n":          -1,
+		"PULL|" + testAuth + "|dev|[a]\n":      44,
+		"PUSH|" + testAuth + "|dev|[Bad:=1;;]": 44,
+	} {
+		h, err := ParseUplinkHeader(raw)
+		if err != nil {
+			t.Fatalf("%q: %v", raw, err)
+		}
+		if h.BodyOffset != want {
+			t.Errorf("%q: body at %d, want %d", raw, h.BodyOffset, want)
+		}
+	}
+}
+
+func TestParseUplinkHeaderErrors(t *testing.T) {
+	for _, raw := range []string{
+		"",
+		"POST|" + testAuth + "|dev|[t:=1]",
+		"PUSH|!x|" + testAuth + "|dev|[t:=1]",
+		"PUSH|!99999999999|" + testAuth + "|dev|[t:=1]",
+		"PUSH|at12|dev|[t:=1]",
+		"PUSH|" + testAuth,
+		"PUSH|" + testAuth + "|",
+		"PUSH|" + testAuth + "|de\\|v|[t:=1]",
+		"PUSH|" + testAuth + "|dev ice|[t:=1]",
+		"PUSH|!3|" + testAuth,
+	} {
+		_, want := ParseUplink(raw)
+		_, got := ParseUplinkHeader(raw)
+		if want == nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v, ParseUplink returns %v", raw, got, want)
+		}
+	}
+	if _, err := ParseUplinkHeader(strings.Repeat("x", MaxFrameSize+1)); err == nil {
+		t.Error("oversized input accepted")
+	}
+}
@@ -0,0 +1,220 @@
+package tagotip
+
+import (
+	"container/list"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// IdempotencyMetaKey is the body-level metadata key carrying the
+	// idempotency key of a logical PUSH.
+	IdempotencyMetaKey = "idem"
+	// IdempotencyKeyLen is the length of an idempotency key in hex characters.
+	IdempotencyKeyLen = 16
+)
+
+// NewIdempotencyKey returns a random 16-character lowercase hex key.
+// Generate one per logical frame and reuse it across retransmissions.
+func NewIdempotencyKey() (string, error) {
+	var buf [IdempotencyKeyLen / 2]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", fmt.Errorf("tagotip: generate idempotency key: %w", err)
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// ValidateIdempotencyKey checks that key is exactly 16 lowercase hex characters.
+func ValidateIdempotencyKey(key string) error {
+	if len(key) != IdempotencyKeyLen {
+		return fmt.Errorf("tagotip: idempotency key must be %d hex characters", IdempotencyKeyLen)
+	}
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
+			return fmt.Errorf("tagotip: idempotency key must be lowercase hex")
+		}
+	}
+	return nil
+}
+
+// StampIdempotencyKey sets the body-level idem=<key> metadata pair on a
+// structured body, replacing any existing one.
+func StampIdempotencyKey(body *StructuredBody, key string) error {
+	if body == nil {
+		return fmt.Errorf("tagotip: nil body")
+	}
+	if err := ValidateIdempotencyKey(key); err != nil {
+		return err
+	}
+	body.Metadata()
+	for i := range body.Meta {
+		if body.Meta[i].Key == IdempotencyMetaKey {
+			body.Meta[i].Value = key
+			return nil
+		}
+	}
+	if len(body.Meta) >= MaxMetaPairs {
+		return fmt.Errorf("tagotip: body metadata already has %d pairs", MaxMetaPairs)
+	}
+	body.Meta = append(body.Meta, MetaPair{Key: IdempotencyMetaKey, Value: key})
+	return nil
+}
+
+// IdempotencyKey returns the idempotency key carried by a frame's body-level
+// metadata. ok is false for non-PUSH frames, passthrough bodies, and bodies
+// without a well-formed key.
+func IdempotencyKey(frame *UplinkFrame) (key string, ok bool) {
+	if frame == nil || frame.PushBody == nil || frame.PushBody.Structured == nil {
+		return "", false
+	}
+	for _, p := range frame.PushBody.Structured.Metadata() {
+		if p.Key == IdempotencyMetaKey {
+			if ValidateIdempotencyKey(p.Value) != nil {
+				return "", false
+			}
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// withIdempotencyKey returns body stamped with a new idempotency key, for
+// the clients to send across all attempts of a call. The key goes on a
+// copy, leaving body as the caller gave it. Passthrough bodies, and bodies
+// that already carry a key, are returned as they are.
+func withIdempotencyKey(body *PushBody) (*PushBody, error) {
+	if body == nil || body.IsPassthrough || body.Structured == nil {
+		return body, nil
+	}
+	if _, ok := IdempotencyKey(&UplinkFrame{Method: MethodPush, PushBody: body}); ok {
+		return body, nil
+	}
+	key, err := NewIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+	sb := body.Structured
+	stamped := &StructuredBody{Group: sb.Group, Timestamp: sb.Timestamp,
+		Meta: slices.Clone(sb.Metadata()), Variables: sb.Variables}
+	if err := StampIdempotencyKey(stamped, key); err != nil {
+		return nil, err
+	}
+	return &PushBody{Structured: stamped}, nil
+}
+
+type idempotencyEntry struct {
+	serial  string
+	key     string
+	ack     *AckFrame
+	expires time.Time
+}
+
+type idempotencyID struct {
+	serial string
+	key    string
+}
+
+// IdempotencyCache remembers recently seen (serial, key) pairs so servers
+// can short-circuit retransmitted PUSH frames. It holds at most maxEntries
+// pairs, evicting the least recently recorded one first, and forgets pairs
+// after ttl. It is safe for concurrent use.
+type IdempotencyCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	ttl        time.Duration
+	entries    map[idempotencyID]*list.Element
+	order      *list.List // front = oldest
+	now        func() time.Time
+}
+
+// NewIdempotencyCache creates a cache bounded to maxEntries pairs with the
+// given time-to-live. maxEntries must be positive.
+func NewIdempotencyCache(maxEntries int, ttl time.Duration) *IdempotencyCache {
+	if maxEntries <= 0 {
+		panic("tagotip: IdempotencyCache maxEntries must be positive")
+	}
+	return &IdempotencyCache{
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		entries:    make(map[idempotencyID]*list.Element),
+		order:      list.New(),
+		now:        time.Now,
+	}
+}
+
+// Seen reports whether (serial, key) was already recorded and has not
+// expired. The first call for a pair records it and returns false.
+func (c *IdempotencyCache) Seen(serial, key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	id := idempotencyID{serial: serial, key: key}
+	if el, ok := c.entries[id]; ok {
+		if now.Before(el.Value.(*idempotencyEntry).expires) {
+			return true
+		}
+		c.remove(el)
+	}
+
+	c.evictExpired(now)
+	for c.order.Len() >= c.maxEntries {
+		c.remove(c.order.Front())
+	}
+	entry := &idempotencyEntry{serial: serial, key: key, expires: now.Add(c.ttl)}
+	c.entries[id] = c.order.PushBack(entry)
+	return false
+}
+
+// SetAck stores the ACK sent for the first delivery of (serial, key) so
+// duplicates can be answered with the same response. It is a no-op if the
+// pair is not currently recorded.
+func (c *IdempotencyCache) SetAck(serial, key string, ack *AckFrame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[idempotencyID{serial: serial, key: key}]; ok {
+		el.Value.(*idempotencyEntry).ack = ack
+	}
+}
+
+// Ack returns the ACK stored with SetAck for (serial, key), if any.
+func (c *IdempotencyCache) Ack(serial, key string) (*AckFrame, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[idempotencyID{serial: serial, key: key}]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*idempotencyEntry)
+	if !c.now().Before(entry.expires) || entry.ack == nil {
+		return nil, false
+	}
+	return entry.ack, true
+}
+
+// Len returns the number of pairs currently held, including expired pairs
+// that have not been evicted yet.
+func (c *IdempotencyCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *IdempotencyCache) evictExpired(now time.Time) {
+	for el := c.order.Front(); el != nil; el = c.order.Front() {
+		if now.Before(el.Value.(*idempotencyEntry).expires) {
+			return
+		}
+		c.remove(el)
+	}
+}
+
+func (c *IdempotencyCache) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*idempotencyEntry)
+	delete(c.entries, idempotencyID{serial: entry.serial, key: entry.key})
+}
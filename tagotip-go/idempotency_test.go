@@ -0,0 +1,217 @@
+package tagotip
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestIdempotencyCache(maxEntries int, ttl time.Duration) (*IdempotencyCache, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	c := NewIdempotencyCache(maxEntries, ttl)
+	c.now = clock.Now
+	return c, clock
+}
+
+// =========================================================================
+// Idempotency keys
+// =========================================================================
+
+func TestNewIdempotencyKeyIsValid(t *testing.T) {
+	key, err := NewIdempotencyKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateIdempotencyKey(key); err != nil {
+		t.Fatalf("generated key %q rejected: %v", key, err)
+	}
+}
+
+func TestValidateIdempotencyKeyRejects(t *testing.T) {
+	for _, key := range []string{"", "0123456789abcde", "0123456789abcdef0", "0123456789ABCDEF", "0123456789abcdeg"} {
+		if ValidateIdempotencyKey(key) == nil {
+			t.Errorf("expected %q to be rejected", key)
+		}
+	}
+}
+
+func TestStampIdempotencyKeyRoundTrip(t *testing.T) {
+	body := &StructuredBody{
+		Variables: []Variable{{Name: "temp", Operator: OperatorNumber, Value: Value{Type: OperatorNumber, Str: "21"}}},
+	}
+	if err := StampIdempotencyKey(body, "00112233aabbccdd"); err != nil {
+		t.Fatal(err)
+	}
+	// Restamping replaces rather than duplicates.
+	if err := StampIdempotencyKey(body, "0123456789abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Meta) != 1 {
+		t.Fatalf("expected 1 meta pair, got %d", len(body.Meta))
+	}
+
+	raw, err := BuildUplink(&UplinkFrame{
+		Method:   MethodPush,
+		Auth:     testAuth,
+		Serial:   "dev",
+		PushBody: &PushBody{Structured: body},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw != "PUSH|"+testAuth+"|dev|{idem=0123456789abcdef}[temp:=21]" {
+		t.Fatalf("unexpected frame: %s", raw)
+	}
+
+	frame, err := ParseUplink(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, ok := IdempotencyKey(frame)
+	if !ok || key != "0123456789abcdef" {
+		t.Errorf("expected key round trip, got %q ok=%v", key, ok)
+	}
+}
+
+func TestStampIdempotencyKeyInvalid(t *testing.T) {
+	if err := StampIdempotencyKey(&StructuredBody{}, "nothex"); err == nil {
+		t.Error("expected error for invalid key")
+	}
+}
+
+func TestIdempotencyKeyAbsent(t *testing.T) {
+	frame, err := ParseUplink("PUSH|" + testAuth + "|dev|{idem=short}[x:=1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := IdempotencyKey(frame); ok {
+		t.Error("malformed key should not be reported")
+	}
+}
+
+// =========================================================================
+// IdempotencyCache
+// =========================================================================
+
+func TestIdempotencyCacheRetransmitDedup(t *testing.T) {
+	c, _ := newTestIdempotencyCache(16, time.Minute)
+	if c.Seen("dev", "0123456789abcdef") {
+		t.Fatal("first delivery reported as duplicate")
+	}
+	ack := &AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "count", Count: 3}}
+	c.SetAck("dev", "0123456789abcdef", ack)
+
+	if !c.Seen("dev", "0123456789abcdef") {
+		t.Fatal("retransmit not detected")
+	}
+	got, ok := c.Ack("dev", "0123456789abcdef")
+	if !ok || got != ack {
+		t.Error("expected original ACK for duplicate")
+	}
+	// Same key from another device is a different logical frame.
+	if c.Seen("other", "0123456789abcdef") {
+		t.Error("key should be scoped per serial")
+	}
+}
+
+func TestIdempotencyCacheTTLExpiry(t *testing.T) {
+	c, clock := newTestIdempotencyCache(16, time.Minute)
+	c.Seen("dev", "0123456789abcdef")
+	c.SetAck("dev", "0123456789abcdef", &AckFrame{Status: AckStatusOk})
+
+	clock.Advance(59 * time.Second)
+	if !c.Seen("dev", "0123456789abcdef") {
+		t.Fatal("expected duplicate within TTL")
+	}
+	clock.Advance(time.Second)
+	if _, ok := c.Ack("dev", "0123456789abcdef"); ok {
+		t.Error("expired ACK should not be returned")
+	}
+	if c.Seen("dev", "0123456789abcdef") {
+		t.Error("expected pair to be forgotten after TTL")
+	}
+}
+
+func TestIdempotencyCacheBounded(t *testing.T) {
+	c, _ := newTestIdempotencyCache(3, time.Hour)
+	keys := []string{"000000000000000a", "000000000000000b", "000000000000000c", "000000000000000d"}
+	for _, k := range keys {
+		c.Seen("dev", k)
+	}
+	if c.Len() != 3 {
+		t.Fatalf("expected 3 entries, got %d", c.Len())
+	}
+	if c.Seen("dev", keys[0]) {
+		t.Error("oldest entry should have been evicted")
+	}
+	if !c.Seen("dev", keys[3]) {
+		t.Error("newest entry should still be present")
+	}
+}
+
+func TestClientIdempotencyKeyKeptAcrossRetries(t *testing.T) {
+	var keys []string
+	reply := scriptedServer("ACK|ERR|rate_limited|0", "ACK|ERR|server_error", "ACK|OK|1")
+	c := pipeClient(t, &ClientOptions{IdempotencyKeys: true, Retry: &RetryPolicy{InitialBackoff: time.Millisecond}},
+		func(f *UplinkFrame) string {
+			key, ok := IdempotencyKey(f)
+			if !ok {
+				t.Errorf("frame without key: %+v", f.PushBody.Structured.Meta)
+			}
+			keys = append(keys, key)
+			return reply(f)
+		})
+	body := &PushBody{Structured: &StructuredBody{Meta: MetaPairs{{"src", "a"}}, Variables: []Variable{
+		{Name: "t", Operator: OperatorNumber, Value: Value{Type: OperatorNumber, Str: "1"}},
+	}}}
+	if _, err := c.Push(context.Background(), body); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 || keys[1] != keys[0] || keys[2] != keys[0] {
+		t.Errorf("keys %q", keys)
+	}
+	if len(body.Structured.Meta) != 1 {
+		t.Errorf("caller's body stamped: %v", body.Structured.Meta)
+	}
+
+	// The next call gets a new key.
+	if _, err := c.Push(context.Background(), body); err != nil {
+		t.Fatal(err)
+	}
+	if keys[3] == keys[0] {
+		t.Error("key reused for a new call")
+	}
+}
+
+func TestUDPClientIdempotencyKeyKeptAcrossRetransmits(t *testing.T) {
+	keys := make(chan string, 3)
+	addr := udpServer(t, func(f *UplinkFrame, n int) []string {
+		key, _ := IdempotencyKey(f)
+		keys <- key
+		if n < 3 {
+			return nil
+		}
+		return []string{ackFor(f, "OK|1")}
+	})
+	c := dialUDP(t, addr, &UDPOptions{RetryInterval: 20 * time.Millisecond, IdempotencyKeys: true})
+	body := &PushBody{Structured: &StructuredBody{Variables: []Variable{
+		{Name: "t", Operator: OperatorNumber, Value: Value{Type: OperatorNumber, Str: "1"}},
+	}}}
+	if _, err := c.Push(context.Background(), body); err != nil {
+		t.Fatal(err)
+	}
+	first := <-keys
+	if ValidateIdempotencyKey(first) != nil {
+		t.Fatalf("key %q", first)
+	}
+	for i := 0; i < 2; i++ {
+		if k := <-keys; k != first {
+			t.Errorf("retransmit carries %q, first %q", k, first)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package tagotip
+
+// ---------------------------------------------------------------------------
+// Envelope internals
+// ---------------------------------------------------------------------------
+//
+// SealUplink and OpenEnvelope build the flags byte, header, and nonce
+// themselves. The functions below expose those steps so that other
+// implementations, such as device firmware, can check their intermediate
+// values against this one.
+
+// EncodeEnvelopeFlags returns the flags byte for the given cipher suite,
+// envelope version, and method. It fails when a value does not fit its bit
+// field, or when the result is the reserved byte 0x41.
+func EncodeEnvelopeFlags(suite CipherSuite, version int, method EnvelopeMethod) (byte, error) {
+	switch {
+	case suite < 0 || suite > flagsCipherMask>>flagsCipherShift:
+		return 0, secureErr(ErrUnsupportedSuite, "cipher suite does not fit the flags byte")
+	case version < 0 || version > flagsVersionMask>>flagsVersionShift:
+		return 0, secureErr(ErrUnsupportedEnvelopeVersion, "version does not fit the flags byte")
+	case method < 0 || method > flagsMethodMask:
+		return 0, secureErr(ErrInvalidEnvelopeMethod, "method does not fit the flags byte")
+	}
+	return encodeFlags(int(suite), version, int(method))
+}
+
+// DecodeEnvelopeFlags splits a flags byte into its cipher suite, envelope
+// version, and method. It fails only for the reserved byte 0x41; whether
+// the values are supported is left to the caller.
+func DecodeEnvelopeFlags(flags byte) (suite CipherSuite, version int, method EnvelopeMethod, err error) {
+	cipherID, version, methodID, err := decodeFlags(flags)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return CipherSuite(cipherID), version, EnvelopeMethod(methodID), nil
+}
+
+// BuildEnvelopeHeader returns the 21-byte envelope header: the flags byte,
+// the counter in big-endian order, the authorization hash, and the device
+// hash. The header is also the additional authenticated data of the AEAD.
+func BuildEnvelopeHeader(
+	flags byte,
+	counter uint32,
+	authHash [authHashSize]byte,
+	deviceHash [deviceHashSize]byte,
+) ([headerSize]byte, error) {
+	var header [headerSize]byte
+	if _, _, _, err := decodeFlags(flags); err != nil {
+		return header, err
+	}
+	putEnvelopeHeader(header[:], flags, counter, authHash, deviceHash)
+	return header, nil
+}
+
+// ConstructNonce returns the 13-byte AEAD nonce: the flags byte, four zero
+// bytes, the first four bytes of the device hash, and the counter in
+// big-endian order.
+func ConstructNonce(flags byte, deviceHash [deviceHashSize]byte, counter uint32) [ccmNonceSize]byte {
+	return constructNonce(flags, deviceHash, counter)
+}
@@ -0,0 +1,97 @@
+package tagotip
+
+import (
+	"bytes"
+	"testing"
+)
+
+// ============================================================================
+// Envelope internals (TagoTiPs.md section 11.1)
+// ============================================================================
+
+func TestEnvelopeFlagsSpecVector(t *testing.T) {
+	flags, err := EncodeEnvelopeFlags(CipherSuiteAes128Ccm, 0, EnvelopeMethodPush)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags != specEnvelope[0] {
+		t.Errorf("flags 0x%02x, want 0x%02x", flags, specEnvelope[0])
+	}
+
+	for _, tc := range []struct {
+		flags   byte
+		suite   CipherSuite
+		version int
+		method  EnvelopeMethod
+	}{
+		{0x00, 0, 0, EnvelopeMethodPush},
+		{0x03, 0, 0, EnvelopeMethodAck},
+		{0b101_10_010, 5, 2, EnvelopeMethodPing},
+		{0xff, 7, 3, 7},
+	} {
+		suite, version, method, err := DecodeEnvelopeFlags(tc.flags)
+		if err != nil || suite != tc.suite || version != tc.version || method != tc.method {
+			t.Errorf("0x%02x decoded as %d %d %d, %v", tc.flags, suite, version, method, err)
+		}
+		if flags, err := EncodeEnvelopeFlags(suite, version, method); err != nil || flags != tc.flags {
+			t.Errorf("0x%02x re-encoded as 0x%02x, %v", tc.flags, flags, err)
+		}
+	}
+}
+
+func TestEnvelopeFlagsRejected(t *testing.T) {
+	// 0x41 is suite 2, version 0, method 1.
+	if _, err := EncodeEnvelopeFlags(2, 0, EnvelopeMethodPull); !IsSecureErrorKind(err, ErrReservedFlags) {
+		t.Errorf("reserved flags encoded: %v", err)
+	}
+	if _, _, _, err := DecodeEnvelopeFlags(reservedFlagsValue); !IsSecureErrorKind(err, ErrReservedFlags) {
+		t.Errorf("reserved flags decoded: %v", err)
+	}
+	for _, tc := range []struct {
+		suite   CipherSuite
+		version int
+		method  EnvelopeMethod
+		kind    SecureErrorKind
+	}{
+		{8, 0, 0, ErrUnsupportedSuite},
+		{-1, 0, 0, ErrUnsupportedSuite},
+		{0, 4, 0, ErrUnsupportedEnvelopeVersion},
+		{0, 0, 8, ErrInvalidEnvelopeMethod},
+	} {
+		if _, err := EncodeEnvelopeFlags(tc.suite, tc.version, tc.method); !IsSecureErrorKind(err, tc.kind) {
+			t.Errorf("%+v: %v", tc, err)
+		}
+	}
+}
+
+func TestBuildEnvelopeHeaderSpecVector(t *testing.T) {
+	header, err := BuildEnvelopeHeader(0x00, 42, specAuthHash, specDeviceHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x00,                   // flags
+		0x00, 0x00, 0x00, 0x2a, // counter
+		0x4d, 0xee, 0xdd, 0x7b, 0xab, 0x88, 0x17, 0xec, // auth hash
+		0xab, 0x77, 0x88, 0xd2, 0x2e, 0xb7, 0x37, 0x2f, // device hash
+	}
+	if !bytes.Equal(header[:], want) || !bytes.Equal(header[:], specEnvelope[:headerSize]) {
+		t.Errorf("header % x", header)
+	}
+	if _, err := BuildEnvelopeHeader(reservedFlagsValue, 42, specAuthHash, specDeviceHash); !IsSecureErrorKind(err, ErrReservedFlags) {
+		t.Errorf("reserved flags: %v", err)
+	}
+}
+
+func TestConstructNonceSpecVector(t *testing.T) {
+	nonce := ConstructNonce(0x00, specDeviceHash, 42)
+	want := [13]byte{
+		0x00,                   // flags
+		0x00, 0x00, 0x00, 0x00, // padding
+		0xab, 0x77, 0x88, 0xd2, // device hash, first 4 bytes
+		0x00, 0x00, 0x00, 0x2a, // counter
+	}
+	if nonce != want {
+		t.Errorf("nonce % x, want % x", nonce, want)
+	}
+}
@@ -0,0 +1,65 @@
+//go:build go1.23
+
+package tagotip
+
+import "iter"
+
+// EachVariable returns an iterator over the variables of a structured PUSH.
+// It yields nothing for other frames.
+func (f *UplinkFrame) EachVariable() iter.Seq[Variable] {
+	return func(yield func(Variable) bool) {
+		sb := structuredBody(f)
+		if sb == nil {
+			return
+		}
+		for _, v := range sb.Variables {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// EachMeta returns an iterator over the body-level metadata pairs, parsing
+// them first if they are held lazily.
+func (sb *StructuredBody) EachMeta() iter.Seq[MetaPair] {
+	return func(yield func(MetaPair) bool) {
+		for _, p := range sb.Metadata() {
+			if !yield(p) {
+				return
+			}
+		}
+	}
+}
+
+// UplinkVariables returns an iterator over the variables of a raw PUSH
+// frame that parses each variable only when the loop asks for it, without
+// building the frame:
+//
+//	for v, err := range tagotip.UplinkVariables(raw) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+//
+// The header and body modifiers are validated before the first variable is
+// yielded; an error in a variable is yielded, ending the iteration, after
+// the variables before it. Breaking out of the loop stops parsing, so the
+// rest of the frame is not validated. Variables do not inherit body-level
+// defaults, and frames other than structured PUSH yield no variables once
+// validated.
+func UplinkVariables(input string) iter.Seq2[Variable, error] {
+	return func(yield func(Variable, error) bool) {
+		p := newParser(nil)
+		err := p.eachUplinkVariable(input, nil, false, func(v *Variable) error {
+			if !yield(*v, nil) {
+				return errStopStream
+			}
+			return nil
+		})
+		if err != nil && err != errStopStream {
+			yield(Variable{}, err)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+//go:build go1.23
+
+package tagotip_test
+
+import (
+	"fmt"
+
+	tagotip "github.com/tago-io/tagotip-sdk/tagotip-go"
+)
+
+func ExampleUplinkVariables() {
+	raw := "PUSH|at0123456789abcdef0123456789abcdef|dev|[temp:=21.5#C;hum:=40;rain:=oops]"
+
+	for v, err := range tagotip.UplinkVariables(raw) {
+		if err != nil {
+			fmt.Println("error:", err)
+			break
+		}
+		fmt.Println(v.Name, v.Value.Str)
+	}
+	// Output:
+	// temp 21.5
+	// hum 40
+	// error: tagotip: invalid_variable at position 72: expected a number
+}
+
+func ExampleUplinkFrame_EachVariable() {
+	frame, err := tagotip.ParseUplink("PUSH|at0123456789abcdef0123456789abcdef|dev|{fw=2}[temp:=21.5;hum:=40]")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for v := range frame.EachVariable() {
+		fmt.Println(v.Name)
+	}
+	for p := range frame.PushBody.Structured.EachMeta() {
+		fmt.Println(p.Key, p.Value)
+	}
+	// Output:
+	// temp
+	// hum
+	// fw 2
+}
@@ -0,0 +1,127 @@
+//go:build go1.23
+
+package tagotip
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Iterators
+// ============================================================================
+
+func TestUplinkVariablesMatchesParse(t *testing.T) {
+	for _, ex := range Spec11Examples() {
+		if strings.HasPrefix(ex.Raw, "ACK") {
+			continue
+		}
+		var got []Variable
+		var gotErr error
+		for v, err := range UplinkVariables(ex.Raw) {
+			if err != nil {
+				gotErr = err
+				break
+			}
+			got = append(got, v)
+		}
+		frame, err := ParseUplink(ex.Raw)
+		if (err == nil) != (gotErr == nil) {
+			t.Errorf("%s: parse error %v, iterator error %v", ex.Label, err, gotErr)
+			continue
+		}
+		var want []Variable
+		for v := range frame.EachVariable() {
+			want = append(want, v)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s:\n  got  %+v\n  want %+v", ex.Label, got, want)
+		}
+	}
+}
+
+func TestUplinkVariablesEarlyBreak(t *testing.T) {
+	// The third variable is malformed: breaking before it never sees the
+	// error, since the rest of the frame is not parsed.
+	raw := "PUSH|" + testAuth + "|dev|[a:=1;b:=2;c:=x]"
+	var names []string
+	for v, err := range UplinkVariables(raw) {
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		names = append(names, v.Name)
+		if len(names) == 2 {
+			break
+		}
+	}
+	if strings.Join(names, ",") != "a,b" {
+		t.Errorf("got %v", names)
+	}
+}
+
+func TestUplinkVariablesErrorMidIteration(t *testing.T) {
+	raw := "PUSH|" + testAuth + "|dev|[a:=1;b:=2;c:=x;d:=4]"
+	var names []string
+	var errs []error
+	for v, err := range UplinkVariables(raw) {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		names = append(names, v.Name)
+	}
+	if strings.Join(names, ",") != "a,b" || len(errs) != 1 {
+		t.Fatalf("got %v, errors %v", names, errs)
+	}
+	_, parseErr := ParseUplink(raw)
+	if errs[0].Error() != parseErr.Error() {
+		t.Errorf("iterator error %v, ParseUplink error %v", errs[0], parseErr)
+	}
+}
+
+func TestUplinkVariablesHeaderErrors(t *testing.T) {
+	for _, raw := range []string{
+		"PUSH|badauth|dev|[a:=1]",
+		"PUSH|" + testAuth + "|dev",
+		"PUSH|" + testAuth + "|dev|{x}[a:=1]",
+		"PUSH|" + testAuth + "|dev|[]",
+		"PUSH|" + testAuth + "|dev|>xZZ",
+		"PULL|" + testAuth + "|dev|[]",
+	} {
+		n := 0
+		var gotErr error
+		for _, err := range UplinkVariables(raw) {
+			n++
+			gotErr = err
+		}
+		if n != 1 || gotErr == nil {
+			t.Errorf("%s: %d items, error %v", raw, n, gotErr)
+		}
+	}
+	for _, raw := range []string{"PING|" + testAuth + "|dev", "PUSH|" + testAuth + "|dev|>xABCD"} {
+		for v, err := range UplinkVariables(raw) {
+			t.Errorf("%s: yielded %v, %v", raw, v, err)
+		}
+	}
+}
+
+func TestEachMetaEarlyBreak(t *testing.T) {
+	frame, err := ParseUplinkWithOptions("PUSH|"+testAuth+"|dev|{a=1,b=2,c=3}[x:=1]", &ParserOptions{LazyMeta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for p := range frame.PushBody.Structured.EachMeta() {
+		keys = append(keys, p.Key)
+		if p.Key == "b" {
+			break
+		}
+	}
+	if strings.Join(keys, ",") != "a,b" {
+		t.Errorf("got %v", keys)
+	}
+	for range (&UplinkFrame{Method: MethodPing}).EachVariable() {
+		t.Error("PING yielded a variable")
+	}
+}
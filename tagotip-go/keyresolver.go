@@ -0,0 +1,96 @@
+package tagotip
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ---------------------------------------------------------------------------
+// Key resolution
+// ---------------------------------------------------------------------------
+//
+// A server learns which device sent an envelope only from its header, so it
+// cannot pick the key before reading it. OpenEnvelopeWith reads the header,
+// asks a KeyResolver for the key, and then opens the envelope.
+// OpenEnvelopeMulti tries several keys, as while a device's token is being
+// rotated.
+
+// KeyResolver returns the key to open an envelope with, given its header.
+type KeyResolver interface {
+	ResolveKey(header *EnvelopeHeader) ([]byte, error)
+}
+
+// ErrKeyNotFound is returned by MapKeyResolver for an unknown authorization
+// hash.
+var ErrKeyNotFound = errors.New("tagotips: no key for authorization hash")
+
+// KeyResolveError wraps an error returned by a KeyResolver, to tell it
+// apart from a *SecureError about the envelope itself.
+type KeyResolveError struct {
+	Header *EnvelopeHeader
+	Err    error
+}
+
+func (e *KeyResolveError) Error() string {
+	return fmt.Sprintf("tagotips: resolving key: %v", e.Err)
+}
+
+func (e *KeyResolveError) Unwrap() error { return e.Err }
+
+// OpenEnvelopeWith is OpenEnvelope with the key returned by resolver for the
+// envelope's header. Resolver errors are returned as a *KeyResolveError;
+// a wrong key fails decryption with a *SecureError as usual.
+func OpenEnvelopeWith(envelope []byte, resolver KeyResolver) (*EnvelopeHeader, EnvelopeMethod, []byte, error) {
+	header, err := ParseEnvelopeHeader(envelope)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	key, err := resolver.ResolveKey(header)
+	if err != nil {
+		return nil, 0, nil, &KeyResolveError{Header: header, Err: err}
+	}
+	return OpenEnvelope(envelope, key)
+}
+
+// MapKeyResolver resolves keys by the envelope's authorization hash. It
+// suits tests and small deployments; it must not be modified while in use.
+type MapKeyResolver map[[authHashSize]byte][]byte
+
+// ResolveKey returns the key stored for header.AuthHash, or ErrKeyNotFound.
+func (m MapKeyResolver) ResolveKey(header *EnvelopeHeader) ([]byte, error) {
+	key, ok := m[header.AuthHash]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// OpenEnvelopeMulti is OpenEnvelope with several candidate keys, such as the
+// old and new key of a device whose token is being rotated. The header is
+// checked once, before any key is tried, and the keys are then tried in
+// order. It returns the index of the key that opened the envelope; when
+// none does, the error is the same as OpenEnvelope's for a wrong key.
+func OpenEnvelopeMulti(envelope []byte, keys [][]byte) (*EnvelopeHeader, EnvelopeMethod, []byte, int, error) {
+	header, method, info, err := checkEnvelope(envelope)
+	if err != nil {
+		return nil, 0, nil, -1, err
+	}
+	if len(keys) == 0 {
+		return nil, 0, nil, -1, secureErr(ErrBadKeySize, "no encryption keys")
+	}
+	for _, key := range keys {
+		if len(key) != info.keySize {
+			return nil, 0, nil, -1, secureErr(ErrBadKeySize, "invalid encryption key size")
+		}
+	}
+	for i, key := range keys {
+		plaintext, err := decryptEnvelope(envelope, header, key)
+		if err == nil {
+			return header, method, plaintext, i, nil
+		}
+		if !IsAuthFailure(err) {
+			return nil, 0, nil, -1, err
+		}
+	}
+	return nil, 0, nil, -1, secureErr(ErrAuthFailed, "AEAD decryption failed")
+}
@@ -0,0 +1,88 @@
+package tagotip
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// ============================================================================
+// Key resolution
+// ============================================================================
+
+func TestOpenEnvelopeWith(t *testing.T) {
+	resolver := MapKeyResolver{specAuthHash: specKey}
+	header, method, plaintext, err := OpenEnvelopeWith(specEnvelope, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method != EnvelopeMethodPush || header.Counter != 42 || string(plaintext) != "sensor-01|[temp:=32]" {
+		t.Errorf("opened %d %+v %q", method, header, plaintext)
+	}
+}
+
+func TestOpenEnvelopeWithUnknownAuthHash(t *testing.T) {
+	_, _, _, err := OpenEnvelopeWith(specEnvelope, MapKeyResolver{})
+	var re *KeyResolveError
+	if !errors.As(err, &re) || !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("got %v", err)
+	}
+	if re.Header.AuthHash != specAuthHash {
+		t.Errorf("header %+v", re.Header)
+	}
+	if IsSecureError(err) {
+		t.Error("resolver error reported as a SecureError")
+	}
+}
+
+func TestOpenEnvelopeWithWrongKey(t *testing.T) {
+	wrong := make([]byte, len(specKey))
+	_, _, _, err := OpenEnvelopeWith(specEnvelope, MapKeyResolver{specAuthHash: wrong})
+	var re *KeyResolveError
+	if !IsSecureError(err) || errors.As(err, &re) {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestOpenEnvelopeMulti(t *testing.T) {
+	old := make([]byte, len(specKey))
+	other := bytes.Repeat([]byte{1}, len(specKey))
+	for _, tc := range []struct {
+		keys  [][]byte
+		index int
+	}{
+		{[][]byte{specKey}, 0},
+		{[][]byte{old, specKey}, 1},
+		{[][]byte{old, other, specKey, old}, 2},
+	} {
+		header, method, plaintext, index, err := OpenEnvelopeMulti(specEnvelope, tc.keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != tc.index || method != EnvelopeMethodPush || header.Counter != 42 || string(plaintext) != "sensor-01|[temp:=32]" {
+			t.Errorf("opened with key %d: %d %+v %q", index, method, header, plaintext)
+		}
+	}
+}
+
+func TestOpenEnvelopeMultiErrors(t *testing.T) {
+	wrong := make([]byte, len(specKey))
+	_, _, _, singleErr := OpenEnvelope(specEnvelope, wrong)
+	_, _, _, index, err := OpenEnvelopeMulti(specEnvelope, [][]byte{wrong, bytes.Repeat([]byte{1}, len(specKey))})
+	if !IsAuthFailure(err) || index != -1 {
+		t.Errorf("no key matches: %d, %v", index, err)
+	}
+	if err.Error() != singleErr.Error() {
+		t.Errorf("error %q differs from OpenEnvelope's %q", err, singleErr)
+	}
+
+	// Header problems are reported without trying any key.
+	if _, _, _, _, err := OpenEnvelopeMulti(specEnvelope[:10], [][]byte{nil}); !IsSecureErrorKind(err, ErrEnvelopeTooShort) {
+		t.Errorf("short envelope: %v", err)
+	}
+	for _, keys := range [][][]byte{nil, {specKey, specKey[:5]}} {
+		if _, _, _, _, err := OpenEnvelopeMulti(specEnvelope, keys); !IsSecureErrorKind(err, ErrBadKeySize) {
+			t.Errorf("%d keys: %v", len(keys), err)
+		}
+	}
+}
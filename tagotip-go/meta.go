@@ -0,0 +1,126 @@
+package tagotip
+
+// ---------------------------------------------------------------------------
+// Metadata pairs
+// ---------------------------------------------------------------------------
+//
+// A metadata block may repeat a key, as in {source=a,source=b}, and servers
+// do not agree on which value wins. MetaPairs reads and edits pairs by key
+// so that callers need not scan for them, and Dedup settles on the last
+// value of each key, which is also what BuildUplinkWithOptions sends under
+// BuildOptions.DedupMetaKeys.
+
+// MetaPairs is a list of metadata pairs in the order they are written.
+// Under ParserOptions.LazyMeta, call Variable.Metadata or
+// StructuredBody.Metadata before using it.
+type MetaPairs []MetaPair
+
+// Get returns the value of key, the last one when key is repeated. ok is
+// false when key is absent.
+func (m MetaPairs) Get(key string) (value string, ok bool) {
+	for i := len(m) - 1; i >= 0; i-- {
+		if m[i].Key == key {
+			return m[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// Set sets the value of key. An existing key keeps its position, and any
+// later pairs repeating it are removed; a new key is appended.
+func (m *MetaPairs) Set(key, value string) {
+	for i := range *m {
+		if (*m)[i].Key == key {
+			(*m)[i].Value = value
+			rest := (*m)[i+1:]
+			rest.Delete(key)
+			*m = (*m)[:i+1+len(rest)]
+			return
+		}
+	}
+	*m = append(*m, MetaPair{Key: key, Value: value})
+}
+
+// Delete removes every pair with key, keeping the order of the others.
+func (m *MetaPairs) Delete(key string) {
+	out := (*m)[:0]
+	for _, p := range *m {
+		if p.Key != key {
+			out = append(out, p)
+		}
+	}
+	clear((*m)[len(out):])
+	*m = out
+}
+
+// Dedup returns the pairs with each key once, at the position it first
+// appears, holding the value it last has. m is returned as is when no key
+// repeats.
+func (m MetaPairs) Dedup() MetaPairs {
+	if !m.hasDuplicates() {
+		return m
+	}
+	out := make(MetaPairs, 0, len(m))
+	at := make(map[string]int, len(m)) // index in out of each key
+	for _, p := range m {
+		if j, ok := at[p.Key]; ok {
+			out[j].Value = p.Value
+		} else {
+			at[p.Key] = len(out)
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func (m MetaPairs) hasDuplicates() bool {
+	var keys dupIndex[string]
+	for _, p := range m {
+		if keys.add(p.Key) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dupScanLimit is the number of keys a dupIndex compares one by one before
+// it builds a map. Most frames carry fewer, and scanning them costs less
+// than hashing.
+const dupScanLimit = 8
+
+// dupIndex finds the keys that repeat among those added to it, in time
+// linear in their number, so that the duplicate checks stay cheap at the
+// raised ParseLimits. The zero value is empty and does not allocate until
+// more than dupScanLimit keys are added.
+type dupIndex[K comparable] struct {
+	n    int
+	scan [dupScanLimit]K
+	m    map[K]int // index of the first of each key, past dupScanLimit
+}
+
+// add records k and returns the index of the first earlier key equal to
+// it, or -1.
+func (d *dupIndex[K]) add(k K) int {
+	i := d.n
+	d.n++
+	if i < dupScanLimit {
+		d.scan[i] = k
+		for j := range i {
+			if d.scan[j] == k {
+				return j
+			}
+		}
+		return -1
+	}
+	if d.m == nil {
+		d.m = make(map[K]int, 2*dupScanLimit)
+		for j := dupScanLimit - 1; j >= 0; j-- {
+			d.m[d.scan[j]] = j
+		}
+	}
+	if j, ok := d.m[k]; ok {
+		return j
+	}
+	d.m[k] = i
+	return -1
+}
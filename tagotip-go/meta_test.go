@@ -0,0 +1,95 @@
+package tagotip
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// ============================================================================
+// Metadata pairs
+// ============================================================================
+
+func TestMetaPairs(t *testing.T) {
+	m := MetaPairs{{"source", "a"}, {"fw", "1"}, {"source", "b"}, {"site", "x"}}
+	if v, ok := m.Get("source"); !ok || v != "b" {
+		t.Errorf("Get repeated key: %q %v", v, ok)
+	}
+	if _, ok := m.Get("missing"); ok {
+		t.Error("Get missing key")
+	}
+
+	m.Set("source", "c")
+	want := MetaPairs{{"source", "c"}, {"fw", "1"}, {"site", "x"}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Set existing: %v", m)
+	}
+	m.Set("zone", "3")
+	m.Delete("fw")
+	want = MetaPairs{{"source", "c"}, {"site", "x"}, {"zone", "3"}}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("Set new, Delete: %v", m)
+	}
+	m.Delete("missing")
+	if len(m) != 3 {
+		t.Errorf("Delete missing: %v", m)
+	}
+
+	var empty MetaPairs
+	empty.Set("a", "1")
+	if v, _ := empty.Get("a"); v != "1" {
+		t.Errorf("Set on nil: %v", empty)
+	}
+}
+
+func TestMetaPairsDedup(t *testing.T) {
+	m := MetaPairs{{"a", "1"}, {"b", "2"}, {"a", "3"}, {"c", "4"}, {"b", "5"}}
+	got := m.Dedup()
+	want := MetaPairs{{"a", "3"}, {"b", "5"}, {"c", "4"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v", got)
+	}
+	if m[0].Value != "1" || len(m) != 5 {
+		t.Errorf("Dedup changed its receiver: %v", m)
+	}
+	unique := MetaPairs{{"a", "1"}, {"b", "2"}}
+	if got := unique.Dedup(); &got[0] != &unique[0] {
+		t.Error("pairs without repeats were copied")
+	}
+
+	var many, wantMany MetaPairs
+	for i := range 3 * dupScanLimit {
+		k := "k" + strconv.Itoa(i)
+		many = append(many, MetaPair{k, "1"})
+		wantMany = append(wantMany, MetaPair{k, "1"})
+	}
+	many = append(many, MetaPair{"k2", "2"}, MetaPair{"k20", "2"})
+	wantMany[2].Value, wantMany[20].Value = "2", "2"
+	if got := many.Dedup(); !reflect.DeepEqual(got, wantMany) {
+		t.Errorf("past the scan limit: got %v", got)
+	}
+}
+
+func TestBuildUplinkDedupMetaKeys(t *testing.T) {
+	for _, lazy := range []bool{false, true} {
+		input := "PUSH|" + testAuth + "|dev|{src=a,src=b}[t:=1{x=1,y=2,x=3};h:=2{z=1}]"
+		f, err := ParseUplinkWithOptions(input, &ParserOptions{LazyMeta: lazy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, err := BuildUplinkWithOptions(f, &BuildOptions{DedupMetaKeys: true})
+		want := "PUSH|" + testAuth + "|dev|{src=b}[t:=1{x=3,y=2};h:=2{z=1}]"
+		if err != nil || raw != want {
+			t.Errorf("lazy=%v: got %q, %v", lazy, raw, err)
+		}
+		if raw, _ := BuildUplinkWithOptions(f, nil); raw != input {
+			t.Errorf("lazy=%v: frame changed, now builds as %q", lazy, raw)
+		}
+	}
+
+	ping := &UplinkFrame{Method: MethodPing, Auth: testAuth, Serial: "dev",
+		PingDiagnostics: []MetaPair{{"bat", "3.7"}, {"bat", "3.6"}}}
+	if raw, err := BuildUplinkWithOptions(ping, &BuildOptions{DedupMetaKeys: true}); err != nil || raw != "PING|"+testAuth+"|dev|{bat=3.6}" {
+		t.Errorf("PING: %q, %v", raw, err)
+	}
+}
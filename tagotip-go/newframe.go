@@ -0,0 +1,69 @@
+package tagotip
+
+import "fmt"
+
+// ---------------------------------------------------------------------------
+// PULL and PING construction
+// ---------------------------------------------------------------------------
+//
+// A PULL or PING carries little more than the device identity, so these
+// helpers write the frame directly instead of going through an UplinkFrame.
+// Their inputs are held to the parser's rules, so what they build parses.
+
+// NewPull builds a PULL for the named variables, of which there must be
+// 1 to MaxVariables.
+func NewPull(auth, serial string, vars ...string) (string, error) {
+	return buildPull(nil, auth, serial, vars)
+}
+
+// NewPullSeq is NewPull with a sequence counter.
+func NewPullSeq(seq uint32, auth, serial string, vars ...string) (string, error) {
+	return buildPull(&seq, auth, serial, vars)
+}
+
+// NewPing builds a PING.
+func NewPing(auth, serial string) (string, error) {
+	return buildPing(nil, auth, serial)
+}
+
+// NewPingSeq is NewPing with a sequence counter.
+func NewPingSeq(seq uint32, auth, serial string) (string, error) {
+	return buildPing(&seq, auth, serial)
+}
+
+func buildPull(seq *uint32, auth, serial string, vars []string) (string, error) {
+	if err := checkDevice("PULL", auth, serial); err != nil {
+		return "", err
+	}
+	if len(vars) == 0 {
+		return "", fmt.Errorf("tagotip: PULL: no variables")
+	}
+	if len(vars) > MaxVariables {
+		return "", fmt.Errorf("tagotip: PULL: %d variables, at most %d allowed", len(vars), MaxVariables)
+	}
+	for _, name := range vars {
+		if validateVarname(name, 0) != nil {
+			return "", fmt.Errorf("tagotip: PULL: invalid variable name %q", name)
+		}
+	}
+	return BuildUplink(&UplinkFrame{Method: MethodPull, Seq: seq, Auth: auth, Serial: serial, PullBody: &PullBody{Variables: vars}})
+}
+
+func buildPing(seq *uint32, auth, serial string) (string, error) {
+	if err := checkDevice("PING", auth, serial); err != nil {
+		return "", err
+	}
+	return BuildUplink(&UplinkFrame{Method: MethodPing, Seq: seq, Auth: auth, Serial: serial})
+}
+
+// checkDevice reports an authorization hash or serial the parser would
+// reject.
+func checkDevice(method, auth, serial string) error {
+	if validateAuth(auth, 0) != nil {
+		return fmt.Errorf("tagotip: %s: invalid authorization hash %q", method, auth)
+	}
+	if validateSerial(serial, 0) != nil {
+		return fmt.Errorf("tagotip: %s: invalid serial %q", method, serial)
+	}
+	return nil
+}
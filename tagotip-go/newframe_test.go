@@ -0,0 +1,77 @@
+package tagotip
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// NewPull / NewPing
+// ============================================================================
+
+func TestNewPull(t *testing.T) {
+	raw, err := NewPull(testAuth, "dev", "temp", "hum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "PULL|" + testAuth + "|dev|[temp;hum]"; raw != want {
+		t.Errorf("got %s, want %s", raw, want)
+	}
+	raw, err = NewPullSeq(7, testAuth, "dev", "temp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := mustParse(t, raw)
+	if *frame.Seq != 7 || !slices.Equal(frame.PullBody.Variables, []string{"temp"}) {
+		t.Errorf("%s parsed as %+v", raw, frame)
+	}
+}
+
+func TestNewPing(t *testing.T) {
+	raw, err := NewPing(testAuth, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "PING|" + testAuth + "|dev"; raw != want {
+		t.Errorf("got %s, want %s", raw, want)
+	}
+	raw, err = NewPingSeq(3, testAuth, "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "PING|!3|" + testAuth + "|dev"; raw != want {
+		t.Errorf("got %s, want %s", raw, want)
+	}
+	mustParse(t, raw)
+}
+
+func TestNewPullPingReject(t *testing.T) {
+	tooMany := make([]string, MaxVariables+1)
+	for i := range tooMany {
+		tooMany[i] = "v"
+	}
+	for name, build := range map[string]func() (string, error){
+		"ping auth":      func() (string, error) { return NewPing("at12", "dev") },
+		"ping serial":    func() (string, error) { return NewPingSeq(1, testAuth, "a|b") },
+		"pull serial":    func() (string, error) { return NewPull(testAuth, "", "v") },
+		"no variables":   func() (string, error) { return NewPull(testAuth, "dev") },
+		"too many":       func() (string, error) { return NewPull(testAuth, "dev", tooMany...) },
+		"bad name":       func() (string, error) { return NewPull(testAuth, "dev", "ok", "Not_ok") },
+		"empty name":     func() (string, error) { return NewPull(testAuth, "dev", "") },
+		"separator name": func() (string, error) { return NewPull(testAuth, "dev", "a;b") },
+	} {
+		raw, err := build()
+		if err == nil {
+			t.Errorf("%s: built %s", name, raw)
+		} else if !strings.HasPrefix(err.Error(), "tagotip: P") {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	raw, err := NewPull(testAuth, "dev", tooMany[:MaxVariables]...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustParse(t, raw)
+}
@@ -0,0 +1,251 @@
+package tagotip
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Canonical form
+// ---------------------------------------------------------------------------
+
+// NormalizationVersion identifies the rules applied by Normalize. It is
+// incremented whenever a rule changes, so that hashes or other values
+// derived from normalized frames can be stored together with the version
+// that produced them.
+//
+// Version 1 rules:
+//
+//  1. Metadata pairs, at body and variable level and in PING diagnostics,
+//     are sorted by key and then by value. Duplicate pairs are kept.
+//  2. Variables are sorted by name and then by timestamp. A variable
+//     without a timestamp sorts before the same name with one; timestamps
+//     compare numerically. Variables that tie on both are ordered by their
+//     wire form, so the order of the input never shows through.
+//  3. A negative zero number ("-0", "-0.00") loses its sign, in values and
+//     in location components. Numbers are otherwise kept as written:
+//     "21.50" and "21.5" stay distinct. Canonicalize also rewrites the
+//     digits.
+//  4. Hex passthrough data is upper-cased.
+//  5. Empty slices become nil, lazily held metadata is parsed, and each
+//     Value holds only the field its operator uses.
+//
+// PULL names keep their order, since a server answers them in order.
+const NormalizationVersion = 1
+
+// Normalize returns a copy of f in canonical form, following the rules of
+// NormalizationVersion; f itself is left untouched. Two frames are
+// semantically equal when their normalized forms are Equal.
+func (f *UplinkFrame) Normalize() *UplinkFrame {
+	n := cloneUplink(f)
+	n.PingDiagnostics = normalizeMeta(n.PingDiagnostics)
+	if pb := n.PushBody; pb != nil {
+		if pt := pb.Passthrough; pt != nil && pt.Encoding == PassthroughEncodingHex {
+			pt.Data = strings.ToUpper(pt.Data)
+		}
+		if sb := pb.Structured; sb != nil {
+			sb.Meta = normalizeMeta(sb.Meta)
+			for i := range sb.Variables {
+				normalizeVariable(&sb.Variables[i])
+			}
+			sortVariables(sb.Variables)
+			if len(sb.Variables) == 0 {
+				sb.Variables = nil
+			}
+		}
+	}
+	if n.PullBody != nil && len(n.PullBody.Variables) == 0 {
+		n.PullBody.Variables = nil
+	}
+	return n
+}
+
+// Equal reports whether f and g have the same exported contents. Nil and
+// empty slices compare equal, and lazily held metadata is compared as
+// parsed. Equal does not normalize: to compare frames semantically, compare
+// their normalized forms.
+func (f *UplinkFrame) Equal(g *UplinkFrame) bool {
+	if f == nil || g == nil {
+		return f == g
+	}
+	return reflect.DeepEqual(cloneUplink(f), cloneUplink(g))
+}
+
+func normalizeMeta(pairs []MetaPair) []MetaPair {
+	if len(pairs) == 0 {
+		return nil
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if pairs[i].Key != pairs[j].Key {
+			return pairs[i].Key < pairs[j].Key
+		}
+		return pairs[i].Value < pairs[j].Value
+	})
+	return pairs
+}
+
+func normalizeVariable(v *Variable) {
+	v.Meta = normalizeMeta(v.Meta)
+	val := Value{Type: v.Operator}
+	switch v.Operator {
+	case OperatorNumber:
+		val.Str = normalizeNumber(v.Value.Str)
+	case OperatorString:
+		val.Str = v.Value.Str
+	case OperatorBoolean:
+		val.Bool = v.Value.Bool
+	case OperatorLocation:
+		if loc := v.Value.Location; loc != nil {
+			val.Location = &LocationValue{Lat: normalizeNumber(loc.Lat), Lng: normalizeNumber(loc.Lng)}
+			if loc.Alt != nil {
+				alt := normalizeNumber(*loc.Alt)
+				val.Location.Alt = &alt
+			}
+		}
+	}
+	v.Value = val
+}
+
+// normalizeNumber drops the sign of a negative zero.
+func normalizeNumber(s string) string {
+	if len(s) < 2 || s[0] != '-' {
+		return s
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] != '0' && s[i] != '.' {
+			return s
+		}
+	}
+	return s[1:]
+}
+
+func sortVariables(vars []Variable) {
+	if len(vars) < 2 {
+		return
+	}
+	// Wire forms break ties; compute them once.
+	wire := make([]string, len(vars))
+	for i := range vars {
+		var b frameBuf
+		writeVariable(&b, &vars[i])
+		wire[i] = b.String()
+	}
+	idx := make([]int, len(vars))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool {
+		va, vb := &vars[idx[a]], &vars[idx[b]]
+		if va.Name != vb.Name {
+			return va.Name < vb.Name
+		}
+		if c := compareTimestamps(va.Timestamp, vb.Timestamp); c != 0 {
+			return c < 0
+		}
+		return wire[idx[a]] < wire[idx[b]]
+	})
+	sorted := make([]Variable, len(vars))
+	for i, j := range idx {
+		sorted[i] = vars[j]
+	}
+	copy(vars, sorted)
+}
+
+// compareTimestamps orders an absent timestamp first and digit strings by
+// numeric value.
+func compareTimestamps(a, b *string) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	x := strings.TrimLeft(*a, "0")
+	y := strings.TrimLeft(*b, "0")
+	if len(x) != len(y) {
+		if len(x) < len(y) {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(x, y)
+}
+
+// ---------------------------------------------------------------------------
+// Canonical numbers
+// ---------------------------------------------------------------------------
+
+// Canonicalize returns a copy of f in canonical form: Normalize applied
+// after writing every number, in values and location components, in
+// canonical form. Two frames carrying the same data, such as
+// "temp:=32.50" and "temp:=32.5", then build into the same string, which
+// CanonicalString returns. f itself is left untouched.
+//
+// A number in canonical form is written by these rules, in order:
+//
+//  1. A leading '+' is dropped: "+5" becomes "5".
+//  2. Trailing zeros of the fraction are dropped, and the '.' with them
+//     when no digit is left: "32.50" becomes "32.5", "7.0" becomes "7".
+//  3. Zero has no sign: "-0", "+0.00", and "0.0" become "0".
+//
+// Numbers are otherwise kept digit for digit: no rounding, no exponent,
+// no change of precision. A number that does not follow the grammar after
+// rule 1 is left as is, and fails to build. String values, names, units,
+// and timestamps are never changed. These rules change only together with
+// NormalizationVersion.
+func Canonicalize(f *UplinkFrame) *UplinkFrame {
+	if f == nil {
+		return nil
+	}
+	c := cloneUplink(f)
+	if pb := c.PushBody; pb != nil && pb.Structured != nil {
+		for i := range pb.Structured.Variables {
+			v := &pb.Structured.Variables[i]
+			switch v.Operator {
+			case OperatorNumber:
+				v.Value.Str = canonicalNumber(v.Value.Str)
+			case OperatorLocation:
+				if loc := v.Value.Location; loc != nil {
+					loc.Lat = canonicalNumber(loc.Lat)
+					loc.Lng = canonicalNumber(loc.Lng)
+					if loc.Alt != nil {
+						*loc.Alt = canonicalNumber(*loc.Alt)
+					}
+				}
+			}
+		}
+	}
+	// Numbers are rewritten first, so that variables tying on name and
+	// timestamp sort by their canonical wire forms.
+	return c.Normalize()
+}
+
+// CanonicalString returns the wire form of Canonicalize(f). Frames whose
+// canonical strings are equal carry the same data, so the string may be
+// hashed to detect duplicates.
+func CanonicalString(f *UplinkFrame) (string, error) {
+	if f == nil {
+		return "", fmt.Errorf("tagotip: nil frame")
+	}
+	return BuildUplink(Canonicalize(f))
+}
+
+// canonicalNumber writes s by the number rules of Canonicalize.
+func canonicalNumber(s string) string {
+	t := strings.TrimPrefix(s, "+")
+	if validateNumber(t, 0) != nil {
+		return s
+	}
+	if strings.IndexByte(t, '.') >= 0 {
+		t = strings.TrimRight(t, "0")
+		t = strings.TrimSuffix(t, ".")
+	}
+	if t == "-0" {
+		return "0"
+	}
+	return t
+}
@@ -0,0 +1,191 @@
+package tagotip
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// ============================================================================
+// Normalize
+// ============================================================================
+
+func mustParse(t *testing.T, raw string) *UplinkFrame {
+	t.Helper()
+	f, err := ParseUplink(raw)
+	if err != nil {
+		t.Fatalf("%s: %v", raw, err)
+	}
+	return f
+}
+
+func TestNormalizeDataloggerOrder(t *testing.T) {
+	f := mustParse(t, "PUSH|"+testAuth+"|dev|[temp:=33@1694567900000;temp:=31@999;hum:=65;temp:=32@1694567890000;temp:=30]")
+	got, err := BuildUplink(f.Normalize())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "PUSH|" + testAuth + "|dev|[hum:=65;temp:=30;temp:=31@999;temp:=32@1694567890000;temp:=33@1694567900000]"
+	if got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestNormalizeRules(t *testing.T) {
+	p := "PUSH|" + testAuth + "|dev|"
+	equal := [][2]string{
+		{p + "{b=2,a=1}[x:=1{z=1,y=2}]", p + "{a=1,b=2}[x:=1{y=2,z=1}]"},
+		{p + "[x:=-0;y:=-0.00;pos@=-0,-0.0,-0]", p + "[x:=0;y:=0.00;pos@=0,0.0,0]"},
+		{p + ">xdeadbeef", p + ">xDEADBEEF"},
+		{p + "[a:=1@5;a:=2@5]", p + "[a:=2@5;a:=1@5]"},
+		{p + "[a:=1@010]", p + "[a:=1@010]"},
+	}
+	for _, tc := range equal {
+		a, b := mustParse(t, tc[0]).Normalize(), mustParse(t, tc[1]).Normalize()
+		if !a.Equal(b) {
+			ra, _ := BuildUplink(a)
+			rb, _ := BuildUplink(b)
+			t.Errorf("expected equal:\n  %s\n  %s", ra, rb)
+		}
+	}
+	distinct := [][2]string{
+		{p + "[x:=21.5]", p + "[x:=21.50]"},
+		{p + "[x:=-1]", p + "[x:=1]"},
+		{p + ">bAAAA", p + ">baaaa"},
+		{"PULL|" + testAuth + "|dev|[a;b]", "PULL|" + testAuth + "|dev|[b;a]"},
+	}
+	for _, tc := range distinct {
+		if mustParse(t, tc[0]).Normalize().Equal(mustParse(t, tc[1]).Normalize()) {
+			t.Errorf("expected distinct: %s, %s", tc[0], tc[1])
+		}
+	}
+}
+
+func TestNormalizeTimestampsNumeric(t *testing.T) {
+	f := mustParse(t, "PUSH|"+testAuth+"|dev|[t:=1@100;t:=2@99;t:=3@0098]")
+	got, _ := BuildUplink(f.Normalize())
+	if want := "PUSH|" + testAuth + "|dev|[t:=3@0098;t:=2@99;t:=1@100]"; got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestNormalizeLeavesOriginal(t *testing.T) {
+	raw := "PUSH|" + testAuth + "|dev|{b=2,a=1}[y:=-0;x:=1]"
+	f := mustParse(t, raw)
+	f.Normalize()
+	if got, _ := BuildUplink(f); got != raw {
+		t.Errorf("original changed to %s", got)
+	}
+
+	lazy, err := ParseUplinkWithOptions(raw, &ParserOptions{LazyMeta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lazy.Normalize().Equal(f.Normalize()) {
+		t.Error("lazy and eager frames normalize differently")
+	}
+	if len(lazy.PushBody.Structured.Meta) != 0 {
+		t.Error("Normalize parsed the lazy metadata of the original")
+	}
+}
+
+func TestNormalizeNilSlices(t *testing.T) {
+	f := &UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev",
+		PushBody: &PushBody{Structured: &StructuredBody{Meta: []MetaPair{}, Variables: []Variable{
+			{Name: "x", Operator: OperatorBoolean, Value: Value{Type: OperatorBoolean, Str: "stale", Bool: true}, Meta: []MetaPair{}},
+		}}}}
+	n := f.Normalize()
+	sb := n.PushBody.Structured
+	if sb.Meta != nil || sb.Variables[0].Meta != nil || sb.Variables[0].Value.Str != "" {
+		t.Errorf("not normalized: %+v", sb)
+	}
+}
+
+func TestNormalizeIdempotentAndStable(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for i := 0; i < 300; i++ {
+		f := (*UplinkFrame)(nil).Generate(r, MaxVariables).Interface().(*UplinkFrame)
+		once := f.Normalize()
+		if twice := once.Normalize(); !twice.Equal(once) {
+			t.Fatalf("frame %d: Normalize is not idempotent", i)
+		}
+		raw, err := BuildUplink(once)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reparsed := mustParse(t, raw).Normalize(); !reparsed.Equal(once) {
+			t.Fatalf("frame %d: normalized form does not survive build and parse: %s", i, raw)
+		}
+		if !f.Equal(f) || f.Equal(&UplinkFrame{}) {
+			t.Fatalf("frame %d: Equal is inconsistent", i)
+		}
+	}
+}
+
+// ============================================================================
+// Canonicalize
+// ============================================================================
+
+func TestCanonicalNumber(t *testing.T) {
+	for _, tc := range [][2]string{
+		{"32.50", "32.5"},
+		{"32.5", "32.5"},
+		{"7.0", "7"},
+		{"7.000", "7"},
+		{"100", "100"},
+		{"10.10", "10.1"},
+		{"0.05", "0.05"},
+		{"0", "0"},
+		{"0.0", "0"},
+		{"-0", "0"},
+		{"-0.000", "0"},
+		{"+0", "0"},
+		{"+0.00", "0"},
+		{"+5", "5"},
+		{"+5.10", "5.1"},
+		{"-5.10", "-5.1"},
+		{"-0.50", "-0.5"},
+		{"12345678901234567890.1200", "12345678901234567890.12"},
+		// Not numbers: kept as written.
+		{"", ""},
+		{"+", "+"},
+		{"++1", "++1"},
+		{"1e5", "1e5"},
+		{"01.0", "01.0"},
+		{"1.", "1."},
+	} {
+		if got := canonicalNumber(tc[0]); got != tc[1] {
+			t.Errorf("canonicalNumber(%q) = %q, want %q", tc[0], got, tc[1])
+		}
+	}
+}
+
+func TestCanonicalString(t *testing.T) {
+	p := "PUSH|" + testAuth + "|dev|"
+	for _, tc := range [][2]string{
+		{p + "[temp:=32.50]", p + "[temp:=32.5]"},
+		{p + "{fw=2,src=a}[x:=-0.0{z=1,y=2};note=1.50]", p + "{fw=2,src=a}[note=1.50;x:=0{y=2,z=1}]"},
+		{p + "[pos@=-23.500,-46.60,0.0]", p + "[pos@=-23.5,-46.6,0]"},
+		{p + "[a:=1.0#B;a:=1#A]", p + "[a:=1#A;a:=1#B]"},
+		{p + "[a:=1.0@5;a:=10@5]", p + "[a:=10@5;a:=1@5]"},
+		{p + "[t:=1.10#C@1700000000000^g]", p + "[t:=1.1#C@1700000000000^g]"},
+	} {
+		got, err := CanonicalString(mustParse(t, tc[0]))
+		if err != nil || got != tc[1] {
+			t.Errorf("%s:\n got  %s, %v\n want %s", tc[0], got, err, tc[1])
+		}
+	}
+
+	// Frames built in code may carry a '+'.
+	f := &UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev", PushBody: &PushBody{
+		Structured: &StructuredBody{Variables: []Variable{{Name: "x", Operator: OperatorNumber,
+			Value: Value{Type: OperatorNumber, Str: "+0.00"}}}}}}
+	if got, err := CanonicalString(f); err != nil || got != p+"[x:=0]" {
+		t.Errorf("plus zero: %s, %v", got, err)
+	}
+	if f.PushBody.Structured.Variables[0].Value.Str != "+0.00" {
+		t.Error("original changed")
+	}
+	if _, err := CanonicalString(nil); err == nil {
+		t.Error("nil frame accepted")
+	}
+}
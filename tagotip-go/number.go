@@ -0,0 +1,136 @@
+package tagotip
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrNotNumber is returned by the numeric accessors of Value for a value
+// that is not a number.
+var ErrNotNumber = errors.New("tagotip: value is not a number")
+
+// ErrInexactNumber is returned by Value.Float64 and LocationValue.Floats,
+// along with the nearest float64, for a number with more significant
+// digits than a float64 holds. Use Decimal to keep such numbers exact.
+var ErrInexactNumber = errors.New("tagotip: number is not exactly representable as a float64")
+
+// Float64 returns the value of a number. A number whose digits do not
+// survive the conversion, such as a 17-digit integer, is returned as the
+// nearest float64 with ErrInexactNumber.
+func (v Value) Float64() (float64, error) {
+	if v.Type != OperatorNumber {
+		return 0, ErrNotNumber
+	}
+	return parseFloat(v.Str)
+}
+
+// Int64 returns the value of a number without a fractional part. It
+// reports false for other values, for numbers with a nonzero fraction, and
+// for numbers outside the range of an int64.
+func (v Value) Int64() (int64, bool) {
+	if v.Type != OperatorNumber {
+		return 0, false
+	}
+	d, err := ParseDecimal(v.Str)
+	if err != nil {
+		return 0, false
+	}
+	neg, intPart, frac := d.parts()
+	if frac != "" {
+		return 0, false
+	}
+	if neg {
+		intPart = "-" + intPart
+	}
+	n, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Floats returns the coordinates of a location, with alt nil when the
+// location has no altitude. Precision loss is reported as by
+// Value.Float64, with all coordinates converted.
+func (l *LocationValue) Floats() (lat, lng float64, alt *float64, err error) {
+	lat, err = parseFloat(l.Lat)
+	lng, lngErr := parseFloat(l.Lng)
+	err = firstNumberErr(err, lngErr)
+	if l.Alt != nil {
+		a, altErr := parseFloat(*l.Alt)
+		alt, err = &a, firstNumberErr(err, altErr)
+	}
+	return lat, lng, alt, err
+}
+
+// parseFloat converts a number in the frame's syntax, reporting
+// ErrInexactNumber when its digits do not round-trip.
+func parseFloat(s string) (float64, error) {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		// Only a range error is possible for valid syntax; f is ±Inf.
+		return f, ErrInexactNumber
+	}
+	back, _ := ParseDecimal(strconv.FormatFloat(f, 'f', -1, 64))
+	if d.Cmp(back) != 0 {
+		return f, ErrInexactNumber
+	}
+	return f, nil
+}
+
+// firstNumberErr returns the first non-nil error, preferring a hard error
+// over ErrInexactNumber.
+func firstNumberErr(a, b error) error {
+	if a == nil || errors.Is(a, ErrInexactNumber) && b != nil {
+		return b
+	}
+	return a
+}
+
+// maxFormatMagnitude is the largest magnitude FormatNumber writes: 1e22,
+// the largest power of ten a float64 holds exactly. Beyond it the digits
+// written in plain notation are mostly padding.
+const maxFormatMagnitude = 1e22
+
+// FormatNumber writes v as a number in the frame's syntax: plain decimal
+// notation, without an exponent, a leading '+', or trailing fractional
+// zeros, and "0" for negative zero. With maxDecimals >= 0, v is rounded to
+// that many digits after the decimal point; with a negative maxDecimals it
+// is written with the fewest digits that read back as the same float64.
+//
+// NaN, infinities, and magnitudes above 1e22 fail rather than being
+// written in exponent form.
+func FormatNumber(v float64, maxDecimals int) (string, error) {
+	s, err := formatNumber(v, maxDecimals)
+	if err != nil {
+		return "", fmt.Errorf("tagotip: %w", err)
+	}
+	return s, nil
+}
+
+// formatNumber is FormatNumber, with errors left for the caller to
+// prefix.
+func formatNumber(v float64, maxDecimals int) (string, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "", fmt.Errorf("%v is not a finite number", v)
+	}
+	if math.Abs(v) > maxFormatMagnitude {
+		return "", fmt.Errorf("%g is too large to write without an exponent", v)
+	}
+	s := strconv.FormatFloat(v, 'f', max(maxDecimals, -1), 64)
+	if strings.IndexByte(s, '.') >= 0 {
+		s = strings.TrimRight(s, "0")
+		s = strings.TrimSuffix(s, ".")
+	}
+	if s == "-0" {
+		s = "0"
+	}
+	return s, nil
+}
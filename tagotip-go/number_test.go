@@ -0,0 +1,170 @@
+package tagotip
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Numeric accessors
+// ============================================================================
+
+func number(s string) Value { return Value{Type: OperatorNumber, Str: s} }
+
+func TestValueFloat64(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    float64
+		inexact bool
+	}{
+		{"0", 0, false},
+		{"0.5", 0.5, false},
+		{"-3.25", -3.25, false},
+		{"21.50", 21.5, false},
+		{"0.1", 0.1, false},
+		{"9007199254740992", 9007199254740992, false},
+		{"9007199254740993", 9007199254740992, true},
+		{"12345678901234567", 12345678901234568, true},
+		{"0.12345678901234567890", 0.12345678901234568, true},
+	} {
+		got, err := number(tc.in).Float64()
+		if got != tc.want || errors.Is(err, ErrInexactNumber) != tc.inexact || (err != nil && !tc.inexact) {
+			t.Errorf("%s: got %v, %v; want %v, inexact %v", tc.in, got, err, tc.want, tc.inexact)
+		}
+	}
+	if f, err := number("-0").Float64(); err != nil || f != 0 || !math.Signbit(f) {
+		t.Errorf("-0: got %v, %v", f, err)
+	}
+}
+
+func TestValueInt64(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"-0", 0, true},
+		{"42", 42, true},
+		{"-7.000", -7, true},
+		{"0.5", 0, false},
+		{"12345678901234567", 12345678901234567, true},
+		{"9223372036854775807", math.MaxInt64, true},
+		{"-9223372036854775808", math.MinInt64, true},
+		{"9223372036854775808", 0, false},
+	} {
+		got, ok := number(tc.in).Int64()
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%s: got %d, %v; want %d, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestNumericAccessorsRejectOtherTypes(t *testing.T) {
+	for _, v := range []Value{
+		{Type: OperatorString, Str: "12"},
+		{Type: OperatorBoolean, Bool: true},
+		{Type: OperatorLocation, Location: &LocationValue{Lat: "1", Lng: "2"}},
+	} {
+		if _, err := v.Float64(); !errors.Is(err, ErrNotNumber) {
+			t.Errorf("%+v: Float64 error %v", v, err)
+		}
+		if _, ok := v.Int64(); ok {
+			t.Errorf("%+v: Int64 accepted", v)
+		}
+		if _, err := v.Decimal(); !errors.Is(err, ErrNotNumber) {
+			t.Errorf("%+v: Decimal error %v", v, err)
+		}
+	}
+}
+
+func TestLocationFloats(t *testing.T) {
+	frame := mustParse(t, "PUSH|"+testAuth+"|dev|[a@=39.74,-104.99,305;b@=-23.5,-46.6]")
+	vars := frame.PushBody.Structured.Variables
+	lat, lng, alt, err := vars[0].Value.Location.Floats()
+	if err != nil || lat != 39.74 || lng != -104.99 || alt == nil || *alt != 305 {
+		t.Errorf("got %v, %v, %v, %v", lat, lng, alt, err)
+	}
+	if _, _, alt, err := vars[1].Value.Location.Floats(); err != nil || alt != nil {
+		t.Errorf("without altitude: %v, %v", alt, err)
+	}
+	loc := &LocationValue{Lat: "1.5", Lng: "12345678901234567", Alt: strPtr("x")}
+	if lat, _, _, err := loc.Floats(); lat != 1.5 || err == nil || errors.Is(err, ErrInexactNumber) {
+		t.Errorf("invalid altitude: %v, %v", lat, err)
+	}
+	loc.Alt = nil
+	if _, _, _, err := loc.Floats(); !errors.Is(err, ErrInexactNumber) {
+		t.Errorf("inexact longitude: %v", err)
+	}
+}
+
+// ============================================================================
+// FormatNumber
+// ============================================================================
+
+func TestFormatNumber(t *testing.T) {
+	for _, tc := range []struct {
+		v        float64
+		decimals int
+		want     string
+	}{
+		{0, -1, "0"},
+		{math.Copysign(0, -1), -1, "0"},
+		{1e6, -1, "1000000"},
+		{1e22, -1, "10000000000000000000000"},
+		{-1e22, -1, "-10000000000000000000000"},
+		{1.5e-7, -1, "0.00000015"},
+		{2.0 / 3, -1, "0.6666666666666666"},
+		{2.0 / 3, 0, "1"},
+		{2.0 / 3, 3, "0.667"},
+		{2.5, 4, "2.5"},
+		{1.999, 2, "2"},
+		{-0.004, 2, "0"},
+		{-1234.5, 0, "-1234"}, // round half to even
+		{math.MaxInt32, 2, "2147483647"},
+	} {
+		got, err := FormatNumber(tc.v, tc.decimals)
+		if err != nil || got != tc.want {
+			t.Errorf("FormatNumber(%v, %d) = %q, %v; want %q", tc.v, tc.decimals, got, err, tc.want)
+		}
+	}
+
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), 1.0000000000000002e22, -1e23, math.MaxFloat64} {
+		if s, err := FormatNumber(v, -1); err == nil || !strings.HasPrefix(err.Error(), "tagotip: ") {
+			t.Errorf("FormatNumber(%v) = %q, %v", v, s, err)
+		}
+	}
+}
+
+func TestFormatNumberRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	values := []float64{math.SmallestNonzeroFloat64, -math.SmallestNonzeroFloat64, 1e-300, 0.1, 123456789.123456789, 1e22}
+	for i := 0; i < 2000; i++ {
+		// Mantissas at every scale the formatter accepts.
+		values = append(values, (r.Float64()*2-1)*math.Pow(10, float64(r.Intn(44)-22)))
+	}
+	for _, v := range values {
+		s, err := FormatNumber(v, -1)
+		if err != nil {
+			t.Fatalf("FormatNumber(%v): %v", v, err)
+		}
+		frame := mustParse(t, "PUSH|"+testAuth+"|dev|[n:="+s+"]")
+		back, err := frame.PushBody.Structured.Variables[0].Value.Float64()
+		if err != nil || back != v {
+			t.Fatalf("%v written as %s, read back as %v, %v", v, s, back, err)
+		}
+
+		rounded, err := FormatNumber(v, 4)
+		if err != nil {
+			t.Fatalf("FormatNumber(%v, 4): %v", v, err)
+		}
+		if err := validateNumber(rounded, 0); err != nil {
+			t.Fatalf("%v rounded as %s: %v", v, rounded, err)
+		}
+		if i := strings.IndexByte(rounded, '.'); i >= 0 && (len(rounded)-i-1 > 4 || strings.HasSuffix(rounded, "0")) {
+			t.Fatalf("%v rounded as %s", v, rounded)
+		}
+	}
+}
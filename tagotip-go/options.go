@@ -0,0 +1,365 @@
+package tagotip
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ParserOptions configures optional parser behaviour. The zero value parses
+// exactly like ParseUplink.
+type ParserOptions struct {
+	// Intern, if non-nil, deduplicates variable names, units, and groups
+	// across parsed frames. Interned strings are copies held by the table
+	// and do not share memory with the input. It is ignored under ZeroCopy.
+	Intern *InternTable
+
+	// ZeroCopy guarantees that every string field of the parsed frame is a
+	// substring of the input: nothing is copied and escape sequences are
+	// kept as written. See UplinkFrame.Detach for the lifetime rules.
+	ZeroCopy bool
+
+	// RetainPassthrough keeps the bytes decoded while validating a
+	// passthrough body so that PassthroughBody.Decode returns them without
+	// decoding again.
+	RetainPassthrough bool
+
+	// LazyMeta defers building metadata pairs. Metadata blocks are still
+	// fully validated, but Meta is left empty and the pairs are parsed on
+	// the first call to Variable.Metadata or StructuredBody.Metadata.
+	LazyMeta bool
+
+	// MaxTotalItems caps the number of variables, metadata pairs, and PULL
+	// variable names in one frame. Items are counted as they are parsed,
+	// so a frame over budget is rejected with ErrTooManyItems at the item
+	// that exhausts it, before the rest of the frame is parsed. Zero or
+	// negative means DefaultMaxTotalItems.
+	MaxTotalItems int
+
+	// AllowUnknownMethods accepts a method token of two to eight upper-case
+	// letters that is not a known method. The header is validated as usual
+	// and the frame is returned with Method set to MethodUnknown, the token
+	// in RawMethod, and everything after the serial in RawBody, so that a
+	// server can still answer ERR|invalid_method with the frame's seq.
+	AllowUnknownMethods bool
+
+	// AllowPingDiagnostics accepts a metadata block as the body of a PING,
+	// parsed into UplinkFrame.PingDiagnostics under the usual metadata
+	// grammar and limits. Without it the body of a PING is ignored and
+	// PingDiagnostics left nil, whatever the body holds.
+	AllowPingDiagnostics bool
+
+	// Verifier, if non-nil, requires frames to be signed (see SignUplink).
+	// The signature field is verified, including the replay rule, and
+	// stripped before parsing, and exposed in UplinkFrame.Signature.
+	// Without a verifier a signature field is not interpreted.
+	Verifier *SignatureVerifier
+
+	// VerifyChecksum strips a checksum trailer (see AppendChecksum) before
+	// parsing and rejects the frame with ErrChecksumMismatch if it does not
+	// match. Frames without a trailer are parsed unchanged.
+	VerifyChecksum bool
+
+	// CollectWarnings records accepted but discouraged constructs in
+	// UplinkFrame.Warnings. Without it the checks are skipped.
+	CollectWarnings bool
+
+	// RejectMalformedAckVariables rejects an "ACK|OK|[...]" detail that is
+	// not a well-formed variable block with the *ParseError of the block,
+	// positioned within the ACK. Without it the detail is kept as text,
+	// and AckFrame.Variables reports the error.
+	RejectMalformedAckVariables bool
+
+	// RejectDuplicateMetaKeys rejects a metadata block that repeats a key
+	// with ErrInvalidMetadata at the repeated pair. Without it the pairs
+	// are kept as written; see MetaPairs.Dedup.
+	RejectDuplicateMetaKeys bool
+
+	// RejectDuplicateVariables rejects a PUSH body that repeats a variable
+	// name with the same timestamp, or with neither variable having one,
+	// with ErrInvalidVariable at the repeat. Datalogger frames, whose
+	// points carry distinct timestamps, are accepted. See
+	// CheckDuplicateVariables.
+	RejectDuplicateVariables bool
+
+	// StrictLineEndings accepts only "\n" as the end of a frame. By
+	// default a trailing "\r\n" or bare '\r', as sent by some modems, is
+	// stripped as well; with StrictLineEndings the '\r' is part of the
+	// frame and fails validation of its last field.
+	StrictLineEndings bool
+
+	// ErrorContext fills in ParseError.Field and ParseError.Snippet, which
+	// Error then prints, to locate an error in a long frame. It costs
+	// nothing until a parse fails.
+	ErrorContext bool
+
+	// Limits raises or lowers the protocol limits the parser enforces,
+	// for deployments whose server accepts frames the protocol constants
+	// rule out. The zero value keeps every constant.
+	Limits ParseLimits
+}
+
+// ParseLimits overrides the limits of the protocol that the parser
+// enforces. A zero field keeps the constant of the same name, such as
+// MaxVariables. A field that is negative or above its bound below makes
+// every parse with the options fail, before input is read:
+//
+//	MaxFrameSize   up to MaxParseFrameSize
+//	MaxVariables   up to MaxParseVariables
+//	MaxMetaPairs   up to MaxParseMetaPairs
+//	other lengths  up to MaxParseFieldLen
+//
+// The limits apply to parsing only. The builders keep the protocol
+// constants, so that frames this package sends are accepted by any
+// server.
+type ParseLimits struct {
+	MaxFrameSize  int // bytes in a frame, including the trailing newline
+	MaxVariables  int // variables in a PUSH body, names in a PULL body
+	MaxMetaPairs  int // pairs in one metadata block
+	MaxVarNameLen int
+	MaxSerialLen  int
+	MaxGroupLen   int
+	MaxMetaKeyLen int
+	MaxUnitLen    int // as written, with escapes
+}
+
+// Bounds on the fields of ParseLimits. They keep the work and memory of a
+// parse within reach of a server, whatever the configuration.
+const (
+	MaxParseFrameSize = 1 << 20
+	MaxParseVariables = 10_000
+	MaxParseMetaPairs = 1_000
+	MaxParseFieldLen  = 4_096
+)
+
+// resolve returns l with its zero fields set to the protocol constants,
+// or an error naming the first field out of bounds.
+func (l ParseLimits) resolve() (ParseLimits, error) {
+	fields := [...]struct {
+		name      string
+		v         *int
+		def, maxV int
+	}{
+		{"MaxFrameSize", &l.MaxFrameSize, MaxFrameSize, MaxParseFrameSize},
+		{"MaxVariables", &l.MaxVariables, MaxVariables, MaxParseVariables},
+		{"MaxMetaPairs", &l.MaxMetaPairs, MaxMetaPairs, MaxParseMetaPairs},
+		{"MaxVarNameLen", &l.MaxVarNameLen, MaxVarNameLen, MaxParseFieldLen},
+		{"MaxSerialLen", &l.MaxSerialLen, MaxSerialLen, MaxParseFieldLen},
+		{"MaxGroupLen", &l.MaxGroupLen, MaxGroupLen, MaxParseFieldLen},
+		{"MaxMetaKeyLen", &l.MaxMetaKeyLen, MaxMetaKeyLen, MaxParseFieldLen},
+		{"MaxUnitLen", &l.MaxUnitLen, MaxUnitLen, MaxParseFieldLen},
+	}
+	for _, f := range fields {
+		switch {
+		case *f.v == 0:
+			*f.v = f.def
+		case *f.v < 0 || *f.v > f.maxV:
+			return ParseLimits{}, fmt.Errorf("tagotip: ParseLimits.%s is %d, outside 1 to %d", f.name, *f.v, f.maxV)
+		}
+	}
+	return l, nil
+}
+
+// defaultLimits holds the protocol constants, as resolved from the zero
+// ParseLimits.
+var defaultLimits, _ = ParseLimits{}.resolve()
+
+// DefaultMaxTotalItems is the item budget used when
+// ParserOptions.MaxTotalItems is not set: MaxVariables variables plus
+// MaxTotalMeta metadata pairs across the frame. When Limits raises
+// MaxVariables, the default budget grows with it.
+//
+// Under this default a parsed frame holds at most about 40KB of parser
+// allocations (100 variables with unit, timestamp, and group, and 512
+// metadata pairs), however the 16KB of input is arranged. Without a budget
+// a frame of 100 variables with 32 one-byte pairs each costs over 120KB.
+const DefaultMaxTotalItems = MaxVariables + MaxTotalMeta
+
+// ParseUplinkWithOptions parses a raw uplink frame string like ParseUplink,
+// applying opts. A nil opts is equivalent to the zero value.
+func ParseUplinkWithOptions(input string, opts *ParserOptions) (*UplinkFrame, error) {
+	frame := &UplinkFrame{}
+	p := newParser(opts)
+	if err := p.parseUplinkInto(frame, input); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// parser carries the options and running state of a single parse call
+// through the body parsers.
+type parser struct {
+	opts *ParserOptions
+
+	items    int // variables, meta pairs, and PULL names parsed so far
+	maxItems int
+
+	lim    ParseLimits // resolved from opts.Limits
+	limErr error       // opts.Limits out of bounds; every parse fails with it
+
+	warnings []Warning // collected under CollectWarnings
+
+	collect bool    // record recoverable errors and go on (ValidateUplink)
+	errs    []error // recorded under collect
+
+	keepEscapes bool // ZeroCopy: string values, units, and metadata values stay as written
+}
+
+var defaultParserOptions ParserOptions
+
+func newParser(opts *ParserOptions) parser {
+	if opts == nil {
+		opts = &defaultParserOptions
+	}
+	lim, limErr := defaultLimits, error(nil)
+	if opts.Limits != (ParseLimits{}) {
+		lim, limErr = opts.Limits.resolve()
+	}
+	maxItems := opts.MaxTotalItems
+	if maxItems <= 0 {
+		maxItems = lim.MaxVariables + MaxTotalMeta
+	}
+	return parser{opts: opts, maxItems: maxItems, lim: lim, limErr: limErr, keepEscapes: opts.ZeroCopy}
+}
+
+// unescape returns the text of a string value, unit, or metadata value,
+// or s as written under ZeroCopy.
+func (p *parser) unescape(s string) string {
+	if p.keepEscapes {
+		return s
+	}
+	return Unescape(s)
+}
+
+// checkInput applies the frame-level checks of checkFrameInput under the
+// parser's limits.
+func (p *parser) checkInput(input string) error {
+	if p.limErr != nil {
+		return p.limErr
+	}
+	return checkFrameInput(input, p.lim.MaxFrameSize)
+}
+
+// skip records err and reports whether parsing goes on past it, which it
+// does only when collecting errors for ValidateUplink. Callers use it for
+// errors confined to one field or variable, after which the rest of the
+// frame can still be read.
+func (p *parser) skip(err error) bool {
+	if !p.collect {
+		return false
+	}
+	p.errs = append(p.errs, err)
+	return true
+}
+
+// inField prefixes the Field of err, a *ParseError, with name under
+// ErrorContext, as err returns through the parser from the part of the
+// frame that failed. Index names such as "variable[3]" are joined with
+// '.', as in "variable[3].unit".
+func (p *parser) inField(err error, name string) error {
+	if !p.opts.ErrorContext {
+		return err
+	}
+	if pe, ok := err.(*ParseError); ok {
+		if pe.Field == "" {
+			pe.Field = name
+		} else {
+			pe.Field = name + "." + pe.Field
+		}
+	}
+	return err
+}
+
+// inVariable is inField for the variable at index i of a body.
+func (p *parser) inVariable(err error, i int) error {
+	if !p.opts.ErrorContext {
+		return err
+	}
+	return p.inField(err, "variable["+strconv.Itoa(i)+"]")
+}
+
+// withContext sets the Snippet of err, a *ParseError, from input under
+// ErrorContext.
+func (p *parser) withContext(err error, input string) error {
+	if pe, ok := err.(*ParseError); ok && p.opts.ErrorContext && pe.Snippet == "" {
+		pe.Snippet = errorSnippet(input, pe.Position)
+	}
+	return err
+}
+
+// spend charges one item at pos against the frame's item budget.
+func (p *parser) spend(pos int) error {
+	p.items++
+	if p.items > p.maxItems {
+		return fail(ErrTooManyItems, pos)
+	}
+	return nil
+}
+
+// intern returns the shared copy of s when interning is enabled, else s.
+func (p *parser) intern(s string) string {
+	if p.opts.Intern == nil || p.opts.ZeroCopy {
+		return s
+	}
+	return p.opts.Intern.Intern(s)
+}
+
+// ---------------------------------------------------------------------------
+// String interning
+// ---------------------------------------------------------------------------
+
+// InternTable is a bounded set of shared strings. Fleets of devices send the
+// same variable names, units, and groups in every frame; interning them
+// lets long-lived frames share one copy of each.
+//
+// Once the table holds maxEntries strings, new strings are returned as-is
+// rather than evicting existing entries. An InternTable is safe for
+// concurrent use and may be shared by parsers on different goroutines.
+type InternTable struct {
+	mu         sync.RWMutex
+	maxEntries int
+	strs       map[string]string
+}
+
+// NewInternTable creates a table holding at most maxEntries strings.
+// maxEntries must be positive.
+func NewInternTable(maxEntries int) *InternTable {
+	if maxEntries <= 0 {
+		panic("tagotip: InternTable maxEntries must be positive")
+	}
+	return &InternTable{
+		maxEntries: maxEntries,
+		strs:       make(map[string]string),
+	}
+}
+
+// Intern returns the table's copy of s, adding a copy of s if it is not
+// yet present and the table is not full.
+func (t *InternTable) Intern(s string) string {
+	t.mu.RLock()
+	v, ok := t.strs[s]
+	t.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if v, ok := t.strs[s]; ok {
+		return v
+	}
+	if len(t.strs) >= t.maxEntries {
+		return s
+	}
+	v = strings.Clone(s)
+	t.strs[v] = v
+	return v
+}
+
+// Len returns the number of strings held by the table.
+func (t *InternTable) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.strs)
+}
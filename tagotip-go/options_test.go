@@ -0,0 +1,847 @@
+package tagotip
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+// =========================================================================
+// ParseUplinkWithOptions
+// =========================================================================
+
+func TestParseUplinkWithOptionsZeroValue(t *testing.T) {
+	input := "PUSH|" + testAuth + "|dev|^batch[temp:=21#C^g1;hum:=40#%]"
+	want, err := ParseUplink(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []*ParserOptions{nil, {}} {
+		got, err := ParseUplinkWithOptions(input, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantRaw, _ := BuildUplink(want)
+		gotRaw, _ := BuildUplink(got)
+		if wantRaw != gotRaw {
+			t.Errorf("opts %+v: got %s, want %s", opts, gotRaw, wantRaw)
+		}
+	}
+}
+
+func TestParseUplinkWithOptionsInterns(t *testing.T) {
+	table := NewInternTable(64)
+	opts := &ParserOptions{Intern: table}
+	frame := "PUSH|" + testAuth + "|dev|^batch[temp:=21#C^g1]"
+
+	a, err := ParseUplinkWithOptions(strings.Clone(frame), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseUplinkWithOptions(strings.Clone(frame), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	va, vb := a.PushBody.Structured.Variables[0], b.PushBody.Structured.Variables[0]
+	if unsafe.StringData(va.Name) != unsafe.StringData(vb.Name) {
+		t.Error("variable names not shared")
+	}
+	if unsafe.StringData(*va.Unit) != unsafe.StringData(*vb.Unit) {
+		t.Error("units not shared")
+	}
+	if unsafe.StringData(*va.Group) != unsafe.StringData(*vb.Group) {
+		t.Error("variable groups not shared")
+	}
+	if unsafe.StringData(*a.PushBody.Structured.Group) != unsafe.StringData(*b.PushBody.Structured.Group) {
+		t.Error("body groups not shared")
+	}
+	if table.Len() != 4 {
+		t.Errorf("expected 4 interned strings, got %d", table.Len())
+	}
+}
+
+func TestParseUplinkWithOptionsInternsPull(t *testing.T) {
+	opts := &ParserOptions{Intern: NewInternTable(64)}
+	a, err := ParseUplinkWithOptions(strings.Clone("PULL|"+testAuth+"|dev|[temp;hum]"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ParseUplinkWithOptions(strings.Clone("PULL|"+testAuth+"|dev|[temp]"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unsafe.StringData(a.PullBody.Variables[0]) != unsafe.StringData(b.PullBody.Variables[0]) {
+		t.Error("PULL variable names not shared")
+	}
+}
+
+// =========================================================================
+// LazyMeta
+// =========================================================================
+
+func TestLazyMetaSameValidationOutcome(t *testing.T) {
+	prefix := "PUSH|" + testAuth + "|dev|"
+	bodies := []string{
+		"{a=b,c=d}[x:=1{k=v,k2=v\\,2}]",
+		"[x:=1{k=v}]",
+		"{}[x:=1]",
+		"[x:=1{}]",
+		"[x:=1{novalue}]",
+		"[x:=1{=v}]",
+		"[x:=1{k=v]",
+		"{a=b[x:=1]",
+		"[x:=1{,,}]",
+		"[x:=1{a=1,b=2,,c=3}]",
+		"[x:=1{bad key=v}]",
+		"[x:=1" + meta32Block(33) + "]",
+		meta32Block(33) + "[x:=1]",
+	}
+	lazy := &ParserOptions{LazyMeta: true}
+	for _, body := range bodies {
+		eager, eagerErr := ParseUplink(prefix + body)
+		lz, lazyErr := ParseUplinkWithOptions(prefix+body, lazy)
+		if (eagerErr == nil) != (lazyErr == nil) {
+			t.Errorf("%q: eager %v, lazy %v", body, eagerErr, lazyErr)
+			continue
+		}
+		if eagerErr != nil {
+			if eagerErr.Error() != lazyErr.Error() {
+				t.Errorf("%q: eager %v, lazy %v", body, eagerErr, lazyErr)
+			}
+			continue
+		}
+		esb, lsb := eager.PushBody.Structured, lz.PushBody.Structured
+		if len(lsb.Meta) != 0 || len(lsb.Variables[0].Meta) != 0 {
+			t.Errorf("%q: lazy parse populated Meta", body)
+		}
+		if !reflect.DeepEqual(esb.Meta, lsb.Metadata()) {
+			t.Errorf("%q: body meta %v, want %v", body, lsb.Meta, esb.Meta)
+		}
+		if !reflect.DeepEqual(esb.Variables[0].Meta, lsb.Variables[0].Metadata()) {
+			t.Errorf("%q: variable meta %v, want %v", body, lsb.Variables[0].Meta, esb.Variables[0].Meta)
+		}
+	}
+}
+
+func meta32Block(n int) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString("k")
+		b.WriteString(strconv.Itoa(i))
+		b.WriteString("=v")
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func TestLazyMetaBuildRoundTrip(t *testing.T) {
+	input := "PUSH|" + testAuth + "|dev|^g{a=b}[x:=1{k=v,k2=w};y:=2]"
+	frame, err := ParseUplinkWithOptions(input, &ParserOptions{LazyMeta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := BuildUplink(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != input {
+		t.Errorf("got %s, want %s", out, input)
+	}
+	// Edits through the accessor take effect.
+	frame.PushBody.Structured.Variables[0].Metadata()[0].Value = "z"
+	out, _ = BuildUplink(frame)
+	if want := "PUSH|" + testAuth + "|dev|^g{a=b}[x:=1{k=z,k2=w};y:=2]"; out != want {
+		t.Errorf("got %s, want %s", out, want)
+	}
+}
+
+func TestLazyMetaIdempotencyKey(t *testing.T) {
+	frame, err := ParseUplinkWithOptions("PUSH|"+testAuth+"|dev|{idem=0123456789abcdef}[x:=1]", &ParserOptions{LazyMeta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := IdempotencyKey(frame); !ok || key != "0123456789abcdef" {
+		t.Errorf("got %q ok=%v", key, ok)
+	}
+}
+
+func TestLazyMetaReusedFrame(t *testing.T) {
+	p := newParser(&ParserOptions{LazyMeta: true})
+	frame := &UplinkFrame{}
+	if err := p.parseUplinkInto(frame, "PUSH|"+testAuth+"|dev|{a=b}[x:=1{k=v}]"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.parseUplinkInto(frame, "PUSH|"+testAuth+"|dev|[x:=1]"); err != nil {
+		t.Fatal(err)
+	}
+	sb := frame.PushBody.Structured
+	if sb.Metadata() != nil || sb.Variables[0].Metadata() != nil {
+		t.Error("stale lazy metadata after reuse")
+	}
+}
+
+// =========================================================================
+// MaxTotalItems
+// =========================================================================
+
+func TestMaxTotalItemsErrorPosition(t *testing.T) {
+	prefix := "PUSH|" + testAuth + "|dev|"
+	cases := []struct {
+		body   string
+		budget int
+		at     string // the item that exhausts the budget
+	}{
+		{"[a=1{k=v,k2=v};b=2]", 3, "b=2"},
+		{"[a=1{k=v,k2=v};b=2]", 2, "k2=v"},
+		{"{m=1,n=2}[a=1]", 2, "a=1"},
+		{"{m=1,n=2}[a=1]", 1, "n=2"},
+	}
+	for _, tc := range cases {
+		_, err := ParseUplinkWithOptions(prefix+tc.body, &ParserOptions{MaxTotalItems: tc.budget})
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Kind != ErrTooManyItems {
+			t.Errorf("%q budget %d: expected ErrTooManyItems, got %v", tc.body, tc.budget, err)
+			continue
+		}
+		if want := len(prefix) + strings.Index(tc.body, tc.at); pe.Position != want {
+			t.Errorf("%q budget %d: position %d, want %d", tc.body, tc.budget, pe.Position, want)
+		}
+		if _, err := ParseUplinkWithOptions(prefix+tc.body, &ParserOptions{MaxTotalItems: 5}); err != nil {
+			t.Errorf("%q: unexpected error within budget: %v", tc.body, err)
+		}
+	}
+}
+
+func TestMaxTotalItemsPull(t *testing.T) {
+	input := "PULL|" + testAuth + "|dev|[a;b;c]"
+	_, err := ParseUplinkWithOptions(input, &ParserOptions{MaxTotalItems: 2})
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Kind != ErrTooManyItems || pe.Position != strings.Index(input, "c]") {
+		t.Errorf("expected ErrTooManyItems at the third name, got %v", err)
+	}
+}
+
+func TestMaxTotalItemsCountsLazyMeta(t *testing.T) {
+	input := "PUSH|" + testAuth + "|dev|[a=1{k=v,k2=v}]"
+	_, err := ParseUplinkWithOptions(input, &ParserOptions{MaxTotalItems: 2, LazyMeta: true})
+	assertParseError(t, err, ErrTooManyItems)
+}
+
+func TestDefaultMaxTotalItems(t *testing.T) {
+	if _, err := ParseUplink(worstCaseFrame()); err != nil {
+		t.Fatalf("worst-case frame within the default budget rejected: %v", err)
+	}
+	_, err := ParseUplink(adversarialFrame())
+	assertParseError(t, err, ErrTooManyItems)
+}
+
+// =========================================================================
+// InternTable
+// =========================================================================
+
+func TestInternTableCopiesInput(t *testing.T) {
+	table := NewInternTable(4)
+	input := "temperature"
+	got := table.Intern(input[:4])
+	if got != "temp" {
+		t.Fatalf("got %q", got)
+	}
+	if unsafe.StringData(got) == unsafe.StringData(input) {
+		t.Error("interned string should not alias the input")
+	}
+}
+
+func TestInternTableBounded(t *testing.T) {
+	table := NewInternTable(2)
+	table.Intern("a")
+	table.Intern("b")
+	c := "c"
+	if got := table.Intern(c); unsafe.StringData(got) != unsafe.StringData(c) {
+		t.Error("full table should return the input unchanged")
+	}
+	if table.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", table.Len())
+	}
+}
+
+func TestInternTableConcurrent(t *testing.T) {
+	opts := &ParserOptions{Intern: NewInternTable(16)}
+	frame := "PUSH|" + testAuth + "|dev|[temp:=21#C;hum:=40#%^g1]"
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if _, err := ParseUplinkWithOptions(frame, opts); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if n := opts.Intern.Len(); n != 5 {
+		t.Errorf("expected 5 interned strings, got %d", n)
+	}
+}
+
+func TestNewInternTablePanicsOnNonPositive(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic")
+		}
+	}()
+	NewInternTable(0)
+}
+
+// =========================================================================
+// AllowUnknownMethods
+// =========================================================================
+
+func TestAllowUnknownMethods(t *testing.T) {
+	opts := &ParserOptions{AllowUnknownMethods: true}
+	seq0, seq7 := uint32(0), uint32(7)
+	cases := []struct {
+		input     string
+		seq       *uint32
+		rawMethod string
+		rawBody   string
+	}{
+		{"SUBS|" + testAuth + "|dev", nil, "SUBS", ""},
+		{"SUBS|!7|" + testAuth + "|dev|[a;b]", &seq7, "SUBS", "[a;b]"},
+		{"XX|" + testAuth + "|dev|a|b\\|c\n", nil, "XX", "a|b\\|c"},
+		{"ABCDEFGH|!0|" + testAuth + "|dev|", &seq0, "ABCDEFGH", ""},
+	}
+	for _, tc := range cases {
+		f, err := ParseUplinkWithOptions(tc.input, opts)
+		if err != nil {
+			t.Errorf("%q: %v", tc.input, err)
+			continue
+		}
+		if f.Method != MethodUnknown || f.RawMethod != tc.rawMethod || f.RawBody != tc.rawBody {
+			t.Errorf("%q: got method %v %q body %q", tc.input, f.Method, f.RawMethod, f.RawBody)
+		}
+		if !reflect.DeepEqual(f.Seq, tc.seq) || f.Auth != testAuth || f.Serial != "dev" {
+			t.Errorf("%q: header not preserved: %+v", tc.input, f)
+		}
+		if f.PushBody != nil || f.PullBody != nil {
+			t.Errorf("%q: unexpected parsed body", tc.input)
+		}
+	}
+}
+
+func TestAllowUnknownMethodsRejects(t *testing.T) {
+	opts := &ParserOptions{AllowUnknownMethods: true}
+	for _, tc := range []struct {
+		input string
+		kind  ParseErrorKind
+	}{
+		{"S|" + testAuth + "|dev", ErrInvalidMethod},
+		{"ABCDEFGHI|" + testAuth + "|dev", ErrInvalidMethod},
+		{"Subs|" + testAuth + "|dev", ErrInvalidMethod},
+		{"SUB1|" + testAuth + "|dev", ErrInvalidMethod},
+		{"SUBS|!01|" + testAuth + "|dev", ErrInvalidSeq},
+		{"SUBS|bad|dev", ErrInvalidAuth},
+		{"SUBS|" + testAuth + "|bad serial", ErrInvalidSerial},
+	} {
+		_, err := ParseUplinkWithOptions(tc.input, opts)
+		assertParseError(t, err, tc.kind)
+	}
+}
+
+func TestUnknownMethodStrictByDefault(t *testing.T) {
+	input := "SUBS|!7|" + testAuth + "|dev|[a]"
+	_, err := ParseUplink(input)
+	assertParseError(t, err, ErrInvalidMethod)
+	_, err = ParseUplinkWithOptions(input, &ParserOptions{})
+	assertParseError(t, err, ErrInvalidMethod)
+
+	// Known methods parse the same in permissive mode.
+	known := "PUSH|!7|" + testAuth + "|dev|[a=1]"
+	f, err := ParseUplinkWithOptions(known, &ParserOptions{AllowUnknownMethods: true})
+	if err != nil || f.Method != MethodPush || f.RawMethod != "" || f.RawBody != "" {
+		t.Fatalf("known method in permissive mode: %+v, %v", f, err)
+	}
+}
+
+func TestUnknownMethodNotBuilt(t *testing.T) {
+	f, err := ParseUplinkWithOptions("SUBS|"+testAuth+"|dev|x", &ParserOptions{AllowUnknownMethods: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := BuildUplink(f); err == nil || !strings.Contains(err.Error(), `"SUBS"`) {
+		t.Errorf("BuildUplink: got %q, %v", raw, err)
+	}
+}
+
+func TestUnknownMethodSeqEcho(t *testing.T) {
+	f, err := ParseUplinkWithOptions("SUBS|!42|"+testAuth+"|dev|[a]", &ParserOptions{AllowUnknownMethods: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply := &AckFrame{
+		Seq:    f.Seq,
+		Status: AckStatusErr,
+		Detail: &AckDetail{Type: "error", Text: "invalid_method", ErrorCode: ErrorCodeInvalidMethod},
+	}
+	raw, err := BuildAck(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ACK|!42|ERR|invalid_method"; raw != want {
+		t.Fatalf("got %q, want %q", raw, want)
+	}
+	ack, err := ParseAck(raw)
+	if err != nil || ack.Seq == nil || *ack.Seq != 42 || ack.Detail.ErrorCode != ErrorCodeInvalidMethod {
+		t.Fatalf("reply does not round-trip: %+v, %v", ack, err)
+	}
+
+	// Reusing the frame for a known method clears the raw fields.
+	p := newParser(&ParserOptions{AllowUnknownMethods: true})
+	if err := p.parseUplinkInto(f, "PING|"+testAuth+"|dev"); err != nil {
+		t.Fatal(err)
+	}
+	if f.Method != MethodPing || f.RawMethod != "" || f.RawBody != "" {
+		t.Errorf("reused frame: %+v", f)
+	}
+}
+
+// =========================================================================
+// AllowPingDiagnostics
+// =========================================================================
+
+func TestPingDiagnostics(t *testing.T) {
+	opts := &ParserOptions{AllowPingDiagnostics: true}
+	input := "PING|!9|" + testAuth + "|dev|{rssi=-87,bat=3.71,fw=1.4.2,note=a\\,b}"
+	f, err := ParseUplinkWithOptions(input, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MetaPair{{"rssi", "-87"}, {"bat", "3.71"}, {"fw", "1.4.2"}, {"note", "a,b"}}
+	if f.Method != MethodPing || !reflect.DeepEqual(f.PingDiagnostics, want) {
+		t.Fatalf("got %+v", f)
+	}
+	raw, err := BuildUplink(f)
+	if err != nil || raw != input {
+		t.Errorf("round trip: got %q, %v", raw, err)
+	}
+}
+
+func TestPingDiagnosticsAbsent(t *testing.T) {
+	opts := &ParserOptions{AllowPingDiagnostics: true}
+	for _, input := range []string{
+		"PING|" + testAuth + "|dev",
+		"PING|" + testAuth + "|dev|",
+		"PING|" + testAuth + "|dev\n",
+	} {
+		for _, o := range []*ParserOptions{nil, opts} {
+			f, err := ParseUplinkWithOptions(input, o)
+			if err != nil {
+				t.Fatalf("%q: %v", input, err)
+			}
+			if f.PingDiagnostics != nil {
+				t.Errorf("%q: unexpected diagnostics %+v", input, f.PingDiagnostics)
+			}
+		}
+	}
+	raw, err := BuildUplink(&UplinkFrame{Method: MethodPing, Auth: testAuth, Serial: "dev"})
+	if err != nil || raw != "PING|"+testAuth+"|dev" {
+		t.Errorf("build without diagnostics: %q, %v", raw, err)
+	}
+}
+
+func TestPingDiagnosticsIgnoredByDefault(t *testing.T) {
+	// Without the option, a PING body is ignored, as it always was.
+	for _, body := range []string{"{rssi=-87}", "anything", "{K=v"} {
+		input := "PING|" + testAuth + "|dev|" + body
+		for _, opts := range []*ParserOptions{nil, {}} {
+			f, err := ParseUplinkWithOptions(input, opts)
+			if err != nil || f.Method != MethodPing || f.Serial != "dev" || f.PingDiagnostics != nil {
+				t.Errorf("%q: %+v, %v", input, f, err)
+			}
+		}
+	}
+}
+
+func TestPingDiagnosticsRejects(t *testing.T) {
+	opts := &ParserOptions{AllowPingDiagnostics: true}
+	prefix := "PING|" + testAuth + "|dev|"
+	for _, tc := range []struct {
+		body string
+		kind ParseErrorKind
+	}{
+		{"rssi=-87", ErrInvalidMetadata},
+		{"{rssi=-87", ErrInvalidMetadata},
+		{"{rssi=-87}x", ErrInvalidMetadata},
+		{"{}", ErrInvalidMetadata},
+		{"{RSSI=-87}", ErrInvalidMetadata},
+		{"{rssi}", ErrInvalidMetadata},
+		{"{" + strings.Repeat("k=v,", MaxMetaPairs) + "k=v}", ErrTooManyItems},
+	} {
+		_, err := ParseUplinkWithOptions(prefix+tc.body, opts)
+		assertParseError(t, err, tc.kind)
+	}
+	_, err := ParseUplinkWithOptions(prefix+"{a=1,b=2,c=3}", &ParserOptions{AllowPingDiagnostics: true, MaxTotalItems: 2})
+	assertParseError(t, err, ErrTooManyItems)
+}
+
+func TestPingDiagnosticsBuildRoundTrip(t *testing.T) {
+	f := &UplinkFrame{Method: MethodPing, Auth: testAuth, Serial: "dev",
+		PingDiagnostics: []MetaPair{{Key: "bat", Value: "3.71"}}}
+	raw, err := BuildUplink(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "PING|" + testAuth + "|dev|{bat=3.71}"; raw != want {
+		t.Fatalf("got %q, want %q", raw, want)
+	}
+	parsed, err := ParseUplinkWithOptions(raw, &ParserOptions{AllowPingDiagnostics: true})
+	if err != nil || !parsed.Equal(f) {
+		t.Errorf("parsed %+v, %v", parsed, err)
+	}
+}
+
+// =========================================================================
+// RejectDuplicateMetaKeys
+// =========================================================================
+
+func TestRejectDuplicateMetaKeys(t *testing.T) {
+	prefix := "PUSH|" + testAuth + "|dev|"
+	for _, lazy := range []bool{false, true} {
+		opts := &ParserOptions{RejectDuplicateMetaKeys: true, LazyMeta: lazy}
+		for _, body := range []string{
+			"{source=a,fw=1,source=b}[t:=1]",
+			"[t:=1{source=a,fw=1,source=b}]",
+		} {
+			_, err := ParseUplinkWithOptions(prefix+body, opts)
+			var pe *ParseError
+			if !errors.As(err, &pe) || pe.Kind != ErrInvalidMetadata || (prefix + body)[pe.Position:][:8] != "source=b" {
+				t.Errorf("lazy=%v %s: %v", lazy, body, err)
+			}
+		}
+
+		// Only a repeat within one block counts.
+		if _, err := ParseUplinkWithOptions(prefix+"{source=a}[t:=1{source=b};h:=2{source=c}]", opts); err != nil {
+			t.Errorf("lazy=%v: separate blocks rejected: %v", lazy, err)
+		}
+	}
+	f, err := ParseUplink(prefix + "[t:=1{source=a,source=b}]")
+	if err != nil || len(f.PushBody.Structured.Variables[0].Meta) != 2 {
+		t.Errorf("default: %+v, %v", f, err)
+	}
+}
+
+// Past dupScanLimit keys the check keeps a set; a repeat must still be
+// found.
+func TestRejectDuplicateMetaKeysPastScanLimit(t *testing.T) {
+	var keys []string
+	for i := range 3 * dupScanLimit {
+		keys = append(keys, "k"+strconv.Itoa(i)+"=v")
+	}
+	prefix := "PUSH|" + testAuth + "|dev|"
+	opts := &ParserOptions{RejectDuplicateMetaKeys: true}
+	if _, err := ParseUplinkWithOptions(prefix+"{"+strings.Join(keys, ",")+"}[t:=1]", opts); err != nil {
+		t.Fatal(err)
+	}
+	input := prefix + "{" + strings.Join(append(keys, "k5=w"), ",") + "}[t:=1]"
+	_, err := ParseUplinkWithOptions(input, opts)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Kind != ErrInvalidMetadata || !strings.HasPrefix(input[pe.Position:], "k5=w") {
+		t.Errorf("%v", err)
+	}
+}
+
+// =========================================================================
+// RejectDuplicateVariables
+// =========================================================================
+
+func TestRejectDuplicateVariables(t *testing.T) {
+	prefix := "PUSH|" + testAuth + "|dev|"
+	opts := &ParserOptions{RejectDuplicateVariables: true}
+	for _, tc := range []struct {
+		body string
+		dup  string // the repeat, empty when the body is accepted
+	}{
+		{"[temp:=1;hum:=2;temp:=3]", "temp:=3"},
+		{"[temp:=1@1700000000000;temp:=2@1700000000000]", "temp:=2@1700000000000"},
+		{"@1700000000000[temp:=1;temp:=2]", "temp:=2"},
+		{"[temp:=1@1700000000000;temp:=2@1700000001000;temp:=3@1700000002000]", ""},
+		{"[temp:=1;temp:=2@1700000000000]", ""},
+		{"[temp:=1;temp_2:=2]", ""},
+	} {
+		input := prefix + tc.body
+		f, err := ParseUplinkWithOptions(input, opts)
+		if tc.dup == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tc.body, err)
+				continue
+			}
+			if err := CheckDuplicateVariables(f.PushBody.Structured); err != nil {
+				t.Errorf("%s: CheckDuplicateVariables: %v", tc.body, err)
+			}
+			continue
+		}
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Kind != ErrInvalidVariable || !strings.HasPrefix(input[pe.Position:], tc.dup) {
+			t.Errorf("%s: %v", tc.body, err)
+		}
+
+		f = mustParse(t, input)
+		var de *DuplicateVariableError
+		err = CheckDuplicateVariables(f.PushBody.Structured)
+		if !errors.As(err, &de) || de.Second != len(f.PushBody.Structured.Variables)-1 || de.Name != "temp" {
+			t.Errorf("%s: CheckDuplicateVariables: %v", tc.body, err)
+		}
+	}
+	if _, err := ParseUplink(prefix + "[temp:=1;temp:=2]"); err != nil {
+		t.Errorf("rejected without the option: %v", err)
+	}
+}
+
+// Past dupScanLimit variables the checks keep a set; a repeat must still
+// be found, and reported against the first of its kind.
+func TestRejectDuplicateVariablesPastScanLimit(t *testing.T) {
+	const n = 3 * dupScanLimit
+	var vars []string
+	for i := range n {
+		vars = append(vars, "v"+strconv.Itoa(i)+":=1")
+	}
+	prefix := "PUSH|" + testAuth + "|dev|"
+	opts := &ParserOptions{RejectDuplicateVariables: true}
+	f, err := ParseUplinkWithOptions(prefix+"["+strings.Join(vars, ";")+"]", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckDuplicateVariables(f.PushBody.Structured); err != nil {
+		t.Errorf("CheckDuplicateVariables: %v", err)
+	}
+
+	input := prefix + "[" + strings.Join(append(vars, "v3:=2", "v3:=3"), ";") + "]"
+	_, err = ParseUplinkWithOptions(input, opts)
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Kind != ErrInvalidVariable || !strings.HasPrefix(input[pe.Position:], "v3:=2") {
+		t.Errorf("%v", err)
+	}
+	var de *DuplicateVariableError
+	err = CheckDuplicateVariables(mustParse(t, input).PushBody.Structured)
+	if !errors.As(err, &de) || de.First != 3 || de.Second != n {
+		t.Errorf("CheckDuplicateVariables: %v", err)
+	}
+}
+
+// =========================================================================
+// Limits
+// =========================================================================
+
+// bigPush returns a PUSH frame with n variables.
+func bigPush(n int) string {
+	var b strings.Builder
+	b.WriteString("PUSH|" + testAuth + "|dev|[")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString("v" + strconv.Itoa(i) + ":=1")
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func TestParseLimits(t *testing.T) {
+	// 500 variables, over the protocol's 100 and its item budget.
+	input := bigPush(500)
+	_, err := ParseUplink(input)
+	assertParseError(t, err, ErrTooManyItems)
+	opts := &ParserOptions{Limits: ParseLimits{MaxVariables: 500}}
+	f, err := ParseUplinkWithOptions(input, opts)
+	if err != nil || len(f.PushBody.Structured.Variables) != 500 {
+		t.Fatalf("500 variables: %v", err)
+	}
+	_, err = ParseUplinkWithOptions(bigPush(501), opts)
+	assertParseError(t, err, ErrTooManyItems)
+
+	// Frames beyond MaxFrameSize.
+	long := "PUSH|" + testAuth + "|dev|[note=" + strings.Repeat("x", MaxFrameSize) + "]"
+	_, err = ParseUplink(long)
+	assertParseError(t, err, ErrFrameTooLarge)
+	if _, err := ParseUplinkWithOptions(long, &ParserOptions{Limits: ParseLimits{MaxFrameSize: 64 << 10}}); err != nil {
+		t.Errorf("64KB frame: %v", err)
+	}
+
+	// Lengths, raised and lowered.
+	unit := "PUSH|" + testAuth + "|dev|[t:=1#" + strings.Repeat("u", 40) + "]"
+	_, err = ParseUplink(unit)
+	assertParseError(t, err, ErrInvalidVariable)
+	if _, err := ParseUplinkWithOptions(unit, &ParserOptions{Limits: ParseLimits{MaxUnitLen: 40}}); err != nil {
+		t.Errorf("40-byte unit: %v", err)
+	}
+	short := &ParserOptions{Limits: ParseLimits{MaxSerialLen: 4, MaxMetaPairs: 1, MaxVarNameLen: 3}}
+	for _, tc := range []struct {
+		input string
+		kind  ParseErrorKind
+	}{
+		{"PING|" + testAuth + "|sensor", ErrInvalidSerial},
+		{"PUSH|" + testAuth + "|dev|{a=1,b=2}[t:=1]", ErrTooManyItems},
+		{"PUSH|" + testAuth + "|dev|[temp:=1]", ErrInvalidVariable},
+		{"PULL|" + testAuth + "|dev|[temp]", ErrInvalidVariable},
+	} {
+		_, err := ParseUplinkWithOptions(tc.input, short)
+		assertParseError(t, err, tc.kind)
+	}
+	if _, err := ParseUplinkWithOptions("PUSH|"+testAuth+"|dev|{a=1}[t:=1]", short); err != nil {
+		t.Errorf("within lowered limits: %v", err)
+	}
+}
+
+func TestParseLimitsBounds(t *testing.T) {
+	for _, lim := range []ParseLimits{
+		{MaxVariables: 10_000_000},
+		{MaxFrameSize: MaxParseFrameSize + 1},
+		{MaxMetaPairs: -1},
+		{MaxUnitLen: MaxParseFieldLen + 1},
+	} {
+		opts := &ParserOptions{Limits: lim}
+		_, err := ParseUplinkWithOptions("PING|"+testAuth+"|dev", opts)
+		var pe *ParseError
+		if err == nil || errors.As(err, &pe) || !strings.Contains(err.Error(), "ParseLimits.") {
+			t.Errorf("%+v: got %v", lim, err)
+		}
+		if _, err := ParseAckWithOptions("ACK|OK", opts); err == nil {
+			t.Errorf("%+v: ACK parsed", lim)
+		}
+		if errs := ValidateUplinkWithOptions("PING|"+testAuth+"|dev", opts); len(errs) != 1 {
+			t.Errorf("%+v: ValidateUplinkWithOptions: %v", lim, errs)
+		}
+	}
+	at := ParseLimits{MaxVariables: MaxParseVariables, MaxFrameSize: MaxParseFrameSize}
+	if _, err := ParseUplinkWithOptions("PING|"+testAuth+"|dev", &ParserOptions{Limits: at}); err != nil {
+		t.Errorf("limits at their bounds: %v", err)
+	}
+}
+
+func TestParseAckWithOptions(t *testing.T) {
+	input := "ACK|!3|OK|[temp:=1#" + strings.Repeat("u", 30) + "]"
+	f, err := ParseAckWithOptions(input, nil)
+	if err != nil || f.Detail.Vars != nil {
+		t.Fatalf("default limits: %+v, %v", f, err)
+	}
+	f, err = ParseAckWithOptions(input, &ParserOptions{Limits: ParseLimits{MaxUnitLen: 30}})
+	if err != nil || f.Detail.Vars == nil || *f.Seq != 3 {
+		t.Fatalf("raised unit limit: %+v, %v", f, err)
+	}
+	_, err = ParseAckWithOptions("ACK|OK|"+strings.Repeat("1", MaxFrameSize), nil)
+	assertParseError(t, err, ErrFrameTooLarge)
+}
+
+// =========================================================================
+// ErrorContext
+// =========================================================================
+
+func TestErrorContextField(t *testing.T) {
+	opts := &ParserOptions{ErrorContext: true, AllowPingDiagnostics: true}
+	head := "PUSH|" + testAuth + "|dev|"
+	for _, tc := range []struct{ input, field string }{
+		{"POST|" + testAuth + "|dev|[a:=1]", "method"},
+		{"PUSH|!x|" + testAuth + "|dev|[a:=1]", "seq"},
+		{"PUSH|at01|dev|[a:=1]", "auth"},
+		{"PUSH|" + testAuth + "|bad serial|[a:=1]", "serial"},
+		{head + "a:=1", "body"},
+		{head + "@x[a:=1]", "body.timestamp"},
+		{head + "^G[a:=1]", "body.group"},
+		{head + "{K=1}[a:=1]", "body.meta"},
+		{head + ">xZZ", "body.passthrough"},
+		{head + "[a:=1;B:=2]", "variable[1].name"},
+		{head + "[a:=1;b:=x]", "variable[1].value"},
+		{head + "[a:=1;b:=1;c]", "variable[2].operator"},
+		{head + "[a:=1#m,s]", "variable[0].unit"},
+		{head + "[a:=1@x]", "variable[0].timestamp"},
+		{head + "[a:=1^G]", "variable[0].group"},
+		{head + "[a:=1;b:=1{k}]", "variable[1].meta"},
+		{"PULL|" + testAuth + "|dev|[a;b;C]", "variable[2]"},
+		{"PING|" + testAuth + "|dev|{K=v}", "diagnostics"},
+	} {
+		_, err := ParseUplinkWithOptions(tc.input, opts)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Field != tc.field {
+			t.Errorf("%s: got %v, want field %s", tc.input, err, tc.field)
+		}
+	}
+
+	// Without the option, errors are as before.
+	_, err := ParseUplink(head + "[a:=1;B:=2]")
+	if pe := err.(*ParseError); pe.Field != "" || pe.Snippet != "" || strings.Contains(err.Error(), "\n") {
+		t.Errorf("without ErrorContext: %q", err)
+	}
+}
+
+func TestErrorContextSnippet(t *testing.T) {
+	opts := &ParserOptions{ErrorContext: true}
+	input := "PUSH|" + testAuth + "|sensor-01|[temp:=21#°C;hum:=x;ok?=true]"
+	_, err := ParseUplinkWithOptions(input, opts)
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatal(err)
+	}
+	want := "…#°C;hum:=x;ok?=true…\n" +
+		"          ^"
+	if pe.Snippet != want {
+		t.Errorf("snippet\n%s\nwant\n%s", pe.Snippet, want)
+	}
+	msg := "tagotip: invalid_variable at position " + strconv.Itoa(pe.Position) +
+		" in variable[1].value: " + pe.Expected + "\n" + want
+	if err.Error() != msg {
+		t.Errorf("Error() = %q", err.Error())
+	}
+
+	// Tokens near the error are redacted, and positions at the ends of
+	// the input are marked.
+	_, err = ParseUplinkWithOptions("PUSH|"+testAuth+"|s d|[a:=1]", opts)
+	pe = err.(*ParseError)
+	if strings.Contains(pe.Snippet, "cdef") || pe.Snippet != "…*********|s d|[a:=1]\n           ^" {
+		t.Errorf("redaction: %q", pe.Snippet)
+	}
+	_, err = ParseUplinkWithOptions("X\n", opts)
+	if pe = err.(*ParseError); pe.Snippet != "X.\n^" {
+		t.Errorf("start of input: %q", pe.Snippet)
+	}
+
+	// ValidateUplink and ParseAckWithOptions fill in context too.
+	errs := ValidateUplinkWithOptions("PUSH|"+testAuth+"|dev|[A:=1;b:=2;C:=3]", opts)
+	if len(errs) != 2 || errs[0].(*ParseError).Field != "variable[0].name" ||
+		errs[1].(*ParseError).Field != "variable[2].name" || errs[1].(*ParseError).Snippet == "" {
+		t.Errorf("ValidateUplink: %v", errs)
+	}
+	if _, err := ParseAckWithOptions("ACK|!x|OK", opts); err == nil || err.(*ParseError).Snippet != "ACK|!x|OK\n    ^" {
+		t.Errorf("ACK: %q", err)
+	}
+}
+
+// =========================================================================
+// StrictLineEndings
+// =========================================================================
+
+func TestStrictLineEndings(t *testing.T) {
+	strict := &ParserOptions{StrictLineEndings: true}
+	if _, err := ParseUplinkWithOptions("PING|"+testAuth+"|dev\n", strict); err != nil {
+		t.Errorf("LF: %v", err)
+	}
+	for _, input := range []string{"PING|" + testAuth + "|dev\r\n", "PING|" + testAuth + "|dev\r"} {
+		_, err := ParseUplinkWithOptions(input, strict)
+		assertParseError(t, err, ErrInvalidSerial)
+	}
+	_, err := ParseAckWithOptions("ACK|PONG\r\n", strict)
+	assertParseError(t, err, ErrInvalidAck)
+	if _, err := ParseAckWithOptions("ACK|PONG\r\n", nil); err != nil {
+		t.Errorf("ACK without strict: %v", err)
+	}
+}
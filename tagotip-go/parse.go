@@ -1,6 +1,14 @@
 package tagotip
 
-import "strings"
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unsafe"
+)
 
 const maxFields = 8
 
@@ -9,26 +17,45 @@ const maxFields = 8
 // ---------------------------------------------------------------------------
 
 func splitFields(input string) []string {
-	fields := make([]string, 0, 5)
+	return appendFields(make([]string, 0, 5), input)
+}
+
+// appendFields appends the pipe-separated fields of input to fields.
+//
+// The scanners in this file share one strategy: the text between two
+// backslashes cannot contain an escape, so it is searched with
+// strings.IndexByte, and only the two bytes of each escape sequence are
+// stepped over by hand.
+func appendFields(fields []string, input string) []string {
 	start := 0
 	i := 0
+	next := -1 // index of the next backslash at or after i, or len(input)
 	for i < len(input) {
-		if input[i] == '\\' && i+1 < len(input) {
-			i += 2
-			continue
+		if next < i {
+			next = strings.IndexByte(input[i:], '\\')
+			if next < 0 {
+				next = len(input)
+			} else {
+				next += i
+			}
 		}
-		if input[i] == '|' {
-			fields = append(fields, input[start:i])
-			start = i + 1
-			if len(fields) == maxFields-1 {
-				fields = append(fields, input[start:])
-				return fields
+		j := strings.IndexByte(input[i:next], '|')
+		if j < 0 {
+			if next == len(input) {
+				break
 			}
+			i = next + 2
+			continue
+		}
+		i += j
+		fields = append(fields, input[start:i])
+		start = i + 1
+		if len(fields) == maxFields-1 {
+			return append(fields, input[start:])
 		}
 		i++
 	}
-	fields = append(fields, input[start:])
-	return fields
+	return append(fields, input[start:])
 }
 
 // ---------------------------------------------------------------------------
@@ -48,6 +75,20 @@ func parseMethod(s string) (Method, error) {
 	}
 }
 
+// isMethodToken reports whether s has the shape of a method, [A-Z]{2,8},
+// for ParserOptions.AllowUnknownMethods.
+func isMethodToken(s string) bool {
+	if len(s) < 2 || len(s) > 8 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < 'A' || s[i] > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
 func parseSeq(s string, pos int) (uint32, error) {
 	if len(s) == 0 || s[0] != '!' {
 		return 0, fail(ErrInvalidSeq, pos)
@@ -107,14 +148,22 @@ func validateAuth(s string, pos int) error {
 func findUnescapedChar(s string, target byte, start int) int {
 	i := start
 	for i < len(s) {
-		if s[i] == '\\' && i+1 < len(s) {
+		if s[i] == '\\' {
 			i += 2
 			continue
 		}
-		if s[i] == target {
-			return i
+		seg := s[i:]
+		bs := strings.IndexByte(seg, '\\')
+		if bs >= 0 {
+			seg = seg[:bs]
 		}
-		i++
+		if j := strings.IndexByte(seg, target); j >= 0 {
+			return i + j
+		}
+		if bs < 0 {
+			return -1
+		}
+		i += bs + 2
 	}
 	return -1
 }
@@ -158,25 +207,58 @@ func findClosingBrace(s string, start int) int {
 func scanUntilAny(s string, pos int, stops string) int {
 	i := pos
 	for i < len(s) {
-		if s[i] == '\\' && i+1 < len(s) {
+		if s[i] == '\\' {
+			// Runs of escapes, as in adversarial input, skip the searches.
 			i += 2
 			continue
 		}
-		if strings.IndexByte(stops, s[i]) >= 0 {
-			return i
+		seg := s[i:]
+		bs := strings.IndexByte(seg, '\\')
+		if bs >= 0 {
+			seg = seg[:bs]
+		}
+		found := false
+		for k := 0; k < len(stops); k++ {
+			if j := strings.IndexByte(seg, stops[k]); j >= 0 {
+				seg = seg[:j]
+				found = true
+			}
+		}
+		if found {
+			return i + len(seg)
+		}
+		if bs < 0 {
+			break
+		}
+		i += bs + 2
+	}
+	return len(s)
+}
+
+// countItems returns an upper bound on the number of sep-separated items in
+// s (unescaped separators plus one), capped at limit. It is used to size
+// slices before parsing.
+func countItems(s string, sep byte, limit int) int {
+	n := 1
+	for i := 0; i < len(s) && n < limit; i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == sep {
+			n++
 		}
-		i++
 	}
-	return i
+	return n
 }
 
 func validateDigits(s string, pos int) error {
 	if len(s) == 0 {
-		return fail(ErrInvalidModifier, pos)
+		return failf(ErrInvalidModifier, pos, "expected digits for timestamp")
 	}
 	for i := 0; i < len(s); i++ {
 		if s[i] < '0' || s[i] > '9' {
-			return fail(ErrInvalidModifier, pos)
+			return failf(ErrInvalidModifier, pos, "expected digits for timestamp")
 		}
 	}
 	return nil
@@ -184,25 +266,23 @@ func validateDigits(s string, pos int) error {
 
 func validateTimestamp(s string, pos int) error {
 	if len(s) == 0 {
-		return fail(ErrInvalidVariable, pos)
+		return failf(ErrInvalidVariable, pos, "expected digits for timestamp")
 	}
 	for i := 0; i < len(s); i++ {
 		if s[i] < '0' || s[i] > '9' {
-			return fail(ErrInvalidVariable, pos)
+			return failf(ErrInvalidVariable, pos, "expected digits for timestamp")
 		}
 	}
 	return nil
 }
 
-func isHexDigit(ch byte) bool {
-	return (ch >= '0' && ch <= '9') || (ch >= 'a' && ch <= 'f') || (ch >= 'A' && ch <= 'F')
-}
-
 // ---------------------------------------------------------------------------
 // Metadata parsing
 // ---------------------------------------------------------------------------
 
-func parseMetaPair(s string, pos int) (MetaPair, error) {
+// parseMetaPair splits a key=value pair. The value is returned as written;
+// scanMetadata unescapes the pairs it keeps.
+func (p *parser) parseMetaPair(s string, pos int) (MetaPair, error) {
 	i := 0
 	for i < len(s) {
 		if s[i] == '\\' && i+1 < len(s) {
@@ -212,22 +292,39 @@ func parseMetaPair(s string, pos int) (MetaPair, error) {
 		if s[i] == '=' {
 			key := s[:i]
 			value := s[i+1:]
-			if err := validateMetaKey(key, pos); err != nil {
+			if err := validateMetaKeyLen(key, pos, p.lim.MaxMetaKeyLen); err != nil {
 				return MetaPair{}, err
 			}
 			return MetaPair{Key: key, Value: value}, nil
 		}
 		i++
 	}
-	return MetaPair{}, fail(ErrInvalidMetadata, pos)
+	return MetaPair{}, failf(ErrInvalidMetadata, pos, "expected key=value in metadata")
+}
+
+// parseMetadata appends the pairs of a metadata block to dst.
+func (p *parser) parseMetadata(dst []MetaPair, s string, basePos int) ([]MetaPair, error) {
+	return p.scanMetadata(dst, true, s, basePos)
 }
 
-func parseMetadata(s string, basePos int) ([]MetaPair, error) {
+// validateMetadata checks a metadata block exactly as parseMetadata does
+// without storing the pairs.
+func (p *parser) validateMetadata(s string, basePos int) error {
+	_, err := p.scanMetadata(nil, false, s, basePos)
+	return err
+}
+
+func (p *parser) scanMetadata(dst []MetaPair, keep bool, s string, basePos int) ([]MetaPair, error) {
 	if len(s) == 0 {
-		return nil, fail(ErrInvalidMetadata, basePos)
+		return nil, failf(ErrInvalidMetadata, basePos, "metadata block must not be empty")
 	}
 
-	var pairs []MetaPair
+	pairs := dst
+	if keep && cap(pairs) == 0 {
+		pairs = make([]MetaPair, 0, countItems(s, ',', p.lim.MaxMetaPairs))
+	}
+	var keys dupIndex[string]
+	n := 0
 	start := 0
 	i := 0
 
@@ -238,14 +335,27 @@ func parseMetadata(s string, basePos int) ([]MetaPair, error) {
 		if atEnd || isComma {
 			pairStr := s[start:i]
 			if len(pairStr) > 0 {
-				if len(pairs) >= MaxMetaPairs {
+				if n >= p.lim.MaxMetaPairs {
 					return nil, fail(ErrTooManyItems, basePos+start)
 				}
-				pair, err := parseMetaPair(pairStr, basePos+start)
+				if err := p.spend(basePos + start); err != nil {
+					return nil, err
+				}
+				pair, err := p.parseMetaPair(pairStr, basePos+start)
 				if err != nil {
 					return nil, err
 				}
-				pairs = append(pairs, pair)
+				if (p.opts.CollectWarnings || p.opts.RejectDuplicateMetaKeys) && keys.add(pair.Key) >= 0 {
+					if p.opts.RejectDuplicateMetaKeys {
+						return nil, failf(ErrInvalidMetadata, basePos+start, "metadata key must not repeat within a block")
+					}
+					p.warnDuplicateKey(pair.Key, basePos+start)
+				}
+				if keep {
+					pair.Value = p.unescape(pair.Value)
+					pairs = append(pairs, pair)
+				}
+				n++
 			}
 			if atEnd {
 				break
@@ -262,12 +372,60 @@ func parseMetadata(s string, basePos int) ([]MetaPair, error) {
 		i++
 	}
 
-	if len(pairs) == 0 {
-		return nil, fail(ErrInvalidMetadata, basePos)
+	if n == 0 {
+		return nil, failf(ErrInvalidMetadata, basePos, "metadata block must not be empty")
 	}
 	return pairs, nil
 }
 
+// setMeta parses the metadata block s into *meta, or only validates it and
+// keeps it in *raw when metadata is parsed lazily.
+func (p *parser) setMeta(meta *MetaPairs, raw *string, s string, basePos int) error {
+	if p.opts.LazyMeta {
+		if err := p.validateMetadata(s, basePos); err != nil {
+			return err
+		}
+		*raw = s
+		return nil
+	}
+	m, err := p.parseMetadata(*meta, s, basePos)
+	if err != nil {
+		return err
+	}
+	*meta = m
+	return nil
+}
+
+// Metadata returns the variable's metadata pairs, parsing them on first use
+// when the frame was parsed with ParserOptions.LazyMeta. It populates Meta
+// and is not safe for concurrent use on the same variable.
+func (v *Variable) Metadata() MetaPairs {
+	v.Meta = lazyMeta(v.Meta, &v.rawMeta)
+	return v.Meta
+}
+
+// Metadata returns the body-level metadata pairs, parsing them on first use
+// when the frame was parsed with ParserOptions.LazyMeta. It populates Meta
+// and is not safe for concurrent use on the same body.
+func (sb *StructuredBody) Metadata() MetaPairs {
+	sb.Meta = lazyMeta(sb.Meta, &sb.rawMeta)
+	return sb.Meta
+}
+
+func lazyMeta(meta []MetaPair, raw *string) []MetaPair {
+	if len(meta) > 0 || *raw == "" {
+		return meta
+	}
+	p := newParser(nil)
+	m, err := p.parseMetadata(meta[:0], *raw, 0)
+	if err != nil {
+		// Unreachable: the block was validated when the frame was parsed.
+		return meta
+	}
+	*raw = ""
+	return m
+}
+
 // ---------------------------------------------------------------------------
 // Variable parsing
 // ---------------------------------------------------------------------------
@@ -279,22 +437,17 @@ func findOperator(s string, basePos int) (int, int, Operator, error) {
 			i += 2
 			continue
 		}
-		if i+1 < len(s) && s[i+1] == '=' {
-			switch s[i] {
-			case ':':
-				return i, 2, OperatorNumber, nil
-			case '?':
-				return i, 2, OperatorBoolean, nil
-			case '@':
-				return i, 2, OperatorLocation, nil
+		if i+1 < len(s) && s[i+1] == operatorAssign {
+			if op := operatorByPrefix[s[i]]; op != 0 {
+				return i, 2, Operator(op - 1), nil
 			}
 		}
-		if s[i] == '=' {
-			return i, 1, OperatorString, nil
+		if s[i] == operatorAssign {
+			return i, 1, plainOperator, nil
 		}
 		i++
 	}
-	return 0, 0, 0, fail(ErrInvalidVariable, basePos)
+	return 0, 0, 0, failf(ErrInvalidVariable, basePos, "expected an operator: '=', ':=', '?=', or '@='")
 }
 
 func scanValue(s string, pos int) (int, int) {
@@ -305,7 +458,7 @@ func scanValue(s string, pos int) (int, int) {
 			i += 2
 			continue
 		}
-		if ch == '#' || ch == '@' || ch == '^' || ch == '{' {
+		if isSuffixMarker[ch] {
 			return i, i
 		}
 		i++
@@ -313,90 +466,113 @@ func scanValue(s string, pos int) (int, int) {
 	return i, i
 }
 
-func parseValue(s string, op Operator, pos int) (Value, error) {
+// parseValue parses a raw value into v, reusing v.Location when present.
+// String values are kept as written, for the caller to unescape.
+func parseValue(v *Value, s string, op Operator, pos int) error {
 	switch op {
 	case OperatorNumber:
 		if len(s) == 0 {
-			return Value{}, fail(ErrInvalidVariable, pos)
+			return failf(ErrInvalidVariable, pos, "expected a number")
 		}
 		if err := validateNumber(s, pos); err != nil {
-			return Value{}, err
+			return err
 		}
-		return Value{Type: OperatorNumber, Str: s}, nil
+		*v = Value{Type: OperatorNumber, Str: s, Location: v.Location}
 	case OperatorString:
 		if len(s) == 0 {
-			return Value{}, fail(ErrInvalidVariable, pos)
+			return failf(ErrInvalidVariable, pos, "string value must not be empty")
 		}
-		return Value{Type: OperatorString, Str: s}, nil
+		*v = Value{Type: OperatorString, Str: s, Location: v.Location}
 	case OperatorBoolean:
 		switch s {
 		case "true":
-			return Value{Type: OperatorBoolean, Bool: true}, nil
+			*v = Value{Type: OperatorBoolean, Bool: true, Location: v.Location}
 		case "false":
-			return Value{Type: OperatorBoolean, Bool: false}, nil
+			*v = Value{Type: OperatorBoolean, Bool: false, Location: v.Location}
 		default:
-			return Value{}, fail(ErrInvalidVariable, pos)
+			return failf(ErrInvalidVariable, pos, "boolean value must be 'true' or 'false'")
 		}
 	case OperatorLocation:
-		return parseLocation(s, pos)
+		return parseLocation(v, s, pos)
+	default:
+		return failf(ErrInvalidVariable, pos, "unknown operator")
 	}
-	return Value{}, fail(ErrInvalidVariable, pos)
+	v.Location = nil
+	return nil
 }
 
-func parseLocation(s string, pos int) (Value, error) {
-	commaCount := 0
-	for i := 0; i < len(s); i++ {
-		if s[i] == ',' {
-			commaCount++
+func parseLocation(v *Value, s string, pos int) error {
+	// lat,lng[,alt] — components are sliced from s in place. Commas are never
+	// escaped here: a backslash is not valid in a number, so "\," fails
+	// number validation.
+	comma := strings.IndexByte(s, ',')
+	if comma < 0 {
+		return failf(ErrInvalidVariable, pos, "expected lat,lng[,alt] for location")
+	}
+	lat := s[:comma]
+	lng := s[comma+1:]
+	alt := ""
+	hasAlt := false
+	if comma = strings.IndexByte(lng, ','); comma >= 0 {
+		lng, alt, hasAlt = lng[:comma], lng[comma+1:], true
+		if strings.IndexByte(alt, ',') >= 0 {
+			return failf(ErrInvalidVariable, pos, "expected lat,lng[,alt] for location")
 		}
 	}
-	if commaCount > 2 {
-		return Value{}, fail(ErrInvalidVariable, pos)
-	}
-
-	parts := strings.SplitN(s, ",", 4)
-	if len(parts) < 2 {
-		return Value{}, fail(ErrInvalidVariable, pos)
-	}
-	lat := parts[0]
-	lng := parts[1]
 	if len(lat) == 0 || len(lng) == 0 {
-		return Value{}, fail(ErrInvalidVariable, pos)
+		return failf(ErrInvalidVariable, pos, "expected lat,lng[,alt] for location")
 	}
 
 	if err := validateNumber(lat, pos); err != nil {
-		return Value{}, err
+		return err
 	}
 	if err := validateNumber(lng, pos); err != nil {
-		return Value{}, err
+		return err
 	}
 
-	loc := &LocationValue{Lat: lat, Lng: lng}
-	if len(parts) > 2 {
-		alt := parts[2]
+	loc := v.Location
+	if loc == nil {
+		loc = &LocationValue{}
+	}
+	loc.Lat = lat
+	loc.Lng = lng
+	if hasAlt {
 		if len(alt) == 0 {
-			return Value{}, fail(ErrInvalidVariable, pos)
+			return failf(ErrInvalidVariable, pos, "expected lat,lng[,alt] for location")
 		}
 		if err := validateNumber(alt, pos); err != nil {
-			return Value{}, err
+			return err
 		}
-		loc.Alt = &alt
+		setOptional(&loc.Alt, alt)
+	} else {
+		loc.Alt = nil
 	}
 
-	return Value{Type: OperatorLocation, Location: loc}, nil
+	*v = Value{Type: OperatorLocation, Location: loc}
+	return nil
 }
 
-func parseVariable(s string, basePos int) (Variable, error) {
+// setOptional stores s in *p, reusing the existing target when there is one.
+func setOptional(p **string, s string) {
+	if *p == nil {
+		*p = new(string)
+	}
+	**p = s
+}
+
+// parseVariable parses a single variable into v. Slices and pointer targets
+// already held by v are reused.
+func (p *parser) parseVariable(v *Variable, s string, basePos int) error {
 	opPos, opLen, operator, err := findOperator(s, basePos)
 	if err != nil {
-		return Variable{}, err
+		return p.inField(err, "operator")
 	}
 	name := s[:opPos]
 	if len(name) == 0 {
-		return Variable{}, fail(ErrInvalidVariable, basePos)
+		return p.inField(failf(ErrInvalidVariable, basePos, "expected a variable name"), "name")
 	}
-	if err := validateVarname(name, basePos); err != nil {
-		return Variable{}, err
+	if err := validateVarnameLen(name, basePos, p.lim.MaxVarNameLen); err != nil {
+		return p.inField(err, "name")
 	}
 
 	pos := opPos + opLen
@@ -406,91 +582,94 @@ func parseVariable(s string, basePos int) (Variable, error) {
 	valueEnd, newPos := scanValue(s, pos)
 	pos = newPos
 	valueStr := s[valueStart:valueEnd]
-	value, err := parseValue(valueStr, operator, basePos+valueStart)
-	if err != nil {
-		return Variable{}, err
+	if err := parseValue(&v.Value, valueStr, operator, basePos+valueStart); err != nil {
+		return p.inField(err, "value")
 	}
-
-	var unit *string
-	var timestamp *string
-	var group *string
-	var meta []MetaPair
+	if operator == OperatorString {
+		v.Value.Str = p.unescape(v.Value.Str)
+	}
+	v.Name = p.intern(name)
+	v.Operator = operator
 
 	// #unit — NOT allowed with @= (location)
 	if pos < len(s) && s[pos] == '#' {
 		if operator == OperatorLocation {
-			return Variable{}, fail(ErrInvalidVariable, basePos+pos)
+			return p.inField(failf(ErrInvalidVariable, basePos+pos, "unit not allowed on location values"), "unit")
 		}
 		pos++
 		start := pos
-		pos = scanUntilAny(s, pos, "@^{")
+		pos = scanUntilAny(s, pos, variableSuffixes[1:])
 		u := s[start:pos]
-		if err := validateUnit(u, basePos+start); err != nil {
-			return Variable{}, err
+		if err := validateUnitLen(u, basePos+start, p.lim.MaxUnitLen); err != nil {
+			return p.inField(err, "unit")
 		}
-		unit = &u
+		setOptional(&v.Unit, p.intern(p.unescape(u)))
+	} else {
+		v.Unit = nil
 	}
 
 	// @timestamp
 	if pos < len(s) && s[pos] == '@' {
 		pos++
 		start := pos
-		pos = scanUntilAny(s, pos, "^{")
+		pos = scanUntilAny(s, pos, variableSuffixes[2:])
 		ts := s[start:pos]
 		if err := validateTimestamp(ts, basePos+start); err != nil {
-			return Variable{}, err
+			return p.inField(err, "timestamp")
 		}
-		timestamp = &ts
+		setOptional(&v.Timestamp, ts)
+	} else {
+		v.Timestamp = nil
 	}
 
 	// ^group
 	if pos < len(s) && s[pos] == '^' {
 		pos++
 		start := pos
-		pos = scanUntilAny(s, pos, "{")
+		pos = scanUntilAny(s, pos, variableSuffixes[3:])
 		g := s[start:pos]
-		if err := validateGroup(g, basePos+start); err != nil {
-			return Variable{}, err
+		if err := validateGroupLen(g, basePos+start, p.lim.MaxGroupLen); err != nil {
+			return p.inField(err, "group")
 		}
-		group = &g
+		setOptional(&v.Group, p.intern(g))
+	} else {
+		v.Group = nil
 	}
 
 	// {metadata}
+	v.Meta = v.Meta[:0]
+	v.rawMeta = ""
 	if pos < len(s) && s[pos] == '{' {
 		pos++
 		start := pos
 		end := findClosingBrace(s, pos)
 		if end == -1 {
-			return Variable{}, fail(ErrInvalidMetadata, basePos+start)
+			return p.inField(failf(ErrInvalidMetadata, basePos+start, "expected '}' to close metadata"), "meta")
 		}
-		metaStr := s[start:end]
-		m, err := parseMetadata(metaStr, basePos+start)
-		if err != nil {
-			return Variable{}, err
+		if err := p.setMeta(&v.Meta, &v.rawMeta, s[start:end], basePos+start); err != nil {
+			return p.inField(err, "meta")
 		}
-		meta = m
 		pos = end + 1
 	}
 
 	_ = pos
 
-	return Variable{
-		Name:      name,
-		Operator:  operator,
-		Value:     value,
-		Unit:      unit,
-		Timestamp: timestamp,
-		Group:     group,
-		Meta:      meta,
-	}, nil
+	return nil
 }
 
 // ---------------------------------------------------------------------------
 // Variable list parsing
 // ---------------------------------------------------------------------------
 
-func parseVariableList(s string, basePos int) ([]Variable, error) {
-	var variables []Variable
+// parseVariableList appends the variables of a variable block to dst. When
+// dst has spare capacity, the elements beyond its length are reused in place.
+func (p *parser) parseVariableList(dst []Variable, s string, basePos int) ([]Variable, error) {
+	variables := dst
+	if cap(variables) == 0 {
+		variables = make([]Variable, 0, countItems(s, ';', p.lim.MaxVariables))
+	}
+	index := 0 // of the variable in the block, counting those skipped
+	var seen dupIndex[varKey]
 	start := 0
 	i := 0
 
@@ -501,14 +680,30 @@ func parseVariableList(s string, basePos int) ([]Variable, error) {
 		if atEnd || isSemi {
 			varStr := s[start:i]
 			if len(varStr) > 0 {
-				if len(variables) >= MaxVariables {
-					return nil, fail(ErrTooManyItems, basePos+start)
+				if len(variables) >= p.lim.MaxVariables {
+					return nil, p.inVariable(fail(ErrTooManyItems, basePos+start), index)
+				}
+				if err := p.spend(basePos + start); err != nil {
+					return nil, p.inVariable(err, index)
+				}
+				if len(variables) < cap(variables) {
+					variables = variables[:len(variables)+1]
+				} else {
+					variables = append(variables, Variable{})
+				}
+				v := &variables[len(variables)-1]
+				err := p.parseVariable(v, varStr, basePos+start)
+				if err == nil && p.opts.RejectDuplicateVariables && seen.add(keyOf(v)) >= 0 {
+					err = failf(ErrInvalidVariable, basePos+start, "variable must not repeat without a distinct timestamp")
 				}
-				v, err := parseVariable(varStr, basePos+start)
 				if err != nil {
-					return nil, err
+					err = p.inVariable(err, index)
+					if !p.skip(err) {
+						return nil, err
+					}
+					variables = variables[:len(variables)-1]
 				}
-				variables = append(variables, v)
+				index++
 			}
 			if atEnd {
 				break
@@ -532,21 +727,14 @@ func parseVariableList(s string, basePos int) ([]Variable, error) {
 // Body-level modifiers
 // ---------------------------------------------------------------------------
 
-type bodyModifiers struct {
-	group     *string
-	timestamp *string
-	meta      []MetaPair
-}
-
-func parseBodyModifiers(s string, basePos int) (bodyModifiers, error) {
-	if len(s) == 0 {
-		return bodyModifiers{}, nil
-	}
+const bodyModifierOrder = "body modifiers must appear in the order @timestamp, ^group, {metadata}"
 
+// parseBodyModifiers parses the body-level @timestamp, ^group, and {meta}
+// modifiers into sb.
+func (p *parser) parseBodyModifiers(sb *StructuredBody, s string, basePos int) error {
 	pos := 0
-	var group *string
-	var timestamp *string
-	var meta []MetaPair
+	var group, timestamp string
+	hasGroup, hasTimestamp := false, false
 	phase := 0 // 0=@, 1=^, 2={, 3=done
 
 	for pos < len(s) {
@@ -554,161 +742,259 @@ func parseBodyModifiers(s string, basePos int) (bodyModifiers, error) {
 		switch ch {
 		case '@':
 			if phase > 0 {
-				return bodyModifiers{}, fail(ErrInvalidModifier, basePos+pos)
+				return failf(ErrInvalidModifier, basePos+pos, bodyModifierOrder)
 			}
 			pos++
 			start := pos
-			pos = scanUntilAny(s, pos, "^{")
+			pos = scanUntilAny(s, pos, bodyModifiers[1:])
 			ts := s[start:pos]
 			if err := validateDigits(ts, basePos+start); err != nil {
-				return bodyModifiers{}, err
+				return p.inField(err, "timestamp")
 			}
-			timestamp = &ts
+			timestamp, hasTimestamp = ts, true
 			phase = 1
 		case '^':
 			if phase > 1 {
-				return bodyModifiers{}, fail(ErrInvalidModifier, basePos+pos)
+				return failf(ErrInvalidModifier, basePos+pos, bodyModifierOrder)
 			}
 			pos++
 			start := pos
-			pos = scanUntilAny(s, pos, "{")
+			pos = scanUntilAny(s, pos, bodyModifiers[2:])
 			g := s[start:pos]
-			if err := validateGroup(g, basePos+start); err != nil {
-				return bodyModifiers{}, err
+			if err := validateGroupLen(g, basePos+start, p.lim.MaxGroupLen); err != nil {
+				return p.inField(err, "group")
 			}
-			group = &g
+			group, hasGroup = g, true
 			phase = 2
 		case '{':
 			if phase > 2 {
-				return bodyModifiers{}, fail(ErrInvalidModifier, basePos+pos)
+				return failf(ErrInvalidModifier, basePos+pos, bodyModifierOrder)
 			}
 			pos++
 			start := pos
 			end := findUnescapedChar(s, '}', pos)
 			if end == -1 {
-				return bodyModifiers{}, fail(ErrInvalidMetadata, basePos+start)
+				return p.inField(failf(ErrInvalidMetadata, basePos+start, "expected '}' to close metadata"), "meta")
 			}
-			metaStr := s[start:end]
-			m, err := parseMetadata(metaStr, basePos+start)
-			if err != nil {
-				return bodyModifiers{}, err
+			if err := p.setMeta(&sb.Meta, &sb.rawMeta, s[start:end], basePos+start); err != nil {
+				return p.inField(err, "meta")
 			}
-			meta = m
 			pos = end + 1
 			phase = 3
 		default:
-			return bodyModifiers{}, fail(ErrInvalidModifier, basePos+pos)
+			return failf(ErrInvalidModifier, basePos+pos, "expected '@', '^', '{', or '[' in body modifiers")
 		}
 	}
 
-	return bodyModifiers{group: group, timestamp: timestamp, meta: meta}, nil
+	if hasGroup {
+		sb.Group = spare(&sb.groupSpare)
+		*sb.Group = p.intern(group)
+	}
+	if hasTimestamp {
+		sb.Timestamp = spare(&sb.timestampSpare)
+		*sb.Timestamp = timestamp
+	}
+	return nil
 }
 
 // ---------------------------------------------------------------------------
 // PUSH body parsing
 // ---------------------------------------------------------------------------
 
-func parsePushBody(body string, basePos int) (*PushBody, error) {
+func (p *parser) parsePushBody(body string, basePos int) (*PushBody, error) {
+	pb := &PushBody{}
+	if err := p.parsePushBodyInto(pb, body, basePos); err != nil {
+		return nil, err
+	}
+	return pb, nil
+}
+
+// parsePushBodyInto parses a PUSH body into pb, which must be reset. The
+// structured or passthrough body kept by pb.Reset is reused.
+func (p *parser) parsePushBodyInto(pb *PushBody, body string, basePos int) error {
 	if strings.HasPrefix(body, ">x") {
-		return parseHexPassthrough(body[2:], basePos+2)
+		return p.inField(p.parsePassthrough(pb, PassthroughEncodingHex, body[2:], basePos+2), "body.passthrough")
 	}
 	if strings.HasPrefix(body, ">b") {
-		return parseBase64Passthrough(body[2:], basePos+2)
+		return p.inField(p.parsePassthrough(pb, PassthroughEncodingBase64, body[2:], basePos+2), "body.passthrough")
 	}
 
 	bracketPos := findUnescapedChar(body, '[', 0)
 	if bracketPos == -1 {
-		return nil, fail(ErrInvalidVarBlock, basePos)
+		return p.inField(fail(ErrInvalidVarBlock, basePos), "body")
 	}
 
 	modStr := body[:bracketPos]
 	endBracket := findClosingBracket(body, bracketPos+1)
 	if endBracket == -1 {
-		return nil, fail(ErrInvalidVarBlock, basePos+bracketPos)
+		return p.inField(fail(ErrInvalidVarBlock, basePos+bracketPos), "body")
 	}
 
 	varBlock := body[bracketPos+1 : endBracket]
 	if len(varBlock) == 0 {
-		return nil, fail(ErrInvalidVarBlock, basePos+bracketPos)
+		return p.inField(fail(ErrInvalidVarBlock, basePos+bracketPos), "body")
 	}
 
-	mods, err := parseBodyModifiers(modStr, basePos)
-	if err != nil {
-		return nil, err
+	sb := spare(&pb.structuredSpare)
+	if err := p.parseBodyModifiers(sb, modStr, basePos); err != nil {
+		if err = p.inField(err, "body"); !p.skip(err) {
+			return err
+		}
 	}
-	variables, err := parseVariableList(varBlock, basePos+bracketPos+1)
+	skipped := len(p.errs)
+	variables, err := p.parseVariableList(sb.Variables[:0], varBlock, basePos+bracketPos+1)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if len(variables) == 0 {
-		return nil, fail(ErrInvalidVarBlock, basePos+bracketPos)
+	if len(variables) == 0 && len(p.errs) == skipped {
+		return p.inField(fail(ErrInvalidVarBlock, basePos+bracketPos), "body")
 	}
+	sb.Variables = variables
+	pb.Structured = sb
+	return nil
+}
 
-	sb := &StructuredBody{
-		Variables: variables,
-		Group:     mods.group,
-		Timestamp: mods.timestamp,
-		Meta:      mods.meta,
+// passthroughScratch holds the buffers passthrough payloads are decoded
+// into for validation when the decoded bytes are not retained.
+var passthroughScratch = sync.Pool{New: func() any { return new([]byte) }}
+
+var errEmptyPassthrough = errors.New("tagotip: empty passthrough payload")
+
+// appendDecodedPassthrough decodes data and appends the bytes to dst.
+// Base64 payloads may carry up to two trailing '=' padding characters,
+// which are not required to complete the final quantum.
+func appendDecodedPassthrough(dst []byte, enc PassthroughEncoding, data string) ([]byte, error) {
+	src := unsafe.Slice(unsafe.StringData(data), len(data)) // read-only
+	off := len(dst)
+	switch enc {
+	case PassthroughEncodingHex:
+		dst = growSlice(dst, hex.DecodedLen(len(src)))
+		if _, err := hex.Decode(dst[off:], src); err != nil {
+			return dst[:off], err
+		}
+		return dst, nil
+	case PassthroughEncodingBase64:
+		for i := 0; i < 2 && len(src) > 0 && src[len(src)-1] == '='; i++ {
+			src = src[:len(src)-1]
+		}
+		if len(src) == 0 {
+			return dst, errEmptyPassthrough
+		}
+		dst = growSlice(dst, base64.RawStdEncoding.DecodedLen(len(src)))
+		n, err := base64.RawStdEncoding.Decode(dst[off:], src)
+		if err != nil {
+			return dst[:off], err
+		}
+		return dst[:off+n], nil
 	}
-
-	return &PushBody{Structured: sb}, nil
+	return dst, fmt.Errorf("tagotip: unknown passthrough encoding %d", enc)
 }
 
-func parseHexPassthrough(data string, pos int) (*PushBody, error) {
-	if len(data) == 0 {
-		return nil, fail(ErrInvalidPassthru, pos)
-	}
-	if len(data)%2 != 0 {
-		return nil, fail(ErrInvalidPassthru, pos)
+// Decode returns the binary payload carried by the body. Bodies parsed with
+// ParserOptions.RetainPassthrough return the bytes decoded during parsing,
+// which callers must not modify; Bytes returns a copy.
+func (pt *PassthroughBody) Decode() ([]byte, error) {
+	if pt.retained {
+		return pt.decoded, nil
 	}
+	return appendDecodedPassthrough(nil, pt.Encoding, pt.Data)
+}
+
+// checkBase64 returns the offset of the first byte of data that breaks the
+// padded base64 structure, with a description, or -1: every byte is in the
+// standard alphabet, the length is a multiple of four, and at most two '='
+// end the final quantum. Passthrough bodies and the binary command detail
+// of an ACK are both held to it.
+func checkBase64(data string) (int, string) {
 	for i := 0; i < len(data); i++ {
-		if !isHexDigit(data[i]) {
-			return nil, fail(ErrInvalidPassthru, pos)
+		c := data[i]
+		if c == '=' {
+			pad := len(data) - i
+			if i%4 < 2 || pad > 2 || strings.Count(data[i:], "=") != pad {
+				return i, "base64 padding may only end the final quantum"
+			}
+			break
+		}
+		if base64Alphabet[c] == 0 {
+			return i, "invalid base64 character"
 		}
 	}
-	return &PushBody{
-		IsPassthrough: true,
-		Passthrough: &PassthroughBody{
-			Encoding: PassthroughEncodingHex,
-			Data:     data,
-		},
-	}, nil
+	if len(data)%4 != 0 {
+		return len(data), "base64 length must be a multiple of 4"
+	}
+	return -1, ""
 }
 
-func parseBase64Passthrough(data string, pos int) (*PushBody, error) {
+var base64Alphabet = func() (t [256]uint8) {
+	for _, c := range "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/" {
+		t[c] = 1
+	}
+	return t
+}()
+
+func (p *parser) parsePassthrough(pb *PushBody, enc PassthroughEncoding, data string, pos int) error {
 	if len(data) == 0 {
-		return nil, fail(ErrInvalidPassthru, pos)
+		return fail(ErrInvalidPassthru, pos)
 	}
-	for i := 0; i < len(data); i++ {
-		ch := data[i]
-		if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '+' || ch == '/' || ch == '=') {
-			return nil, fail(ErrInvalidPassthru, pos)
+	if enc == PassthroughEncodingBase64 {
+		if off, msg := checkBase64(data); off >= 0 {
+			return failf(ErrInvalidPassthru, pos+off, msg)
 		}
 	}
-	return &PushBody{
-		IsPassthrough: true,
-		Passthrough: &PassthroughBody{
-			Encoding: PassthroughEncodingBase64,
-			Data:     data,
-		},
-	}, nil
+	pt := spare(&pb.passthroughSpare)
+	if p.opts.RetainPassthrough {
+		decoded, err := appendDecodedPassthrough(pt.decoded[:0], enc, data)
+		if err != nil {
+			return fail(ErrInvalidPassthru, pos)
+		}
+		pt.decoded, pt.retained = decoded, true
+	} else {
+		buf := passthroughScratch.Get().(*[]byte)
+		var err error
+		*buf, err = appendDecodedPassthrough((*buf)[:0], enc, data)
+		passthroughScratch.Put(buf)
+		if err != nil {
+			return fail(ErrInvalidPassthru, pos)
+		}
+	}
+
+	pt.Encoding = enc
+	pt.Data = data
+	pb.IsPassthrough = true
+	pb.Passthrough = pt
+	return nil
 }
 
 // ---------------------------------------------------------------------------
 // PULL body parsing
 // ---------------------------------------------------------------------------
 
-func parsePullBody(body string, basePos int) (*PullBody, error) {
+func (p *parser) parsePullBody(body string, basePos int) (*PullBody, error) {
+	pb := &PullBody{}
+	if err := p.parsePullBodyInto(pb, body, basePos); err != nil {
+		return nil, err
+	}
+	return pb, nil
+}
+
+// parsePullBodyInto parses a PULL body into pb, which must be reset,
+// appending to the capacity kept in pb.Variables.
+func (p *parser) parsePullBodyInto(pb *PullBody, body string, basePos int) error {
 	if len(body) < 2 || body[0] != '[' || body[len(body)-1] != ']' {
-		return nil, fail(ErrMissingBody, basePos)
+		return p.inField(fail(ErrMissingBody, basePos), "body")
 	}
 
 	inner := body[1 : len(body)-1]
 	if len(inner) == 0 {
-		return nil, fail(ErrInvalidVarBlock, basePos)
+		return p.inField(fail(ErrInvalidVarBlock, basePos), "body")
 	}
 
-	var variables []string
+	variables := pb.Variables
+	if cap(variables) == 0 {
+		variables = make([]string, 0, countItems(inner, ';', p.lim.MaxVariables))
+	}
+	skipped := len(p.errs)
+	index := 0 // of the name in the block, counting those skipped
 	start := 0
 	i := 0
 
@@ -719,13 +1005,18 @@ func parsePullBody(body string, basePos int) (*PullBody, error) {
 		if atEnd || isSemi {
 			name := inner[start:i]
 			if len(name) > 0 {
-				if len(variables) >= MaxVariables {
-					return nil, fail(ErrTooManyItems, basePos+1+start)
+				if len(variables) >= p.lim.MaxVariables {
+					return p.inVariable(fail(ErrTooManyItems, basePos+1+start), index)
 				}
-				if err := validateVarname(name, basePos+1+start); err != nil {
-					return nil, err
+				if err := p.spend(basePos + 1 + start); err != nil {
+					return p.inVariable(err, index)
 				}
-				variables = append(variables, name)
+				if err := validateVarnameLen(name, basePos+1+start, p.lim.MaxVarNameLen); err == nil {
+					variables = append(variables, p.intern(name))
+				} else if err = p.inVariable(err, index); !p.skip(err) {
+					return err
+				}
+				index++
 			}
 			if atEnd {
 				break
@@ -742,48 +1033,181 @@ func parsePullBody(body string, basePos int) (*PullBody, error) {
 		i++
 	}
 
-	if len(variables) == 0 {
-		return nil, fail(ErrInvalidVarBlock, basePos)
+	if len(variables) == 0 && len(p.errs) == skipped {
+		return p.inField(fail(ErrInvalidVarBlock, basePos), "body")
 	}
-	return &PullBody{Variables: variables}, nil
+	pb.Variables = variables
+	return nil
 }
 
 // ---------------------------------------------------------------------------
 // Public API
 // ---------------------------------------------------------------------------
 
-// ParseUplink parses a raw uplink frame string into an UplinkFrame.
+// ParseUplink parses a raw uplink frame string into an UplinkFrame. A
+// trailing "\n", "\r\n", or bare '\r' ends the frame and is stripped; see
+// ParserOptions.StrictLineEndings.
+//
+// Parsing takes time linear in the length of input, whatever its contents:
+// every scanner reads each byte a bounded number of times. The same holds
+// for ParseUplinkInto and ParseUplinkWithOptions, whose duplicate checks
+// (RejectDuplicateVariables, RejectDuplicateMetaKeys, and the warnings)
+// look each item up in a set of those before it rather than rescanning
+// them, and so are linear in expected time at any ParseLimits.
 func ParseUplink(input string) (*UplinkFrame, error) {
-	if strings.ContainsRune(input, '\x00') {
-		return nil, fail(ErrNulByte, 0)
+	frame := &UplinkFrame{}
+	if err := ParseUplinkInto(frame, input); err != nil {
+		return nil, err
 	}
-	if len(input) > MaxFrameSize {
-		return nil, fail(ErrFrameTooLarge, 0)
+	return frame, nil
+}
+
+// ParseUplinkInto parses a raw uplink frame string into frame, reusing its
+// storage instead of allocating a new frame.
+//
+// frame is cleared with Reset before parsing. The Variables, Meta, and PULL
+// Variables slices are refilled within their existing capacity, and the
+// targets of the optional pointer fields (Seq, Unit, Timestamp, Group,
+// Location, Alt) and of PushBody, Structured, Passthrough, and PullBody
+// are written in place when already allocated. Callers must therefore not
+// retain any of those slices or pointers from a previous parse once frame
+// is reused.
+//
+// String fields are substrings of input and share its memory, except for
+// string values, units, and metadata values holding escape sequences,
+// which are unescaped into copies; see ParserOptions.ZeroCopy to keep
+// them as written. On error the contents of frame are unspecified.
+func ParseUplinkInto(frame *UplinkFrame, input string) error {
+	p := newParser(nil)
+	return p.parseUplinkInto(frame, input)
+}
+
+func (p *parser) parseUplinkInto(frame *UplinkFrame, input string) error {
+	if err := p.parseUplink(frame, input); err != nil {
+		return p.withContext(err, input)
 	}
+	return nil
+}
 
-	stripped := input
-	if len(stripped) > 0 && stripped[len(stripped)-1] == '\n' {
-		stripped = stripped[:len(stripped)-1]
+func (p *parser) parseUplink(frame *UplinkFrame, input string) error {
+	stripped, signature, err := p.prepareUplink(input)
+	if err != nil {
+		return err
+	}
+	var buf [maxFields]string
+	fields := appendFields(buf[:0], stripped)
+	h, err := p.parseUplinkHeader(fields)
+	if err != nil {
+		return err
+	}
+	method, bodyIdx, bodyPos := h.method, h.bodyIdx, h.bodyPos
+
+	frame.Reset()
+	frame.Method = method
+	if h.hasSeq {
+		frame.Seq = spare(&frame.seqSpare)
+		*frame.Seq = h.seq
+	}
+	frame.Auth = h.auth
+	frame.Serial = h.serial
+	frame.Signature = signature
+
+	switch method {
+	case MethodPush:
+		if len(fields) <= bodyIdx {
+			return p.inField(fail(ErrMissingBody, bodyPos), "body")
+		}
+		frame.PushBody = spare(&frame.pushSpare)
+		if err := p.parsePushBodyInto(frame.PushBody, fields[bodyIdx], bodyPos); err != nil {
+			return err
+		}
+	case MethodPull:
+		if len(fields) <= bodyIdx {
+			return p.inField(fail(ErrMissingBody, bodyPos), "body")
+		}
+		frame.PullBody = spare(&frame.pullSpare)
+		if err := p.parsePullBodyInto(frame.PullBody, fields[bodyIdx], bodyPos); err != nil {
+			return err
+		}
+	case MethodPing:
+		if len(fields) > bodyIdx && fields[bodyIdx] != "" {
+			if err := p.parsePingDiagnostics(frame, fields[bodyIdx], bodyPos); err != nil {
+				return p.inField(err, "diagnostics")
+			}
+		}
+	case MethodUnknown:
+		frame.RawMethod = fields[0]
+		if len(fields) > bodyIdx {
+			frame.RawBody = stripped[bodyPos:]
+		}
 	}
-	fields := splitFields(stripped)
+	frame.Warnings = p.warnings
 
+	return nil
+}
+
+// prepareUplink applies the frame-level checks and options to input and
+// returns the frame without its trailing newline, checksum, or signature,
+// together with the signature.
+func (p *parser) prepareUplink(input string) (stripped, signature string, err error) {
+	if err := p.checkInput(input); err != nil {
+		return "", "", err
+	}
+	input = trimLineEnding(input, p.opts.StrictLineEndings)
+	if p.opts.VerifyChecksum {
+		unchecked, ok := VerifyChecksum(input)
+		if !ok {
+			return "", "", failf(ErrChecksumMismatch, checksumPos(input), "frame does not match its CRC-16 trailer")
+		}
+		input = unchecked
+	}
+	if v := p.opts.Verifier; v != nil {
+		unsigned, sig, err := v.verify(input)
+		if err != nil {
+			return "", "", err
+		}
+		input, signature = unsigned, sig
+	}
+	return input, signature, nil
+}
+
+// uplinkHeader holds the validated header fields of an uplink frame and
+// the index and position of its body field.
+type uplinkHeader struct {
+	method  Method
+	seq     uint32
+	hasSeq  bool
+	auth    string
+	serial  string
+	bodyIdx int
+	bodyPos int
+}
+
+// parseUplinkHeader validates the method, sequence counter, auth, and
+// serial fields of an uplink frame.
+func (p *parser) parseUplinkHeader(fields []string) (uplinkHeader, error) {
+	var h uplinkHeader
 	if len(fields) == 0 || len(fields[0]) == 0 {
-		return nil, fail(ErrEmptyFrame, 0)
+		return h, fail(ErrEmptyFrame, 0)
 	}
 
 	method, err := parseMethod(fields[0])
 	if err != nil {
-		return nil, err
+		if !p.opts.AllowUnknownMethods || !isMethodToken(fields[0]) {
+			return h, p.inField(err, "method")
+		}
+		method = MethodUnknown
 	}
+	h.method = method
 
-	var seq *uint32
 	authIdx := 1
 	if len(fields) > 1 && len(fields[1]) > 0 && fields[1][0] == '!' {
 		s, err := parseSeq(fields[1], len(fields[0])+1)
-		if err != nil {
-			return nil, err
+		if err == nil {
+			h.seq, h.hasSeq = s, true
+		} else if err = p.inField(err, "seq"); !p.skip(err) {
+			return h, err
 		}
-		seq = &s
 		authIdx = 2
 	}
 
@@ -793,107 +1217,197 @@ func ParseUplink(input string) (*UplinkFrame, error) {
 	}
 
 	if len(fields) <= authIdx {
-		return nil, fail(ErrInvalidAuth, authPos)
+		return h, p.inField(fail(ErrInvalidAuth, authPos), "auth")
 	}
 	auth := fields[authIdx]
 	if err := validateAuth(auth, authPos); err != nil {
-		return nil, err
+		if err = p.inField(err, "auth"); !p.skip(err) {
+			return h, err
+		}
+	} else if p.opts.CollectWarnings {
+		p.checkAuthCase(auth, authPos)
 	}
+	h.auth = auth
 
 	serialIdx := authIdx + 1
 	serialPos := authPos + len(auth) + 1
 	if len(fields) <= serialIdx {
-		return nil, fail(ErrInvalidSerial, serialPos)
+		return h, p.inField(fail(ErrInvalidSerial, serialPos), "serial")
 	}
 	serial := fields[serialIdx]
-	if err := validateSerial(serial, serialPos); err != nil {
-		return nil, err
+	if err := validateSerialLen(serial, serialPos, p.lim.MaxSerialLen); err != nil {
+		if err = p.inField(err, "serial"); !p.skip(err) {
+			return h, err
+		}
 	}
+	h.serial = serial
 
-	bodyIdx := serialIdx + 1
-	bodyPos := serialPos + len(serial) + 1
+	h.bodyIdx = serialIdx + 1
+	h.bodyPos = serialPos + len(serial) + 1
+	return h, nil
+}
 
-	frame := &UplinkFrame{
-		Method: method,
-		Seq:    seq,
-		Auth:   auth,
-		Serial: serial,
+// parsePingDiagnostics parses the body of a PING, a single metadata block,
+// into frame.PingDiagnostics. Without AllowPingDiagnostics the body is
+// ignored, as it was before diagnostics existed.
+func (p *parser) parsePingDiagnostics(frame *UplinkFrame, body string, basePos int) error {
+	if !p.opts.AllowPingDiagnostics {
+		return nil
+	}
+	if len(body) < 2 || body[0] != '{' || findUnescapedChar(body, '}', 1) != len(body)-1 {
+		return failf(ErrInvalidMetadata, basePos, "expected a {key=value,...} block after PING")
+	}
+	meta, err := p.parseMetadata(nil, body[1:len(body)-1], basePos+1)
+	if err != nil {
+		return err
 	}
+	frame.PingDiagnostics = meta
+	return nil
+}
 
-	switch method {
-	case MethodPush:
-		if len(fields) <= bodyIdx {
-			return nil, fail(ErrMissingBody, bodyPos)
-		}
-		pb, err := parsePushBody(fields[bodyIdx], bodyPos)
-		if err != nil {
-			return nil, err
-		}
-		frame.PushBody = pb
-	case MethodPull:
-		if len(fields) <= bodyIdx {
-			return nil, fail(ErrMissingBody, bodyPos)
-		}
-		pb, err := parsePullBody(fields[bodyIdx], bodyPos)
-		if err != nil {
-			return nil, err
-		}
-		frame.PullBody = pb
-	case MethodPing:
-		// No body for PING
+// ParseAck parses a raw ACK frame string into an AckFrame. As with
+// ParseUplink, input containing a NUL byte or longer than MaxFrameSize is
+// rejected before it is split, and a trailing "\n", "\r\n", or '\r' is
+// stripped. Only an ERR detail may be followed by further fields (see
+// ErrorDetail); after any other detail they fail with ErrInvalidAck at
+// the first extra field.
+func ParseAck(input string) (*AckFrame, error) {
+	frame := &AckFrame{}
+	if err := ParseAckInto(frame, input); err != nil {
+		return nil, err
 	}
+	return frame, nil
+}
 
+// checkFrameInput rejects input containing a NUL byte or longer than
+// maxSize, before it is split into fields.
+func checkFrameInput(input string, maxSize int) error {
+	if strings.IndexByte(input, 0) >= 0 {
+		return fail(ErrNulByte, 0)
+	}
+	if len(input) > maxSize {
+		return fail(ErrFrameTooLarge, 0)
+	}
+	return nil
+}
+
+// trimLineEnding returns input without its line ending: a trailing "\n",
+// "\r\n", or bare '\r'. Under strict only "\n" is a line ending, and a
+// '\r' before it is left for field validation to reject.
+func trimLineEnding(input string, strict bool) string {
+	input = strings.TrimSuffix(input, "\n")
+	if !strict {
+		input = strings.TrimSuffix(input, "\r")
+	}
+	return input
+}
+
+// ParseAckInto parses a raw ACK frame string into frame, reusing its
+// storage instead of allocating a new frame. frame is cleared with Reset
+// first, and the Seq and Detail targets are written in place when already
+// allocated, so callers must not retain them from a previous parse once
+// frame is reused. On error the contents of
+// frame are unspecified.
+func ParseAckInto(frame *AckFrame, input string) error {
+	p := newParser(nil)
+	return p.parseAckInto(frame, input)
+}
+
+// ParseAckWithOptions parses a raw ACK frame string like ParseAck, applying
+// opts. Only opts.Limits, opts.StrictLineEndings, opts.ErrorContext, and
+// opts.RejectMalformedAckVariables apply to ACKs; Limits bounds the frame
+// and the variables of an "ACK|OK|[...]" detail. A nil opts is equivalent
+// to the zero value.
+func ParseAckWithOptions(input string, opts *ParserOptions) (*AckFrame, error) {
+	frame := &AckFrame{}
+	p := newParser(opts)
+	if err := p.parseAckInto(frame, input); err != nil {
+		return nil, err
+	}
 	return frame, nil
 }
 
-// ParseAck parses a raw ACK frame string into an AckFrame.
-func ParseAck(input string) (*AckFrame, error) {
-	stripped := input
-	if len(stripped) > 0 && stripped[len(stripped)-1] == '\n' {
-		stripped = stripped[:len(stripped)-1]
+func (p *parser) parseAckInto(frame *AckFrame, input string) error {
+	p.keepEscapes = false // ZeroCopy does not apply to ACKs
+	if err := p.parseAck(frame, input); err != nil {
+		return p.withContext(err, input)
 	}
-	fields := splitFields(stripped)
+	return nil
+}
 
-	if len(fields) == 0 || fields[0] != "ACK" {
-		return nil, fail(ErrInvalidAck, 0)
+func (p *parser) parseAck(frame *AckFrame, input string) error {
+	if err := p.checkInput(input); err != nil {
+		return err
 	}
-	if len(fields) < 2 {
-		return nil, fail(ErrInvalidAck, 0)
+	stripped := trimLineEnding(input, p.opts.StrictLineEndings)
+	var buf [maxFields]string
+	var fields []string
+	if strings.IndexByte(stripped, '\\') == -1 {
+		fields = appendPlainFields(buf[:0], stripped)
+	} else {
+		fields = appendFields(buf[:0], stripped)
 	}
 
-	var seq *uint32
+	if len(fields) == 0 || fields[0] != "ACK" {
+		return fail(ErrInvalidAck, 0)
+	}
+
+	frame.Reset()
 	statusIdx := 1
-	if len(fields[1]) > 0 && fields[1][0] == '!' {
-		s, err := parseSeq(fields[1], 4)
+	statusPos := len(fields[0]) + 1
+	if len(fields) > 1 && len(fields[1]) > 0 && fields[1][0] == '!' {
+		s, err := parseSeq(fields[1], statusPos)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		seq = &s
+		frame.Seq = spare(&frame.seqSpare)
+		*frame.Seq = s
 		statusIdx = 2
+		statusPos += len(fields[1]) + 1
 	}
 
 	if len(fields) <= statusIdx {
-		return nil, fail(ErrInvalidAck, 0)
+		return fail(ErrInvalidAck, statusPos)
 	}
 
-	status, err := parseAckStatus(fields[statusIdx])
+	status, err := parseAckStatus(fields[statusIdx], statusPos)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	frame.Status = status
 
-	var detail *AckDetail
 	if len(fields) > statusIdx+1 {
-		detail = parseAckDetail(fields[statusIdx+1], status)
+		frame.Detail = spare(&frame.detailSpare)
+		detailPos := statusPos + len(fields[statusIdx]) + 1
+		frame.detailPos = detailPos
+		if err := p.parseAckDetail(frame.Detail, fields[statusIdx+1], status, detailPos); err != nil {
+			return err
+		}
+		if len(fields) > statusIdx+2 {
+			extraPos := detailPos + len(fields[statusIdx+1]) + 1
+			if err := parseAckExtra(frame.Detail, status, stripped[extraPos:], extraPos); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
+}
 
-	return &AckFrame{
-		Seq:    seq,
-		Status: status,
-		Detail: detail,
-	}, nil
+// appendPlainFields is appendFields for input known to contain no
+// backslashes, scanning with strings.IndexByte.
+func appendPlainFields(fields []string, input string) []string {
+	for len(fields) < maxFields-1 {
+		i := strings.IndexByte(input, '|')
+		if i == -1 {
+			break
+		}
+		fields = append(fields, input[:i])
+		input = input[i+1:]
+	}
+	return append(fields, input)
 }
 
-func parseAckStatus(s string) (AckStatus, error) {
+func parseAckStatus(s string, pos int) (AckStatus, error) {
 	switch s {
 	case "OK":
 		return AckStatusOk, nil
@@ -904,29 +1418,49 @@ func parseAckStatus(s string) (AckStatus, error) {
 	case "ERR":
 		return AckStatusErr, nil
 	default:
-		return 0, fail(ErrInvalidAck, 0)
+		return 0, fail(ErrInvalidAck, pos)
 	}
 }
 
-func parseAckDetail(s string, status AckStatus) *AckDetail {
+// parseAckDetail parses an ACK detail field found at pos into d,
+// overwriting it.
+func (p *parser) parseAckDetail(d *AckDetail, s string, status AckStatus, pos int) error {
 	switch status {
 	case AckStatusOk:
 		if len(s) > 0 && s[0] == '[' {
-			return &AckDetail{Type: "variables", Text: s}
+			*d = AckDetail{Type: "variables", Text: s}
+			vars, err := p.parseDetailVariables(s, pos)
+			if err != nil {
+				if p.opts.RejectMalformedAckVariables {
+					return err
+				}
+				return nil
+			}
+			if vars != nil {
+				d.Vars, d.Variables = &VariablesDetail{Variables: vars}, vars
+			}
+			return nil
 		}
 		if n, ok := parseU32(s); ok {
-			return &AckDetail{Type: "count", Count: n}
+			*d = AckDetail{Type: "count", Count: n}
+			return nil
 		}
-		return &AckDetail{Type: "raw", Text: s}
+		*d = AckDetail{Type: "raw", Text: s}
 	case AckStatusPong:
-		return &AckDetail{Type: "raw", Text: s}
+		*d = AckDetail{Type: "raw", Text: s, Pong: parsePongDetail(s)}
 	case AckStatusCmd:
-		return &AckDetail{Type: "command", Text: s}
+		cmd, err := parseCommandDetail(s, pos)
+		if err != nil {
+			return err
+		}
+		*d = AckDetail{Type: "command", Text: s, Cmd: cmd}
 	case AckStatusErr:
 		code := parseErrorCodeStr(s)
-		return &AckDetail{Type: "error", ErrorCode: code, Text: s}
+		*d = AckDetail{Type: "error", ErrorCode: code, Text: s}
+	default:
+		*d = AckDetail{Type: "raw", Text: s}
 	}
-	return &AckDetail{Type: "raw", Text: s}
+	return nil
 }
 
 // ParseHeadless parses a headless inner frame (for TagoTiP/S).
@@ -936,6 +1470,7 @@ func parseAckDetail(s string, status AckStatus) *AckDetail {
 //   - PING: SERIAL
 func ParseHeadless(method Method, input string) (*HeadlessFrame, error) {
 	frame := &HeadlessFrame{}
+	p := newParser(nil)
 
 	switch method {
 	case MethodPush:
@@ -949,7 +1484,7 @@ func ParseHeadless(method Method, input string) (*HeadlessFrame, error) {
 		}
 		frame.Serial = serial
 		body := input[pipePos+1:]
-		pb, err := parsePushBody(body, pipePos+1)
+		pb, err := p.parsePushBody(body, pipePos+1)
 		if err != nil {
 			return nil, err
 		}
@@ -966,7 +1501,7 @@ func ParseHeadless(method Method, input string) (*HeadlessFrame, error) {
 		}
 		frame.Serial = serial
 		body := input[pipePos+1:]
-		pb, err := parsePullBody(body, pipePos+1)
+		pb, err := p.parsePullBody(body, pipePos+1)
 		if err != nil {
 			return nil, err
 		}
@@ -989,19 +1524,30 @@ func ParseAckInner(input string) (*AckFrame, error) {
 		return nil, fail(ErrInvalidAck, 0)
 	}
 
-	status, err := parseAckStatus(fields[0])
+	status, err := parseAckStatus(fields[0], 0)
 	if err != nil {
 		return nil, err
 	}
 
 	var detail *AckDetail
 	if len(fields) > 1 {
-		detail = parseAckDetail(fields[1], status)
+		detail = &AckDetail{}
+		p := newParser(nil)
+		if err := p.parseAckDetail(detail, fields[1], status, len(fields[0])+1); err != nil {
+			return nil, err
+		}
+		if len(fields) > 2 {
+			extraPos := len(fields[0]) + len(fields[1]) + 2
+			if err := parseAckExtra(detail, status, input[extraPos:], extraPos); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	return &AckFrame{
-		Status: status,
-		Detail: detail,
+		Status:    status,
+		Detail:    detail,
+		detailPos: len(fields[0]) + 1,
 	}, nil
 }
 
@@ -1033,3 +1579,32 @@ func parseErrorCodeStr(s string) ErrorCode {
 		return ErrorCodeUnknown
 	}
 }
+
+func errorCodeName(c ErrorCode) string {
+	switch c {
+	case ErrorCodeInvalidToken:
+		return "invalid_token"
+	case ErrorCodeInvalidMethod:
+		return "invalid_method"
+	case ErrorCodeInvalidPayload:
+		return "invalid_payload"
+	case ErrorCodeInvalidSeq:
+		return "invalid_seq"
+	case ErrorCodeDeviceNotFound:
+		return "device_not_found"
+	case ErrorCodeVariableNotFound:
+		return "variable_not_found"
+	case ErrorCodeRateLimited:
+		return "rate_limited"
+	case ErrorCodeAuthFailed:
+		return "auth_failed"
+	case ErrorCodeUnsupportedVersion:
+		return "unsupported_version"
+	case ErrorCodePayloadTooLarge:
+		return "payload_too_large"
+	case ErrorCodeServerError:
+		return "server_error"
+	default:
+		return "unknown"
+	}
+}
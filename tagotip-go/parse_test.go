@@ -2,6 +2,8 @@ package tagotip
 
 import (
 	"errors"
+	"math/rand"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -373,6 +375,46 @@ func TestRejectNulByte(t *testing.T) {
 	assertParseError(t, err, ErrNulByte)
 }
 
+func TestRejectAckFrameTooLarge(t *testing.T) {
+	large := "ACK|OK|[x=" + strings.Repeat("a", MaxFrameSize) + "]"
+	_, err := ParseAck(large)
+	assertParseError(t, err, ErrFrameTooLarge)
+	err = ParseAckInto(&AckFrame{}, large)
+	assertParseError(t, err, ErrFrameTooLarge)
+}
+
+func TestRejectAckNulByte(t *testing.T) {
+	_, err := ParseAck("ACK|!3|OK|[x:=1\x00]")
+	assertParseError(t, err, ErrNulByte)
+}
+
+func TestParseAckErrorPositions(t *testing.T) {
+	for _, tc := range []struct {
+		raw  string
+		kind ParseErrorKind
+		pos  int
+	}{
+		{"NAK|OK", ErrInvalidAck, 0},
+		{"ACK", ErrInvalidAck, 4},
+		{"ACK|!x|OK", ErrInvalidSeq, 4},
+		{"ACK|BADSTATUS", ErrInvalidAck, 4},
+		{"ACK|!42|BADSTATUS", ErrInvalidAck, 8},
+		{"ACK|!42", ErrInvalidAck, 8},
+		{"ACK|!42|CMD|fw >xZZ", ErrInvalidPassthru, 17},
+	} {
+		for _, parse := range []func(string) error{
+			func(s string) error { _, err := ParseAck(s); return err },
+			func(s string) error { return ParseAckInto(&AckFrame{}, s) },
+		} {
+			err := parse(tc.raw)
+			var pe *ParseError
+			if !errors.As(err, &pe) || pe.Kind != tc.kind || pe.Position != tc.pos {
+				t.Errorf("%s: got %v, want %s at %d", tc.raw, err, tc.kind, tc.pos)
+			}
+		}
+	}
+}
+
 func TestRejectEmptyStringValue(t *testing.T) {
 	_, err := ParseUplink("PUSH|" + testAuth + "|dev|[x=]")
 	assertParseError(t, err, ErrInvalidVariable)
@@ -637,6 +679,104 @@ func TestRejectInvalidAckStatus(t *testing.T) {
 	assertParseError(t, err, ErrInvalidAck)
 }
 
+// =========================================================================
+// ParseAckInto — frame reuse
+// =========================================================================
+
+var ackMatrix = []string{
+	"ACK|OK",
+	"ACK|OK|3",
+	"ACK|OK|0",
+	"ACK|OK|4294967295",
+	"ACK|OK|[temp:=32]",
+	"ACK|OK|x",
+	"ACK|!10|OK|5",
+	"ACK|!42|ERR|rate_limited",
+	"ACK|ERR|auth_failed",
+	"ACK|ERR|whatever",
+	"ACK|PONG",
+	"ACK|PONG|hi",
+	"ACK|CMD|reboot",
+	"ACK|CMD",
+	"ACK|CMD|a\\|b|c",
+	"ACK|OK|3\n",
+	"ACK|OK|1|2|3|4|5|6|7|8",
+	"",
+	"ACK",
+	"ACK|",
+	"ACK|!|OK",
+	"ACK|!1",
+	"ACK|INVALID",
+	"NAK|OK",
+}
+
+func TestParseAckIntoMatchesParseAck(t *testing.T) {
+	reused := &AckFrame{}
+	for _, input := range ackMatrix {
+		want, wantErr := ParseAck(input)
+		gotErr := ParseAckInto(reused, input)
+		if (wantErr == nil) != (gotErr == nil) {
+			t.Fatalf("%q: error mismatch: ParseAck %v, ParseAckInto %v", input, wantErr, gotErr)
+		}
+		if wantErr != nil {
+			if wantErr.Error() != gotErr.Error() {
+				t.Errorf("%q: error mismatch: %v vs %v", input, wantErr, gotErr)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(want.Seq, reused.Seq) || want.Status != reused.Status || !reflect.DeepEqual(want.Detail, reused.Detail) {
+			t.Errorf("%q: got %+v, want %+v", input, reused, want)
+		}
+	}
+}
+
+func TestAppendPlainFieldsMatchesAppendFields(t *testing.T) {
+	for _, input := range append(ackMatrix, "a|b|c|d|e|f|g|h|i|j", "||", "|") {
+		if strings.IndexByte(input, '\\') != -1 {
+			continue
+		}
+		want := appendFields(nil, input)
+		got := appendPlainFields(nil, input)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%q: got %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestParseAckIntoClearsPreviousFrame(t *testing.T) {
+	frame := &AckFrame{}
+	if err := ParseAckInto(frame, "ACK|!10|ERR|rate_limited"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseAckInto(frame, "ACK|OK"); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Seq != nil || frame.Detail != nil || frame.Status != AckStatusOk {
+		t.Errorf("stale fields: %+v", frame)
+	}
+	if err := ParseAckInto(frame, "ACK|OK|[a]"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseAckInto(frame, "ACK|OK|3"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*frame.Detail, AckDetail{Type: "count", Count: 3}) {
+		t.Errorf("stale detail: %+v", frame.Detail)
+	}
+}
+
+func TestParseAckIntoZeroAllocs(t *testing.T) {
+	frame := &AckFrame{}
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := ParseAckInto(frame, "ACK|OK|3"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected 0 allocs per reused parse, got %.1f", allocs)
+	}
+}
+
 // =========================================================================
 // Number edge cases
 // =========================================================================
@@ -810,3 +950,409 @@ func TestSpec11_12(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// =========================================================================
+// ParseUplinkInto — frame reuse
+// =========================================================================
+
+func dataloggerFrame(n int) string {
+	var b strings.Builder
+	b.WriteString("PUSH|!7|" + testAuth + "|dev|@1700000000000^batch{src=dht22}[")
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString("temperature:=21.5#C@1700000000000^g{k=v}")
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func TestParseUplinkIntoMatchesParseUplink(t *testing.T) {
+	inputs := []string{
+		dataloggerFrame(3),
+		"PUSH|" + testAuth + "|dev|[pos@=39.74,-104.99,305;ok?=true;s=hi]",
+		"PUSH|" + testAuth + "|dev|>xDEADBEEF",
+		"PULL|!1|" + testAuth + "|dev|[a;b]",
+		"PING|" + testAuth + "|dev",
+	}
+	reused := &UplinkFrame{}
+	for _, input := range inputs {
+		want, err := ParseUplink(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ParseUplinkInto(reused, input); err != nil {
+			t.Fatal(err)
+		}
+		wantStr, _ := BuildUplink(want)
+		gotStr, _ := BuildUplink(reused)
+		if wantStr != gotStr {
+			t.Errorf("mismatch for %s:\n  want: %s\n  got:  %s", input, wantStr, gotStr)
+		}
+	}
+}
+
+func TestParseUplinkIntoClearsPreviousFrame(t *testing.T) {
+	frame := &UplinkFrame{}
+	if err := ParseUplinkInto(frame, dataloggerFrame(5)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ParseUplinkInto(frame, "PUSH|"+testAuth+"|dev|[x:=1]"); err != nil {
+		t.Fatal(err)
+	}
+	if frame.Seq != nil {
+		t.Error("stale seq")
+	}
+	sb := frame.PushBody.Structured
+	if sb.Group != nil || sb.Timestamp != nil || len(sb.Meta) != 0 {
+		t.Error("stale body modifiers")
+	}
+	if len(sb.Variables) != 1 {
+		t.Fatalf("expected 1 var, got %d", len(sb.Variables))
+	}
+	v := sb.Variables[0]
+	if v.Name != "x" || v.Value.Str != "1" || v.Unit != nil || v.Timestamp != nil || v.Group != nil || len(v.Meta) != 0 {
+		t.Errorf("stale variable fields: %+v", v)
+	}
+}
+
+func TestParseUplinkIntoSteadyStateAllocs(t *testing.T) {
+	input := dataloggerFrame(20)
+	frame := &UplinkFrame{}
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := ParseUplinkInto(frame, input); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected 0 allocs per reused parse, got %.1f", allocs)
+	}
+}
+
+// =========================================================================
+// Passthrough validation
+// =========================================================================
+
+func TestRejectMalformedPassthrough(t *testing.T) {
+	prefix := "PUSH|" + testAuth + "|dev|"
+	// offset is the position of the error within the payload. Hex errors
+	// are reported at its start, base64 errors at the offending byte.
+	for _, tc := range []struct {
+		body   string
+		offset int
+	}{
+		{">x", 0}, {">xDEA", 0}, {">xDEADBEEG", 0}, {">x DEADBEEF", 0},
+		{">b", 0}, {">b=", 0}, {">b==", 0}, {">bA", 1}, {">bAQ", 2}, {">bAAAAA", 5},
+		{">bAA=A", 2}, {">bAB=CD=", 2}, {">bA===", 1}, {">bAAA===", 3}, {">bAA-_", 2}, {">bAA AA", 2},
+	} {
+		_, err := ParseUplink(prefix + tc.body)
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Kind != ErrInvalidPassthru {
+			t.Errorf("%q: expected ErrInvalidPassthru, got %v", tc.body, err)
+			continue
+		}
+		if want := len(prefix) + 2 + tc.offset; pe.Position != want {
+			t.Errorf("%q: position %d, want %d", tc.body, pe.Position, want)
+		}
+	}
+}
+
+func TestPassthroughDecode(t *testing.T) {
+	prefix := "PUSH|" + testAuth + "|dev|"
+	cases := []struct {
+		body string
+		want string
+	}{
+		{">xDEADbeef", "\xde\xad\xbe\xef"},
+		{">bAQID", "\x01\x02\x03"},
+		{">bAQI=", "\x01\x02"},
+		{">bAQ==", "\x01"},
+		{">b3q2+7wECAwQ=", "\xde\xad\xbe\xef\x01\x02\x03\x04"},
+	}
+	for _, opts := range []*ParserOptions{nil, {RetainPassthrough: true}} {
+		for _, tc := range cases {
+			frame, err := ParseUplinkWithOptions(prefix+tc.body, opts)
+			if err != nil {
+				t.Fatalf("%q: %v", tc.body, err)
+			}
+			got, err := frame.PushBody.Passthrough.Decode()
+			if err != nil {
+				t.Fatalf("%q: %v", tc.body, err)
+			}
+			if string(got) != tc.want {
+				t.Errorf("%q: decoded %x, want %x", tc.body, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestPassthroughRetainedBytes(t *testing.T) {
+	opts := &ParserOptions{RetainPassthrough: true}
+	frame, err := ParseUplinkWithOptions("PUSH|"+testAuth+"|dev|>xDEADBEEF", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		if _, err := frame.PushBody.Passthrough.Decode(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected retained Decode to be free, got %.1f allocs", allocs)
+	}
+
+	// Without retention Decode works from Data.
+	plain, err := ParseUplink("PUSH|" + testAuth + "|dev|>xDEADBEEF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.PushBody.Passthrough.decoded != nil {
+		t.Error("bytes retained without RetainPassthrough")
+	}
+}
+
+// =========================================================================
+// Scanners — IndexByte versions against the byte-at-a-time originals
+// =========================================================================
+
+func refAppendFields(fields []string, input string) []string {
+	start := 0
+	i := 0
+	for i < len(input) {
+		if input[i] == '\\' && i+1 < len(input) {
+			i += 2
+			continue
+		}
+		if input[i] == '|' {
+			fields = append(fields, input[start:i])
+			start = i + 1
+			if len(fields) == maxFields-1 {
+				return append(fields, input[start:])
+			}
+		}
+		i++
+	}
+	return append(fields, input[start:])
+}
+
+func refFindUnescapedChar(s string, target byte, start int) int {
+	i := start
+	for i < len(s) {
+		if s[i] == '\\' && i+1 < len(s) {
+			i += 2
+			continue
+		}
+		if s[i] == target {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+func refScanUntilAny(s string, pos int, stops string) int {
+	i := pos
+	for i < len(s) {
+		if s[i] == '\\' && i+1 < len(s) {
+			i += 2
+			continue
+		}
+		if strings.IndexByte(stops, s[i]) >= 0 {
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+// scannerInputs returns the corpus frames plus pseudo-random strings dense
+// in backslashes and structural characters.
+func scannerInputs(t *testing.T) []string {
+	inputs := append(loadCorpus(t), "", "\\", "|", "\\|", "a\\", "||||||||||", "a\\\\|b")
+	rng := rand.New(rand.NewSource(1))
+	const alphabet = "ab\\|{}[]^@#;,="
+	for n := 0; n < 2000; n++ {
+		b := make([]byte, rng.Intn(40))
+		for i := range b {
+			b[i] = alphabet[rng.Intn(len(alphabet))]
+		}
+		inputs = append(inputs, string(b))
+	}
+	return inputs
+}
+
+func TestScannersMatchReference(t *testing.T) {
+	for _, s := range scannerInputs(t) {
+		if got, want := appendFields(nil, s), refAppendFields(nil, s); !reflect.DeepEqual(got, want) {
+			t.Errorf("appendFields(%q) = %q, want %q", s, got, want)
+		}
+		for start := 0; start <= len(s); start++ {
+			for _, target := range []byte("|[}") {
+				if got, want := findUnescapedChar(s, target, start), refFindUnescapedChar(s, target, start); got != want {
+					t.Errorf("findUnescapedChar(%q, %q, %d) = %d, want %d", s, target, start, got, want)
+				}
+			}
+			for _, stops := range []string{"@^{", "^{", "{"} {
+				if got, want := scanUntilAny(s, start, stops), refScanUntilAny(s, start, stops); got != want {
+					t.Errorf("scanUntilAny(%q, %d, %q) = %d, want %d", s, start, stops, got, want)
+				}
+			}
+		}
+	}
+}
+
+// =========================================================================
+// Location values
+// =========================================================================
+
+func TestRejectLocationEscapedComma(t *testing.T) {
+	// An escaped comma is not a component separator and a backslash is never
+	// valid inside a number, so the value is rejected rather than split.
+	_, err := ParseUplink("PUSH|" + testAuth + "|dev|[pos@=39.74\\,1,-104.99]")
+	assertParseError(t, err, ErrInvalidVariable)
+	var pe *ParseError
+	errors.As(err, &pe)
+	if pe.Position != len("PUSH|"+testAuth+"|dev|[pos@=") {
+		t.Errorf("expected error at value start, got %d", pe.Position)
+	}
+}
+
+func TestRejectLocationErrorPositions(t *testing.T) {
+	prefix := "PUSH|" + testAuth + "|dev|[x:=1;pos@="
+	for _, value := range []string{"1", "1,", ",1", "1,2,", "1,2,3,4", "a,2", "1,b", "1,2,c"} {
+		_, err := ParseUplink(prefix + value + "]")
+		assertParseError(t, err, ErrInvalidVariable)
+		var pe *ParseError
+		errors.As(err, &pe)
+		if pe.Position != len(prefix) {
+			t.Errorf("%q: expected position %d, got %d", value, len(prefix), pe.Position)
+		}
+	}
+}
+
+// =========================================================================
+// Grammar hints
+// =========================================================================
+
+func TestParseErrorExpected(t *testing.T) {
+	p := "PUSH|" + testAuth + "|dev|"
+	tests := []struct {
+		body     string
+		kind     ParseErrorKind
+		expected string
+	}{
+		{"[x?=maybe]", ErrInvalidVariable, "boolean value must be 'true' or 'false'"},
+		{"[x:=01]", ErrInvalidVariable, "number must not have a leading zero"},
+		{"[x=]", ErrInvalidVariable, "string value must not be empty"},
+		{"[x:=1.]", ErrInvalidVariable, "expected digits after '.'"},
+		{"[x:=.]", ErrInvalidVariable, "expected a number"},
+		{"[x:=-]", ErrInvalidVariable, "expected digits after '-'"},
+		{"[x:=]", ErrInvalidVariable, "expected a number"},
+		{"[x:=abc]", ErrInvalidVariable, "expected a number"},
+		{"[x:=1@12a]", ErrInvalidVariable, "expected digits for timestamp"},
+		{"[x:=1#]", ErrInvalidVariable, "expected a unit of 1 to 25 characters"},
+		{"[x:=1^Grp]", ErrInvalidVariable, "group may only contain a-z, 0-9, and '_'"},
+		{"[pos@=39.74,-104.99#m]", ErrInvalidVariable, "unit not allowed on location values"},
+		{"[pos@=1,2,3,4]", ErrInvalidVariable, "expected lat,lng[,alt] for location"},
+		{"[pos@=,-104.99]", ErrInvalidVariable, "expected lat,lng[,alt] for location"},
+		{"[pos@=39.74,]", ErrInvalidVariable, "expected lat,lng[,alt] for location"},
+		{"[pos@=39.74,-104.99,]", ErrInvalidVariable, "expected lat,lng[,alt] for location"},
+		{"[pos@=39]", ErrInvalidVariable, "expected lat,lng[,alt] for location"},
+		{"[Temp:=1]", ErrInvalidVariable, "variable name may only contain a-z, 0-9, and '_'"},
+		{"[:=1]", ErrInvalidVariable, "expected a variable name"},
+		{"[temp]", ErrInvalidVariable, "expected an operator: '=', ':=', '?=', or '@='"},
+		{"[x:=1{}]", ErrInvalidMetadata, "metadata block must not be empty"},
+		{"[x:=1{,}]", ErrInvalidMetadata, "metadata block must not be empty"},
+		{"[x:=1{badmeta}]", ErrInvalidMetadata, "expected key=value in metadata"},
+		{"[x:=1{Key=v}]", ErrInvalidMetadata, "metadata key may only contain a-z, 0-9, and '_'"},
+		{"[x:=1{k=v]", ErrInvalidMetadata, "expected '}' to close metadata"},
+		{"^group@123[x:=1]", ErrInvalidVariable, "group may only contain a-z, 0-9, and '_'"},
+		{"{k=v}@123[x:=1]", ErrInvalidModifier, "body modifiers must appear in the order @timestamp, ^group, {metadata}"},
+		{"@12a[x:=1]", ErrInvalidModifier, "expected digits for timestamp"},
+		{"x[x:=1]", ErrInvalidModifier, "expected '@', '^', '{', or '[' in body modifiers"},
+		{"{k=v[x:=1]", ErrInvalidMetadata, "expected '}' to close metadata"},
+	}
+	for _, tt := range tests {
+		_, err := ParseUplink(p + tt.body)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("%s: expected a ParseError, got %v", tt.body, err)
+			continue
+		}
+		if pe.Kind != tt.kind || pe.Expected != tt.expected {
+			t.Errorf("%s: got %s %q, want %s %q", tt.body, pe.Kind, pe.Expected, tt.kind, tt.expected)
+		}
+	}
+}
+
+func TestParseErrorMessage(t *testing.T) {
+	err := failf(ErrInvalidVariable, 37, "expected digits for timestamp")
+	if want := "tagotip: invalid_variable at position 37: expected digits for timestamp"; err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+	if want := "tagotip: invalid_auth at position 5"; fail(ErrInvalidAuth, 5).Error() != want {
+		t.Errorf("got %q, want %q", fail(ErrInvalidAuth, 5).Error(), want)
+	}
+}
+
+// =========================================================================
+// Line endings
+// =========================================================================
+
+func TestParseCRLF(t *testing.T) {
+	for _, input := range []string{
+		"PING|" + testAuth + "|dev\r\n",
+		"PING|" + testAuth + "|dev\r",
+		"PUSH|" + testAuth + "|dev|[a:=1;b=x]\r\n",
+		"PUSH|" + testAuth + "|dev|[a:=1;b=x]\r",
+	} {
+		f, err := ParseUplink(input)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+			continue
+		}
+		if f.Serial != "dev" {
+			t.Errorf("%q: serial %q", input, f.Serial)
+		}
+		if f.Method == MethodPush {
+			if v := f.PushBody.Structured.Variables[1]; v.Value.Str != "x" {
+				t.Errorf("%q: last value %q", input, v.Value.Str)
+			}
+		}
+	}
+
+	a, err := ParseAck("ACK|!7|OK|3\r\n")
+	if err != nil || *a.Seq != 7 || a.Detail == nil || a.Detail.Count != 3 {
+		t.Errorf("ACK: %+v, %v", a, err)
+	}
+	h, err := ParseUplinkHeader("PING|" + testAuth + "|dev\r\n")
+	if err != nil || h.Serial != "dev" {
+		t.Errorf("header: %+v, %v", h, err)
+	}
+	input := AppendChecksum("PUSH|"+testAuth+"|dev|[a:=1]") + "\r\n"
+	if _, err := ParseUplinkWithOptions(input, &ParserOptions{VerifyChecksum: true}); err != nil {
+		t.Errorf("checksum: %v", err)
+	}
+}
+
+func TestParseCRMidFrame(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		kind  ParseErrorKind
+	}{
+		{"PING|" + testAuth + "|d\rev\r\n", ErrInvalidSerial},
+		{"PUSH|" + testAuth + "|dev\r|[a:=1]\r\n", ErrInvalidSerial},
+		{"PUSH|" + testAuth + "|dev|[a:=1\r;b:=2]\r\n", ErrInvalidVariable},
+		{"PING|" + testAuth + "|dev\r\r\n", ErrInvalidSerial},
+		{"ACK|OK\r|3", ErrInvalidAck},
+	} {
+		var err error
+		if strings.HasPrefix(tc.input, "ACK") {
+			_, err = ParseAck(tc.input)
+		} else {
+			_, err = ParseUplink(tc.input)
+		}
+		assertParseError(t, err, tc.kind)
+	}
+}
@@ -0,0 +1,63 @@
+package tagotip
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// NewPassthroughBody returns a passthrough body carrying data, encoded in
+// upper-case hex for PassthroughEncodingHex and in padded standard base64
+// for PassthroughEncodingBase64. BuildUplink writes it with the ">x" or
+// ">b" prefix of its encoding.
+func NewPassthroughBody(data []byte, enc PassthroughEncoding) *PassthroughBody {
+	if enc == PassthroughEncodingBase64 {
+		return &PassthroughBody{Encoding: enc, Data: base64.StdEncoding.EncodeToString(data)}
+	}
+	return &PassthroughBody{Encoding: PassthroughEncodingHex, Data: hexUpper(data)}
+}
+
+func hexUpper(data []byte) string {
+	const digits = "0123456789ABCDEF"
+	b := make([]byte, 2*len(data))
+	for i, c := range data {
+		b[2*i], b[2*i+1] = digits[c>>4], digits[c&0x0f]
+	}
+	return string(b)
+}
+
+// Bytes decodes Data according to Encoding into a new slice, which the
+// caller owns. Malformed data is reported as a *ParseError of kind
+// ErrInvalidPassthru whose Position is the offset within Data, under the
+// rules the parser applies to a PUSH payload.
+func (pt *PassthroughBody) Bytes() ([]byte, error) {
+	if len(pt.Data) == 0 {
+		return nil, failf(ErrInvalidPassthru, 0, "expected a payload")
+	}
+	switch pt.Encoding {
+	case PassthroughEncodingHex:
+		if off, msg := checkHex(pt.Data); off >= 0 {
+			return nil, failf(ErrInvalidPassthru, off, msg)
+		}
+		return hex.DecodeString(pt.Data)
+	case PassthroughEncodingBase64:
+		if off, msg := checkBase64(pt.Data); off >= 0 {
+			return nil, failf(ErrInvalidPassthru, off, msg)
+		}
+		return base64.StdEncoding.DecodeString(pt.Data)
+	}
+	return nil, failf(ErrInvalidPassthru, 0, "unknown encoding")
+}
+
+// checkHex is the hex counterpart of checkBase64.
+func checkHex(data string) (int, string) {
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return i, "invalid hex character"
+		}
+	}
+	if len(data)%2 != 0 {
+		return len(data), "hex length must be even"
+	}
+	return -1, ""
+}
@@ -0,0 +1,67 @@
+package tagotip
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Passthrough payloads
+// ============================================================================
+
+func TestNewPassthroughBodyRoundTrip(t *testing.T) {
+	data := []byte{0xde, 0xad, 0xbe, 0xef, 0x01, 0xfe}
+	for enc, prefix := range map[PassthroughEncoding]string{
+		PassthroughEncodingHex:    ">xDEADBEEF01FE",
+		PassthroughEncodingBase64: ">b3q2+7wH+",
+	} {
+		frame := &UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev",
+			PushBody: &PushBody{IsPassthrough: true, Passthrough: NewPassthroughBody(data, enc)}}
+		raw, err := BuildUplink(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(raw, "|"+prefix) {
+			t.Errorf("encoding %d built as %s", enc, raw)
+		}
+		got, err := mustParse(t, raw).PushBody.Passthrough.Bytes()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("encoding %d decoded as %x, %v", enc, got, err)
+		}
+	}
+}
+
+func TestPassthroughBytesErrors(t *testing.T) {
+	for _, tc := range []struct {
+		pt     PassthroughBody
+		offset int
+	}{
+		{PassthroughBody{Encoding: PassthroughEncodingHex, Data: ""}, 0},
+		{PassthroughBody{Encoding: PassthroughEncodingHex, Data: "DEADBEEG"}, 7},
+		{PassthroughBody{Encoding: PassthroughEncodingHex, Data: "DEA"}, 3},
+		{PassthroughBody{Encoding: PassthroughEncodingBase64, Data: "AB=CD="}, 2},
+		{PassthroughBody{Encoding: PassthroughEncodingBase64, Data: "AQ"}, 2},
+		{PassthroughBody{Encoding: PassthroughEncoding(9), Data: "AQ=="}, 0},
+	} {
+		_, err := tc.pt.Bytes()
+		var pe *ParseError
+		if !errors.As(err, &pe) || pe.Kind != ErrInvalidPassthru || pe.Position != tc.offset {
+			t.Errorf("%q: got %v, want invalid_passthrough at %d", tc.pt.Data, err, tc.offset)
+		}
+	}
+}
+
+func TestPassthroughBytesIsOwned(t *testing.T) {
+	frame, err := ParseUplinkWithOptions("PUSH|"+testAuth+"|dev|>xDEADBEEF", &ParserOptions{RetainPassthrough: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt := frame.PushBody.Passthrough
+	b, _ := pt.Bytes()
+	b[0] = 0
+	if d, _ := pt.Decode(); d[0] != 0xde {
+		t.Errorf("Bytes shares the retained buffer")
+	}
+}
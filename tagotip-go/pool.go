@@ -0,0 +1,119 @@
+package tagotip
+
+import "sync"
+
+// ---------------------------------------------------------------------------
+// Reset
+// ---------------------------------------------------------------------------
+//
+// Reset methods return a value to its zero state as seen through the
+// exported fields, while keeping its storage for the next parse: slices are
+// truncated to zero length, and sub-objects that were allocated (bodies,
+// sequence counters, ACK details) are parked in unexported fields from which
+// ParseUplinkInto and ParseAckInto take them again.
+
+// Reset clears every field of f, retaining its allocated storage.
+func (f *UplinkFrame) Reset() {
+	if f.Seq != nil {
+		f.seqSpare = f.Seq
+	}
+	if f.PushBody != nil {
+		f.PushBody.Reset()
+		f.pushSpare = f.PushBody
+	}
+	if f.PullBody != nil {
+		f.PullBody.Reset()
+		f.pullSpare = f.PullBody
+	}
+	*f = UplinkFrame{seqSpare: f.seqSpare, pushSpare: f.pushSpare, pullSpare: f.pullSpare}
+}
+
+// Reset clears every field of b, retaining its allocated storage.
+func (b *PushBody) Reset() {
+	if b.Structured != nil {
+		b.Structured.Reset()
+		b.structuredSpare = b.Structured
+	}
+	if b.Passthrough != nil {
+		b.Passthrough.reset()
+		b.passthroughSpare = b.Passthrough
+	}
+	*b = PushBody{structuredSpare: b.structuredSpare, passthroughSpare: b.passthroughSpare}
+}
+
+// Reset clears every field of b, retaining its allocated storage. The
+// Variables slice is truncated; the variables beyond its length keep their
+// storage for reuse and must not be read.
+func (b *StructuredBody) Reset() {
+	if b.Group != nil {
+		b.groupSpare = b.Group
+	}
+	if b.Timestamp != nil {
+		b.timestampSpare = b.Timestamp
+	}
+	*b = StructuredBody{
+		Meta:           b.Meta[:0],
+		Variables:      b.Variables[:0],
+		groupSpare:     b.groupSpare,
+		timestampSpare: b.timestampSpare,
+	}
+}
+
+func (pt *PassthroughBody) reset() {
+	*pt = PassthroughBody{decoded: pt.decoded[:0]}
+}
+
+// Reset clears every field of b, retaining its allocated storage.
+func (b *PullBody) Reset() {
+	b.Variables = b.Variables[:0]
+}
+
+// Reset clears every field of f, retaining its allocated storage.
+func (f *AckFrame) Reset() {
+	if f.Seq != nil {
+		f.seqSpare = f.Seq
+	}
+	if f.Detail != nil {
+		f.detailSpare = f.Detail
+	}
+	*f = AckFrame{seqSpare: f.seqSpare, detailSpare: f.detailSpare}
+}
+
+// spare returns *s, allocating it first if it is nil.
+func spare[T any](s **T) *T {
+	if *s == nil {
+		*s = new(T)
+	}
+	return *s
+}
+
+// ---------------------------------------------------------------------------
+// FramePool
+// ---------------------------------------------------------------------------
+
+// FramePool is a pool of reusable UplinkFrames for use with
+// ParseUplinkInto. The zero value is ready to use and a FramePool is safe
+// for concurrent use.
+//
+// A frame handed to Put is reset and may be returned by a later Get on any
+// goroutine, so callers must not keep the frame, or any slice, pointer, or
+// body taken from it, after calling Put. Copy out whatever must outlive the
+// frame first.
+type FramePool struct {
+	pool sync.Pool
+}
+
+// Get returns an empty frame from the pool, allocating one if the pool is
+// empty.
+func (p *FramePool) Get() *UplinkFrame {
+	if f, ok := p.pool.Get().(*UplinkFrame); ok {
+		return f
+	}
+	return &UplinkFrame{}
+}
+
+// Put resets f and returns it to the pool.
+func (p *FramePool) Put(f *UplinkFrame) {
+	f.Reset()
+	p.pool.Put(f)
+}
@@ -0,0 +1,129 @@
+package tagotip
+
+import (
+	"sync"
+	"testing"
+)
+
+// =========================================================================
+// Reset
+// =========================================================================
+
+func TestUplinkFrameResetClearsFields(t *testing.T) {
+	frame, err := ParseUplink(dataloggerFrame(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb := frame.PushBody.Structured
+	frame.Reset()
+	if frame.Method != 0 || frame.Seq != nil || frame.Auth != "" || frame.Serial != "" || frame.PushBody != nil || frame.PullBody != nil {
+		t.Errorf("frame not cleared: %+v", frame)
+	}
+	if sb.Group != nil || sb.Timestamp != nil || len(sb.Meta) != 0 || len(sb.Variables) != 0 {
+		t.Errorf("structured body not cleared: %+v", sb)
+	}
+	if cap(sb.Variables) < 5 {
+		t.Errorf("variable capacity not retained: %d", cap(sb.Variables))
+	}
+}
+
+func TestResetNoStaleDataAcrossParses(t *testing.T) {
+	inputs := []string{
+		dataloggerFrame(8),
+		"PUSH|" + testAuth + "|dev|[x:=1]",
+		"PUSH|" + testAuth + "|dev|>xDEADBEEF",
+		"PUSH|" + testAuth + "|dev|^g[y=s]",
+		"PULL|" + testAuth + "|dev|[a;b;c]",
+		"PULL|" + testAuth + "|dev|[a]",
+		"PING|" + testAuth + "|dev",
+		"PUSH|!3|" + testAuth + "|dev|{k=v}[z?=true]",
+	}
+	frame := &UplinkFrame{}
+	for i, a := range inputs {
+		for _, b := range inputs {
+			if err := ParseUplinkInto(frame, a); err != nil {
+				t.Fatal(err)
+			}
+			frame.Reset()
+			if err := ParseUplinkInto(frame, b); err != nil {
+				t.Fatal(err)
+			}
+			want, err := ParseUplink(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantRaw, _ := BuildUplink(want)
+			gotRaw, _ := BuildUplink(frame)
+			if wantRaw != gotRaw {
+				t.Errorf("input %d then %q: got %s", i, b, gotRaw)
+			}
+			if (frame.PushBody == nil) != (want.PushBody == nil) || (frame.PullBody == nil) != (want.PullBody == nil) || (frame.Seq == nil) != (want.Seq == nil) {
+				t.Errorf("input %d then %q: stale body or seq", i, b)
+			}
+			if pb := frame.PushBody; pb != nil && (pb.Structured == nil) == (pb.Passthrough == nil) {
+				t.Errorf("input %d then %q: stale push body kind", i, b)
+			}
+		}
+	}
+}
+
+func TestAckFrameReset(t *testing.T) {
+	frame := &AckFrame{}
+	if err := ParseAckInto(frame, "ACK|!9|ERR|rate_limited"); err != nil {
+		t.Fatal(err)
+	}
+	frame.Reset()
+	if frame.Seq != nil || frame.Status != 0 || frame.Detail != nil {
+		t.Errorf("ack not cleared: %+v", frame)
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		frame.Reset()
+		if err := ParseAckInto(frame, "ACK|!9|ERR|rate_limited"); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("expected reset frame to be reused without allocating, got %.1f", allocs)
+	}
+}
+
+// =========================================================================
+// FramePool
+// =========================================================================
+
+func TestFramePoolReturnsResetFrames(t *testing.T) {
+	var pool FramePool
+	frame := pool.Get()
+	if err := ParseUplinkInto(frame, dataloggerFrame(3)); err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(frame)
+	if frame.PushBody != nil || frame.Serial != "" {
+		t.Error("Put did not reset the frame")
+	}
+}
+
+func TestFramePoolConcurrent(t *testing.T) {
+	var pool FramePool
+	inputs := []string{dataloggerFrame(4), "PULL|" + testAuth + "|dev|[a;b]", "PING|" + testAuth + "|dev"}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 300; i++ {
+				input := inputs[(g+i)%len(inputs)]
+				frame := pool.Get()
+				if err := ParseUplinkInto(frame, input); err != nil {
+					t.Error(err)
+					return
+				}
+				if frame.Serial != "dev" {
+					t.Errorf("unexpected serial %q", frame.Serial)
+				}
+				pool.Put(frame)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
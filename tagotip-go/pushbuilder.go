@@ -0,0 +1,234 @@
+package tagotip
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Fluent PUSH construction
+// ---------------------------------------------------------------------------
+//
+// PushBuilder assembles a structured PUSH one call at a time:
+//
+//	raw, err := NewPush(auth, "sensor_01").Seq(42).
+//		Number("temp", 32.5).Unit("C").
+//		Bool("active", true).
+//		Location("pos", 39.74, -104.99).Group("batch").Timestamp(time.Now()).
+//		Meta("source", "dht22").
+//		Build()
+//
+// Unit, Altitude, Timestamp, Group, and Meta apply to the variable added
+// last; before the first variable, Timestamp, Group, and Meta set the body
+// modifiers instead. Arguments are checked as they are given, and the first
+// problem is returned by Build.
+
+// PushBuilder builds a structured PUSH frame. Create one with NewPush.
+type PushBuilder struct {
+	frame UplinkFrame
+	body  StructuredBody
+	items int // variables and metadata pairs, against DefaultMaxTotalItems
+	err   error
+}
+
+// NewPush starts a structured PUSH from the device with the given
+// authorization hash and serial.
+func NewPush(auth, serial string) *PushBuilder {
+	b := &PushBuilder{frame: UplinkFrame{Method: MethodPush, Auth: auth, Serial: serial}}
+	if validateAuth(auth, 0) != nil {
+		b.failf("invalid authorization hash %q", auth)
+	} else if validateSerial(serial, 0) != nil {
+		b.failf("invalid serial %q", serial)
+	}
+	return b
+}
+
+func (b *PushBuilder) failf(format string, args ...any) *PushBuilder {
+	if b.err == nil {
+		b.err = fmt.Errorf("tagotip: push builder: "+format, args...)
+	}
+	return b
+}
+
+// Seq sets the sequence counter.
+func (b *PushBuilder) Seq(n uint32) *PushBuilder {
+	b.frame.Seq = &n
+	return b
+}
+
+// Number adds a number variable. v is written by FormatNumber with the
+// fewest digits that read back as v; a value it rejects, such as NaN, is
+// reported by Build.
+func (b *PushBuilder) Number(name string, v float64) *PushBuilder {
+	s, err := formatNumber(v, -1)
+	if err != nil {
+		return b.failf("number %q: %v", name, err)
+	}
+	return b.add(name, OperatorNumber, Value{Type: OperatorNumber, Str: s})
+}
+
+// Decimal adds a number variable holding d exactly.
+func (b *PushBuilder) Decimal(name string, d Decimal) *PushBuilder {
+	return b.add(name, OperatorNumber, DecimalValue(d))
+}
+
+// Text adds a string variable. The value is escaped when the frame is
+// built. (The method is not called String, which would read as
+// fmt.Stringer.)
+func (b *PushBuilder) Text(name, v string) *PushBuilder {
+	if v == "" {
+		return b.failf("string %q is empty", name)
+	}
+	return b.add(name, OperatorString, Value{Type: OperatorString, Str: v})
+}
+
+// Bool adds a boolean variable.
+func (b *PushBuilder) Bool(name string, v bool) *PushBuilder {
+	return b.add(name, OperatorBoolean, Value{Type: OperatorBoolean, Bool: v})
+}
+
+// Location adds a location variable. Use Altitude to add an altitude.
+func (b *PushBuilder) Location(name string, lat, lng float64) *PushBuilder {
+	latStr, err := formatNumber(lat, -1)
+	if err != nil {
+		return b.failf("location %q: %v", name, err)
+	}
+	lngStr, err := formatNumber(lng, -1)
+	if err != nil {
+		return b.failf("location %q: %v", name, err)
+	}
+	loc := &LocationValue{Lat: latStr, Lng: lngStr}
+	return b.add(name, OperatorLocation, Value{Type: OperatorLocation, Location: loc})
+}
+
+func (b *PushBuilder) add(name string, op Operator, v Value) *PushBuilder {
+	if validateVarname(name, 0) != nil {
+		return b.failf("invalid variable name %q", name)
+	}
+	if len(b.body.Variables) == MaxVariables {
+		return b.failf("more than %d variables", MaxVariables)
+	}
+	if b.items++; b.items > DefaultMaxTotalItems {
+		return b.failf("more than %d items", DefaultMaxTotalItems)
+	}
+	b.body.Variables = append(b.body.Variables, Variable{Name: name, Operator: op, Value: v})
+	return b
+}
+
+// last returns the variable added last, or nil, recording an error, when
+// there is none.
+func (b *PushBuilder) last(suffix string) *Variable {
+	if len(b.body.Variables) == 0 {
+		b.failf("%s given before any variable", suffix)
+		return nil
+	}
+	return &b.body.Variables[len(b.body.Variables)-1]
+}
+
+// Unit sets the unit of the last variable, which must not be a location.
+func (b *PushBuilder) Unit(unit string) *PushBuilder {
+	v := b.last("unit")
+	switch {
+	case v == nil:
+	case v.Operator == OperatorLocation:
+		b.failf("unit on location %q", v.Name)
+	case validateUnit(Escape(unit), 0) != nil:
+		b.failf("invalid unit %q", unit)
+	default:
+		v.Unit = &unit
+	}
+	return b
+}
+
+// Altitude sets the altitude of the last variable, which must be a
+// location.
+func (b *PushBuilder) Altitude(alt float64) *PushBuilder {
+	v := b.last("altitude")
+	switch {
+	case v == nil:
+	case v.Operator != OperatorLocation:
+		b.failf("altitude on %s %q", v.Operator, v.Name)
+	default:
+		s, err := formatNumber(alt, -1)
+		if err != nil {
+			b.failf("location %q: altitude %v", v.Name, err)
+			break
+		}
+		v.Value.Location.Alt = &s
+	}
+	return b
+}
+
+// Timestamp sets the timestamp of the last variable, or of the body before
+// any variable is added.
+func (b *PushBuilder) Timestamp(t time.Time) *PushBuilder {
+	if len(b.body.Variables) == 0 {
+		b.body.SetTimestamp(t)
+	} else {
+		b.body.Variables[len(b.body.Variables)-1].SetTimestamp(t)
+	}
+	return b
+}
+
+// Group sets the group of the last variable, or of the body before any
+// variable is added.
+func (b *PushBuilder) Group(group string) *PushBuilder {
+	if validateGroup(group, 0) != nil {
+		return b.failf("invalid group %q", group)
+	}
+	if len(b.body.Variables) == 0 {
+		b.body.Group = &group
+	} else {
+		b.body.Variables[len(b.body.Variables)-1].Group = &group
+	}
+	return b
+}
+
+// Meta adds a metadata pair to the last variable, or to the body before any
+// variable is added. The value is escaped when the frame is built.
+func (b *PushBuilder) Meta(key, value string) *PushBuilder {
+	if validateMetaKey(key, 0) != nil {
+		return b.failf("invalid metadata key %q", key)
+	}
+	meta := &b.body.Meta
+	if n := len(b.body.Variables); n > 0 {
+		meta = &b.body.Variables[n-1].Meta
+	}
+	if len(*meta) == MaxMetaPairs {
+		return b.failf("more than %d metadata pairs in a block", MaxMetaPairs)
+	}
+	if b.items++; b.items > DefaultMaxTotalItems {
+		return b.failf("more than %d items", DefaultMaxTotalItems)
+	}
+	*meta = append(*meta, MetaPair{Key: key, Value: value})
+	return b
+}
+
+// errNoVariables is returned by Build for a PUSH without variables.
+var errNoVariables = errors.New("tagotip: push builder: no variables")
+
+// Frame returns the frame built so far, or the first problem found.
+func (b *PushBuilder) Frame() (*UplinkFrame, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.body.Variables) == 0 {
+		return nil, errNoVariables
+	}
+	f := b.frame
+	body := b.body
+	f.PushBody = &PushBody{Structured: &body}
+	return cloneUplink(&f), nil
+}
+
+// Build serializes the frame. It fails on the first problem found while
+// building, and with a *FrameSizeError when the frame would exceed
+// MaxFrameSize.
+func (b *PushBuilder) Build() (string, error) {
+	f, err := b.Frame()
+	if err != nil {
+		return "", err
+	}
+	return BuildUplink(f)
+}
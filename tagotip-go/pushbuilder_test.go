@@ -0,0 +1,143 @@
+package tagotip
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// PushBuilder
+// ============================================================================
+
+func TestPushBuilder(t *testing.T) {
+	ts := time.UnixMilli(1700000000000)
+	raw, err := NewPush(testAuth, "sensor_01").Seq(42).
+		Number("temp", 32.5).Unit("C").
+		Bool("active", true).
+		Location("pos", 39.74, -104.99).Altitude(1609).Group("batch").Timestamp(ts).
+		Meta("source", "dht22").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "PUSH|!42|" + testAuth + "|sensor_01|[temp:=32.5#C;active?=true;pos@=39.74,-104.99,1609@1700000000000^batch{source=dht22}]"
+	if raw != want {
+		t.Fatalf("got  %s\nwant %s", raw, want)
+	}
+	if _, err := ParseUplink(raw); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPushBuilderBodyModifiers(t *testing.T) {
+	raw, err := NewPush(testAuth, "dev").
+		Group("g").Meta("fw", "1.2").
+		Number("v", 1).Meta("k", "x").
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "PUSH|" + testAuth + "|dev|^g{fw=1.2}[v:=1{k=x}]"; raw != want {
+		t.Errorf("got  %s\nwant %s", raw, want)
+	}
+}
+
+func TestPushBuilderEscapesStrings(t *testing.T) {
+	text := `a|b;c,d\e[f]`
+	raw, err := NewPush(testAuth, "dev").Text("msg", text).Meta("note", "x{y}").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := mustParse(t, raw)
+	v := frame.PushBody.Structured.Variables[0]
+	if v.Value.Str != text {
+		t.Errorf("string parsed back as %q", v.Value.Str)
+	}
+	if got := v.Meta[0].Value; got != "x{y}" {
+		t.Errorf("metadata parsed back as %q", got)
+	}
+}
+
+func TestPushBuilderFormatsNumbersWithoutExponent(t *testing.T) {
+	for _, tc := range []struct {
+		in   float64
+		want string
+	}{
+		{1e21, "1000000000000000000000"},
+		{0.000001, "0.000001"},
+		{-2.5e-7, "-0.00000025"},
+		{0, "0"},
+	} {
+		f, err := NewPush(testAuth, "dev").Number("v", tc.in).Frame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.PushBody.Structured.Variables[0].Value.Str; got != tc.want {
+			t.Errorf("%g written as %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestPushBuilderRejects(t *testing.T) {
+	for name, b := range map[string]*PushBuilder{
+		"auth":             NewPush("short", "dev").Number("v", 1),
+		"serial":           NewPush(testAuth, "bad|serial").Number("v", 1),
+		"variable name":    NewPush(testAuth, "dev").Number("Temp", 1),
+		"NaN":              NewPush(testAuth, "dev").Number("v", math.NaN()),
+		"too large":        NewPush(testAuth, "dev").Number("v", 1e23),
+		"large altitude":   NewPush(testAuth, "dev").Location("p", 1, 2).Altitude(-1e30),
+		"infinite lat":     NewPush(testAuth, "dev").Location("p", math.Inf(1), 0),
+		"empty string":     NewPush(testAuth, "dev").Text("s", ""),
+		"unit first":       NewPush(testAuth, "dev").Unit("C").Number("v", 1),
+		"unit on location": NewPush(testAuth, "dev").Location("p", 1, 2).Unit("m"),
+		"altitude on num":  NewPush(testAuth, "dev").Number("v", 1).Altitude(3),
+		"group":            NewPush(testAuth, "dev").Number("v", 1).Group("G"),
+		"metadata key":     NewPush(testAuth, "dev").Number("v", 1).Meta("K", "v"),
+		"no variables":     NewPush(testAuth, "dev").Seq(1),
+	} {
+		if raw, err := b.Build(); err == nil {
+			t.Errorf("%s: built %s", name, raw)
+		}
+	}
+}
+
+func TestPushBuilderKeepsFirstError(t *testing.T) {
+	_, err := NewPush(testAuth, "dev").Number("Bad", 1).Text("s", "").Build()
+	if err == nil || !strings.Contains(err.Error(), `"Bad"`) {
+		t.Errorf("got %v, want the variable name error", err)
+	}
+}
+
+func TestPushBuilderLimits(t *testing.T) {
+	b := NewPush(testAuth, "dev")
+	for i := 0; i <= MaxVariables; i++ {
+		b.Bool("v", true)
+	}
+	if _, err := b.Build(); err == nil || !strings.Contains(err.Error(), "variables") {
+		t.Errorf("%d variables: %v", MaxVariables+1, err)
+	}
+
+	b = NewPush(testAuth, "dev")
+	long := strings.Repeat("x", MaxFrameSize/4)
+	for i := 0; i < 5; i++ {
+		b.Text("s", long)
+	}
+	if _, err := b.Build(); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("oversized frame: %v", err)
+	}
+}
+
+func TestPushBuilderFrameIsIndependent(t *testing.T) {
+	b := NewPush(testAuth, "dev").Number("v", 1).Unit("C")
+	f, err := b.Frame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Unit("F").Number("w", 2)
+	sb := f.PushBody.Structured
+	if len(sb.Variables) != 1 || *sb.Variables[0].Unit != "C" {
+		t.Errorf("frame changed with the builder: %+v", sb.Variables)
+	}
+}
@@ -0,0 +1,440 @@
+package tagotip
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"math/rand"
+	"reflect"
+	"strconv"
+)
+
+// ---------------------------------------------------------------------------
+// Random frames (testing/quick)
+// ---------------------------------------------------------------------------
+//
+// UplinkFrame, AckFrame, and Variable implement quick.Generator, so property
+// tests can take them as arguments:
+//
+//	quick.Check(func(f *tagotip.UplinkFrame) bool { ... }, nil)
+//
+// Every generated frame is well formed (it passes Validate) and fits within
+// MaxFrameSize. The size argument bounds the number of variables and PULL
+// names.
+
+// Generate returns a random *UplinkFrame. It implements quick.Generator and
+// does not use its receiver, which may be nil.
+func (*UplinkFrame) Generate(r *rand.Rand, size int) reflect.Value {
+	f := &UplinkFrame{
+		Method: Method(r.Intn(3)),
+		Auth:   "at" + randHex(r, (AuthTokenLen-2)/2),
+		Serial: randString(r, serialAlphabet, 1, 24),
+	}
+	if r.Intn(2) == 0 {
+		seq := r.Uint32()
+		f.Seq = &seq
+	}
+	n := 1 + r.Intn(max(1, min(size, MaxVariables)))
+	switch f.Method {
+	case MethodPush:
+		if r.Intn(8) == 0 {
+			f.PushBody = &PushBody{IsPassthrough: true, Passthrough: randPassthrough(r)}
+			break
+		}
+		sb := &StructuredBody{Variables: make([]Variable, n)}
+		if r.Intn(4) == 0 {
+			sb.Timestamp = randOpt(r, randTimestamp)
+		}
+		if r.Intn(4) == 0 {
+			g := randString(r, nameAlphabet, 1, 12)
+			sb.Group = &g
+		}
+		sb.Meta = randMeta(r)
+		for i := range sb.Variables {
+			sb.Variables[i] = randVariable(r)
+		}
+		f.PushBody = &PushBody{Structured: sb}
+	case MethodPull:
+		names := make([]string, n)
+		for i := range names {
+			names[i] = randString(r, nameAlphabet, 1, 12)
+		}
+		f.PullBody = &PullBody{Variables: names}
+	}
+	return reflect.ValueOf(f)
+}
+
+// Generate returns a random Variable. It implements quick.Generator.
+func (Variable) Generate(r *rand.Rand, size int) reflect.Value {
+	return reflect.ValueOf(randVariable(r))
+}
+
+// Generate returns a random *AckFrame. It implements quick.Generator and
+// does not use its receiver, which may be nil.
+func (*AckFrame) Generate(r *rand.Rand, size int) reflect.Value {
+	f := &AckFrame{Status: AckStatus(r.Intn(4))}
+	if r.Intn(2) == 0 {
+		seq := r.Uint32()
+		f.Seq = &seq
+	}
+	switch f.Status {
+	case AckStatusOk:
+		if r.Intn(2) == 0 {
+			f.Detail = &AckDetail{Type: "count", Count: uint32(r.Intn(MaxVariables + 1))}
+			break
+		}
+		vars := make([]Variable, 1+r.Intn(max(1, min(size, 8))))
+		for i := range vars {
+			vars[i] = randVariable(r)
+		}
+		var b frameBuf
+		writeDetailVariables(&b, vars)
+		f.Detail = &AckDetail{Type: "variables", Text: b.String(), Vars: &VariablesDetail{Variables: vars}, Variables: vars}
+	case AckStatusCmd:
+		f.Detail = &AckDetail{Type: "command", Text: randString(r, valueAlphabet, 1, 32)}
+	case AckStatusErr:
+		code := ErrorCode(r.Intn(int(ErrorCodeUnknown)))
+		f.Detail = &AckDetail{Type: "error", Text: errorCodeName(code), ErrorCode: code}
+	}
+	return reflect.ValueOf(f)
+}
+
+const (
+	nameAlphabet   = "abcdefghijklmnopqrstuvwxyz0123456789_"
+	serialAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
+	valueAlphabet  = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 -._:/%"
+	unitAlphabet   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ%/"
+)
+
+// valueStructural holds the structural characters mixed into generated
+// string values, which BuildUplink escapes.
+const valueStructural = "|;,#@^{}[]\\\n"
+
+func randString(r *rand.Rand, alphabet string, minLen, maxLen int) string {
+	b := make([]byte, minLen+r.Intn(maxLen-minLen+1))
+	for i := range b {
+		b[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+func randHex(r *rand.Rand, n int) string {
+	b := make([]byte, n)
+	r.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func randOpt(r *rand.Rand, gen func(*rand.Rand) string) *string {
+	s := gen(r)
+	return &s
+}
+
+func randTimestamp(r *rand.Rand) string {
+	return strconv.FormatInt(1_600_000_000_000+r.Int63n(200_000_000_000), 10)
+}
+
+func randNumber(r *rand.Rand) string {
+	s := strconv.Itoa(r.Intn(100_000))
+	if r.Intn(3) == 0 {
+		s += "." + strconv.Itoa(r.Intn(1000))
+	}
+	if r.Intn(3) == 0 {
+		s = "-" + s
+	}
+	return s
+}
+
+func randMeta(r *rand.Rand) []MetaPair {
+	if r.Intn(3) != 0 {
+		return nil
+	}
+	pairs := make([]MetaPair, 1+r.Intn(3))
+	for i := range pairs {
+		pairs[i] = MetaPair{Key: randString(r, nameAlphabet, 1, 8), Value: randString(r, valueAlphabet, 1, 8)}
+	}
+	return pairs
+}
+
+func randVariable(r *rand.Rand) Variable {
+	v := Variable{Name: randString(r, nameAlphabet, 1, 12), Operator: Operator(r.Intn(4))}
+	v.Value.Type = v.Operator
+	switch v.Operator {
+	case OperatorNumber:
+		v.Value.Str = randNumber(r)
+	case OperatorString:
+		s := randString(r, valueAlphabet, 1, 16)
+		if r.Intn(4) == 0 {
+			s += string(valueStructural[r.Intn(len(valueStructural))])
+		}
+		v.Value.Str = s
+	case OperatorBoolean:
+		v.Value.Bool = r.Intn(2) == 0
+	case OperatorLocation:
+		loc := &LocationValue{Lat: randNumber(r), Lng: randNumber(r)}
+		if r.Intn(2) == 0 {
+			loc.Alt = randOpt(r, randNumber)
+		}
+		v.Value.Location = loc
+	}
+	if v.Operator != OperatorLocation && r.Intn(3) == 0 {
+		u := randString(r, unitAlphabet, 1, 6)
+		v.Unit = &u
+	}
+	if r.Intn(4) == 0 {
+		v.Timestamp = randOpt(r, randTimestamp)
+	}
+	if r.Intn(4) == 0 {
+		g := randString(r, nameAlphabet, 1, 12)
+		v.Group = &g
+	}
+	v.Meta = randMeta(r)
+	return v
+}
+
+func randPassthrough(r *rand.Rand) *PassthroughBody {
+	b := make([]byte, 1+r.Intn(64))
+	r.Read(b)
+	if r.Intn(2) == 0 {
+		return &PassthroughBody{Encoding: PassthroughEncodingHex, Data: hex.EncodeToString(b)}
+	}
+	return &PassthroughBody{Encoding: PassthroughEncodingBase64, Data: base64.StdEncoding.EncodeToString(b)}
+}
+
+// ---------------------------------------------------------------------------
+// Shrinking
+// ---------------------------------------------------------------------------
+
+// Shrink reduces a frame for which fails reports true to a smaller frame for
+// which it still does. It repeatedly removes variables, PULL names, and
+// metadata pairs, strips optional suffixes and modifiers, and shortens
+// strings, keeping each step only if the result passes Validate and still
+// fails. frame is not modified; if fails(frame) is false, Shrink returns a
+// copy of frame.
+func Shrink(frame *UplinkFrame, fails func(*UplinkFrame) bool) *UplinkFrame {
+	cur := cloneUplink(frame)
+	if !fails(cur) {
+		return cur
+	}
+	for progress := true; progress; {
+		progress = false
+		for _, edit := range shrinkEdits(cur) {
+			next := cloneUplink(cur)
+			if !edit(next) || next.Validate() != nil || !fails(next) {
+				continue
+			}
+			cur = next
+			progress = true
+			break
+		}
+	}
+	return cur
+}
+
+// shrinkEdit applies one reduction to a copy of the frame it was planned
+// for and reports whether it changed anything.
+type shrinkEdit func(f *UplinkFrame) bool
+
+// shrinkEdits plans the reductions of f, coarsest first.
+func shrinkEdits(f *UplinkFrame) []shrinkEdit {
+	var edits []shrinkEdit
+	if f.Seq != nil {
+		edits = append(edits, func(f *UplinkFrame) bool { f.Seq = nil; return true })
+	}
+
+	if pb := f.PushBody; pb != nil && pb.Structured != nil {
+		body := func(f *UplinkFrame) *StructuredBody { return f.PushBody.Structured }
+		edits = appendRemovals(edits, len(pb.Structured.Variables), func(f *UplinkFrame) *[]Variable { return &body(f).Variables })
+		edits = appendRemovals(edits, len(pb.Structured.Meta), func(f *UplinkFrame) *[]MetaPair { return (*[]MetaPair)(&body(f).Meta) })
+		edits = append(edits,
+			func(f *UplinkFrame) bool { return clearPtr(&body(f).Timestamp) },
+			func(f *UplinkFrame) bool { return clearPtr(&body(f).Group) },
+		)
+		for i := range pb.Structured.Variables {
+			v := func(f *UplinkFrame) *Variable { return &body(f).Variables[i] }
+			edits = appendRemovals(edits, len(pb.Structured.Variables[i].Meta), func(f *UplinkFrame) *[]MetaPair { return (*[]MetaPair)(&v(f).Meta) })
+			edits = append(edits,
+				func(f *UplinkFrame) bool { return clearPtr(&v(f).Unit) },
+				func(f *UplinkFrame) bool { return clearPtr(&v(f).Timestamp) },
+				func(f *UplinkFrame) bool { return clearPtr(&v(f).Group) },
+				func(f *UplinkFrame) bool {
+					loc := v(f).Value.Location
+					return loc != nil && clearPtr(&loc.Alt)
+				},
+			)
+		}
+	}
+	if f.PullBody != nil {
+		edits = appendRemovals(edits, len(f.PullBody.Variables), func(f *UplinkFrame) *[]string { return &f.PullBody.Variables })
+	}
+
+	// Shortening comes last: it is the finest-grained reduction.
+	edits = appendShortenings(edits, func(f *UplinkFrame) *string { return &f.Serial })
+	if pb := f.PushBody; pb != nil && pb.Passthrough != nil {
+		edits = appendShortenings(edits, func(f *UplinkFrame) *string { return &f.PushBody.Passthrough.Data })
+	}
+	if pb := f.PushBody; pb != nil && pb.Structured != nil {
+		sb := pb.Structured
+		body := func(f *UplinkFrame) *StructuredBody { return f.PushBody.Structured }
+		edits = appendOptShortenings(edits, sb.Group, func(f *UplinkFrame) *string { return body(f).Group })
+		edits = appendMetaShortenings(edits, len(sb.Meta), func(f *UplinkFrame) []MetaPair { return body(f).Meta })
+		for i := range sb.Variables {
+			v := func(f *UplinkFrame) *Variable { return &body(f).Variables[i] }
+			edits = appendShortenings(edits, func(f *UplinkFrame) *string { return &v(f).Name })
+			if sb.Variables[i].Operator == OperatorString {
+				edits = appendShortenings(edits, func(f *UplinkFrame) *string { return &v(f).Value.Str })
+			}
+			edits = appendOptShortenings(edits, sb.Variables[i].Unit, func(f *UplinkFrame) *string { return v(f).Unit })
+			edits = appendOptShortenings(edits, sb.Variables[i].Group, func(f *UplinkFrame) *string { return v(f).Group })
+			edits = appendMetaShortenings(edits, len(sb.Variables[i].Meta), func(f *UplinkFrame) []MetaPair { return v(f).Meta })
+		}
+	}
+	if f.PullBody != nil {
+		for i := range f.PullBody.Variables {
+			edits = appendShortenings(edits, func(f *UplinkFrame) *string { return &f.PullBody.Variables[i] })
+		}
+	}
+	return edits
+}
+
+// appendRemovals plans the removal of runs of n elements from the slice
+// returned by get: halves first, then quarters, down to single elements.
+func appendRemovals[T any](edits []shrinkEdit, n int, get func(*UplinkFrame) *[]T) []shrinkEdit {
+	for size := n; size >= 1; size /= 2 {
+		for start := 0; start < n; start += size {
+			end := min(start+size, n)
+			edits = append(edits, func(f *UplinkFrame) bool {
+				s := get(f)
+				*s = append((*s)[:start:start], (*s)[end:]...)
+				return true
+			})
+		}
+	}
+	return edits
+}
+
+// appendShortenings plans cutting the string returned by get in half and
+// dropping its last byte.
+func appendShortenings(edits []shrinkEdit, get func(*UplinkFrame) *string) []shrinkEdit {
+	return append(edits,
+		func(f *UplinkFrame) bool {
+			s := get(f)
+			if len(*s) < 2 {
+				return false
+			}
+			*s = (*s)[:len(*s)/2]
+			return true
+		},
+		func(f *UplinkFrame) bool {
+			s := get(f)
+			if len(*s) < 2 {
+				return false
+			}
+			*s = (*s)[:len(*s)-1]
+			return true
+		},
+	)
+}
+
+func appendOptShortenings(edits []shrinkEdit, s *string, get func(*UplinkFrame) *string) []shrinkEdit {
+	if s == nil {
+		return edits
+	}
+	return appendShortenings(edits, get)
+}
+
+func appendMetaShortenings(edits []shrinkEdit, n int, get func(*UplinkFrame) []MetaPair) []shrinkEdit {
+	for i := 0; i < n; i++ {
+		edits = appendShortenings(edits, func(f *UplinkFrame) *string { return &get(f)[i].Key })
+		edits = appendShortenings(edits, func(f *UplinkFrame) *string { return &get(f)[i].Value })
+	}
+	return edits
+}
+
+func clearPtr(p **string) bool {
+	if *p == nil {
+		return false
+	}
+	*p = nil
+	return true
+}
+
+// cloneUplink returns a deep copy of f's exported contents. Lazily held
+// metadata is parsed into the copy, leaving f untouched.
+func cloneUplink(f *UplinkFrame) *UplinkFrame {
+	c := &UplinkFrame{
+		Method:    f.Method,
+		Seq:       clonePtr(f.Seq),
+		Auth:      f.Auth,
+		Serial:    f.Serial,
+		RawMethod: f.RawMethod,
+		RawBody:   f.RawBody,
+
+		PingDiagnostics: cloneSlice(f.PingDiagnostics),
+		Signature:       f.Signature,
+		Warnings:        cloneSlice(f.Warnings),
+	}
+	if pb := f.PushBody; pb != nil {
+		c.PushBody = &PushBody{IsPassthrough: pb.IsPassthrough}
+		if pt := pb.Passthrough; pt != nil {
+			c.PushBody.Passthrough = &PassthroughBody{Encoding: pt.Encoding, Data: pt.Data}
+		}
+		if sb := pb.Structured; sb != nil {
+			csb := &StructuredBody{
+				Group:     clonePtr(sb.Group),
+				Timestamp: clonePtr(sb.Timestamp),
+				Meta:      cloneMeta(sb.Meta, sb.rawMeta),
+				Variables: make([]Variable, len(sb.Variables)),
+			}
+			for i := range sb.Variables {
+				csb.Variables[i] = cloneVariable(&sb.Variables[i])
+			}
+			c.PushBody.Structured = csb
+		}
+	}
+	if f.PullBody != nil {
+		c.PullBody = &PullBody{Variables: cloneSlice(f.PullBody.Variables)}
+	}
+	return c
+}
+
+// cloneVariable returns a deep copy of v's exported contents.
+func cloneVariable(v *Variable) Variable {
+	c := Variable{
+		Name:      v.Name,
+		Operator:  v.Operator,
+		Value:     v.Value,
+		Unit:      clonePtr(v.Unit),
+		Timestamp: clonePtr(v.Timestamp),
+		Group:     clonePtr(v.Group),
+		Meta:      cloneMeta(v.Meta, v.rawMeta),
+	}
+	if loc := v.Value.Location; loc != nil {
+		c.Value.Location = &LocationValue{Lat: loc.Lat, Lng: loc.Lng, Alt: clonePtr(loc.Alt)}
+	}
+	return c
+}
+
+// cloneMeta copies meta, or parses raw into a new slice when the metadata is
+// held lazily.
+func cloneMeta(meta []MetaPair, raw string) []MetaPair {
+	if len(meta) == 0 && raw != "" {
+		p := newParser(nil)
+		m, _ := p.parseMetadata(nil, raw, 0)
+		return m
+	}
+	return cloneSlice(meta)
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func cloneSlice[T any](s []T) []T {
+	if s == nil {
+		return nil
+	}
+	return append([]T(nil), s...)
+}
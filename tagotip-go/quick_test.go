@@ -0,0 +1,169 @@
+package tagotip
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// ============================================================================
+// Generators
+// ============================================================================
+
+var quickConfig = &quick.Config{MaxCount: 500, Rand: rand.New(rand.NewSource(1))}
+
+func TestGeneratedUplinkFramesValidate(t *testing.T) {
+	check := func(f *UplinkFrame) bool {
+		if err := f.Validate(); err != nil {
+			t.Log(err)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(check, quickConfig); err != nil {
+		t.Fatal(err)
+	}
+
+	// quick passes a fixed size of 50; cover frames up to MaxVariables too.
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		f := (*UplinkFrame)(nil).Generate(r, MaxVariables).Interface().(*UplinkFrame)
+		if !check(f) {
+			t.Fatalf("generated frame %d is invalid", i)
+		}
+	}
+}
+
+func TestGeneratedVariablesValidate(t *testing.T) {
+	check := func(v Variable) bool {
+		f := &UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev",
+			PushBody: &PushBody{Structured: &StructuredBody{Variables: []Variable{v}}}}
+		if err := f.Validate(); err != nil {
+			t.Log(err)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(check, quickConfig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeneratedAckFramesRoundTrip(t *testing.T) {
+	check := func(f *AckFrame) bool {
+		raw, err := BuildAck(f)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		parsed, err := ParseAck(raw)
+		if err != nil {
+			t.Logf("%s: %v", raw, err)
+			return false
+		}
+		if path := diffExported(reflect.ValueOf(f), reflect.ValueOf(parsed), "frame"); path != "" {
+			t.Logf("%s: mismatch at %s", raw, path)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(check, quickConfig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	valid := func() *UplinkFrame {
+		return &UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev",
+			PushBody: &PushBody{Structured: &StructuredBody{Variables: []Variable{
+				{Name: "temp", Operator: OperatorString, Value: Value{Type: OperatorString, Str: "ok"}},
+			}}}}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid frame: %v", err)
+	}
+	tests := map[string]func(f *UplinkFrame){
+		"bad auth":        func(f *UplinkFrame) { f.Auth = "at00" },
+		"bad serial":      func(f *UplinkFrame) { f.Serial = "a b" },
+		"uppercase name":  func(f *UplinkFrame) { f.PushBody.Structured.Variables[0].Name = "Temp" },
+		"empty string":    func(f *UplinkFrame) { f.PushBody.Structured.Variables[0].Value.Str = "" },
+		"type mismatch":   func(f *UplinkFrame) { f.PushBody.Structured.Variables[0].Value.Type = OperatorNumber },
+		"empty variables": func(f *UplinkFrame) { f.PushBody.Structured.Variables = nil },
+	}
+	for name, mutate := range tests {
+		f := valid()
+		mutate(f)
+		if err := f.Validate(); err == nil {
+			t.Errorf("%s: Validate accepted the frame", name)
+		}
+	}
+}
+
+// ============================================================================
+// Shrink
+// ============================================================================
+
+func TestShrinkToMinimalFrame(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	var f *UplinkFrame
+	for f == nil || f.Method != MethodPush || f.PushBody.IsPassthrough {
+		f = (*UplinkFrame)(nil).Generate(r, 90).Interface().(*UplinkFrame)
+	}
+	f.Serial = "device_under_test"
+	unit, ts, group := "kWh", "1694567890000", "batch_42"
+	culprit := Variable{
+		Name: "target_sensor", Operator: OperatorString, Value: Value{Type: OperatorString, Str: "BUGgy reading"},
+		Unit: &unit, Timestamp: &ts, Group: &group, Meta: []MetaPair{{Key: "source", Value: "field"}},
+	}
+	vars := f.PushBody.Structured.Variables
+	f.PushBody.Structured.Variables = append(vars[:37:37], append([]Variable{culprit}, vars[37:]...)...)
+	if err := f.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := BuildUplink(f)
+
+	fails := func(f *UplinkFrame) bool {
+		for _, v := range f.PushBody.Structured.Variables {
+			if v.Operator == OperatorString && strings.Contains(v.Value.Str, "BUG") {
+				return true
+			}
+		}
+		return false
+	}
+	got, err := BuildUplink(Shrink(f, fails))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "PUSH|" + f.Auth + "|d|[t=BUG]"; got != want {
+		t.Errorf("Shrink = %s, want %s", got, want)
+	}
+	if after, _ := BuildUplink(f); after != before {
+		t.Error("Shrink modified its input")
+	}
+}
+
+func TestShrinkPull(t *testing.T) {
+	f := &UplinkFrame{Method: MethodPull, Auth: testAuth, Serial: "dev",
+		PullBody: &PullBody{Variables: []string{"alpha", "beta", "humidity", "gamma"}}}
+	got := Shrink(f, func(f *UplinkFrame) bool {
+		for _, name := range f.PullBody.Variables {
+			if strings.HasPrefix(name, "hum") {
+				return true
+			}
+		}
+		return false
+	})
+	if !reflect.DeepEqual(got.PullBody.Variables, []string{"hum"}) {
+		t.Errorf("Shrink = %q, want [hum]", got.PullBody.Variables)
+	}
+}
+
+func TestShrinkPassingFrame(t *testing.T) {
+	f := &UplinkFrame{Method: MethodPing, Auth: testAuth, Serial: "dev"}
+	got := Shrink(f, func(*UplinkFrame) bool { return false })
+	if got == f || !reflect.DeepEqual(got, f) {
+		t.Errorf("Shrink = %+v, want a copy of %+v", got, f)
+	}
+}
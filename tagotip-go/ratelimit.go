@@ -0,0 +1,109 @@
+package tagotip
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Rate limiting
+// ---------------------------------------------------------------------------
+//
+// A FrameMux given a RateLimiter asks it about every frame that parses, and
+// answers the frames it refuses with a rate_limited ERR carrying the number
+// of seconds to wait, before any handler sees them:
+//
+//	ACK|!4|ERR|rate_limited|30
+//
+// TokenBucketLimiter gives each device a bucket of its own.
+
+// RateLimiter decides whether the device with the given serial may send a
+// frame now. When it may not, wait is how long it should hold off. A
+// RateLimiter must be safe for concurrent use.
+type RateLimiter interface {
+	Allow(serial string) (ok bool, wait time.Duration)
+}
+
+// TokenBucketLimiter is a RateLimiter allowing each device burst frames at
+// once, and rate frames per second after that. It is safe for concurrent
+// use.
+type TokenBucketLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	sweepAt int // size of buckets that triggers dropping full ones
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucketLimiter returns a TokenBucketLimiter refilling rate tokens
+// per second into buckets of burst tokens. rate and burst must be positive.
+func NewTokenBucketLimiter(rate float64, burst int) *TokenBucketLimiter {
+	if rate <= 0 || burst <= 0 {
+		panic("tagotip: TokenBucketLimiter rate and burst must be positive")
+	}
+	return &TokenBucketLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		sweepAt: 1024,
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of serial, and when it is empty
+// returns the time until the next token.
+func (l *TokenBucketLimiter) Allow(serial string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b, ok := l.buckets[serial]
+	if !ok {
+		l.sweep(now)
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[serial] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+func (l *TokenBucketLimiter) refill(b *tokenBucket, now time.Time) float64 {
+	return min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+}
+
+// sweep drops the buckets that have refilled, which a new bucket would
+// match, once there are sweepAt of them, so that the devices seen once do
+// not hold memory.
+func (l *TokenBucketLimiter) sweep(now time.Time) {
+	if len(l.buckets) < l.sweepAt {
+		return
+	}
+	for serial, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, serial)
+		}
+	}
+	l.sweepAt = max(1024, 2*len(l.buckets))
+}
+
+// retryAfterSeconds returns wait in whole seconds for a rate_limited ERR,
+// rounded up and at least 1.
+func retryAfterSeconds(wait time.Duration) uint32 {
+	s := math.Ceil(wait.Seconds())
+	if s > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint32(max(s, 1))
+}
@@ -0,0 +1,97 @@
+package tagotip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// Rate limiting
+// ============================================================================
+
+func newTestLimiter(rate float64, burst int) (*TokenBucketLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1_700_000_000, 0)}
+	l := NewTokenBucketLimiter(rate, burst)
+	l.now = clock.Now
+	return l, clock
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	l, clock := newTestLimiter(0.5, 2)
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("frame %d of the burst refused", i+1)
+		}
+	}
+	if ok, wait := l.Allow("a"); ok || wait != 2*time.Second {
+		t.Errorf("after the burst: %v, %v", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("another device refused")
+	}
+	clock.Advance(time.Second)
+	if ok, wait := l.Allow("a"); ok || wait != time.Second {
+		t.Errorf("half a token later: %v, %v", ok, wait)
+	}
+	clock.Advance(time.Second)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("refused after refill")
+	}
+}
+
+func TestTokenBucketLimiterSweeps(t *testing.T) {
+	l, clock := newTestLimiter(1, 1)
+	l.sweepAt = 4
+	for _, serial := range []string{"a", "b", "c", "d"} {
+		l.Allow(serial)
+	}
+	clock.Advance(time.Second)
+	l.Allow("e")
+	if n := len(l.buckets); n != 1 {
+		t.Errorf("%d buckets kept", n)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	for _, tc := range []struct {
+		wait time.Duration
+		want uint32
+	}{
+		{0, 1}, {time.Millisecond, 1}, {time.Second, 1}, {1500 * time.Millisecond, 2}, {time.Duration(1<<63 - 1), 4294967295},
+	} {
+		if got := retryAfterSeconds(tc.wait); got != tc.want {
+			t.Errorf("%v: got %d, want %d", tc.wait, got, tc.want)
+		}
+	}
+}
+
+func TestFrameMuxLimiter(t *testing.T) {
+	l, _ := newTestLimiter(0.1, 1)
+	m := &FrameMux{Limiter: l}
+	calls := 0
+	m.HandlePing(func(context.Context, *UplinkFrame) (*AckFrame, error) {
+		calls++
+		return &AckFrame{Status: AckStatusPong}, nil
+	})
+	client, server := net.Pipe()
+	go m.ServeConn(context.Background(), server)
+	c := NewClient(client, testAuth, "dev", &ClientOptions{Sequencing: true})
+	defer c.Close()
+
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The client reads the delay from the ERR.
+	err := c.Ping(context.Background())
+	var se *ServerError
+	if !errors.As(err, &se) || se.Code != ErrorCodeRateLimited || *se.Ack.Seq != 2 ||
+		se.Ack.Detail.Err == nil || *se.Ack.Detail.Err.RetryAfter != 10 {
+		t.Errorf("got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("handler called %d times", calls)
+	}
+}
@@ -0,0 +1,240 @@
+package tagotip
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ---------------------------------------------------------------------------
+// Redaction and debug output
+// ---------------------------------------------------------------------------
+//
+// Frames carry the device token in the clear, so a frame pasted into a log
+// or a ticket leaks it. RedactFrame masks tokens in raw frames, keeping
+// every other byte and every position in place, and Dump prints a parsed
+// frame for reading with its Auth redacted.
+
+// RedactFrame returns raw with the 32 hex digits of every field shaped like
+// an authorization token replaced by '*'. A field is the text between two
+// '|', or between one and the start or end of a line. The length of raw,
+// and so every position a ParseError reports, is kept.
+func RedactFrame(raw string) string {
+	var b []byte
+	start := 0
+	for i := 0; i <= len(raw); i++ {
+		if i < len(raw) && raw[i] != '|' && raw[i] != '\n' && raw[i] != '\r' {
+			continue
+		}
+		if i-start == AuthTokenLen && validateAuth(raw[start:i], 0) == nil {
+			if b == nil {
+				b = []byte(raw)
+			}
+			for j := start + 2; j < i; j++ {
+				b[j] = '*'
+			}
+		}
+		start = i + 1
+	}
+	if b == nil {
+		return raw
+	}
+	return string(b)
+}
+
+// snippetRadius is the number of bytes of input a ParseError snippet shows
+// on each side of the error position.
+const snippetRadius = 10
+
+// errorSnippet returns the redacted input around pos, with "…" where it is
+// cut, over a line with a caret under pos. Control characters are shown
+// as '.'.
+func errorSnippet(input string, pos int) string {
+	pos = max(0, min(pos, len(input)))
+	start, end := max(0, pos-snippetRadius), min(len(input), pos+snippetRadius)
+	for start > 0 && !utf8.RuneStart(input[start]) {
+		start--
+	}
+	for end < len(input) && !utf8.RuneStart(input[end]) {
+		end++
+	}
+	text := []byte(RedactFrame(input)[start:end])
+	for i, c := range text {
+		if c < 0x20 || c == 0x7f {
+			text[i] = '.'
+		}
+	}
+	var b strings.Builder
+	col := utf8.RuneCount(text[:pos-start])
+	if start > 0 {
+		b.WriteString("…")
+		col++
+	}
+	b.Write(text)
+	if end < len(input) {
+		b.WriteString("…")
+	}
+	b.WriteByte('\n')
+	b.WriteString(strings.Repeat(" ", col))
+	b.WriteByte('^')
+	return b.String()
+}
+
+// redactAuth returns the redacted form of an Auth field, as printed by
+// AuthToken.String. Text that is not a valid token is hidden entirely.
+func redactAuth(auth string) string {
+	if auth == "" {
+		return ""
+	}
+	if tok, err := ParseAuthToken(auth); err == nil {
+		return tok.String()
+	}
+	return "<redacted>"
+}
+
+// Dump returns a multi-line description of f for debugging, one variable
+// per line, with its Auth redacted:
+//
+//	PUSH !42 sensor-01 auth=at0123…cdef
+//	  group: batch
+//	  meta: src="dht", fw="2"
+//	  temp := 21.5 #"C" @1700000000000 {fw="3"}
+//	  note = "hi"
+//	  ok ?= true
+//
+// Strings, units, and metadata values are quoted as Go strings, so that
+// spaces and control characters show. The layout is meant for people and
+// may change; do not parse it.
+func Dump(f *UplinkFrame) string {
+	if f == nil {
+		return "<nil>\n"
+	}
+	var b strings.Builder
+	if f.Method == MethodUnknown && f.RawMethod != "" {
+		b.WriteString(strconv.Quote(f.RawMethod))
+	} else {
+		b.WriteString(f.Method.String())
+	}
+	if f.Seq != nil {
+		b.WriteString(" !")
+		b.WriteString(strconv.FormatUint(uint64(*f.Seq), 10))
+	}
+	b.WriteByte(' ')
+	b.WriteString(f.Serial)
+	b.WriteString(" auth=")
+	b.WriteString(redactAuth(f.Auth))
+	b.WriteByte('\n')
+
+	if len(f.PingDiagnostics) > 0 {
+		b.WriteString("  diagnostics: ")
+		dumpMeta(&b, f.PingDiagnostics)
+		b.WriteByte('\n')
+	}
+	if pb := f.PushBody; pb != nil {
+		if pt := pb.Passthrough; pb.IsPassthrough && pt != nil {
+			b.WriteString("  passthrough: ")
+			b.WriteString(pt.Encoding.String())
+			b.WriteByte(' ')
+			b.WriteString(pt.Data)
+			b.WriteByte('\n')
+		}
+		if sb := pb.Structured; sb != nil {
+			dumpStructured(&b, sb)
+		}
+	}
+	if f.PullBody != nil {
+		b.WriteString("  pull: ")
+		b.WriteString(strings.Join(f.PullBody.Variables, ", "))
+		b.WriteByte('\n')
+	}
+	if f.RawBody != "" {
+		b.WriteString("  body: ")
+		b.WriteString(strconv.Quote(f.RawBody))
+		b.WriteByte('\n')
+	}
+	if f.Signature != "" {
+		b.WriteString("  signature: ")
+		b.WriteString(f.Signature)
+		b.WriteByte('\n')
+	}
+	for _, w := range f.Warnings {
+		b.WriteString("  warning: ")
+		b.WriteString(w.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func dumpStructured(b *strings.Builder, sb *StructuredBody) {
+	if sb.Group != nil {
+		b.WriteString("  group: ")
+		b.WriteString(*sb.Group)
+		b.WriteByte('\n')
+	}
+	if sb.Timestamp != nil {
+		b.WriteString("  timestamp: ")
+		b.WriteString(*sb.Timestamp)
+		b.WriteByte('\n')
+	}
+	if meta := sb.Metadata(); len(meta) > 0 {
+		b.WriteString("  meta: ")
+		dumpMeta(b, meta)
+		b.WriteByte('\n')
+	}
+	for i := range sb.Variables {
+		v := &sb.Variables[i]
+		b.WriteString("  ")
+		b.WriteString(v.Name)
+		b.WriteByte(' ')
+		if op := int(v.Operator); op >= 0 && op < numOperators {
+			b.WriteString(operatorTokens[op])
+		} else {
+			b.WriteString(v.Operator.String())
+		}
+		b.WriteByte(' ')
+		switch v.Operator {
+		case OperatorNumber:
+			b.WriteString(v.Value.Str)
+		case OperatorString:
+			b.WriteString(strconv.Quote(v.Value.Str))
+		case OperatorBoolean:
+			b.WriteString(strconv.FormatBool(v.Value.Bool))
+		case OperatorLocation:
+			if l := v.Value.Location; l != nil {
+				b.WriteString(l.Lat + "," + l.Lng)
+				if l.Alt != nil {
+					b.WriteString("," + *l.Alt)
+				}
+			}
+		}
+		if v.Unit != nil {
+			b.WriteString(" #")
+			b.WriteString(strconv.Quote(*v.Unit))
+		}
+		if v.Timestamp != nil {
+			b.WriteString(" @")
+			b.WriteString(*v.Timestamp)
+		}
+		if v.Group != nil {
+			b.WriteString(" ^")
+			b.WriteString(*v.Group)
+		}
+		if meta := v.Metadata(); len(meta) > 0 {
+			b.WriteString(" {")
+			dumpMeta(b, meta)
+			b.WriteByte('}')
+		}
+		b.WriteByte('\n')
+	}
+}
+
+func dumpMeta(b *strings.Builder, meta []MetaPair) {
+	for i, p := range meta {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(p.Key)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(p.Value))
+	}
+}
@@ -0,0 +1,72 @@
+package tagotip
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Redaction and debug output
+// ============================================================================
+
+func TestRedactFrame(t *testing.T) {
+	masked := "at" + strings.Repeat("*", 32)
+	for _, tc := range [][2]string{
+		{"PUSH|" + testAuth + "|dev|[a:=1]", "PUSH|" + masked + "|dev|[a:=1]"},
+		{"PUSH|!5|" + testAuth + "|Bad Serial|[a:=1]", "PUSH|!5|" + masked + "|Bad Serial|[a:=1]"},
+		{"PING|" + testAuth, "PING|" + masked},
+		{testAuth + "|x\n" + "PULL|" + strings.ToUpper(testAuth[2:]) + "|" + "at" + strings.ToUpper(testAuth[2:]) + "\r\n",
+			masked + "|x\n" + "PULL|" + strings.ToUpper(testAuth[2:]) + "|" + masked + "\r\n"},
+		// Not token-shaped: too short, too long, not hex, or inside a field.
+		{"PUSH|at0123|dev", "PUSH|at0123|dev"},
+		{"PUSH|" + testAuth + "0|dev", "PUSH|" + testAuth + "0|dev"},
+		{"PUSH|at0123456789abcdef0123456789abcdeg|dev", "PUSH|at0123456789abcdef0123456789abcdeg|dev"},
+		{"PUSH|x" + testAuth + "|dev", "PUSH|x" + testAuth + "|dev"},
+		{"", ""},
+	} {
+		if got := RedactFrame(tc[0]); got != tc[1] {
+			t.Errorf("RedactFrame(%q)\n got  %q\n want %q", tc[0], got, tc[1])
+		}
+	}
+}
+
+func TestRedactFrameKeepsPositions(t *testing.T) {
+	raw := "PUSH|" + testAuth + "|bad serial|[a:=1]"
+	_, err := ParseUplink(raw)
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("got %v", err)
+	}
+	red := RedactFrame(raw)
+	if len(red) != len(raw) || red[pe.Position:] != raw[pe.Position:] || strings.Contains(red, testAuth) {
+		t.Errorf("redacted as %q", red)
+	}
+}
+
+func TestDump(t *testing.T) {
+	f := mustParse(t, "PUSH|!42|"+testAuth+"|sensor-01|^batch{src=dht,fw=2}"+
+		"[temp:=21.5#C@1700000000000{fw=3};note=a b;ok?=true;pos@=1,2,3]")
+	want := "PUSH !42 sensor-01 auth=at0123…cdef\n" +
+		"  group: batch\n" +
+		"  meta: src=\"dht\", fw=\"2\"\n" +
+		"  temp := 21.5 #\"C\" @1700000000000 {fw=\"3\"}\n" +
+		"  note = \"a b\"\n" +
+		"  ok ?= true\n" +
+		"  pos @= 1,2,3\n"
+	if got := Dump(f); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	f = mustParse(t, "PULL|"+testAuth+"|dev|[a;b]")
+	if got := Dump(f); got != "PULL dev auth=at0123…cdef\n  pull: a, b\n" {
+		t.Errorf("PULL: %q", got)
+	}
+	f.Auth = "not-a-token-but-secret"
+	if got := Dump(f); strings.Contains(got, "secret") {
+		t.Errorf("invalid Auth printed: %q", got)
+	}
+	if Dump(nil) != "<nil>\n" {
+		t.Error("nil frame")
+	}
+}
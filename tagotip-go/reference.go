@@ -0,0 +1,252 @@
+package tagotip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// ---------------------------------------------------------------------------
+// Differential testing against recorded reference outputs
+// ---------------------------------------------------------------------------
+//
+// A reference file records how one version of this package parsed a list of
+// frames, so that a later version can be checked against it:
+//
+//	{
+//	  "version": 1,
+//	  "entries": [
+//	    {"frame": "PUSH|...", "parsed": {...}},
+//	    {"frame": "ACK|OK|3", "parsed": {...}},
+//	    {"frame": "PUSH|x", "error": {"kind": "invalid_auth", "position": 5}}
+//	  ]
+//	}
+//
+// Frames whose first field is ACK are parsed with ParseAck, all others with
+// ParseUplink. Parsed frames use the structures of the golden corpus (see
+// VerifyCorpus), and errors are recorded by kind and position. Entries are
+// written one per line so that changes to a checked-in reference read well
+// in a diff.
+
+// ReferenceVersion is the reference file format version written by
+// RecordReference and understood by CompareAgainstReference.
+const ReferenceVersion = 1
+
+// Mismatch is a difference between a recorded reference and the current
+// parse of a frame. Path locates the differing field within the parsed
+// result, such as "parsed.push.variables[2].unit" or "error.position"; Want
+// and Got are the JSON values at Path, or empty when the field is absent.
+type Mismatch struct {
+	Index int
+	Frame string
+	Path  string
+	Want  string
+	Got   string
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("frame %d: %s: want %s, got %s", m.Index, m.Path, orAbsent(m.Want), orAbsent(m.Got))
+}
+
+func orAbsent(s string) string {
+	if s == "" {
+		return "(absent)"
+	}
+	return s
+}
+
+type referenceFile struct {
+	Version int              `json:"version"`
+	Entries []referenceEntry `json:"entries"`
+}
+
+type referenceEntry struct {
+	Frame  string          `json:"frame"`
+	Parsed json.RawMessage `json:"parsed,omitempty"`
+	Error  *corpusError    `json:"error,omitempty"`
+}
+
+// RecordReference parses each frame and writes the results to w as a
+// reference file.
+func RecordReference(frames []string, w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "{\n  \"version\": %d,\n  \"entries\": [", ReferenceVersion)
+	for i, frame := range frames {
+		line, err := marshalReference(referenceFor(frame))
+		if err != nil {
+			return fmt.Errorf("tagotip: record frame %d: %w", i, err)
+		}
+		if i > 0 {
+			bw.WriteByte(',')
+		}
+		bw.WriteString("\n    ")
+		bw.Write(line)
+	}
+	bw.WriteString("\n  ]\n}\n")
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("tagotip: write reference: %w", err)
+	}
+	return nil
+}
+
+// CompareAgainstReference parses each frame and compares the result with
+// the reference read from r. The error reports only problems reading or
+// decoding the reference, including a frame list that does not match it;
+// differences in the parse results are returned as mismatches, in frame
+// order.
+func CompareAgainstReference(frames []string, r io.Reader) ([]Mismatch, error) {
+	var file referenceFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, fmt.Errorf("tagotip: decode reference: %w", err)
+	}
+	if file.Version != ReferenceVersion {
+		return nil, fmt.Errorf("tagotip: unsupported reference version %d", file.Version)
+	}
+	if len(file.Entries) != len(frames) {
+		return nil, fmt.Errorf("tagotip: reference has %d frames, got %d", len(file.Entries), len(frames))
+	}
+
+	var mismatches []Mismatch
+	for i, frame := range frames {
+		want := &file.Entries[i]
+		if want.Frame != frame {
+			return nil, fmt.Errorf("tagotip: frame %d differs from the reference", i)
+		}
+		wantTree, err := referenceTree(want)
+		if err != nil {
+			return nil, fmt.Errorf("tagotip: decode reference frame %d: %w", i, err)
+		}
+		gotTree, _ := referenceTree(referenceFor(frame))
+		diffJSON(wantTree, gotTree, "", func(path string, w, g any) {
+			mismatches = append(mismatches, Mismatch{
+				Index: i,
+				Frame: frame,
+				Path:  path,
+				Want:  jsonString(w),
+				Got:   jsonString(g),
+			})
+		})
+	}
+	return mismatches, nil
+}
+
+func referenceFor(frame string) *referenceEntry {
+	e := &referenceEntry{Frame: frame}
+	var parsed any
+	var err error
+	if isAckFrame(frame) {
+		var f *AckFrame
+		if f, err = ParseAck(frame); err == nil {
+			parsed = corpusFromAck(f)
+		}
+	} else {
+		var f *UplinkFrame
+		if f, err = ParseUplink(frame); err == nil {
+			parsed = corpusFromUplink(f)
+		}
+	}
+	if err != nil {
+		if e.Error = corpusFromError(err); e.Error == nil {
+			e.Error = &corpusError{Kind: "other", Message: err.Error()}
+		}
+		return e
+	}
+	e.Parsed, _ = marshalReference(parsed)
+	return e
+}
+
+// marshalReference is json.Marshal without HTML escaping, which would turn
+// the '>' of passthrough frames into \u003e.
+func marshalReference(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// referenceTree decodes the result recorded in e into generic JSON values,
+// keyed "parsed" or "error".
+func referenceTree(e *referenceEntry) (map[string]any, error) {
+	tree := make(map[string]any, 1)
+	if e.Error != nil {
+		raw, _ := json.Marshal(e.Error)
+		var v any
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		tree["error"] = v
+	}
+	if e.Parsed != nil {
+		var v any
+		if err := json.Unmarshal(e.Parsed, &v); err != nil {
+			return nil, err
+		}
+		tree["parsed"] = v
+	}
+	return tree, nil
+}
+
+// diffJSON walks two decoded JSON values and calls report for each path at
+// which they differ. A value absent on one side is passed as nil.
+func diffJSON(want, got any, path string, report func(path string, want, got any)) {
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, ok := w[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			diffJSON(w[k], g[k], p, report)
+		}
+		return
+	case []any:
+		g, ok := got.([]any)
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(w), len(g)); i++ {
+			var wi, gi any
+			if i < len(w) {
+				wi = w[i]
+			}
+			if i < len(g) {
+				gi = g[i]
+			}
+			diffJSON(wi, gi, path+"["+strconv.Itoa(i)+"]", report)
+		}
+		return
+	}
+	if jsonString(want) != jsonString(got) {
+		report(path, want, got)
+	}
+}
+
+// jsonString renders a decoded JSON value, or "" for an absent one.
+func jsonString(v any) string {
+	if v == nil {
+		return ""
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
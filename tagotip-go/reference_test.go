@@ -0,0 +1,136 @@
+package tagotip
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Differential reference
+// ============================================================================
+
+var updateReference = flag.Bool("update-reference", false, "rewrite testdata/reference.json from the current parser")
+
+const referencePath = "testdata/reference.json"
+
+// referenceFrames returns the frames checked against testdata/reference.json:
+// the uplink and ACK inputs of the golden corpus and the spec examples.
+func referenceFrames(t *testing.T) []string {
+	t.Helper()
+	data, err := os.ReadFile("testdata/golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var corpus corpusFile
+	if err := json.Unmarshal(data, &corpus); err != nil {
+		t.Fatal(err)
+	}
+	var frames []string
+	for _, e := range corpus.Entries {
+		if e.Direction != "envelope" {
+			frames = append(frames, e.Input)
+		}
+	}
+	for _, ex := range Spec11Examples() {
+		frames = append(frames, ex.Raw)
+	}
+	return frames
+}
+
+// TestReferenceUnchanged fails when a change to the parser alters the result
+// of any recorded frame. Run with -update-reference to accept the change.
+func TestReferenceUnchanged(t *testing.T) {
+	frames := referenceFrames(t)
+	if *updateReference {
+		var buf bytes.Buffer
+		if err := RecordReference(frames, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(referencePath, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(referencePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	mismatches, err := CompareAgainstReference(frames, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range mismatches {
+		t.Error(m)
+	}
+}
+
+func TestCompareAgainstPerturbedReference(t *testing.T) {
+	frames := []string{
+		"PUSH|" + testAuth + "|dev|[temp:=21.5#C;hum:=40]",
+		"PULL|" + testAuth + "|dev|[temp]",
+		"PUSH|" + testAuth + "|dev|[temp:=01]",
+		"ACK|OK|3",
+	}
+	var buf bytes.Buffer
+	if err := RecordReference(frames, &buf); err != nil {
+		t.Fatal(err)
+	}
+	clean := buf.String()
+	if m, err := CompareAgainstReference(frames, strings.NewReader(clean)); err != nil || len(m) != 0 {
+		t.Fatalf("unperturbed reference: %v, %v", m, err)
+	}
+
+	perturbed := clean
+	for _, r := range [][2]string{
+		{`"value":"21.5","unit":"C"`, `"value":"21.50"`},                                       // value changed, unit dropped
+		{`"pull":["temp"]`, `"pull":["temp","hum"]`},                                           // extra element
+		{`"kind":"invalid_variable","position":51`, `"kind":"invalid_variable","position":50`}, // error moved
+		{`"count":3`, `"count":3,"text":"three"`},                                              // extra field
+	} {
+		if !strings.Contains(perturbed, r[0]) {
+			t.Fatalf("reference does not contain %s:\n%s", r[0], clean)
+		}
+		perturbed = strings.Replace(perturbed, r[0], r[1], 1)
+	}
+
+	got, err := CompareAgainstReference(frames, strings.NewReader(perturbed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mismatch{
+		{Index: 0, Frame: frames[0], Path: "parsed.push.variables[0].unit", Want: "", Got: `"C"`},
+		{Index: 0, Frame: frames[0], Path: "parsed.push.variables[0].value", Want: `"21.50"`, Got: `"21.5"`},
+		{Index: 1, Frame: frames[1], Path: "parsed.pull[1]", Want: `"hum"`, Got: ""},
+		{Index: 2, Frame: frames[2], Path: "error.position", Want: "50", Got: "51"},
+		{Index: 3, Frame: frames[3], Path: "parsed.detail.text", Want: `"three"`, Got: ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mismatches:\n  got  %v\n  want %v", got, want)
+	}
+}
+
+func TestCompareAgainstReferenceErrors(t *testing.T) {
+	frames := []string{"ACK|OK|3"}
+	var buf bytes.Buffer
+	if err := RecordReference(frames, &buf); err != nil {
+		t.Fatal(err)
+	}
+	for name, tc := range map[string]struct {
+		frames []string
+		ref    string
+	}{
+		"not json":      {frames, "{"},
+		"wrong version": {frames, strings.Replace(buf.String(), `"version": 1`, `"version": 2`, 1)},
+		"frame count":   {append(frames, "ACK|PONG"), buf.String()},
+		"frame differs": {[]string{"ACK|OK|4"}, buf.String()},
+	} {
+		if _, err := CompareAgainstReference(tc.frames, strings.NewReader(tc.ref)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
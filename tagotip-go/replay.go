@@ -0,0 +1,167 @@
+package tagotip
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// Replay protection
+// ---------------------------------------------------------------------------
+//
+// OpenEnvelope proves that an envelope came from the device, not that it
+// is new. ReplayWindow tracks, per device hash, the latest counter
+// accepted and which of the counters just below it have been seen, in the
+// manner of IPsec's anti-replay window: newer counters are accepted, as
+// are unseen counters within the window, to tolerate reordering on the
+// way.
+
+// DefaultReplayWindowSize is the window size NewReplayWindow uses for a
+// size of 0.
+const DefaultReplayWindowSize = 64
+
+// ErrReplayDuplicate and ErrReplayStale are the causes of a ReplayError:
+// the counter was already accepted, or it is too far behind the latest to
+// tell.
+var (
+	ErrReplayDuplicate = errors.New("tagotips: envelope counter already seen")
+	ErrReplayStale     = errors.New("tagotips: envelope counter outside the replay window")
+)
+
+// ReplayError is returned by ReplayWindow.Check for a rejected counter. It
+// unwraps to ErrReplayDuplicate or ErrReplayStale.
+type ReplayError struct {
+	DeviceHash [deviceHashSize]byte
+	Counter    uint32
+	Latest     uint32 // latest counter accepted for the device
+	Err        error
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("%v: counter %d, latest %d", e.Err, e.Counter, e.Latest)
+}
+
+func (e *ReplayError) Unwrap() error { return e.Err }
+
+// ReplayState is the state a ReplayWindow keeps for one device, as
+// exported by Export.
+type ReplayState struct {
+	DeviceHash [deviceHashSize]byte
+	Latest     uint32
+	// Seen holds one bit per counter of the window, lowest word first:
+	// bit i is set when counter Latest-i has been accepted.
+	Seen []uint64
+}
+
+// ReplayWindow rejects replayed envelope counters. It is safe for
+// concurrent use. The zero value is not usable; create one with
+// NewReplayWindow.
+type ReplayWindow struct {
+	size int
+
+	mu      sync.Mutex
+	devices map[[deviceHashSize]byte]*replayState
+}
+
+type replayState struct {
+	latest uint32
+	seen   []uint64
+}
+
+// NewReplayWindow returns a ReplayWindow that accepts counters up to size-1
+// behind the latest one. A size of 0 selects DefaultReplayWindowSize.
+func NewReplayWindow(size int) *ReplayWindow {
+	if size <= 0 {
+		size = DefaultReplayWindowSize
+	}
+	return &ReplayWindow{size: size, devices: make(map[[deviceHashSize]byte]*replayState)}
+}
+
+// Check accepts counter from the device, recording it, or returns a
+// *ReplayError when it must be rejected. Check only after the envelope has
+// been authenticated, so that forged envelopes cannot advance the window.
+func (w *ReplayWindow) Check(deviceHash [deviceHashSize]byte, counter uint32) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := w.devices[deviceHash]
+	if st == nil {
+		st = &replayState{latest: counter, seen: make([]uint64, w.words())}
+		st.seen[0] = 1
+		w.devices[deviceHash] = st
+		return nil
+	}
+	if counter > st.latest {
+		shiftSeen(st.seen, uint64(counter-st.latest))
+		st.seen[0] |= 1
+		st.latest = counter
+		return nil
+	}
+	back := uint64(st.latest - counter)
+	if back >= uint64(w.size) {
+		return &ReplayError{DeviceHash: deviceHash, Counter: counter, Latest: st.latest, Err: ErrReplayStale}
+	}
+	word, bit := back/64, uint64(1)<<(back%64)
+	if st.seen[word]&bit != 0 {
+		return &ReplayError{DeviceHash: deviceHash, Counter: counter, Latest: st.latest, Err: ErrReplayDuplicate}
+	}
+	st.seen[word] |= bit
+	return nil
+}
+
+// Export returns the state of every device, ordered by device hash, to be
+// restored with Import after a restart.
+func (w *ReplayWindow) Export() []ReplayState {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	states := make([]ReplayState, 0, len(w.devices))
+	for hash, st := range w.devices {
+		states = append(states, ReplayState{DeviceHash: hash, Latest: st.latest, Seen: append([]uint64(nil), st.seen...)})
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return bytes.Compare(states[i].DeviceHash[:], states[j].DeviceHash[:]) < 0
+	})
+	return states
+}
+
+// Import replaces the state of the devices in states. It fails, changing
+// nothing, when a state was exported from a window of another size.
+func (w *ReplayWindow) Import(states []ReplayState) error {
+	for _, s := range states {
+		if len(s.Seen) != w.words() {
+			return fmt.Errorf("tagotips: replay state of %d words, window needs %d", len(s.Seen), w.words())
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, s := range states {
+		w.devices[s.DeviceHash] = &replayState{latest: s.Latest, seen: append([]uint64(nil), s.Seen...)}
+	}
+	return nil
+}
+
+func (w *ReplayWindow) words() int {
+	return (w.size + 63) / 64
+}
+
+// shiftSeen moves every bit of seen n places up, as the latest counter
+// advances by n, dropping the bits that leave the window.
+func shiftSeen(seen []uint64, n uint64) {
+	if n >= uint64(len(seen))*64 {
+		clear(seen)
+		return
+	}
+	words, bits := int(n/64), n%64
+	for i := len(seen) - 1; i >= 0; i-- {
+		var v uint64
+		if j := i - words; j >= 0 {
+			v = seen[j] << bits
+			if bits != 0 && j > 0 {
+				v |= seen[j-1] >> (64 - bits)
+			}
+		}
+		seen[i] = v
+	}
+}
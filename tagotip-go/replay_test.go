@@ -0,0 +1,126 @@
+package tagotip
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// ============================================================================
+// Replay protection
+// ============================================================================
+
+func TestReplayWindow(t *testing.T) {
+	w := NewReplayWindow(0)
+	dev := specDeviceHash
+	for _, tc := range []struct {
+		counter uint32
+		want    error
+	}{
+		{100, nil},
+		{101, nil},
+		{101, ErrReplayDuplicate},
+		{99, nil}, // late, within the window
+		{99, ErrReplayDuplicate},
+		{100, ErrReplayDuplicate},
+		{101 - 63, nil},
+		{101 - 64, ErrReplayStale},
+		{200, nil},
+		{137, nil}, // 200-63
+		{136, ErrReplayStale},
+		{101, ErrReplayStale},
+		{10_000, nil},
+		{200, ErrReplayStale},
+	} {
+		err := w.Check(dev, tc.counter)
+		if !errors.Is(err, tc.want) || (tc.want == nil) != (err == nil) {
+			t.Fatalf("counter %d: got %v, want %v", tc.counter, err, tc.want)
+		}
+		var re *ReplayError
+		if err != nil && (!errors.As(err, &re) || re.Counter != tc.counter || re.DeviceHash != dev) {
+			t.Errorf("counter %d: %#v", tc.counter, err)
+		}
+	}
+
+	// Devices are tracked apart.
+	if err := w.Check([8]byte{1}, 5); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayWindowMultiWord(t *testing.T) {
+	w := NewReplayWindow(200)
+	dev := specDeviceHash
+	for c := uint32(1); c <= 300; c += 3 {
+		if err := w.Check(dev, c); err != nil {
+			t.Fatalf("%d: %v", c, err)
+		}
+	}
+	// Latest is 298; 99..298 are in the window, and the loop fills it.
+	for c := uint32(99); c <= 298; c++ {
+		err := w.Check(dev, c)
+		if (c%3 == 1) != errors.Is(err, ErrReplayDuplicate) {
+			t.Fatalf("%d: %v", c, err)
+		}
+	}
+	if err := w.Check(dev, 98); !errors.Is(err, ErrReplayStale) {
+		t.Errorf("98: %v", err)
+	}
+	if err := w.Check(dev, 298+70); err != nil {
+		t.Fatal(err)
+	}
+	// Now 169..368 are in the window, with 299..367 unseen.
+	for c, want := range map[uint32]error{298: ErrReplayDuplicate, 169: ErrReplayDuplicate, 168: ErrReplayStale, 299: nil, 367: nil} {
+		if err := w.Check(dev, c); !errors.Is(err, want) || (want == nil) != (err == nil) {
+			t.Errorf("%d after shift: %v", c, err)
+		}
+	}
+}
+
+func TestReplayWindowExportImport(t *testing.T) {
+	w := NewReplayWindow(64)
+	for _, c := range []uint32{10, 12, 11} {
+		w.Check(specDeviceHash, c)
+	}
+	w.Check([8]byte{1}, 3)
+	states := w.Export()
+	if len(states) != 2 || states[0].DeviceHash != ([8]byte{1}) || states[1].Latest != 12 {
+		t.Fatalf("exported %+v", states)
+	}
+
+	restored := NewReplayWindow(64)
+	if err := restored.Import(states); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []uint32{10, 11, 12} {
+		if err := restored.Check(specDeviceHash, c); !errors.Is(err, ErrReplayDuplicate) {
+			t.Errorf("%d: %v", c, err)
+		}
+	}
+	if err := restored.Check(specDeviceHash, 9); err != nil {
+		t.Error(err)
+	}
+	if err := NewReplayWindow(128).Import(states); err == nil {
+		t.Error("imported state of another size")
+	}
+}
+
+func TestReplayWindowConcurrent(t *testing.T) {
+	w := NewReplayWindow(1024)
+	var accepted sync.Map
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := uint32(1); c <= 500; c++ {
+				if w.Check(specDeviceHash, c) == nil {
+					if _, dup := accepted.LoadOrStore(c, true); dup {
+						t.Errorf("counter %d accepted twice", c)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
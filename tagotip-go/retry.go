@@ -0,0 +1,128 @@
+package tagotip
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Retry policy
+// ---------------------------------------------------------------------------
+//
+// Some ERR replies say the frame may succeed later: rate_limited asks the
+// device to slow down and server_error reports a passing fault. RetryPolicy
+// resends such frames after a growing, jittered delay, or after the delay a
+// rate_limited reply asks for, and gives up at once on any other error.
+
+// RetryPolicy configures how Client.Push and Client.Pull retry frames the
+// server answered with a retryable error code. Zero fields take the
+// defaults given with them.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times a frame is sent in all, counting
+	// the first. Zero means 3.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry, multiplied by
+	// Multiplier for each later one up to MaxBackoff. Zero means one
+	// second, 30 seconds, and 2.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+
+	// Jitter spreads each delay at random by up to this fraction of it, so
+	// that devices rejected together do not retry together. Zero means
+	// 0.2; negative means none.
+	Jitter float64
+
+	// RetryableCodes lists the error codes worth retrying. Nil means
+	// ErrorCodeRateLimited and ErrorCodeServerError.
+	RetryableCodes []ErrorCode
+
+	// OnAttempt, if not nil, is called after every attempt, as for
+	// metrics.
+	OnAttempt func(RetryAttempt)
+}
+
+// RetryAttempt describes one attempt of a call under a RetryPolicy.
+type RetryAttempt struct {
+	Attempt int   // 1 for the first send
+	Err     error // the attempt's error, nil when it succeeded
+	// Backoff is the delay before the next attempt, or 0 when there is
+	// none.
+	Backoff time.Duration
+}
+
+var defaultRetryableCodes = []ErrorCode{ErrorCodeRateLimited, ErrorCodeServerError}
+
+// do calls attempt until it succeeds, fails with an error that is not
+// retryable, or has been called MaxAttempts times.
+func (p *RetryPolicy) do(ctx context.Context, attempt func() (*AckFrame, error)) (*AckFrame, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	for n := 1; ; n++ {
+		ack, err := attempt()
+		var wait time.Duration
+		var se *ServerError
+		retry := n < maxAttempts && errors.As(err, &se) && p.retryable(se.Code)
+		if retry {
+			wait = p.backoff(n, se.Ack)
+		}
+		if p.OnAttempt != nil {
+			p.OnAttempt(RetryAttempt{Attempt: n, Err: err, Backoff: wait})
+		}
+		if !retry {
+			return ack, err
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (p *RetryPolicy) retryable(code ErrorCode) bool {
+	codes := p.RetryableCodes
+	if codes == nil {
+		codes = defaultRetryableCodes
+	}
+	return slices.Contains(codes, code)
+}
+
+// backoff returns the delay after the n-th attempt was answered with ack:
+// the delay a rate_limited ack asks for, or else the n-th step of the
+// exponential backoff, jittered.
+func (p *RetryPolicy) backoff(n int, ack *AckFrame) time.Duration {
+	if d := ack.Detail; d != nil && d.Err != nil && d.Err.RetryAfter != nil {
+		return time.Duration(*d.Err.RetryAfter) * time.Second
+	}
+	initial, limit, mult, jitter := p.InitialBackoff, p.MaxBackoff, p.Multiplier, p.Jitter
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if limit <= 0 {
+		limit = 30 * time.Second
+	}
+	if mult <= 0 {
+		mult = 2
+	}
+	if jitter == 0 {
+		jitter = 0.2
+	}
+	d := float64(initial)
+	for i := 1; i < n && d < float64(limit); i++ {
+		d *= mult
+	}
+	d = min(d, float64(limit))
+	if jitter > 0 {
+		d += d * jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
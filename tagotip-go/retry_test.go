@@ -0,0 +1,111 @@
+package tagotip
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// Retry policy
+// ============================================================================
+
+// scriptedServer answers successive frames with the given ACKs, repeating
+// the last one.
+func scriptedServer(acks ...string) func(*UplinkFrame) string {
+	n := 0
+	return func(*UplinkFrame) string {
+		ack := acks[min(n, len(acks)-1)]
+		n++
+		return ack
+	}
+}
+
+func TestClientRetriesRetryableCodes(t *testing.T) {
+	var attempts []RetryAttempt
+	policy := &RetryPolicy{
+		InitialBackoff: time.Millisecond,
+		MaxAttempts:    4,
+		OnAttempt:      func(a RetryAttempt) { attempts = append(attempts, a) },
+	}
+	c := pipeClient(t, &ClientOptions{Retry: policy},
+		scriptedServer("ACK|ERR|rate_limited", "ACK|ERR|server_error", "ACK|OK|1"))
+	ack, err := c.Pull(context.Background(), "temp")
+	if err != nil || ack.Detail.Count != 1 {
+		t.Fatalf("got %+v, %v", ack, err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("%d attempts", len(attempts))
+	}
+	for i, a := range attempts[:2] {
+		var se *ServerError
+		if a.Attempt != i+1 || !errors.As(a.Err, &se) || a.Backoff <= 0 {
+			t.Errorf("attempt %+v", a)
+		}
+	}
+	if last := attempts[2]; last.Err != nil || last.Backoff != 0 {
+		t.Errorf("last attempt %+v", last)
+	}
+}
+
+func TestClientRetryGivesUp(t *testing.T) {
+	n := 0
+	policy := &RetryPolicy{InitialBackoff: time.Millisecond, MaxAttempts: 2, OnAttempt: func(RetryAttempt) { n++ }}
+	c := pipeClient(t, &ClientOptions{Retry: policy}, scriptedServer("ACK|ERR|server_error"))
+	_, err := c.Pull(context.Background(), "temp")
+	var se *ServerError
+	if !errors.As(err, &se) || se.Code != ErrorCodeServerError || n != 2 {
+		t.Errorf("after %d attempts: %v", n, err)
+	}
+}
+
+func TestClientRetryStopsOnOtherCodes(t *testing.T) {
+	for _, ack := range []string{"ACK|ERR|auth_failed", "ACK|ERR|invalid_payload"} {
+		n := 0
+		policy := &RetryPolicy{InitialBackoff: time.Millisecond, OnAttempt: func(RetryAttempt) { n++ }}
+		c := pipeClient(t, &ClientOptions{Retry: policy}, scriptedServer(ack, "ACK|OK|1"))
+		_, err := c.Pull(context.Background(), "temp")
+		var se *ServerError
+		if !errors.As(err, &se) || se.Ack.Detail.Text != ack[8:] || n != 1 {
+			t.Errorf("%s: %d attempts, %v", ack, n, err)
+		}
+	}
+
+	// RetryableCodes replaces the default set.
+	policy := &RetryPolicy{InitialBackoff: time.Millisecond, RetryableCodes: []ErrorCode{ErrorCodeDeviceNotFound}}
+	c := pipeClient(t, &ClientOptions{Retry: policy}, scriptedServer("ACK|ERR|device_not_found", "ACK|OK|1"))
+	if _, err := c.Pull(context.Background(), "temp"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientRetryHonoursContext(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoff: time.Hour}
+	c := pipeClient(t, &ClientOptions{Retry: policy}, scriptedServer("ACK|ERR|server_error"))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.Pull(ctx, "temp"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := &RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 3, Jitter: -1}
+	plain := &AckFrame{Status: AckStatusErr, Detail: &AckDetail{Type: "error", ErrorCode: ErrorCodeServerError}}
+	for n, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 300 * time.Millisecond, 3: 900 * time.Millisecond, 4: time.Second, 40: time.Second} {
+		if got := p.backoff(n, plain); got != want {
+			t.Errorf("attempt %d: %v, want %v", n, got, want)
+		}
+	}
+	if got := p.backoff(1, NewAckErrRateLimited(nil, 7)); got != 7*time.Second {
+		t.Errorf("retry-after: %v", got)
+	}
+
+	jittered := &RetryPolicy{InitialBackoff: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if d := jittered.backoff(1, plain); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("jittered backoff %v", d)
+		}
+	}
+}
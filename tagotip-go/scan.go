@@ -0,0 +1,46 @@
+package tagotip
+
+import "bytes"
+
+// ---------------------------------------------------------------------------
+// Frame scanning
+// ---------------------------------------------------------------------------
+//
+// Over a stream transport such as TCP every frame ends with '\n'. A
+// backslash escapes the byte after it, as splitFields reads it, so a
+// newline after an odd run of backslashes belongs to the frame rather than
+// ending it.
+
+// ScanFrames is a bufio.SplitFunc that splits a stream into the frames
+// ending at each unescaped newline. The token is the frame without its
+// newline; an empty line yields an empty token. A frame left at the end of
+// the stream without a newline is returned as the last token.
+//
+// A frame longer than MaxFrameSize fails with a *FrameSizeError, rather
+// than bufio.ErrTooLong, as soon as the bytes read show it too long. Its
+// Size is then the frame's length when its end has been read, and
+// otherwise the number of bytes read of it so far.
+func ScanFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	for i := 0; i < len(data); {
+		j := bytes.IndexAny(data[i:], "\n\\")
+		if j < 0 {
+			break
+		}
+		i += j
+		if data[i] == '\\' {
+			i += 2
+			continue
+		}
+		if i > MaxFrameSize {
+			return 0, nil, &FrameSizeError{Size: i, Limit: MaxFrameSize}
+		}
+		return i + 1, data[:i], nil
+	}
+	if len(data) > MaxFrameSize {
+		return 0, nil, &FrameSizeError{Size: len(data), Limit: MaxFrameSize}
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
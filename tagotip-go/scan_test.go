@@ -0,0 +1,108 @@
+package tagotip
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// ============================================================================
+// Frame scanning
+// ============================================================================
+
+func scanAll(t *testing.T, sc *bufio.Scanner) ([]string, error) {
+	t.Helper()
+	sc.Split(ScanFrames)
+	var frames []string
+	for sc.Scan() {
+		frames = append(frames, sc.Text())
+	}
+	return frames, sc.Err()
+}
+
+func TestScanFrames(t *testing.T) {
+	const escaped = `PUSH|` + testAuth + `|sensor-01|[note=line1\nline2;status=a\\]`
+	input := escaped + "\n" +
+		"PING|" + testAuth + "|sensor-01\n" +
+		"\n" +
+		`PUSH|` + testAuth + `|sensor-01|[note=ends\` + "\n" + `still the same frame]` + "\n" +
+		"PULL|" + testAuth + "|sensor-01|[temp]" // no newline at the end
+	want := []string{
+		escaped,
+		"PING|" + testAuth + "|sensor-01",
+		"",
+		`PUSH|` + testAuth + `|sensor-01|[note=ends\` + "\n" + `still the same frame]`,
+		"PULL|" + testAuth + "|sensor-01|[temp]",
+	}
+
+	readers := map[string]func() *bufio.Scanner{
+		"all at once": func() *bufio.Scanner { return bufio.NewScanner(strings.NewReader(input)) },
+		"byte by byte": func() *bufio.Scanner {
+			return bufio.NewScanner(iotest.OneByteReader(strings.NewReader(input)))
+		},
+	}
+	for name, newScanner := range readers {
+		got, err := scanAll(t, newScanner())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+			t.Errorf("%s: got %q", name, got)
+		}
+	}
+
+	// The escaped newline stays inside the string value.
+	f, err := ParseUplink(want[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := f.PushBody.Structured.Variables[0]; v.Value.Str != "line1\nline2" {
+		t.Errorf("note = %q", v.Value.Str)
+	}
+}
+
+func TestScanFramesSplitAcrossReads(t *testing.T) {
+	// A trailing backslash must not be decided on until the next byte is in.
+	adv, tok, err := ScanFrames([]byte(`PING|x\`), false)
+	if adv != 0 || tok != nil || err != nil {
+		t.Fatalf("partial frame: %d %q %v", adv, tok, err)
+	}
+	adv, tok, err = ScanFrames([]byte("PING|x\\\n|y\nnext"), false)
+	if adv != 11 || string(tok) != "PING|x\\\n|y" || err != nil {
+		t.Errorf("completed frame: %d %q %v", adv, tok, err)
+	}
+	adv, tok, err = ScanFrames(nil, true)
+	if adv != 0 || tok != nil || err != nil {
+		t.Errorf("end of stream: %d %q %v", adv, tok, err)
+	}
+}
+
+func TestScanFramesTooLarge(t *testing.T) {
+	fits := strings.Repeat("a", MaxFrameSize)
+	got, err := scanAll(t, bufio.NewScanner(strings.NewReader(fits+"\n")))
+	if err != nil || len(got) != 1 || len(got[0]) != MaxFrameSize {
+		t.Fatalf("frame of MaxFrameSize: %d frames, %v", len(got), err)
+	}
+
+	long := strings.Repeat("a", MaxFrameSize+10)
+	for name, input := range map[string]string{
+		"terminated":   long + "\nPING\n",
+		"unterminated": long,
+	} {
+		sc := bufio.NewScanner(iotest.HalfReader(strings.NewReader(input)))
+		got, err := scanAll(t, sc)
+		var fse *FrameSizeError
+		if len(got) != 0 || !errors.As(err, &fse) || fse.Limit != MaxFrameSize || fse.Size <= MaxFrameSize {
+			t.Errorf("%s: %d frames, %v", name, len(got), err)
+		}
+	}
+
+	// The exact size is known once the newline has been read.
+	_, _, err = ScanFrames([]byte(long+"\n"), false)
+	var fse *FrameSizeError
+	if !errors.As(err, &fse) || fse.Size != len(long) {
+		t.Errorf("got %v", err)
+	}
+}
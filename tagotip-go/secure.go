@@ -2,12 +2,14 @@ package tagotip
 
 import (
 	"crypto/aes"
+	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 )
 
@@ -20,6 +22,7 @@ const (
 	ccmTagSize         = 8
 	ccmNonceSize       = 13
 	maxInnerFrameSize  = 16_384
+	maxEnvelopeSize    = headerSize + maxInnerFrameSize + ccmTagSize
 	reservedFlagsValue = 0x41
 
 	flagsCipherMask  = 0b1110_0000
@@ -36,6 +39,23 @@ const (
 	CipherSuiteAes128Ccm CipherSuite = 0
 )
 
+// cipherSuiteInfo describes a cipher suite implemented by this package.
+type cipherSuiteInfo struct {
+	name    string
+	keySize int
+}
+
+// cipherSuites lists the cipher suites SealUplink and OpenEnvelope accept.
+var cipherSuites = map[CipherSuite]cipherSuiteInfo{
+	CipherSuiteAes128Ccm: {name: "aes-128-ccm", keySize: 16},
+}
+
+// envelopeVersion is the envelope version written by SealUplink.
+const envelopeVersion = 0
+
+// envelopeVersions lists the envelope versions OpenEnvelope accepts.
+var envelopeVersions = []int{envelopeVersion}
+
 // EnvelopeMethod represents the method in the envelope flags.
 type EnvelopeMethod int
 
@@ -54,8 +74,25 @@ type EnvelopeHeader struct {
 	DeviceHash [deviceHashSize]byte
 }
 
+// SecureErrorKind identifies the category of a SecureError.
+type SecureErrorKind string
+
+const (
+	ErrEnvelopeTooShort           SecureErrorKind = "envelope_too_short"
+	ErrUnsupportedSuite           SecureErrorKind = "unsupported_suite"
+	ErrUnsupportedEnvelopeVersion SecureErrorKind = "unsupported_version"
+	ErrReservedFlags              SecureErrorKind = "reserved_flags"
+	ErrInvalidEnvelopeMethod      SecureErrorKind = "invalid_envelope_method"
+	ErrBadKeySize                 SecureErrorKind = "bad_key_size"
+	ErrInvalidNonce               SecureErrorKind = "invalid_nonce"
+	ErrAuthFailed                 SecureErrorKind = "auth_failed"
+	ErrInnerTooLarge              SecureErrorKind = "inner_too_large"
+	ErrEnvelopeTooLarge           SecureErrorKind = "envelope_too_large"
+)
+
 // SecureError represents an error from crypto envelope operations.
 type SecureError struct {
+	Kind    SecureErrorKind
 	Message string
 }
 
@@ -63,8 +100,8 @@ func (e *SecureError) Error() string {
 	return fmt.Sprintf("tagotips: %s", e.Message)
 }
 
-func secureErr(msg string) error {
-	return &SecureError{Message: msg}
+func secureErr(kind SecureErrorKind, msg string) error {
+	return &SecureError{Kind: kind, Message: msg}
 }
 
 // DeriveAuthHash derives the Authorization Hash from a token.
@@ -97,7 +134,7 @@ func DeriveDeviceHash(serial string) [deviceHashSize]byte {
 // keyLen must be 16 (AES-128) or 32 (AES-256/ChaCha20).
 func DeriveKey(token, serial string, keyLen int) ([]byte, error) {
 	if keyLen != 16 && keyLen != 32 {
-		return nil, secureErr("key length must be 16 or 32")
+		return nil, secureErr(ErrBadKeySize, "key length must be 16 or 32")
 	}
 	hexPart := token
 	if strings.HasPrefix(token, "at") {
@@ -122,14 +159,14 @@ func BytesToHex(data []byte) string {
 func encodeFlags(cipherID, version, methodID int) (byte, error) {
 	flags := byte((cipherID << flagsCipherShift) | (version << flagsVersionShift) | methodID)
 	if flags == reservedFlagsValue {
-		return 0, secureErr("flags byte 0x41 is reserved")
+		return 0, secureErr(ErrReservedFlags, "flags byte 0x41 is reserved")
 	}
 	return flags, nil
 }
 
 func decodeFlags(flags byte) (cipherID, version, methodID int, err error) {
 	if flags == reservedFlagsValue {
-		return 0, 0, 0, secureErr("flags byte 0x41 is reserved")
+		return 0, 0, 0, secureErr(ErrReservedFlags, "flags byte 0x41 is reserved")
 	}
 	cipherID = int((flags & flagsCipherMask) >> flagsCipherShift)
 	version = int((flags & flagsVersionMask) >> flagsVersionShift)
@@ -137,17 +174,16 @@ func decodeFlags(flags byte) (cipherID, version, methodID int, err error) {
 	return cipherID, version, methodID, nil
 }
 
-func buildEnvelopeHeader(flags byte, counter uint32, authHash [authHashSize]byte, deviceHash [deviceHashSize]byte) []byte {
-	header := make([]byte, headerSize)
-	header[0] = flags
-	binary.BigEndian.PutUint32(header[flagsSize:], counter)
-	copy(header[flagsSize+counterSize:], authHash[:])
-	copy(header[flagsSize+counterSize+authHashSize:], deviceHash[:])
-	return header
+// putEnvelopeHeader writes the 21-byte envelope header into dst.
+func putEnvelopeHeader(dst []byte, flags byte, counter uint32, authHash [authHashSize]byte, deviceHash [deviceHashSize]byte) {
+	dst[0] = flags
+	binary.BigEndian.PutUint32(dst[flagsSize:], counter)
+	copy(dst[flagsSize+counterSize:], authHash[:])
+	copy(dst[flagsSize+counterSize+authHashSize:], deviceHash[:])
 }
 
-func constructNonce(flags byte, deviceHash [deviceHashSize]byte, counter uint32) []byte {
-	nonce := make([]byte, ccmNonceSize)
+func constructNonce(flags byte, deviceHash [deviceHashSize]byte, counter uint32) [ccmNonceSize]byte {
+	var nonce [ccmNonceSize]byte
 	nonce[0] = flags
 	// Zero padding at bytes 1-4 (already zeroed)
 	// Device hash first 4 bytes at offset (13 - 8) = 5
@@ -157,23 +193,14 @@ func constructNonce(flags byte, deviceHash [deviceHashSize]byte, counter uint32)
 	return nonce
 }
 
-// ccmEncrypt performs AES-128-CCM encryption with 8-byte tag.
-func ccmEncrypt(key, nonce, aad, plaintext []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, secureErr("invalid encryption key")
-	}
-	return ccmSeal(block, nonce, aad, plaintext)
-}
-
 // ccmDecrypt performs AES-128-CCM decryption with 8-byte tag.
 func ccmDecrypt(key, nonce, aad, ciphertextWithTag []byte) ([]byte, error) {
 	if len(ciphertextWithTag) < ccmTagSize {
-		return nil, secureErr("ciphertext too short")
+		return nil, secureErr(ErrEnvelopeTooShort, "ciphertext too short")
 	}
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, secureErr("invalid encryption key")
+		return nil, secureErr(ErrBadKeySize, "invalid encryption key")
 	}
 	return ccmOpen(block, nonce, aad, ciphertextWithTag)
 }
@@ -187,82 +214,159 @@ func SealUplink(
 	deviceHash [deviceHashSize]byte,
 	key []byte,
 	suite CipherSuite,
+) ([]byte, error) {
+	dst := make([]byte, 0, headerSize+len(innerFrame)+ccmTagSize)
+	return AppendSealUplink(dst, method, innerFrame, counter, authHash, deviceHash, key, suite)
+}
+
+// AppendSealUplink is like SealUplink but appends the envelope to dst and
+// returns the extended slice. When dst has enough spare capacity no
+// allocation is made for the envelope. innerFrame must not overlap dst.
+func AppendSealUplink(
+	dst []byte,
+	method EnvelopeMethod,
+	innerFrame []byte,
+	counter uint32,
+	authHash [authHashSize]byte,
+	deviceHash [deviceHashSize]byte,
+	key []byte,
+	suite CipherSuite,
 ) ([]byte, error) {
 	if len(innerFrame) > maxInnerFrameSize {
-		return nil, secureErr("inner frame exceeds maximum size")
+		return nil, secureErr(ErrInnerTooLarge, "inner frame exceeds maximum size")
 	}
-	if suite != CipherSuiteAes128Ccm {
-		return nil, secureErr("unsupported cipher suite")
+	info, ok := cipherSuites[suite]
+	if !ok {
+		return nil, secureErr(ErrUnsupportedSuite, "unsupported cipher suite")
 	}
-	if len(key) != 16 {
-		return nil, secureErr("invalid encryption key size")
+	if len(key) != info.keySize {
+		return nil, secureErr(ErrBadKeySize, "invalid encryption key size")
 	}
 
-	flags, err := encodeFlags(int(suite), 0, int(method))
+	flags, err := encodeFlags(int(suite), envelopeVersion, int(method))
 	if err != nil {
 		return nil, err
 	}
 
-	header := buildEnvelopeHeader(flags, counter, authHash, deviceHash)
-	nonce := constructNonce(flags, deviceHash, counter)
-
-	ciphertextWithTag, err := ccmEncrypt(key, nonce, header, innerFrame)
+	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, err
+		return nil, secureErr(ErrBadKeySize, "invalid encryption key")
 	}
 
-	envelope := make([]byte, headerSize+len(ciphertextWithTag))
-	copy(envelope, header)
-	copy(envelope[headerSize:], ciphertextWithTag)
-	return envelope, nil
+	return appendSealBlock(dst, block, flags, innerFrame, counter, authHash, deviceHash), nil
+}
+
+// appendSealBlock appends the envelope of innerFrame, sealed with block, to
+// dst. The arguments have been checked.
+func appendSealBlock(
+	dst []byte,
+	block cipher.Block,
+	flags byte,
+	innerFrame []byte,
+	counter uint32,
+	authHash [authHashSize]byte,
+	deviceHash [deviceHashSize]byte,
+) []byte {
+	start := len(dst)
+	envelope := growSlice(dst, headerSize+len(innerFrame)+ccmTagSize)
+	header := envelope[start : start+headerSize]
+	putEnvelopeHeader(header, flags, counter, authHash, deviceHash)
+	nonce := constructNonce(flags, deviceHash, counter)
+
+	ccmSealTo(block, envelope[start+headerSize:], nonce[:], header, innerFrame)
+	return envelope
+}
+
+// growSlice extends b by n bytes, reallocating only when capacity is short.
+func growSlice(b []byte, n int) []byte {
+	if cap(b)-len(b) < n {
+		grown := make([]byte, len(b), len(b)+n)
+		copy(grown, b)
+		b = grown
+	}
+	return b[:len(b)+n]
 }
 
 // OpenEnvelope decrypts a TagoTiP/S envelope.
 // Returns the header, method, and decrypted inner frame bytes.
+// Oversized envelopes are rejected before any decryption is attempted.
 func OpenEnvelope(envelope, key []byte) (*EnvelopeHeader, EnvelopeMethod, []byte, error) {
-	header, err := ParseEnvelopeHeader(envelope)
+	header, method, info, err := checkEnvelope(envelope)
 	if err != nil {
 		return nil, 0, nil, err
 	}
+	if len(key) != info.keySize {
+		return nil, 0, nil, secureErr(ErrBadKeySize, "invalid encryption key size")
+	}
 
-	cipherID, version, methodID, err := decodeFlags(header.Flags)
+	plaintext, err := decryptEnvelope(envelope, header, key)
 	if err != nil {
 		return nil, 0, nil, err
 	}
 
-	if version != 0 {
-		return nil, 0, nil, secureErr("unsupported version")
+	return header, method, plaintext, nil
+}
+
+// checkEnvelope parses the header of envelope and checks everything that
+// can be checked without the key.
+func checkEnvelope(envelope []byte) (*EnvelopeHeader, EnvelopeMethod, cipherSuiteInfo, error) {
+	header, err := ParseEnvelopeHeader(envelope)
+	if err != nil {
+		return nil, 0, cipherSuiteInfo{}, err
 	}
-	if cipherID != 0 {
-		return nil, 0, nil, secureErr("unsupported cipher suite")
+
+	cipherID, version, methodID, err := decodeFlags(header.Flags)
+	if err != nil {
+		return nil, 0, cipherSuiteInfo{}, err
 	}
-	if methodID > 3 {
-		return nil, 0, nil, secureErr("invalid method")
+
+	if !slices.Contains(envelopeVersions, version) {
+		return nil, 0, cipherSuiteInfo{}, secureErr(ErrUnsupportedEnvelopeVersion, "unsupported version")
 	}
-	if len(key) != 16 {
-		return nil, 0, nil, secureErr("invalid encryption key size")
+	info, ok := cipherSuites[CipherSuite(cipherID)]
+	if !ok {
+		return nil, 0, cipherSuiteInfo{}, secureErr(ErrUnsupportedSuite, "unsupported cipher suite")
+	}
+	if methodID > 3 {
+		return nil, 0, cipherSuiteInfo{}, secureErr(ErrInvalidEnvelopeMethod, "invalid method")
 	}
 
-	ciphertextWithTag := envelope[headerSize:]
-	if len(ciphertextWithTag) < ccmTagSize {
-		return nil, 0, nil, secureErr("envelope too short")
+	if len(envelope)-headerSize < ccmTagSize {
+		return nil, 0, cipherSuiteInfo{}, secureErr(ErrEnvelopeTooShort, "envelope too short")
 	}
+	return header, EnvelopeMethod(methodID), info, nil
+}
 
+// decryptEnvelope decrypts an envelope that checkEnvelope accepted.
+func decryptEnvelope(envelope []byte, header *EnvelopeHeader, key []byte) ([]byte, error) {
 	aad := envelope[:headerSize]
 	nonce := constructNonce(header.Flags, header.DeviceHash, header.Counter)
+	return ccmDecrypt(key, nonce[:], aad, envelope[headerSize:])
+}
 
-	plaintext, err := ccmDecrypt(key, nonce, aad, ciphertextWithTag)
-	if err != nil {
-		return nil, 0, nil, err
+// appendOpenBlock decrypts an envelope that checkEnvelope accepted with
+// block and appends the inner frame to dst. On failure dst is returned
+// unchanged.
+func appendOpenBlock(dst []byte, block cipher.Block, envelope []byte, header *EnvelopeHeader) ([]byte, error) {
+	nonce := constructNonce(header.Flags, header.DeviceHash, header.Counter)
+	ciphertextWithTag := envelope[headerSize:]
+	start := len(dst)
+	out := growSlice(dst, len(ciphertextWithTag)-ccmTagSize)
+	if err := ccmOpenTo(block, out[start:], nonce[:], envelope[:headerSize], ciphertextWithTag); err != nil {
+		return dst, err
 	}
-
-	return header, EnvelopeMethod(methodID), plaintext, nil
+	return out, nil
 }
 
 // ParseEnvelopeHeader parses the 21-byte envelope header for server-side routing.
+// Envelopes longer than a sealed maximum-size inner frame are rejected
+// before anything else is read.
 func ParseEnvelopeHeader(envelope []byte) (*EnvelopeHeader, error) {
+	if len(envelope) > maxEnvelopeSize {
+		return nil, secureErr(ErrEnvelopeTooLarge, "envelope exceeds maximum size")
+	}
 	if len(envelope) < headerSize {
-		return nil, secureErr("envelope too short")
+		return nil, secureErr(ErrEnvelopeTooShort, "envelope too short")
 	}
 
 	flags := envelope[0]
@@ -298,3 +402,15 @@ func IsSecureError(err error) bool {
 	var se *SecureError
 	return errors.As(err, &se)
 }
+
+// IsSecureErrorKind checks if an error is a SecureError of the given kind.
+func IsSecureErrorKind(err error, kind SecureErrorKind) bool {
+	var se *SecureError
+	return errors.As(err, &se) && se.Kind == kind
+}
+
+// IsAuthFailure checks if an error is an envelope that failed
+// authentication: a wrong key, or a tampered or corrupted envelope.
+func IsAuthFailure(err error) bool {
+	return IsSecureErrorKind(err, ErrAuthFailed)
+}
@@ -2,6 +2,7 @@ package tagotip
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 )
 
@@ -369,6 +370,53 @@ func TestSpecVectorOpen(t *testing.T) {
 	}
 }
 
+func TestSpecVectorAppendSeal(t *testing.T) {
+	prefix := []byte("prefix")
+	dst := make([]byte, len(prefix), len(prefix)+len(specEnvelope))
+	copy(dst, prefix)
+
+	out, err := AppendSealUplink(
+		dst,
+		EnvelopeMethodPush,
+		[]byte("sensor-01|[temp:=32]"),
+		42,
+		specAuthHash,
+		specDeviceHash,
+		specKey,
+		CipherSuiteAes128Ccm,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &out[0] != &dst[0] {
+		t.Error("expected envelope to be written into dst's spare capacity")
+	}
+	if !bytes.Equal(out[:len(prefix)], prefix) {
+		t.Errorf("prefix clobbered: %q", out[:len(prefix)])
+	}
+	if !bytes.Equal(out[len(prefix):], specEnvelope) {
+		t.Errorf("envelope mismatch:\n  want: %x\n  got:  %x", specEnvelope, out[len(prefix):])
+	}
+}
+
+func TestSealOpenRoundTripLongInner(t *testing.T) {
+	// Exercises multi-block AAD-free plaintext and partial final blocks.
+	for _, n := range []int{0, 1, 15, 16, 17, 31, 32, 33, 1000} {
+		inner := bytes.Repeat([]byte{'a'}, n)
+		envelope, err := SealUplink(EnvelopeMethodPush, inner, 7, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, plaintext, err := OpenEnvelope(envelope, specKey)
+		if err != nil {
+			t.Fatalf("n=%d: %v", n, err)
+		}
+		if !bytes.Equal(plaintext, inner) {
+			t.Errorf("n=%d: plaintext mismatch", n)
+		}
+	}
+}
+
 // =========================================================================
 // Round-trip tests
 // =========================================================================
@@ -558,3 +606,86 @@ func TestParseEnvelopeHeader(t *testing.T) {
 		t.Errorf("wrong device hash")
 	}
 }
+
+// =========================================================================
+// SecureError kinds
+// =========================================================================
+
+func TestSecureErrorKinds(t *testing.T) {
+	withFlags := func(flags byte) []byte {
+		env := bytes.Clone(specEnvelope)
+		env[0] = flags
+		return env
+	}
+	open := func(env, key []byte) error {
+		_, _, _, err := OpenEnvelope(env, key)
+		return err
+	}
+	tampered := bytes.Clone(specEnvelope)
+	tampered[25] ^= 0xFF
+	_, deriveErr := DeriveKey(specToken, specSerial, 20)
+	_, sealErr := SealUplink(EnvelopeMethodPush, make([]byte, maxInnerFrameSize+1), 1, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm)
+	_, suiteErr := SealUplink(EnvelopeMethodPush, []byte("x"), 1, specAuthHash, specDeviceHash, specKey, CipherSuite(7))
+	_, _, downlinkErr := OpenDownlink(specEnvelope, specKey)
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		kind SecureErrorKind
+	}{
+		{"wrong key", open(specEnvelope, make([]byte, 16)), ErrAuthFailed},
+		{"tampered", open(tampered, specKey), ErrAuthFailed},
+		{"too short", open(specEnvelope[:10], specKey), ErrEnvelopeTooShort},
+		{"no tag", open(specEnvelope[:headerSize+3], specKey), ErrEnvelopeTooShort},
+		{"reserved flags", open(withFlags(reservedFlagsValue), specKey), ErrReservedFlags},
+		{"version", open(withFlags(1<<flagsVersionShift), specKey), ErrUnsupportedEnvelopeVersion},
+		{"open suite", open(withFlags(7<<flagsCipherShift), specKey), ErrUnsupportedSuite},
+		{"key size", open(specEnvelope, specKey[:5]), ErrBadKeySize},
+		{"derive key size", deriveErr, ErrBadKeySize},
+		{"inner too large", sealErr, ErrInnerTooLarge},
+		{"envelope too large", open(make([]byte, maxEnvelopeSize+1), specKey), ErrEnvelopeTooLarge},
+		{"seal suite", suiteErr, ErrUnsupportedSuite},
+		{"downlink method", downlinkErr, ErrInvalidEnvelopeMethod},
+	} {
+		var se *SecureError
+		if !errors.As(tc.err, &se) {
+			t.Errorf("%s: got %T %v", tc.name, tc.err, tc.err)
+			continue
+		}
+		if se.Kind != tc.kind {
+			t.Errorf("%s: kind %q, want %q", tc.name, se.Kind, tc.kind)
+		}
+		if IsAuthFailure(tc.err) != (tc.kind == ErrAuthFailed) {
+			t.Errorf("%s: IsAuthFailure is %v", tc.name, IsAuthFailure(tc.err))
+		}
+	}
+}
+
+func TestOpenEnvelopeRejectsOversized(t *testing.T) {
+	// A multi-megabyte envelope with a valid header is refused up front.
+	huge := make([]byte, 4<<20)
+	copy(huge, specEnvelope[:headerSize])
+	if _, err := ParseEnvelopeHeader(huge); !IsSecureErrorKind(err, ErrEnvelopeTooLarge) {
+		t.Errorf("ParseEnvelopeHeader: %v", err)
+	}
+	if _, _, _, err := OpenEnvelope(huge, specKey); !IsSecureErrorKind(err, ErrEnvelopeTooLarge) {
+		t.Errorf("OpenEnvelope: %v", err)
+	}
+	if allocs := testing.AllocsPerRun(10, func() { OpenEnvelope(huge, specKey) }); allocs > 1 {
+		t.Errorf("rejecting allocated %.0f times", allocs)
+	}
+
+	// The largest envelope SealUplink produces still opens.
+	inner := append([]byte("sensor-01|[s="), bytes.Repeat([]byte("x"), maxInnerFrameSize-14)...)
+	inner = append(inner, ']')
+	envelope, err := SealUplink(EnvelopeMethodPush, inner, 1, specAuthHash, specDeviceHash, specKey, CipherSuiteAes128Ccm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(envelope) != maxEnvelopeSize {
+		t.Fatalf("envelope is %d bytes, want %d", len(envelope), maxEnvelopeSize)
+	}
+	if _, _, _, err := OpenEnvelope(envelope, specKey); err != nil {
+		t.Error(err)
+	}
+}
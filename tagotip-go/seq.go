@@ -0,0 +1,145 @@
+package tagotip
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Sequence counters
+// ---------------------------------------------------------------------------
+//
+// A device numbers its frames with seq so that the ACKs coming back can be
+// matched to them, in any order. SeqTracker hands out the numbers and
+// PendingAcks matches the ACKs. UDPClient uses both; the TCP Client
+// numbers its frames with a SeqTracker but keeps its own queue of calls,
+// which also matches ACKs without seq in order.
+
+// SeqTracker hands out seq values in order, wrapping from 4294967295 back
+// to 0. The zero value starts at 1. It is safe for concurrent use.
+type SeqTracker struct {
+	last atomic.Uint32
+}
+
+// NewSeqTracker returns a SeqTracker whose first value follows last, as
+// when resuming a device's numbering.
+func NewSeqTracker(last uint32) *SeqTracker {
+	t := &SeqTracker{}
+	t.last.Store(last)
+	return t
+}
+
+// Next returns the next seq.
+func (t *SeqTracker) Next() uint32 {
+	return t.last.Add(1)
+}
+
+// Last returns the seq returned by the last call to Next, or the value the
+// tracker was created with.
+func (t *SeqTracker) Last() uint32 {
+	return t.last.Load()
+}
+
+type pendingAck struct {
+	seq     uint32
+	ch      chan *AckFrame
+	expires time.Time
+}
+
+// PendingAcks matches ACKs to the frames awaiting them by seq. Entries not
+// resolved within ttl of being registered are dropped, closing their
+// channel, so that the ACKs of frames given up on do not hold memory.
+// Expired entries are dropped by the next call to any method. It is safe
+// for concurrent use.
+type PendingAcks struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[uint32]*list.Element
+	order   *list.List // front = oldest
+	now     func() time.Time
+}
+
+// NewPendingAcks returns an empty registry whose entries expire after ttl.
+// ttl must be positive.
+func NewPendingAcks(ttl time.Duration) *PendingAcks {
+	if ttl <= 0 {
+		panic("tagotip: PendingAcks ttl must be positive")
+	}
+	return &PendingAcks{
+		ttl:     ttl,
+		entries: make(map[uint32]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Register records that the frame numbered seq awaits its ACK and returns
+// the channel the ACK will be sent on. The channel is closed without a
+// value if the entry expires or is cancelled. Registering a seq again
+// cancels its previous entry.
+func (p *PendingAcks) Register(seq uint32) <-chan *AckFrame {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	p.evictExpired(now)
+	if el, ok := p.entries[seq]; ok {
+		close(p.remove(el).ch)
+	}
+	e := &pendingAck{seq: seq, ch: make(chan *AckFrame, 1), expires: now.Add(p.ttl)}
+	p.entries[seq] = p.order.PushBack(e)
+	return e.ch
+}
+
+// Resolve sends ack to the entry of its seq and removes the entry. It
+// reports false, dropping ack, when ack has no seq or no entry awaits it,
+// as for a duplicate ACK.
+func (p *PendingAcks) Resolve(ack *AckFrame) bool {
+	if ack.Seq == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evictExpired(p.now())
+	el, ok := p.entries[*ack.Seq]
+	if !ok {
+		return false
+	}
+	e := p.remove(el)
+	e.ch <- ack
+	close(e.ch)
+	return true
+}
+
+// Cancel removes the entry of seq, if any, closing its channel.
+func (p *PendingAcks) Cancel(seq uint32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if el, ok := p.entries[seq]; ok {
+		close(p.remove(el).ch)
+	}
+}
+
+// Len returns the number of entries awaiting an ACK.
+func (p *PendingAcks) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evictExpired(p.now())
+	return p.order.Len()
+}
+
+func (p *PendingAcks) evictExpired(now time.Time) {
+	for el := p.order.Front(); el != nil; el = p.order.Front() {
+		if now.Before(el.Value.(*pendingAck).expires) {
+			return
+		}
+		close(p.remove(el).ch)
+	}
+}
+
+func (p *PendingAcks) remove(el *list.Element) *pendingAck {
+	e := p.order.Remove(el).(*pendingAck)
+	delete(p.entries, e.seq)
+	return e
+}
@@ -0,0 +1,127 @@
+package tagotip
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// Sequence counters
+// ============================================================================
+
+func TestSeqTracker(t *testing.T) {
+	var zero SeqTracker
+	if n := zero.Next(); n != 1 {
+		t.Errorf("zero value starts at %d", n)
+	}
+
+	s := NewSeqTracker(math.MaxUint32 - 1)
+	for _, want := range []uint32{math.MaxUint32, 0, 1} {
+		if n := s.Next(); n != want {
+			t.Errorf("got %d, want %d", n, want)
+		}
+	}
+	if s.Last() != 1 {
+		t.Errorf("Last %d", s.Last())
+	}
+}
+
+func TestSeqTrackerConcurrent(t *testing.T) {
+	var s SeqTracker
+	var mu sync.Mutex
+	seen := make(map[uint32]bool)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				n := s.Next()
+				mu.Lock()
+				if seen[n] {
+					t.Errorf("%d handed out twice", n)
+				}
+				seen[n] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 8000 || s.Last() != 8000 {
+		t.Errorf("%d values, last %d", len(seen), s.Last())
+	}
+}
+
+func TestPendingAcksOutOfOrder(t *testing.T) {
+	p := NewPendingAcks(time.Minute)
+	first, second := p.Register(1), p.Register(2)
+	if !p.Resolve(&AckFrame{Seq: u32Ptr(2), Status: AckStatusOk}) {
+		t.Fatal("seq 2 not resolved")
+	}
+	if p.Resolve(&AckFrame{Seq: u32Ptr(2), Status: AckStatusOk}) {
+		t.Error("duplicate ACK resolved")
+	}
+	if p.Resolve(&AckFrame{Status: AckStatusOk}) {
+		t.Error("ACK without seq resolved")
+	}
+	if !p.Resolve(&AckFrame{Seq: u32Ptr(1), Status: AckStatusPong}) {
+		t.Fatal("seq 1 not resolved")
+	}
+	if ack := <-second; *ack.Seq != 2 {
+		t.Errorf("seq 2 got %+v", ack)
+	}
+	if ack := <-first; ack.Status != AckStatusPong {
+		t.Errorf("seq 1 got %+v", ack)
+	}
+	if _, ok := <-first; ok {
+		t.Error("channel not closed after its ACK")
+	}
+	if p.Len() != 0 {
+		t.Errorf("%d entries left", p.Len())
+	}
+}
+
+func TestPendingAcksExpiry(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	p := NewPendingAcks(time.Second)
+	p.now = func() time.Time { return now }
+	old := p.Register(1)
+	now = now.Add(600 * time.Millisecond)
+	recent := p.Register(2)
+	now = now.Add(600 * time.Millisecond)
+
+	if p.Len() != 1 {
+		t.Errorf("%d entries after the first expired", p.Len())
+	}
+	if _, ok := <-old; ok {
+		t.Error("expired entry received a value")
+	}
+	if p.Resolve(&AckFrame{Seq: u32Ptr(1)}) {
+		t.Error("expired entry resolved")
+	}
+	if !p.Resolve(&AckFrame{Seq: u32Ptr(2)}) {
+		t.Error("live entry not resolved")
+	}
+	if ack := <-recent; ack == nil {
+		t.Error("live entry got nothing")
+	}
+}
+
+func TestPendingAcksCancel(t *testing.T) {
+	p := NewPendingAcks(time.Minute)
+	replaced := p.Register(7)
+	current := p.Register(7)
+	if _, ok := <-replaced; ok {
+		t.Error("replaced entry not closed")
+	}
+	p.Cancel(7)
+	if _, ok := <-current; ok {
+		t.Error("cancelled entry not closed")
+	}
+	p.Cancel(7) // no entry: no-op
+	if p.Resolve(&AckFrame{Seq: u32Ptr(7)}) {
+		t.Error("cancelled entry resolved")
+	}
+}
@@ -0,0 +1,212 @@
+package tagotip
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// Server frame dispatch
+// ---------------------------------------------------------------------------
+//
+// FrameMux is the skeleton of an ingest server: it reads newline-terminated
+// uplink frames from each connection, hands every parsed frame to the
+// handler registered for its method, and writes back the ACK the handler
+// returns, with the frame's seq. Frames that fail to parse never reach a
+// handler; they are answered with the ERR code matching the parse error.
+// A connection's frames are handled one at a time, in order, so its ACKs
+// come back in the order of the frames, as clients without seq expect.
+
+// FrameHandlerFunc handles a parsed uplink frame and returns the ACK to
+// send. The context carries the sender's address, read with RemoteAddr,
+// and ends when the connection closes or the server stops.
+//
+// Returning a nil ACK and a nil error sends no reply. An error of type
+// *ServerError is answered with its Ack, or when that is nil an ERR ACK
+// carrying its Code; any other error is answered with server_error.
+type FrameHandlerFunc func(ctx context.Context, frame *UplinkFrame) (*AckFrame, error)
+
+// FrameMux dispatches uplink frames to the handlers registered for their
+// method. Register handlers before calling Serve; the mux is then safe for
+// concurrent use.
+//
+// A PUSH or PULL without a handler is answered with invalid_method, and a
+// PING without one with PONG.
+type FrameMux struct {
+	// ParserOptions, if not nil, is used to parse every frame.
+	ParserOptions *ParserOptions
+
+	// Limiter, if not nil, is asked about every frame that parses, before
+	// its handler. A frame it refuses is answered with rate_limited and the
+	// wait it gives, in seconds rounded up.
+	Limiter RateLimiter
+
+	push, pull, ping FrameHandlerFunc
+}
+
+// HandlePush registers the handler for PUSH frames, replacing any before.
+func (m *FrameMux) HandlePush(h FrameHandlerFunc) { m.push = h }
+
+// HandlePull registers the handler for PULL frames, replacing any before.
+func (m *FrameMux) HandlePull(h FrameHandlerFunc) { m.pull = h }
+
+// HandlePing registers the handler for PING frames, replacing any before.
+func (m *FrameMux) HandlePing(h FrameHandlerFunc) { m.ping = h }
+
+type remoteAddrKey struct{}
+
+// RemoteAddr returns the address of the connection a frame arrived on, as
+// held by the context a FrameMux passes to its handlers, or nil when ctx
+// carries none.
+func RemoteAddr(ctx context.Context) net.Addr {
+	addr, _ := ctx.Value(remoteAddrKey{}).(net.Addr)
+	return addr
+}
+
+// Serve accepts connections on ln and serves each in its own goroutine
+// until ln fails, as when it is closed. It then closes the connections it
+// accepted, waits for their handlers to return, and returns the error of
+// ln.Accept.
+func (m *FrameMux) Serve(ln net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.ServeConn(ctx, conn)
+		}()
+	}
+}
+
+// ServeConn reads frames from conn and writes back their ACKs until the
+// connection ends, a write fails, or ctx is done, then closes conn. A
+// frame longer than MaxFrameSize is answered with payload_too_large and
+// ends the connection, as the stream cannot be resynchronised. Empty lines
+// are skipped.
+func (m *FrameMux) ServeConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, remoteAddrKey{}, conn.RemoteAddr()))
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	sc := bufio.NewScanner(conn)
+	sc.Split(ScanFrames)
+	var out []byte
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		ack := m.HandleFrame(ctx, sc.Text())
+		if ack == nil {
+			continue
+		}
+		out = appendAckLine(out[:0], ack)
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+	var tooLarge *FrameSizeError
+	if errors.As(sc.Err(), &tooLarge) {
+		conn.Write(appendAckLine(out[:0], NewAckErr(ErrorCodePayloadTooLarge, nil)))
+	}
+}
+
+// HandleFrame parses raw, a single uplink frame, and returns the ACK to
+// send for it, as ServeConn does for each frame it reads. It suits
+// transports other than a stream, such as UDP, with ctx carrying what the
+// handlers expect. The ACK carries the frame's seq, when the header could
+// be read, set on a copy of the handler's ACK so that a handler may return
+// the same ACK for every frame; nil means no reply.
+func (m *FrameMux) HandleFrame(ctx context.Context, raw string) *AckFrame {
+	frame, err := ParseUplinkWithOptions(raw, m.ParserOptions)
+	if err != nil {
+		var seq *uint32
+		if h, herr := ParseUplinkHeader(raw); herr == nil {
+			seq = h.Seq
+		}
+		return NewAckErr(parseErrorCode(err), seq)
+	}
+
+	if m.Limiter != nil {
+		if ok, wait := m.Limiter.Allow(frame.Serial); !ok {
+			return NewAckErrRateLimited(frame.Seq, retryAfterSeconds(wait))
+		}
+	}
+
+	var h FrameHandlerFunc
+	switch frame.Method {
+	case MethodPush:
+		h = m.push
+	case MethodPull:
+		h = m.pull
+	case MethodPing:
+		h = m.ping
+	}
+	if h == nil {
+		if frame.Method == MethodPing {
+			return &AckFrame{Seq: frame.Seq, Status: AckStatusPong}
+		}
+		return NewAckErr(ErrorCodeInvalidMethod, frame.Seq)
+	}
+
+	ack, err := h(ctx, frame)
+	if err != nil {
+		var se *ServerError
+		switch {
+		case !errors.As(err, &se):
+			ack = NewAckErr(ErrorCodeServerError, nil)
+		case se.Ack != nil:
+			ack = se.Ack
+		default:
+			ack = NewAckErr(se.Code, nil)
+		}
+	}
+	if ack == nil {
+		return nil
+	}
+	a := *ack
+	a.Seq = frame.Seq
+	return &a
+}
+
+// appendAckLine appends ack and its newline to dst. An ACK that cannot be
+// built is replaced by server_error.
+func appendAckLine(dst []byte, ack *AckFrame) []byte {
+	out, err := AppendAck(dst, ack)
+	if err != nil {
+		out, _ = AppendAck(dst, NewAckErr(ErrorCodeServerError, ack.Seq))
+	}
+	return append(out, '\n')
+}
+
+// parseErrorCode returns the ERR code answering a frame that failed to
+// parse with err.
+func parseErrorCode(err error) ErrorCode {
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		return ErrorCodeInvalidPayload
+	}
+	switch pe.Kind {
+	case ErrInvalidAuth:
+		return ErrorCodeInvalidToken
+	case ErrInvalidMethod:
+		return ErrorCodeInvalidMethod
+	case ErrInvalidSeq:
+		return ErrorCodeInvalidSeq
+	case ErrFrameTooLarge:
+		return ErrorCodePayloadTooLarge
+	default:
+		return ErrorCodeInvalidPayload
+	}
+}
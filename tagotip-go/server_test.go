@@ -0,0 +1,188 @@
+package tagotip
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ============================================================================
+// Server frame dispatch
+// ============================================================================
+
+// serveMux serves m on one end of a pipe and returns the other end, with a
+// reader for the ACKs.
+func serveMux(t *testing.T, m *FrameMux) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.ServeConn(context.Background(), server)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	return client, bufio.NewReader(client)
+}
+
+// exchange sends frame and returns the ACK line it receives.
+func exchange(t *testing.T, conn net.Conn, r *bufio.Reader, frame string) string {
+	t.Helper()
+	if _, err := conn.Write([]byte(frame + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("%s: %v", frame, err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
+
+func TestFrameMuxDispatch(t *testing.T) {
+	var m FrameMux
+	m.HandlePush(func(ctx context.Context, f *UplinkFrame) (*AckFrame, error) {
+		if RemoteAddr(ctx) == nil {
+			t.Error("no remote address in context")
+		}
+		n := len(f.PushBody.Structured.Variables)
+		return &AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "count", Count: uint32(n)}}, nil
+	})
+	m.HandlePull(func(ctx context.Context, f *UplinkFrame) (*AckFrame, error) {
+		switch f.PullBody.Variables[0] {
+		case "missing":
+			return nil, &ServerError{Code: ErrorCodeVariableNotFound}
+		case "limited":
+			return nil, &ServerError{Ack: NewAckErrRateLimited(nil, 30)}
+		case "silent":
+			return nil, nil
+		}
+		return nil, errors.New("database down")
+	})
+	conn, r := serveMux(t, &m)
+
+	prefix := "|" + testAuth + "|sensor-01"
+	for _, tc := range []struct{ frame, ack string }{
+		{"PUSH|!7" + prefix + "|[temp:=21;hum:=60]", "ACK|!7|OK|2"},
+		{"PUSH" + prefix + "|[temp:=21]", "ACK|OK|1"},
+		{"PING|!8" + prefix, "ACK|!8|PONG"},
+		{"PULL|!9" + prefix + "|[missing]", "ACK|!9|ERR|variable_not_found"},
+		{"PULL" + prefix + "|[limited]", "ACK|ERR|rate_limited|30"},
+		{"PULL|!10" + prefix + "|[other]", "ACK|!10|ERR|server_error"},
+	} {
+		if got := exchange(t, conn, r, tc.frame); got != tc.ack {
+			t.Errorf("%s: got %s, want %s", tc.frame, got, tc.ack)
+		}
+	}
+
+	// A nil ACK sends nothing: the next reply is for the next frame.
+	conn.Write([]byte("PULL|!11" + prefix + "|[silent]\n\n"))
+	if got := exchange(t, conn, r, "PING|!12"+prefix); got != "ACK|!12|PONG" {
+		t.Errorf("after silent frame: %s", got)
+	}
+}
+
+func TestFrameMuxParseErrors(t *testing.T) {
+	var m FrameMux
+	m.HandlePush(func(context.Context, *UplinkFrame) (*AckFrame, error) {
+		t.Error("handler called for a bad frame")
+		return nil, nil
+	})
+	conn, r := serveMux(t, &m)
+
+	prefix := "|" + testAuth + "|sensor-01"
+	for _, tc := range []struct{ frame, ack string }{
+		{"PUSH|!3|not-a-token|sensor-01|[temp:=1]", "ACK|ERR|invalid_token"},
+		{"SEND|!3" + prefix + "|[temp:=1]", "ACK|ERR|invalid_method"},
+		{"PUSH|!x" + prefix + "|[temp:=1]", "ACK|ERR|invalid_seq"},
+		{"PUSH|!4" + prefix + "|[temp:=1", "ACK|!4|ERR|invalid_payload"},
+		{"PULL|!5" + prefix + "|[temp]", "ACK|!5|ERR|invalid_method"}, // no PULL handler
+	} {
+		if got := exchange(t, conn, r, tc.frame); got != tc.ack {
+			t.Errorf("%s: got %s, want %s", tc.frame, got, tc.ack)
+		}
+	}
+
+	// An oversized frame is answered, then the connection ends.
+	go conn.Write([]byte(strings.Repeat("a", MaxFrameSize+1) + "\n"))
+	if line, _ := r.ReadString('\n'); line != "ACK|ERR|payload_too_large\n" {
+		t.Errorf("oversized frame: %q", line)
+	}
+	if _, err := r.ReadString('\n'); err == nil {
+		t.Error("connection still open")
+	}
+}
+
+func TestFrameMuxServe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	var m FrameMux
+	m.HandlePush(func(ctx context.Context, f *UplinkFrame) (*AckFrame, error) {
+		if f.Serial != "dev" {
+			t.Errorf("serial %q", f.Serial)
+		}
+		return &AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "count", Count: 1}}, nil
+	})
+	served := make(chan error, 1)
+	go func() { served <- m.Serve(ln) }()
+
+	c, err := Dial(ln.Addr().String(), testAuth, "dev", &ClientOptions{Sequencing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+	body := &PushBody{Structured: &StructuredBody{Variables: []Variable{
+		{Name: "temp", Operator: OperatorNumber, Value: Value{Type: OperatorNumber, Str: "21.5"}},
+	}}}
+	if ack, err := c.Push(ctx, body); err != nil || *ack.Seq != 1 || ack.Detail.Count != 1 {
+		t.Errorf("Push: %+v, %v", ack, err)
+	}
+	if err := c.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+
+	ln.Close()
+	if err := <-served; !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Serve returned %v", err)
+	}
+	// Serve closed the client's connection on the way out.
+	if err := c.Ping(ctx); err == nil {
+		t.Error("Ping after Serve returned")
+	}
+}
+
+func TestFrameMuxSharedAck(t *testing.T) {
+	ok := &AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "count", Count: 1}}
+	limited := &ServerError{Ack: NewAckErrRateLimited(nil, 30)}
+	var m FrameMux
+	m.HandlePush(func(context.Context, *UplinkFrame) (*AckFrame, error) { return ok, nil })
+	m.HandlePull(func(context.Context, *UplinkFrame) (*AckFrame, error) { return nil, limited })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(seq int) {
+			defer wg.Done()
+			prefix := fmt.Sprintf("|!%d|%s|dev|", seq, testAuth)
+			for _, frame := range []string{"PUSH" + prefix + "[t:=1]", "PULL" + prefix + "[t]"} {
+				ack := m.HandleFrame(context.Background(), frame)
+				if ack.Seq == nil || *ack.Seq != uint32(seq) {
+					t.Errorf("%s: answered with seq %v", frame, ack.Seq)
+				}
+			}
+		}(i + 1)
+	}
+	wg.Wait()
+	if ok.Seq != nil || limited.Ack.Seq != nil {
+		t.Error("the handler's ACK was modified")
+	}
+}
@@ -0,0 +1,114 @@
+package tagotip
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// Secure sessions
+// ---------------------------------------------------------------------------
+//
+// The envelope nonce is built from the device hash and the counter, so a
+// counter must never be used twice under the same key. SecureSession hands
+// out counters in order, records each one in a CounterStore before it is
+// used, and stops once the counter space is exhausted.
+
+// ErrCounterExhausted is returned by SecureSession.Seal once the counter
+// has reached the largest uint32; the device needs a new key.
+var ErrCounterExhausted = errors.New("tagotips: envelope counter exhausted")
+
+// CounterStore persists the last counter a SecureSession used, so that
+// counters are not reused after a restart.
+type CounterStore interface {
+	// Get returns the last counter used, or 0 when none has been.
+	Get() (uint32, error)
+	// Put records counter as used. It is called before the envelope
+	// carrying counter is sealed.
+	Put(counter uint32) error
+}
+
+// SecureSession seals uplinks and opens downlinks for one device, deriving
+// the key and hashes from its token and serial and numbering envelopes
+// from 1 up. It is safe for concurrent use.
+type SecureSession struct {
+	cipher     *EnvelopeCipher
+	authHash   [authHashSize]byte
+	deviceHash [deviceHashSize]byte
+	store      CounterStore
+
+	mu   sync.Mutex
+	last uint32 // last counter used
+}
+
+// NewSecureSession returns a session for the device with the given token
+// and serial. When store is not nil, counting resumes after the counter it
+// returns; otherwise it starts at 1.
+func NewSecureSession(token, serial string, suite CipherSuite, store CounterStore) (*SecureSession, error) {
+	info, ok := cipherSuites[suite]
+	if !ok {
+		return nil, secureErr(ErrUnsupportedSuite, "unsupported cipher suite")
+	}
+	key, err := DeriveKey(token, serial, info.keySize)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewEnvelopeCipher(key, suite)
+	if err != nil {
+		return nil, err
+	}
+	s := &SecureSession{
+		cipher:     c,
+		authHash:   DeriveAuthHash(token),
+		deviceHash: DeriveDeviceHash(serial),
+		store:      store,
+	}
+	if store != nil {
+		if s.last, err = store.Get(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Seal encrypts innerFrame into an envelope with the next counter. It
+// returns ErrCounterExhausted, without sealing, when no counter is left,
+// and the store's error when the counter cannot be recorded.
+func (s *SecureSession) Seal(method EnvelopeMethod, innerFrame []byte) ([]byte, error) {
+	s.mu.Lock()
+	if s.last == math.MaxUint32 {
+		s.mu.Unlock()
+		return nil, ErrCounterExhausted
+	}
+	counter := s.last + 1
+	if s.store != nil {
+		if err := s.store.Put(counter); err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+	s.last = counter
+	s.mu.Unlock()
+	return s.cipher.Seal(method, innerFrame, counter, s.authHash, s.deviceHash)
+}
+
+// Open decrypts a downlink envelope for the device with OpenDownlink.
+func (s *SecureSession) Open(envelope []byte) (*EnvelopeHeader, *AckFrame, error) {
+	header, method, plaintext, err := s.cipher.Open(envelope)
+	if err != nil {
+		return nil, nil, err
+	}
+	frame, err := parseDownlink(method, plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, frame, nil
+}
+
+// Counter returns the last counter used, or 0 when none has been.
+func (s *SecureSession) Counter() uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
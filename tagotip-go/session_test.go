@@ -0,0 +1,168 @@
+package tagotip
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+)
+
+// ============================================================================
+// Secure sessions
+// ============================================================================
+
+type memCounterStore struct {
+	n   uint32
+	err error
+}
+
+func (m *memCounterStore) Get() (uint32, error) { return m.n, m.err }
+
+func (m *memCounterStore) Put(n uint32) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.n = n
+	return nil
+}
+
+func TestSecureSessionSealsInOrder(t *testing.T) {
+	s, err := NewSecureSession(specToken, specSerial, CipherSuiteAes128Ccm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for want := uint32(1); want <= 3; want++ {
+		envelope, err := s.Seal(EnvelopeMethodPush, []byte("sensor-01|[temp:=32]"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		header, _, method, err := OpenUplinkFrame(envelope, sessionKey(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Counter != want || method != MethodPush || header.AuthHash != specAuthHash || header.DeviceHash != specDeviceHash {
+			t.Errorf("envelope %d: %+v", want, header)
+		}
+	}
+}
+
+func TestSecureSessionMatchesSpecVector(t *testing.T) {
+	s, err := NewSecureSession(specToken, specSerial, CipherSuiteAes128Ccm, &memCounterStore{n: 41})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The spec vector's key is not derived from its token.
+	if s.cipher, err = NewEnvelopeCipher(specKey, CipherSuiteAes128Ccm); err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := s.Seal(EnvelopeMethodPush, []byte("sensor-01|[temp:=32]"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(envelope) != string(specEnvelope) {
+		t.Errorf("envelope %x", envelope)
+	}
+}
+
+func TestSecureSessionStore(t *testing.T) {
+	store := &memCounterStore{n: 10}
+	s, err := NewSecureSession(specToken, specSerial, CipherSuiteAes128Ccm, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Seal(EnvelopeMethodPing, []byte("sensor-01")); err != nil {
+		t.Fatal(err)
+	}
+	if store.n != 11 || s.Counter() != 11 {
+		t.Errorf("stored %d, session at %d", store.n, s.Counter())
+	}
+
+	broken := errors.New("disk full")
+	store.err = broken
+	if _, err := s.Seal(EnvelopeMethodPing, []byte("sensor-01")); err != broken || s.Counter() != 11 {
+		t.Errorf("got %v at %d", err, s.Counter())
+	}
+	if _, err := NewSecureSession(specToken, specSerial, CipherSuiteAes128Ccm, store); err != broken {
+		t.Errorf("new session: %v", err)
+	}
+}
+
+func TestSecureSessionCounterExhausted(t *testing.T) {
+	s, err := NewSecureSession(specToken, specSerial, CipherSuiteAes128Ccm, &memCounterStore{n: math.MaxUint32 - 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Seal(EnvelopeMethodPing, []byte("sensor-01")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Seal(EnvelopeMethodPing, []byte("sensor-01")); !errors.Is(err, ErrCounterExhausted) {
+			t.Fatalf("got %v", err)
+		}
+	}
+	if s.Counter() != math.MaxUint32 {
+		t.Errorf("counter %d", s.Counter())
+	}
+}
+
+func TestSecureSessionConcurrentSeal(t *testing.T) {
+	s, err := NewSecureSession(specToken, specSerial, CipherSuiteAes128Ccm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	seen := make(map[uint32]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				envelope, err := s.Seal(EnvelopeMethodPing, []byte("sensor-01"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				h, _ := ParseEnvelopeHeader(envelope)
+				mu.Lock()
+				if seen[h.Counter] {
+					t.Errorf("counter %d reused", h.Counter)
+				}
+				seen[h.Counter] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 400 {
+		t.Errorf("%d counters", len(seen))
+	}
+}
+
+func TestSecureSessionOpen(t *testing.T) {
+	s, err := NewSecureSession(specToken, specSerial, CipherSuiteAes128Ccm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := SealDownlink([]byte("OK|2"), 5, s.authHash, s.deviceHash, sessionKey(t), CipherSuiteAes128Ccm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, frame, err := s.Open(envelope)
+	if err != nil || header.Counter != 5 || frame.Detail.Count != 2 {
+		t.Errorf("opened %+v, %+v, %v", header, frame, err)
+	}
+	if _, err := NewSecureSession(specToken, specSerial, CipherSuite(7), nil); !IsSecureError(err) {
+		t.Errorf("unknown suite: %v", err)
+	}
+}
+
+// sessionKey returns the key a session derives for specToken and specSerial.
+func sessionKey(t *testing.T) []byte {
+	t.Helper()
+	key, err := DeriveKey(specToken, specSerial, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
@@ -0,0 +1,188 @@
+package tagotip
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ---------------------------------------------------------------------------
+// Signed plaintext frames
+// ---------------------------------------------------------------------------
+//
+// Devices that can compute SHA-256 but not AES-CCM may sign plaintext
+// uplinks to get integrity without confidentiality. A signed frame is the
+// frame followed by one more field holding a truncated HMAC-SHA256:
+//
+//	PUSH|!42|<auth>|<serial>|[temp:=21.5]|~3f9a0c17d2e4b861
+//
+// The MAC is computed over every byte before "|~", that is the frame as it
+// would be sent unsigned, without a trailing newline, and its first
+// SignatureLen bytes are written as lowercase hex. The key is a device
+// secret, for example derived with DeriveKey from a token provisioned on
+// the device. It must not be derived from the auth token the frame carries,
+// which travels in clear.
+//
+// Replay rule: a signed frame must carry a sequence counter, and a
+// verifier accepts it only if the counter is strictly greater than the
+// last counter it accepted from the same serial. Since the counter is part
+// of the signed bytes, a captured frame cannot be replayed, nor its counter
+// changed, without failing verification. Devices must therefore persist
+// their counter across restarts, as for secure envelopes.
+
+// SignatureLen is the length in bytes of the truncated HMAC of a signed
+// frame; it is written as twice as many hex characters.
+const SignatureLen = 8
+
+// signaturePrefix separates a signature from the frame it signs.
+const signaturePrefix = "|~"
+
+var (
+	// ErrMissingSignature is returned when a frame has no signature field,
+	// or a signed frame has no sequence counter.
+	ErrMissingSignature = errors.New("tagotip: frame is not signed with a sequence counter")
+	// ErrBadSignature is returned when a signature does not match the frame.
+	ErrBadSignature = errors.New("tagotip: frame signature does not match")
+	// ErrReplayedFrame is returned when a signed frame's counter is not
+	// greater than the last one accepted from its serial.
+	ErrReplayedFrame = errors.New("tagotip: signed frame counter was already used")
+)
+
+// SignUplink appends the signature field to frame. A trailing newline is
+// kept at the end of the signed frame. The frame should carry a sequence
+// counter, without which verifiers reject it.
+func SignUplink(frame string, key []byte) string {
+	body, nl := strings.CutSuffix(frame, "\n")
+	var b strings.Builder
+	b.Grow(len(frame) + len(signaturePrefix) + 2*SignatureLen)
+	b.WriteString(body)
+	b.WriteString(signaturePrefix)
+	b.WriteString(hex.EncodeToString(frameMAC(body, key)))
+	if nl {
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// VerifyUplink checks the signature of a signed frame and returns the frame
+// without it, keeping a trailing newline. It checks that the frame carries
+// a sequence counter but, holding no state, cannot enforce the replay rule;
+// use a SignatureVerifier for that.
+func VerifyUplink(signed string, key []byte) (string, error) {
+	frame, _, err := verifySignature(signed, key)
+	return frame, err
+}
+
+func frameMAC(frame string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(frame))
+	return mac.Sum(nil)[:SignatureLen]
+}
+
+// splitSignature splits a signed frame into the signed bytes, the hex
+// signature, and whether a trailing newline followed.
+func splitSignature(signed string) (frame, sig string, nl, ok bool) {
+	body, nl := strings.CutSuffix(signed, "\n")
+	i := strings.LastIndex(body, signaturePrefix)
+	if i < 0 || len(body)-i-len(signaturePrefix) != 2*SignatureLen {
+		return "", "", false, false
+	}
+	// An odd run of backslashes before the separator escapes it.
+	n := 0
+	for j := i - 1; j >= 0 && body[j] == '\\'; j-- {
+		n++
+	}
+	if n%2 == 1 {
+		return "", "", false, false
+	}
+	return body[:i], body[i+len(signaturePrefix):], nl, true
+}
+
+// verifySignature checks signed against key and returns the unsigned
+// frame and the hex signature.
+func verifySignature(signed string, key []byte) (frame, sig string, err error) {
+	body, sig, nl, ok := splitSignature(signed)
+	if !ok {
+		return "", "", ErrMissingSignature
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, frameMAC(body, key)) {
+		return "", "", ErrBadSignature
+	}
+	if _, _, hasSeq := signedHeader(body); !hasSeq {
+		return "", "", ErrMissingSignature
+	}
+	if nl {
+		return body + "\n", sig, nil
+	}
+	return body, sig, nil
+}
+
+// signedHeader returns the serial and sequence counter of an uplink frame
+// from its header fields, without validating them.
+func signedHeader(frame string) (serial string, seq uint32, hasSeq bool) {
+	var buf [maxFields]string
+	fields := appendFields(buf[:0], frame)
+	serialIdx := 2
+	if len(fields) > 1 && strings.HasPrefix(fields[1], "!") {
+		if s, err := parseSeq(fields[1], 0); err == nil {
+			seq, hasSeq = s, true
+		}
+		serialIdx = 3
+	}
+	if len(fields) > serialIdx {
+		serial = fields[serialIdx]
+	}
+	return serial, seq, hasSeq
+}
+
+// SignatureVerifier verifies signed frames and enforces the replay rule,
+// remembering the last counter accepted from each serial. It holds one
+// entry per signing device and is safe for concurrent use.
+type SignatureVerifier struct {
+	key func(serial string) []byte
+
+	mu   sync.Mutex
+	last map[string]uint32
+}
+
+// NewSignatureVerifier creates a verifier that looks up the signing key of
+// each device with key, which returns nil for an unknown serial.
+func NewSignatureVerifier(key func(serial string) []byte) *SignatureVerifier {
+	return &SignatureVerifier{key: key, last: make(map[string]uint32)}
+}
+
+// Verify checks the signature and counter of a signed frame and returns the
+// frame without its signature, keeping a trailing newline. The counter is
+// recorded only when the frame is accepted.
+func (v *SignatureVerifier) Verify(signed string) (string, error) {
+	frame, _, err := v.verify(signed)
+	return frame, err
+}
+
+func (v *SignatureVerifier) verify(signed string) (frame, sig string, err error) {
+	body, _, _, ok := splitSignature(signed)
+	if !ok {
+		return "", "", ErrMissingSignature
+	}
+	serial, seq, _ := signedHeader(body)
+	key := v.key(serial)
+	if key == nil {
+		return "", "", ErrBadSignature
+	}
+	frame, sig, err = verifySignature(signed, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if last, seen := v.last[serial]; seen && seq <= last {
+		return "", "", ErrReplayedFrame
+	}
+	v.last[serial] = seq
+	return frame, sig, nil
+}
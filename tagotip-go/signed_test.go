@@ -0,0 +1,157 @@
+package tagotip
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testSigningKey, _ = DeriveKey("at"+strings.Repeat("5a", 16), "dev", 32)
+
+func signingKeys(serial string) []byte {
+	if serial == "dev" {
+		return testSigningKey
+	}
+	return nil
+}
+
+// =========================================================================
+// SignUplink / VerifyUplink
+// =========================================================================
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	for _, frame := range []string{
+		"PUSH|!1|" + testAuth + "|dev|[temp:=21.5#C]",
+		"PUSH|!2|" + testAuth + "|dev|[msg=a\\|b]\n",
+		"PING|!3|" + testAuth + "|dev",
+	} {
+		signed := SignUplink(frame, testSigningKey)
+		body := strings.TrimSuffix(frame, "\n")
+		if !strings.HasPrefix(signed, body+"|~") || len(signed) != len(frame)+2+2*SignatureLen {
+			t.Errorf("%q: signed as %q", frame, signed)
+		}
+		if strings.HasSuffix(frame, "\n") != strings.HasSuffix(signed, "\n") {
+			t.Errorf("%q: trailing newline not kept: %q", frame, signed)
+		}
+		got, err := VerifyUplink(signed, testSigningKey)
+		if err != nil || got != frame {
+			t.Errorf("%q: verified as %q, %v", frame, got, err)
+		}
+	}
+}
+
+func TestVerifyUplinkDetectsTampering(t *testing.T) {
+	signed := SignUplink("PUSH|!7|"+testAuth+"|dev|[temp:=21.5]", testSigningKey)
+	cases := map[string]string{
+		"value":     strings.Replace(signed, "21.5", "91.5", 1),
+		"counter":   strings.Replace(signed, "!7", "!8", 1),
+		"signature": signed[:len(signed)-1] + flipHex(signed[len(signed)-1]),
+		"not hex":   signed[:len(signed)-1] + "z",
+	}
+	for name, tampered := range cases {
+		if _, err := VerifyUplink(tampered, testSigningKey); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: got %v, want ErrBadSignature", name, err)
+		}
+	}
+	otherKey, _ := DeriveKey("at"+strings.Repeat("a5", 16), "dev", 32)
+	if _, err := VerifyUplink(signed, otherKey); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("wrong key: got %v", err)
+	}
+}
+
+func TestVerifyUplinkRequiresSignatureAndCounter(t *testing.T) {
+	unsigned := "PUSH|!7|" + testAuth + "|dev|[temp:=21.5]"
+	for _, input := range []string{
+		unsigned,
+		unsigned + "|~abc",
+		"PUSH|!7|" + testAuth + "|dev|[msg=x\\|~0011223344556677]",
+		SignUplink("PUSH|"+testAuth+"|dev|[temp:=21.5]", testSigningKey),
+	} {
+		if _, err := VerifyUplink(input, testSigningKey); !errors.Is(err, ErrMissingSignature) {
+			t.Errorf("%q: got %v, want ErrMissingSignature", input, err)
+		}
+	}
+}
+
+// flipHex returns a hex digit other than c.
+func flipHex(c byte) string {
+	if c == '0' {
+		return "1"
+	}
+	return "0"
+}
+
+// =========================================================================
+// SignatureVerifier
+// =========================================================================
+
+func TestSignatureVerifierReplay(t *testing.T) {
+	v := NewSignatureVerifier(signingKeys)
+	sign := func(seq string) string {
+		return SignUplink("PUSH|!"+seq+"|"+testAuth+"|dev|[temp:=21.5]", testSigningKey)
+	}
+	if _, err := v.Verify(sign("5")); err != nil {
+		t.Fatal(err)
+	}
+	for _, seq := range []string{"5", "4", "0"} {
+		if _, err := v.Verify(sign(seq)); !errors.Is(err, ErrReplayedFrame) {
+			t.Errorf("counter %s: got %v, want ErrReplayedFrame", seq, err)
+		}
+	}
+	if _, err := v.Verify(sign("9")); err != nil {
+		t.Errorf("counter 9: %v", err)
+	}
+
+	// A rejected frame does not move the counter.
+	tampered := strings.Replace(sign("20"), "21.5", "0", 1)
+	if _, err := v.Verify(tampered); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("tampered: %v", err)
+	}
+	if _, err := v.Verify(sign("10")); err != nil {
+		t.Errorf("counter 10 after rejected 20: %v", err)
+	}
+}
+
+func TestSignatureVerifierUnknownDevice(t *testing.T) {
+	v := NewSignatureVerifier(signingKeys)
+	signed := SignUplink("PUSH|!1|"+testAuth+"|other|[a:=1]", testSigningKey)
+	if _, err := v.Verify(signed); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("got %v, want ErrBadSignature", err)
+	}
+}
+
+func TestParseSignedFrame(t *testing.T) {
+	frame := "PUSH|!1|" + testAuth + "|dev|[temp:=21.5#C]"
+	signed := SignUplink(frame, testSigningKey)
+	opts := &ParserOptions{Verifier: NewSignatureVerifier(signingKeys)}
+	f, err := ParseUplinkWithOptions(signed, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Signature != signed[len(frame)+2:] || f.PushBody.Structured.Variables[0].Name != "temp" {
+		t.Errorf("parsed %+v", f)
+	}
+	if raw, _ := BuildUplink(f); raw != frame {
+		t.Errorf("rebuilt as %q", raw)
+	}
+	if _, err := ParseUplinkWithOptions(signed, opts); !errors.Is(err, ErrReplayedFrame) {
+		t.Errorf("replay: got %v", err)
+	}
+	if _, err := ParseUplinkWithOptions(frame, opts); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("unsigned: got %v", err)
+	}
+}
+
+func TestUnsignedParsingUnaffected(t *testing.T) {
+	frame := "PUSH|!1|" + testAuth + "|dev|[temp:=21.5]"
+	f, err := ParseUplink(frame)
+	if err != nil || f.Signature != "" {
+		t.Fatalf("got %+v, %v", f, err)
+	}
+	// Without a verifier the signature is not interpreted: it lands in a
+	// field PUSH does not read.
+	f, err = ParseUplink(SignUplink(frame, testSigningKey))
+	if err != nil || f.Signature != "" {
+		t.Errorf("signed frame without verifier: %+v, %v", f, err)
+	}
+}
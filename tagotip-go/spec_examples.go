@@ -0,0 +1,204 @@
+package tagotip
+
+// SpecExample is one worked example from the protocol specification: the
+// wire form of a frame and the structure it parses to. Exactly one of
+// Uplink and Ack is set.
+type SpecExample struct {
+	Label  string // section and title, e.g. "§11.1 Simple Push"
+	Raw    string
+	Uplink *UplinkFrame
+	Ack    *AckFrame
+}
+
+// placeholderAuth is a well-formed authorization token that belongs to no
+// one, used wherever a real token must not appear.
+const placeholderAuth = "at0123456789abcdef0123456789abcdef"
+
+// Spec11Examples returns the frames of specification §11 and the ACK
+// examples of §9.3, §11.13, and §11.14, each with its fully populated
+// parsed form. Every call returns fresh values that callers may modify.
+func Spec11Examples() []SpecExample {
+	const auth = placeholderAuth
+	str := func(s string) *string { return &s }
+	u32 := func(n uint32) *uint32 { return &n }
+	num := func(name, v string) Variable {
+		return Variable{Name: name, Operator: OperatorNumber, Value: Value{Type: OperatorNumber, Str: v}}
+	}
+	withUnit := func(v Variable, unit string) Variable { v.Unit = &unit; return v }
+	withTime := func(v Variable, ts string) Variable { v.Timestamp = &ts; return v }
+	push := func(sb *StructuredBody) *PushBody { return &PushBody{Structured: sb} }
+	ackVars := func(text string, vars ...Variable) *AckDetail {
+		return &AckDetail{Type: "variables", Text: text, Vars: &VariablesDetail{Variables: vars}, Variables: vars}
+	}
+
+	return []SpecExample{
+		{
+			Label: "§11.1 Simple Push",
+			Raw:   "PUSH|" + auth + "|weather_denver|[temperature:=32;humidity:=65]",
+			Uplink: &UplinkFrame{Method: MethodPush, Auth: auth, Serial: "weather_denver",
+				PushBody: push(&StructuredBody{Variables: []Variable{num("temperature", "32"), num("humidity", "65")}})},
+		},
+		{
+			Label: "§11.2 Push with Sequence Counter",
+			Raw:   "PUSH|!1|" + auth + "|weather_denver|[temperature:=32;humidity:=65]",
+			Uplink: &UplinkFrame{Method: MethodPush, Seq: u32(1), Auth: auth, Serial: "weather_denver",
+				PushBody: push(&StructuredBody{Variables: []Variable{num("temperature", "32"), num("humidity", "65")}})},
+		},
+		{
+			Label: "§11.3 Typed Values",
+			Raw:   "PUSH|" + auth + "|sensor_0a1f|[temperature:=32.5#C;status=online;active?=true]",
+			Uplink: &UplinkFrame{Method: MethodPush, Auth: auth, Serial: "sensor_0a1f",
+				PushBody: push(&StructuredBody{Variables: []Variable{
+					withUnit(num("temperature", "32.5"), "C"),
+					{Name: "status", Operator: OperatorString, Value: Value{Type: OperatorString, Str: "online"}},
+					{Name: "active", Operator: OperatorBoolean, Value: Value{Type: OperatorBoolean, Bool: true}},
+				}})},
+		},
+		{
+			Label: "§11.3 Negative Number",
+			Raw:   "PUSH|" + auth + "|sensor_0a1f|[temperature:=-15.3#C]",
+			Uplink: &UplinkFrame{Method: MethodPush, Auth: auth, Serial: "sensor_0a1f",
+				PushBody: push(&StructuredBody{Variables: []Variable{withUnit(num("temperature", "-15.3"), "C")}})},
+		},
+		{
+			Label: "§11.4 Location and Altitude",
+			Raw:   "PUSH|" + auth + "|drone_07|[altitude:=305#m;position@=39.74,-104.99,305]",
+			Uplink: &UplinkFrame{Method: MethodPush, Auth: auth, Serial: "drone_07",
+				PushBody: push(&StructuredBody{Variables: []Variable{
+					withUnit(num("altitude", "305"), "m"),
+					{Name: "position", Operator: OperatorLocation, Value: Value{Type: OperatorLocation,
+						Location: &LocationValue{Lat: "39.74", Lng: "-104.99", Alt: str("305")}}},
+				}})},
+		},
+		{
+			Label: "§11.5 With Metadata",
+			Raw:   "PUSH|" + auth + "|sensor_01|[temperature:=32{source=dht22,quality=high}]",
+			Uplink: &UplinkFrame{Method: MethodPush, Auth: auth, Serial: "sensor_01",
+				PushBody: push(&StructuredBody{Variables: []Variable{{
+					Name: "temperature", Operator: OperatorNumber, Value: Value{Type: OperatorNumber, Str: "32"},
+					Meta: []MetaPair{{Key: "source", Value: "dht22"}, {Key: "quality", Value: "high"}},
+				}}})},
+		},
+		{
+			Label: "§11.6 Body-Level Defaults",
+			Raw:   "PUSH|" + auth + "|sensor_01|@1694567890000^batch_42{firmware=2.1}[temperature:=32#C;humidity:=65#%]",
+			Uplink: &UplinkFrame{Method: MethodPush, Auth: auth, Serial: "sensor_01",
+				PushBody: push(&StructuredBody{
+					Timestamp: str("1694567890000"),
+					Group:     str("batch_42"),
+					Meta:      []MetaPair{{Key: "firmware", Value: "2.1"}},
+					Variables: []Variable{withUnit(num("temperature", "32"), "C"), withUnit(num("humidity", "65"), "%")},
+				})},
+		},
+		{
+			Label: "§11.7 Variable-Level Timestamps (Datalogger)",
+			Raw:   "PUSH|" + auth + "|datalogger_7|[temp:=32@1694567890000;temp:=33@1694567900000;temp:=31@1694567910000]",
+			Uplink: &UplinkFrame{Method: MethodPush, Auth: auth, Serial: "datalogger_7",
+				PushBody: push(&StructuredBody{Variables: []Variable{
+					withTime(num("temp", "32"), "1694567890000"),
+					withTime(num("temp", "33"), "1694567900000"),
+					withTime(num("temp", "31"), "1694567910000"),
+				}})},
+		},
+		{
+			Label: "§11.8 Passthrough (Hex)",
+			Raw:   "PUSH|" + auth + "|sensor_01|>xDEADBEEF01020304",
+			Uplink: &UplinkFrame{Method: MethodPush, Auth: auth, Serial: "sensor_01",
+				PushBody: &PushBody{IsPassthrough: true,
+					Passthrough: &PassthroughBody{Encoding: PassthroughEncodingHex, Data: "DEADBEEF01020304"}}},
+		},
+		{
+			Label: "§11.9 Passthrough (Base64)",
+			Raw:   "PUSH|" + auth + "|sensor_01|>b3q2+7wECAwQ=",
+			Uplink: &UplinkFrame{Method: MethodPush, Auth: auth, Serial: "sensor_01",
+				PushBody: &PushBody{IsPassthrough: true,
+					Passthrough: &PassthroughBody{Encoding: PassthroughEncodingBase64, Data: "3q2+7wECAwQ="}}},
+		},
+		{
+			Label: "§11.10 Retrieve Last Value",
+			Raw:   "PULL|" + auth + "|weather_denver|[temperature]",
+			Uplink: &UplinkFrame{Method: MethodPull, Auth: auth, Serial: "weather_denver",
+				PullBody: &PullBody{Variables: []string{"temperature"}}},
+		},
+		{
+			Label: "§11.11 Retrieve Last Value with Sequence Counter",
+			Raw:   "PULL|!7|" + auth + "|weather_denver|[temperature]",
+			Uplink: &UplinkFrame{Method: MethodPull, Seq: u32(7), Auth: auth, Serial: "weather_denver",
+				PullBody: &PullBody{Variables: []string{"temperature"}}},
+		},
+		{
+			Label:  "§11.12 Keepalive",
+			Raw:    "PING|" + auth + "|sensor_01",
+			Uplink: &UplinkFrame{Method: MethodPing, Auth: auth, Serial: "sensor_01"},
+		},
+		{
+			Label:  "§11.13 Conversation: PING",
+			Raw:    "PING|" + auth + "|weather_denver",
+			Uplink: &UplinkFrame{Method: MethodPing, Auth: auth, Serial: "weather_denver"},
+		},
+		{
+			Label: "§11.13 Conversation: PUSH",
+			Raw:   "PUSH|" + auth + "|weather_denver|[temperature:=32#F;humidity:=65#%;active?=true]",
+			Uplink: &UplinkFrame{Method: MethodPush, Auth: auth, Serial: "weather_denver",
+				PushBody: push(&StructuredBody{Variables: []Variable{
+					withUnit(num("temperature", "32"), "F"),
+					withUnit(num("humidity", "65"), "%"),
+					{Name: "active", Operator: OperatorBoolean, Value: Value{Type: OperatorBoolean, Bool: true}},
+				}})},
+		},
+		{
+			Label: "§11.13 Conversation: PULL",
+			Raw:   "PULL|" + auth + "|weather_denver|[temperature]",
+			Uplink: &UplinkFrame{Method: MethodPull, Auth: auth, Serial: "weather_denver",
+				PullBody: &PullBody{Variables: []string{"temperature"}}},
+		},
+		{
+			Label:  "§11.14 Sequenced Conversation: PING",
+			Raw:    "PING|!1|" + auth + "|weather_denver",
+			Uplink: &UplinkFrame{Method: MethodPing, Seq: u32(1), Auth: auth, Serial: "weather_denver"},
+		},
+		{
+			Label: "§11.14 Sequenced Conversation: PUSH",
+			Raw:   "PUSH|!2|" + auth + "|weather_denver|[temperature:=32#F]",
+			Uplink: &UplinkFrame{Method: MethodPush, Seq: u32(2), Auth: auth, Serial: "weather_denver",
+				PushBody: push(&StructuredBody{Variables: []Variable{withUnit(num("temperature", "32"), "F")}})},
+		},
+		{
+			Label: "§11.14 Sequenced Conversation: second PUSH",
+			Raw:   "PUSH|!3|" + auth + "|weather_denver|[humidity:=65#%]",
+			Uplink: &UplinkFrame{Method: MethodPush, Seq: u32(3), Auth: auth, Serial: "weather_denver",
+				PushBody: push(&StructuredBody{Variables: []Variable{withUnit(num("humidity", "65"), "%")}})},
+		},
+		{
+			Label: "§11.14 Sequenced Conversation: replayed counter",
+			Raw:   "PUSH|!2|" + auth + "|weather_denver|[pressure:=1013#hPa]",
+			Uplink: &UplinkFrame{Method: MethodPush, Seq: u32(2), Auth: auth, Serial: "weather_denver",
+				PushBody: push(&StructuredBody{Variables: []Variable{withUnit(num("pressure", "1013"), "hPa")}})},
+		},
+
+		{Label: "§9.3 ACK: OK with count", Raw: "ACK|OK|2",
+			Ack: &AckFrame{Status: AckStatusOk, Detail: &AckDetail{Type: "count", Count: 2}}},
+		{Label: "§9.3 ACK: OK with variables", Raw: "ACK|OK|[temperature:=32#F@1694567890000]",
+			Ack: &AckFrame{Status: AckStatusOk, Detail: ackVars("[temperature:=32#F@1694567890000]", withTime(withUnit(num("temperature", "32"), "F"), "1694567890000"))}},
+		{Label: "§9.3 ACK: PONG", Raw: "ACK|PONG",
+			Ack: &AckFrame{Status: AckStatusPong}},
+		{Label: "§9.3 ACK: command", Raw: "ACK|CMD|reboot",
+			Ack: &AckFrame{Status: AckStatusCmd, Detail: &AckDetail{Type: "command", Text: "reboot"}}},
+		{Label: "§9.3 ACK: invalid token", Raw: "ACK|ERR|invalid_token",
+			Ack: &AckFrame{Status: AckStatusErr, Detail: &AckDetail{Type: "error", Text: "invalid_token", ErrorCode: ErrorCodeInvalidToken}}},
+		{Label: "§9.3 ACK: invalid payload", Raw: "ACK|ERR|invalid_payload",
+			Ack: &AckFrame{Status: AckStatusErr, Detail: &AckDetail{Type: "error", Text: "invalid_payload", ErrorCode: ErrorCodeInvalidPayload}}},
+		{Label: "§11.14 ACK: OK with count", Raw: "ACK|!1|OK|2",
+			Ack: &AckFrame{Seq: u32(1), Status: AckStatusOk, Detail: &AckDetail{Type: "count", Count: 2}}},
+		{Label: "§11.14 ACK: OK with variables", Raw: "ACK|!2|OK|[temperature:=32#F@1694567890000]",
+			Ack: &AckFrame{Seq: u32(2), Status: AckStatusOk, Detail: ackVars("[temperature:=32#F@1694567890000]", withTime(withUnit(num("temperature", "32"), "F"), "1694567890000"))}},
+		{Label: "§11.14 ACK: PONG", Raw: "ACK|!3|PONG",
+			Ack: &AckFrame{Seq: u32(3), Status: AckStatusPong}},
+		{Label: "§11.14 ACK: invalid token", Raw: "ACK|!5|ERR|invalid_token",
+			Ack: &AckFrame{Seq: u32(5), Status: AckStatusErr, Detail: &AckDetail{Type: "error", Text: "invalid_token", ErrorCode: ErrorCodeInvalidToken}}},
+		{Label: "§11.14 ACK: invalid seq", Raw: "ACK|!6|ERR|invalid_seq",
+			Ack: &AckFrame{Seq: u32(6), Status: AckStatusErr, Detail: &AckDetail{Type: "error", Text: "invalid_seq", ErrorCode: ErrorCodeInvalidSeq}}},
+		{Label: "§11.14 ACK: invalid payload", Raw: "ACK|!7|ERR|invalid_payload",
+			Ack: &AckFrame{Seq: u32(7), Status: AckStatusErr, Detail: &AckDetail{Type: "error", Text: "invalid_payload", ErrorCode: ErrorCodeInvalidPayload}}},
+	}
+}
@@ -0,0 +1,107 @@
+package tagotip
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// ============================================================================
+// Spec §11 examples
+// ============================================================================
+
+func TestSpec11ExamplesParse(t *testing.T) {
+	for _, ex := range Spec11Examples() {
+		t.Run(ex.Label, func(t *testing.T) {
+			var got, want any
+			var err error
+			if ex.Uplink != nil {
+				got, err = ParseUplink(ex.Raw)
+				want = ex.Uplink
+			} else {
+				got, err = ParseAck(ex.Raw)
+				want = ex.Ack
+			}
+			if err != nil {
+				t.Fatalf("parse %q: %v", ex.Raw, err)
+			}
+			if path := diffExported(reflect.ValueOf(want), reflect.ValueOf(got), "frame"); path != "" {
+				t.Errorf("parse %q: mismatch at %s\n  want: %+v\n  got:  %+v", ex.Raw, path, want, got)
+			}
+		})
+	}
+}
+
+func TestSpec11ExamplesBuild(t *testing.T) {
+	for _, ex := range Spec11Examples() {
+		t.Run(ex.Label, func(t *testing.T) {
+			var got string
+			var err error
+			if ex.Uplink != nil {
+				got, err = BuildUplink(ex.Uplink)
+			} else {
+				got, err = BuildAck(ex.Ack)
+			}
+			if err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			if got != ex.Raw {
+				t.Errorf("build:\n  want: %s\n  got:  %s", ex.Raw, got)
+			}
+		})
+	}
+}
+
+func TestSpec11ExamplesFresh(t *testing.T) {
+	a := Spec11Examples()
+	a[0].Uplink.PushBody.Structured.Variables[0].Name = "changed"
+	if b := Spec11Examples(); b[0].Uplink.PushBody.Structured.Variables[0].Name == "changed" {
+		t.Error("Spec11Examples returned shared values")
+	}
+}
+
+// diffExported compares want and got through their exported fields only,
+// since parsed frames also carry unexported storage kept for reuse. It
+// returns the path of the first difference, or "" if there is none.
+func diffExported(want, got reflect.Value, path string) string {
+	if want.Kind() != got.Kind() {
+		return path
+	}
+	switch want.Kind() {
+	case reflect.Pointer:
+		if want.IsNil() || got.IsNil() {
+			if want.IsNil() != got.IsNil() {
+				return path
+			}
+			return ""
+		}
+		return diffExported(want.Elem(), got.Elem(), path)
+	case reflect.Interface:
+		return diffExported(want.Elem(), got.Elem(), path)
+	case reflect.Struct:
+		for i := 0; i < want.NumField(); i++ {
+			f := want.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if p := diffExported(want.Field(i), got.Field(i), path+"."+f.Name); p != "" {
+				return p
+			}
+		}
+		return ""
+	case reflect.Slice:
+		if want.Len() != got.Len() {
+			return path + " (length)"
+		}
+		for i := 0; i < want.Len(); i++ {
+			if p := diffExported(want.Index(i), got.Index(i), path+"["+strconv.Itoa(i)+"]"); p != "" {
+				return p
+			}
+		}
+		return ""
+	}
+	if !reflect.DeepEqual(want.Interface(), got.Interface()) {
+		return path
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package tagotip
+
+// ---------------------------------------------------------------------------
+// Frame statistics
+// ---------------------------------------------------------------------------
+
+// numOperators is the number of Operator values, for per-operator tables.
+const numOperators = int(OperatorLocation) + 1
+
+// FrameStats summarizes the size and shape of one uplink frame.
+type FrameStats struct {
+	// Variables is the number of variables in a PUSH body, or of names in
+	// a PULL body.
+	Variables int
+	// ByOperator counts the variables of a PUSH body by operator, indexed
+	// by Operator.
+	ByOperator [numOperators]int
+	// MetaPairs and MetaBytes count the metadata of the body and its
+	// variables, and the diagnostics of a PING; MetaBytes is the wire size
+	// of the blocks, braces included.
+	MetaPairs int
+	MetaBytes int
+	// MaxValueLen is the wire length of the longest variable value.
+	MaxValueLen int
+	// Passthrough reports whether the frame carries a passthrough body.
+	Passthrough bool
+	// WireSize is the length of the frame as built by
+	// BuildUplinkUnchecked, which also measures frames over MaxFrameSize.
+	WireSize int
+}
+
+// Stats computes the statistics of frame. Metadata held lazily (see
+// ParserOptions.LazyMeta) is parsed in place to be counted.
+func Stats(frame *UplinkFrame) FrameStats {
+	var s FrameStats
+	if raw, err := BuildUplinkUnchecked(frame); err == nil {
+		s.WireSize = len(raw)
+	}
+	if pb := frame.PushBody; pb != nil {
+		if pb.IsPassthrough && pb.Passthrough != nil {
+			s.Passthrough = true
+		} else if sb := pb.Structured; sb != nil {
+			s.addMeta(sb.Metadata())
+			s.Variables = len(sb.Variables)
+			for i := range sb.Variables {
+				v := &sb.Variables[i]
+				if int(v.Operator) < numOperators {
+					s.ByOperator[v.Operator]++
+				}
+				s.MaxValueLen = max(s.MaxValueLen, valueWireLen(v))
+				s.addMeta(v.Metadata())
+			}
+		}
+	}
+	if frame.PullBody != nil {
+		s.Variables = len(frame.PullBody.Variables)
+	}
+	s.addMeta(frame.PingDiagnostics)
+	return s
+}
+
+func (s *FrameStats) addMeta(pairs []MetaPair) {
+	s.MetaPairs += len(pairs)
+	s.MetaBytes += metaSize(pairs, "")
+}
+
+// valueWireLen returns the length of v's value as written by writeValue,
+// without the operator.
+func valueWireLen(v *Variable) int {
+	switch v.Operator {
+	case OperatorBoolean:
+		if v.Value.Bool {
+			return len("true")
+		}
+		return len("false")
+	case OperatorLocation:
+		if loc := v.Value.Location; loc != nil {
+			return len(loc.Lat) + 1 + len(loc.Lng) + optLen(loc.Alt)
+		}
+		return 0
+	}
+	return escapedLen(v.Value.Str)
+}
+
+// StatsWireSizeBuckets are the inclusive upper bounds of the wire size
+// histogram buckets of AggregateStats. Larger frames fall in the last bucket.
+var StatsWireSizeBuckets = [...]int{64, 128, 256, 512, 1024, 2048, 4096, 8192, MaxFrameSize}
+
+// StatsVariableBuckets are the inclusive upper bounds of the variable count
+// histogram buckets of AggregateStats. Larger counts fall in the last bucket.
+var StatsVariableBuckets = [...]int{0, 1, 2, 5, 10, 20, 50, MaxVariables}
+
+// AggregateStats aggregates FrameStats over many frames. The zero value is
+// an empty aggregate.
+type AggregateStats struct {
+	Frames      int
+	Passthrough int // frames with a passthrough body
+	Variables   int
+	ByOperator  [numOperators]int
+	MetaPairs   int
+	MetaBytes   int
+	WireBytes   int
+	MaxValueLen int
+	MaxWireSize int
+
+	// WireSizes[i] counts the frames whose wire size is at most
+	// StatsWireSizeBuckets[i] and above the previous bound; VariableCount[i]
+	// likewise for StatsVariableBuckets.
+	WireSizes     [len(StatsWireSizeBuckets)]int
+	VariableCount [len(StatsVariableBuckets)]int
+}
+
+// AccumulateStats adds the statistics of frame to agg. Only the counters in
+// agg are updated, so frame may be reused or discarded afterwards.
+func AccumulateStats(agg *AggregateStats, frame *UplinkFrame) {
+	agg.Add(Stats(frame))
+}
+
+// Add adds the statistics of one frame to a.
+func (a *AggregateStats) Add(s FrameStats) {
+	a.Frames++
+	if s.Passthrough {
+		a.Passthrough++
+	}
+	a.Variables += s.Variables
+	for i, n := range s.ByOperator {
+		a.ByOperator[i] += n
+	}
+	a.MetaPairs += s.MetaPairs
+	a.MetaBytes += s.MetaBytes
+	a.WireBytes += s.WireSize
+	a.MaxValueLen = max(a.MaxValueLen, s.MaxValueLen)
+	a.MaxWireSize = max(a.MaxWireSize, s.WireSize)
+	a.WireSizes[bucketIndex(StatsWireSizeBuckets[:], s.WireSize)]++
+	a.VariableCount[bucketIndex(StatsVariableBuckets[:], s.Variables)]++
+}
+
+// bucketIndex returns the first bucket whose upper bound is at least n, or
+// the last bucket.
+func bucketIndex(bounds []int, n int) int {
+	for i, b := range bounds {
+		if n <= b {
+			return i
+		}
+	}
+	return len(bounds) - 1
+}
@@ -0,0 +1,118 @@
+package tagotip
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// ============================================================================
+// Stats
+// ============================================================================
+
+func TestStatsSpecExamples(t *testing.T) {
+	want := map[string]FrameStats{
+		"§11.1 Simple Push":  {Variables: 2, ByOperator: [numOperators]int{2, 0, 0, 0}, MaxValueLen: 2},
+		"§11.3 Typed Values": {Variables: 3, ByOperator: [numOperators]int{1, 1, 1, 0}, MaxValueLen: 6},
+		"§11.4 Location and Altitude": {Variables: 2, ByOperator: [numOperators]int{1, 0, 0, 1},
+			MaxValueLen: len("39.74,-104.99,305")},
+		"§11.5 With Metadata": {Variables: 1, ByOperator: [numOperators]int{1, 0, 0, 0}, MaxValueLen: 2,
+			MetaPairs: 2, MetaBytes: len("{source=dht22,quality=high}")},
+		"§11.6 Body-Level Defaults": {Variables: 2, ByOperator: [numOperators]int{2, 0, 0, 0}, MaxValueLen: 2,
+			MetaPairs: 1, MetaBytes: len("{firmware=2.1}")},
+		"§11.8 Passthrough (Hex)":    {Passthrough: true},
+		"§11.10 Retrieve Last Value": {Variables: 1},
+		"§11.12 Keepalive":           {},
+	}
+	seen := 0
+	for _, ex := range Spec11Examples() {
+		w, ok := want[ex.Label]
+		if !ok {
+			continue
+		}
+		seen++
+		frame, err := ParseUplink(ex.Raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WireSize = len(ex.Raw)
+		if got := Stats(frame); got != w {
+			t.Errorf("%s:\n  got  %+v\n  want %+v", ex.Label, got, w)
+		}
+	}
+	if seen != len(want) {
+		t.Errorf("matched %d of %d examples", seen, len(want))
+	}
+}
+
+func TestStatsLazyMeta(t *testing.T) {
+	raw := "PUSH|" + testAuth + "|dev|{a=1}[x:=1{b=2,c=3}]"
+	eager, _ := ParseUplink(raw)
+	lazy, err := ParseUplinkWithOptions(raw, &ParserOptions{LazyMeta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Stats(lazy) != Stats(eager) {
+		t.Errorf("lazy %+v, eager %+v", Stats(lazy), Stats(eager))
+	}
+}
+
+func TestAccumulateStats(t *testing.T) {
+	r := rand.New(rand.NewSource(4))
+	var agg AggregateStats
+	var want AggregateStats
+	var sizeBuckets, varBuckets int
+	for i := 0; i < 500; i++ {
+		f := (*UplinkFrame)(nil).Generate(r, MaxVariables).Interface().(*UplinkFrame)
+		s := Stats(f)
+		AccumulateStats(&agg, f)
+
+		want.Frames++
+		want.Variables += s.Variables
+		want.MetaBytes += s.MetaBytes
+		want.WireBytes += s.WireSize
+		if s.Passthrough {
+			want.Passthrough++
+		}
+		want.MaxWireSize = max(want.MaxWireSize, s.WireSize)
+		for op, n := range s.ByOperator {
+			want.ByOperator[op] += n
+		}
+	}
+	if agg.Frames != want.Frames || agg.Variables != want.Variables || agg.MetaBytes != want.MetaBytes ||
+		agg.WireBytes != want.WireBytes || agg.Passthrough != want.Passthrough ||
+		agg.MaxWireSize != want.MaxWireSize || agg.ByOperator != want.ByOperator {
+		t.Errorf("aggregate:\n  got  %+v\n  want %+v", agg, want)
+	}
+	for _, n := range agg.WireSizes {
+		sizeBuckets += n
+	}
+	for _, n := range agg.VariableCount {
+		varBuckets += n
+	}
+	if sizeBuckets != agg.Frames || varBuckets != agg.Frames {
+		t.Errorf("histograms hold %d and %d frames, want %d", sizeBuckets, varBuckets, agg.Frames)
+	}
+	if agg.Passthrough == 0 || agg.ByOperator[OperatorLocation] == 0 {
+		t.Errorf("generated corpus lacks variety: %+v", agg)
+	}
+}
+
+func TestAggregateStatsBuckets(t *testing.T) {
+	var agg AggregateStats
+	for _, s := range []FrameStats{
+		{WireSize: 64, Variables: 0},
+		{WireSize: 65, Variables: 1},
+		{WireSize: MaxFrameSize, Variables: MaxVariables},
+		{WireSize: MaxFrameSize + 1, Variables: MaxVariables + 1},
+	} {
+		agg.Add(s)
+	}
+	last := len(StatsWireSizeBuckets) - 1
+	if agg.WireSizes[0] != 1 || agg.WireSizes[1] != 1 || agg.WireSizes[last] != 2 {
+		t.Errorf("wire size histogram %v", agg.WireSizes)
+	}
+	lastVar := len(StatsVariableBuckets) - 1
+	if agg.VariableCount[0] != 1 || agg.VariableCount[1] != 1 || agg.VariableCount[lastVar] != 2 {
+		t.Errorf("variable histogram %v", agg.VariableCount)
+	}
+}
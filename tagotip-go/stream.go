@@ -0,0 +1,128 @@
+package tagotip
+
+import (
+	"errors"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Streaming variable access
+// ---------------------------------------------------------------------------
+//
+// ParseUplinkFunc and ParseUplinkBodyFunc hand the variables of a PUSH to a
+// callback one at a time, without building the frame or its Variables
+// slice; UplinkVariables offers the same as an iterator on Go 1.23 and
+// later.
+
+// ParseUplinkFunc parses a raw uplink frame, calling fn with each variable
+// of a structured PUSH body in turn. The same Variable, with the slices
+// and pointer targets it holds, is reused for every call, so fn must copy
+// what it keeps. An error from fn stops parsing and is returned as is.
+//
+// The header and body modifiers are validated before the first call; an
+// error in a variable is returned after fn has seen the variables before
+// it. Variables do not inherit body-level defaults, and frames other than
+// structured PUSH are validated without calling fn.
+func ParseUplinkFunc(input string, fn func(v *Variable) error) error {
+	return ParseUplinkBodyFunc(input, nil, fn)
+}
+
+// ParseUplinkBodyFunc is ParseUplinkFunc that first calls body, when not
+// nil, with the frame's header and its body modifiers: a StructuredBody
+// holding the body-level timestamp, group, and metadata, and no variables.
+func ParseUplinkBodyFunc(input string, body func(h *UplinkHeader, sb *StructuredBody) error, fn func(v *Variable) error) error {
+	p := newParser(nil)
+	return p.eachUplinkVariable(input, body, true, fn)
+}
+
+// errStopStream is returned by an eachUplinkVariable callback to stop
+// without error.
+var errStopStream = errors.New("tagotip: stop")
+
+// eachUplinkVariable parses input, calling fn with each variable of a
+// structured PUSH body in turn until it returns an error. When reuse is
+// set, every call gets the same Variable. head, when not nil, is called
+// with the header and body modifiers before the first variable.
+func (p *parser) eachUplinkVariable(input string, head func(*UplinkHeader, *StructuredBody) error, reuse bool, fn func(*Variable) error) error {
+	stripped, _, err := p.prepareUplink(input)
+	if err != nil {
+		return err
+	}
+	var buf [maxFields]string
+	fields := appendFields(buf[:0], stripped)
+	h, err := p.parseUplinkHeader(fields)
+	if err != nil {
+		return err
+	}
+	if h.method != MethodPush || (len(fields) > h.bodyIdx && strings.HasPrefix(fields[h.bodyIdx], ">")) {
+		// Nothing to stream: validate the frame as a whole.
+		var frame UplinkFrame
+		return p.parseUplinkInto(&frame, input)
+	}
+	if len(fields) <= h.bodyIdx {
+		return fail(ErrMissingBody, h.bodyPos)
+	}
+
+	body, basePos := fields[h.bodyIdx], h.bodyPos
+	bracketPos := findUnescapedChar(body, '[', 0)
+	if bracketPos == -1 {
+		return fail(ErrInvalidVarBlock, basePos)
+	}
+	endBracket := findClosingBracket(body, bracketPos+1)
+	if endBracket == -1 {
+		return fail(ErrInvalidVarBlock, basePos+bracketPos)
+	}
+	var sb StructuredBody
+	if err := p.parseBodyModifiers(&sb, body[:bracketPos], basePos); err != nil {
+		return err
+	}
+	if head != nil {
+		if err := head(h.export(), &sb); err != nil {
+			return err
+		}
+	}
+
+	block, blockPos := body[bracketPos+1:endBracket], basePos+bracketPos+1
+	var v *Variable
+	n := 0
+	for start := 0; start <= len(block); {
+		end := nextItem(block, start, ';')
+		if end > start {
+			if n >= MaxVariables {
+				return fail(ErrTooManyItems, blockPos+start)
+			}
+			if err := p.spend(blockPos + start); err != nil {
+				return err
+			}
+			if v == nil || !reuse {
+				v = &Variable{}
+			}
+			if err := p.parseVariable(v, block[start:end], blockPos+start); err != nil {
+				return err
+			}
+			n++
+			if err := fn(v); err != nil {
+				return err
+			}
+		}
+		start = end + 1
+	}
+	if n == 0 {
+		return fail(ErrInvalidVarBlock, basePos+bracketPos)
+	}
+	return nil
+}
+
+// nextItem returns the index of the first unescaped sep in s at or after
+// start, or len(s).
+func nextItem(s string, start int, sep byte) int {
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return i
+		}
+	}
+	return len(s)
+}
@@ -0,0 +1,107 @@
+package tagotip
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Streaming variable access
+// ============================================================================
+
+func TestParseUplinkFuncMatchesParse(t *testing.T) {
+	for _, raw := range loadCorpus(t) {
+		frame, parseErr := ParseUplink(raw)
+		var sb *StructuredBody
+		if parseErr == nil {
+			sb = structuredBody(frame)
+		}
+		i := 0
+		err := ParseUplinkFunc(raw, func(v *Variable) error {
+			got := *v
+			if len(got.Meta) == 0 {
+				got.Meta = nil // emptied for reuse
+			}
+			if sb == nil || i >= len(sb.Variables) || !reflect.DeepEqual(got, sb.Variables[i]) {
+				t.Errorf("%s: variable %d is %+v", raw, i, v)
+			}
+			i++
+			return nil
+		})
+		if !reflect.DeepEqual(err, parseErr) {
+			t.Errorf("%s: got %v, ParseUplink returns %v", raw, err, parseErr)
+		}
+		if sb != nil && i != len(sb.Variables) {
+			t.Errorf("%s: %d of %d variables", raw, i, len(sb.Variables))
+		}
+	}
+}
+
+func TestParseUplinkFuncReusesVariable(t *testing.T) {
+	raw := "PUSH|" + testAuth + "|dev|[a:=1#C{k=v};b:=2#F{k=w};c?=true]"
+	var seen []*Variable
+	var units []string
+	err := ParseUplinkFunc(raw, func(v *Variable) error {
+		seen = append(seen, v)
+		if v.Unit != nil {
+			units = append(units, *v.Unit)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 3 || seen[0] != seen[1] || seen[1] != seen[2] {
+		t.Errorf("variables not reused: %p", seen)
+	}
+	if !reflect.DeepEqual(units, []string{"C", "F"}) {
+		t.Errorf("units %q", units)
+	}
+}
+
+func TestParseUplinkFuncStopsOnError(t *testing.T) {
+	stop := errors.New("stop")
+	n := 0
+	err := ParseUplinkFunc("PUSH|"+testAuth+"|dev|[a:=1;b:=2;c:=x]", func(v *Variable) error {
+		n++
+		if v.Name == "b" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 2 {
+		t.Errorf("got %v after %d variables", err, n)
+	}
+}
+
+func TestParseUplinkBodyFunc(t *testing.T) {
+	raw := "PUSH|!9|" + testAuth + "|dev|@1700000000000^batch{fw=1.2}[a:=1;b=x]"
+	var order []string
+	err := ParseUplinkBodyFunc(raw, func(h *UplinkHeader, sb *StructuredBody) error {
+		if *h.Seq != 9 || h.Serial != "dev" || h.BodyOffset != strings.Index(raw, "@") {
+			t.Errorf("header %+v", h)
+		}
+		if *sb.Timestamp != "1700000000000" || *sb.Group != "batch" || sb.Meta[0] != (MetaPair{"fw", "1.2"}) || sb.Variables != nil {
+			t.Errorf("body %+v", sb)
+		}
+		order = append(order, "body")
+		return nil
+	}, func(v *Variable) error {
+		order = append(order, v.Name)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(order, []string{"body", "a", "b"}) {
+		t.Errorf("got %q, %v", order, err)
+	}
+
+	stop := errors.New("stop")
+	err = ParseUplinkBodyFunc(raw, func(*UplinkHeader, *StructuredBody) error { return stop }, func(*Variable) error {
+		t.Error("variable after body error")
+		return nil
+	})
+	if err != stop {
+		t.Errorf("got %v", err)
+	}
+}
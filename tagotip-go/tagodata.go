@@ -0,0 +1,305 @@
+package tagotip
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// TagoIO Data objects
+// ---------------------------------------------------------------------------
+//
+// TagoIO stores each variable of a PUSH as a Data object:
+//
+//	{"variable":"temp","value":21.5,"unit":"C","time":"2023-11-14T22:13:20Z",
+//	 "group":"batch_1","metadata":{"source":"dht22"}}
+//
+// ToTagoData and FromTagoData convert between a structured PUSH body and
+// these objects. A body-level timestamp, group, or metadata pair applies to
+// every variable that does not set its own.
+
+// TagoData is a TagoIO Data object, encoded by encoding/json in the shape
+// the platform takes.
+type TagoData struct {
+	Variable string `json:"variable"`
+
+	// Value is a float64, string, or bool; it is nil for a location, whose
+	// coordinates are in Location.
+	Value    any            `json:"value,omitempty"`
+	Unit     string         `json:"unit,omitempty"`
+	Time     *time.Time     `json:"time,omitempty"`
+	Group    string         `json:"group,omitempty"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Location *TagoLocation  `json:"location,omitempty"`
+}
+
+// TagoLocation is the location of a TagoData.
+type TagoLocation struct {
+	Lat float64  `json:"lat"`
+	Lng float64  `json:"lng"`
+	Alt *float64 `json:"alt,omitempty"`
+}
+
+// ToTagoData returns a Data object for each variable of frame, a PUSH with
+// a structured body. Numbers become float64 values, rounded to the nearest
+// when they hold more digits than a float64; a number out of the float64
+// range is an error, as is a timestamp out of the range of time.Time.
+// Metadata values are strings.
+func ToTagoData(frame *UplinkFrame) ([]TagoData, error) {
+	sb := structuredBody(frame)
+	if sb == nil {
+		return nil, errors.New("tagotip: frame has no structured PUSH body")
+	}
+	bodyTime, _, err := sb.Time()
+	if err != nil {
+		return nil, err
+	}
+	bodyMeta := sb.Metadata()
+
+	data := make([]TagoData, len(sb.Variables))
+	for i := range sb.Variables {
+		v, d := &sb.Variables[i], &data[i]
+		d.Variable = v.Name
+		switch v.Operator {
+		case OperatorNumber:
+			f, err := tagoFloat(v.Value.Str)
+			if err != nil {
+				return nil, fmt.Errorf("tagotip: variable %q: %w", v.Name, err)
+			}
+			d.Value = f
+		case OperatorString:
+			d.Value = v.Value.Str
+		case OperatorBoolean:
+			d.Value = v.Value.Bool
+		case OperatorLocation:
+			loc, err := tagoLocation(v.Value.Location)
+			if err != nil {
+				return nil, fmt.Errorf("tagotip: variable %q: %w", v.Name, err)
+			}
+			d.Location = loc
+		}
+		if v.Unit != nil {
+			d.Unit = *v.Unit
+		}
+
+		t, ok, err := v.Time()
+		if err != nil {
+			return nil, fmt.Errorf("tagotip: variable %q: %w", v.Name, err)
+		}
+		if !ok && sb.Timestamp != nil {
+			t, ok = bodyTime, true
+		}
+		if ok {
+			t = t.UTC()
+			d.Time = &t
+		}
+
+		switch {
+		case v.Group != nil:
+			d.Group = *v.Group
+		case sb.Group != nil:
+			d.Group = *sb.Group
+		}
+
+		meta := v.Metadata()
+		if len(bodyMeta)+len(meta) > 0 {
+			d.Metadata = make(map[string]any, len(bodyMeta)+len(meta))
+			for _, p := range bodyMeta {
+				d.Metadata[p.Key] = p.Value
+			}
+			for _, p := range meta {
+				d.Metadata[p.Key] = p.Value
+			}
+		}
+	}
+	return data, nil
+}
+
+// tagoFloat converts a number to a float64, accepting a rounded value but
+// not an infinite one.
+func tagoFloat(s string) (float64, error) {
+	f, err := parseFloat(s)
+	if errors.Is(err, ErrInexactNumber) && !math.IsInf(f, 0) {
+		err = nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("number %s is out of range", s)
+	}
+	return f, nil
+}
+
+func tagoLocation(l *LocationValue) (*TagoLocation, error) {
+	if l == nil {
+		return nil, errors.New("location has no coordinates")
+	}
+	lat, err := tagoFloat(l.Lat)
+	if err != nil {
+		return nil, err
+	}
+	lng, err := tagoFloat(l.Lng)
+	if err != nil {
+		return nil, err
+	}
+	loc := &TagoLocation{Lat: lat, Lng: lng}
+	if l.Alt != nil {
+		alt, err := tagoFloat(*l.Alt)
+		if err != nil {
+			return nil, err
+		}
+		loc.Alt = &alt
+	}
+	return loc, nil
+}
+
+// FromTagoData returns a structured PUSH body holding a variable for each
+// Data object, with its own timestamp, group, and metadata; nothing is
+// moved to the body level. Times keep millisecond precision.
+//
+// A value may be a float64 or other Go number, a json.Number, a string, or
+// a bool, or nil when Location is set. Metadata values may be strings,
+// numbers, or bools, which are written as text, and metadata keys are
+// sorted. Anything else, such as a nested metadata object, is an error, as
+// is a Data object that would not make a valid variable.
+func FromTagoData(data []TagoData) (*StructuredBody, error) {
+	sb := &StructuredBody{Variables: make([]Variable, len(data))}
+	for i := range data {
+		d, v := &data[i], &sb.Variables[i]
+		v.Name = d.Variable
+		if err := setTagoValue(v, d); err != nil {
+			return nil, fmt.Errorf("tagotip: variable %q: %w", d.Variable, err)
+		}
+		if d.Unit != "" {
+			unit := d.Unit
+			v.Unit = &unit
+		}
+		if d.Time != nil {
+			v.SetTimestamp(*d.Time)
+		}
+		if d.Group != "" {
+			group := d.Group
+			v.Group = &group
+		}
+		keys := make([]string, 0, len(d.Metadata))
+		for k := range d.Metadata {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			s, err := tagoMetaString(d.Metadata[k])
+			if err != nil {
+				return nil, fmt.Errorf("tagotip: variable %q metadata %q: %w", d.Variable, k, err)
+			}
+			v.Meta = append(v.Meta, MetaPair{Key: k, Value: s})
+		}
+		if err := checkVariable(v); err != nil {
+			return nil, err
+		}
+	}
+	return sb, nil
+}
+
+func setTagoValue(v *Variable, d *TagoData) error {
+	if d.Location != nil {
+		if d.Value != nil {
+			return errors.New("both a value and a location")
+		}
+		loc := &LocationValue{}
+		var err error
+		if loc.Lat, err = formatTagoFloat(d.Location.Lat); err != nil {
+			return err
+		}
+		if loc.Lng, err = formatTagoFloat(d.Location.Lng); err != nil {
+			return err
+		}
+		if d.Location.Alt != nil {
+			alt, err := formatTagoFloat(*d.Location.Alt)
+			if err != nil {
+				return err
+			}
+			loc.Alt = &alt
+		}
+		v.Operator = OperatorLocation
+		v.Value = Value{Type: OperatorLocation, Location: loc}
+		return nil
+	}
+
+	switch x := d.Value.(type) {
+	case string:
+		v.Operator = OperatorString
+		v.Value = Value{Type: OperatorString, Str: x}
+		return nil
+	case bool:
+		v.Operator = OperatorBoolean
+		v.Value = Value{Type: OperatorBoolean, Bool: x}
+		return nil
+	case nil:
+		return errors.New("no value")
+	}
+	s, ok, err := tagoNumber(d.Value)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("value of type %T cannot be sent", d.Value)
+	}
+	v.Operator = OperatorNumber
+	v.Value = Value{Type: OperatorNumber, Str: s}
+	return nil
+}
+
+// tagoNumber formats x when it is a number. ok is false when it is not.
+func tagoNumber(x any) (s string, ok bool, err error) {
+	switch n := x.(type) {
+	case float64:
+		s, err = formatTagoFloat(n)
+	case float32:
+		s, err = formatTagoFloat(float64(n))
+	case int:
+		s = strconv.Itoa(n)
+	case int64:
+		s = strconv.FormatInt(n, 10)
+	case int32:
+		s = strconv.FormatInt(int64(n), 10)
+	case uint:
+		s = strconv.FormatUint(uint64(n), 10)
+	case uint64:
+		s = strconv.FormatUint(n, 10)
+	case uint32:
+		s = strconv.FormatUint(uint64(n), 10)
+	case json.Number:
+		s = string(n)
+		if validateNumber(s, 0) != nil {
+			err = fmt.Errorf("number %s cannot be written without an exponent", s)
+		}
+	default:
+		return "", false, nil
+	}
+	return s, true, err
+}
+
+func formatTagoFloat(f float64) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", fmt.Errorf("number %v cannot be sent", f)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
+
+// tagoMetaString returns a metadata value as text.
+func tagoMetaString(x any) (string, error) {
+	switch m := x.(type) {
+	case string:
+		return m, nil
+	case bool:
+		return strconv.FormatBool(m), nil
+	}
+	s, ok, err := tagoNumber(x)
+	if !ok {
+		return "", fmt.Errorf("value of type %T cannot be sent", x)
+	}
+	return s, err
+}
@@ -0,0 +1,105 @@
+package tagotip
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// TagoIO Data objects
+// ============================================================================
+
+func TestToTagoData(t *testing.T) {
+	f := mustParse(t, "PUSH|"+testAuth+"|sensor-01|@1700000000000^batch{src=dht,fw=2}"+
+		"[temp:=21.5#C;ok?=true;note=hi{fw=3};pos@=-23.5,-46.6,760@1700000001000^other;n:=-0]")
+	data, err := ToTagoData(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[` +
+		`{"variable":"temp","value":21.5,"unit":"C","time":"2023-11-14T22:13:20Z","group":"batch","metadata":{"fw":"2","src":"dht"}},` +
+		`{"variable":"ok","value":true,"time":"2023-11-14T22:13:20Z","group":"batch","metadata":{"fw":"2","src":"dht"}},` +
+		`{"variable":"note","value":"hi","time":"2023-11-14T22:13:20Z","group":"batch","metadata":{"fw":"3","src":"dht"}},` +
+		`{"variable":"pos","time":"2023-11-14T22:13:21Z","group":"other","metadata":{"fw":"2","src":"dht"},"location":{"lat":-23.5,"lng":-46.6,"alt":760}},` +
+		`{"variable":"n","value":-0,"time":"2023-11-14T22:13:20Z","group":"batch","metadata":{"fw":"2","src":"dht"}}` +
+		`]`
+	if string(got) != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	// Digits beyond a float64 are rounded; a number out of range fails.
+	f = mustParse(t, "PUSH|"+testAuth+"|sensor-01|[a:=12345678901234567890]")
+	if data, err := ToTagoData(f); err != nil || data[0].Value != 12345678901234567890.0 {
+		t.Errorf("rounded: %+v, %v", data, err)
+	}
+	f = mustParse(t, "PUSH|"+testAuth+"|sensor-01|[a:=1"+strings.Repeat("0", 400)+"]")
+	if _, err := ToTagoData(f); err == nil {
+		t.Error("number out of range accepted")
+	}
+	if _, err := ToTagoData(mustParse(t, "PING|"+testAuth+"|sensor-01")); err == nil {
+		t.Error("PING accepted")
+	}
+}
+
+func TestFromTagoData(t *testing.T) {
+	var data []TagoData
+	err := json.Unmarshal([]byte(`[
+		{"variable":"temp","value":21.5,"unit":"C","time":"2023-11-14T22:13:20.123Z","metadata":{"src":"dht","cal":2,"ok":true}},
+		{"variable":"note","value":"a|b","group":"g1"},
+		{"variable":"ok","value":false},
+		{"variable":"pos","location":{"lat":-23.5,"lng":-46.6}}
+	]`), &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb, err := FromTagoData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := BuildUplink(&UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "sensor-01",
+		PushBody: &PushBody{Structured: sb}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "PUSH|" + testAuth + "|sensor-01|[temp:=21.5#C@1700000000123{cal=2,ok=true,src=dht};" +
+		`note=a\|b^g1;ok?=false;pos@=-23.5,-46.6]`
+	if raw != want {
+		t.Errorf("got  %s\nwant %s", raw, want)
+	}
+
+	// The round trip gives back the Data objects.
+	back, err := ToTagoData(mustParse(t, raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back[0].Time.UnixMilli() != 1700000000123 || back[1].Value != "a|b" || back[3].Location.Lng != -46.6 {
+		t.Errorf("round trip: %+v", back)
+	}
+
+	for name, d := range map[string]TagoData{
+		"nested metadata":  {Variable: "a", Value: 1.0, Metadata: map[string]any{"cfg": map[string]any{"x": 1}}},
+		"array value":      {Variable: "a", Value: []any{1.0}},
+		"no value":         {Variable: "a"},
+		"value and coords": {Variable: "a", Value: 1.0, Location: &TagoLocation{}},
+		"exponent number":  {Variable: "a", Value: json.Number("1e6")},
+		"empty string":     {Variable: "a", Value: ""},
+		"bad name":         {Variable: "a b", Value: 1.0},
+		"bad metadata key": {Variable: "a", Value: 1.0, Metadata: map[string]any{"Key": "v"}},
+	} {
+		if sb, err := FromTagoData([]TagoData{d}); err == nil {
+			t.Errorf("%s: got %+v", name, sb.Variables)
+		}
+	}
+
+	ts := time.UnixMilli(1700000000000)
+	sb, err = FromTagoData([]TagoData{{Variable: "big", Value: 1e21, Time: &ts}})
+	if err != nil || sb.Variables[0].Value.Str != "1000000000000000000000" || *sb.Variables[0].Timestamp != "1700000000000" {
+		t.Errorf("large float: %+v, %v", sb, err)
+	}
+}
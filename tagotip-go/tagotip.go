@@ -1,5 +1,7 @@
 // Package tagotip provides a pure Go implementation of the TagoTiP protocol codec.
 //
 // It supports parsing and building uplink frames (PUSH, PULL, PING) and
-// ACK (downlink) frames, with no CGo or external dependencies.
+// ACK (downlink) frames, with no CGo or external dependencies. Parsing runs
+// in linear time on any input (see ParseUplink), so frames from untrusted
+// devices can be parsed without further limits.
 package tagotip
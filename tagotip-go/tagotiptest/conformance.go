@@ -0,0 +1,421 @@
+package tagotiptest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tagotip "github.com/tago-io/tagotip-sdk/tagotip-go"
+)
+
+// ---------------------------------------------------------------------------
+// Conformance
+// ---------------------------------------------------------------------------
+//
+// RunConformance sends a scripted battery of frames to a server and checks
+// each ACK against the specification. The checks are grouped, and each
+// group can be selected on its own:
+//
+//	CheckSpecExamples  the uplink frames of specification §11
+//	CheckBoundaries    frames at the protocol limits
+//	CheckMalformed     frames the server must refuse, with the ERR code
+//	CheckSeqEcho       ACKs carrying the seq of their frame, or none
+//
+// Every frame is sent for the device of the given Credentials, so the
+// server must know it and accept its PUSH and PULL frames.
+
+// Credentials identify a device to a server.
+type Credentials struct {
+	Token  string // authorization token, "at" and 32 hex digits
+	Serial string
+}
+
+// Transport carries the frames of a conformance run to the server under
+// test.
+type Transport interface {
+	// RoundTrip sends a plaintext frame, without newline, and returns the
+	// ACK it is answered with, without newline.
+	RoundTrip(ctx context.Context, frame string) (string, error)
+}
+
+// TransportFunc adapts a function to Transport, as for a server called in
+// process.
+type TransportFunc func(ctx context.Context, frame string) (string, error)
+
+// RoundTrip calls f.
+func (f TransportFunc) RoundTrip(ctx context.Context, frame string) (string, error) {
+	return f(ctx, frame)
+}
+
+// streamTransport sends newline-terminated frames over a stream, one at a
+// time.
+type streamTransport struct {
+	mu   sync.Mutex
+	conn net.Conn
+	sc   *bufio.Scanner
+}
+
+// NewStreamTransport returns a Transport over conn, a stream carrying
+// newline-terminated frames such as a TCP connection or the Client end of
+// a PipeTransport. A frame not answered before its context ends leaves
+// the stream out of step, and the Transport fails every frame after it.
+func NewStreamTransport(conn net.Conn) Transport {
+	sc := bufio.NewScanner(conn)
+	sc.Split(tagotip.ScanFrames)
+	return &streamTransport{conn: conn, sc: sc}
+}
+
+func (t *streamTransport) RoundTrip(ctx context.Context, frame string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sc == nil {
+		return "", errors.New("tagotiptest: stream out of step after an earlier failure")
+	}
+	deadline, _ := ctx.Deadline()
+	t.conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() { t.conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	if _, err := t.conn.Write([]byte(frame + "\n")); err != nil {
+		t.sc = nil
+		return "", err
+	}
+	if !t.sc.Scan() {
+		err := t.sc.Err()
+		if err == nil {
+			err = errors.New("tagotiptest: connection closed")
+		}
+		t.sc = nil
+		return "", err
+	}
+	return t.sc.Text(), nil
+}
+
+// Check names a group of conformance checks.
+type Check string
+
+const (
+	CheckSpecExamples Check = "spec_examples"
+	CheckBoundaries   Check = "boundaries"
+	CheckMalformed    Check = "malformed"
+	CheckSeqEcho      Check = "seq_echo"
+)
+
+// AllChecks lists every Check in the order RunConformance runs them.
+var AllChecks = []Check{CheckSpecExamples, CheckBoundaries, CheckMalformed, CheckSeqEcho}
+
+// ConformanceOptions configures RunConformance. The zero value runs every
+// check.
+type ConformanceOptions struct {
+	// Checks selects the checks to run, in the order of AllChecks. Empty
+	// means all.
+	Checks []Check
+
+	// Timeout bounds each exchange. Zero means five seconds.
+	Timeout time.Duration
+}
+
+// Report is the outcome of a conformance run.
+type Report struct {
+	Results []Result
+}
+
+// Result is the outcome of one check. Failure is empty when it passed.
+type Result struct {
+	Check     Check
+	Name      string
+	Failure   string
+	Exchanges []Exchange
+}
+
+// Exchange is a frame sent and the ACK it was answered with, as on the
+// wire.
+type Exchange struct {
+	Frame string
+	Ack   string
+}
+
+// Failed returns the results of the checks that did not pass.
+func (r Report) Failed() []Result {
+	var failed []Result
+	for _, res := range r.Results {
+		if res.Failure != "" {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// RunConformance runs the checks selected by opts against the server
+// behind target, for the device of creds. A nil opts runs them all. The
+// error is that of target, or of ctx, when an exchange could not be made;
+// the Report then holds the results up to it.
+func RunConformance(ctx context.Context, target Transport, creds Credentials, opts *ConformanceOptions) (Report, error) {
+	var o ConformanceOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 5 * time.Second
+	}
+	selected := make(map[Check]bool)
+	for _, c := range o.Checks {
+		if !slices.Contains(AllChecks, c) {
+			return Report{}, fmt.Errorf("tagotiptest: unknown check %q", c)
+		}
+		selected[c] = true
+	}
+
+	var report Report
+	for _, check := range AllChecks {
+		if len(selected) > 0 && !selected[check] {
+			continue
+		}
+		for _, cs := range conformanceCases(check, creds) {
+			res := Result{Check: check, Name: cs.name}
+			for _, frame := range cs.frames {
+				if frame.err != nil {
+					res.Failure = frame.err.Error()
+					break
+				}
+				ectx, cancel := context.WithTimeout(ctx, o.Timeout)
+				raw, err := target.RoundTrip(ectx, frame.raw)
+				cancel()
+				if err != nil {
+					report.Results = append(report.Results, res)
+					return report, fmt.Errorf("tagotiptest: %s: %s: %w", check, cs.name, err)
+				}
+				res.Exchanges = append(res.Exchanges, Exchange{Frame: frame.raw, Ack: raw})
+				if res.Failure == "" {
+					res.Failure = expectAck(raw, frame.seq, cs.want)
+				}
+			}
+			report.Results = append(report.Results, res)
+		}
+	}
+	return report, nil
+}
+
+// conformanceCase is one check: frames to send, each answered as want
+// accepts.
+type conformanceCase struct {
+	name   string
+	frames []caseFrame
+	want   func(ack *tagotip.AckFrame) string // describes what is wrong, or ""
+}
+
+type caseFrame struct {
+	raw string
+	seq *uint32 // the seq the ACK must carry, nil for none
+	err error   // the frame could not be built
+}
+
+// uplink builds f for the device of creds.
+func uplink(creds Credentials, f *tagotip.UplinkFrame) caseFrame {
+	f.Auth, f.Serial = creds.Token, creds.Serial
+	raw, err := tagotip.BuildUplink(f)
+	return caseFrame{raw: raw, seq: f.Seq, err: err}
+}
+
+func conformanceCases(check Check, creds Credentials) []conformanceCase {
+	switch check {
+	case CheckSpecExamples:
+		return specCases(creds)
+	case CheckBoundaries:
+		return boundaryCases(creds)
+	case CheckMalformed:
+		return malformedCases(creds)
+	default:
+		return seqEchoCases(creds)
+	}
+}
+
+func specCases(creds Credentials) []conformanceCase {
+	var cases []conformanceCase
+	for _, ex := range tagotip.Spec11Examples() {
+		if ex.Uplink == nil {
+			continue
+		}
+		cases = append(cases, conformanceCase{
+			name:   ex.Label,
+			frames: []caseFrame{uplink(creds, ex.Uplink)},
+			want:   acceptedAs(ex.Uplink.Method),
+		})
+	}
+	return cases
+}
+
+func boundaryCases(creds Credentials) []conformanceCase {
+	vars := make([]tagotip.Variable, tagotip.MaxVariables)
+	for i := range vars {
+		vars[i] = number("v"+strconv.Itoa(i), "1")
+	}
+	meta := make(tagotip.MetaPairs, tagotip.MaxMetaPairs)
+	for i := range meta {
+		meta[i] = tagotip.MetaPair{Key: "k" + strconv.Itoa(i), Value: "v"}
+	}
+
+	// A string variable s fills the frame to MaxFrameSize with its newline.
+	head := len("PUSH|" + creds.Token + "|" + creds.Serial + "|[s=]")
+	largest := tagotip.Variable{Name: "s", Operator: tagotip.OperatorString,
+		Value: tagotip.Value{Type: tagotip.OperatorString, Str: strings.Repeat("x", max(0, tagotip.MaxFrameSize-1-head))}}
+
+	push := func(sb *tagotip.StructuredBody) *tagotip.UplinkFrame {
+		return &tagotip.UplinkFrame{Method: tagotip.MethodPush, PushBody: &tagotip.PushBody{Structured: sb}}
+	}
+	return []conformanceCase{
+		{
+			name:   "MaxVariables variables",
+			frames: []caseFrame{uplink(creds, push(&tagotip.StructuredBody{Variables: vars}))},
+			want:   acceptedAs(tagotip.MethodPush),
+		},
+		{
+			name:   "MaxMetaPairs metadata pairs",
+			frames: []caseFrame{uplink(creds, push(&tagotip.StructuredBody{Meta: meta, Variables: vars[:1]}))},
+			want:   acceptedAs(tagotip.MethodPush),
+		},
+		{
+			name:   "MaxFrameSize bytes with the newline",
+			frames: []caseFrame{uplink(creds, push(&tagotip.StructuredBody{Variables: []tagotip.Variable{largest}}))},
+			want:   acceptedAs(tagotip.MethodPush),
+		},
+	}
+}
+
+func malformedCases(creds Credentials) []conformanceCase {
+	var cases []conformanceCase
+	seq := uint32(7)
+	header := "PUSH|!7|" + creds.Token + "|" + creds.Serial + "|"
+	for _, tc := range []struct {
+		name string
+		raw  string
+		seq  *uint32
+		code tagotip.ErrorCode
+	}{
+		{"malformed token", "PUSH|at0123|" + creds.Serial + "|[t:=1]", nil, tagotip.ErrorCodeInvalidToken},
+		{"unknown method", "FETCH|" + creds.Token + "|" + creds.Serial + "|[t]", nil, tagotip.ErrorCodeInvalidMethod},
+		{"seq not a number", "PUSH|!x|" + creds.Token + "|" + creds.Serial + "|[t:=1]", nil, tagotip.ErrorCodeInvalidSeq},
+		{"seq out of range", "PUSH|!4294967296|" + creds.Token + "|" + creds.Serial + "|[t:=1]", nil, tagotip.ErrorCodeInvalidSeq},
+		{"empty variable block", header + "[]", &seq, tagotip.ErrorCodeInvalidPayload},
+		{"unclosed variable block", header + "[t:=1", &seq, tagotip.ErrorCodeInvalidPayload},
+		{"number that is not one", header + "[t:=abc]", &seq, tagotip.ErrorCodeInvalidPayload},
+		{"PUSH without body", "PUSH|!7|" + creds.Token + "|" + creds.Serial, &seq, tagotip.ErrorCodeInvalidPayload},
+	} {
+		cases = append(cases, conformanceCase{
+			name:   tc.name,
+			frames: []caseFrame{{raw: tc.raw, seq: tc.seq}},
+			want:   refusedWith(tc.code),
+		})
+	}
+	return cases
+}
+
+func seqEchoCases(creds Credentials) []conformanceCase {
+	u32 := func(n uint32) *uint32 { return &n }
+	ping := func(seq *uint32) *tagotip.UplinkFrame {
+		return &tagotip.UplinkFrame{Method: tagotip.MethodPing, Seq: seq}
+	}
+	return []conformanceCase{
+		{
+			name:   "PING without seq",
+			frames: []caseFrame{uplink(creds, ping(nil))},
+			want:   acceptedAs(tagotip.MethodPing),
+		},
+		{
+			name:   "PING with seq",
+			frames: []caseFrame{uplink(creds, ping(u32(1)))},
+			want:   acceptedAs(tagotip.MethodPing),
+		},
+		{
+			name:   "largest seq",
+			frames: []caseFrame{uplink(creds, ping(u32(4294967295)))},
+			want:   acceptedAs(tagotip.MethodPing),
+		},
+		{
+			name: "seq out of order",
+			frames: []caseFrame{
+				uplink(creds, ping(u32(20))),
+				uplink(creds, ping(u32(10))),
+				uplink(creds, ping(u32(30))),
+			},
+			want: acceptedAs(tagotip.MethodPing),
+		},
+		{
+			name: "ERR with seq",
+			frames: []caseFrame{{
+				raw: "PUSH|!9|" + creds.Token + "|" + creds.Serial + "|[t:=]",
+				seq: u32(9),
+			}},
+			want: refusedWith(tagotip.ErrorCodeInvalidPayload),
+		},
+	}
+}
+
+// expectAck checks raw, the ACK of a frame that carried seq, against want.
+func expectAck(raw string, seq *uint32, want func(*tagotip.AckFrame) string) string {
+	ack, err := tagotip.ParseAck(raw)
+	if err != nil {
+		return fmt.Sprintf("ACK %q does not parse: %v", raw, err)
+	}
+	switch {
+	case seq == nil && ack.Seq != nil:
+		return fmt.Sprintf("ACK %q carries a seq for a frame without one", raw)
+	case seq != nil && ack.Seq == nil:
+		return fmt.Sprintf("ACK %q does not echo seq %d", raw, *seq)
+	case seq != nil && *ack.Seq != *seq:
+		return fmt.Sprintf("ACK %q echoes seq %d, want %d", raw, *ack.Seq, *seq)
+	}
+	return want(ack)
+}
+
+// acceptedAs accepts the ACK a frame of method is answered with when it is
+// accepted: PONG for PING, and OK or CMD otherwise. A PULL may also be
+// answered with variable_not_found.
+func acceptedAs(method tagotip.Method) func(*tagotip.AckFrame) string {
+	return func(ack *tagotip.AckFrame) string {
+		switch {
+		case method == tagotip.MethodPing:
+			if ack.Status == tagotip.AckStatusPong {
+				return ""
+			}
+		case ack.Status == tagotip.AckStatusOk || ack.Status == tagotip.AckStatusCmd:
+			return ""
+		case method == tagotip.MethodPull && ack.Status == tagotip.AckStatusErr &&
+			ack.Detail != nil && ack.Detail.ErrorCode == tagotip.ErrorCodeVariableNotFound:
+			return ""
+		}
+		return fmt.Sprintf("%v answered with %s", method, describeAck(ack))
+	}
+}
+
+// refusedWith accepts an ERR ACK carrying code.
+func refusedWith(code tagotip.ErrorCode) func(*tagotip.AckFrame) string {
+	return func(ack *tagotip.AckFrame) string {
+		if ack.Status == tagotip.AckStatusErr && ack.Detail != nil && ack.Detail.ErrorCode == code {
+			return ""
+		}
+		return fmt.Sprintf("answered with %s, want ERR %v", describeAck(ack), code)
+	}
+}
+
+func describeAck(ack *tagotip.AckFrame) string {
+	if ack.Detail == nil {
+		return ack.Status.String()
+	}
+	if ack.Status == tagotip.AckStatusErr {
+		return fmt.Sprintf("%v %v", ack.Status, ack.Detail.ErrorCode)
+	}
+	return fmt.Sprintf("%v %s", ack.Status, ack.Detail.Type)
+}
+
+func number(name, v string) tagotip.Variable {
+	return tagotip.Variable{Name: name, Operator: tagotip.OperatorNumber,
+		Value: tagotip.Value{Type: tagotip.OperatorNumber, Str: v}}
+}
@@ -0,0 +1,119 @@
+package tagotiptest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	tagotip "github.com/tago-io/tagotip-sdk/tagotip-go"
+)
+
+// ============================================================================
+// Conformance
+// ============================================================================
+
+var testCreds = Credentials{Token: testAuth, Serial: "sensor-01"}
+
+// referenceMux returns a FrameMux that accepts every PUSH and answers every
+// PULL with the variables asked for.
+func referenceMux() *tagotip.FrameMux {
+	m, _ := countingMux()
+	m.HandlePull(func(_ context.Context, f *tagotip.UplinkFrame) (*tagotip.AckFrame, error) {
+		vars := make([]tagotip.Variable, len(f.PullBody.Variables))
+		for i, name := range f.PullBody.Variables {
+			vars[i] = number(name, "1")
+		}
+		return &tagotip.AckFrame{Status: tagotip.AckStatusOk, Detail: &tagotip.AckDetail{Type: "variables", Variables: vars}}, nil
+	})
+	return m
+}
+
+func TestRunConformanceFrameMux(t *testing.T) {
+	p := servePipe(t, referenceMux())
+	report, err := RunConformance(context.Background(), NewStreamTransport(p.Client), testCreds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range report.Failed() {
+		t.Errorf("%s: %s: %s\n%+v", res.Check, res.Name, res.Failure, res.Exchanges)
+	}
+	ran := make(map[Check]int)
+	for _, res := range report.Results {
+		ran[res.Check]++
+		if len(res.Exchanges) == 0 {
+			t.Errorf("%s: %s: no exchanges recorded", res.Check, res.Name)
+		} else if res.Name == "MaxFrameSize bytes with the newline" && len(res.Exchanges[0].Frame) != tagotip.MaxFrameSize-1 {
+			t.Errorf("%s: %d bytes", res.Name, len(res.Exchanges[0].Frame))
+		}
+	}
+	for _, c := range AllChecks {
+		if ran[c] == 0 {
+			t.Errorf("%s: not run", c)
+		}
+	}
+}
+
+func TestRunConformanceSelectsChecks(t *testing.T) {
+	p := servePipe(t, referenceMux())
+	report, err := RunConformance(context.Background(), NewStreamTransport(p.Client), testCreds,
+		&ConformanceOptions{Checks: []Check{CheckSeqEcho, CheckMalformed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) == 0 || len(report.Failed()) != 0 {
+		t.Fatalf("%+v", report)
+	}
+	for _, res := range report.Results {
+		if res.Check != CheckMalformed && res.Check != CheckSeqEcho {
+			t.Errorf("ran %s: %s", res.Check, res.Name)
+		}
+	}
+
+	if _, err := RunConformance(context.Background(), NewStreamTransport(p.Client), testCreds,
+		&ConformanceOptions{Checks: []Check{"secure"}}); err == nil {
+		t.Error("unknown check accepted")
+	}
+}
+
+func TestRunConformanceReportsFailures(t *testing.T) {
+	// A server that answers everything with a bare OK neither echoes seq
+	// nor refuses anything.
+	lax := TransportFunc(func(context.Context, string) (string, error) { return "ACK|OK", nil })
+	report, err := RunConformance(context.Background(), lax, testCreds, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := make(map[string]string)
+	for _, res := range report.Failed() {
+		failed[res.Name] = res.Failure
+	}
+	for name, want := range map[string]string{
+		"PING with seq":        "does not echo seq 1",
+		"PING without seq":     "PING answered with OK",
+		"unknown method":       "want ERR invalid_method",
+		"empty variable block": "does not echo seq 7",
+	} {
+		if !strings.Contains(failed[name], want) {
+			t.Errorf("%s: failure %q, want %q", name, failed[name], want)
+		}
+	}
+	if _, ok := failed["§11.1 Simple Push"]; ok {
+		t.Error("§11.1 Simple Push failed")
+	}
+}
+
+func TestRunConformanceTransportError(t *testing.T) {
+	errDown := errors.New("down")
+	calls := 0
+	flaky := TransportFunc(func(_ context.Context, frame string) (string, error) {
+		if calls++; calls == 3 {
+			return "", errDown
+		}
+		return "ACK|OK", nil
+	})
+	report, err := RunConformance(context.Background(), flaky, testCreds, nil)
+	if !errors.Is(err, errDown) || len(report.Results) != 3 {
+		t.Errorf("%d results, %v", len(report.Results), err)
+	}
+}
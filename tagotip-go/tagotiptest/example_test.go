@@ -0,0 +1,94 @@
+package tagotiptest_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	tagotip "github.com/tago-io/tagotip-sdk/tagotip-go"
+	"github.com/tago-io/tagotip-sdk/tagotip-go/tagotiptest"
+)
+
+// A device sends a sealed PUSH whose ACK is lost on the way back, and sends
+// the envelope again once it has waited long enough for it.
+func ExamplePipeTransport_secureRetransmit() {
+	const token, serial = "at0123456789abcdef0123456789abcdef", "sensor-01"
+	p := tagotiptest.NewPipeTransport()
+	defer p.Close()
+
+	// The server opens every envelope and answers with a sealed ACK, each
+	// one numbered with its own downlink counter.
+	key, _ := tagotip.DeriveKey(token, serial, 16)
+	go func() {
+		buf := make([]byte, 1024)
+		for counter := uint32(1); ; counter++ {
+			n, err := p.Server.Read(buf)
+			if err != nil {
+				return
+			}
+			h, frame, _, err := tagotip.OpenUplinkFrame(buf[:n], key)
+			if err != nil {
+				return
+			}
+			fmt.Printf("server: counter %d, %d variables\n", h.Counter, len(frame.PushBody.Structured.Variables))
+			inner, _ := tagotip.BuildAckInner(&tagotip.AckFrame{
+				Status: tagotip.AckStatusOk,
+				Detail: &tagotip.AckDetail{Type: "count", Count: 1},
+			})
+			ack, _ := tagotip.SealDownlink([]byte(inner), counter, h.AuthHash, h.DeviceHash, key, tagotip.CipherSuiteAes128Ccm)
+			p.Server.Write(ack)
+		}
+	}()
+
+	session, _ := tagotip.NewSecureSession(token, serial, tagotip.CipherSuiteAes128Ccm, nil)
+	inner, _ := tagotip.BuildHeadless(tagotip.MethodPush, &tagotip.HeadlessFrame{
+		Serial:   serial,
+		PushBody: &tagotip.PushBody{Structured: &tagotip.StructuredBody{Variables: []tagotip.Variable{{Name: "temp", Operator: tagotip.OperatorNumber, Value: tagotip.Value{Type: tagotip.OperatorNumber, Str: "21.5"}}}}},
+	})
+	envelope, _ := session.Seal(tagotip.EnvelopeMethodPush, []byte(inner))
+
+	p.Downlink.Drop(1)
+	buf := make([]byte, 1024)
+	for attempt := 1; attempt <= 3; attempt++ {
+		p.Client.Write(envelope)
+		p.Client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := p.Client.Read(buf)
+		if err != nil {
+			fmt.Printf("device: attempt %d, no ACK\n", attempt)
+			continue
+		}
+		_, ack, _ := session.Open(buf[:n])
+		fmt.Printf("device: attempt %d, ACK %v|%d\n", attempt, ack.Status, ack.Detail.Count)
+		break
+	}
+	// Output:
+	// server: counter 1, 1 variables
+	// device: attempt 1, no ACK
+	// server: counter 1, 1 variables
+	// device: attempt 2, ACK OK|1
+}
+
+func ExampleMockServer() {
+	p := tagotiptest.NewPipeTransport()
+	defer p.Close()
+
+	s := tagotiptest.MockServer{Ordered: true}
+	s.Expect(tagotip.MethodPing)
+	s.Expect(tagotip.MethodPush).WithVariable("temp").Respond(tagotiptest.AckOKCount(1))
+	go s.ServeConn(context.Background(), p.Server)
+
+	// The code under test sends its PUSH without the PING first.
+	c := tagotip.NewClient(p.Client, "at0123456789abcdef0123456789abcdef", "sensor-01", nil)
+	defer c.Close()
+	_, err := c.Push(context.Background(), &tagotip.PushBody{Structured: &tagotip.StructuredBody{Variables: []tagotip.Variable{{Name: "temp", Operator: tagotip.OperatorNumber, Value: tagotip.Value{Type: tagotip.OperatorNumber, Str: "21.5"}}}}})
+	fmt.Println(err)
+	fmt.Println(s.Err())
+	// Output:
+	// tagotip: server error: server_error
+	// tagotiptest: mock server:
+	// expected PING, not received
+	// expected PUSH with temp, not received
+	// frame out of order, expecting PING:
+	//   PUSH sensor-01 auth=at0123…cdef
+	//     temp := 21.5
+}
@@ -0,0 +1,364 @@
+package tagotiptest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	tagotip "github.com/tago-io/tagotip-sdk/tagotip-go"
+)
+
+// ---------------------------------------------------------------------------
+// Mock server
+// ---------------------------------------------------------------------------
+//
+// MockServer stands in for a TagoTiP server in the tests of device and
+// application code. Each expectation declares a frame the code under test
+// should send and the ACK to answer it with:
+//
+//	var s tagotiptest.MockServer
+//	s.Expect(tagotip.MethodPush).WithVariable("temp").Respond(tagotiptest.AckOKCount(1))
+//	go s.ServeConn(ctx, p.Server)
+//	... run the code under test against p.Client ...
+//	s.Verify(t)
+//
+// A frame no expectation matches is answered with ERR server_error and
+// reported by Verify, together with the expectations left unmet. Given
+// Credentials, the server opens the sealed envelopes of that device and
+// seals its ACKs in return.
+
+// AckOKCount returns an OK ACK counting n variables, as a server answers a
+// PUSH.
+func AckOKCount(n uint32) *tagotip.AckFrame {
+	return &tagotip.AckFrame{Status: tagotip.AckStatusOk, Detail: &tagotip.AckDetail{Type: "count", Count: n}}
+}
+
+// MockServer answers frames as its expectations declare. The zero value
+// has no expectations and accepts plaintext frames in any order. It is
+// safe for concurrent use.
+type MockServer struct {
+	// Ordered requires the expectations to be met in the order declared: a
+	// frame that does not match the first unmet one is unexpected.
+	Ordered bool
+
+	// Credentials, if not nil, make the server read sealed envelopes of the
+	// device they identify, on streams as written by EnvelopeWriter and in
+	// datagrams as they are. Its ACKs are sealed with a downlink counter
+	// from 1 up.
+	Credentials *Credentials
+
+	mu         sync.Mutex
+	exps       []*Expectation
+	unexpected []string
+	downlink   uint32
+}
+
+// Expectation is a frame a MockServer expects, and the ACK it answers the
+// frame with. Its methods return the Expectation, to be chained.
+type Expectation struct {
+	s      *MockServer
+	method tagotip.Method
+	serial string
+	vars   []string
+	ack    *tagotip.AckFrame
+	met    bool
+}
+
+// Expect declares a frame of method the server expects once. Without
+// Respond, a PING is answered with PONG and any other frame with OK and
+// the number of its variables.
+func (s *MockServer) Expect(method tagotip.Method) *Expectation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := &Expectation{s: s, method: method}
+	s.exps = append(s.exps, e)
+	return e
+}
+
+// WithSerial requires the frame to come from the device with serial.
+func (e *Expectation) WithSerial(serial string) *Expectation {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+	e.serial = serial
+	return e
+}
+
+// WithVariable requires the frame to carry a variable named name: in the
+// structured body of a PUSH, or among the names of a PULL.
+func (e *Expectation) WithVariable(name string) *Expectation {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+	e.vars = append(e.vars, name)
+	return e
+}
+
+// Respond sets the ACK the frame is answered with. Its seq is replaced by
+// that of the frame.
+func (e *Expectation) Respond(ack *tagotip.AckFrame) *Expectation {
+	e.s.mu.Lock()
+	defer e.s.mu.Unlock()
+	e.ack = ack
+	return e
+}
+
+func (e *Expectation) String() string {
+	var b strings.Builder
+	b.WriteString(e.method.String())
+	if e.serial != "" {
+		b.WriteString(" from " + e.serial)
+	}
+	if len(e.vars) > 0 {
+		b.WriteString(" with " + strings.Join(e.vars, ", "))
+	}
+	return b.String()
+}
+
+// matches reports whether frame is the frame e expects.
+func (e *Expectation) matches(frame *tagotip.UplinkFrame) bool {
+	if frame.Method != e.method || (e.serial != "" && frame.Serial != e.serial) {
+		return false
+	}
+	names := frameVariables(frame)
+	for _, v := range e.vars {
+		if !slices.Contains(names, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// response returns the ACK answering frame.
+func (e *Expectation) response(frame *tagotip.UplinkFrame) *tagotip.AckFrame {
+	switch {
+	case e.ack != nil:
+		return e.ack
+	case frame.Method == tagotip.MethodPing:
+		return &tagotip.AckFrame{Status: tagotip.AckStatusPong}
+	default:
+		return AckOKCount(uint32(len(frameVariables(frame))))
+	}
+}
+
+// frameVariables returns the names of the variables of a PUSH with a
+// structured body, or those a PULL asks for.
+func frameVariables(frame *tagotip.UplinkFrame) []string {
+	var names []string
+	if frame.PushBody != nil && frame.PushBody.Structured != nil {
+		for _, v := range frame.PushBody.Structured.Variables {
+			names = append(names, v.Name)
+		}
+	}
+	if frame.PullBody != nil {
+		names = append(names, frame.PullBody.Variables...)
+	}
+	return names
+}
+
+// HandleFrame is a tagotip.FrameHandlerFunc answering frame as the
+// expectation it meets, for a FrameMux of the caller's own.
+func (s *MockServer) HandleFrame(_ context.Context, frame *tagotip.UplinkFrame) (*tagotip.AckFrame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next *Expectation
+	for _, e := range s.exps {
+		if e.met {
+			continue
+		}
+		if next == nil {
+			next = e
+		}
+		if e.matches(frame) {
+			e.met = true
+			return e.response(frame), nil
+		}
+		if s.Ordered {
+			break
+		}
+	}
+	what := "unexpected frame"
+	if s.Ordered && next != nil {
+		what = "frame out of order, expecting " + next.String()
+	}
+	s.unexpected = append(s.unexpected, what+":\n"+indent(tagotip.Dump(frame)))
+	return nil, &tagotip.ServerError{Code: tagotip.ErrorCodeServerError}
+}
+
+// unexpectedEnvelope records an envelope the server could not read.
+func (s *MockServer) unexpectedEnvelope(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unexpected = append(s.unexpected, "unreadable envelope: "+err.Error())
+}
+
+// Err returns an error listing the expectations not met and the frames
+// not expected, or nil when there are none.
+func (s *MockServer) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var problems []string
+	for _, e := range s.exps {
+		if !e.met {
+			problems = append(problems, "expected "+e.String()+", not received")
+		}
+	}
+	problems = append(problems, s.unexpected...)
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("tagotiptest: mock server:\n" + strings.Join(problems, "\n"))
+}
+
+// Verify fails t with Err, if not nil.
+func (s *MockServer) Verify(t testing.TB) {
+	t.Helper()
+	if err := s.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func (s *MockServer) mux() *tagotip.FrameMux {
+	m := &tagotip.FrameMux{}
+	m.HandlePush(s.HandleFrame)
+	m.HandlePull(s.HandleFrame)
+	m.HandlePing(s.HandleFrame)
+	return m
+}
+
+// Serve accepts connections on ln, a TCP listener, and serves each with
+// ServeConn until ln fails, as when it is closed. It returns the error of
+// ln.Accept.
+func (s *MockServer) Serve(ln net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.ServeConn(ctx, conn)
+		}()
+	}
+}
+
+// ServeConn serves the frames of a stream, such as a TCP connection or the
+// Server end of a PipeTransport, until it ends or ctx is done, then closes
+// conn.
+func (s *MockServer) ServeConn(ctx context.Context, conn net.Conn) {
+	if s.Credentials == nil {
+		s.mux().ServeConn(ctx, conn)
+		return
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	r := tagotip.NewEnvelopeReader(conn, 0)
+	w := tagotip.NewEnvelopeWriter(conn)
+	for {
+		envelope, err := r.Next()
+		if err != nil {
+			return
+		}
+		sealed, fallback := s.openAndAnswer(ctx, envelope)
+		if fallback != nil {
+			err = w.WritePlaintext(fallback)
+		} else if sealed != nil {
+			err = w.WriteEnvelope(sealed)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ServePacketConn answers every datagram read from pc, such as a UDP
+// socket or PipeTransport.PacketConn, in a datagram to its sender, until
+// pc fails. It returns the error of pc.ReadFrom.
+func (s *MockServer) ServePacketConn(pc net.PacketConn) error {
+	m := s.mux()
+	buf := make([]byte, maxRelayFrame)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		var reply []byte
+		if s.Credentials != nil && tagotip.IsEnvelope(buf[:n]) {
+			sealed, fallback := s.openAndAnswer(context.Background(), buf[:n])
+			reply = sealed
+			if fallback != nil {
+				reply = fallback
+			}
+		} else if ack := m.HandleFrame(context.Background(), string(buf[:n])); ack != nil {
+			raw, err := tagotip.BuildAck(ack)
+			if err != nil {
+				continue
+			}
+			reply = []byte(raw)
+		}
+		if reply != nil {
+			pc.WriteTo(reply, addr)
+		}
+	}
+}
+
+// openAndAnswer opens an uplink envelope of the device of s.Credentials and
+// returns its sealed ACK. An envelope that does not open is answered with
+// the plaintext ERR auth_failed in fallback instead.
+func (s *MockServer) openAndAnswer(ctx context.Context, envelope []byte) (sealed, fallback []byte) {
+	creds := s.Credentials
+	key, err := tagotip.DeriveKey(creds.Token, creds.Serial, 16)
+	if err != nil {
+		s.unexpectedEnvelope(err)
+		return nil, nil
+	}
+	h, err := tagotip.ParseEnvelopeHeader(envelope)
+	if err == nil {
+		_, _, _, err = tagotip.OpenEnvelope(envelope, key)
+	}
+	if err != nil {
+		s.unexpectedEnvelope(err)
+		raw, _ := tagotip.BuildAck(tagotip.NewAckErr(tagotip.ErrorCodeAuthFailed, nil))
+		return nil, []byte(raw)
+	}
+
+	var ack *tagotip.AckFrame
+	_, inner, method, err := tagotip.OpenUplinkFrame(envelope, key)
+	if err != nil {
+		s.unexpectedEnvelope(err)
+		ack = tagotip.NewAckErr(tagotip.ErrorCodeInvalidPayload, nil)
+	} else {
+		frame := &tagotip.UplinkFrame{Method: method, Auth: creds.Token, Serial: inner.Serial,
+			PushBody: inner.PushBody, PullBody: inner.PullBody}
+		if ack, err = s.HandleFrame(ctx, frame); err != nil {
+			ack = tagotip.NewAckErr(tagotip.ErrorCodeServerError, nil)
+		}
+	}
+	raw, err := tagotip.BuildAckInner(ack)
+	if err != nil {
+		return nil, nil
+	}
+	s.mu.Lock()
+	s.downlink++
+	counter := s.downlink
+	s.mu.Unlock()
+	sealed, err = tagotip.SealDownlink([]byte(raw), counter, h.AuthHash, h.DeviceHash, key, tagotip.CipherSuiteAes128Ccm)
+	if err != nil {
+		return nil, nil
+	}
+	return sealed, nil
+}
+
+// indent indents every line of s by two spaces.
+func indent(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return "  " + strings.ReplaceAll(s, "\n", "\n  ")
+}
@@ -0,0 +1,237 @@
+package tagotiptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	tagotip "github.com/tago-io/tagotip-sdk/tagotip-go"
+)
+
+// ============================================================================
+// Mock server
+// ============================================================================
+
+// mockClient serves s on a new PipeTransport and returns a Client on it.
+func mockClient(t *testing.T, s *MockServer) *tagotip.Client {
+	t.Helper()
+	p := NewPipeTransport()
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.ServeConn(ctx, p.Server)
+	c := tagotip.NewClient(p.Client, testAuth, "dev", &tagotip.ClientOptions{Sequencing: true})
+	t.Cleanup(func() {
+		c.Close()
+		cancel()
+		p.Close()
+	})
+	return c
+}
+
+// recordingTB records the errors reported to it.
+type recordingTB struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recordingTB) Helper() {}
+
+func (r *recordingTB) Error(args ...any) { r.errs = append(r.errs, fmt.Sprint(args...)) }
+
+func TestMockServerUnordered(t *testing.T) {
+	var s MockServer
+	s.Expect(tagotip.MethodPush).WithVariable("temp").Respond(AckOKCount(7))
+	s.Expect(tagotip.MethodPing)
+	s.Expect(tagotip.MethodPull).WithSerial("dev").WithVariable("temp")
+	c := mockClient(t, &s)
+	ctx := context.Background()
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ack, err := c.Push(ctx, temp)
+	if err != nil || ack.Detail.Count != 7 || *ack.Seq != 2 {
+		t.Fatalf("Push: %+v, %v", ack, err)
+	}
+	if _, err := c.Pull(ctx, "temp"); err != nil {
+		t.Fatal(err)
+	}
+	s.Verify(t)
+}
+
+func TestMockServerOrderedViolation(t *testing.T) {
+	s := MockServer{Ordered: true}
+	s.Expect(tagotip.MethodPush).WithVariable("temp")
+	s.Expect(tagotip.MethodPing)
+	c := mockClient(t, &s)
+	ctx := context.Background()
+
+	err := c.Ping(ctx)
+	var se *tagotip.ServerError
+	if !errors.As(err, &se) || se.Code != tagotip.ErrorCodeServerError {
+		t.Fatalf("PING before PUSH: %v", err)
+	}
+	if _, err := c.Push(ctx, temp); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &recordingTB{TB: t}
+	s.Verify(rec)
+	if len(rec.errs) != 1 {
+		t.Fatalf("Verify reported %q", rec.errs)
+	}
+	for _, want := range []string{
+		"expected PING, not received",
+		"frame out of order, expecting PUSH with temp:\n  PING !1 dev",
+	} {
+		if !strings.Contains(rec.errs[0], want) {
+			t.Errorf("Verify reported %q, missing %q", rec.errs[0], want)
+		}
+	}
+}
+
+func TestMockServerUnexpectedFrame(t *testing.T) {
+	var s MockServer
+	s.Expect(tagotip.MethodPush).WithVariable("hum")
+	c := mockClient(t, &s)
+
+	if _, err := c.Push(context.Background(), temp); err == nil {
+		t.Fatal("unexpected PUSH accepted")
+	}
+	err := s.Err()
+	if err == nil {
+		t.Fatal("Err is nil")
+	}
+	for _, want := range []string{
+		"expected PUSH with hum, not received",
+		"unexpected frame:\n  PUSH !1 dev auth=",
+		`temp := 21.5`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Err %q, missing %q", err, want)
+		}
+	}
+}
+
+// sealedPush seals a PUSH of temp from the device with serial as the next
+// envelope of session.
+func sealedPush(t *testing.T, session *tagotip.SecureSession, serial string) []byte {
+	t.Helper()
+	inner, err := tagotip.BuildHeadless(tagotip.MethodPush, &tagotip.HeadlessFrame{Serial: serial, PushBody: temp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := session.Seal(tagotip.EnvelopeMethodPush, []byte(inner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return envelope
+}
+
+func TestMockServerSecure(t *testing.T) {
+	s := MockServer{Credentials: &testCreds}
+	s.Expect(tagotip.MethodPush).WithSerial(testCreds.Serial).WithVariable("temp").Respond(AckOKCount(1))
+	p := NewPipeTransport()
+	defer p.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.ServeConn(ctx, p.Server)
+
+	session, err := tagotip.NewSecureSession(testCreds.Token, testCreds.Serial, tagotip.CipherSuiteAes128Ccm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tagotip.NewEnvelopeWriter(p.Client)
+	r := tagotip.NewEnvelopeReader(p.Client, 0)
+	if err := w.WriteEnvelope(sealedPush(t, session, testCreds.Serial)); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, ack, err := session.Open(reply)
+	if err != nil || ack.Status != tagotip.AckStatusOk || ack.Detail.Count != 1 || h.Counter != 1 {
+		t.Fatalf("ACK %+v, %v", ack, err)
+	}
+	s.Verify(t)
+
+	// Another device's envelope does not open, and is answered in
+	// plaintext.
+	other, _ := tagotip.NewSecureSession(testCreds.Token, "other", tagotip.CipherSuiteAes128Ccm, nil)
+	if err := w.WriteEnvelope(sealedPush(t, other, "other")); err != nil {
+		t.Fatal(err)
+	}
+	reply, err = r.Next()
+	if err != nil || string(reply) != "ACK|ERR|auth_failed" {
+		t.Fatalf("got %q, %v", reply, err)
+	}
+	if err := s.Err(); err == nil || !strings.Contains(err.Error(), "unreadable envelope") {
+		t.Errorf("Err: %v", err)
+	}
+}
+
+func TestMockServerPackets(t *testing.T) {
+	var s MockServer
+	s.Expect(tagotip.MethodPush).WithVariable("temp").Respond(AckOKCount(1))
+	p := NewPipeTransport()
+	defer p.Close()
+	go s.ServePacketConn(p.PacketConn())
+	c := tagotip.NewUDPClient(p.Client, testAuth, "dev", nil)
+	defer c.Close()
+
+	res, err := c.Push(context.Background(), temp)
+	if err != nil || res.Ack.Detail.Count != 1 {
+		t.Fatalf("Push: %+v, %v", res, err)
+	}
+	s.Verify(t)
+}
+
+func TestMockServerUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+	var s MockServer
+	s.Expect(tagotip.MethodPing)
+	go s.ServePacketConn(pc)
+
+	c, err := tagotip.DialUDP(pc.LocalAddr().String(), testAuth, "dev", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if res, err := c.Ping(context.Background()); err != nil || res.Ack.Status != tagotip.AckStatusPong {
+		t.Fatalf("Ping: %+v, %v", res, err)
+	}
+	s.Verify(t)
+}
+
+func TestMockServerTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	var s MockServer
+	s.Expect(tagotip.MethodPush).WithVariable("temp")
+	served := make(chan struct{})
+	go func() {
+		s.Serve(ln)
+		close(served)
+	}()
+
+	c, err := tagotip.Dial(ln.Addr().String(), testAuth, "dev", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack, err := c.Push(context.Background(), temp); err != nil || ack.Detail.Count != 1 {
+		t.Fatalf("Push: %+v, %v", ack, err)
+	}
+	c.Close()
+	ln.Close()
+	<-served
+	s.Verify(t)
+}
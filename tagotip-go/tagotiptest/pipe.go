@@ -0,0 +1,261 @@
+package tagotiptest
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Pipe transport
+// ---------------------------------------------------------------------------
+//
+// PipeTransport links a client and a server in memory through two net.Pipe
+// connections and a relay in each direction:
+//
+//	Client <-> relay <-> Server
+//
+// Each Write is one frame, as Client, UDPClient, and FrameMux write them,
+// and a reader with room for a whole frame receives it in one Read, so the
+// endpoints stand in for a TCP connection as well as for a UDP socket. The
+// relays pass frames on in order, applying the faults set on Uplink and
+// Downlink on the way. Delays run on a clock of the transport's own that
+// moves only when Advance is called, so that a test decides when a delayed
+// frame arrives.
+
+// maxRelayFrame bounds the frame a relay passes on whole, the largest UDP
+// datagram. A longer Write is relayed, and counted, as several frames.
+const maxRelayFrame = 1 << 16
+
+// PipeTransport is an in-memory connection between a client and a server
+// with faults injectable in each direction. Close it when done.
+type PipeTransport struct {
+	Client net.Conn // the device's end, for NewClient or NewUDPClient
+	Server net.Conn // the server's end, for FrameMux.ServeConn
+
+	Uplink   *Faults // applied to the frames written to Client
+	Downlink *Faults // applied to the frames written to Server
+
+	clock *pipeClock
+	done  chan struct{}
+	once  sync.Once
+	wg    sync.WaitGroup
+}
+
+// NewPipeTransport returns a PipeTransport without faults, its clock at
+// zero.
+func NewPipeTransport() *PipeTransport {
+	client, up := net.Pipe()
+	down, server := net.Pipe()
+	p := &PipeTransport{
+		Client: client,
+		Server: server,
+		clock:  &pipeClock{tick: make(chan struct{})},
+		done:   make(chan struct{}),
+	}
+	p.Uplink = &Faults{clock: p.clock}
+	p.Downlink = &Faults{clock: p.clock}
+	p.wg.Add(2)
+	go p.relay(p.Uplink, up, down)
+	go p.relay(p.Downlink, down, up)
+	return p
+}
+
+// Advance moves the transport's clock forward by d, releasing the frames
+// delayed until then.
+func (p *PipeTransport) Advance(d time.Duration) {
+	p.clock.advance(d)
+}
+
+// PacketConn returns the server's end as a net.PacketConn, each ReadFrom
+// returning one frame, for a server written against a UDP socket.
+func (p *PipeTransport) PacketConn() net.PacketConn {
+	return packetConn{p.Server}
+}
+
+// Close closes both ends, dropping the frames still held, and waits for the
+// relays to stop.
+func (p *PipeTransport) Close() error {
+	p.once.Do(func() { close(p.done) })
+	p.Client.Close()
+	p.Server.Close()
+	p.wg.Wait()
+	return nil
+}
+
+// relay passes the frames read from one end's peer on to the other's until
+// either closes. Closing one end thus closes the other, as with a network
+// connection.
+func (p *PipeTransport) relay(f *Faults, from, to net.Conn) {
+	defer p.wg.Done()
+	defer to.Close()
+	defer from.Close()
+	buf := make([]byte, maxRelayFrame)
+	for {
+		n, err := from.Read(buf)
+		if err != nil {
+			return
+		}
+		frame := buf[:n]
+		fault := f.next()
+		if fault.drop {
+			continue
+		}
+		if i := fault.corrupt; i >= 0 && i < len(frame) {
+			frame[i] ^= 1
+		}
+		if !p.clock.waitUntil(fault.due, p.done) {
+			return
+		}
+		for range fault.copies {
+			if _, err := to.Write(frame); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ---------------------------------------------------------------------------
+// Faults
+// ---------------------------------------------------------------------------
+
+// Faults are the faults a PipeTransport injects into the frames of one
+// direction. Each applies to the frames relayed after it is set, in the
+// order they are written. Its methods are safe for concurrent use.
+type Faults struct {
+	clock *pipeClock
+
+	mu      sync.Mutex
+	drop    int
+	dup     int
+	corrupt []int
+	delay   []time.Duration // due times on the transport's clock
+	frames  int
+}
+
+// fault is what happens to one frame.
+type fault struct {
+	drop    bool
+	copies  int
+	corrupt int // index of the byte to flip, or -1
+	due     time.Duration
+}
+
+// Drop discards the next n frames.
+func (f *Faults) Drop(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.drop += n
+}
+
+// Duplicate delivers each of the next n frames twice.
+func (f *Faults) Duplicate(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dup += n
+}
+
+// Delay holds the next frame not given a delay yet until the transport's
+// clock has been advanced by d from where it is now. The frames after it
+// wait behind it, as on a stream.
+func (f *Faults) Delay(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.delay = append(f.delay, f.clock.now()+d)
+}
+
+// Corrupt flips the lowest bit of byte i of the next frame not corrupted
+// yet, turning the P of PUSH into a Q, say. A frame shorter than i+1 bytes
+// is passed on intact.
+func (f *Faults) Corrupt(i int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.corrupt = append(f.corrupt, i)
+}
+
+// Frames returns the number of frames written in this direction so far,
+// those dropped included.
+func (f *Faults) Frames() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.frames
+}
+
+// next takes the faults for the next frame. A dropped frame uses up none
+// of the others.
+func (f *Faults) next() fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.frames++
+	if f.drop > 0 {
+		f.drop--
+		return fault{drop: true}
+	}
+	ft := fault{copies: 1, corrupt: -1}
+	if f.dup > 0 {
+		f.dup--
+		ft.copies = 2
+	}
+	if len(f.corrupt) > 0 {
+		ft.corrupt, f.corrupt = f.corrupt[0], f.corrupt[1:]
+	}
+	if len(f.delay) > 0 {
+		ft.due, f.delay = f.delay[0], f.delay[1:]
+	}
+	return ft
+}
+
+// pipeClock is the clock delays are measured on, as the time since the
+// transport was created.
+type pipeClock struct {
+	mu   sync.Mutex
+	t    time.Duration
+	tick chan struct{} // closed and replaced by every advance
+}
+
+func (c *pipeClock) now() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *pipeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t += d
+	close(c.tick)
+	c.tick = make(chan struct{})
+}
+
+// waitUntil blocks until the clock reaches due, and reports false if done
+// is closed first.
+func (c *pipeClock) waitUntil(due time.Duration, done <-chan struct{}) bool {
+	for {
+		c.mu.Lock()
+		t, tick := c.t, c.tick
+		c.mu.Unlock()
+		if t >= due {
+			return true
+		}
+		select {
+		case <-tick:
+		case <-done:
+			return false
+		}
+	}
+}
+
+// packetConn adapts a connection carrying one frame per Write to
+// net.PacketConn, every frame coming from and going to its peer.
+type packetConn struct {
+	net.Conn
+}
+
+func (c packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
+}
+
+func (c packetConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
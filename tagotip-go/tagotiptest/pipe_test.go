@@ -0,0 +1,183 @@
+package tagotiptest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tagotip "github.com/tago-io/tagotip-sdk/tagotip-go"
+)
+
+const testAuth = "at0123456789abcdef0123456789abcdef"
+
+// ============================================================================
+// Pipe transport
+// ============================================================================
+
+// countingMux returns a FrameMux answering PUSH with OK and the number of
+// structured variables, and the count of PUSH frames it has handled.
+func countingMux() (*tagotip.FrameMux, *atomic.Int32) {
+	var n atomic.Int32
+	m := &tagotip.FrameMux{}
+	m.HandlePush(func(_ context.Context, f *tagotip.UplinkFrame) (*tagotip.AckFrame, error) {
+		n.Add(1)
+		var count uint32
+		if sb := f.PushBody.Structured; sb != nil {
+			count = uint32(len(sb.Variables))
+		}
+		return &tagotip.AckFrame{Status: tagotip.AckStatusOk, Detail: &tagotip.AckDetail{Type: "count", Count: count}}, nil
+	})
+	return m, &n
+}
+
+// servePipe serves m on the server end of a new PipeTransport as a stream
+// until the test ends.
+func servePipe(t *testing.T, m *tagotip.FrameMux) *PipeTransport {
+	t.Helper()
+	p := NewPipeTransport()
+	ctx, cancel := context.WithCancel(context.Background())
+	go m.ServeConn(ctx, p.Server)
+	t.Cleanup(func() {
+		cancel()
+		p.Close()
+	})
+	return p
+}
+
+// servePackets answers every frame read from pc with m, one datagram each
+// way, until pc is closed.
+func servePackets(m *tagotip.FrameMux, pc net.PacketConn) {
+	buf := make([]byte, maxRelayFrame)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		ack := m.HandleFrame(context.Background(), string(buf[:n]))
+		if ack == nil {
+			continue
+		}
+		if raw, err := tagotip.BuildAck(ack); err == nil {
+			pc.WriteTo([]byte(raw), addr)
+		}
+	}
+}
+
+var temp = &tagotip.PushBody{Structured: &tagotip.StructuredBody{Variables: []tagotip.Variable{
+	{Name: "temp", Operator: tagotip.OperatorNumber, Value: tagotip.Value{Type: tagotip.OperatorNumber, Str: "21.5"}},
+}}}
+
+func TestPipeTransportExchange(t *testing.T) {
+	m, pushes := countingMux()
+	p := servePipe(t, m)
+	c := tagotip.NewClient(p.Client, testAuth, "dev", &tagotip.ClientOptions{Sequencing: true})
+	defer c.Close()
+
+	ack, err := c.Push(context.Background(), temp)
+	if err != nil || ack.Status != tagotip.AckStatusOk || ack.Detail.Count != 1 || *ack.Seq != 1 {
+		t.Fatalf("Push: %+v, %v", ack, err)
+	}
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	if pushes.Load() != 1 || p.Uplink.Frames() != 2 || p.Downlink.Frames() != 2 {
+		t.Errorf("%d pushes, %d frames up, %d down", pushes.Load(), p.Uplink.Frames(), p.Downlink.Frames())
+	}
+}
+
+func TestPipeTransportDropRetransmits(t *testing.T) {
+	m, pushes := countingMux()
+	p := NewPipeTransport()
+	defer p.Close()
+	go servePackets(m, p.PacketConn())
+	c := tagotip.NewUDPClient(p.Client, testAuth, "dev", &tagotip.UDPOptions{RetryInterval: 20 * time.Millisecond})
+	defer c.Close()
+
+	p.Downlink.Drop(1)
+	res, err := c.Push(context.Background(), temp)
+	if err != nil || res.Retries != 1 || res.Ack.Detail.Count != 1 {
+		t.Fatalf("Push: %+v, %v", res, err)
+	}
+	if pushes.Load() != 2 {
+		t.Errorf("server handled %d frames, want the frame and its retransmission", pushes.Load())
+	}
+}
+
+func TestPipeTransportDelay(t *testing.T) {
+	m, _ := countingMux()
+	p := servePipe(t, m)
+	c := tagotip.NewClient(p.Client, testAuth, "dev", nil)
+	defer c.Close()
+
+	p.Downlink.Delay(time.Minute)
+	done := make(chan error, 1)
+	go func() { done <- c.Ping(context.Background()) }()
+
+	p.Advance(59 * time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("ACK arrived before its delay: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	p.Advance(time.Second)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipeTransportDuplicate(t *testing.T) {
+	m, pushes := countingMux()
+	p := servePipe(t, m)
+	c := tagotip.NewClient(p.Client, testAuth, "dev", &tagotip.ClientOptions{Sequencing: true})
+	defer c.Close()
+
+	p.Uplink.Duplicate(1)
+	if _, err := c.Push(context.Background(), temp); err != nil {
+		t.Fatal(err)
+	}
+	// The second ACK of the PUSH is dropped by the client, which then
+	// matches the ACK of the PING.
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if pushes.Load() != 2 {
+		t.Errorf("server handled %d frames, want 2", pushes.Load())
+	}
+}
+
+func TestPipeTransportCorrupt(t *testing.T) {
+	m, _ := countingMux()
+	p := servePipe(t, m)
+	c := tagotip.NewClient(p.Client, testAuth, "dev", nil)
+	defer c.Close()
+
+	p.Uplink.Corrupt(0) // QUSH
+	_, err := c.Push(context.Background(), temp)
+	var se *tagotip.ServerError
+	if !errors.As(err, &se) || se.Code != tagotip.ErrorCodeInvalidMethod {
+		t.Fatalf("got %v, want invalid_method", err)
+	}
+	if _, err := c.Push(context.Background(), temp); err != nil {
+		t.Errorf("frame after the corrupted one: %v", err)
+	}
+}
+
+func TestPipeTransportCloseEndsPeer(t *testing.T) {
+	m, _ := countingMux()
+	p := NewPipeTransport()
+	defer p.Close()
+	served := make(chan struct{})
+	go func() {
+		m.ServeConn(context.Background(), p.Server)
+		close(served)
+	}()
+	p.Client.Close()
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeConn did not return after the client closed")
+	}
+}
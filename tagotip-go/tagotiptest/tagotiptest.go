@@ -0,0 +1,29 @@
+// Package tagotiptest provides test helpers for TagoTiP implementations.
+package tagotiptest
+
+import (
+	"testing"
+
+	tagotip "github.com/tago-io/tagotip-sdk/tagotip-go"
+)
+
+// RunCorpus verifies the golden corpus at path, running each entry as a
+// subtest named after it. See tagotip.VerifyCorpus for the file format.
+func RunCorpus(t *testing.T, path string) {
+	t.Helper()
+	report, err := tagotip.VerifyCorpus(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Results) == 0 {
+		t.Fatalf("corpus %s has no entries", path)
+	}
+	for _, res := range report.Results {
+		res := res
+		t.Run(res.Name, func(t *testing.T) {
+			if res.Failure != "" {
+				t.Errorf("%s: %s", res.Direction, res.Failure)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package tagotip
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Timestamps and clock skew
+// ---------------------------------------------------------------------------
+//
+// Frame timestamps are Unix times in milliseconds. Devices whose clocks
+// drift produce timestamps that are off by the drift; a clock offset here
+// is always how far the device clock runs ahead of true time, so a device
+// 2s fast has an offset of 2*time.Second and a slow one a negative offset.
+// Correcting a timestamp subtracts the offset.
+
+// OrigTimestampMetaKey is the metadata key under which ClampTimestamps
+// records a timestamp it replaced.
+const OrigTimestampMetaKey = "orig_ts"
+
+// formatTimestamp writes t as Unix milliseconds. Times before the epoch,
+// which frames cannot carry, are written as 0.
+func formatTimestamp(t time.Time) string {
+	return strconv.FormatInt(max(t.UnixMilli(), 0), 10)
+}
+
+// parseTimestamp reads a frame timestamp. ok is false when s overflows.
+func parseTimestamp(s string) (t time.Time, ok bool) {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(ms), true
+}
+
+// Time returns the timestamp of v, read as Unix milliseconds whatever its
+// number of digits. ok is false when v has no timestamp; a timestamp too
+// large for an int64 of milliseconds is an error. SetTimestamp is the
+// reverse.
+func (v *Variable) Time() (t time.Time, ok bool, err error) {
+	return timestampTime(v.Timestamp)
+}
+
+// Time returns the body-level timestamp of b, as Variable.Time does.
+func (b *StructuredBody) Time() (t time.Time, ok bool, err error) {
+	return timestampTime(b.Timestamp)
+}
+
+func timestampTime(ts *string) (time.Time, bool, error) {
+	if ts == nil {
+		return time.Time{}, false, nil
+	}
+	t, ok := parseTimestamp(*ts)
+	if !ok {
+		return time.Time{}, true, fmt.Errorf("tagotip: timestamp %q is out of range", *ts)
+	}
+	return t, true, nil
+}
+
+// SetTimestamp sets the timestamp of v to t.
+func (v *Variable) SetTimestamp(t time.Time) {
+	ts := formatTimestamp(t)
+	v.Timestamp = &ts
+}
+
+// SetTimestampWithSkew sets the timestamp of v to t, read from a clock
+// running knownOffset ahead of true time, corrected by that offset.
+func (v *Variable) SetTimestampWithSkew(t time.Time, knownOffset time.Duration) {
+	v.SetTimestamp(t.Add(-knownOffset))
+}
+
+// SetTimestamp sets the body-level timestamp of b to t.
+func (b *StructuredBody) SetTimestamp(t time.Time) {
+	ts := formatTimestamp(t)
+	b.Timestamp = &ts
+}
+
+// SetTimestampWithSkew sets the body-level timestamp of b to t, read from a
+// clock running knownOffset ahead of true time, corrected by that offset.
+func (b *StructuredBody) SetTimestampWithSkew(t time.Time, knownOffset time.Duration) {
+	b.SetTimestamp(t.Add(-knownOffset))
+}
+
+// AdjustTimestamps returns a copy of frame with offset, the measured offset
+// of the device clock, subtracted from every body-level and variable
+// timestamp. Timestamps too large to read are left as they are.
+func AdjustTimestamps(frame *UplinkFrame, offset time.Duration) *UplinkFrame {
+	c := cloneUplink(frame)
+	sb := structuredBody(c)
+	if sb == nil || offset == 0 {
+		return c
+	}
+	adjust := func(ts *string) {
+		if ts == nil {
+			return
+		}
+		if t, ok := parseTimestamp(*ts); ok {
+			*ts = formatTimestamp(t.Add(-offset))
+		}
+	}
+	adjust(sb.Timestamp)
+	for i := range sb.Variables {
+		adjust(sb.Variables[i].Timestamp)
+	}
+	return c
+}
+
+// ClampTimestamps returns a copy of frame in which every body-level or
+// variable timestamp more than window away from received, the time the
+// frame was received, is replaced by received. The replaced timestamp is
+// recorded in the metadata of the body or variable under
+// OrigTimestampMetaKey, unless a pair with that key is already present.
+// Variables without a timestamp of their own follow the body's. A frame
+// with every timestamp in window is returned as an unchanged copy.
+//
+// It fails if the metadata to record an original timestamp in is full.
+func ClampTimestamps(frame *UplinkFrame, received time.Time, window time.Duration) (*UplinkFrame, error) {
+	c := cloneUplink(frame)
+	sb := structuredBody(c)
+	if sb == nil {
+		return c, nil
+	}
+	now := formatTimestamp(received)
+	added := 0
+	clamp := func(ts *string, meta *MetaPairs) error {
+		if ts == nil {
+			return nil
+		}
+		if t, ok := parseTimestamp(*ts); ok && t.Sub(received).Abs() <= window {
+			return nil
+		}
+		if !hasMetaKey(*meta, OrigTimestampMetaKey) {
+			if len(*meta) >= MaxMetaPairs {
+				return fmt.Errorf("tagotip: no room in metadata to record %s=%s", OrigTimestampMetaKey, *ts)
+			}
+			*meta = append(*meta, MetaPair{Key: OrigTimestampMetaKey, Value: *ts})
+			added++
+		}
+		*ts = now
+		return nil
+	}
+	if err := clamp(sb.Timestamp, &sb.Meta); err != nil {
+		return nil, err
+	}
+	items := len(sb.Variables) + len(sb.Meta)
+	for i := range sb.Variables {
+		v := &sb.Variables[i]
+		if err := clamp(v.Timestamp, &v.Meta); err != nil {
+			return nil, err
+		}
+		items += len(v.Meta)
+	}
+	if added > 0 && items > DefaultMaxTotalItems {
+		return nil, fmt.Errorf("tagotip: recording original timestamps exceeds %d items", DefaultMaxTotalItems)
+	}
+	return c, nil
+}
+
+func hasMetaKey(pairs []MetaPair, key string) bool {
+	for _, p := range pairs {
+		if p.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package tagotip
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// Timestamps and clock skew
+// ============================================================================
+
+func TestSetTimestampWithSkew(t *testing.T) {
+	at := time.UnixMilli(1700000000000)
+	var v Variable
+	v.SetTimestampWithSkew(at, 2*time.Second)
+	if *v.Timestamp != "1699999998000" {
+		t.Errorf("fast clock: got %s", *v.Timestamp)
+	}
+	var b StructuredBody
+	b.SetTimestampWithSkew(at.In(time.FixedZone("UTC-3", -3*3600)), -1500*time.Millisecond)
+	if *b.Timestamp != "1700000001500" {
+		t.Errorf("slow clock in another zone: got %s", *b.Timestamp)
+	}
+	v.SetTimestamp(time.Unix(-5, 0))
+	if *v.Timestamp != "0" {
+		t.Errorf("before epoch: got %s", *v.Timestamp)
+	}
+}
+
+func TestAdjustTimestamps(t *testing.T) {
+	frame := mustParse(t, "PUSH|"+testAuth+"|dev|@1700000010000[a:=1;b:=2@1700000005000;c:=3@99999999999999999999]")
+	adj := AdjustTimestamps(frame, 10*time.Second)
+	raw, _ := BuildUplink(adj)
+	want := "PUSH|" + testAuth + "|dev|@1700000000000[a:=1;b:=2@1699999995000;c:=3@99999999999999999999]"
+	if raw != want {
+		t.Errorf("got  %s\nwant %s", raw, want)
+	}
+	if *frame.PushBody.Structured.Timestamp != "1700000010000" {
+		t.Error("input frame was modified")
+	}
+}
+
+func TestClampTimestamps(t *testing.T) {
+	received := time.UnixMilli(1700000000000)
+	frame := mustParse(t, "PUSH|"+testAuth+"|dev|@1600000000000{fw=2}[a:=1;b:=2@1700000030000;c:=3@1800000000000{q=ok};d:=4@5{orig_ts=1}]")
+	clamped, err := ClampTimestamps(frame, received, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := BuildUplink(clamped)
+	want := "PUSH|" + testAuth + "|dev|@1700000000000{fw=2,orig_ts=1600000000000}" +
+		"[a:=1;b:=2@1700000030000;c:=3@1700000000000{q=ok,orig_ts=1800000000000};d:=4@1700000000000{orig_ts=1}]"
+	if raw != want {
+		t.Errorf("got  %s\nwant %s", raw, want)
+	}
+	if orig, _ := BuildUplink(frame); strings.Contains(orig, OrigTimestampMetaKey+"=1600") {
+		t.Error("input frame was modified")
+	}
+	if _, err := ParseUplink(raw); err != nil {
+		t.Errorf("clamped frame does not parse: %v", err)
+	}
+}
+
+func TestClampTimestampsInWindow(t *testing.T) {
+	received := time.UnixMilli(1700000000000)
+	for _, input := range []string{
+		"PUSH|" + testAuth + "|dev|@1699999990000[a:=1;b:=2@1700000005000{x=1}]",
+		"PUSH|" + testAuth + "|dev|[a:=1]",
+		"PUSH|" + testAuth + "|dev|>xABCD",
+		"PING|" + testAuth + "|dev",
+	} {
+		frame := mustParse(t, input)
+		clamped, err := ClampTimestamps(frame, received, 10*time.Second)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		if !clamped.Equal(frame) {
+			got, _ := BuildUplink(clamped)
+			t.Errorf("%s: changed to %s", input, got)
+		}
+	}
+}
+
+func TestClampTimestampsFullMetadata(t *testing.T) {
+	pairs := make([]string, MaxMetaPairs)
+	for i := range pairs {
+		pairs[i] = "k" + strings.Repeat("x", i) + "=1"
+	}
+	frame := mustParse(t, "PUSH|"+testAuth+"|dev|[a:=1@5{"+strings.Join(pairs, ",")+"}]")
+	if _, err := ClampTimestamps(frame, time.UnixMilli(1700000000000), time.Minute); err == nil {
+		t.Error("expected an error for full metadata")
+	}
+}
+
+func TestTimestampTime(t *testing.T) {
+	frame := mustParse(t, "PUSH|"+testAuth+"|dev|@1700000000123[a:=1@1700000000;b:=2;c:=3@99999999999999999999]")
+	sb := frame.PushBody.Structured
+	if ts, ok, err := sb.Time(); !ok || err != nil || !ts.Equal(time.UnixMilli(1700000000123)) {
+		t.Errorf("body: %v, %v, %v", ts, ok, err)
+	}
+	// Ten digits are still milliseconds, not seconds.
+	if ts, ok, err := sb.Variables[0].Time(); !ok || err != nil || !ts.Equal(time.UnixMilli(1700000000)) {
+		t.Errorf("10 digits: %v, %v, %v", ts, ok, err)
+	}
+	if _, ok, err := sb.Variables[1].Time(); ok || err != nil {
+		t.Errorf("absent: %v, %v", ok, err)
+	}
+	if _, ok, err := sb.Variables[2].Time(); !ok || err == nil {
+		t.Errorf("overflow: %v, %v", ok, err)
+	}
+
+	var v Variable
+	want := time.Date(2024, 3, 1, 12, 0, 0, 250e6, time.UTC)
+	v.SetTimestamp(want)
+	if ts, _, _ := v.Time(); !ts.Equal(want) {
+		t.Errorf("round trip: %v", ts)
+	}
+}
@@ -7,6 +7,9 @@ const (
 	MethodPush Method = iota
 	MethodPull
 	MethodPing
+	// MethodUnknown marks a frame whose method is not recognized, parsed
+	// under ParserOptions.AllowUnknownMethods. Such frames cannot be built.
+	MethodUnknown
 )
 
 // Operator represents the variable value type hint.
@@ -71,12 +74,13 @@ type LocationValue struct {
 // Value represents a parsed variable value.
 type Value struct {
 	Type     Operator // Discriminant matching operator
-	Str      string   // Number or String raw value
+	Str      string   // Number as written, or unescaped String value
 	Bool     bool     // Boolean value
 	Location *LocationValue
 }
 
-// Variable represents a parsed variable with optional suffixes.
+// Variable represents a parsed variable with optional suffixes. Under
+// ParserOptions.LazyMeta, Meta stays empty until Metadata is called.
 type Variable struct {
 	Name      string
 	Operator  Operator
@@ -84,21 +88,31 @@ type Variable struct {
 	Unit      *string // nil if not present
 	Timestamp *string // nil if not present
 	Group     *string // nil if not present
-	Meta      []MetaPair
+	Meta      MetaPairs
+
+	rawMeta string // unparsed metadata block kept by a lazy parse
 }
 
-// StructuredBody represents a structured PUSH body.
+// StructuredBody represents a structured PUSH body. Under
+// ParserOptions.LazyMeta, Meta stays empty until Metadata is called.
 type StructuredBody struct {
 	Group     *string
 	Timestamp *string
-	Meta      []MetaPair
+	Meta      MetaPairs
 	Variables []Variable
+
+	rawMeta string // unparsed metadata block kept by a lazy parse
+
+	groupSpare, timestampSpare *string // kept by Reset for reuse
 }
 
 // PassthroughBody represents a passthrough PUSH body.
 type PassthroughBody struct {
 	Encoding PassthroughEncoding
 	Data     string
+
+	decoded  []byte // decode buffer, kept across Reset
+	retained bool   // decoded holds the payload (ParserOptions.RetainPassthrough)
 }
 
 // PushBody represents a PUSH body (structured or passthrough).
@@ -106,6 +120,9 @@ type PushBody struct {
 	IsPassthrough bool
 	Structured    *StructuredBody
 	Passthrough   *PassthroughBody
+
+	structuredSpare  *StructuredBody  // kept by Reset for reuse
+	passthroughSpare *PassthroughBody // kept by Reset for reuse
 }
 
 // PullBody represents a PULL body with variable names.
@@ -121,6 +138,28 @@ type UplinkFrame struct {
 	Serial   string
 	PushBody *PushBody
 	PullBody *PullBody
+
+	// PingDiagnostics holds the link diagnostics a PING may carry, such as
+	// "PING|<auth>|<serial>|{rssi=-87,bat=3.71}", when parsed under
+	// ParserOptions.AllowPingDiagnostics. Values are unescaped.
+	PingDiagnostics []MetaPair
+
+	// Signature is the hex signature of a signed frame, set when parsed
+	// with ParserOptions.Verifier.
+	Signature string
+
+	// RawMethod and RawBody hold the method token and the unparsed body of
+	// a MethodUnknown frame; RawBody is empty when the frame has no body.
+	RawMethod string
+	RawBody   string
+
+	// Warnings lists the discouraged constructs found in the frame when
+	// parsed with ParserOptions.CollectWarnings.
+	Warnings []Warning
+
+	seqSpare  *uint32   // kept by Reset for reuse
+	pushSpare *PushBody // kept by Reset for reuse
+	pullSpare *PullBody // kept by Reset for reuse
 }
 
 // HeadlessFrame represents a headless inner frame for TagoTiP/S.
@@ -138,6 +177,28 @@ type AckDetail struct {
 	Count     uint32
 	Text      string
 	ErrorCode ErrorCode
+
+	// Vars is the typed form of a "variables" detail, Pong that of the
+	// detail of a PONG, Cmd that of a command with a binary payload, and
+	// Err that of the fields following an error code. When the typed form
+	// is nil, BuildAck writes Text as given. An "error" detail with empty
+	// Text is written as the canonical name of ErrorCode.
+	Vars *VariablesDetail
+	Pong *PongDetail
+	Cmd  *CommandDetail
+	Err  *ErrorDetail
+
+	// Variables holds the variables of a "variables" detail that parsed as
+	// a well-formed block, as does Vars. BuildAck writes them when Vars is
+	// nil and Text is empty.
+	Variables []Variable
+}
+
+// VariablesDetail is the typed form of an "ACK|OK|[...]" detail. Like the
+// variables of an uplink, these hold plain text: BuildAck escapes string
+// values, units, and metadata values, and ParseAck unescapes them.
+type VariablesDetail struct {
+	Variables []Variable
 }
 
 // AckFrame represents a parsed ACK (downlink) frame.
@@ -145,4 +206,8 @@ type AckFrame struct {
 	Seq    *uint32
 	Status AckStatus
 	Detail *AckDetail
+
+	seqSpare    *uint32    // kept by Reset for reuse
+	detailSpare *AckDetail // kept by Reset for reuse
+	detailPos   int        // offset of Detail in the parsed input
 }
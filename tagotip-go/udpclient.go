@@ -0,0 +1,238 @@
+package tagotip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// UDP client
+// ---------------------------------------------------------------------------
+//
+// Over UDP each frame travels alone in a datagram, without a newline, and
+// either the frame or its ACK may be lost. UDPClient numbers every frame
+// with a seq and sends it again, unchanged, until the ACK carrying that seq
+// arrives, the retries run out, or the context ends. ACKs are matched by
+// seq only, through PendingAcks, so a duplicate ACK for a frame sent twice
+// is dropped once the first has been delivered.
+
+// DefaultUDPDatagramSize is the datagram size limit used when
+// UDPOptions.MaxDatagramSize is not set. Frames of this size fit the
+// 1280-byte minimum IPv6 MTU with room for the IP and UDP headers.
+const DefaultUDPDatagramSize = 1200
+
+// ErrNoAck is returned by UDPClient calls whose frame was sent as many
+// times as allowed without an ACK arriving.
+var ErrNoAck = errors.New("tagotip: no ACK received")
+
+// UDPOptions configures a UDPClient.
+type UDPOptions struct {
+	// MaxDatagramSize caps the size of a frame, so that it fits a single
+	// datagram. Zero means DefaultUDPDatagramSize; it cannot exceed
+	// MaxFrameSize.
+	MaxDatagramSize int
+
+	// Retries is the number of times a frame is sent again after the first
+	// time. Zero means 3; negative means never.
+	Retries int
+
+	// RetryInterval is how long to wait for an ACK before sending the frame
+	// again, and after the last send before giving up. Zero means one
+	// second.
+	RetryInterval time.Duration
+
+	// IdempotencyKeys stamps the structured body of every Push with a new
+	// idempotency key, which its retransmissions carry unchanged, so that
+	// an IdempotencyCache can tell them from new frames even once seq
+	// numbering restarts. A body that already carries a key keeps it.
+	IdempotencyKeys bool
+}
+
+// UDPResult is the outcome of a UDPClient call.
+type UDPResult struct {
+	Ack     *AckFrame
+	Retries int // times the frame was sent again before the ACK arrived
+}
+
+// UDPClient sends frames for one device as UDP datagrams. It is safe for
+// concurrent use.
+type UDPClient struct {
+	conn   net.Conn
+	auth   string
+	serial string
+
+	maxSize  int
+	retries  int
+	interval time.Duration
+	idem     bool
+
+	seq     SeqTracker
+	pending *PendingAcks
+
+	mu   sync.Mutex
+	err  error // set when the connection is closed or fails
+	done chan struct{}
+}
+
+// DialUDP returns a UDPClient sending to the server at addr, a UDP
+// host:port, for the device with the given authorization hash and serial.
+// A nil opts is equivalent to the zero value.
+func DialUDP(addr, auth, serial string, opts *UDPOptions) (*UDPClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewUDPClient(conn, auth, serial, opts), nil
+}
+
+// NewUDPClient returns a UDPClient using conn, a connection that preserves
+// datagram boundaries, which it takes over.
+func NewUDPClient(conn net.Conn, auth, serial string, opts *UDPOptions) *UDPClient {
+	var o UDPOptions
+	if opts != nil {
+		o = *opts
+	}
+	c := &UDPClient{
+		conn:     conn,
+		auth:     auth,
+		serial:   serial,
+		maxSize:  o.MaxDatagramSize,
+		retries:  o.Retries,
+		interval: o.RetryInterval,
+		idem:     o.IdempotencyKeys,
+		done:     make(chan struct{}),
+	}
+	if c.maxSize <= 0 {
+		c.maxSize = DefaultUDPDatagramSize
+	}
+	c.maxSize = min(c.maxSize, MaxFrameSize)
+	switch {
+	case c.retries == 0:
+		c.retries = 3
+	case c.retries < 0:
+		c.retries = 0
+	}
+	if c.interval <= 0 {
+		c.interval = time.Second
+	}
+	// A call waits at most one interval after each send.
+	c.pending = NewPendingAcks(c.interval * time.Duration(c.retries+2))
+	go c.readLoop()
+	return c
+}
+
+// Close closes the connection. Calls in progress return ErrClientClosed.
+func (c *UDPClient) Close() error {
+	c.fail(ErrClientClosed)
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// Push sends a PUSH with body. An ERR ACK is returned as a *ServerError,
+// along with the result.
+func (c *UDPClient) Push(ctx context.Context, body *PushBody) (*UDPResult, error) {
+	return c.call(ctx, &UplinkFrame{Method: MethodPush, PushBody: body})
+}
+
+// Pull sends a PULL for the named variables. An ERR ACK is returned as a
+// *ServerError, along with the result.
+func (c *UDPClient) Pull(ctx context.Context, vars ...string) (*UDPResult, error) {
+	return c.call(ctx, &UplinkFrame{Method: MethodPull, PullBody: &PullBody{Variables: vars}})
+}
+
+// Ping sends a PING. An ERR ACK is returned as a *ServerError, along with
+// the result.
+func (c *UDPClient) Ping(ctx context.Context) (*UDPResult, error) {
+	return c.call(ctx, &UplinkFrame{Method: MethodPing})
+}
+
+func (c *UDPClient) call(ctx context.Context, f *UplinkFrame) (*UDPResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := c.closedErr(); err != nil {
+		return nil, err
+	}
+	if c.idem && f.Method == MethodPush {
+		body, err := withIdempotencyKey(f.PushBody)
+		if err != nil {
+			return nil, err
+		}
+		f.PushBody = body
+	}
+	seq := c.seq.Next()
+	f.Auth, f.Serial, f.Seq = c.auth, c.serial, &seq
+	raw, err := BuildUplink(f)
+	if err == nil {
+		err = checkFrameSize(len(raw), c.maxSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ack := c.pending.Register(seq)
+	defer c.pending.Cancel(seq)
+
+	datagram := []byte(raw)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	res := &UDPResult{Retries: -1}
+	for {
+		select {
+		case a, ok := <-ack:
+			if !ok {
+				return nil, fmt.Errorf("%w after %d retries", ErrNoAck, res.Retries)
+			}
+			res.Ack = a
+			return res, ackError(a)
+		case <-c.done:
+			return nil, c.closedErr()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			if res.Retries == c.retries {
+				return nil, fmt.Errorf("%w after %d retries", ErrNoAck, res.Retries)
+			}
+			if _, err := c.conn.Write(datagram); err != nil {
+				return nil, err
+			}
+			res.Retries++
+			timer.Reset(c.interval)
+		}
+	}
+}
+
+func (c *UDPClient) readLoop() {
+	defer close(c.done)
+	buf := make([]byte, MaxFrameSize+1)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			c.fail(fmt.Errorf("tagotip: reading ACK: %w", err))
+			return
+		}
+		if ack, err := ParseAck(string(buf[:n])); err == nil {
+			c.pending.Resolve(ack)
+		}
+	}
+}
+
+// fail records err as the reason the client stopped, if none is yet.
+// Waiting calls are released when the read loop ends.
+func (c *UDPClient) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+}
+
+func (c *UDPClient) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
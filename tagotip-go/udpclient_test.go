@@ -0,0 +1,154 @@
+package tagotip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// UDP client
+// ============================================================================
+
+// udpServer answers each datagram with the ACKs reply returns for it,
+// one datagram per ACK.
+func udpServer(t *testing.T, reply func(f *UplinkFrame, n int) []string) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, MaxFrameSize)
+		for n := 1; ; n++ {
+			size, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			f, err := ParseUplink(string(buf[:size]))
+			if err != nil {
+				t.Errorf("server got %q: %v", buf[:size], err)
+				return
+			}
+			for _, ack := range reply(f, n) {
+				pc.WriteTo([]byte(ack), addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func dialUDP(t *testing.T, addr string, opts *UDPOptions) *UDPClient {
+	t.Helper()
+	c, err := DialUDP(addr, testAuth, "dev", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func ackFor(f *UplinkFrame, rest string) string {
+	return "ACK|!" + strconv.FormatUint(uint64(*f.Seq), 10) + "|" + rest
+}
+
+func TestUDPClientCalls(t *testing.T) {
+	addr := udpServer(t, func(f *UplinkFrame, _ int) []string {
+		if f.Seq == nil || f.Auth != testAuth || f.Serial != "dev" {
+			t.Errorf("server got %+v", f)
+		}
+		switch f.Method {
+		case MethodPull:
+			return []string{ackFor(f, "OK|["+f.PullBody.Variables[0]+":=1]")}
+		case MethodPing:
+			return []string{ackFor(f, "PONG")}
+		}
+		return []string{ackFor(f, "ERR|invalid_payload")}
+	})
+	c := dialUDP(t, addr, nil)
+	ctx := context.Background()
+
+	res, err := c.Ping(ctx)
+	if err != nil || res.Retries != 0 || res.Ack.Status != AckStatusPong || *res.Ack.Seq != 1 {
+		t.Errorf("Ping: %+v, %v", res, err)
+	}
+	res, err = c.Pull(ctx, "temp")
+	if err != nil || *res.Ack.Seq != 2 || res.Ack.Detail.Vars.Variables[0].Name != "temp" {
+		t.Errorf("Pull: %+v, %v", res, err)
+	}
+	body := &PushBody{Structured: &StructuredBody{Variables: []Variable{
+		{Name: "t", Operator: OperatorNumber, Value: Value{Type: OperatorNumber, Str: "1"}},
+	}}}
+	res, err = c.Push(ctx, body)
+	var se *ServerError
+	if !errors.As(err, &se) || se.Code != ErrorCodeInvalidPayload || res == nil || res.Ack != se.Ack {
+		t.Errorf("Push: %+v, %v", res, err)
+	}
+}
+
+func TestUDPClientRetransmits(t *testing.T) {
+	// The first two copies of each frame are lost; the third is answered
+	// twice.
+	var seen atomic.Int32
+	addr := udpServer(t, func(f *UplinkFrame, n int) []string {
+		seen.Add(1)
+		if n%3 != 0 {
+			return nil
+		}
+		return []string{ackFor(f, "OK|"+strconv.Itoa(n)), ackFor(f, "OK|"+strconv.Itoa(n))}
+	})
+	c := dialUDP(t, addr, &UDPOptions{RetryInterval: 20 * time.Millisecond})
+	res, err := c.Ping(context.Background())
+	if err != nil || res.Retries != 2 || *res.Ack.Seq != 1 {
+		t.Fatalf("got %+v, %v", res, err)
+	}
+	// The duplicate ACK of seq 1 is not taken for seq 2.
+	res, err = c.Ping(context.Background())
+	if err != nil || res.Retries != 2 || *res.Ack.Seq != 2 || res.Ack.Detail.Count != 6 {
+		t.Fatalf("got %+v, %v", res, err)
+	}
+	if n := seen.Load(); n != 6 {
+		t.Errorf("server got %d datagrams", n)
+	}
+}
+
+func TestUDPClientGivesUp(t *testing.T) {
+	var seen atomic.Int32
+	addr := udpServer(t, func(*UplinkFrame, int) []string {
+		seen.Add(1)
+		return nil
+	})
+	c := dialUDP(t, addr, &UDPOptions{Retries: 2, RetryInterval: 10 * time.Millisecond})
+	if _, err := c.Ping(context.Background()); !errors.Is(err, ErrNoAck) {
+		t.Errorf("got %v", err)
+	}
+	if n := seen.Load(); n != 3 {
+		t.Errorf("sent %d times", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Millisecond)
+	defer cancel()
+	c = dialUDP(t, addr, &UDPOptions{Retries: 100, RetryInterval: time.Hour})
+	if _, err := c.Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestUDPClientDatagramSize(t *testing.T) {
+	addr := udpServer(t, func(f *UplinkFrame, _ int) []string { return []string{ackFor(f, "OK")} })
+	c := dialUDP(t, addr, &UDPOptions{MaxDatagramSize: 64})
+	_, err := c.Pull(context.Background(), strings.Repeat("v", 64))
+	var fe *FrameSizeError
+	if !errors.As(err, &fe) || fe.Limit != 64 {
+		t.Errorf("got %v", err)
+	}
+	if _, err := c.Pull(context.Background(), "v"); err != nil {
+		t.Error(err)
+	}
+}
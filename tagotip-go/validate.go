@@ -1,5 +1,10 @@
 package tagotip
 
+import (
+	"fmt"
+	"reflect"
+)
+
 func isLowercaseAlnumUnderscore(c byte) bool {
 	return (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_'
 }
@@ -8,20 +13,31 @@ func isSerialChar(c byte) bool {
 	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_'
 }
 
+// The validators below check a field against the protocol limits; their
+// Len forms take the limit, which the parser reads from ParseLimits.
+
 func validateVarname(s string, pos int) error {
-	if len(s) == 0 || len(s) > MaxVarNameLen {
-		return fail(ErrInvalidVariable, pos)
+	return validateVarnameLen(s, pos, MaxVarNameLen)
+}
+
+func validateVarnameLen(s string, pos, max int) error {
+	if len(s) == 0 || len(s) > max {
+		return failf(ErrInvalidVariable, pos, fmt.Sprintf("expected a variable name of 1 to %d characters", max))
 	}
 	for i := 0; i < len(s); i++ {
 		if !isLowercaseAlnumUnderscore(s[i]) {
-			return fail(ErrInvalidVariable, pos)
+			return failf(ErrInvalidVariable, pos, "variable name may only contain a-z, 0-9, and '_'")
 		}
 	}
 	return nil
 }
 
 func validateSerial(s string, pos int) error {
-	if len(s) == 0 || len(s) > MaxSerialLen {
+	return validateSerialLen(s, pos, MaxSerialLen)
+}
+
+func validateSerialLen(s string, pos, max int) error {
+	if len(s) == 0 || len(s) > max {
 		return fail(ErrInvalidSerial, pos)
 	}
 	for i := 0; i < len(s); i++ {
@@ -33,52 +49,78 @@ func validateSerial(s string, pos int) error {
 }
 
 func validateGroup(s string, pos int) error {
-	if len(s) == 0 || len(s) > MaxGroupLen {
-		return fail(ErrInvalidVariable, pos)
+	return validateGroupLen(s, pos, MaxGroupLen)
+}
+
+func validateGroupLen(s string, pos, max int) error {
+	if len(s) == 0 || len(s) > max {
+		return failf(ErrInvalidVariable, pos, fmt.Sprintf("expected a group of 1 to %d characters", max))
 	}
 	for i := 0; i < len(s); i++ {
 		if !isLowercaseAlnumUnderscore(s[i]) {
-			return fail(ErrInvalidVariable, pos)
+			return failf(ErrInvalidVariable, pos, "group may only contain a-z, 0-9, and '_'")
 		}
 	}
 	return nil
 }
 
 func validateMetaKey(s string, pos int) error {
-	if len(s) == 0 || len(s) > MaxMetaKeyLen {
-		return fail(ErrInvalidMetadata, pos)
+	return validateMetaKeyLen(s, pos, MaxMetaKeyLen)
+}
+
+func validateMetaKeyLen(s string, pos, max int) error {
+	if len(s) == 0 || len(s) > max {
+		return failf(ErrInvalidMetadata, pos, fmt.Sprintf("expected a metadata key of 1 to %d characters", max))
 	}
 	for i := 0; i < len(s); i++ {
 		if !isLowercaseAlnumUnderscore(s[i]) {
-			return fail(ErrInvalidMetadata, pos)
+			return failf(ErrInvalidMetadata, pos, "metadata key may only contain a-z, 0-9, and '_'")
 		}
 	}
 	return nil
 }
 
 func validateUnit(s string, pos int) error {
-	if len(s) == 0 || len(s) > MaxUnitLen {
-		return fail(ErrInvalidVariable, pos)
+	return validateUnitLen(s, pos, MaxUnitLen)
+}
+
+// validateUnitLen checks a unit as written in the frame. Structural
+// characters must be escaped, and a backslash must start an escape
+// sequence, so that the unit builds back as it was read.
+func validateUnitLen(s string, pos, max int) error {
+	if len(s) == 0 || len(s) > max {
+		return failf(ErrInvalidVariable, pos, fmt.Sprintf("expected a unit of 1 to %d characters", max))
+	}
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			if i+1 == len(s) || unescapeTable[s[i+1]] == 0 {
+				return failf(ErrInvalidVariable, pos+i, "invalid escape sequence in unit")
+			}
+			i++
+		case escapeTable[c] != 0:
+			return failf(ErrInvalidVariable, pos+i, "structural characters in a unit must be escaped")
+		}
 	}
 	return nil
 }
 
 func validateNumber(s string, pos int) error {
 	if len(s) == 0 {
-		return fail(ErrInvalidVariable, pos)
+		return failf(ErrInvalidVariable, pos, "expected a number")
 	}
 	i := 0
 	if s[i] == '-' {
 		i++
 		if i >= len(s) {
-			return fail(ErrInvalidVariable, pos)
+			return failf(ErrInvalidVariable, pos, "expected digits after '-'")
 		}
 	}
 	if i >= len(s) || s[i] < '0' || s[i] > '9' {
-		return fail(ErrInvalidVariable, pos)
+		return failf(ErrInvalidVariable, pos, "expected a number")
 	}
 	if s[i] == '0' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9' {
-		return fail(ErrInvalidVariable, pos)
+		return failf(ErrInvalidVariable, pos, "number must not have a leading zero")
 	}
 	i++
 	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
@@ -87,14 +129,376 @@ func validateNumber(s string, pos int) error {
 	if i < len(s) && s[i] == '.' {
 		i++
 		if i >= len(s) || s[i] < '0' || s[i] > '9' {
-			return fail(ErrInvalidVariable, pos)
+			return failf(ErrInvalidVariable, pos, "expected digits after '.'")
 		}
 		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
 			i++
 		}
 	}
 	if i != len(s) {
-		return fail(ErrInvalidVariable, pos)
+		return failf(ErrInvalidVariable, pos, "expected a number")
+	}
+	return nil
+}
+
+// Validate reports whether f is a well-formed uplink frame: one that
+// BuildUplink serializes into a frame that ParseUplink accepts and parses
+// back into f.
+func (f *UplinkFrame) Validate() error {
+	raw, err := BuildUplink(f)
+	if err != nil {
+		return err
+	}
+	parsed, err := ParseUplink(raw)
+	if err != nil {
+		return fmt.Errorf("tagotip: invalid frame: %w", err)
+	}
+	if !reflect.DeepEqual(corpusFromUplink(parsed), corpusFromUplink(f)) {
+		return fmt.Errorf("tagotip: frame does not parse back from its wire form %q", raw)
+	}
+	return nil
+}
+
+// ValidateUplink parses input and returns every problem found in it, in
+// order of position, or nil when input is a valid uplink frame. Unlike
+// ParseUplink, it goes on past an error confined to one part of the
+// frame: the sequence counter, the auth, the serial, the body-level
+// modifiers, a single variable, or a single PULL name. Within a variable,
+// only the first problem is reported. Structural errors, such as a missing
+// variable block or an unknown method, end the list. Each error is a
+// *ParseError.
+func ValidateUplink(input string) []error {
+	return ValidateUplinkWithOptions(input, nil)
+}
+
+// ValidateUplinkWithOptions is ValidateUplink applying opts, as
+// ParseUplinkWithOptions does. A nil opts is equivalent to the zero value.
+func ValidateUplinkWithOptions(input string, opts *ParserOptions) []error {
+	p := newParser(opts)
+	p.collect = true
+	var frame UplinkFrame
+	if err := p.parseUplinkInto(&frame, input); err != nil {
+		p.errs = append(p.errs, err)
+	}
+	for _, err := range p.errs {
+		p.withContext(err, input)
+	}
+	return p.errs
+}
+
+// ValidateFrame returns every problem found in the fields of f, a frame
+// built in code, or nil when f.Validate accepts it. Each variable, PULL
+// name, and metadata block is checked on its own; the errors unwrap to a
+// *ParseError, whose position is zero. When no field is at fault, the
+// error of f.Validate, if any, is returned alone.
+func ValidateFrame(f *UplinkFrame) []error {
+	if f == nil {
+		return []error{fmt.Errorf("tagotip: nil frame")}
+	}
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := validateAuth(f.Auth, 0); err != nil {
+		add(invalidField(err, "authorization hash"))
+	}
+	if err := validateSerial(f.Serial, 0); err != nil {
+		add(invalidField(err, "serial %q", f.Serial))
+	}
+	switch f.Method {
+	case MethodPush:
+		pb := f.PushBody
+		if pb == nil || pb.IsPassthrough || pb.Structured == nil || len(pb.Structured.Variables) == 0 {
+			add(checkPushBody(pb))
+			break
+		}
+		sb := pb.Structured
+		if sb.Group != nil {
+			if err := validateGroup(*sb.Group, 0); err != nil {
+				add(invalidField(err, "body group %q", *sb.Group))
+			}
+		}
+		add(checkMeta(sb.Meta, "body metadata"))
+		for i := range sb.Variables {
+			add(checkVariable(&sb.Variables[i]))
+		}
+	case MethodPull:
+		if f.PullBody == nil || len(f.PullBody.Variables) == 0 {
+			add(checkPullBody(f.PullBody))
+			break
+		}
+		for _, name := range f.PullBody.Variables {
+			if err := validateVarname(name, 0); err != nil {
+				add(invalidField(err, "variable name %q", name))
+			}
+		}
+	case MethodPing:
+		add(checkMeta(f.PingDiagnostics, "PING diagnostics"))
+	}
+	if len(errs) == 0 {
+		add(f.Validate())
+	}
+	return errs
+}
+
+// fieldError reports a frame field that the builders refuse to serialize.
+// It unwraps to the *ParseError the parser would return for the field.
+type fieldError struct {
+	field string
+	err   *ParseError
+}
+
+func (e *fieldError) Error() string {
+	why := e.err.Expected
+	if why == "" {
+		why = string(e.err.Kind)
+	}
+	return fmt.Sprintf("tagotip: %s: %s", e.field, why)
+}
+
+func (e *fieldError) Unwrap() error { return e.err }
+
+// invalidField wraps err, returned by one of the validators, as a problem
+// with the field described by format and args.
+func invalidField(err error, format string, args ...any) error {
+	return &fieldError{field: fmt.Sprintf(format, args...), err: err.(*ParseError)}
+}
+
+// checkUplink reports the first field of frame that ParseUplink would
+// reject once built.
+func checkUplink(frame *UplinkFrame) error {
+	if err := validateAuth(frame.Auth, 0); err != nil {
+		return invalidField(err, "authorization hash")
+	}
+	if err := validateSerial(frame.Serial, 0); err != nil {
+		return invalidField(err, "serial %q", frame.Serial)
+	}
+	switch frame.Method {
+	case MethodPush:
+		return checkPushBody(frame.PushBody)
+	case MethodPull:
+		return checkPullBody(frame.PullBody)
+	case MethodPing:
+		return checkMeta(frame.PingDiagnostics, "PING diagnostics")
+	default:
+		return invalidField(fail(ErrInvalidMethod, 0), "method %v", frame.Method)
+	}
+}
+
+// checkHeadless is checkUplink for the fields of a headless frame.
+func checkHeadless(method Method, frame *HeadlessFrame) error {
+	if err := validateSerial(frame.Serial, 0); err != nil {
+		return invalidField(err, "serial %q", frame.Serial)
+	}
+	switch method {
+	case MethodPush:
+		return checkPushBody(frame.PushBody)
+	case MethodPull:
+		return checkPullBody(frame.PullBody)
+	case MethodPing:
+		return nil
+	default:
+		return invalidField(fail(ErrInvalidMethod, 0), "method %v", method)
+	}
+}
+
+func checkPushBody(body *PushBody) error {
+	if body == nil {
+		return invalidField(fail(ErrMissingBody, 0), "PUSH body")
+	}
+	if body.IsPassthrough && body.Passthrough != nil {
+		return checkPassthrough(body.Passthrough)
+	}
+	sb := body.Structured
+	if sb == nil || len(sb.Variables) == 0 {
+		return invalidField(failf(ErrInvalidVarBlock, 0, "expected at least one variable"), "PUSH body")
+	}
+	if len(sb.Variables) > MaxVariables {
+		return invalidField(failf(ErrTooManyItems, 0, "too many variables"), "PUSH body")
+	}
+	items := len(sb.Variables) + len(sb.Meta)
+	for i := range sb.Variables {
+		items += len(sb.Variables[i].Meta)
+	}
+	if items > DefaultMaxTotalItems {
+		return invalidField(failf(ErrTooManyItems, 0, "too many variables and metadata pairs"), "PUSH body")
+	}
+	if sb.Timestamp != nil {
+		if err := validateTimestamp(*sb.Timestamp, 0); err != nil {
+			return invalidField(err, "body timestamp %q", *sb.Timestamp)
+		}
+	}
+	if sb.Group != nil {
+		if err := validateGroup(*sb.Group, 0); err != nil {
+			return invalidField(err, "body group %q", *sb.Group)
+		}
+	}
+	if err := checkMeta(sb.Meta, "body metadata"); err != nil {
+		return err
+	}
+	for i := range sb.Variables {
+		if err := checkVariable(&sb.Variables[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkVariable(v *Variable) error {
+	if err := validateVarname(v.Name, 0); err != nil {
+		return invalidField(err, "variable name %q", v.Name)
+	}
+	if v.Value.Type != v.Operator {
+		return invalidField(failf(ErrInvalidVariable, 0, "value type does not match the operator"), "variable %q", v.Name)
+	}
+	var err error
+	switch v.Operator {
+	case OperatorNumber:
+		err = validateNumber(v.Value.Str, 0)
+	case OperatorString:
+		if v.Value.Str == "" {
+			err = failf(ErrInvalidVariable, 0, "expected a value")
+		}
+	case OperatorLocation:
+		err = checkLocation(v.Value.Location)
+	}
+	if err != nil {
+		return invalidField(err, "variable %q value", v.Name)
+	}
+	if v.Unit != nil {
+		if err := validateUnit(Escape(*v.Unit), 0); err != nil {
+			return invalidField(err, "variable %q unit", v.Name)
+		}
+	}
+	if v.Timestamp != nil {
+		if err := validateTimestamp(*v.Timestamp, 0); err != nil {
+			return invalidField(err, "variable %q timestamp %q", v.Name, *v.Timestamp)
+		}
+	}
+	if v.Group != nil {
+		if err := validateGroup(*v.Group, 0); err != nil {
+			return invalidField(err, "variable %q group %q", v.Name, *v.Group)
+		}
+	}
+	if len(v.Meta) > MaxMetaPairs {
+		return invalidField(failf(ErrTooManyItems, 0, "too many metadata pairs"), "variable %q metadata", v.Name)
+	}
+	for _, p := range v.Meta {
+		if err := validateMetaKey(p.Key, 0); err != nil {
+			return invalidField(err, "variable %q metadata key %q", v.Name, p.Key)
+		}
+	}
+	return nil
+}
+
+// checkPassthrough applies the parser's encoding checks to a passthrough
+// payload.
+func checkPassthrough(pt *PassthroughBody) error {
+	off, msg := -1, ""
+	switch {
+	case pt.Data == "":
+		off, msg = 0, "expected a payload"
+	case pt.Encoding == PassthroughEncodingHex:
+		off, msg = checkHex(pt.Data)
+	case pt.Encoding == PassthroughEncodingBase64:
+		off, msg = checkBase64(pt.Data)
+	default:
+		off, msg = 0, "unknown encoding"
+	}
+	if off >= 0 {
+		return invalidField(failf(ErrInvalidPassthru, off, msg), "passthrough data")
+	}
+	return nil
+}
+
+func checkLocation(loc *LocationValue) error {
+	if loc == nil {
+		return failf(ErrInvalidVariable, 0, "expected lat,lng[,alt]")
+	}
+	if err := validateNumber(loc.Lat, 0); err != nil {
+		return err
+	}
+	if err := validateNumber(loc.Lng, 0); err != nil {
+		return err
+	}
+	if loc.Alt != nil {
+		return validateNumber(*loc.Alt, 0)
 	}
 	return nil
 }
+
+func checkMeta(pairs []MetaPair, block string) error {
+	if len(pairs) > MaxMetaPairs {
+		return invalidField(failf(ErrTooManyItems, 0, "too many metadata pairs"), "%s", block)
+	}
+	for _, p := range pairs {
+		if err := validateMetaKey(p.Key, 0); err != nil {
+			return invalidField(err, "%s key %q", block, p.Key)
+		}
+	}
+	return nil
+}
+
+func checkPullBody(body *PullBody) error {
+	if body == nil || len(body.Variables) == 0 {
+		return invalidField(failf(ErrInvalidVarBlock, 0, "expected at least one variable"), "PULL body")
+	}
+	if len(body.Variables) > MaxVariables {
+		return invalidField(failf(ErrTooManyItems, 0, "too many variables"), "PULL body")
+	}
+	for _, name := range body.Variables {
+		if err := validateVarname(name, 0); err != nil {
+			return invalidField(err, "variable name %q", name)
+		}
+	}
+	return nil
+}
+
+// DuplicateVariableError is returned by CheckDuplicateVariables for a
+// variable that repeats an earlier one.
+type DuplicateVariableError struct {
+	Name   string
+	First  int // index of the earlier variable in the body
+	Second int // index of the repeat
+}
+
+func (e *DuplicateVariableError) Error() string {
+	return fmt.Sprintf("tagotip: variable %q at index %d repeats index %d without a distinct timestamp", e.Name, e.Second, e.First)
+}
+
+// CheckDuplicateVariables reports the first variable of sb that repeats an
+// earlier one: the same name with the same timestamp, or with neither
+// having one. Such a repeat is most often a firmware bug, while a
+// datalogger sending several points of a variable gives each its own
+// timestamp. The error is a *DuplicateVariableError. See also
+// ParserOptions.RejectDuplicateVariables.
+func CheckDuplicateVariables(sb *StructuredBody) error {
+	if sb == nil {
+		return nil
+	}
+	var seen dupIndex[varKey]
+	for i := range sb.Variables {
+		if j := seen.add(keyOf(&sb.Variables[i])); j >= 0 {
+			return &DuplicateVariableError{Name: sb.Variables[i].Name, First: j, Second: i}
+		}
+	}
+	return nil
+}
+
+// varKey is what makes a variable a repeat of another: its name and
+// timestamp, if any.
+type varKey struct {
+	name  string
+	ts    string
+	hasTS bool
+}
+
+func keyOf(v *Variable) varKey {
+	k := varKey{name: v.Name}
+	if v.Timestamp != nil {
+		k.ts, k.hasTS = *v.Timestamp, true
+	}
+	return k
+}
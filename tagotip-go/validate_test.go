@@ -0,0 +1,117 @@
+package tagotip
+
+import (
+	"errors"
+	"testing"
+)
+
+// ============================================================================
+// Reporting every problem
+// ============================================================================
+
+// problems returns the kind and position of each error in errs, which
+// must all be *ParseError.
+func problems(t *testing.T, errs []error) [][2]any {
+	t.Helper()
+	var out [][2]any
+	for _, err := range errs {
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("%T: %v", err, err)
+		}
+		out = append(out, [2]any{pe.Kind, pe.Position})
+	}
+	return out
+}
+
+func TestValidateUplink(t *testing.T) {
+	if errs := ValidateUplink("PUSH|" + testAuth + "|dev|[a:=1;b=x]"); errs != nil {
+		t.Errorf("valid frame: %v", errs)
+	}
+
+	// Bad names in variables 2 and 4, bad metadata in variable 5: each is
+	// reported, and the valid variables between them are read.
+	head := "PUSH|" + testAuth + "|dev|"
+	input := head + "[a:=1;B:=2;c:=3;d-x=4;e:=5{K=1};f:=6]"
+	got := problems(t, ValidateUplink(input))
+	want := [][2]any{
+		{ErrInvalidVariable, len(head) + 6},
+		{ErrInvalidVariable, len(head) + 16},
+		{ErrInvalidMetadata, len(head) + 27},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("error %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+	// The first error is the one ParseUplink returns.
+	_, err := ParseUplink(input)
+	if pe := err.(*ParseError); pe.Kind != want[0][0] || pe.Position != want[0][1] {
+		t.Errorf("ParseUplink: %v", err)
+	}
+}
+
+func TestValidateUplinkHeaderAndBody(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		kinds []ParseErrorKind
+	}{
+		{"PUSH|!x|bad|Bad Serial|^G[a:=1;b:=x]",
+			[]ParseErrorKind{ErrInvalidSeq, ErrInvalidAuth, ErrInvalidSerial, ErrInvalidVariable, ErrInvalidVariable}},
+		{"PUSH|" + testAuth + "|dev|[A:=1;B:=2]", []ParseErrorKind{ErrInvalidVariable, ErrInvalidVariable}},
+		{"PULL|" + testAuth + "|dev|[A;b;C]", []ParseErrorKind{ErrInvalidVariable, ErrInvalidVariable}},
+		// Structural failures end the list.
+		{"PUSH|bad|dev|a:=1", []ParseErrorKind{ErrInvalidAuth, ErrInvalidVarBlock}},
+		{"POST|bad|dev|[A:=1]", []ParseErrorKind{ErrInvalidMethod}},
+		{"PUSH|" + testAuth + "|dev|[A:=1]{", []ParseErrorKind{ErrInvalidVariable}},
+	} {
+		errs := ValidateUplink(tc.input)
+		if len(errs) != len(tc.kinds) {
+			t.Errorf("%s: got %v", tc.input, errs)
+			continue
+		}
+		for i, err := range errs {
+			if pe := err.(*ParseError); pe.Kind != tc.kinds[i] {
+				t.Errorf("%s: error %d: got %v, want %s", tc.input, i, err, tc.kinds[i])
+			}
+		}
+	}
+
+	opts := &ParserOptions{RejectDuplicateVariables: true}
+	if errs := ValidateUplinkWithOptions("PUSH|"+testAuth+"|dev|[a:=1;a:=2;b:=1;b:=2]", opts); len(errs) != 2 {
+		t.Errorf("duplicates: %v", errs)
+	}
+}
+
+func TestValidateFrame(t *testing.T) {
+	f := mustParse(t, "PUSH|"+testAuth+"|dev|[a:=1;b:=2;c:=3]")
+	if errs := ValidateFrame(f); errs != nil {
+		t.Errorf("valid frame: %v", errs)
+	}
+
+	f.Serial = ""
+	vars := f.PushBody.Structured.Variables
+	vars[0].Name = "Bad"
+	vars[2].Value.Str = "1.2.3"
+	vars[2].Meta = MetaPairs{{"K", "v"}}
+	errs := ValidateFrame(f)
+	got := problems(t, errs)
+	if len(got) != 3 || got[0][0] != ErrInvalidSerial || got[1][0] != ErrInvalidVariable || got[2][0] != ErrInvalidVariable {
+		t.Errorf("got %v", errs)
+	}
+
+	// A problem no field check sees comes from Validate.
+	big := mustParse(t, "PULL|"+testAuth+"|dev|[a]")
+	for len(big.PullBody.Variables) < MaxVariables+1 {
+		big.PullBody.Variables = append(big.PullBody.Variables, "a")
+	}
+	if errs := ValidateFrame(big); len(errs) != 1 {
+		t.Errorf("too many variables: %v", errs)
+	}
+	if errs := ValidateFrame(nil); len(errs) != 1 {
+		t.Errorf("nil frame: %v", errs)
+	}
+}
@@ -0,0 +1,168 @@
+package tagotip
+
+import (
+	"fmt"
+	"time"
+)
+
+// ---------------------------------------------------------------------------
+// Variable construction
+// ---------------------------------------------------------------------------
+//
+// A Variable literal repeats its type in Operator and Value.Type and holds
+// its optional parts behind pointers. NumberVar, StringVar, BoolVar, and
+// LocationVar fill those in from plain values:
+//
+//	v, err := NumberVar("temp", 21.5, WithUnit("C"), WithTime(time.Now()))
+//
+// Their inputs are held to the parser's rules, and an input the parser
+// would reject is returned as an error; they do not panic.
+
+// A VarOption sets an optional part of a variable built by NumberVar,
+// StringVar, BoolVar, or LocationVar.
+type VarOption func(*varOptions)
+
+type varOptions struct {
+	unit        string
+	time        time.Time
+	group       string
+	meta        MetaPairs
+	maxDecimals int
+}
+
+// WithUnit sets the variable's unit, unescaped. Locations take no unit.
+func WithUnit(unit string) VarOption {
+	return func(o *varOptions) { o.unit = unit }
+}
+
+// WithTime sets the variable's timestamp. The zero time sets none.
+func WithTime(t time.Time) VarOption {
+	return func(o *varOptions) { o.time = t }
+}
+
+// WithGroup sets the variable's group.
+func WithGroup(group string) VarOption {
+	return func(o *varOptions) { o.group = group }
+}
+
+// WithMeta adds a metadata pair to the variable, with value unescaped.
+// Given more than once, it adds the pairs in order.
+func WithMeta(key, value string) VarOption {
+	return func(o *varOptions) { o.meta = append(o.meta, MetaPair{Key: key, Value: value}) }
+}
+
+// WithMaxDecimals, when n is positive, rounds numbers and coordinates to n
+// digits after the decimal point. Without it they are written with the
+// fewest digits that read back as the same float64. Round to an integer
+// with math.Round before building.
+func WithMaxDecimals(n int) VarOption {
+	return func(o *varOptions) { o.maxDecimals = n }
+}
+
+// NumberVar returns a number variable holding value, written by
+// FormatNumber. NaN, infinities, and magnitudes FormatNumber rejects are
+// rejected.
+func NumberVar(name string, value float64, opts ...VarOption) (Variable, error) {
+	o := applyVarOptions(opts)
+	s, err := formatNumber(value, o.decimals())
+	if err != nil {
+		return Variable{}, fmt.Errorf("tagotip: variable %q: %w", name, err)
+	}
+	return newVar(name, Value{Type: OperatorNumber, Str: s}, &o)
+}
+
+// StringVar returns a string variable holding value, unescaped. The value
+// must not be empty.
+func StringVar(name, value string, opts ...VarOption) (Variable, error) {
+	if value == "" {
+		return Variable{}, fmt.Errorf("tagotip: variable %q: empty string", name)
+	}
+	o := applyVarOptions(opts)
+	return newVar(name, Value{Type: OperatorString, Str: value}, &o)
+}
+
+// BoolVar returns a boolean variable holding value.
+func BoolVar(name string, value bool, opts ...VarOption) (Variable, error) {
+	o := applyVarOptions(opts)
+	return newVar(name, Value{Type: OperatorBoolean, Bool: value}, &o)
+}
+
+// LocationVar returns a location variable at lat and lng, with an altitude
+// when alt is not nil, each written by FormatNumber. A coordinate
+// FormatNumber rejects is rejected, as is WithUnit.
+func LocationVar(name string, lat, lng float64, alt *float64, opts ...VarOption) (Variable, error) {
+	o := applyVarOptions(opts)
+	if o.unit != "" {
+		return Variable{}, fmt.Errorf("tagotip: variable %q: unit on a location", name)
+	}
+	coords := []float64{lat, lng}
+	if alt != nil {
+		coords = append(coords, *alt)
+	}
+	strs := make([]string, len(coords))
+	for i, c := range coords {
+		s, err := formatNumber(c, o.decimals())
+		if err != nil {
+			return Variable{}, fmt.Errorf("tagotip: variable %q: coordinate %w", name, err)
+		}
+		strs[i] = s
+	}
+	loc := &LocationValue{Lat: strs[0], Lng: strs[1]}
+	if alt != nil {
+		loc.Alt = &strs[2]
+	}
+	return newVar(name, Value{Type: OperatorLocation, Location: loc}, &o)
+}
+
+func applyVarOptions(opts []VarOption) varOptions {
+	var o varOptions
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	return o
+}
+
+// decimals returns the maxDecimals argument of FormatNumber for o.
+func (o *varOptions) decimals() int {
+	if o.maxDecimals > 0 {
+		return o.maxDecimals
+	}
+	return -1
+}
+
+// newVar checks name and the options and returns the variable holding v.
+func newVar(name string, v Value, o *varOptions) (Variable, error) {
+	if validateVarname(name, 0) != nil {
+		return Variable{}, fmt.Errorf("tagotip: invalid variable name %q", name)
+	}
+	out := Variable{Name: name, Operator: v.Type, Value: v}
+	if o.unit != "" {
+		if validateUnit(Escape(o.unit), 0) != nil {
+			return Variable{}, fmt.Errorf("tagotip: variable %q: invalid unit %q", name, o.unit)
+		}
+		unit := o.unit
+		out.Unit = &unit
+	}
+	if !o.time.IsZero() {
+		out.SetTimestamp(o.time)
+	}
+	if o.group != "" {
+		if validateGroup(o.group, 0) != nil {
+			return Variable{}, fmt.Errorf("tagotip: variable %q: invalid group %q", name, o.group)
+		}
+		group := o.group
+		out.Group = &group
+	}
+	if len(o.meta) > MaxMetaPairs {
+		return Variable{}, fmt.Errorf("tagotip: variable %q: %d metadata pairs, at most %d allowed", name, len(o.meta), MaxMetaPairs)
+	}
+	for _, p := range o.meta {
+		if validateMetaKey(p.Key, 0) != nil {
+			return Variable{}, fmt.Errorf("tagotip: variable %q: invalid metadata key %q", name, p.Key)
+		}
+	}
+	out.Meta = o.meta
+	return out, nil
+}
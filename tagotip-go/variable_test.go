@@ -0,0 +1,129 @@
+package tagotip
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ============================================================================
+// Variable construction
+// ============================================================================
+
+// buildVars builds a PUSH holding vars and parses it back.
+func buildVars(t *testing.T, vars ...Variable) []Variable {
+	t.Helper()
+	raw, err := BuildUplink(&UplinkFrame{Method: MethodPush, Auth: testAuth, Serial: "dev",
+		PushBody: &PushBody{Structured: &StructuredBody{Variables: vars}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mustParse(t, raw).PushBody.Structured.Variables
+}
+
+func TestVarConstructors(t *testing.T) {
+	ts := time.UnixMilli(1700000000000)
+	alt := 1609.0
+	num, err := NumberVar("temp", 21.5, WithUnit("°C"), WithTime(ts), WithGroup("g"), WithMeta("k", "a,b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	str, err := StringVar("note", "a|b;c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := BoolVar("ok", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos, err := LocationVar("pos", 39.74, -104.99, &alt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := buildVars(t, num, str, ok, pos)
+	if v := got[0]; v.Operator != OperatorNumber || v.Value.Str != "21.5" || *v.Unit != "°C" ||
+		*v.Timestamp != "1700000000000" || *v.Group != "g" || v.Meta[0] != (MetaPair{"k", "a,b"}) {
+		t.Errorf("number: %+v", v)
+	}
+	if v := got[1]; v.Operator != OperatorString || v.Value.Type != OperatorString || v.Value.Str != "a|b;c" {
+		t.Errorf("string: %+v", v)
+	}
+	if v := got[2]; v.Operator != OperatorBoolean || !v.Value.Bool {
+		t.Errorf("bool: %+v", v)
+	}
+	if l := got[3].Value.Location; l.Lat != "39.74" || l.Lng != "-104.99" || *l.Alt != "1609" {
+		t.Errorf("location: %+v", l)
+	}
+}
+
+func TestNumberVarFormatting(t *testing.T) {
+	for _, tc := range []struct {
+		v        float64
+		decimals int
+		want     string
+	}{
+		{1e6, 0, "1000000"},
+		{1e-7, 0, "0.0000001"},
+		{2.0 / 3, 0, "0.6666666666666666"},
+		{2.0 / 3, 2, "0.67"},
+		{0.30000000000000004, 2, "0.3"},
+		{2.5, 3, "2.5"},
+		{1.996, 2, "2"},
+		{-0.001, 2, "0"},
+		{math.Copysign(0, -1), 0, "0"},
+	} {
+		v, err := NumberVar("n", tc.v, WithMaxDecimals(tc.decimals))
+		if err != nil || v.Value.Str != tc.want {
+			t.Errorf("NumberVar(%v, %d) = %q, %v; want %q", tc.v, tc.decimals, v.Value.Str, err, tc.want)
+		}
+	}
+}
+
+func TestVarConstructorErrors(t *testing.T) {
+	nan := math.NaN()
+	for name, build := range map[string]func() (Variable, error){
+		"bad name":     func() (Variable, error) { return NumberVar("Temp", 1) },
+		"NaN":          func() (Variable, error) { return NumberVar("n", nan) },
+		"infinity":     func() (Variable, error) { return NumberVar("n", math.Inf(1)) },
+		"too large":    func() (Variable, error) { return NumberVar("n", 1e30) },
+		"empty string": func() (Variable, error) { return StringVar("s", "") },
+		"bad group":    func() (Variable, error) { return BoolVar("b", true, WithGroup("a b")) },
+		"bad meta key": func() (Variable, error) { return BoolVar("b", true, WithMeta("K", "v")) },
+		"bad unit": func() (Variable, error) {
+			return NumberVar("n", 1, WithUnit(strings.Repeat("u", MaxUnitLen+1)))
+		},
+		"location unit": func() (Variable, error) { return LocationVar("p", 1, 2, nil, WithUnit("m")) },
+		"NaN altitude":  func() (Variable, error) { return LocationVar("p", 1, 2, &nan) },
+		"too much meta": func() (Variable, error) {
+			opts := make([]VarOption, MaxMetaPairs+1)
+			for i := range opts {
+				opts[i] = WithMeta(fmt.Sprintf("k%d", i), "v")
+			}
+			return BoolVar("b", true, opts...)
+		},
+	} {
+		if v, err := build(); err == nil || !strings.HasPrefix(err.Error(), "tagotip: ") {
+			t.Errorf("%s: got %+v, %v", name, v, err)
+		}
+	}
+}
+
+func TestVarOptionsShared(t *testing.T) {
+	opts := []VarOption{WithMeta("a", "1"), WithMeta("b", "2")}
+	v1, err := BoolVar("b", true, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := BoolVar("b", false, append(opts, WithMeta("c", "3"))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1.Meta[0].Value = "changed"
+	if want := (MetaPairs{{"a", "1"}, {"b", "2"}, {"c", "3"}}); !slices.Equal(v2.Meta, want) {
+		t.Errorf("meta = %v, want %v", v2.Meta, want)
+	}
+}
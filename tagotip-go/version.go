@@ -0,0 +1,133 @@
+package tagotip
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Protocol version negotiation
+// ---------------------------------------------------------------------------
+//
+// A client announces the protocol version it speaks in one of two ways:
+//
+//	PING|TOKEN|sensor_01--v1                  serial suffixed with "--v<N>"
+//	PUSH|TOKEN|sensor_01|{v=1}[temp:=21]      body-level meta pair v=<N>
+//
+// A server that cannot serve the announced version answers
+// ACK|ERR|unsupported_version. A server that predates negotiation ignores
+// the marker and answers as it would any other frame, so clients must treat
+// every other reply as "not rejected".
+
+// ProtocolVersion is the TagoTiP protocol version implemented by this
+// package.
+const ProtocolVersion = 1
+
+// VersionSerialSuffix separates a serial from the version it announces in a
+// version probe.
+const VersionSerialSuffix = "--v"
+
+// VersionMetaKey is the body-level metadata key announcing a version in a
+// PUSH frame.
+const VersionMetaKey = "v"
+
+// ErrUnsupportedVersion is returned by CheckVersionAck when the server
+// rejected the announced protocol version.
+var ErrUnsupportedVersion = errors.New("tagotip: server does not support the announced protocol version")
+
+// Version returns the TagoTiP protocol version implemented by this package.
+func Version() int {
+	return ProtocolVersion
+}
+
+// BuildVersionProbe builds the PING frame a client sends on connect to
+// announce ProtocolVersion. seq may be nil.
+func BuildVersionProbe(seq *uint32, auth, serial string) (string, error) {
+	probe := serial + VersionSerialSuffix + strconv.Itoa(ProtocolVersion)
+	if err := validateSerial(probe, 0); err != nil {
+		return "", err
+	}
+	return BuildUplink(&UplinkFrame{Method: MethodPing, Seq: seq, Auth: auth, Serial: probe})
+}
+
+// CheckVersionAck inspects the server's reply to a version probe. It
+// returns ErrUnsupportedVersion when the server rejected the version and
+// nil for any other reply, including the replies of servers that ignore
+// the marker.
+func CheckVersionAck(ack *AckFrame) error {
+	if ack != nil && ack.Status == AckStatusErr && ack.Detail != nil &&
+		ack.Detail.Type == "error" && ack.Detail.ErrorCode == ErrorCodeUnsupportedVersion {
+		return ErrUnsupportedVersion
+	}
+	return nil
+}
+
+// FrameVersion returns the protocol version announced by frame, either by
+// the serial suffix of a PING or by the body-level meta pair of a PUSH, and
+// the serial with any suffix removed. ok is false when the frame announces
+// no version.
+func FrameVersion(frame *UplinkFrame) (version int, serial string, ok bool) {
+	serial = frame.Serial
+	switch frame.Method {
+	case MethodPing:
+		if i := strings.LastIndex(serial, VersionSerialSuffix); i > 0 {
+			if v, valid := parseVersion(serial[i+len(VersionSerialSuffix):]); valid {
+				return v, serial[:i], true
+			}
+		}
+	case MethodPush:
+		if pb := frame.PushBody; pb != nil && pb.Structured != nil {
+			for _, m := range pb.Structured.Metadata() {
+				if m.Key == VersionMetaKey {
+					if v, valid := parseVersion(m.Value); valid {
+						return v, serial, true
+					}
+				}
+			}
+		}
+	}
+	return 0, serial, false
+}
+
+// parseVersion parses a positive decimal version without leading zeros.
+func parseVersion(s string) (int, bool) {
+	if len(s) == 0 || len(s) > 9 || s[0] == '0' {
+		return 0, false
+	}
+	v := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+		v = v*10 + int(s[i]-'0')
+	}
+	return v, true
+}
+
+// UplinkHandler handles a parsed uplink frame on a server and returns the
+// ACK to send, or nil to send none.
+type UplinkHandler func(frame *UplinkFrame) *AckFrame
+
+// RequireVersion wraps next so that frames announcing a version outside
+// [minVersion, maxVersion] are answered with ERR|unsupported_version
+// without reaching next. Frames in range reach next with the PING serial
+// suffix removed; frames that announce no version are passed through
+// unchanged, so clients that predate negotiation keep working.
+func RequireVersion(minVersion, maxVersion int, next UplinkHandler) UplinkHandler {
+	return func(frame *UplinkFrame) *AckFrame {
+		version, serial, ok := FrameVersion(frame)
+		if !ok {
+			return next(frame)
+		}
+		if version < minVersion || version > maxVersion {
+			return &AckFrame{
+				Seq:    frame.Seq,
+				Status: AckStatusErr,
+				Detail: &AckDetail{Type: "error", Text: "unsupported_version", ErrorCode: ErrorCodeUnsupportedVersion},
+			}
+		}
+		frame.Serial = serial
+		return next(frame)
+	}
+}
@@ -0,0 +1,187 @@
+package tagotip
+
+import (
+	"errors"
+	"testing"
+)
+
+// ============================================================================
+// Version negotiation
+// ============================================================================
+
+func TestVersion(t *testing.T) {
+	if Version() != ProtocolVersion {
+		t.Errorf("Version() = %d, want %d", Version(), ProtocolVersion)
+	}
+}
+
+func TestBuildVersionProbe(t *testing.T) {
+	seq := uint32(4)
+	got, err := BuildVersionProbe(&seq, testAuth, "sensor_01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "PING|!4|" + testAuth + "|sensor_01--v1"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, err := ParseUplink(got); err != nil {
+		t.Errorf("probe does not parse: %v", err)
+	}
+
+	long := make([]byte, MaxSerialLen)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if _, err := BuildVersionProbe(nil, testAuth, string(long)); err == nil {
+		t.Error("expected error for serial too long to carry the suffix")
+	}
+}
+
+func TestFrameVersion(t *testing.T) {
+	tests := []struct {
+		raw     string
+		version int
+		serial  string
+		ok      bool
+	}{
+		{"PING|" + testAuth + "|sensor_01--v1", 1, "sensor_01", true},
+		{"PING|" + testAuth + "|sensor--v1--v12", 12, "sensor--v1", true},
+		{"PING|" + testAuth + "|sensor_01", 0, "sensor_01", false},
+		{"PING|" + testAuth + "|sensor_01--v", 0, "sensor_01--v", false},
+		{"PING|" + testAuth + "|sensor_01--v01", 0, "sensor_01--v01", false},
+		{"PING|" + testAuth + "|sensor_01--vx", 0, "sensor_01--vx", false},
+		{"PING|" + testAuth + "|--v1", 0, "--v1", false},
+		{"PUSH|" + testAuth + "|sensor_01|{v=2}[temp:=1]", 2, "sensor_01", true},
+		{"PUSH|" + testAuth + "|sensor_01|{fw=3,v=1}[temp:=1]", 1, "sensor_01", true},
+		{"PUSH|" + testAuth + "|sensor_01|[temp:=1{v=1}]", 0, "sensor_01", false},
+		{"PUSH|" + testAuth + "|sensor_01--v1|[temp:=1]", 0, "sensor_01--v1", false},
+		{"PULL|" + testAuth + "|sensor_01--v1|[temp]", 0, "sensor_01--v1", false},
+	}
+	for _, tt := range tests {
+		frame, err := ParseUplink(tt.raw)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.raw, err)
+		}
+		version, serial, ok := FrameVersion(frame)
+		if version != tt.version || serial != tt.serial || ok != tt.ok {
+			t.Errorf("%s: got (%d, %q, %v), want (%d, %q, %v)",
+				tt.raw, version, serial, ok, tt.version, tt.serial, tt.ok)
+		}
+	}
+}
+
+func TestFrameVersionLazyMeta(t *testing.T) {
+	frame, err := ParseUplinkWithOptions("PUSH|"+testAuth+"|sensor_01|{v=1}[temp:=1]", &ParserOptions{LazyMeta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _, ok := FrameVersion(frame); !ok || v != 1 {
+		t.Errorf("got (%d, %v), want (1, true)", v, ok)
+	}
+}
+
+// versionServer answers frames the way a server using RequireVersion does.
+func versionServer(minVersion, maxVersion int, seen *[]string) UplinkHandler {
+	return RequireVersion(minVersion, maxVersion, func(frame *UplinkFrame) *AckFrame {
+		*seen = append(*seen, frame.Serial)
+		return &AckFrame{Seq: frame.Seq, Status: AckStatusPong}
+	})
+}
+
+// negotiate sends a version probe to handler over the wire format and
+// checks the reply as a client would.
+func negotiate(t *testing.T, handler UplinkHandler) error {
+	t.Helper()
+	seq := uint32(9)
+	probe, err := BuildVersionProbe(&seq, testAuth, "sensor_01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := ParseUplink(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply, err := BuildAck(handler(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, err := ParseAck(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.Seq == nil || *ack.Seq != seq {
+		t.Errorf("reply %q does not echo the sequence counter", reply)
+	}
+	return CheckVersionAck(ack)
+}
+
+func TestVersionNegotiationAccept(t *testing.T) {
+	var seen []string
+	if err := negotiate(t, versionServer(1, 3, &seen)); err != nil {
+		t.Fatalf("CheckVersionAck = %v, want nil", err)
+	}
+	if len(seen) != 1 || seen[0] != "sensor_01" {
+		t.Errorf("handler saw serials %q, want [sensor_01]", seen)
+	}
+}
+
+func TestVersionNegotiationReject(t *testing.T) {
+	var seen []string
+	if err := negotiate(t, versionServer(2, 3, &seen)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("CheckVersionAck = %v, want ErrUnsupportedVersion", err)
+	}
+	if len(seen) != 0 {
+		t.Errorf("rejected frame reached the handler: %q", seen)
+	}
+
+	frame, err := ParseUplink("PUSH|" + testAuth + "|sensor_01|{v=4}[temp:=1]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack := versionServer(1, 3, &seen)(frame)
+	if err := CheckVersionAck(ack); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("PUSH v=4: CheckVersionAck = %v, want ErrUnsupportedVersion", err)
+	}
+}
+
+func TestVersionNegotiationLegacyServer(t *testing.T) {
+	// A server that predates negotiation does not strip the suffix. Whatever
+	// it answers, the client must not conclude that the version is rejected.
+	for _, reply := range []*AckFrame{
+		{Status: AckStatusPong},
+		{Status: AckStatusErr, Detail: &AckDetail{Type: "error", Text: "device_not_found", ErrorCode: ErrorCodeDeviceNotFound}},
+	} {
+		legacy := func(frame *UplinkFrame) *AckFrame {
+			ack := *reply
+			ack.Seq = frame.Seq
+			return &ack
+		}
+		if err := negotiate(t, legacy); err != nil {
+			t.Errorf("status %v: CheckVersionAck = %v, want nil", reply.Status, err)
+		}
+	}
+	if err := CheckVersionAck(nil); err != nil {
+		t.Errorf("CheckVersionAck(nil) = %v, want nil", err)
+	}
+}
+
+func TestRequireVersionPassesUnmarkedFrames(t *testing.T) {
+	var seen []string
+	handler := versionServer(2, 3, &seen)
+	for _, raw := range []string{
+		"PING|" + testAuth + "|sensor_01",
+		"PUSH|" + testAuth + "|sensor_01|[temp:=1]",
+	} {
+		frame, err := ParseUplink(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckVersionAck(handler(frame)); err != nil {
+			t.Errorf("%s: %v", raw, err)
+		}
+	}
+	if len(seen) != 2 {
+		t.Errorf("handler saw %d frames, want 2", len(seen))
+	}
+}
@@ -0,0 +1,97 @@
+package tagotip
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ---------------------------------------------------------------------------
+// Warnings
+// ---------------------------------------------------------------------------
+//
+// Warnings flag constructs that the parser accepts but that are discouraged
+// or slated for removal from the spec, so that a server can find the
+// devices still sending them. They are collected inline during the parse
+// under ParserOptions.CollectWarnings, and by Lint.
+
+// WarningCode identifies a kind of warning. Codes are stable and meant to
+// be aggregated, for example per device on a dashboard.
+type WarningCode string
+
+const (
+	// WarnUppercaseHex flags an auth token whose hex digits are not all
+	// lowercase.
+	WarnUppercaseHex WarningCode = "uppercase_hex"
+	// WarnDuplicateMetaKey flags a metadata block that repeats a key.
+	WarnDuplicateMetaKey WarningCode = "duplicate_meta_key"
+	// WarnLegacyRateLimited flags a rate_limited ERR ACK without the
+	// retry-after field, the two-field form of older servers.
+	WarnLegacyRateLimited WarningCode = "legacy_rate_limited"
+)
+
+// Warning is a discouraged construct found at Position, the byte offset in
+// the frame.
+type Warning struct {
+	Code     WarningCode
+	Position int
+	Message  string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s at position %d: %s", w.Code, w.Position, w.Message)
+}
+
+// warn records a warning if warnings are being collected.
+func (p *parser) warn(code WarningCode, pos int, msg string) {
+	if p.opts.CollectWarnings {
+		p.warnings = append(p.warnings, Warning{Code: code, Position: pos, Message: msg})
+	}
+}
+
+// checkAuthCase warns about upper-case hex digits in a valid auth token.
+func (p *parser) checkAuthCase(auth string, pos int) {
+	for i := 2; i < len(auth); i++ {
+		if auth[i] >= 'A' && auth[i] <= 'F' {
+			p.warn(WarnUppercaseHex, pos+i, "auth token hex digits should be lowercase")
+			return
+		}
+	}
+}
+
+// warnDuplicateKey warns about key repeating within its metadata block.
+func (p *parser) warnDuplicateKey(key string, pos int) {
+	p.warn(WarnDuplicateMetaKey, pos, fmt.Sprintf("metadata key %q repeated", key))
+}
+
+// Lint parses a raw frame and returns the warnings for it. Frames whose
+// first field is ACK are parsed with ParseAck, all others with
+// ParseUplinkWithOptions and CollectWarnings.
+func Lint(input string) ([]Warning, error) {
+	if strings.HasPrefix(input, "ACK|") {
+		ack, err := ParseAck(input)
+		if err != nil {
+			return nil, err
+		}
+		return lintAck(ack, input), nil
+	}
+	frame, err := ParseUplinkWithOptions(input, &ParserOptions{CollectWarnings: true})
+	if err != nil {
+		return nil, err
+	}
+	return frame.Warnings, nil
+}
+
+func lintAck(ack *AckFrame, input string) []Warning {
+	d := ack.Detail
+	if ack.Status != AckStatusErr || d == nil || d.ErrorCode != ErrorCodeRateLimited {
+		return nil
+	}
+	if d.Err != nil && d.Err.RetryAfter != nil {
+		return nil
+	}
+	return []Warning{{
+		Code:     WarnLegacyRateLimited,
+		Position: strings.Index(input, "|ERR|") + len("|ERR|"),
+		Message:  "rate_limited should carry a retry-after field",
+	}}
+}
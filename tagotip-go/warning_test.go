@@ -0,0 +1,115 @@
+package tagotip
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// ============================================================================
+// Warnings
+// ============================================================================
+
+var warnOpts = &ParserOptions{CollectWarnings: true}
+
+func TestWarningUppercaseHex(t *testing.T) {
+	auth := "at" + strings.ToUpper(testAuth[2:])
+	raw := "PUSH|!1|" + auth + "|dev|[a:=1]"
+	frame, err := ParseUplinkWithOptions(raw, warnOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pos := strings.IndexFunc(raw, func(r rune) bool { return r >= 'A' && r <= 'F' })
+	want := []Warning{{Code: WarnUppercaseHex, Position: pos, Message: "auth token hex digits should be lowercase"}}
+	if !reflect.DeepEqual(frame.Warnings, want) {
+		t.Errorf("got %v, want %v", frame.Warnings, want)
+	}
+
+	frame = mustParse(t, raw)
+	if frame.Warnings != nil {
+		t.Errorf("warnings collected without the option: %v", frame.Warnings)
+	}
+}
+
+func TestWarningDuplicateMetaKey(t *testing.T) {
+	raw := "PUSH|" + testAuth + "|dev|{a=1,b=2,a=3}[x:=1{k=1,k=1};y:=2{k=1}]"
+	for _, lazy := range []bool{false, true} {
+		frame, err := ParseUplinkWithOptions(raw, &ParserOptions{CollectWarnings: true, LazyMeta: lazy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int
+		for _, w := range frame.Warnings {
+			if w.Code != WarnDuplicateMetaKey {
+				t.Errorf("unexpected warning %v", w)
+			}
+			got = append(got, w.Position)
+		}
+		want := []int{strings.Index(raw, "a=3"), strings.Index(raw, "k=1}")}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("lazy=%v: positions %v, want %v", lazy, got, want)
+		}
+	}
+
+	pings, err := ParseUplinkWithOptions("PING|"+testAuth+"|dev|{rssi=1,rssi=2}",
+		&ParserOptions{CollectWarnings: true, AllowPingDiagnostics: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pings.Warnings) != 1 || pings.Warnings[0].Code != WarnDuplicateMetaKey {
+		t.Errorf("PING diagnostics: %v", pings.Warnings)
+	}
+}
+
+func TestWarningLegacyRateLimited(t *testing.T) {
+	raw := "ACK|!7|ERR|rate_limited"
+	got, err := Lint(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Code != WarnLegacyRateLimited || got[0].Position != strings.Index(raw, "rate_limited") {
+		t.Errorf("got %v", got)
+	}
+
+	for _, clean := range []string{"ACK|!7|ERR|rate_limited|30", "ACK|ERR|invalid_token", "ACK|OK|3"} {
+		if got, err := Lint(clean); err != nil || got != nil {
+			t.Errorf("%s: %v, %v", clean, got, err)
+		}
+	}
+}
+
+func TestLintUplink(t *testing.T) {
+	if got, err := Lint("PUSH|" + testAuth + "|dev|[a:=1{k=1,k=2}]"); err != nil || len(got) != 1 {
+		t.Errorf("got %v, %v", got, err)
+	}
+	if got, err := Lint("PUSH|" + testAuth + "|dev|[a:=1]"); err != nil || got != nil {
+		t.Errorf("clean frame: %v, %v", got, err)
+	}
+	if _, err := Lint("PUSH|" + testAuth + "|dev|[a:=]"); err == nil {
+		t.Error("expected a parse error")
+	}
+}
+
+func TestWarningsDisabledAllocations(t *testing.T) {
+	flagged := "PUSH|at" + strings.ToUpper(testAuth[2:]) + "|dev|{a=1,a=2}[x:=1{k=1,k=2};y:=2]"
+	clean := "PUSH|" + testAuth + "|dev|{a=1,b=2}[x:=1{k=1,l=2};y:=2]"
+	frame := &UplinkFrame{}
+	for _, lazy := range []bool{false, true} {
+		opts := &ParserOptions{LazyMeta: lazy}
+		allocs := func(raw string) float64 {
+			return testing.AllocsPerRun(100, func() {
+				if err := parseIntoWithOptions(frame, raw, opts); err != nil {
+					t.Fatal(err)
+				}
+			})
+		}
+		if a, b := allocs(flagged), allocs(clean); a != b || a != 0 {
+			t.Errorf("lazy=%v: %v allocations for a flagged frame, %v for a clean one", lazy, a, b)
+		}
+	}
+}
+
+func parseIntoWithOptions(frame *UplinkFrame, raw string, opts *ParserOptions) error {
+	p := newParser(opts)
+	return p.parseUplinkInto(frame, raw)
+}
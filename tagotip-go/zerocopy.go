@@ -0,0 +1,93 @@
+package tagotip
+
+import "strings"
+
+// ---------------------------------------------------------------------------
+// Zero-copy parsing
+// ---------------------------------------------------------------------------
+//
+// The parser slices the input instead of copying out of it, so the string
+// fields of a parsed frame share memory with the input. For an ordinary Go
+// string, which is immutable, this is free and safe. It matters when the
+// input was made without a copy from a buffer that is later reused, as
+// with unsafe.String over a network read buffer: the frame's strings then
+// change along with the buffer.
+//
+// Under ParserOptions.ZeroCopy this aliasing is guaranteed for every string
+// field, including names, units, and groups, which interning would
+// otherwise copy. Such a frame may only be used while the input's bytes
+// stay unchanged; Detach returns a copy that owns all of its memory, to
+// keep beyond that point.
+
+// Detach returns a deep copy of f that shares no memory with f or with the
+// input it was parsed from. Lazily held metadata is parsed.
+func (f *UplinkFrame) Detach() *UplinkFrame {
+	c := cloneUplink(f)
+	c.eachString(detach)
+	return c
+}
+
+// eachString calls fn with a pointer to every string field of f, including
+// lazily held metadata.
+func (f *UplinkFrame) eachString(fn func(*string)) {
+	fn(&f.Auth)
+	fn(&f.Serial)
+	fn(&f.Signature)
+	fn(&f.RawMethod)
+	fn(&f.RawBody)
+	eachMetaString(f.PingDiagnostics, fn)
+	for i := range f.Warnings {
+		fn(&f.Warnings[i].Message)
+	}
+	if pb := f.PushBody; pb != nil {
+		if pt := pb.Passthrough; pt != nil {
+			fn(&pt.Data)
+		}
+		if sb := pb.Structured; sb != nil {
+			eachPtrString(sb.Group, fn)
+			eachPtrString(sb.Timestamp, fn)
+			eachMetaString(sb.Meta, fn)
+			fn(&sb.rawMeta)
+			for i := range sb.Variables {
+				sb.Variables[i].eachString(fn)
+			}
+		}
+	}
+	if f.PullBody != nil {
+		for i := range f.PullBody.Variables {
+			fn(&f.PullBody.Variables[i])
+		}
+	}
+}
+
+func (v *Variable) eachString(fn func(*string)) {
+	fn(&v.Name)
+	fn(&v.Value.Str)
+	if loc := v.Value.Location; loc != nil {
+		fn(&loc.Lat)
+		fn(&loc.Lng)
+		eachPtrString(loc.Alt, fn)
+	}
+	eachPtrString(v.Unit, fn)
+	eachPtrString(v.Timestamp, fn)
+	eachPtrString(v.Group, fn)
+	eachMetaString(v.Meta, fn)
+	fn(&v.rawMeta)
+}
+
+func eachMetaString(pairs []MetaPair, fn func(*string)) {
+	for i := range pairs {
+		fn(&pairs[i].Key)
+		fn(&pairs[i].Value)
+	}
+}
+
+func eachPtrString(s *string, fn func(*string)) {
+	if s != nil {
+		fn(s)
+	}
+}
+
+func detach(s *string) {
+	*s = strings.Clone(*s)
+}
@@ -0,0 +1,148 @@
+package tagotip
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+// ============================================================================
+// Zero-copy parsing
+// ============================================================================
+
+// frameStrings returns every non-empty exported string reachable from v.
+func frameStrings(v reflect.Value, out []string) []string {
+	switch v.Kind() {
+	case reflect.String:
+		if v.Len() > 0 {
+			out = append(out, v.String())
+		}
+	case reflect.Pointer:
+		if !v.IsNil() {
+			out = frameStrings(v.Elem(), out)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			out = frameStrings(v.Index(i), out)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				out = frameStrings(v.Field(i), out)
+			}
+		}
+	}
+	return out
+}
+
+// aliases reports whether s shares memory with input.
+func aliases(s, input string) bool {
+	start := uintptr(unsafe.Pointer(unsafe.StringData(input)))
+	p := uintptr(unsafe.Pointer(unsafe.StringData(s)))
+	return p >= start && p+uintptr(len(s)) <= start+uintptr(len(input))
+}
+
+var zeroCopyFrames = []struct {
+	raw  string
+	opts ParserOptions
+}{
+	{raw: "PUSH|!7|" + testAuth + "|dev|@1700000000000^site{fw=2\\,1}[pos@=39.74,-104.99,305{k=v};t:=21.5#C@1^g{q=ok};s=a\\;b]"},
+	{raw: "PUSH|" + testAuth + "|dev|>xDEADBEEF"},
+	{raw: "PULL|" + testAuth + "|dev|[temp;hum]"},
+	{raw: "PING|" + testAuth + "|dev|{rssi=-87}", opts: ParserOptions{AllowPingDiagnostics: true}},
+	{raw: "SUBS|!3|" + testAuth + "|dev|[x]", opts: ParserOptions{AllowUnknownMethods: true}},
+	{raw: "PUSH|" + testAuth + "|dev|{a=1}[t:=1{b=2}]", opts: ParserOptions{LazyMeta: true}},
+}
+
+func TestZeroCopyAliasesInput(t *testing.T) {
+	for _, tc := range zeroCopyFrames {
+		// Build the input at run time so that it is not a string constant.
+		input := string([]byte(tc.raw))
+		opts := tc.opts
+		opts.ZeroCopy = true
+		opts.Intern = NewInternTable(100)
+		frame, err := ParseUplinkWithOptions(input, &opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.raw, err)
+		}
+		strs := frameStrings(reflect.ValueOf(frame), nil)
+		if len(strs) < 3 {
+			t.Fatalf("%s: only %d strings found", tc.raw, len(strs))
+		}
+		for _, s := range strs {
+			if !aliases(s, input) {
+				t.Errorf("%s: %q does not alias the input", tc.raw, s)
+			}
+		}
+		if opts.Intern.Len() != 0 {
+			t.Errorf("%s: interned %d strings under ZeroCopy", tc.raw, opts.Intern.Len())
+		}
+	}
+}
+
+func TestZeroCopyKeepsEscapes(t *testing.T) {
+	input := "PUSH|" + testAuth + "|dev|{m=x\\,y}[s=a\\;b#u\\|v{k=c\\}d}]"
+	for _, zc := range []bool{false, true} {
+		f, err := ParseUplinkWithOptions(input, &ParserOptions{ZeroCopy: zc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sb := f.PushBody.Structured
+		v := sb.Variables[0]
+		got := []string{sb.Meta[0].Value, v.Value.Str, *v.Unit, v.Meta[0].Value}
+		want := []string{"x,y", "a;b", "u|v", "c}d"}
+		if zc {
+			want = []string{`x\,y`, `a\;b`, `u\|v`, `c\}d`}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ZeroCopy=%v: got %q, want %q", zc, got, want)
+		}
+	}
+
+	// ACKs are unescaped whatever the options.
+	ack, err := ParseAckWithOptions("ACK|OK|[s=a\\;b]", &ParserOptions{ZeroCopy: true})
+	if err != nil || ack.Detail.Vars.Variables[0].Value.Str != "a;b" {
+		t.Errorf("ACK: %+v, %v", ack, err)
+	}
+}
+
+func TestDetachBreaksAliasing(t *testing.T) {
+	for _, tc := range zeroCopyFrames {
+		input := string([]byte(tc.raw))
+		opts := tc.opts
+		opts.ZeroCopy = true
+		frame, err := ParseUplinkWithOptions(input, &opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.raw, err)
+		}
+		detached := frame.Detach()
+		for _, s := range frameStrings(reflect.ValueOf(detached), nil) {
+			if aliases(s, input) {
+				t.Errorf("%s: detached %q still aliases the input", tc.raw, s)
+			}
+		}
+		if !detached.Equal(frame) {
+			t.Errorf("%s: detached frame differs", tc.raw)
+		}
+	}
+}
+
+func TestDetachSurvivesBufferReuse(t *testing.T) {
+	buf := []byte("PUSH|" + testAuth + "|dev|[temp:=21.5#C]")
+	input := unsafe.String(&buf[0], len(buf))
+	frame, err := ParseUplinkWithOptions(input, &ParserOptions{ZeroCopy: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	detached := frame.Detach()
+	for i := range buf {
+		buf[i] = 'x'
+	}
+	if name := frame.PushBody.Structured.Variables[0].Name; name != "xxxx" {
+		t.Errorf("aliased name is %q after the buffer changed", name)
+	}
+	v := detached.PushBody.Structured.Variables[0]
+	if v.Name != "temp" || v.Value.Str != "21.5" || *v.Unit != "C" || detached.Auth != testAuth {
+		t.Errorf("detached frame changed with the buffer: %+v", v)
+	}
+}